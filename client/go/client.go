@@ -0,0 +1,358 @@
+// Package tasklists is a client for the tasklists sync protocol
+// (docs/protocol-spec.md), for Go programs such as command line tools, bots
+// and migration scripts that would otherwise hand-roll it.
+//
+// Client has one typed method per sync endpoint. It authenticates with an API
+// token, announces the protocol version, retries requests the server could
+// not serve (429 and 503, honoring Retry-After) with jittered backoff, and
+// turns generation conflicts into a ConflictError that says how to recover.
+// Sync builds on it: it keeps a program's replica and cursor in step with the
+// server and recovers from conflicts the way the web app does.
+//
+// Ops are passed through as they are: the package does not interpret CRDT
+// payloads.
+package tasklists
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProtocolVersion is the sync protocol version the client speaks.
+const ProtocolVersion = 1
+
+const protocolVersionHeader = "X-Sync-Protocol-Version"
+
+// Config configures a Client.
+type Config struct {
+	// BaseURL is where the server is reached, such as
+	// https://lists.example.com.
+	BaseURL string
+	// ClientID identifies this program's replica to the server, which keeps a
+	// cursor per client. It must stay the same across runs.
+	ClientID string
+	// Token is the secret (lat_…) of an API token with the admin scope, which
+	// sync needs. Create one under /auth/tokens or with RedeemPairing. Tokens
+	// that only accept signed requests are not supported.
+	Token string
+	// OmitOwn leaves the ops this client pushed out of pulls and streams.
+	OmitOwn bool
+	// HTTPClient sends the requests; nil means a client without a timeout,
+	// since streams stay open. Bound requests with their context instead.
+	HTTPClient *http.Client
+	// Retry configures retries; zero fields take the defaults.
+	Retry RetryPolicy
+}
+
+// Client talks to one server as one client. It is safe for concurrent use.
+type Client struct {
+	baseURL  *url.URL
+	clientID string
+	token    string
+	omitOwn  bool
+	http     *http.Client
+	retry    RetryPolicy
+}
+
+// New returns a client for cfg.
+func New(cfg Config) (*Client, error) {
+	baseURL, err := parseBaseURL(cfg.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ClientID == "" {
+		return nil, errors.New("client id is required")
+	}
+	if cfg.Token == "" {
+		return nil, errors.New("api token is required")
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	return &Client{
+		baseURL:  baseURL,
+		clientID: cfg.ClientID,
+		token:    cfg.Token,
+		omitOwn:  cfg.OmitOwn,
+		http:     httpClient,
+		retry:    cfg.Retry.withDefaults(),
+	}, nil
+}
+
+func parseBaseURL(raw string) (*url.URL, error) {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("base url %q must be an http(s) url", raw)
+	}
+	parsed.Path = strings.TrimSuffix(parsed.Path, "/")
+	return parsed, nil
+}
+
+// ClientID returns the client id the client syncs as.
+func (c *Client) ClientID() string {
+	return c.clientID
+}
+
+// Bootstrap fetches the active generation's snapshot and op log.
+func (c *Client) Bootstrap(ctx context.Context) (*Bootstrap, error) {
+	var bootstrap Bootstrap
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/sync/bootstrap", idempotent: true}, &bootstrap); err != nil {
+		return nil, err
+	}
+	return &bootstrap, nil
+}
+
+// Push sends ops made against the generation datasetGenerationKey. A push
+// is safe to repeat, since the server ignores ops it already stored, so it is
+// retried like a GET. A stale generation fails with a *ConflictError.
+func (c *Client) Push(ctx context.Context, datasetGenerationKey string, ops []Op) (*PushResult, error) {
+	if ops == nil {
+		ops = []Op{}
+	}
+	body := struct {
+		ClientID             string `json:"clientId"`
+		DatasetGenerationKey string `json:"datasetGenerationKey"`
+		Ops                  []Op   `json:"ops"`
+	}{c.clientID, datasetGenerationKey, ops}
+	var result PushResult
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/sync/push", body: body, idempotent: true}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Pull fetches the ops after cursor and moves the client's cursor on the
+// server. A stale generation fails with a *ConflictError.
+func (c *Client) Pull(ctx context.Context, cursor Cursor) (*PullResult, error) {
+	var result PullResult
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/sync/pull", query: c.cursorQuery(cursor), idempotent: true}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *Client) cursorQuery(cursor Cursor) url.Values {
+	query := url.Values{
+		"clientId":             {c.clientID},
+		"datasetGenerationKey": {cursor.DatasetGenerationKey},
+		"since":                {strconv.FormatInt(cursor.ServerSeq, 10)},
+	}
+	if c.omitOwn {
+		query.Set("omitOwn", "true")
+	}
+	return query
+}
+
+// ResetRequest replaces the user's dataset with a new generation.
+type ResetRequest struct {
+	// DatasetGenerationKey names the new generation; it must be new.
+	DatasetGenerationKey string `json:"datasetGenerationKey"`
+	// Snapshot is the new generation's snapshot, encoded as in
+	// docs/export-snapshot-spec.md.
+	Snapshot string `json:"snapshot"`
+	// ExpectedPreviousDatasetGenerationKey, when set, must still be the
+	// active generation, or the reset fails with a *ConflictError.
+	ExpectedPreviousDatasetGenerationKey string `json:"expectedPreviousDatasetGenerationKey,omitempty"`
+}
+
+// Reset replaces the user's dataset, for every client, and returns the
+// cursor at the start of the new generation. A reset that might have reached
+// the server is not retried, since repeating it fails once it succeeded.
+func (c *Client) Reset(ctx context.Context, reset ResetRequest) (Cursor, error) {
+	body := struct {
+		ClientID string `json:"clientId"`
+		ResetRequest
+	}{c.clientID, reset}
+	var cursor Cursor
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/sync/reset", body: body}, &cursor); err != nil {
+		return Cursor{}, err
+	}
+	return cursor, nil
+}
+
+// request is one API call.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	body   any
+	// idempotent marks requests that are safe to send again after a network
+	// error or a bad gateway, when it is unknown whether the server got them.
+	idempotent bool
+}
+
+// do sends req, retrying as the policy allows, and decodes a 2xx answer into
+// out.
+func (c *Client) do(ctx context.Context, req request, out any) error {
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode %s response: %w", req.path, err)
+	}
+	return nil
+}
+
+// send sends req until it gets a 2xx response, which the caller closes, or
+// fails.
+func (c *Client) send(ctx context.Context, req request) (*http.Response, error) {
+	var body []byte
+	if req.body != nil {
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return nil, err
+		}
+	}
+	target := *c.baseURL
+	target.Path += req.path
+	target.RawQuery = req.query.Encode()
+	backoff := c.retry.backoff()
+	for attempt := 1; ; attempt++ {
+		resp, err := c.sendOnce(ctx, req, target.String(), body)
+		if err == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil || attempt >= c.retry.Attempts || !retryable(err, req.idempotent) {
+			return nil, err
+		}
+		if err := sleep(ctx, backoff.next(err)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) sendOnce(ctx context.Context, req request, target string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range req.header {
+		httpReq.Header[name] = values
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	httpReq.Header.Set("Accept", "application/json")
+	httpReq.Header.Set(protocolVersionHeader, strconv.Itoa(ProtocolVersion))
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer func() { _ = resp.Body.Close() }()
+	return nil, responseError(resp)
+}
+
+// responseError turns an error response into an *Error, or a
+// *ConflictError for a stale generation.
+func responseError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBytes))
+	if resp.StatusCode == http.StatusConflict {
+		var conflict ConflictError
+		if json.Unmarshal(data, &conflict) == nil && conflict.DatasetGenerationKey != "" {
+			conflict.StatusCode = resp.StatusCode
+			if conflict.Code == CodeForcedResync {
+				var bootstrap Bootstrap
+				if err := json.Unmarshal(data, &bootstrap); err != nil {
+					return fmt.Errorf("decode forced resync: %w", err)
+				}
+				conflict.Bootstrap = &bootstrap
+			}
+			return &conflict
+		}
+	}
+	apiErr := &Error{StatusCode: resp.StatusCode, ErrorID: resp.Header.Get("X-Error-Id")}
+	_ = json.Unmarshal(data, apiErr)
+	if apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(data))
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return apiErr
+}
+
+// maxErrorBytes bounds how much of an error response is read; forced
+// resyncs carry a whole bootstrap.
+const maxErrorBytes = 256 << 20
+
+// Pairing is the result of redeeming a pairing code.
+type Pairing struct {
+	Token APIToken `json:"token"`
+	// Secret is the token's secret, for Config.Token. The server shows it
+	// only once.
+	Secret string `json:"secret"`
+	// Bootstrap is the user's bootstrap, when the token may read every list.
+	Bootstrap *Bootstrap `json:"bootstrap"`
+}
+
+// APIToken describes an API token.
+type APIToken struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ListID    string   `json:"listId,omitempty"`
+	CreatedAt int64    `json:"createdAt"`
+	ExpiresAt int64    `json:"expiresAt,omitempty"`
+}
+
+// RedeemPairing exchanges a pairing code, started from a signed-in browser
+// with POST /auth/pairing, for an API token named name. The code works once,
+// so the request is never retried. httpClient may be nil.
+func RedeemPairing(ctx context.Context, baseURL string, httpClient *http.Client, code string, name string) (*Pairing, error) {
+	parsed, err := parseBaseURL(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	body, err := json.Marshal(struct {
+		Code string `json:"code"`
+		Name string `json:"name"`
+	}{code, name})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, parsed.String()+"/auth/pairing/redeem", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+	var pairing Pairing
+	if err := json.NewDecoder(resp.Body).Decode(&pairing); err != nil {
+		return nil, fmt.Errorf("decode pairing: %w", err)
+	}
+	return &pairing, nil
+}
@@ -0,0 +1,186 @@
+package tasklists
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client, err := New(Config{
+		BaseURL:  server.URL + "/",
+		ClientID: "client-1",
+		Token:    "lat_secret",
+		Retry:    RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	return client
+}
+
+func TestPushRetriesUnservedRequestsButResetIsSentOnce(t *testing.T) {
+	var pushes, resets int
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer lat_secret" || r.Header.Get(protocolVersionHeader) != "1" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		switch r.URL.Path {
+		case "/sync/push":
+			pushes++
+			var body struct {
+				ClientID             string `json:"clientId"`
+				DatasetGenerationKey string `json:"datasetGenerationKey"`
+				Ops                  []Op   `json:"ops"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ClientID != "client-1" || body.DatasetGenerationKey != "gen-1" || len(body.Ops) != 1 {
+				t.Errorf("unexpected push %+v (%v)", body, err)
+			}
+			if pushes == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = w.Write([]byte(`{"error":"database is locked"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"serverSeq":7,"datasetGenerationKey":"gen-1"}`))
+		case "/sync/reset":
+			resets++
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	})
+	ctx := context.Background()
+
+	result, err := client.Push(ctx, "gen-1", []Op{{Scope: ScopeList, ResourceID: "list-1", Actor: "a", Clock: 1, Payload: json.RawMessage(`{"type":"insert"}`)}})
+	if err != nil || result.ServerSeq != 7 || pushes != 2 {
+		t.Fatalf("expected the push to succeed on its second attempt, got %+v %v after %d", result, err, pushes)
+	}
+	_, err = client.Reset(ctx, ResetRequest{DatasetGenerationKey: "gen-2", Snapshot: "{}"})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway || resets != 1 {
+		t.Fatalf("expected one reset attempt failing with 502, got %v after %d", err, resets)
+	}
+}
+
+func TestConflictsSayHowToRecover(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "1")
+		switch r.URL.Query().Get("datasetGenerationKey") {
+		case "compacted":
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"datasetGenerationKey":"gen-2","snapshot":"{}","lineage":"ancestor","conflict":{"compaction":true,"action":"rebase","conflicts":1}}`))
+		case "stuck":
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"code":"forced-resync","datasetGenerationKey":"gen-2","snapshot":"{}","protocolVersion":1,"ops":[{"serverSeq":1,"scope":"list","resourceId":"list-1","actor":"a","clock":1,"payload":{}}],"serverSeq":1,"lineage":"divergent","conflict":{"action":"resync","conflicts":5,"stuck":true}}`))
+		default:
+			w.WriteHeader(http.StatusUpgradeRequired)
+			_, _ = w.Write([]byte(`{"error":"unsupported sync protocol version \"1\""}`))
+		}
+	})
+	ctx := context.Background()
+
+	var conflict *ConflictError
+	_, err := client.Pull(ctx, Cursor{DatasetGenerationKey: "compacted"})
+	if !errors.As(err, &conflict) || !conflict.Rebase() || conflict.DatasetGenerationKey != "gen-2" || conflict.Lineage != LineageAncestor {
+		t.Fatalf("expected a rebase conflict, got %v", err)
+	}
+	_, err = client.Pull(ctx, Cursor{DatasetGenerationKey: "stuck"})
+	if !errors.As(err, &conflict) || conflict.Rebase() || conflict.Bootstrap == nil || conflict.Bootstrap.ServerSeq != 1 || len(conflict.Bootstrap.Ops) != 1 || !conflict.Conflict.Stuck {
+		t.Fatalf("expected a forced resync carrying the bootstrap, got %v", err)
+	}
+	_, err = client.Pull(ctx, Cursor{DatasetGenerationKey: "gen-1"})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUpgradeRequired || apiErr.RetryAfter != time.Second || !strings.Contains(err.Error(), "unsupported sync protocol version") {
+		t.Fatalf("expected the error answer, got %v", err)
+	}
+}
+
+func TestStreamResumesAfterTheLastHandledOps(t *testing.T) {
+	var connections []string
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		connections = append(connections, r.URL.Query().Get("since"))
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "retry: 3000\n\n")
+		if len(connections) == 1 {
+			fmt.Fprint(w, ": keepalive\n\nid: token-3\nevent: ops\ndata: {\"serverSeq\":3,\"datasetGenerationKey\":\"gen-1\",\n")
+			fmt.Fprint(w, "data: \"ops\":[{\"serverSeq\":3,\"scope\":\"list\",\"resourceId\":\"list-1\",\"actor\":\"b\",\"clock\":1,\"payload\":{}}]}\n\n")
+			return
+		}
+		fmt.Fprint(w, "event: reset\ndata: {\"datasetGenerationKey\":\"gen-2\"}\n\n")
+	})
+
+	var handled []int64
+	err := client.Stream(context.Background(), Cursor{DatasetGenerationKey: "gen-1", ServerSeq: 2}, func(result *PullResult) error {
+		for _, op := range result.Ops {
+			handled = append(handled, op.ServerSeq)
+		}
+		return nil
+	})
+	if !errors.Is(err, ErrGenerationChanged) || !strings.Contains(err.Error(), "gen-2") {
+		t.Fatalf("expected the reset to end the stream, got %v", err)
+	}
+	if len(handled) != 1 || handled[0] != 3 || strings.Join(connections, ",") != "2,3" {
+		t.Fatalf("expected op 3 once and a reconnect after it, got %v over connections since %v", handled, connections)
+	}
+}
+
+type replica struct {
+	snapshot string
+	ops      []Op
+}
+
+func (r *replica) Restore(_ context.Context, snapshot string, ops []Op) error {
+	r.snapshot, r.ops = snapshot, append([]Op(nil), ops...)
+	return nil
+}
+
+func (r *replica) Apply(_ context.Context, ops []Op) error {
+	r.ops = append(r.ops, ops...)
+	return nil
+}
+
+func TestSyncRebasesUnsentOpsAndResyncsOnHint(t *testing.T) {
+	var requests []string
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("datasetGenerationKey")
+		if r.URL.Path == "/sync/push" {
+			var body struct {
+				DatasetGenerationKey string `json:"datasetGenerationKey"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			key = body.DatasetGenerationKey
+		}
+		requests = append(requests, strings.TrimPrefix(r.URL.Path, "/sync/")+" "+key)
+		switch {
+		case r.URL.Path == "/sync/bootstrap":
+			_, _ = w.Write([]byte(`{"datasetGenerationKey":"gen-3","snapshot":"snapshot-3","ops":[],"serverSeq":0}`))
+		case key == "gen-1":
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"datasetGenerationKey":"gen-2","snapshot":"snapshot-2","lineage":"ancestor","conflict":{"compaction":true,"action":"rebase","conflicts":1}}`))
+		case r.URL.Path == "/sync/push":
+			_, _ = w.Write([]byte(`{"serverSeq":1,"datasetGenerationKey":"gen-2"}`))
+		default:
+			_, _ = w.Write([]byte(`{"serverSeq":2,"datasetGenerationKey":"gen-2","ops":[{"serverSeq":2,"scope":"list","resourceId":"list-1","actor":"b","clock":1,"payload":{}}],"clientHint":"resync"}`))
+		}
+	})
+
+	local := &replica{}
+	sync := NewSync(client, local, Cursor{DatasetGenerationKey: "gen-1", ServerSeq: 9})
+	sync.Queue(Op{Scope: ScopeList, ResourceID: "list-1", Actor: "a", Clock: 1, Payload: json.RawMessage(`{}`)})
+	if err := sync.Run(context.Background()); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got := strings.Join(requests, ", "); got != "push gen-1, push gen-2, pull gen-2, bootstrap " {
+		t.Fatalf("expected a rebase, the push again, and a resync for the hint, got %s", got)
+	}
+	if sync.Cursor() != (Cursor{DatasetGenerationKey: "gen-3"}) || len(sync.Pending()) != 0 || local.snapshot != "snapshot-3" || len(local.ops) != 0 {
+		t.Fatalf("expected the replica restored from the bootstrap, got %+v %+v", sync.Cursor(), local)
+	}
+}
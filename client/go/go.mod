@@ -0,0 +1,3 @@
+module a4-tasklists/client/go
+
+go 1.25
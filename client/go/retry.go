@@ -0,0 +1,89 @@
+package tasklists
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy configures retries. Zero fields take the defaults.
+//
+// Answers that say the server did not serve the request, 429 and 503, are
+// retried for every request, after Retry-After when the server sent one.
+// Network errors, 502 and 504 leave it unknown whether the server got the
+// request, so only requests that are safe to repeat are retried after them.
+type RetryPolicy struct {
+	// Attempts is how often a request is tried in total. 1 disables retries.
+	// Defaults to 5.
+	Attempts int
+	// BaseDelay is the backoff before the first retry; it doubles with every
+	// further retry up to MaxDelay. The actual delay is drawn uniformly from
+	// zero up to that bound so that retrying clients spread out. Defaults to
+	// 250ms and 10s.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.Attempts <= 0 {
+		p.Attempts = 5
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = 250 * time.Millisecond
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = 10 * time.Second
+	}
+	return p
+}
+
+func (p RetryPolicy) backoff() *backoff {
+	return &backoff{delay: p.BaseDelay, max: p.MaxDelay}
+}
+
+// backoff hands out the delays before the retries of one request.
+type backoff struct {
+	delay time.Duration
+	max   time.Duration
+}
+
+// next returns the delay before the next retry after err.
+func (b *backoff) next(err error) time.Duration {
+	delay := rand.N(b.delay) + 1
+	b.delay = min(2*b.delay, b.max)
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return apiErr.RetryAfter
+	}
+	return delay
+}
+
+// retryable reports whether a request that failed with err may be sent
+// again.
+func retryable(err error, idempotent bool) bool {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		var conflict *ConflictError
+		return idempotent && !errors.As(err, &conflict)
+	}
+	switch apiErr.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,116 @@
+package tasklists
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrGenerationChanged is returned by Stream when the active generation
+// changed while it was open. Pull with the same cursor then fails with the
+// *ConflictError that says how to recover.
+var ErrGenerationChanged = errors.New("dataset generation changed")
+
+// Stream hands the ops after cursor to handle as the server stores them,
+// until ctx ends or handle fails. A dropped connection is resumed after the
+// last ops handled, so none are lost or repeated; it fails once reconnecting
+// failed as often as the retry policy allows in a row.
+//
+// A stale cursor fails with a *ConflictError, and a generation change while
+// streaming with ErrGenerationChanged. Client hints only come with Pull.
+func (c *Client) Stream(ctx context.Context, cursor Cursor, handle func(*PullResult) error) error {
+	backoff := c.retry.backoff()
+	failures := 0
+	for {
+		var handleErr error
+		delivered := false
+		err := c.streamOnce(ctx, cursor, func(result *PullResult) error {
+			delivered = true
+			cursor = result.Cursor()
+			handleErr = handle(result)
+			return handleErr
+		})
+		if handleErr != nil {
+			return handleErr
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, ErrGenerationChanged) || !retryable(err, true) {
+			return err
+		}
+		if delivered {
+			failures = 0
+			backoff = c.retry.backoff()
+		}
+		failures++
+		if failures >= c.retry.Attempts {
+			return err
+		}
+		if err := sleep(ctx, backoff.next(err)); err != nil {
+			return err
+		}
+	}
+}
+
+// streamOnce reads one stream connection until it ends.
+func (c *Client) streamOnce(ctx context.Context, cursor Cursor, handle func(*PullResult) error) error {
+	req := request{method: http.MethodGet, path: "/sync/stream", query: c.cursorQuery(cursor), header: http.Header{"Accept": {"text/event-stream"}}, idempotent: true}
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	reader := bufio.NewReader(resp.Body)
+	var event string
+	var data strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("stream closed: %w", io.ErrUnexpectedEOF)
+			}
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		if line != "" {
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "event":
+				event = value
+			case "data":
+				if data.Len() > 0 {
+					data.WriteByte('\n')
+				}
+				data.WriteString(value)
+			}
+			continue
+		}
+		switch event {
+		case "ops":
+			var result PullResult
+			if err := json.Unmarshal([]byte(data.String()), &result); err != nil {
+				return fmt.Errorf("decode stream event: %w", err)
+			}
+			if err := handle(&result); err != nil {
+				return err
+			}
+		case "reset":
+			var reset Cursor
+			_ = json.Unmarshal([]byte(data.String()), &reset)
+			return fmt.Errorf("%w: the active generation is now %s", ErrGenerationChanged, reset.DatasetGenerationKey)
+		}
+		event = ""
+		data.Reset()
+	}
+}
@@ -0,0 +1,146 @@
+package tasklists
+
+import (
+	"context"
+	"errors"
+	"slices"
+)
+
+// Replica is the local state a Sync keeps in step with the server. Ops are
+// applied in serverSeq order and may arrive more than once; CRDT ops are
+// idempotent.
+type Replica interface {
+	// Restore replaces the local state with snapshot and then applies ops.
+	// It is called after a bootstrap and to recover from conflicts.
+	Restore(ctx context.Context, snapshot string, ops []Op) error
+	// Apply applies ops pulled from the server: those of other clients and,
+	// unless Config.OmitOwn is set, the client's own again.
+	Apply(ctx context.Context, ops []Op) error
+}
+
+// maxRecoveries bounds the conflicts Run recovers from in one call, in case
+// the generation keeps changing under it.
+const maxRecoveries = 3
+
+// Sync pushes a program's ops and applies those of other clients to its
+// Replica, recovering from generation conflicts as the protocol asks: it
+// rebases the unsent ops after a compaction and resyncs from a bootstrap
+// otherwise. A Sync is not safe for concurrent use.
+type Sync struct {
+	client  *Client
+	replica Replica
+	cursor  Cursor
+	pending []Op
+}
+
+// NewSync returns a Sync that continues from cursor, as saved by an earlier
+// run with Cursor. The zero Cursor starts with a bootstrap.
+func NewSync(client *Client, replica Replica, cursor Cursor) *Sync {
+	return &Sync{client: client, replica: replica, cursor: cursor}
+}
+
+// Cursor returns how far the replica is synced. Programs save it together
+// with the replica, and with the pending ops.
+func (s *Sync) Cursor() Cursor {
+	return s.cursor
+}
+
+// Queue adds ops the program made, and already applied to the replica, to
+// be pushed by the next Run.
+func (s *Sync) Queue(ops ...Op) {
+	s.pending = append(s.pending, ops...)
+}
+
+// Pending returns the queued ops that were not pushed yet.
+func (s *Sync) Pending() []Op {
+	return s.pending
+}
+
+// Run bootstraps when there is no cursor yet, pushes the queued ops and
+// applies the ops pulled since the cursor. It recovers from conflicts on
+// the way; other errors leave the queue and cursor where they were, so Run
+// can simply be called again.
+func (s *Sync) Run(ctx context.Context) error {
+	var err error
+	for range maxRecoveries + 1 {
+		err = s.round(ctx)
+		var conflict *ConflictError
+		if !errors.As(err, &conflict) {
+			return err
+		}
+		if err := s.recover(ctx, conflict); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (s *Sync) round(ctx context.Context) error {
+	if s.cursor.DatasetGenerationKey == "" {
+		if err := s.restore(ctx, nil, true); err != nil {
+			return err
+		}
+	}
+	if len(s.pending) > 0 {
+		result, err := s.client.Push(ctx, s.cursor.DatasetGenerationKey, s.pending)
+		if err != nil {
+			return err
+		}
+		s.pending = nil
+		for _, diagnostic := range result.Diagnostics {
+			// The server changed the ops, so the replica no longer matches.
+			if diagnostic.Action == "repaired" {
+				return s.restore(ctx, nil, true)
+			}
+		}
+	}
+	result, err := s.client.Pull(ctx, s.cursor)
+	if err != nil {
+		return err
+	}
+	if len(result.Ops) > 0 {
+		if err := s.replica.Apply(ctx, result.Ops); err != nil {
+			return err
+		}
+	}
+	s.cursor = result.Cursor()
+	if result.ClientHint == HintResync {
+		return s.restore(ctx, nil, false)
+	}
+	return nil
+}
+
+// recover follows conflict's advice.
+func (s *Sync) recover(ctx context.Context, conflict *ConflictError) error {
+	if !conflict.Rebase() {
+		return s.restore(ctx, conflict.Bootstrap, false)
+	}
+	// The snapshot contains everything the replica synced; the unsent ops
+	// are applied on top again and pushed to the new generation.
+	if err := s.replica.Restore(ctx, conflict.Snapshot, s.pending); err != nil {
+		return err
+	}
+	s.cursor = Cursor{DatasetGenerationKey: conflict.DatasetGenerationKey}
+	return nil
+}
+
+// restore restores the replica from bootstrap, fetched when nil. The unsent
+// ops are applied on top again unless they are dropped, as they are when the
+// server discarded the state they were made against.
+func (s *Sync) restore(ctx context.Context, bootstrap *Bootstrap, keepPending bool) error {
+	if bootstrap == nil {
+		var err error
+		if bootstrap, err = s.client.Bootstrap(ctx); err != nil {
+			return err
+		}
+	}
+	var pending []Op
+	if keepPending {
+		pending = s.pending
+	}
+	if err := s.replica.Restore(ctx, bootstrap.Snapshot, append(slices.Clip(bootstrap.Ops), pending...)); err != nil {
+		return err
+	}
+	s.cursor, s.pending = bootstrap.Cursor(), pending
+	return nil
+}
@@ -0,0 +1,195 @@
+package tasklists
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Op scopes the server accepts.
+const (
+	// ScopeRegistry ops create, rename, reorder and remove lists. Their
+	// ResourceID is always ResourceRegistry.
+	ScopeRegistry = "registry"
+	// ScopeList ops change the items of the list named by their ResourceID.
+	ScopeList = "list"
+	// ScopeComment ops change the comments on the items of the list named by
+	// their ResourceID.
+	ScopeComment = "comment"
+)
+
+// ResourceRegistry is the ResourceID of registry ops.
+const ResourceRegistry = "registry"
+
+// Op is one CRDT operation in its sync envelope. The server dedupes ops on
+// (Actor, Clock, Scope, ResourceID).
+type Op struct {
+	// ServerSeq is assigned by the server; leave it zero when pushing.
+	ServerSeq  int64  `json:"serverSeq,omitempty"`
+	Scope      string `json:"scope"`
+	ResourceID string `json:"resourceId"`
+	// Actor is the id of the replica that made the op. The first push of an
+	// actor binds it to the user and client.
+	Actor string `json:"actor"`
+	// Clock is the op's Lamport clock, starting at 1.
+	Clock   int64           `json:"clock"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Cursor is a position in the op log: the ops of the generation
+// DatasetGenerationKey up to ServerSeq.
+type Cursor struct {
+	DatasetGenerationKey string `json:"datasetGenerationKey"`
+	ServerSeq            int64  `json:"serverSeq"`
+}
+
+// Bootstrap is the active generation's snapshot and op log.
+type Bootstrap struct {
+	DatasetGenerationKey string `json:"datasetGenerationKey"`
+	// Snapshot is encoded as in docs/export-snapshot-spec.md.
+	Snapshot        string `json:"snapshot"`
+	ProtocolVersion int    `json:"protocolVersion"`
+	Ops             []Op   `json:"ops"`
+	ServerSeq       int64  `json:"serverSeq"`
+}
+
+// Cursor returns the position right after the bootstrap.
+func (b *Bootstrap) Cursor() Cursor {
+	return Cursor{DatasetGenerationKey: b.DatasetGenerationKey, ServerSeq: b.ServerSeq}
+}
+
+// PushResult is the server's answer to a push.
+type PushResult struct {
+	ServerSeq            int64  `json:"serverSeq"`
+	DatasetGenerationKey string `json:"datasetGenerationKey"`
+	// Spooled reports that the server's storage was unavailable and it
+	// journaled the ops instead. They are durable, but ServerSeq is unknown
+	// and the ops arrive with a later pull.
+	Spooled     bool         `json:"spooled,omitempty"`
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+}
+
+// Diagnostic reports a problem the server found with pushed ops.
+type Diagnostic struct {
+	// Code is move-target-missing or move-source-missing.
+	Code string `json:"code"`
+	// Action is repaired when the server changed the ops, after which the
+	// local state no longer matches and should be restored from a bootstrap.
+	Action       string `json:"action"`
+	ItemID       string `json:"itemId,omitempty"`
+	SourceListID string `json:"sourceListId,omitempty"`
+	TargetListID string `json:"targetListId,omitempty"`
+	Message      string `json:"message,omitempty"`
+}
+
+// PullResult carries the ops after a cursor. Stream delivers the same.
+type PullResult struct {
+	ServerSeq            int64  `json:"serverSeq"`
+	DatasetGenerationKey string `json:"datasetGenerationKey"`
+	Ops                  []Op   `json:"ops"`
+	// Actors attributes ops made by other users' actors, on shared lists.
+	Actors map[string]Actor `json:"actors,omitempty"`
+	// ClientHint is an operator's one-time hint, HintResync or HintUpgrade.
+	// Streams never carry it.
+	ClientHint string `json:"clientHint,omitempty"`
+}
+
+// Cursor returns the position after the result's ops.
+func (r *PullResult) Cursor() Cursor {
+	return Cursor{DatasetGenerationKey: r.DatasetGenerationKey, ServerSeq: r.ServerSeq}
+}
+
+// Actor is the display attribution of another user's actor.
+type Actor struct {
+	DisplayName string `json:"displayName,omitempty"`
+	AvatarURL   string `json:"avatarUrl,omitempty"`
+}
+
+// Client hints an operator can leave for a client.
+const (
+	// HintResync asks the client to discard its sync state, unsent ops
+	// included, and restore from a bootstrap.
+	HintResync = "resync"
+	// HintUpgrade asks the client to update itself.
+	HintUpgrade = "upgrade"
+)
+
+// Error is an error answer of the server.
+type Error struct {
+	StatusCode int `json:"-"`
+	// Message is the server's English error message.
+	Message string `json:"error"`
+	// ErrorID identifies a server bug in the operator's logs.
+	ErrorID string `json:"errorId,omitempty"`
+	// RetryAfter is how long the server asked the client to wait.
+	RetryAfter time.Duration `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if e.ErrorID != "" {
+		return fmt.Sprintf("server answered %d: %s (error id %s)", e.StatusCode, e.Message, e.ErrorID)
+	}
+	return fmt.Sprintf("server answered %d: %s", e.StatusCode, e.Message)
+}
+
+// Recovery actions of a ConflictError.
+const (
+	// ActionRebase means the generation was compacted: adopt the returned
+	// snapshot and key and push the unsent ops again.
+	ActionRebase = "rebase"
+	// ActionResync means the generation was replaced: discard the local sync
+	// state, unsent ops included, and restore from a bootstrap.
+	ActionResync = "resync"
+)
+
+// Lineage values of a ConflictError.
+const (
+	// LineageAncestor means the client's generation was compacted into the
+	// active one, which contains everything the client synced.
+	LineageAncestor = "ancestor"
+	// LineageDivergent means an import replaced the client's generation.
+	LineageDivergent = "divergent"
+)
+
+// CodeForcedResync is the Code of a ConflictError that carries a Bootstrap.
+const CodeForcedResync = "forced-resync"
+
+// ConflictError reports that a request named a generation that is no longer
+// active. It carries the active generation and how to recover.
+type ConflictError struct {
+	StatusCode int `json:"-"`
+	// Code is CodeForcedResync when the server forces a client stuck in a
+	// conflict loop to resync.
+	Code                 string `json:"code"`
+	DatasetGenerationKey string `json:"datasetGenerationKey"`
+	// Snapshot is the active generation's snapshot.
+	Snapshot string `json:"snapshot"`
+	// Lineage is LineageAncestor or LineageDivergent.
+	Lineage  string   `json:"lineage"`
+	Conflict Conflict `json:"conflict"`
+	// Bootstrap is the whole bootstrap that comes with a forced resync.
+	Bootstrap *Bootstrap `json:"-"`
+}
+
+// Conflict explains a ConflictError. Resets that lost a race carry none.
+type Conflict struct {
+	GenerationAgeSeconds int64 `json:"generationAgeSeconds"`
+	// Compaction reports whether a compaction replaced the generation.
+	Compaction bool `json:"compaction"`
+	// Action is ActionRebase or ActionResync.
+	Action string `json:"action"`
+	// Conflicts counts the client's conflicts in a row, this one included.
+	Conflicts int  `json:"conflicts"`
+	Stuck     bool `json:"stuck"`
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("dataset generation conflict (%d): active generation is %s, lineage %s", e.StatusCode, e.DatasetGenerationKey, e.Lineage)
+}
+
+// Rebase reports whether the client can keep its unsent ops: adopt Snapshot
+// at the start of the active generation and push them again. Otherwise it
+// has to resync.
+func (e *ConflictError) Rebase() bool {
+	return e.Bootstrap == nil && e.Conflict.Action == ActionRebase
+}
@@ -114,6 +114,9 @@ export class ListRepository {
   private _syncOptions: SyncOptions | null;
   private _syncErrorHandler: ((error: unknown) => void) | null;
   private _outboxPersistQueue: Promise<void>;
+  // Lists the server reports as archived. Their local copies stay in storage
+  // but are hidden until the server restores them.
+  private _archivedListIds: Set<ListId>;
 
   constructor(
     options: {
@@ -150,6 +153,7 @@ export class ListRepository {
     this._sync = null;
     this._syncErrorHandler = null;
     this._outboxPersistQueue = Promise.resolve();
+    this._archivedListIds = new Set();
   }
 
   recordHistory({
@@ -320,6 +324,7 @@ export class ListRepository {
       onSnapshot: async ({ snapshot }) => {
         await this.applySnapshotBlob(snapshot);
       },
+      onArchivedLists: (listIds) => this.setArchivedLists(listIds),
       onConnectionError: (error) => {
         this._syncErrorHandler?.(error);
         this.disableSync();
@@ -343,7 +348,19 @@ export class ListRepository {
   }
 
   getRegistrySnapshot(): ListRegistryEntry[] {
-    return this._listsCrdt.getVisibleLists();
+    const lists = this._listsCrdt.getVisibleLists();
+    if (this._archivedListIds.size === 0) return lists;
+    return lists.filter((entry) => !this._archivedListIds.has(entry.id));
+  }
+
+  setArchivedLists(listIds: ListId[]) {
+    const next = new Set(listIds);
+    const unchanged =
+      next.size === this._archivedListIds.size &&
+      [...next].every((listId) => this._archivedListIds.has(listId));
+    if (unchanged) return;
+    this._archivedListIds = next;
+    this.emitRegistryChange();
   }
 
   getListIds(): ListId[] {
@@ -5,6 +5,15 @@ import type { ListsOperation, TaskListOperation } from "../types/crdt.js";
 
 type FetchFn = typeof fetch;
 
+// Sync protocol version this client implements; see docs/protocol-spec.md.
+// Version 2 servers leave archived lists out of sync responses unless
+// includeArchived is set.
+const SYNC_PROTOCOL_VERSION = 2;
+
+// Code of the 409 that forces a client stuck in a conflict loop to resync;
+// it carries the whole bootstrap.
+const FORCED_RESYNC_CODE = "forced-resync";
+
 type SyncEngineOptions = {
   storage: ListStorage;
   baseUrl: string;
@@ -13,13 +22,26 @@ type SyncEngineOptions = {
   fetchFn?: FetchFn;
   onRemoteOps?: (ops: SyncOp[]) => Promise<void> | void;
   onSnapshot?: (payload: { datasetGenerationKey: string; snapshot: string }) => Promise<void> | void;
+  // Called with the ids of the user's archived lists after each bootstrap
+  // and pull, so their local copies can be hidden.
+  onArchivedLists?: (listIds: string[]) => Promise<void> | void;
   onConnectionError?: (error: unknown) => void;
   clientId?: string;
+  // Receive archived lists too (archived=include).
+  includeArchived?: boolean;
+};
+
+type SyncPushDiagnostic = {
+  code: string;
+  action: "repaired" | "accepted";
+  itemId?: string;
+  message?: string;
 };
 
 type SyncPushResponse = {
   serverSeq?: number;
   datasetGenerationKey?: string;
+  diagnostics?: SyncPushDiagnostic[];
 };
 
 type SyncPullResponse = {
@@ -27,6 +49,9 @@ type SyncPullResponse = {
   ops?: SyncOp[];
   datasetGenerationKey?: string;
   snapshot?: string;
+  clientHint?: string;
+  code?: string;
+  archivedLists?: string[];
 };
 
 type SyncBootstrapResponse = SyncPullResponse;
@@ -42,7 +67,9 @@ export class SyncEngine {
   private fetchFn: FetchFn;
   private onRemoteOps: ((ops: SyncOp[]) => Promise<void> | void) | null;
   private onSnapshot: ((payload: { datasetGenerationKey: string; snapshot: string }) => Promise<void> | void) | null;
+  private onArchivedLists: ((listIds: string[]) => Promise<void> | void) | null;
   private onConnectionError: ((error: unknown) => void) | null;
+  private includeArchived: boolean;
   private state: SyncState;
   private outbox: SyncOp[];
   private timer: ReturnType<typeof setTimeout> | null;
@@ -66,7 +93,9 @@ export class SyncEngine {
       options.fetchFn ?? globalThis.fetch?.bind(globalThis);
     this.onRemoteOps = options.onRemoteOps ?? null;
     this.onSnapshot = options.onSnapshot ?? null;
+    this.onArchivedLists = options.onArchivedLists ?? null;
     this.onConnectionError = options.onConnectionError ?? null;
+    this.includeArchived = options.includeArchived ?? false;
     this.state = { clientId: "", lastServerSeq: 0, datasetGenerationKey: "" };
     this.outbox = [];
     this.timer = null;
@@ -99,7 +128,7 @@ export class SyncEngine {
     if (this.outbox.length > 0) return;
     if (this.state.lastServerSeq > 0 && this.state.datasetGenerationKey) return;
     if (!applyOps) return;
-    const response = await this.safeFetch(`${this.baseUrl}/sync/bootstrap`, {
+    const response = await this.safeFetch(this.bootstrapUrl(), {
       method: "GET",
     });
     if (!response) {
@@ -109,6 +138,7 @@ export class SyncEngine {
       return;
     }
     const payload = (await response.json()) as SyncBootstrapResponse;
+    await this.handleArchivedLists(payload);
     const resetApplied = await this.handleSnapshotResponse(payload);
     if (resetApplied) {
       return;
@@ -194,8 +224,12 @@ export class SyncEngine {
     if (!response) {
       return;
     }
+    if (response.status === 410) {
+      this.handleRevoked();
+      return;
+    }
     if (response.status === 409) {
-      await this.handleSnapshotResponse(await response.json());
+      await this.handleConflictResponse(await response.json());
       return;
     }
     if (!response.ok) {
@@ -209,26 +243,48 @@ export class SyncEngine {
     this.outbox = [];
     await this.storage.persistOutbox(this.outbox);
     await this.storage.persistSyncState(this.state);
+    const diagnostics = Array.isArray(payload.diagnostics) ? payload.diagnostics : [];
+    if (diagnostics.some((diagnostic) => diagnostic.action === "repaired")) {
+      // The server dropped some of our ops, so local state no longer matches.
+      await this.resyncFromServer();
+    }
   }
 
   private async pullRemoteOps() {
     const response = await this.safeFetch(
       `${this.baseUrl}/sync/pull?since=${this.state.lastServerSeq}&clientId=${encodeURIComponent(
         this.state.clientId
-      )}&datasetGenerationKey=${encodeURIComponent(this.state.datasetGenerationKey ?? "")}`,
+      )}&datasetGenerationKey=${encodeURIComponent(this.state.datasetGenerationKey ?? "")}&omitOwn=true${
+        this.includeArchived ? "&archived=include" : ""
+      }`,
       { method: "GET" }
     );
     if (!response) {
       return;
     }
+    if (response.status === 410) {
+      this.handleRevoked();
+      return;
+    }
     if (response.status === 409) {
-      await this.handleSnapshotResponse(await response.json());
+      await this.handleConflictResponse(await response.json());
       return;
     }
     if (!response.ok) {
       return;
     }
     const payload = (await response.json()) as SyncPullResponse;
+    if (payload.clientHint === "upgrade" && typeof window !== "undefined") {
+      try {
+        window.location.reload();
+      } catch {}
+      return;
+    }
+    if (payload.clientHint === "resync") {
+      await this.resyncFromServer();
+      return;
+    }
+    await this.handleArchivedLists(payload);
     if (payload.datasetGenerationKey) {
       this.state.datasetGenerationKey = payload.datasetGenerationKey;
     }
@@ -243,11 +299,48 @@ export class SyncEngine {
     }
   }
 
+  // Discards local sync state (including unsent ops) and restores from a
+  // fresh bootstrap. The server asks for this when an operator flags the
+  // client as needing a full resync.
+  private async resyncFromServer() {
+    const response = await this.safeFetch(this.bootstrapUrl(), {
+      method: "GET",
+    });
+    if (!response || !response.ok) {
+      return;
+    }
+    await this.restoreFromBootstrap((await response.json()) as SyncBootstrapResponse);
+  }
+
+  // A forced resync carries the bootstrap, so it is restored without another
+  // request; other conflicts only bring the active snapshot.
+  private async handleConflictResponse(payload: SyncPullResponse) {
+    if (payload?.code === FORCED_RESYNC_CODE) {
+      await this.restoreFromBootstrap(payload);
+      return;
+    }
+    await this.handleSnapshotResponse(payload);
+  }
+
+  private async restoreFromBootstrap(payload: SyncBootstrapResponse) {
+    await this.handleArchivedLists(payload);
+    this.state.datasetGenerationKey = "";
+    const resetApplied = await this.handleSnapshotResponse(payload);
+    if (!resetApplied) {
+      return;
+    }
+    const ops = Array.isArray(payload.ops) ? payload.ops : [];
+    if (ops.length > 0 && this.onRemoteOps) {
+      await this.onRemoteOps(ops);
+    }
+  }
+
   async resetWithSnapshot(snapshot: string): Promise<{ ok: boolean; error?: string; status?: number }> {
     if (!snapshot || typeof snapshot !== "string") {
       return { ok: false, error: "Snapshot payload is required." };
     }
     const datasetGenerationKey = crypto.randomUUID();
+    const expectedPreviousDatasetGenerationKey = this.state.datasetGenerationKey || undefined;
     const response = await this.safeFetch(`${this.baseUrl}/sync/reset`, {
       method: "POST",
       headers: { "Content-Type": "application/json" },
@@ -255,6 +348,7 @@ export class SyncEngine {
         clientId: this.state.clientId,
         datasetGenerationKey,
         snapshot,
+        expectedPreviousDatasetGenerationKey,
       }),
     });
     if (!response) {
@@ -263,11 +357,13 @@ export class SyncEngine {
     if (!response.ok) {
       const status = response.status;
       let message = "Failed to publish snapshot to the server.";
+      let generationChanged = false;
       try {
-        const payload = (await response.json()) as { error?: string };
+        const payload = (await response.json()) as { error?: string; datasetGenerationKey?: string };
         if (payload?.error) {
           message = payload.error;
         }
+        generationChanged = typeof payload?.datasetGenerationKey === "string";
       } catch {
         try {
           const text = await response.text();
@@ -277,7 +373,9 @@ export class SyncEngine {
         } catch {}
       }
       if (status === 409) {
-        message = "Server rejected the snapshot because the dataset generation key already exists.";
+        message = generationChanged
+          ? "Server rejected the snapshot because another device replaced the data since this device last synced."
+          : "Server rejected the snapshot because the dataset generation key already exists.";
       }
       return { ok: false, error: message, status };
     }
@@ -311,6 +409,26 @@ export class SyncEngine {
     return true;
   }
 
+  // Responses leave the field out when no list is archived.
+  private async handleArchivedLists(payload: SyncPullResponse) {
+    if (!this.onArchivedLists) return;
+    const listIds = Array.isArray(payload?.archivedLists)
+      ? payload.archivedLists.filter((listId) => typeof listId === "string")
+      : [];
+    await this.onArchivedLists(listIds);
+  }
+
+  private bootstrapUrl() {
+    return `${this.baseUrl}/sync/bootstrap${this.includeArchived ? "?archived=include" : ""}`;
+  }
+
+  // The user revoked this client id from another device; syncing stops until
+  // the app's local data is cleared and it registers as a new client.
+  private handleRevoked() {
+    this.stop();
+    this.onConnectionError?.(new Error("This device was removed from sync."));
+  }
+
   private async safeFetch(
     url: string,
     init: RequestInit
@@ -318,8 +436,15 @@ export class SyncEngine {
     const controller = new AbortController();
     const timeout = setTimeout(() => controller.abort(), this.requestTimeoutMs);
     try {
+      const headers = new Headers(init.headers);
+      headers.set("X-Sync-Protocol-Version", String(SYNC_PROTOCOL_VERSION));
+      const csrfToken = readCsrfToken();
+      if (csrfToken) {
+        headers.set("X-CSRF-Token", csrfToken);
+      }
       const response = await this.fetchFn(url, {
         ...init,
+        headers,
         signal: controller.signal,
       });
       clearTimeout(timeout);
@@ -337,6 +462,14 @@ export class SyncEngine {
   }
 }
 
+// Servers in double-submit CSRF mode expect the csrf_token cookie echoed back
+// in a header; other modes never set the cookie.
+function readCsrfToken() {
+  if (typeof document === "undefined") return "";
+  const match = document.cookie.match(/(?:^|;\s*)csrf_token=([^;]*)/);
+  return match ? match[1] : "";
+}
+
 function parseServerSeq(value: unknown) {
   if (!Number.isFinite(value)) return 0;
   return Math.max(0, Math.floor(value as number));
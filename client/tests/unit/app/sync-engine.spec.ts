@@ -101,3 +101,328 @@ test("SyncEngine applies remote ops", async () => {
   assert.equal(received.length, 1);
   assert.equal(received[0].scope, "registry");
 });
+
+test("SyncEngine reloads the page for an upgrade hint without applying the pull", async () => {
+  const { storage, getState } = createStorage();
+  const received: SyncOp[] = [];
+  let reloads = 0;
+  const fetchFn = async (url: string) => {
+    if (url.includes("/sync/pull")) {
+      return new Response(
+        JSON.stringify({
+          serverSeq: 4,
+          datasetGenerationKey: "dataset-1",
+          ops: [{ scope: "registry", resourceId: "registry", actor: "actor-2", clock: 1, payload: {} }],
+          clientHint: "upgrade",
+        }),
+        { status: 200 }
+      );
+    }
+    return new Response("", { status: 404 });
+  };
+  const engine = new SyncEngine({
+    storage,
+    baseUrl: "http://localhost:8080",
+    fetchFn,
+    clientId: "client-1",
+    onRemoteOps: async (ops) => {
+      received.push(...ops);
+    },
+  });
+  await engine.initialize();
+  const globals = globalThis as { window?: unknown };
+  globals.window = { location: { reload: () => reloads++ } };
+  try {
+    await engine.syncOnce();
+  } finally {
+    delete globals.window;
+  }
+
+  assert.equal(reloads, 1);
+  assert.equal(received.length, 0);
+  assert.equal(getState().lastServerSeq, 0);
+});
+
+test("SyncEngine restores from a bootstrap for a resync hint", async () => {
+  const { storage, getState, getOutbox } = createStorage();
+  const received: SyncOp[] = [];
+  const snapshots: string[] = [];
+  const fetchFn = async (url: string) => {
+    if (url.includes("/sync/push")) {
+      return new Response(JSON.stringify({ serverSeq: 2, datasetGenerationKey: "dataset-1" }), { status: 200 });
+    }
+    if (url.includes("/sync/pull")) {
+      return new Response(
+        JSON.stringify({
+          serverSeq: 2,
+          datasetGenerationKey: "dataset-1",
+          ops: [{ scope: "registry", resourceId: "registry", actor: "actor-2", clock: 1, payload: {} }],
+          clientHint: "resync",
+        }),
+        { status: 200 }
+      );
+    }
+    if (url.includes("/sync/bootstrap")) {
+      return new Response(
+        JSON.stringify({
+          serverSeq: 9,
+          datasetGenerationKey: "dataset-1",
+          snapshot: '{"data":{"lists":[]}}',
+          ops: [{ scope: "list", resourceId: "list-1", actor: "actor-2", clock: 2, payload: {} }],
+        }),
+        { status: 200 }
+      );
+    }
+    return new Response("", { status: 404 });
+  };
+  const engine = new SyncEngine({
+    storage,
+    baseUrl: "http://localhost:8080",
+    fetchFn,
+    clientId: "client-1",
+    onRemoteOps: async (ops) => {
+      received.push(...ops);
+    },
+    onSnapshot: async ({ snapshot }) => {
+      snapshots.push(snapshot);
+    },
+  });
+  await engine.initialize();
+  engine.enqueueOps("list", "list-1", [
+    { type: "insert", actor: "actor-1", clock: 1, itemId: "item-1" } as any,
+  ]);
+  await engine.syncOnce();
+
+  assert.deepEqual(snapshots, ['{"data":{"lists":[]}}']);
+  assert.deepEqual(
+    received.map((op) => op.resourceId),
+    ["list-1"]
+  );
+  assert.equal(getState().lastServerSeq, 9);
+  assert.equal(getState().datasetGenerationKey, "dataset-1");
+  assert.equal(getOutbox().length, 0);
+});
+
+test("SyncEngine stops syncing when the server answers 410 to a push or a pull", async () => {
+  for (const revokedPath of ["/sync/push", "/sync/pull"]) {
+    const { storage, getOutbox } = createStorage();
+    const errors: string[] = [];
+    const received: SyncOp[] = [];
+    let pulls = 0;
+    const fetchFn = async (url: string) => {
+      if (url.includes("/sync/pull")) {
+        pulls++;
+      }
+      if (url.includes(revokedPath)) {
+        return new Response(JSON.stringify({ error: "client was retired" }), { status: 410 });
+      }
+      if (url.includes("/sync/push")) {
+        return new Response(JSON.stringify({ serverSeq: 1, datasetGenerationKey: "dataset-1" }), { status: 200 });
+      }
+      return new Response(
+        JSON.stringify({
+          serverSeq: 1,
+          datasetGenerationKey: "dataset-1",
+          ops: [{ scope: "registry", resourceId: "registry", actor: "actor-2", clock: 1, payload: {} }],
+        }),
+        { status: 200 }
+      );
+    };
+    const engine = new SyncEngine({
+      storage,
+      baseUrl: "http://localhost:8080",
+      fetchFn,
+      clientId: "client-1",
+      pollIntervalMs: 1,
+      onRemoteOps: async (ops) => {
+        received.push(...ops);
+      },
+      onConnectionError: (error) => {
+        errors.push((error as Error).message);
+      },
+    });
+    await engine.initialize();
+    engine.enqueueOps("list", "list-1", [
+      { type: "insert", actor: "actor-1", clock: 1, itemId: "item-1" } as any,
+    ]);
+    engine.start();
+    await new Promise((resolve) => setTimeout(resolve, 30));
+    engine.stop();
+
+    assert.deepEqual(errors, ["This device was removed from sync."], revokedPath);
+    assert.equal(pulls, 1, `${revokedPath}: no further polls after the revocation`);
+    if (revokedPath === "/sync/push") {
+      assert.equal(getOutbox().length, 1, "unsent ops stay queued");
+    } else {
+      assert.equal(received.length, 0, "the revoked pull applies nothing");
+    }
+  }
+});
+
+test("SyncEngine resyncs after a push the server repaired, but not after one it accepted", async () => {
+  for (const action of ["repaired", "accepted"]) {
+    const { storage, getState } = createStorage();
+    const requested: string[] = [];
+    const fetchFn = async (url: string) => {
+      requested.push(new URL(url).pathname);
+      if (url.includes("/sync/push")) {
+        return new Response(
+          JSON.stringify({
+            serverSeq: 3,
+            datasetGenerationKey: "dataset-1",
+            diagnostics: [{ code: "move-target-missing", action, itemId: "item-1" }],
+          }),
+          { status: 200 }
+        );
+      }
+      if (url.includes("/sync/bootstrap")) {
+        return new Response(
+          JSON.stringify({ serverSeq: 3, datasetGenerationKey: "dataset-1", snapshot: '{"data":{"lists":[]}}', ops: [] }),
+          { status: 200 }
+        );
+      }
+      return new Response(JSON.stringify({ serverSeq: 3, datasetGenerationKey: "dataset-1", ops: [] }), { status: 200 });
+    };
+    const snapshots: string[] = [];
+    const engine = new SyncEngine({
+      storage,
+      baseUrl: "http://localhost:8080",
+      fetchFn,
+      clientId: "client-1",
+      onSnapshot: async ({ snapshot }) => {
+        snapshots.push(snapshot);
+      },
+    });
+    await engine.initialize();
+    engine.enqueueOps("list", "list-1", [
+      { type: "insert", actor: "actor-1", clock: 1, itemId: "item-1" } as any,
+    ]);
+    await engine.syncOnce();
+
+    if (action === "repaired") {
+      assert.deepEqual(requested, ["/sync/push", "/sync/bootstrap", "/sync/pull"]);
+      assert.equal(snapshots.length, 1);
+    } else {
+      assert.deepEqual(requested, ["/sync/push", "/sync/pull"]);
+      assert.equal(snapshots.length, 0);
+    }
+    assert.equal(getState().lastServerSeq, 3);
+  }
+});
+
+test("SyncEngine pulls without its own ops and still moves past them", async () => {
+  const { storage, getState } = createStorage();
+  const pulls: URL[] = [];
+  const fetchFn = async (url: string) => {
+    if (url.includes("/sync/push")) {
+      return new Response(JSON.stringify({ serverSeq: 2, datasetGenerationKey: "dataset-1" }), { status: 200 });
+    }
+    pulls.push(new URL(url));
+    // The server leaves out the ops this client pushed but reports their serverSeq.
+    return new Response(JSON.stringify({ serverSeq: 2, datasetGenerationKey: "dataset-1", ops: [] }), { status: 200 });
+  };
+  const engine = new SyncEngine({
+    storage,
+    baseUrl: "http://localhost:8080",
+    fetchFn,
+    clientId: "client-1",
+  });
+  await engine.initialize();
+  engine.enqueueOps("list", "list-1", [
+    { type: "insert", actor: "actor-1", clock: 1, itemId: "item-1" } as any,
+    { type: "insert", actor: "actor-1", clock: 2, itemId: "item-2" } as any,
+  ]);
+  await engine.syncOnce();
+  await engine.syncOnce();
+
+  assert.equal(pulls.length, 2);
+  for (const pull of pulls) {
+    assert.equal(pull.searchParams.get("omitOwn"), "true");
+    assert.equal(pull.searchParams.get("clientId"), "client-1");
+  }
+  assert.equal(pulls[1].searchParams.get("since"), "2");
+  assert.equal(getState().lastServerSeq, 2);
+});
+
+test("SyncEngine restores the bootstrap that comes with a forced resync", async () => {
+  const { storage, getState, getOutbox } = createStorage();
+  const received: SyncOp[] = [];
+  const snapshots: string[] = [];
+  const requested: string[] = [];
+  const fetchFn = async (url: string) => {
+    requested.push(new URL(url).pathname);
+    if (url.includes("/sync/pull")) {
+      return new Response(JSON.stringify({ serverSeq: 7, datasetGenerationKey: "dataset-2", ops: [] }), { status: 200 });
+    }
+    return new Response(
+      JSON.stringify({
+        code: "forced-resync",
+        datasetGenerationKey: "dataset-2",
+        snapshot: '{"data":{"lists":[]}}',
+        ops: [{ scope: "list", resourceId: "list-1", actor: "actor-2", clock: 1, payload: {} }],
+        serverSeq: 7,
+        lineage: "divergent",
+        conflict: { action: "resync", conflicts: 5, stuck: true },
+      }),
+      { status: 409 }
+    );
+  };
+  const engine = new SyncEngine({
+    storage,
+    baseUrl: "http://localhost:8080",
+    fetchFn,
+    clientId: "client-1",
+    onRemoteOps: async (ops) => {
+      received.push(...ops);
+    },
+    onSnapshot: async ({ snapshot }) => {
+      snapshots.push(snapshot);
+    },
+  });
+  await engine.initialize();
+  engine.enqueueOps("list", "list-1", [
+    { type: "insert", actor: "actor-1", clock: 1, itemId: "item-1" } as any,
+  ]);
+  await engine.syncOnce();
+
+  // The push's answer restores everything, so the pull continues from it.
+  assert.deepEqual(requested, ["/sync/push", "/sync/pull"]);
+  assert.deepEqual(snapshots, ['{"data":{"lists":[]}}']);
+  assert.equal(received.length, 1);
+  assert.equal(getState().datasetGenerationKey, "dataset-2");
+  assert.equal(getState().lastServerSeq, 7);
+  assert.equal(getOutbox().length, 0);
+});
+
+test("SyncEngine announces protocol 2 and reports archived lists", async () => {
+  const { storage } = createStorage();
+  const requests: Array<{ url: string; version: string | null }> = [];
+  const archived: string[][] = [];
+  const fetchFn = async (url: string, init?: RequestInit) => {
+    requests.push({ url, version: new Headers(init?.headers).get("X-Sync-Protocol-Version") });
+    if (url.includes("/sync/pull")) {
+      return new Response(
+        JSON.stringify({ serverSeq: 2, datasetGenerationKey: "dataset-1", ops: [], archivedLists: ["list-2"] }),
+        { status: 200 }
+      );
+    }
+    return new Response("", { status: 404 });
+  };
+  const engine = new SyncEngine({
+    storage,
+    baseUrl: "http://localhost:8080",
+    fetchFn,
+    clientId: "client-1",
+    includeArchived: true,
+    onArchivedLists: (listIds) => {
+      archived.push(listIds);
+    },
+  });
+  await engine.initialize();
+  await engine.syncOnce();
+
+  assert.equal(requests.length, 1);
+  assert.equal(requests[0].version, "2");
+  assert.ok(requests[0].url.includes("archived=include"));
+  assert.deepEqual(archived, [["list-2"]]);
+});
@@ -0,0 +1,354 @@
+// Command loadgen simulates sync clients against a running server and reports
+// request latency percentiles and serverSeq throughput.
+//
+// It targets servers running with SERVER_AUTH_MODE=dev (all clients share the
+// dev user) or, with -cookie, an authenticated session. Clients push realistic
+// op mixes (list creation, item inserts, updates, moves, removals, tags) and
+// pull after every push, re-bootstrapping on 409 so compaction runs are
+// exercised too.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// syncProtocolVersion is the X-Sync-Protocol-Version this generator speaks.
+const syncProtocolVersion = "1"
+
+type options struct {
+	baseURL  string
+	clients  int
+	duration time.Duration
+	interval time.Duration
+	batch    int
+	cookie   string
+}
+
+type syncOp struct {
+	Scope     string         `json:"scope"`
+	Resource  string         `json:"resourceId"`
+	Actor     string         `json:"actor"`
+	Clock     int64          `json:"clock"`
+	Payload   map[string]any `json:"payload"`
+	ServerSeq int64          `json:"serverSeq,omitempty"`
+}
+
+type syncResponse struct {
+	DatasetGenerationKey string   `json:"datasetGenerationKey"`
+	ServerSeq            int64    `json:"serverSeq"`
+	Ops                  []syncOp `json:"ops"`
+}
+
+type recorder struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	errors    map[string]int
+	conflicts int
+	maxSeq    int64
+	minSeq    int64
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		latencies: make(map[string][]time.Duration),
+		errors:    make(map[string]int),
+		minSeq:    -1,
+	}
+}
+
+func (r *recorder) observe(kind string, took time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies[kind] = append(r.latencies[kind], took)
+	if err != nil {
+		r.errors[kind]++
+	}
+}
+
+func (r *recorder) seq(serverSeq int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.minSeq < 0 || serverSeq < r.minSeq {
+		r.minSeq = serverSeq
+	}
+	if serverSeq > r.maxSeq {
+		r.maxSeq = serverSeq
+	}
+}
+
+func (r *recorder) conflict() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conflicts++
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.baseURL, "url", "http://localhost:8080", "server base URL")
+	flag.IntVar(&opts.clients, "clients", 10, "number of simulated clients")
+	flag.DurationVar(&opts.duration, "duration", 30*time.Second, "test duration")
+	flag.DurationVar(&opts.interval, "interval", 500*time.Millisecond, "pause between push/pull rounds per client")
+	flag.IntVar(&opts.batch, "batch", 5, "ops per push")
+	flag.StringVar(&opts.cookie, "cookie", "", "Cookie header to send (for OIDC-protected servers)")
+	flag.Parse()
+	if opts.clients <= 0 || opts.batch <= 0 {
+		log.Fatalf("clients and batch must be positive")
+	}
+	opts.baseURL = strings.TrimRight(opts.baseURL, "/")
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	ctx, cancelTimeout := context.WithTimeout(ctx, opts.duration)
+	defer cancelTimeout()
+
+	rec := newRecorder()
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	started := time.Now()
+	var wg sync.WaitGroup
+	for i := range opts.clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := &client{
+				opts:   opts,
+				http:   httpClient,
+				rec:    rec,
+				id:     fmt.Sprintf("loadgen-%d-%s", i, uuid.NewString()[:8]),
+				actor:  "loadgen-actor-" + uuid.NewString(),
+				random: rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), uint64(i))),
+			}
+			c.run(ctx)
+		}()
+	}
+	wg.Wait()
+	report(os.Stdout, rec, time.Since(started))
+}
+
+type client struct {
+	opts   options
+	http   *http.Client
+	rec    *recorder
+	id     string
+	actor  string
+	random *rand.Rand
+
+	datasetGenerationKey string
+	serverSeq            int64
+	clock                int64
+	lists                []string
+	items                map[string][]string
+}
+
+func (c *client) run(ctx context.Context) {
+	if err := c.bootstrap(ctx); err != nil {
+		log.Printf("client %s bootstrap failed: %v", c.id, err)
+		return
+	}
+	ticker := time.NewTicker(c.opts.interval)
+	defer ticker.Stop()
+	for {
+		if err := c.round(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("client %s: %v", c.id, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *client) bootstrap(ctx context.Context) error {
+	var resp syncResponse
+	status, err := c.do(ctx, "bootstrap", http.MethodGet, "/sync/bootstrap", nil, &resp)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("bootstrap status %d", status)
+	}
+	c.datasetGenerationKey = resp.DatasetGenerationKey
+	c.serverSeq = resp.ServerSeq
+	c.lists = nil
+	c.items = make(map[string][]string)
+	c.rec.seq(resp.ServerSeq)
+	return nil
+}
+
+func (c *client) round(ctx context.Context) error {
+	ops := make([]syncOp, 0, c.opts.batch)
+	for range c.opts.batch {
+		ops = append(ops, c.nextOp())
+	}
+	body, err := json.Marshal(map[string]any{
+		"clientId":             c.id,
+		"datasetGenerationKey": c.datasetGenerationKey,
+		"ops":                  ops,
+	})
+	if err != nil {
+		return err
+	}
+	var pushResp syncResponse
+	status, err := c.do(ctx, "push", http.MethodPost, "/sync/push", body, &pushResp)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusConflict {
+		c.rec.conflict()
+		return c.bootstrap(ctx)
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("push status %d", status)
+	}
+	c.rec.seq(pushResp.ServerSeq)
+
+	path := "/sync/pull?since=" + strconv.FormatInt(c.serverSeq, 10) + "&clientId=" + c.id + "&datasetGenerationKey=" + c.datasetGenerationKey
+	var pullResp syncResponse
+	status, err = c.do(ctx, "pull", http.MethodGet, path, nil, &pullResp)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusConflict {
+		c.rec.conflict()
+		return c.bootstrap(ctx)
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("pull status %d", status)
+	}
+	c.serverSeq = pullResp.ServerSeq
+	c.rec.seq(pullResp.ServerSeq)
+	return nil
+}
+
+// nextOp picks an op roughly matching interactive usage: mostly item inserts
+// and toggles, some moves, tags, and removals, and occasionally a new list.
+func (c *client) nextOp() syncOp {
+	c.clock++
+	pos := []map[string]any{{"digit": c.random.IntN(1024), "actor": c.actor}}
+	if len(c.lists) == 0 || c.random.IntN(50) == 0 {
+		listID := "list-" + uuid.NewString()
+		c.lists = append(c.lists, listID)
+		return syncOp{Scope: "registry", Resource: "registry", Actor: c.actor, Clock: c.clock, Payload: map[string]any{
+			"type": "createList", "listId": listID, "payload": map[string]any{"title": "List " + listID[5:13], "pos": pos},
+		}}
+	}
+	listID := c.lists[c.random.IntN(len(c.lists))]
+	items := c.items[listID]
+	roll := c.random.IntN(100)
+	if len(items) == 0 || roll < 45 {
+		itemID := "item-" + uuid.NewString()
+		c.items[listID] = append(items, itemID)
+		return c.listOp(listID, "insert", itemID, map[string]any{
+			"data": map[string]any{"text": "Task " + itemID[5:13], "done": false, "note": ""},
+			"pos":  pos,
+		})
+	}
+	index := c.random.IntN(len(items))
+	itemID := items[index]
+	switch {
+	case roll < 75:
+		return c.listOp(listID, "update", itemID, map[string]any{"data": map[string]any{"done": c.random.IntN(2) == 0}})
+	case roll < 85:
+		return c.listOp(listID, "move", itemID, map[string]any{"pos": pos})
+	case roll < 92:
+		return c.listOp(listID, "addTag", itemID, map[string]any{"tag": []string{"home", "work", "errands"}[c.random.IntN(3)]})
+	default:
+		c.items[listID] = slices.Delete(items, index, index+1)
+		return c.listOp(listID, "remove", itemID, nil)
+	}
+}
+
+func (c *client) listOp(listID, opType, itemID string, payload map[string]any) syncOp {
+	body := map[string]any{"type": opType, "itemId": itemID}
+	if payload != nil {
+		body["payload"] = payload
+	}
+	return syncOp{Scope: "list", Resource: listID, Actor: c.actor, Clock: c.clock, Payload: body}
+}
+
+func (c *client) do(ctx context.Context, kind, method, path string, body []byte, target any) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.opts.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("X-Sync-Protocol-Version", syncProtocolVersion)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.opts.cookie != "" {
+		req.Header.Set("Cookie", c.opts.cookie)
+	}
+	started := time.Now()
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.rec.observe(kind, time.Since(started), err)
+		}
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := io.ReadAll(resp.Body)
+	took := time.Since(started)
+	if err == nil && resp.StatusCode >= 500 {
+		err = fmt.Errorf("%s status %d", kind, resp.StatusCode)
+	}
+	c.rec.observe(kind, took, err)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode == http.StatusOK && target != nil {
+		if err := json.Unmarshal(data, target); err != nil {
+			return resp.StatusCode, fmt.Errorf("decode %s: %w", kind, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+func report(out io.Writer, rec *recorder, elapsed time.Duration) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	kinds := make([]string, 0, len(rec.latencies))
+	for kind := range rec.latencies {
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+	_, _ = fmt.Fprintf(out, "elapsed %s\n", elapsed.Round(time.Millisecond))
+	_, _ = fmt.Fprintf(out, "%-10s %8s %8s %10s %10s %10s %10s\n", "request", "count", "errors", "p50", "p90", "p99", "max")
+	for _, kind := range kinds {
+		latencies := rec.latencies[kind]
+		slices.Sort(latencies)
+		_, _ = fmt.Fprintf(out, "%-10s %8d %8d %10s %10s %10s %10s\n", kind, len(latencies), rec.errors[kind],
+			percentile(latencies, 0.50), percentile(latencies, 0.90), percentile(latencies, 0.99), percentile(latencies, 1))
+	}
+	_, _ = fmt.Fprintf(out, "conflicts (409) %d\n", rec.conflicts)
+	if rec.minSeq >= 0 && elapsed > 0 {
+		advanced := rec.maxSeq - rec.minSeq
+		_, _ = fmt.Fprintf(out, "serverSeq %d -> %d (%.1f/s)\n", rec.minSeq, rec.maxSeq, float64(advanced)/elapsed.Seconds())
+	}
+}
+
+// percentile expects sorted input.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := int(float64(len(sorted)-1) * p)
+	return sorted[index].Round(10 * time.Microsecond)
+}
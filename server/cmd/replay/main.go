@@ -0,0 +1,132 @@
+// Command replay feeds a sync traffic recording (made with
+// SERVER_TRAFFIC_RECORD_PATH) to a fresh server built from this tree and
+// reports the exchanges whose status differs from the recorded one, so
+// protocol changes can be checked against real-world workloads.
+//
+// The server runs in-process with an empty in-memory store, or with -db a new
+// SQLite database, and every recorded user gets their own dataset. It exits
+// with status 1 when any exchange mismatched.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"a4-tasklists/server/internal/compaction"
+	"a4-tasklists/server/internal/httpapi"
+	"a4-tasklists/server/internal/storage"
+	"a4-tasklists/server/internal/traffic"
+)
+
+type options struct {
+	dbPath     string
+	speed      float64
+	maxOps     int64
+	maxOpBytes int64
+	examples   int
+	json       bool
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.dbPath, "db", "", "replay into a new SQLite database at this path instead of memory")
+	flag.Float64Var(&opts.speed, "speed", 0, "pacing relative to the recording (1 = real time); 0 replays as fast as possible")
+	flag.Int64Var(&opts.maxOps, "snapshot-max-ops", 0, "auto-compaction op threshold of the replay server (0 disables)")
+	flag.Int64Var(&opts.maxOpBytes, "snapshot-max-op-bytes", 0, "auto-compaction op byte threshold of the replay server (0 disables)")
+	flag.IntVar(&opts.examples, "examples", 20, "mismatches to list")
+	flag.BoolVar(&opts.json, "json", false, "print the report as JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: replay [flags] recording.jsonl\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if !replay(flag.Arg(0), opts) {
+		os.Exit(1)
+	}
+}
+
+// replay runs the recording at path and reports whether every status
+// matched.
+func replay(path string, opts options) bool {
+	recording, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("recording: %v", err)
+	}
+	defer func() { _ = recording.Close() }()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	store, err := openStore(ctx, opts.dbPath)
+	if err != nil {
+		log.Fatalf("storage error: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	compactor := compaction.New(store, compaction.Thresholds{MaxOps: opts.maxOps, MaxOpBytes: opts.maxOpBytes})
+	defer compactor.Wait()
+	mux := http.NewServeMux()
+	httpapi.NewServerWithConfig(store, httpapi.Config{Compaction: compactor}).RegisterRoutes(mux)
+
+	report, err := traffic.Replay(ctx, mux, traffic.Read(recording), traffic.ReplayOptions{Speed: opts.speed, MaxExamples: opts.examples})
+	if err != nil {
+		log.Fatalf("replay: %v", err)
+	}
+	if opts.json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(report)
+	} else {
+		printReport(os.Stdout, report)
+	}
+	return report.Mismatches == 0
+}
+
+// openStore returns an empty store, so the replay never mixes with existing
+// data.
+func openStore(ctx context.Context, dbPath string) (storage.Store, error) {
+	var store storage.Store = storage.NewMemoryStore()
+	if dbPath != "" {
+		if _, err := os.Stat(dbPath); err == nil {
+			return nil, fmt.Errorf("%s already exists; replays need a new database", dbPath)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		sqliteStore, err := storage.OpenSQLite(dbPath)
+		if err != nil {
+			return nil, err
+		}
+		store = sqliteStore
+	}
+	if err := store.Init(ctx); err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func printReport(w io.Writer, report traffic.Report) {
+	fmt.Fprintf(w, "replayed %d exchanges (%d push, %d pull) in %s\n",
+		report.Exchanges, report.Kinds[traffic.KindPush], report.Kinds[traffic.KindPull], report.Duration.Round(1e6))
+	fmt.Fprintf(w, "pulled ops: recorded %d, replayed %d\n", report.RecordedPulledOps, report.ReplayedPulledOps)
+	if report.Mismatches == 0 {
+		fmt.Fprintf(w, "all statuses match\n")
+		return
+	}
+	fmt.Fprintf(w, "%d status mismatches:\n", report.Mismatches)
+	for _, mismatch := range report.Examples {
+		fmt.Fprintf(w, "  #%d %s user=%s client=%s recorded=%d replayed=%d\n",
+			mismatch.Index, mismatch.Kind, mismatch.User, mismatch.Client, mismatch.Recorded, mismatch.Replayed)
+	}
+}
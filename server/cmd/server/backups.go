@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"a4-tasklists/server/internal/backup"
+	"a4-tasklists/server/internal/blobstore"
+	"a4-tasklists/server/internal/storage"
+)
+
+// defaultBackupSchedule writes a backup every night at 03:00 server time.
+const defaultBackupSchedule = "0 3 * * *"
+
+// defaultBackupKeep is how many backups are kept without SERVER_BACKUP_KEEP.
+const defaultBackupKeep = 7
+
+// databasePath returns SERVER_DB_PATH or its default.
+func databasePath() string {
+	if dbPath := os.Getenv("SERVER_DB_PATH"); dbPath != "" {
+		return dbPath
+	}
+	return "data.db"
+}
+
+// restoreStageDir is where a restore requested through /admin/backups waits
+// for the next start.
+func restoreStageDir() string {
+	return databasePath() + ".restore"
+}
+
+// applyStagedRestore puts a staged backup in place before the database is
+// opened.
+func applyStagedRestore() error {
+	restored, ok, err := backup.ApplyStaged(restoreStageDir(), databasePath(), os.Getenv("SERVER_DB_USER_DIR"))
+	if err != nil {
+		return err
+	}
+	if ok {
+		log.Printf("restored backup id=%s created_at=%s files=%d", restored.ID, time.Unix(restored.CreatedAt, 0).UTC().Format(time.RFC3339), len(restored.Files))
+	}
+	return nil
+}
+
+// backupDestination returns the store backups are written to, or nil when
+// backups are not configured.
+func backupDestination() (blobstore.Store, error) {
+	dir := os.Getenv("SERVER_BACKUP_DIR")
+	endpoint := os.Getenv("SERVER_BACKUP_S3_ENDPOINT")
+	switch {
+	case dir != "" && endpoint != "":
+		return nil, errors.New("SERVER_BACKUP_DIR and SERVER_BACKUP_S3_ENDPOINT both decide where backups go; set only one")
+	case dir != "":
+		return blobstore.NewDir(dir)
+	case endpoint != "":
+		return blobstore.NewS3(blobstore.S3Config{
+			Endpoint:        endpoint,
+			Region:          os.Getenv("SERVER_BACKUP_S3_REGION"),
+			Bucket:          os.Getenv("SERVER_BACKUP_S3_BUCKET"),
+			AccessKeyID:     os.Getenv("SERVER_BACKUP_S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("SERVER_BACKUP_S3_SECRET_ACCESS_KEY"),
+			// Backups are uploaded in one request and can be large.
+			HTTPClient: &http.Client{Timeout: 30 * time.Minute},
+		})
+	default:
+		return nil, nil
+	}
+}
+
+// backupSchedule parses SERVER_BACKUP_SCHEDULE. "off" leaves only backups
+// started through /admin/backups and returns nil.
+func backupSchedule() (*backup.Schedule, error) {
+	expr := strings.TrimSpace(os.Getenv("SERVER_BACKUP_SCHEDULE"))
+	if expr == "" {
+		expr = defaultBackupSchedule
+	}
+	if expr == "off" {
+		return nil, nil
+	}
+	return backup.ParseSchedule(expr)
+}
+
+// backupEncryption parses SERVER_BACKUP_AGE_RECIPIENTS and reads
+// SERVER_BACKUP_AGE_IDENTITY_FILE. The identity is optional; without it
+// encrypted backups can neither be fully verified nor restored here.
+func backupEncryption() ([]backup.AgeRecipient, *backup.AgeIdentity, error) {
+	var recipients []backup.AgeRecipient
+	for _, value := range envList("SERVER_BACKUP_AGE_RECIPIENTS") {
+		recipient, err := backup.ParseAgeRecipient(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("SERVER_BACKUP_AGE_RECIPIENTS: %w", err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	path := os.Getenv("SERVER_BACKUP_AGE_IDENTITY_FILE")
+	if path == "" {
+		return recipients, nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("SERVER_BACKUP_AGE_IDENTITY_FILE: %w", err)
+	}
+	identity, err := backup.ParseAgeIdentity(string(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("SERVER_BACKUP_AGE_IDENTITY_FILE: %w", err)
+	}
+	if len(recipients) > 0 && !slices.ContainsFunc(recipients, func(r backup.AgeRecipient) bool { return r.String() == identity.Recipient().String() }) {
+		return nil, nil, errors.New("SERVER_BACKUP_AGE_IDENTITY_FILE does not belong to any of SERVER_BACKUP_AGE_RECIPIENTS")
+	}
+	return recipients, &identity, nil
+}
+
+// newBackupManager configures backups of store from the environment. It
+// returns nil when no destination is set.
+func newBackupManager(store storage.Store) (*backup.Manager, error) {
+	destination, err := backupDestination()
+	if err != nil || destination == nil {
+		return nil, err
+	}
+	schedule, err := backupSchedule()
+	if err != nil {
+		return nil, fmt.Errorf("SERVER_BACKUP_SCHEDULE: %w", err)
+	}
+	recipients, identity, err := backupEncryption()
+	if err != nil {
+		return nil, err
+	}
+	if unwrapper, ok := store.(interface{ Unwrap() storage.Store }); ok {
+		store = unwrapper.Unwrap()
+	}
+	source, ok := store.(backup.Source)
+	if !ok {
+		return nil, errors.New("backups need a SQLite database; they are not available in demo mode")
+	}
+	return backup.New(backup.Config{
+		Source:      source,
+		Destination: destination,
+		Schedule:    schedule,
+		Retention: backup.Retention{
+			Keep:   int(envInt64Default("SERVER_BACKUP_KEEP", defaultBackupKeep)),
+			MaxAge: time.Duration(envInt64Default("SERVER_BACKUP_MAX_AGE_DAYS", 0)) * 24 * time.Hour,
+		},
+		StageDir:   restoreStageDir(),
+		Recipients: recipients,
+		Identity:   identity,
+	}), nil
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"a4-tasklists/server/internal/oidctest"
+	"a4-tasklists/server/internal/storage"
+)
+
+// The end-to-end tests run the server as main assembles it, with OIDC login
+// against a test identity provider, and talk to it over real HTTP the way the
+// app does: one cookie jar per device.
+
+const e2eIndex = "<!doctype html><title>tasklists</title>"
+
+// startE2EServer starts the full server with OIDC login and returns its URL.
+func startE2EServer(t *testing.T) string {
+	t.Helper()
+	_, serverURL := startE2EApp(t)
+	return serverURL
+}
+
+// startE2EApp is startE2EServer that also returns the application, for
+// tests that serve its admin listener.
+func startE2EApp(t *testing.T) (*application, string) {
+	t.Helper()
+	idp := oidctest.New(t, "tasklists", "secret")
+	staticDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(staticDir, "index.html"), []byte(e2eIndex), 0o644); err != nil {
+		t.Fatalf("write index: %v", err)
+	}
+	server := httptest.NewUnstartedServer(nil)
+	serverURL := "http://" + server.Listener.Addr().String()
+	t.Setenv("SERVER_AUTH_MODE", "")
+	t.Setenv("OIDC_ISSUER_URL", idp.URL)
+	t.Setenv("OIDC_CLIENT_ID", idp.ClientID)
+	t.Setenv("OIDC_CLIENT_SECRET", idp.ClientSecret)
+	t.Setenv("OIDC_REDIRECT_URL", serverURL+"/auth/callback")
+	t.Setenv("SERVER_COOKIE_SECURE", "false")
+	t.Setenv("SERVER_STATIC_DIR", staticDir)
+
+	store := storage.NewMemoryStore()
+	reloader, err := newConfigReloader("")
+	if err != nil {
+		t.Fatalf("config reloader: %v", err)
+	}
+	app, err := newApp(store, reloader)
+	if err != nil {
+		t.Fatalf("new app: %v", err)
+	}
+	server.Config.Handler = app.handler
+	server.Start()
+	t.Cleanup(func() {
+		server.Close()
+		app.compactor.Wait()
+	})
+	return app, serverURL
+}
+
+// device is one signed-in app instance.
+type device struct {
+	t         *testing.T
+	serverURL string
+	client    *http.Client
+	clientID  string
+
+	generation string
+	serverSeq  int64
+}
+
+// newDevice returns a device without a session. Redirects are not followed
+// so the login flow can be checked step by step.
+func newDevice(t *testing.T, serverURL, clientID string) *device {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("cookie jar: %v", err)
+	}
+	return &device{t: t, serverURL: serverURL, clientID: clientID, client: &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+// login signs user in through the identity provider and bootstraps.
+func login(t *testing.T, serverURL, user, clientID string) *device {
+	t.Helper()
+	d := newDevice(t, serverURL, clientID)
+	resp := d.get(serverURL + "/")
+	authorizeURL := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusFound || !strings.Contains(authorizeURL, "/authorize?") {
+		t.Fatalf("expected redirect to the identity provider, got %d %q", resp.StatusCode, authorizeURL)
+	}
+	resp = d.get(authorizeURL + "&login_hint=" + url.QueryEscape(user))
+	callbackURL := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusFound || !strings.HasPrefix(callbackURL, serverURL+"/auth/callback?") {
+		t.Fatalf("expected redirect to the callback, got %d %q", resp.StatusCode, callbackURL)
+	}
+	resp = d.get(callbackURL)
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/" {
+		t.Fatalf("expected callback to return to the app, got %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	resp = d.get(serverURL + "/")
+	if body, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(body) != e2eIndex {
+		t.Fatalf("expected the app after login, got %d %q", resp.StatusCode, body)
+	}
+	d.bootstrap()
+	return d
+}
+
+func (d *device) get(rawURL string) *http.Response {
+	d.t.Helper()
+	resp, err := d.client.Get(rawURL)
+	if err != nil {
+		d.t.Fatalf("GET %s: %v", rawURL, err)
+	}
+	d.t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+// do sends a same-origin API request and decodes the JSON response into out.
+func (d *device) do(method, path string, body any, out any) int {
+	d.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			d.t.Fatalf("encode %s: %v", path, err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, d.serverURL+path, reader)
+	if err != nil {
+		d.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	req.Header.Set("Origin", d.serverURL)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		d.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			d.t.Fatalf("decode %s %s (status %d): %v", method, path, resp.StatusCode, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func (d *device) bootstrap() {
+	d.t.Helper()
+	var payload struct {
+		DatasetGenerationKey string `json:"datasetGenerationKey"`
+		ServerSeq            int64  `json:"serverSeq"`
+	}
+	if status := d.do(http.MethodGet, "/sync/bootstrap", nil, &payload); status != http.StatusOK {
+		d.t.Fatalf("bootstrap status: got %d", status)
+	}
+	d.generation, d.serverSeq = payload.DatasetGenerationKey, payload.ServerSeq
+}
+
+func (d *device) push(actor string, clock int64, itemID string) int {
+	d.t.Helper()
+	return d.do(http.MethodPost, "/sync/push", map[string]any{
+		"clientId":             d.clientID,
+		"datasetGenerationKey": d.generation,
+		"ops": []map[string]any{{
+			"scope":      "list",
+			"resourceId": "list-1",
+			"actor":      actor,
+			"clock":      clock,
+			"payload":    map[string]any{"type": "insert", "itemId": itemID},
+		}},
+	}, nil)
+}
+
+// pull fetches ops since the device's cursor and advances it.
+func (d *device) pull() (int, []storage.Op) {
+	d.t.Helper()
+	var payload struct {
+		ServerSeq int64        `json:"serverSeq"`
+		Ops       []storage.Op `json:"ops"`
+	}
+	query := url.Values{
+		"since":                {strconv.FormatInt(d.serverSeq, 10)},
+		"clientId":             {d.clientID},
+		"datasetGenerationKey": {d.generation},
+	}
+	status := d.do(http.MethodGet, "/sync/pull?"+query.Encode(), nil, &payload)
+	if status == http.StatusOK {
+		d.serverSeq = payload.ServerSeq
+	}
+	return status, payload.Ops
+}
+
+func TestE2ELoginEstablishesSession(t *testing.T) {
+	serverURL := startE2EServer(t)
+
+	anonymous := newDevice(t, serverURL, "anonymous")
+	if status := anonymous.do(http.MethodGet, "/sync/bootstrap", nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("sync without session: got %d", status)
+	}
+
+	alice := login(t, serverURL, "alice", "laptop")
+	var me struct {
+		UserID string `json:"userId"`
+		Email  string `json:"email"`
+	}
+	if status := alice.do(http.MethodGet, "/me", nil, &me); status != http.StatusOK || me.UserID != "alice" || me.Email != "alice@example.com" {
+		t.Fatalf("unexpected /me: %d %+v", status, me)
+	}
+
+	// Unsafe requests with the session cookie must come from the app's origin.
+	req, _ := http.NewRequest(http.MethodPost, serverURL+"/sync/push", strings.NewReader(`{}`))
+	req.Header.Set("Origin", "http://evil.example")
+	resp, err := alice.client.Do(req)
+	if err != nil {
+		t.Fatalf("cross-site push: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("cross-site push: got %d", resp.StatusCode)
+	}
+
+	if status := alice.do(http.MethodPost, "/auth/logout", nil, nil); status != http.StatusFound {
+		t.Fatalf("logout status: got %d", status)
+	}
+	if status := alice.do(http.MethodGet, "/sync/bootstrap", nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("sync after logout: got %d", status)
+	}
+}
+
+func TestE2EMultiClientSyncIsolatesUsers(t *testing.T) {
+	serverURL := startE2EServer(t)
+	laptop := login(t, serverURL, "alice", "laptop")
+	phone := login(t, serverURL, "alice", "phone")
+	bob := login(t, serverURL, "bob", "desktop")
+
+	if status := laptop.push("alice-laptop", 1, "item-1"); status != http.StatusOK {
+		t.Fatalf("laptop push: got %d", status)
+	}
+	if status := phone.push("alice-phone", 1, "item-2"); status != http.StatusOK {
+		t.Fatalf("phone push: got %d", status)
+	}
+	for _, d := range []*device{laptop, phone} {
+		status, ops := d.pull()
+		if status != http.StatusOK || len(ops) != 2 {
+			t.Fatalf("%s pull: got %d with %d ops", d.clientID, status, len(ops))
+		}
+	}
+
+	if status, ops := bob.pull(); status != http.StatusOK || len(ops) != 0 {
+		t.Fatalf("bob sees another user's ops: %d %+v", status, ops)
+	}
+	// Actors are bound to the user that first pushed them, so another user
+	// cannot write ops in their name.
+	if status := bob.push("alice-laptop", 2, "item-3"); status != http.StatusForbidden {
+		t.Fatalf("bob pushing alice's actor: got %d", status)
+	}
+	if status := bob.push("bob-desktop", 1, "item-3"); status != http.StatusOK {
+		t.Fatalf("bob push: got %d", status)
+	}
+	if status, ops := laptop.pull(); status != http.StatusOK || len(ops) != 0 {
+		t.Fatalf("alice sees bob's ops: %d %+v", status, ops)
+	}
+}
+
+func TestE2EResetMovesOtherClientsToNewGeneration(t *testing.T) {
+	serverURL := startE2EServer(t)
+	laptop := login(t, serverURL, "alice", "laptop")
+	phone := login(t, serverURL, "alice", "phone")
+	if status := laptop.push("alice-laptop", 1, "item-1"); status != http.StatusOK {
+		t.Fatalf("laptop push: got %d", status)
+	}
+
+	snapshot := `{"schema":"net.aggregat4.tasklist.snapshot@v1","data":{"lists":[]}}`
+	status := laptop.do(http.MethodPost, "/sync/reset", map[string]any{
+		"clientId":                             laptop.clientID,
+		"datasetGenerationKey":                 "generation-2",
+		"snapshot":                             snapshot,
+		"expectedPreviousDatasetGenerationKey": laptop.generation,
+	}, nil)
+	if status != http.StatusOK {
+		t.Fatalf("reset status: got %d", status)
+	}
+	laptop.generation, laptop.serverSeq = "generation-2", 0
+
+	if status := phone.push("alice-phone", 1, "item-2"); status != http.StatusConflict {
+		t.Fatalf("push to the replaced generation: got %d", status)
+	}
+	if status, _ := phone.pull(); status != http.StatusConflict {
+		t.Fatalf("pull of the replaced generation: got %d", status)
+	}
+	phone.bootstrap()
+	if phone.generation != "generation-2" {
+		t.Fatalf("phone bootstrapped generation %q", phone.generation)
+	}
+	if status := phone.push("alice-phone", 1, "item-2"); status != http.StatusOK {
+		t.Fatalf("phone push after bootstrap: got %d", status)
+	}
+	if status, ops := laptop.pull(); status != http.StatusOK || len(ops) != 1 || ops[0].Actor != "alice-phone" {
+		t.Fatalf("laptop pull after reset: %d %+v", status, ops)
+	}
+}
+
+func TestE2EAdminAPIRequiresAnAdminUser(t *testing.T) {
+	t.Setenv("SERVER_ADMIN_LISTEN_ADDRS", "")
+	t.Setenv("SERVER_ADMIN_USERS", "alice")
+	serverURL := startE2EServer(t)
+
+	// The test server is reached over loopback, which the network filter
+	// admits, as it would behind a reverse proxy on the same host.
+	anonymous := newDevice(t, serverURL, "anonymous")
+	if status := anonymous.do(http.MethodGet, "/admin/users", nil, nil); status != http.StatusFound {
+		t.Fatalf("expected the admin API to redirect to the login, got %d", status)
+	}
+	bob := login(t, serverURL, "bob", "desktop")
+	if status := bob.do(http.MethodGet, "/admin/users", nil, nil); status != http.StatusForbidden {
+		t.Fatalf("admin API for a user who is not an admin: got %d", status)
+	}
+	alice := login(t, serverURL, "alice", "laptop")
+	if status := alice.do(http.MethodGet, "/admin/users", nil, nil); status != http.StatusOK {
+		t.Fatalf("admin API for an admin: got %d", status)
+	}
+}
+
+func TestE2EAdminAPIRejectsCrossOriginWrites(t *testing.T) {
+	t.Setenv("SERVER_ADMIN_LISTEN_ADDRS", "")
+	t.Setenv("SERVER_ADMIN_USERS", "alice")
+	serverURL := startE2EServer(t)
+	alice := login(t, serverURL, "alice", "laptop")
+	req, err := http.NewRequest(http.MethodPost, serverURL+"/admin/settings", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("Origin", "https://evil.example.net")
+	resp, err := alice.client.Do(req)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected a cross-origin write with the admin's session to be rejected, got %d", resp.StatusCode)
+	}
+
+	// Admin listeners need no session, so a page in the operator's browser
+	// must not reach them either.
+	t.Setenv("SERVER_ADMIN_LISTEN_ADDRS", "127.0.0.1:0")
+	app, _ := startE2EApp(t)
+	admin := httptest.NewServer(app.adminHandler)
+	t.Cleanup(admin.Close)
+	post := func(header http.Header) int {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, admin.URL+"/admin/settings", nil)
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		req.Header = header
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := post(http.Header{"Origin": {"https://evil.example.net"}, "Sec-Fetch-Site": {"cross-site"}}); status != http.StatusForbidden {
+		t.Fatalf("expected a cross-origin write to the admin listener to be rejected, got %d", status)
+	}
+	if status := post(http.Header{}); status != http.StatusMethodNotAllowed {
+		t.Fatalf("expected a write from a script to reach the admin API, got %d", status)
+	}
+}
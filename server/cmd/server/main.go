@@ -1,41 +1,83 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"embed"
+	"errors"
+	"flag"
+	"fmt"
 	"io"
 	"io/fs"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"a4-tasklists/server/internal/auth"
+	"a4-tasklists/server/internal/blobstore"
+	"a4-tasklists/server/internal/chaos"
+	"a4-tasklists/server/internal/compaction"
+	"a4-tasklists/server/internal/digest"
+	"a4-tasklists/server/internal/export"
+	"a4-tasklists/server/internal/features"
+	"a4-tasklists/server/internal/fleet"
 	"a4-tasklists/server/internal/httpapi"
+	"a4-tasklists/server/internal/i18n"
+	"a4-tasklists/server/internal/integrity"
+	"a4-tasklists/server/internal/ipfilter"
+	"a4-tasklists/server/internal/limiter"
+	"a4-tasklists/server/internal/mail"
+	"a4-tasklists/server/internal/notes"
+	"a4-tasklists/server/internal/outbox"
+	"a4-tasklists/server/internal/quarantine"
+	"a4-tasklists/server/internal/recovery"
+	"a4-tasklists/server/internal/sentry"
+	"a4-tasklists/server/internal/signup"
+	"a4-tasklists/server/internal/slo"
+	"a4-tasklists/server/internal/spool"
+	"a4-tasklists/server/internal/stats"
 	"a4-tasklists/server/internal/storage"
-
-	baselibmiddleware "github.com/aggregat4/go-baselib-services/v4/middleware"
+	"a4-tasklists/server/internal/traffic"
 )
 
 //go:embed all:static
 var staticFS embed.FS
 
 func main() {
+	demo := flag.Bool("demo", false, "keep all data in memory (nothing is persisted)")
+	check := flag.Bool("check", false, "validate configuration, database, OIDC discovery and static files, print a report and exit")
+	flag.BoolVar(check, "selftest", false, "alias for -check")
+	flag.Parse()
+
+	reloader, err := newConfigReloader(os.Getenv("SERVER_CONFIG_FILE"))
+	if err != nil {
+		log.Fatalf("SERVER_CONFIG_FILE: %v", err)
+	}
+
 	addr := ":8080"
 	if port := os.Getenv("PORT"); port != "" {
 		addr = ":" + port
 	}
 
-	dbPath := os.Getenv("SERVER_DB_PATH")
-	if dbPath == "" {
-		dbPath = "data.db"
+	if *check {
+		if !runSelfTest(context.Background(), os.Stdout, *demo) {
+			os.Exit(1)
+		}
+		return
 	}
-	if err := ensureParentDir(dbPath); err != nil {
-		log.Fatalf("db path error: %v", err)
+
+	if !*demo {
+		if err := applyStagedRestore(); err != nil {
+			log.Fatalf("restore error: %v", err)
+		}
 	}
-	store, err := storage.OpenSQLite(dbPath)
+	store, err := openStore(*demo)
 	if err != nil {
 		log.Fatalf("storage error: %v", err)
 	}
@@ -48,45 +90,102 @@ func main() {
 	if err := store.Init(context.Background()); err != nil {
 		log.Fatalf("storage init error: %v", err)
 	}
+	if sqliteStore, ok := store.(sqliteDatabase); ok {
+		if tuning, err := sqliteStore.Tuning(context.Background()); err != nil {
+			log.Printf("sqlite tuning error: %v", err)
+		} else {
+			log.Printf("sqlite tuning cache_bytes=%d mmap_bytes=%d journal_size_limit_bytes=%d synchronous=%s temp_store=%s",
+				tuning.CacheBytes, tuning.MmapBytes, tuning.JournalSizeLimitBytes, tuning.Synchronous, tuning.TempStore)
+		}
+		if interval := envInt64Default("SERVER_SQLITE_CHECKPOINT_INTERVAL_SECONDS", 0); interval > 0 {
+			go runCheckpoints(sqliteStore, time.Duration(interval)*time.Second)
+		}
+	}
+	store = storage.NewRetryingStore(store, storage.RetryPolicy{
+		Attempts:         int(envInt64Default("SERVER_STORAGE_RETRY_ATTEMPTS", 3)),
+		BreakerThreshold: int(envInt64Default("SERVER_STORAGE_BREAKER_THRESHOLD", 20)),
+		BreakerCooldown:  time.Duration(envInt64Default("SERVER_STORAGE_BREAKER_COOLDOWN_MS", 5000)) * time.Millisecond,
+	})
 
-	issuerURL := os.Getenv("OIDC_ISSUER_URL")
-	clientID := os.Getenv("OIDC_CLIENT_ID")
-	clientSecret := os.Getenv("OIDC_CLIENT_SECRET")
-	redirectURL := os.Getenv("OIDC_REDIRECT_URL")
-	sessionKey := os.Getenv("SERVER_SESSION_KEY")
-	cookieSecure := envBoolDefault("SERVER_COOKIE_SECURE", true)
-	cookieDomain := os.Getenv("SERVER_COOKIE_DOMAIN")
-	authMode := strings.ToLower(strings.TrimSpace(os.Getenv("SERVER_AUTH_MODE")))
-	devUserID := os.Getenv("SERVER_DEV_USER_ID")
+	if err := reloader.loadStoredSettings(context.Background(), store); err != nil {
+		log.Fatalf("stored settings error: %v", err)
+	}
+	app, err := newApp(store, reloader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer app.compactor.Wait()
+	defer func() {
+		if err := app.recorder.Close(); err != nil {
+			log.Printf("error closing traffic recording: %v", err)
+		}
+	}()
+	go reloader.reloadOnSignal()
 
-	var authManager *auth.Manager
-	if authMode != "dev" {
-		if issuerURL == "" || clientID == "" || redirectURL == "" {
-			log.Fatalf("oidc config error: OIDC_ISSUER_URL, OIDC_CLIENT_ID, and OIDC_REDIRECT_URL are required unless SERVER_AUTH_MODE=dev")
-		}
-		var err error
-		authManager, err = auth.NewManager(auth.Config{
-			IssuerURL:      issuerURL,
-			ClientID:       clientID,
-			ClientSecret:   clientSecret,
-			RedirectURL:    redirectURL,
-			SessionKey:     sessionKey,
-			SessionTTL:     30 * 24 * time.Hour,
-			CookieSecure:   cookieSecure,
-			CookieSameSite: http.SameSiteLaxMode,
-			CookieDomain:   cookieDomain,
-			FallbackURL:    "/",
+	listenAddrs := envList("SERVER_LISTEN_ADDRS")
+	if len(listenAddrs) == 0 {
+		listenAddrs = []string{addr}
+	}
+	var servers []*http.Server
+	for _, listenAddr := range listenAddrs {
+		log.Printf("server listening on %s", listenAddr)
+		servers = append(servers, &http.Server{
+			Addr:              listenAddr,
+			Handler:           app.handler,
+			ReadHeaderTimeout: 5 * time.Second,
+		})
+	}
+	// Admin listeners skip login entirely but keep the network restriction,
+	// so binding one to a public address still needs SERVER_ADMIN_ALLOW_CIDRS.
+	for _, adminAddr := range app.adminAddrs {
+		log.Printf("admin listening on %s", adminAddr)
+		servers = append(servers, &http.Server{
+			Addr:              adminAddr,
+			Handler:           app.adminHandler,
+			ReadHeaderTimeout: 5 * time.Second,
 		})
-		if err != nil {
-			log.Fatalf("auth config error: %v", err)
-		}
+	}
+	if err := serveAll(servers); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("server error: %v", err)
+	}
+}
+
+// application is the assembled server: the handler for the public listeners
+// and what main needs to run and stop it.
+type application struct {
+	handler http.Handler
+	// adminHandler serves adminAddrs. It is nil without admin listeners.
+	adminHandler http.Handler
+	adminAddrs   []string
+	compactor    *compaction.Compactor
+	recorder     *traffic.Recorder
+}
+
+// newApp builds the routes, authentication and middleware around store from
+// the environment. The end-to-end tests run the server through it too, so
+// keep wiring here rather than in main.
+func newApp(store storage.Store, reloader *configReloader) (*application, error) {
+	authMode := strings.ToLower(strings.TrimSpace(os.Getenv("SERVER_AUTH_MODE")))
+	devUserID := os.Getenv("SERVER_DEV_USER_ID")
+	if authMode == "none" {
+		log.Printf("WARNING: authentication is disabled (SERVER_AUTH_MODE=none); every request reads and changes one shared dataset")
+	}
+	signupGate := signup.New(store, signupConfig())
+	authManager, err := newAuthManager(authMode, store, signupGate)
+	if err != nil {
+		return nil, fmt.Errorf("auth config error: %w", err)
 	}
 
 	mux := http.NewServeMux()
-	if authManager != nil {
+	if authMode == "passkey" {
+		mux.Handle("/auth/login", authManager.PasskeyLoginPage())
+		mux.Handle("/auth/passkey/", authManager.PasskeyHandler())
+		mux.Handle("/auth/logout", authManager.LogoutHandler())
+	} else if authManager != nil {
 		mux.Handle("/auth/login", authManager.LoginHandler())
 		mux.Handle("/auth/callback", authManager.CallbackHandler())
 		mux.Handle("/auth/logout", authManager.LogoutHandler())
+		mux.Handle("/signup", authManager.SignupHandler())
 	} else {
 		mux.HandleFunc("/auth/login", func(w http.ResponseWriter, r *http.Request) {
 			if r.Method != http.MethodGet {
@@ -104,42 +203,605 @@ func main() {
 		})
 	}
 
-	serverAPI := httpapi.NewServer(store)
+	compactor := compaction.New(store, compaction.Thresholds{
+		MaxOps:     envInt64Default("SERVER_SNAPSHOT_MAX_OPS", 0),
+		MaxOpBytes: envInt64Default("SERVER_SNAPSHOT_MAX_OP_BYTES", 0),
+	})
+	var opQuarantine *quarantine.Tracker
+	if threshold := envInt64Default("SERVER_QUARANTINE_THRESHOLD", quarantine.DefaultThreshold); threshold > 0 {
+		opQuarantine = quarantine.New(store, int(threshold))
+		compactor.SetQuarantine(opQuarantine)
+	}
+	if thresholds := compactor.Thresholds(); thresholds.Enabled() {
+		log.Printf("snapshot auto-compaction enabled max_ops=%d max_op_bytes=%d", thresholds.MaxOps, thresholds.MaxOpBytes)
+	}
+
+	featureFlags, err := features.Parse(os.Getenv("SERVER_FEATURES"))
+	if err != nil {
+		return nil, fmt.Errorf("SERVER_FEATURES: %w", err)
+	}
+	messages := i18n.Builtin()
+	if dir := os.Getenv("SERVER_MESSAGES_DIR"); dir != "" {
+		if err := messages.LoadDir(dir); err != nil {
+			return nil, fmt.Errorf("SERVER_MESSAGES_DIR: %w", err)
+		}
+		log.Printf("message catalogs loaded dir=%s languages=%s", dir, strings.Join(messages.Languages(), ","))
+	}
+
+	emailTemplates := mail.Builtin()
+	if dir := os.Getenv("SERVER_EMAIL_TEMPLATE_DIR"); dir != "" {
+		if err := emailTemplates.LoadDir(dir); err != nil {
+			return nil, fmt.Errorf("SERVER_EMAIL_TEMPLATE_DIR: %w", err)
+		}
+		log.Printf("email templates loaded dir=%s", dir)
+	}
+
+	digestsEnabled := false
+	var sender mail.Sender
+	if smtpAddr := os.Getenv("SERVER_SMTP_ADDR"); smtpAddr != "" {
+		smtp, err := mail.NewSMTPSender(mail.SMTPConfig{
+			Addr:     smtpAddr,
+			From:     os.Getenv("SERVER_SMTP_FROM"),
+			Username: os.Getenv("SERVER_SMTP_USERNAME"),
+			Password: os.Getenv("SERVER_SMTP_PASSWORD"),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("SERVER_SMTP_ADDR: %w", err)
+		}
+		sender = smtp
+		interval := time.Duration(envInt64Default("SERVER_DIGEST_INTERVAL_SECONDS", 900)) * time.Second
+		go digest.New(store, sender, messages, emailTemplates).Run(context.Background(), interval)
+		digestsEnabled = true
+		log.Printf("email digests enabled smtp=%s interval=%s", smtpAddr, interval)
+	}
+
+	var exports *export.Runner
+	if key := os.Getenv("SERVER_EXPORT_KEY"); key != "" {
+		sealer, err := export.NewSealer(key)
+		if err != nil {
+			return nil, fmt.Errorf("SERVER_EXPORT_KEY: %w", err)
+		}
+		allowPrivate := envBoolDefault("SERVER_EXPORT_ALLOW_PRIVATE_TARGETS", false)
+		exports = export.New(store, sealer, export.Options{AllowPrivateTargets: allowPrivate, Messages: messages})
+		interval := time.Duration(max(envInt64Default("SERVER_EXPORT_INTERVAL_SECONDS", 300), 1)) * time.Second
+		go exports.Run(context.Background(), interval)
+		compactor.OnCompacted(exports.Compacted)
+		log.Printf("scheduled exports enabled interval=%s allow_private_targets=%t", interval, allowPrivate)
+	}
+
+	var recorder *traffic.Recorder
+	if path := os.Getenv("SERVER_TRAFFIC_RECORD_PATH"); path != "" {
+		if recorder, err = traffic.Create(path); err != nil {
+			return nil, fmt.Errorf("SERVER_TRAFFIC_RECORD_PATH: %w", err)
+		}
+		log.Printf("recording anonymized sync traffic to %s", path)
+	}
+
+	var pushSpool *spool.Journal
+	if path := os.Getenv("SERVER_PUSH_SPOOL_PATH"); path != "" {
+		if pushSpool, err = spool.Open(path); err != nil {
+			return nil, fmt.Errorf("SERVER_PUSH_SPOOL_PATH: %w", err)
+		}
+		log.Printf("push spool enabled path=%s pending=%d", path, pushSpool.Pending())
+	}
+
+	var statsExporter *stats.Exporter
+	if target := os.Getenv("SERVER_STATS_EXPORT"); target != "" {
+		sink, err := stats.ParseSink(target)
+		if err != nil {
+			return nil, fmt.Errorf("SERVER_STATS_EXPORT: %w", err)
+		}
+		statsExporter = stats.New(store, sink)
+		go statsExporter.Run(context.Background(), time.Minute)
+		log.Printf("daily stats export enabled target=%s", target)
+	}
+
+	var integrityReports []integrity.Notifier
+	if recipients := envList("SERVER_INTEGRITY_REPORT_EMAIL"); len(recipients) > 0 {
+		if sender == nil {
+			return nil, errors.New("SERVER_INTEGRITY_REPORT_EMAIL needs SERVER_SMTP_ADDR")
+		}
+		integrityReports = append(integrityReports, &integrity.Email{Sender: sender, To: recipients})
+	}
+	if target := os.Getenv("SERVER_INTEGRITY_REPORT_WEBHOOK"); target != "" {
+		webhook, err := integrity.NewWebhook(target)
+		if err != nil {
+			return nil, fmt.Errorf("SERVER_INTEGRITY_REPORT_WEBHOOK: %w", err)
+		}
+		integrityReports = append(integrityReports, webhook)
+	}
+	var integrityChecker *integrity.Checker
+	if len(integrityReports) > 0 {
+		integrityChecker = integrity.New(store, integrityReports...)
+		go integrityChecker.Run(context.Background(), time.Minute)
+		log.Printf("daily integrity reports enabled notifiers=%d", len(integrityReports))
+	}
+
+	backups, err := newBackupManager(store)
+	if err != nil {
+		return nil, fmt.Errorf("backups: %w", err)
+	}
+	if backups != nil {
+		go backups.Loop(context.Background())
+		log.Printf("backups enabled next_run=%s encrypted=%t", backups.Next().Format(time.RFC3339), backups.Encrypted())
+	}
+
+	dispatcher := outbox.New(store)
+	serverAPI := httpapi.NewServerWithConfig(store, httpapi.Config{
+		Compaction:         compactor,
+		SnapshotChunkBytes: int(envInt64Default("SERVER_SNAPSHOT_CHUNK_BYTES", 1<<20)),
+		CompressMinBytes:   int(envInt64Default("SERVER_OP_COMPRESSION_MIN_BYTES", storage.DefaultCompressMinBytes)),
+		Notes:              notes.Policy{MaxBytes: int(envInt64Default("SERVER_NOTE_MAX_BYTES", notes.DefaultMaxBytes))},
+		Features:           featureFlags,
+		Digests:            digestsEnabled,
+		AuthMode:           authMode,
+		Reload:             reloader.Reload,
+		Settings:           reloader,
+		SignatureWindow:    time.Duration(envInt64Default("SERVER_SIGNATURE_WINDOW_SECONDS", 300)) * time.Second,
+		Quarantine:         opQuarantine,
+		Fleet:              fleet.NewCounters(int(envInt64Default("SERVER_CONFLICT_LOOP_THRESHOLD", fleet.DefaultStuckConflicts))),
+		Traffic:            recorder,
+		Stats:              statsExporter,
+		Outbox:             dispatcher,
+		Spool:              pushSpool,
+		Backups:            backups,
+		Exports:            exports,
+		Messages:           messages,
+		EmailTemplates:     emailTemplates,
+	})
+	// Delivers the side effects of writes made outside request handlers, such
+	// as compactions, and retries failed deliveries.
+	go dispatcher.Run(context.Background(), time.Duration(max(envInt64Default("SERVER_OUTBOX_INTERVAL_SECONDS", 1), 1))*time.Second)
+	if pushSpool != nil {
+		interval := time.Duration(max(envInt64Default("SERVER_PUSH_SPOOL_REPLAY_SECONDS", 5), 1)) * time.Second
+		go pushSpool.Run(context.Background(), interval, serverAPI.ReplaySpooled)
+	}
 	serverAPI.RegisterRoutes(mux)
+	// With admin listeners, operator endpoints are only served there and the
+	// public listeners do not know them at all. Otherwise the public
+	// listeners serve them to signed-in SERVER_ADMIN_USERS only: behind a
+	// reverse proxy on the same host every request comes from loopback, so
+	// the network filter alone would let anyone in.
+	adminAddrs := envList("SERVER_ADMIN_LISTEN_ADDRS")
+	adminMux := http.NewServeMux()
+	serverAPI.RegisterAdminRoutes(adminMux)
+	metricsMux := mux
+	if len(adminAddrs) > 0 {
+		adminMux.HandleFunc("/healthz", serverAPI.HandleHealthz)
+		metricsMux = adminMux
+	} else {
+		adminUsers := envList("SERVER_ADMIN_USERS")
+		if authMode == "dev" {
+			adminUsers = append(adminUsers, cmp.Or(devUserID, "dev-user"))
+		}
+		admin := adminUsersOnly(adminUsers, adminMux)
+		mux.Handle("/admin", admin)
+		mux.Handle("/admin/", admin)
+	}
 	registerStatic(mux)
 
+	endpointLimits, err := limiter.ParseEndpoints(os.Getenv("SERVER_MAX_IN_FLIGHT_ENDPOINTS"))
+	if err != nil {
+		return nil, fmt.Errorf("SERVER_MAX_IN_FLIGHT_ENDPOINTS: %w", err)
+	}
+	requestLimiter := limiter.New(limiter.Config{
+		Global:    int(envInt64Default("SERVER_MAX_IN_FLIGHT", 0)),
+		Endpoints: endpointLimits,
+		MaxWait:   time.Duration(envInt64Default("SERVER_MAX_IN_FLIGHT_WAIT_MS", 0)) * time.Millisecond,
+		// Streams stay open for as long as the client is connected.
+		Exempt: []string{"/healthz", "/metrics", "/sync/stream"},
+	})
+	objectives, err := slo.ParseObjectives(os.Getenv("SERVER_SLOS"))
+	if err != nil {
+		return nil, fmt.Errorf("SERVER_SLOS: %w", err)
+	}
+	var sloAlerts slo.Notifier
+	if target := os.Getenv("SERVER_SLO_ALERT_WEBHOOK"); target != "" {
+		webhook, err := slo.NewWebhook(target)
+		if err != nil {
+			return nil, fmt.Errorf("SERVER_SLO_ALERT_WEBHOOK: %w", err)
+		}
+		sloAlerts = webhook
+	}
+	requestMetrics := slo.New(slo.Config{
+		Objectives: objectives,
+		// Mux patterns keep ids in paths such as /items/{id} out of the
+		// metric labels.
+		Route: func(r *http.Request) string {
+			_, pattern := mux.Handler(r)
+			return pattern
+		},
+		Exempt: []string{"/healthz", "/metrics", "/sync/stream"},
+		Alerts: sloAlerts,
+	})
+	go requestMetrics.Run(context.Background(), time.Minute)
+	var errorReporter *sentry.Reporter
+	var reportPanic func(context.Context, recovery.Panic)
+	if dsn := os.Getenv("SERVER_SENTRY_DSN"); dsn != "" {
+		client, err := sentry.New(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("SERVER_SENTRY_DSN: %w", err)
+		}
+		errorReporter = sentry.NewReporter(client, os.Getenv("SERVER_SENTRY_ENVIRONMENT"))
+		reportPanic = func(_ context.Context, p recovery.Panic) {
+			errorReporter.Report(sentry.Event{
+				ID:     p.ErrorID,
+				Level:  sentry.LevelFatal,
+				Type:   "panic",
+				Value:  fmt.Sprint(p.Value),
+				Frames: p.Stack,
+				Method: p.Method,
+				Path:   p.Path,
+			})
+		}
+		if retrying, ok := store.(*storage.RetryingStore); ok {
+			retrying.OnFailure(errorReporter.StorageFailure)
+		}
+		log.Printf("sentry error reporting enabled")
+	}
+	recoverer := recovery.New(recovery.Config{Report: reportPanic})
+	if len(objectives) > 0 {
+		log.Printf("slo alerts enabled objectives=%d webhook=%t", len(objectives), sloAlerts != nil)
+	}
+	metricsMux.Handle("/metrics", metricsHandler(requestLimiter.WriteMetrics, requestMetrics.WriteMetrics, recoverer.WriteMetrics, integrityChecker.WriteMetrics))
+
 	skipAuthPaths := map[string]struct{}{
 		"/auth/login":    {},
 		"/auth/callback": {},
 		"/auth/logout":   {},
+		"/signup":        {},
 		"/healthz":       {},
+		// /metrics is restricted by network (ipfilter) so scrapers need no
+		// login.
+		"/metrics": {},
+		// /mcp and /quick-add authenticate with API tokens instead of a
+		// session, /oauth/token with the app's client credentials and
+		// /auth/pairing/redeem with a pairing code.
+		"/mcp":                 {},
+		"/quick-add":           {},
+		"/dav":                 {},
+		"/oauth/token":         {},
+		"/auth/pairing/redeem": {},
 	}
 	authSkipper := func(r *http.Request) bool {
-		if strings.HasPrefix(r.URL.Path, "/sync/") {
+		// /integrations/ and /dav/ authenticate with API tokens.
+		if strings.HasPrefix(r.URL.Path, "/sync/") || strings.HasPrefix(r.URL.Path, "/auth/passkey/") || strings.HasPrefix(r.URL.Path, "/integrations/") || strings.HasPrefix(r.URL.Path, "/dav/") {
 			return true
 		}
 		_, ok := skipAuthPaths[r.URL.Path]
 		return ok
 	}
 
-	handler := http.Handler(mux)
+	handler := httpapi.LocalizeErrors(messages, mux)
+	chaosConfig, err := chaos.Parse(os.Getenv("SERVER_CHAOS"))
+	if err != nil {
+		return nil, fmt.Errorf("SERVER_CHAOS: %w", err)
+	}
+	if chaosConfig.Enabled() {
+		// Failure injection is for local client development only; refusing
+		// it with real logins keeps it out of production deployments.
+		if authMode != "dev" && authMode != "none" {
+			return nil, errors.New("SERVER_CHAOS requires SERVER_AUTH_MODE=dev or none")
+		}
+		log.Printf("WARNING: chaos mode injects failures into /sync/* (%s)", chaosConfig)
+		handler = chaos.New(chaosConfig).Middleware(handler)
+	}
 	if authMode == "dev" {
 		handler = auth.DevUserMiddleware(devUserID)(handler)
+	} else if authMode == "none" {
+		handler = auth.AnonymousMiddleware()(handler)
+	} else if authMode == "passkey" {
+		handler = authManager.WithUser(handler)
+		handler = authManager.CSRFMiddleware(handler)
+		handler = authManager.RequireLogin(authSkipper)(handler)
 	} else {
 		handler = authManager.WithUser(handler)
-		handler = baselibmiddleware.CsrfMiddlewareStd(handler)
+		handler = authManager.CSRFMiddleware(handler)
 		handler = authManager.OIDCMiddleware(authSkipper)(handler)
 	}
+	handler = serverAPI.WithAPITokens(httpapi.LocalizeErrors(messages, mux), handler)
+	handler = requestLimiter.Middleware(handler)
+	handler = errorReporter.Middleware(handler)
+	handler = recoverer.Middleware(handler)
+	// Measured outside the limiter and the recoverer, so their 503s and
+	// 500s count against the objectives.
+	handler = requestMetrics.Middleware(handler)
+	adminFilter := ipfilter.New(ipfilter.Config{
+		Allow:          envPrefixes("SERVER_ADMIN_ALLOW_CIDRS"),
+		Deny:           envPrefixes("SERVER_ADMIN_DENY_CIDRS"),
+		TrustedProxies: envPrefixes("SERVER_TRUSTED_PROXY_CIDRS"),
+		ClientIPHeader: os.Getenv("SERVER_CLIENT_IP_HEADER"),
+	})
+	handler = adminFilter.Middleware(handler)
 
-	server := &http.Server{
-		Addr:              addr,
-		Handler:           handler,
-		ReadHeaderTimeout: 5 * time.Second,
+	reloader.server, reloader.compactor, reloader.filter = serverAPI, compactor, adminFilter
+	reloader.store, reloader.backups, reloader.signup = store, backups, signupGate
+
+	app := &application{handler: handler, adminAddrs: adminAddrs, compactor: compactor, recorder: recorder}
+	if len(adminAddrs) > 0 {
+		// Admin listeners need no login, so a page the operator visits must
+		// not be able to post to them.
+		adminRoutes := http.NewCrossOriginProtection().Handler(adminMux)
+		app.adminHandler = adminFilter.Middleware(recoverer.Middleware(errorReporter.Middleware(adminRoutes)))
 	}
+	return app, nil
+}
 
-	log.Printf("server listening on %s", addr)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("server error: %v", err)
+// adminUsersOnly serves next to the signed-in users listed in admins and
+// answers everyone else with 403.
+func adminUsersOnly(admins []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if userID, ok := auth.UserIDFromContext(r.Context()); !ok || !slices.Contains(admins, userID) {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = io.WriteString(w, `{"error":"admin access requires a user listed in SERVER_ADMIN_USERS"}`+"\n")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// metricsHandler serves the metrics of every writer in the Prometheus text
+// format.
+func metricsHandler(writers ...func(io.Writer) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		for _, write := range writers {
+			if err := write(w); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// serveAll runs the servers until one of them stops and returns its error.
+func serveAll(servers []*http.Server) error {
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func() {
+			if err := server.ListenAndServe(); err != nil {
+				errs <- fmt.Errorf("%s: %w", server.Addr, err)
+				return
+			}
+			errs <- nil
+		}()
+	}
+	return <-errs
+}
+
+// newAuthManager builds the login manager for authMode from the environment.
+// The dev and none modes have no login and get nil. OIDC logins are admitted
+// by gate, when set.
+func newAuthManager(authMode string, store storage.Store, gate *signup.Gate) (*auth.Manager, error) {
+	issuerURL := os.Getenv("OIDC_ISSUER_URL")
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	clientSecret := os.Getenv("OIDC_CLIENT_SECRET")
+	redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+	sessionKey := os.Getenv("SERVER_SESSION_KEY")
+	cookieSecure := envBoolDefault("SERVER_COOKIE_SECURE", true)
+	cookieDomain := os.Getenv("SERVER_COOKIE_DOMAIN")
+
+	profileUpdater := func(ctx context.Context, userID string, profile auth.Profile) error {
+		// A zone the server does not know would only be ignored later.
+		if _, err := time.LoadLocation(profile.TimeZone); err != nil || profile.TimeZone == "Local" {
+			profile.TimeZone = ""
+		}
+		return store.UpdateUserProfile(ctx, userID, storage.UserProfile{
+			Email:       profile.Email,
+			DisplayName: profile.DisplayName,
+			AvatarURL:   profile.AvatarURL,
+			Locale:      profile.Locale,
+			TimeZone:    profile.TimeZone,
+		})
+	}
+
+	sessionTTL := time.Duration(envInt64Default("SERVER_SESSION_TTL_SECONDS", 30*24*60*60)) * time.Second
+	sessionIdleTimeout := time.Duration(envInt64Default("SERVER_SESSION_IDLE_TIMEOUT_SECONDS", 14*24*60*60)) * time.Second
+
+	csrfMode, err := auth.ParseCSRFMode(os.Getenv("SERVER_CSRF_MODE"))
+	if err != nil {
+		return nil, err
+	}
+
+	switch authMode {
+	case "dev", "none":
+		return nil, nil
+	case "passkey":
+		return auth.NewPasskeyManager(auth.Config{
+			SessionKey:         sessionKey,
+			SessionTTL:         sessionTTL,
+			SessionIdleTimeout: sessionIdleTimeout,
+			CookieSecure:       cookieSecure,
+			CookieSameSite:     http.SameSiteLaxMode,
+			CookieDomain:       cookieDomain,
+			FallbackURL:        "/",
+			CSRFMode:           csrfMode,
+			OnLogin:            profileUpdater,
+		}, auth.PasskeyConfig{
+			RPID:          os.Getenv("SERVER_PASSKEY_RP_ID"),
+			RPDisplayName: os.Getenv("SERVER_PASSKEY_RP_NAME"),
+			RPOrigins:     envList("SERVER_PASSKEY_RP_ORIGINS"),
+			AllowSignup:   envBoolDefault("SERVER_PASSKEY_ALLOW_SIGNUP", false),
+			TOTP:          auth.TOTPPolicy(os.Getenv("SERVER_PASSKEY_TOTP")),
+		}, store)
+	default:
+		if issuerURL == "" || clientID == "" || redirectURL == "" {
+			return nil, errors.New("OIDC_ISSUER_URL, OIDC_CLIENT_ID, and OIDC_REDIRECT_URL are required unless SERVER_AUTH_MODE is dev, none or passkey")
+		}
+		var admit func(ctx context.Context, signup auth.Signup) error
+		if gate != nil {
+			// The gate always runs, so its hourly cap can be set at runtime.
+			admit = gate.Admit
+		}
+		if config := signupConfig(); config.Enabled() {
+			log.Printf("signup gating enabled allow=%d deny=%d require_invite=%t max_per_hour=%d", len(config.Allow), len(config.Deny), config.RequireInvite, config.MaxPerHour)
+		}
+		return auth.NewManager(auth.Config{
+			IssuerURL:           issuerURL,
+			ClientID:            clientID,
+			ClientSecret:        clientSecret,
+			RedirectURL:         redirectURL,
+			SessionKey:          sessionKey,
+			SessionTTL:          sessionTTL,
+			SessionIdleTimeout:  sessionIdleTimeout,
+			CookieSecure:        cookieSecure,
+			CookieSameSite:      http.SameSiteLaxMode,
+			CookieDomain:        cookieDomain,
+			FallbackURL:         "/",
+			CSRFMode:            csrfMode,
+			UserIDClaim:         os.Getenv("OIDC_USER_ID_CLAIM"),
+			PreviousUserIDClaim: os.Getenv("OIDC_PREVIOUS_USER_ID_CLAIM"),
+			MigrateUserID:       store.MigrateUserID,
+			OnLogin:             profileUpdater,
+			Admit:               admit,
+		})
+	}
+}
+
+// signupConfig reads who may sign up through OIDC from the environment.
+func signupConfig() signup.Config {
+	return signup.Config{
+		Allow:         envList("SERVER_SIGNUP_ALLOW"),
+		Deny:          envList("SERVER_SIGNUP_DENY"),
+		RequireInvite: envBoolDefault("SERVER_SIGNUP_REQUIRE_INVITE", false),
+		MaxPerHour:    int(envInt64Default("SERVER_SIGNUP_MAX_PER_HOUR", 0)),
+	}
+}
+
+func openStore(demo bool) (storage.Store, error) {
+	if demo {
+		log.Printf("demo mode: using in-memory storage, data is lost on restart")
+		return storage.NewMemoryStore(), nil
+	}
+	dbPath := databasePath()
+	if err := ensureParentDir(dbPath); err != nil {
+		return nil, fmt.Errorf("db path: %w", err)
+	}
+	// The options apply to the database file and, in the per-user layout, to
+	// every user file.
+	options := []func(*storage.SQLiteStore){
+		func(store *storage.SQLiteStore) { store.OnInstanceLockLost(exitOnLockLost) },
+	}
+	if value := os.Getenv("SERVER_SQLITE_INTEGRITY_CHECK"); value != "" {
+		mode, err := storage.ParseIntegrityCheck(value)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, func(store *storage.SQLiteStore) { store.SetIntegrityCheck(mode) })
+	}
+	tuning, err := sqliteTuning()
+	if err != nil {
+		return nil, err
+	}
+	options = append(options, func(store *storage.SQLiteStore) { store.SetTuning(tuning) })
+	compressMinBytes := int(envInt64Default("SERVER_OP_COMPRESSION_MIN_BYTES", storage.DefaultCompressMinBytes))
+	options = append(options, func(store *storage.SQLiteStore) { store.CompressPayloads(compressMinBytes) })
+	if envBoolDefault("SERVER_SQLITE_EXTERNAL_REPLICATION", false) {
+		options = append(options, (*storage.SQLiteStore).DisableAutoCheckpoint)
+		log.Printf("external replication mode: sqlite auto-checkpoints disabled")
+	}
+	if payloadDir := os.Getenv("SERVER_PAYLOAD_DIR"); payloadDir != "" {
+		if os.Getenv("SERVER_SNAPSHOT_S3_ENDPOINT") != "" {
+			return nil, errors.New("SERVER_PAYLOAD_DIR and SERVER_SNAPSHOT_S3_ENDPOINT both decide where snapshots live; set only one")
+		}
+		blobs, err := blobstore.NewDir(payloadDir)
+		if err != nil {
+			return nil, fmt.Errorf("payload store: %w", err)
+		}
+		options = append(options, func(store *storage.SQLiteStore) { store.StorePayloadsIn(blobs) })
+		log.Printf("payload store enabled dir=%s", payloadDir)
+	} else if endpoint := os.Getenv("SERVER_SNAPSHOT_S3_ENDPOINT"); endpoint != "" {
+		blobs, err := blobstore.NewS3(blobstore.S3Config{
+			Endpoint:        endpoint,
+			Region:          os.Getenv("SERVER_SNAPSHOT_S3_REGION"),
+			Bucket:          os.Getenv("SERVER_SNAPSHOT_S3_BUCKET"),
+			AccessKeyID:     os.Getenv("SERVER_SNAPSHOT_S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("SERVER_SNAPSHOT_S3_SECRET_ACCESS_KEY"),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("snapshot offload: %w", err)
+		}
+		minBytes := envInt64Default("SERVER_SNAPSHOT_OFFLOAD_MIN_BYTES", 1<<20)
+		options = append(options, func(store *storage.SQLiteStore) { store.OffloadSnapshots(blobs, int(minBytes)) })
+		log.Printf("snapshot offload enabled endpoint=%s min_bytes=%d", endpoint, minBytes)
+	}
+	if userDir := os.Getenv("SERVER_DB_USER_DIR"); userDir != "" {
+		maxOpen := envInt64Default("SERVER_DB_USER_MAX_OPEN", storage.DefaultMaxOpenUserFiles)
+		store, err := storage.OpenSQLitePerUser(dbPath, userDir, int(maxOpen))
+		if err != nil {
+			return nil, err
+		}
+		for _, option := range options {
+			store.Configure(option)
+		}
+		log.Printf("per-user databases enabled dir=%s max_open=%d", userDir, maxOpen)
+		return store, nil
+	}
+	store, err := storage.OpenSQLite(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, option := range options {
+		option(store)
+	}
+	return store, nil
+}
+
+// exitOnLockLost stops the process once another one took over the database.
+// The store already refuses writes; exiting lets a supervisor notice.
+func exitOnLockLost(err error) {
+	log.Fatalf("storage error: %v", err)
+}
+
+// sqliteTuning reads the SQLite PRAGMA settings from SERVER_SQLITE_*, falling
+// back to storage.DefaultSQLiteTuning for unset ones.
+func sqliteTuning() (storage.SQLiteTuning, error) {
+	tuning := storage.DefaultSQLiteTuning()
+	tuning.CacheBytes = envInt64Default("SERVER_SQLITE_CACHE_BYTES", tuning.CacheBytes)
+	tuning.MmapBytes = envInt64Default("SERVER_SQLITE_MMAP_BYTES", tuning.MmapBytes)
+	tuning.JournalSizeLimitBytes = envInt64Default("SERVER_SQLITE_JOURNAL_SIZE_LIMIT_BYTES", tuning.JournalSizeLimitBytes)
+	if value := os.Getenv("SERVER_SQLITE_SYNCHRONOUS"); value != "" {
+		level, err := storage.ParseSynchronous(value)
+		if err != nil {
+			return tuning, fmt.Errorf("SERVER_SQLITE_SYNCHRONOUS: %w", err)
+		}
+		tuning.Synchronous = level
+	}
+	if value := os.Getenv("SERVER_SQLITE_TEMP_STORE"); value != "" {
+		store, err := storage.ParseTempStore(value)
+		if err != nil {
+			return tuning, fmt.Errorf("SERVER_SQLITE_TEMP_STORE: %w", err)
+		}
+		tuning.TempStore = store
+	}
+	return tuning, nil
+}
+
+// sqliteDatabase is satisfied by the SQLite stores of either layout.
+type sqliteDatabase interface {
+	Tuning(ctx context.Context) (storage.SQLiteTuning, error)
+	Checkpoint(ctx context.Context, mode storage.CheckpointMode) (storage.CheckpointResult, error)
+}
+
+// runCheckpoints periodically runs a passive WAL checkpoint. Passive
+// checkpoints never wait on readers, so they are safe to combine with an
+// external replicator that holds a read lock while shipping the WAL.
+func runCheckpoints(store sqliteDatabase, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		result, err := store.Checkpoint(context.Background(), storage.CheckpointPassive)
+		if err != nil {
+			log.Printf("sqlite checkpoint error: %v", err)
+			continue
+		}
+		if result.Busy {
+			log.Printf("sqlite checkpoint busy log_frames=%d checkpointed=%d", result.LogFrames, result.CheckpointedFrames)
+		}
 	}
 }
 
@@ -220,6 +882,30 @@ func serveIndexFallback(w http.ResponseWriter, r *http.Request, fs fs.FS) {
 	}
 }
 
+// envList splits a comma-separated variable, dropping empty entries.
+func envList(key string) []string {
+	return splitList(os.Getenv(key))
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(list string) []string {
+	var values []string
+	for value := range strings.SplitSeq(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func envPrefixes(key string) []netip.Prefix {
+	prefixes, err := ipfilter.ParsePrefixes(envList(key))
+	if err != nil {
+		log.Fatalf("%s: %v", key, err)
+	}
+	return prefixes
+}
+
 func envBoolDefault(key string, defaultValue bool) bool {
 	value := strings.TrimSpace(os.Getenv(key))
 	if value == "" {
@@ -234,3 +920,16 @@ func envBoolDefault(key string, defaultValue bool) bool {
 		return defaultValue
 	}
 }
+
+func envInt64Default(key string, defaultValue int64) int64 {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || parsed < 0 {
+		log.Printf("warning: ignoring invalid %s=%q", key, value)
+		return defaultValue
+	}
+	return parsed
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/netip"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"a4-tasklists/server/internal/backup"
+	"a4-tasklists/server/internal/compaction"
+	"a4-tasklists/server/internal/features"
+	"a4-tasklists/server/internal/httpapi"
+	"a4-tasklists/server/internal/ipfilter"
+	"a4-tasklists/server/internal/signup"
+	"a4-tasklists/server/internal/storage"
+)
+
+// Settings in SERVER_CONFIG_FILE (KEY=VALUE lines) override the environment.
+// On SIGHUP or POST /admin/reload the file is read again and the reloadable
+// settings below take effect on the running server, without dropping
+// connections. Other changed settings are reported as needing a restart.
+//
+// Most reloadable settings can also be stored in the database through
+// /admin/settings, which overrides both and takes effect at once. Every change
+// is recorded with who made it.
+
+// reloadableSettings are the settings a reload applies.
+var reloadableSettings = []string{
+	"SERVER_FEATURES",
+	"SERVER_SNAPSHOT_MAX_OPS",
+	"SERVER_SNAPSHOT_MAX_OP_BYTES",
+	"SERVER_ADMIN_ALLOW_CIDRS",
+	"SERVER_ADMIN_DENY_CIDRS",
+	"SERVER_BACKUP_KEEP",
+	"SERVER_BACKUP_MAX_AGE_DAYS",
+	"SERVER_SIGNUP_MAX_PER_HOUR",
+}
+
+// storableSettings are the reloadable settings /admin/settings can store. The
+// admin networks stay out, so a mistake cannot lock operators out of the API
+// that would undo it.
+var storableSettings = slices.DeleteFunc(slices.Clone(reloadableSettings), func(key string) bool {
+	return key == "SERVER_ADMIN_ALLOW_CIDRS" || key == "SERVER_ADMIN_DENY_CIDRS"
+})
+
+// configReloader owns SERVER_CONFIG_FILE, the stored settings and the
+// components whose settings can be reloaded. The components are set once the
+// server is built.
+type configReloader struct {
+	path string
+	// environ is the process environment before the file was applied, which
+	// settings removed from the file fall back to.
+	environ map[string]string
+
+	store     storage.Store
+	server    *httpapi.Server
+	compactor *compaction.Compactor
+	filter    *ipfilter.Filter
+	backups   *backup.Manager
+	signup    *signup.Gate
+
+	mu      sync.Mutex
+	applied map[string]string
+	// stored are the settings stored through /admin/settings, by key.
+	stored map[string]storage.Setting
+}
+
+// newConfigReloader applies the settings of the config file at path, if any,
+// to the environment so they are read like any other.
+func newConfigReloader(path string) (*configReloader, error) {
+	r := &configReloader{path: path, environ: make(map[string]string), applied: make(map[string]string), stored: make(map[string]storage.Setting)}
+	for _, entry := range os.Environ() {
+		if key, value, ok := strings.Cut(entry, "="); ok {
+			r.environ[key] = value
+		}
+	}
+	if path == "" {
+		return r, nil
+	}
+	settings, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range settings {
+		if err := os.Setenv(key, value); err != nil {
+			return nil, fmt.Errorf("set %s: %w", key, err)
+		}
+	}
+	r.applied = settings
+	log.Printf("config file loaded path=%s settings=%d", path, len(settings))
+	return r, nil
+}
+
+// loadStoredSettings applies the settings stored through /admin/settings to
+// the environment, over the config file, so they are read like any other.
+func (r *configReloader) loadStoredSettings(ctx context.Context, store storage.Store) error {
+	settings, err := store.ListSettings(ctx)
+	if err != nil {
+		return err
+	}
+	for _, setting := range settings {
+		if !slices.Contains(storableSettings, setting.Key) {
+			log.Printf("stored setting %s ignored: it cannot be changed at runtime", setting.Key)
+			continue
+		}
+		if err := os.Setenv(setting.Key, setting.Value); err != nil {
+			return fmt.Errorf("set %s: %w", setting.Key, err)
+		}
+		r.stored[setting.Key] = setting
+	}
+	if len(r.stored) > 0 {
+		log.Printf("stored settings loaded settings=%d", len(r.stored))
+	}
+	return nil
+}
+
+// readConfigFile parses KEY=VALUE lines. Blank lines and lines starting with #
+// are skipped, and values may be wrapped in double quotes.
+func readConfigFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+	settings := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(text, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("config file %s:%d: expected KEY=VALUE", path, line)
+		}
+		value = strings.TrimSpace(value)
+		if unquoted, err := strconv.Unquote(value); err == nil && strings.HasPrefix(value, `"`) {
+			value = unquoted
+		}
+		settings[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+	return settings, nil
+}
+
+// value resolves key as it is with the given file and stored settings.
+func (r *configReloader) value(settings map[string]string, stored map[string]storage.Setting, key string) string {
+	if setting, ok := stored[key]; ok {
+		return setting.Value
+	}
+	if value, ok := settings[key]; ok {
+		return value
+	}
+	return r.environ[key]
+}
+
+// runtimeConfig holds the parsed reloadable settings.
+type runtimeConfig struct {
+	flags            *features.Flags
+	thresholds       compaction.Thresholds
+	allow            []netip.Prefix
+	deny             []netip.Prefix
+	retention        backup.Retention
+	signupMaxPerHour int
+}
+
+// parse checks and parses the reloadable settings as they are with the given
+// file and stored settings.
+func (r *configReloader) parse(settings map[string]string, stored map[string]storage.Setting) (runtimeConfig, error) {
+	var config runtimeConfig
+	flags, err := features.Parse(r.value(settings, stored, "SERVER_FEATURES"))
+	if err != nil {
+		return runtimeConfig{}, fmt.Errorf("SERVER_FEATURES: %w", err)
+	}
+	config.flags = flags
+	var keep, maxAgeDays, signupMaxPerHour int64
+	for key, target := range map[string]*int64{
+		"SERVER_SNAPSHOT_MAX_OPS":      &config.thresholds.MaxOps,
+		"SERVER_SNAPSHOT_MAX_OP_BYTES": &config.thresholds.MaxOpBytes,
+		"SERVER_BACKUP_KEEP":           &keep,
+		"SERVER_BACKUP_MAX_AGE_DAYS":   &maxAgeDays,
+		"SERVER_SIGNUP_MAX_PER_HOUR":   &signupMaxPerHour,
+	} {
+		if value := strings.TrimSpace(r.value(settings, stored, key)); value != "" {
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || parsed < 0 {
+				return runtimeConfig{}, fmt.Errorf("%s: %q is not a non-negative integer", key, value)
+			}
+			*target = parsed
+		} else if key == "SERVER_BACKUP_KEEP" {
+			keep = defaultBackupKeep
+		}
+	}
+	config.retention = backup.Retention{Keep: int(keep), MaxAge: time.Duration(maxAgeDays) * 24 * time.Hour}
+	config.signupMaxPerHour = int(signupMaxPerHour)
+	if config.allow, err = ipfilter.ParsePrefixes(splitList(r.value(settings, stored, "SERVER_ADMIN_ALLOW_CIDRS"))); err != nil {
+		return runtimeConfig{}, fmt.Errorf("SERVER_ADMIN_ALLOW_CIDRS: %w", err)
+	}
+	if config.deny, err = ipfilter.ParsePrefixes(splitList(r.value(settings, stored, "SERVER_ADMIN_DENY_CIDRS"))); err != nil {
+		return runtimeConfig{}, fmt.Errorf("SERVER_ADMIN_DENY_CIDRS: %w", err)
+	}
+	return config, nil
+}
+
+// apply hands the settings to the running components.
+func (r *configReloader) apply(config runtimeConfig) {
+	r.server.SetFeatures(config.flags)
+	r.compactor.SetThresholds(config.thresholds)
+	r.filter.SetNetworks(config.allow, config.deny)
+	r.backups.SetRetention(config.retention)
+	if r.signup != nil {
+		r.signup.SetMaxPerHour(config.signupMaxPerHour)
+	}
+}
+
+// Reload reads the config file again and applies the reloadable settings. An
+// invalid file changes nothing.
+func (r *configReloader) Reload() (httpapi.ReloadResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.path == "" {
+		return httpapi.ReloadResult{}, errors.New("SERVER_CONFIG_FILE is not set, so there is nothing to reload")
+	}
+	settings, err := readConfigFile(r.path)
+	if err != nil {
+		return httpapi.ReloadResult{}, err
+	}
+	config, err := r.parse(settings, r.stored)
+	if err != nil {
+		return httpapi.ReloadResult{}, err
+	}
+	r.apply(config)
+
+	result := httpapi.ReloadResult{Applied: []string{}, RestartRequired: []string{}}
+	keys := slices.Collect(maps.Keys(settings))
+	for key := range r.applied {
+		if _, ok := settings[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		if r.value(settings, r.stored, key) == r.value(r.applied, r.stored, key) {
+			continue
+		}
+		if slices.Contains(reloadableSettings, key) {
+			result.Applied = append(result.Applied, key)
+		} else {
+			result.RestartRequired = append(result.RestartRequired, key)
+		}
+	}
+	r.applied = settings
+	log.Printf("config reloaded applied=%s restart_required=%s", strings.Join(result.Applied, ","), strings.Join(result.RestartRequired, ","))
+	return result, nil
+}
+
+// Settings returns the settings /admin/settings can store, with their
+// current values.
+func (r *configReloader) Settings() []httpapi.Setting {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	settings := make([]httpapi.Setting, 0, len(storableSettings))
+	for _, key := range storableSettings {
+		settings = append(settings, r.setting(key))
+	}
+	return settings
+}
+
+// SetSetting stores and applies a setting.
+func (r *configReloader) SetSetting(ctx context.Context, key string, value string, by string) (httpapi.Setting, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !slices.Contains(storableSettings, key) {
+		return httpapi.Setting{}, httpapi.ErrUnknownSetting
+	}
+	setting := storage.Setting{Key: key, Value: strings.TrimSpace(value), UpdatedAt: time.Now().Unix(), UpdatedBy: by}
+	stored := maps.Clone(r.stored)
+	stored[key] = setting
+	return r.commit(key, by, stored, func() error { return r.store.PutSetting(ctx, setting) })
+}
+
+// ResetSetting removes a stored setting, so the config file or environment
+// decides again.
+func (r *configReloader) ResetSetting(ctx context.Context, key string, by string) (httpapi.Setting, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !slices.Contains(storableSettings, key) {
+		return httpapi.Setting{}, httpapi.ErrUnknownSetting
+	}
+	stored := maps.Clone(r.stored)
+	delete(stored, key)
+	return r.commit(key, by, stored, func() error { return r.store.DeleteSetting(ctx, key, by, time.Now().Unix()) })
+}
+
+// commit checks the settings as they are with stored, records the change with
+// persist and applies them. Nothing changes when either fails.
+func (r *configReloader) commit(key string, by string, stored map[string]storage.Setting, persist func() error) (httpapi.Setting, error) {
+	config, err := r.parse(r.applied, stored)
+	if err != nil {
+		return httpapi.Setting{}, fmt.Errorf("%w: %w", httpapi.ErrInvalidSetting, err)
+	}
+	if err := persist(); err != nil {
+		return httpapi.Setting{}, err
+	}
+	r.apply(config)
+	r.stored = stored
+	setting := r.setting(key)
+	log.Printf("setting changed key=%s source=%s by=%s", key, setting.Source, by)
+	return setting, nil
+}
+
+// setting describes key with its current value and where it comes from.
+func (r *configReloader) setting(key string) httpapi.Setting {
+	setting := httpapi.Setting{Key: key, Source: httpapi.SettingSourceDefault}
+	if value, ok := r.applied[key]; ok {
+		setting.Default, setting.Source = value, httpapi.SettingSourceFile
+	} else if value := r.environ[key]; value != "" {
+		setting.Default, setting.Source = value, httpapi.SettingSourceEnvironment
+	}
+	setting.Value = setting.Default
+	if stored, ok := r.stored[key]; ok {
+		setting.Value, setting.Source = stored.Value, httpapi.SettingSourceDatabase
+		setting.UpdatedAt, setting.UpdatedBy = stored.UpdatedAt, stored.UpdatedBy
+	}
+	return setting
+}
+
+// reloadOnSignal reloads the config file whenever the process gets SIGHUP.
+func (r *configReloader) reloadOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if _, err := r.Reload(); err != nil {
+			log.Printf("config reload failed, keeping current settings: %v", err)
+		}
+	}
+}
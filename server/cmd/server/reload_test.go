@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"a4-tasklists/server/internal/compaction"
+	"a4-tasklists/server/internal/httpapi"
+	"a4-tasklists/server/internal/ipfilter"
+	"a4-tasklists/server/internal/signup"
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestStoredSettingsOverrideConfigFileAndAreAudited(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.env")
+	writeConfig := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("write config: %v", err)
+		}
+	}
+	writeConfig("SERVER_SNAPSHOT_MAX_OPS=100\n")
+	t.Setenv("SERVER_SNAPSHOT_MAX_OPS", "")
+	t.Setenv("SERVER_FEATURES", "")
+	reloader, err := newConfigReloader(path)
+	if err != nil {
+		t.Fatalf("config reloader: %v", err)
+	}
+	store := storage.NewMemoryStore()
+	server := httpapi.NewServerWithConfig(store, httpapi.Config{Settings: reloader})
+	reloader.store, reloader.server = store, server
+	reloader.compactor = compaction.New(store, compaction.Thresholds{})
+	reloader.filter = ipfilter.New(ipfilter.Config{})
+	reloader.signup = signup.New(store, signup.Config{})
+	mux := http.NewServeMux()
+	server.RegisterAdminRoutes(mux)
+
+	do := func(method, target, body string) (int, httpapi.Setting) {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		var setting httpapi.Setting
+		if resp.Code == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&setting); err != nil {
+				t.Fatalf("decode setting: %v", err)
+			}
+		}
+		return resp.Code, setting
+	}
+
+	if code, setting := do(http.MethodGet, "/admin/settings/SERVER_SNAPSHOT_MAX_OPS", ""); code != http.StatusOK || setting.Value != "100" || setting.Source != httpapi.SettingSourceFile {
+		t.Fatalf("unexpected setting before a change: %d %+v", code, setting)
+	}
+	if code, _ := do(http.MethodPut, "/admin/settings/SERVER_SNAPSHOT_MAX_OPS", `{"value":"lots"}`); code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid value to be refused, got %d", code)
+	}
+	if code, _ := do(http.MethodPut, "/admin/settings/SERVER_ADMIN_ALLOW_CIDRS", `{"value":"0.0.0.0/0"}`); code != http.StatusNotFound {
+		t.Fatalf("expected the admin networks not to be storable, got %d", code)
+	}
+	if code, _ := do(http.MethodDelete, "/admin/settings/SERVER_SNAPSHOT_MAX_OPS", ""); code != http.StatusNotFound {
+		t.Fatalf("expected resetting an unstored setting to be 404, got %d", code)
+	}
+	code, setting := do(http.MethodPut, "/admin/settings/SERVER_SNAPSHOT_MAX_OPS", `{"value":"500","by":"ann"}`)
+	if code != http.StatusOK || setting.Value != "500" || setting.Default != "100" || setting.Source != httpapi.SettingSourceDatabase || setting.UpdatedBy != "ann" {
+		t.Fatalf("unexpected stored setting: %d %+v", code, setting)
+	}
+	if code, _ := do(http.MethodPut, "/admin/settings/SERVER_FEATURES", `{"value":"nonsense=maybe"}`); code != http.StatusBadRequest {
+		t.Fatalf("expected invalid feature flags to be refused, got %d", code)
+	}
+
+	// The stored value keeps winning over the file, so a reload changes
+	// nothing that is in effect.
+	writeConfig("SERVER_SNAPSHOT_MAX_OPS=200\n")
+	result, err := reloader.Reload()
+	if err != nil || len(result.Applied) != 0 {
+		t.Fatalf("expected the reload to apply nothing, got %+v %v", result, err)
+	}
+	if code, setting := do(http.MethodDelete, "/admin/settings/SERVER_SNAPSHOT_MAX_OPS?by=bob", ""); code != http.StatusOK || setting.Value != "200" || setting.Source != httpapi.SettingSourceFile {
+		t.Fatalf("expected the file value after a reset, got %d %+v", code, setting)
+	}
+
+	changes, err := store.ListSettingChanges(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("list changes: %v", err)
+	}
+	if len(changes) != 2 || changes[0].ChangedBy != "bob" || changes[0].Value != nil || *changes[0].Previous != "500" || changes[1].ChangedBy != "ann" {
+		t.Fatalf("unexpected audit trail: %+v", changes)
+	}
+
+	// A restart picks the stored values up again.
+	if err := store.PutSetting(context.Background(), storage.Setting{Key: "SERVER_BACKUP_KEEP", Value: "3", UpdatedBy: "ann"}); err != nil {
+		t.Fatalf("put setting: %v", err)
+	}
+	t.Setenv("SERVER_BACKUP_KEEP", "")
+	restarted, err := newConfigReloader(path)
+	if err != nil {
+		t.Fatalf("config reloader: %v", err)
+	}
+	if err := restarted.loadStoredSettings(context.Background(), store); err != nil {
+		t.Fatalf("load stored settings: %v", err)
+	}
+	if got := os.Getenv("SERVER_BACKUP_KEEP"); got != "3" {
+		t.Fatalf("expected the stored setting in the environment, got %q", got)
+	}
+}
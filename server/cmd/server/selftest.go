@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"a4-tasklists/server/internal/blobstore"
+	"a4-tasklists/server/internal/chaos"
+	"a4-tasklists/server/internal/digest"
+	"a4-tasklists/server/internal/export"
+	"a4-tasklists/server/internal/features"
+	"a4-tasklists/server/internal/i18n"
+	"a4-tasklists/server/internal/integrity"
+	"a4-tasklists/server/internal/ipfilter"
+	"a4-tasklists/server/internal/limiter"
+	"a4-tasklists/server/internal/mail"
+	"a4-tasklists/server/internal/sentry"
+	"a4-tasklists/server/internal/slo"
+	"a4-tasklists/server/internal/stats"
+	"a4-tasklists/server/internal/storage"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// The self-test (--check) validates a deployment without serving it, for
+// container entrypoints and deployment CI. It reports every problem it finds
+// instead of stopping at the first, and never changes the database.
+
+// selfTestTimeout bounds network checks such as OIDC discovery.
+const selfTestTimeout = 10 * time.Second
+
+type selfTest struct {
+	out      io.Writer
+	failures int
+	warnings int
+}
+
+func (t *selfTest) report(status string, check string, format string, args ...any) {
+	fmt.Fprintf(t.out, "%-5s %-10s %s\n", status, check, fmt.Sprintf(format, args...))
+}
+
+func (t *selfTest) ok(check string, format string, args ...any) {
+	t.report("ok", check, format, args...)
+}
+
+func (t *selfTest) warn(check string, format string, args ...any) {
+	t.warnings++
+	t.report("WARN", check, format, args...)
+}
+
+func (t *selfTest) fail(check string, format string, args ...any) {
+	t.failures++
+	t.report("FAIL", check, format, args...)
+}
+
+// runSelfTest checks the configuration, identity provider, database and
+// static files, writes a report to out, and returns whether all checks
+// passed. Warnings do not fail the self-test.
+func runSelfTest(ctx context.Context, out io.Writer, demo bool) bool {
+	t := &selfTest{out: out}
+	authMode := strings.ToLower(strings.TrimSpace(os.Getenv("SERVER_AUTH_MODE")))
+	t.checkConfig(authMode)
+	t.checkAuth(ctx, authMode)
+	t.checkDatabase(ctx, demo)
+	t.checkStatic()
+	if t.failures > 0 {
+		fmt.Fprintf(out, "self-test failed: %d failure(s), %d warning(s)\n", t.failures, t.warnings)
+		return false
+	}
+	fmt.Fprintf(out, "self-test passed with %d warning(s)\n", t.warnings)
+	return true
+}
+
+func (t *selfTest) checkConfig(authMode string) {
+	if port := os.Getenv("PORT"); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			t.fail("config", "PORT=%q is not a valid port", port)
+		}
+	}
+
+	for _, key := range []string{"SERVER_LISTEN_ADDRS", "SERVER_ADMIN_LISTEN_ADDRS"} {
+		for _, addr := range envList(key) {
+			if _, port, err := net.SplitHostPort(addr); err != nil || port == "" {
+				t.fail("config", "%s: %q is not a host:port address", key, addr)
+			}
+		}
+	}
+
+	switch authMode {
+	case "dev":
+		t.warn("config", "SERVER_AUTH_MODE=dev trusts every request as %q; do not use it in production", envOr("SERVER_DEV_USER_ID", "dev-user"))
+	case "none":
+		t.warn("config", "SERVER_AUTH_MODE=none: every request shares one dataset without login")
+	case "", "passkey", "oidc":
+	default:
+		t.warn("config", "unknown SERVER_AUTH_MODE=%q is treated as OIDC", authMode)
+	}
+	if authMode != "dev" && len(envList("SERVER_ADMIN_LISTEN_ADDRS")) == 0 && len(envList("SERVER_ADMIN_USERS")) == 0 {
+		t.warn("config", "neither SERVER_ADMIN_LISTEN_ADDRS nor SERVER_ADMIN_USERS is set; nobody can use /admin/*")
+	}
+	if authMode != "dev" && authMode != "none" && os.Getenv("SERVER_SESSION_KEY") == "" {
+		t.warn("config", "SERVER_SESSION_KEY is unset; sessions end at every restart")
+	}
+	if signupConfig().Enabled() && (authMode == "dev" || authMode == "none" || authMode == "passkey") {
+		t.warn("config", "SERVER_SIGNUP_* only gate OIDC logins and are ignored with SERVER_AUTH_MODE=%s", authMode)
+	}
+
+	if _, err := features.Parse(os.Getenv("SERVER_FEATURES")); err != nil {
+		t.fail("config", "SERVER_FEATURES: %v", err)
+	}
+	for _, key := range []string{"SERVER_ADMIN_ALLOW_CIDRS", "SERVER_ADMIN_DENY_CIDRS", "SERVER_TRUSTED_PROXY_CIDRS"} {
+		if _, err := ipfilter.ParsePrefixes(envList(key)); err != nil {
+			t.fail("config", "%s: %v", key, err)
+		}
+	}
+	if _, err := limiter.ParseEndpoints(os.Getenv("SERVER_MAX_IN_FLIGHT_ENDPOINTS")); err != nil {
+		t.fail("config", "SERVER_MAX_IN_FLIGHT_ENDPOINTS: %v", err)
+	}
+	if _, err := slo.ParseObjectives(os.Getenv("SERVER_SLOS")); err != nil {
+		t.fail("config", "SERVER_SLOS: %v", err)
+	}
+	if target := os.Getenv("SERVER_SLO_ALERT_WEBHOOK"); target != "" {
+		if _, err := slo.NewWebhook(target); err != nil {
+			t.fail("config", "SERVER_SLO_ALERT_WEBHOOK: %v", err)
+		} else if os.Getenv("SERVER_SLOS") == "" {
+			t.warn("config", "SERVER_SLO_ALERT_WEBHOOK is set but SERVER_SLOS defines no objectives to alert on")
+		}
+	}
+	if dsn := os.Getenv("SERVER_SENTRY_DSN"); dsn != "" {
+		if _, err := sentry.New(dsn); err != nil {
+			t.fail("config", "SERVER_SENTRY_DSN: %v", err)
+		}
+	} else if os.Getenv("SERVER_SENTRY_ENVIRONMENT") != "" {
+		t.warn("config", "SERVER_SENTRY_ENVIRONMENT is ignored without SERVER_SENTRY_DSN")
+	}
+	if cfg, err := chaos.Parse(os.Getenv("SERVER_CHAOS")); err != nil {
+		t.fail("config", "SERVER_CHAOS: %v", err)
+	} else if cfg.Enabled() && authMode != "dev" && authMode != "none" {
+		t.fail("config", "SERVER_CHAOS requires SERVER_AUTH_MODE=dev or none")
+	} else if cfg.Enabled() {
+		t.warn("config", "SERVER_CHAOS injects failures into /sync/*; do not use it in production")
+	}
+	if target := os.Getenv("SERVER_STATS_EXPORT"); target != "" {
+		if _, err := stats.ParseSink(target); err != nil {
+			t.fail("config", "SERVER_STATS_EXPORT: %v", err)
+		}
+	}
+	if value := os.Getenv("SERVER_SQLITE_INTEGRITY_CHECK"); value != "" {
+		if _, err := storage.ParseIntegrityCheck(value); err != nil {
+			t.fail("config", "SERVER_SQLITE_INTEGRITY_CHECK: %v", err)
+		}
+	}
+	if tuning, err := sqliteTuning(); err != nil {
+		t.fail("config", "%v", err)
+	} else if tuning.Synchronous == storage.SynchronousOff {
+		t.warn("config", "SERVER_SQLITE_SYNCHRONOUS=off can corrupt the database on power loss")
+	}
+	for _, key := range []string{
+		"SERVER_SESSION_TTL_SECONDS",
+		"SERVER_SESSION_IDLE_TIMEOUT_SECONDS",
+		"SERVER_SNAPSHOT_MAX_OPS",
+		"SERVER_SNAPSHOT_MAX_OP_BYTES",
+		"SERVER_SNAPSHOT_CHUNK_BYTES",
+		"SERVER_OP_COMPRESSION_MIN_BYTES",
+		"SERVER_NOTE_MAX_BYTES",
+		"SERVER_SNAPSHOT_OFFLOAD_MIN_BYTES",
+		"SERVER_SQLITE_CHECKPOINT_INTERVAL_SECONDS",
+		"SERVER_SQLITE_CACHE_BYTES",
+		"SERVER_DB_USER_MAX_OPEN",
+		"SERVER_SQLITE_MMAP_BYTES",
+		"SERVER_SQLITE_JOURNAL_SIZE_LIMIT_BYTES",
+		"SERVER_DIGEST_INTERVAL_SECONDS",
+		"SERVER_MAX_IN_FLIGHT",
+		"SERVER_MAX_IN_FLIGHT_WAIT_MS",
+		"SERVER_STORAGE_RETRY_ATTEMPTS",
+		"SERVER_STORAGE_BREAKER_THRESHOLD",
+		"SERVER_STORAGE_BREAKER_COOLDOWN_MS",
+		"SERVER_QUARANTINE_THRESHOLD",
+		"SERVER_CONFLICT_LOOP_THRESHOLD",
+		"SERVER_PUSH_SPOOL_REPLAY_SECONDS",
+		"SERVER_OUTBOX_INTERVAL_SECONDS",
+		"SERVER_BACKUP_KEEP",
+		"SERVER_BACKUP_MAX_AGE_DAYS",
+		"SERVER_EXPORT_INTERVAL_SECONDS",
+		"SERVER_SIGNUP_MAX_PER_HOUR",
+		"SERVER_SIGNATURE_WINDOW_SECONDS",
+	} {
+		if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+			if n, err := strconv.ParseInt(value, 10, 64); err != nil || n < 0 {
+				t.warn("config", "%s=%q is not a non-negative integer and will be ignored", key, value)
+			}
+		}
+	}
+	if smtpAddr := os.Getenv("SERVER_SMTP_ADDR"); smtpAddr != "" {
+		if _, err := mail.NewSMTPSender(mail.SMTPConfig{
+			Addr:     smtpAddr,
+			From:     os.Getenv("SERVER_SMTP_FROM"),
+			Username: os.Getenv("SERVER_SMTP_USERNAME"),
+			Password: os.Getenv("SERVER_SMTP_PASSWORD"),
+		}); err != nil {
+			t.fail("config", "SERVER_SMTP_ADDR: %v", err)
+		}
+	}
+	if len(envList("SERVER_INTEGRITY_REPORT_EMAIL")) > 0 && os.Getenv("SERVER_SMTP_ADDR") == "" {
+		t.fail("config", "SERVER_INTEGRITY_REPORT_EMAIL needs SERVER_SMTP_ADDR")
+	}
+	if target := os.Getenv("SERVER_INTEGRITY_REPORT_WEBHOOK"); target != "" {
+		if _, err := integrity.NewWebhook(target); err != nil {
+			t.fail("config", "SERVER_INTEGRITY_REPORT_WEBHOOK: %v", err)
+		}
+	}
+	if dir := os.Getenv("SERVER_EMAIL_TEMPLATE_DIR"); dir != "" {
+		templates := mail.Builtin()
+		if err := templates.LoadDir(dir); err != nil {
+			t.fail("config", "SERVER_EMAIL_TEMPLATE_DIR: %v", err)
+		} else if _, err := digest.Compose(templates, digest.Sample(), i18n.Builtin().Printer()); err != nil {
+			// Parsing does not catch fields the templates get wrong.
+			t.fail("config", "SERVER_EMAIL_TEMPLATE_DIR: digest: %v", err)
+		}
+	}
+	if _, err := backupDestination(); err != nil {
+		t.fail("config", "backups: %v", err)
+	}
+	if _, err := backupSchedule(); err != nil {
+		t.fail("config", "SERVER_BACKUP_SCHEDULE: %v", err)
+	}
+	if recipients, identity, err := backupEncryption(); err != nil {
+		t.fail("config", "backups: %v", err)
+	} else if len(recipients) > 0 && identity == nil {
+		t.warn("config", "backups are encrypted without SERVER_BACKUP_AGE_IDENTITY_FILE; they are only checked before encryption and cannot be restored through /admin/backups")
+	}
+	if key := os.Getenv("SERVER_EXPORT_KEY"); key != "" {
+		if _, err := export.NewSealer(key); err != nil {
+			t.fail("config", "SERVER_EXPORT_KEY: %v", err)
+		}
+		if envBoolDefault("SERVER_EXPORT_ALLOW_PRIVATE_TARGETS", false) {
+			t.warn("config", "SERVER_EXPORT_ALLOW_PRIVATE_TARGETS lets every user make the server send requests into its own network")
+		}
+	}
+	if dir := os.Getenv("SERVER_MESSAGES_DIR"); dir != "" {
+		if err := i18n.Builtin().LoadDir(dir); err != nil {
+			t.fail("config", "SERVER_MESSAGES_DIR: %v", err)
+		}
+	}
+	if os.Getenv("SERVER_PAYLOAD_DIR") != "" && os.Getenv("SERVER_SNAPSHOT_S3_ENDPOINT") != "" {
+		t.fail("config", "SERVER_PAYLOAD_DIR and SERVER_SNAPSHOT_S3_ENDPOINT both decide where snapshots live; set only one")
+	}
+	if endpoint := os.Getenv("SERVER_SNAPSHOT_S3_ENDPOINT"); endpoint != "" {
+		if _, err := blobstore.NewS3(blobstore.S3Config{
+			Endpoint:        endpoint,
+			Region:          os.Getenv("SERVER_SNAPSHOT_S3_REGION"),
+			Bucket:          os.Getenv("SERVER_SNAPSHOT_S3_BUCKET"),
+			AccessKeyID:     os.Getenv("SERVER_SNAPSHOT_S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("SERVER_SNAPSHOT_S3_SECRET_ACCESS_KEY"),
+		}); err != nil {
+			t.fail("config", "snapshot offload: %v", err)
+		}
+	}
+}
+
+func (t *selfTest) checkDatabase(ctx context.Context, demo bool) {
+	if demo {
+		t.ok("database", "demo mode keeps data in memory")
+		return
+	}
+	if userDir := os.Getenv("SERVER_DB_USER_DIR"); userDir != "" {
+		if info, err := os.Stat(userDir); err == nil && !info.IsDir() {
+			t.fail("database", "SERVER_DB_USER_DIR %s is not a directory", userDir)
+		} else if err != nil && !os.IsNotExist(err) {
+			t.fail("database", "SERVER_DB_USER_DIR %s: %v", userDir, err)
+		} else {
+			t.ok("database", "user data is kept in one file per user in %s", userDir)
+		}
+	}
+	dbPath := envOr("SERVER_DB_PATH", "data.db")
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		// Opening would create the file, so only check that it can be.
+		dir := filepath.Dir(dbPath)
+		if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+			t.fail("database", "%s is not a directory", dir)
+			return
+		}
+		t.ok("database", "%s does not exist yet and will be created at startup", dbPath)
+		return
+	} else if err != nil {
+		t.fail("database", "%v", err)
+		return
+	}
+	store, err := storage.OpenSQLite(dbPath)
+	if err != nil {
+		t.fail("database", "open %s: %v", dbPath, err)
+		return
+	}
+	defer func() { _ = store.Close() }()
+	plan, err := store.PlanMigrations(ctx)
+	if err != nil {
+		t.fail("database", "%s: %v", dbPath, err)
+		return
+	}
+	switch {
+	case plan.NewDatabase:
+		t.ok("database", "%s is empty; startup creates %d tables", dbPath, len(plan.Tables))
+	case plan.Pending():
+		t.ok("database", "%s opened; startup migrates it (renamed tables: %s; new tables: %s; new columns: %s)", dbPath, listOrNone(plan.Renames), listOrNone(plan.Tables), listOrNone(plan.Columns))
+	default:
+		t.ok("database", "%s opened; schema is up to date", dbPath)
+	}
+}
+
+// checkAuth runs OIDC discovery when OIDC is used and then builds the auth
+// manager the server would use.
+func (t *selfTest) checkAuth(ctx context.Context, authMode string) {
+	issuerURL := os.Getenv("OIDC_ISSUER_URL")
+	if authMode != "dev" && authMode != "none" && authMode != "passkey" && issuerURL != "" {
+		// The OIDC manager runs discovery itself and panics when it fails, so
+		// it is only built once discovery worked.
+		discoveryCtx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+		defer cancel()
+		provider, err := oidc.NewProvider(discoveryCtx, issuerURL)
+		if err != nil {
+			t.fail("oidc", "discovery for %s: %v", issuerURL, err)
+			return
+		}
+		t.ok("oidc", "discovered %s (authorization endpoint %s)", issuerURL, provider.Endpoint().AuthURL)
+	}
+	// Managers are built against an in-memory store so the database is only
+	// touched by checkDatabase.
+	if _, err := newAuthManager(authMode, storage.NewMemoryStore(), nil); err != nil {
+		t.fail("auth", "%v", err)
+		return
+	}
+	t.ok("auth", "auth mode %s", envOr("SERVER_AUTH_MODE", "oidc"))
+}
+
+func (t *selfTest) checkStatic() {
+	if staticDir := os.Getenv("SERVER_STATIC_DIR"); staticDir != "" {
+		if _, err := os.Stat(filepath.Join(staticDir, "index.html")); err != nil {
+			t.fail("static", "SERVER_STATIC_DIR: %v", err)
+			return
+		}
+		t.ok("static", "serving %s", staticDir)
+		return
+	}
+	if _, err := fs.Stat(staticFS, "static/index.html"); err != nil {
+		t.warn("static", "no static files (set SERVER_STATIC_DIR or build with embedded files)")
+		return
+	}
+	t.ok("static", "serving embedded files")
+}
+
+func envOr(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func listOrNone(values []string) string {
+	if len(values) == 0 {
+		return "none"
+	}
+	return strings.Join(values, ", ")
+}
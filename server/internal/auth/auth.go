@@ -7,6 +7,8 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -23,16 +25,51 @@ const (
 )
 
 type Config struct {
-	IssuerURL      string
-	ClientID       string
-	ClientSecret   string
-	RedirectURL    string
-	SessionKey     string
-	SessionTTL     time.Duration
-	CookieSecure   bool
-	CookieSameSite http.SameSite
-	CookieDomain   string
-	FallbackURL    string
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	SessionKey   string
+	// SessionTTL is the absolute session lifetime, counted from login.
+	SessionTTL time.Duration
+	// SessionIdleTimeout ends sessions that made no authenticated request for
+	// this long. Zero disables the idle check.
+	SessionIdleTimeout time.Duration
+	CookieSecure       bool
+	CookieSameSite     http.SameSite
+	CookieDomain       string
+	FallbackURL        string
+	// CSRFMode selects the CSRF protection for cookie-authenticated requests.
+	// Empty selects CSRFOrigin.
+	CSRFMode CSRFMode
+	// UserIDClaim selects the ID token claim used as the stable user id:
+	// "sub" (default), "email", or "preferred_username".
+	UserIDClaim string
+	// PreviousUserIDClaim, when set, names the claim that was used as user id
+	// before UserIDClaim. At login the user's data is moved from the previous
+	// id to the new one through MigrateUserID.
+	PreviousUserIDClaim string
+	// MigrateUserID moves a user's data from one id to another. It is required
+	// when PreviousUserIDClaim is set; an error fails the login so data is
+	// never silently orphaned.
+	MigrateUserID func(ctx context.Context, fromUserID string, toUserID string) error
+	// OnLogin, when set, receives the user's profile claims after every
+	// successful login. Errors are logged and do not block the login.
+	OnLogin func(ctx context.Context, userID string, profile Profile) error
+	// Admit, when set, decides whether an OIDC login may proceed, before
+	// OnLogin stores anything. Errors wrapping ErrSignupRefused answer 403
+	// with their text; other errors fail the login.
+	Admit func(ctx context.Context, signup Signup) error
+}
+
+// Profile is the identity taken from the ID token's standard claims.
+type Profile struct {
+	Email       string
+	DisplayName string
+	AvatarURL   string
+	Locale      string
+	// TimeZone is the zoneinfo claim, an IANA time zone name.
+	TimeZone string
 }
 
 type Manager struct {
@@ -40,16 +77,91 @@ type Manager struct {
 	sessionStore  *sessions.CookieStore
 	cookieOptions *sessions.Options
 	fallbackURL   string
+	userIDClaim   string
+	previousClaim string
+	migrateUserID func(ctx context.Context, fromUserID string, toUserID string) error
+	onLogin       func(ctx context.Context, userID string, profile Profile) error
+	admit         func(ctx context.Context, signup Signup) error
+	passkeys      *passkeyAuth
+	sessionTTL    time.Duration
+	idleTimeout   time.Duration
+	now           func() time.Time
+	csrfMode      CSRFMode
+}
+
+// sessionTouchInterval limits how often activity is written back to the
+// session cookie, so busy clients do not get a Set-Cookie on every request.
+const sessionTouchInterval = time.Minute
+
+// Claims that may serve as the stable user id.
+const (
+	ClaimSubject           = "sub"
+	ClaimEmail             = "email"
+	ClaimPreferredUsername = "preferred_username"
+)
+
+// ParseUserIDClaim validates a user id claim name. Empty selects "sub".
+func ParseUserIDClaim(value string) (string, error) {
+	switch claim := strings.TrimSpace(value); claim {
+	case "":
+		return ClaimSubject, nil
+	case ClaimSubject, ClaimEmail, ClaimPreferredUsername:
+		return claim, nil
+	default:
+		return "", fmt.Errorf("unsupported user id claim %q (want sub, email, or preferred_username)", value)
+	}
 }
 
 func NewManager(cfg Config) (*Manager, error) {
 	if cfg.IssuerURL == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
 		return nil, errors.New("oidc issuer, client id, and redirect url are required")
 	}
-	masterKey, err := parseSessionKey(cfg.SessionKey)
+	userIDClaim, err := ParseUserIDClaim(cfg.UserIDClaim)
 	if err != nil {
 		return nil, err
 	}
+	previousClaim := ""
+	if cfg.PreviousUserIDClaim != "" {
+		if previousClaim, err = ParseUserIDClaim(cfg.PreviousUserIDClaim); err != nil {
+			return nil, err
+		}
+		if previousClaim == userIDClaim {
+			previousClaim = ""
+		} else if cfg.MigrateUserID == nil {
+			return nil, errors.New("previous user id claim requires a user id migration")
+		}
+	}
+	// The identity provider redirects back cross-site, so a SameSite=Strict
+	// session cookie would be withheld right after login.
+	if cfg.CSRFMode == CSRFSameSiteStrict {
+		return nil, errors.New("samesite-strict csrf mode is not supported with oidc login")
+	}
+	store, options, err := newSessionStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{
+		oidcConfig:    baseliboidc.CreateOidcConfiguration(cfg.IssuerURL, cfg.ClientID, cfg.ClientSecret, cfg.RedirectURL),
+		sessionStore:  store,
+		cookieOptions: options,
+		fallbackURL:   cfg.FallbackURL,
+		userIDClaim:   userIDClaim,
+		previousClaim: previousClaim,
+		migrateUserID: cfg.MigrateUserID,
+		onLogin:       cfg.OnLogin,
+		admit:         cfg.Admit,
+		sessionTTL:    time.Duration(options.MaxAge) * time.Second,
+		idleTimeout:   cfg.SessionIdleTimeout,
+		now:           time.Now,
+		csrfMode:      cfg.CSRFMode,
+	}, nil
+}
+
+func newSessionStore(cfg Config) (*sessions.CookieStore, *sessions.Options, error) {
+	masterKey, err := parseSessionKey(cfg.SessionKey)
+	if err != nil {
+		return nil, nil, err
+	}
 	hashKey, blockKey := deriveCookieKeys(masterKey)
 	store := sessions.NewCookieStore(hashKey, blockKey)
 	if cfg.SessionTTL == 0 {
@@ -58,6 +170,9 @@ func NewManager(cfg Config) (*Manager, error) {
 	if cfg.CookieSameSite == 0 {
 		cfg.CookieSameSite = http.SameSiteLaxMode
 	}
+	if cfg.CSRFMode == CSRFSameSiteStrict {
+		cfg.CookieSameSite = http.SameSiteStrictMode
+	}
 	options := &sessions.Options{
 		Path:     "/",
 		MaxAge:   int(cfg.SessionTTL.Seconds()),
@@ -68,13 +183,7 @@ func NewManager(cfg Config) (*Manager, error) {
 	}
 	store.Options = options
 	store.MaxAge(options.MaxAge)
-
-	return &Manager{
-		oidcConfig:    baseliboidc.CreateOidcConfiguration(cfg.IssuerURL, cfg.ClientID, cfg.ClientSecret, cfg.RedirectURL),
-		sessionStore:  store,
-		cookieOptions: options,
-		fallbackURL:   cfg.FallbackURL,
-	}, nil
+	return store, options, nil
 }
 
 func (m *Manager) OIDCMiddleware(skipper func(r *http.Request) bool) func(http.Handler) http.Handler {
@@ -83,7 +192,17 @@ func (m *Manager) OIDCMiddleware(skipper func(r *http.Request) bool) func(http.H
 
 func (m *Manager) CallbackHandler() http.Handler {
 	delegate := baseliboidc.CreateSTDSessionBasedOidcDelegate(m.handleIDToken, m.fallbackURL)
-	return m.oidcConfig.CreateOidcCallbackHandler(delegate)
+	return m.oidcConfig.CreateOidcCallbackHandler(func(w http.ResponseWriter, r *http.Request, idToken *oidc.IDToken, state string) error {
+		// Refusals are answered here: the delegate turns every error into a
+		// bare 500.
+		if err := m.admitIDToken(r, idToken); err != nil {
+			if refuseLogin(w, err) {
+				return nil
+			}
+			return err
+		}
+		return delegate(w, r, idToken, state)
+	})
 }
 
 func (m *Manager) LoginHandler() http.HandlerFunc {
@@ -116,6 +235,7 @@ func (m *Manager) WithUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		userID, _ := m.userIDFromSession(r)
 		if userID != "" {
+			m.touchSession(w, r)
 			ctx := context.WithValue(r.Context(), userIDContextKey, userID)
 			r = r.WithContext(ctx)
 		}
@@ -153,25 +273,104 @@ func DevUserMiddleware(userID string) func(http.Handler) http.Handler {
 	}
 }
 
-func (m *Manager) handleIDToken(w http.ResponseWriter, r *http.Request, idToken *oidc.IDToken) error {
-	var claims struct {
-		Subject string `json:"sub"`
+// AnonymousUserID owns the single dataset of deployments without
+// authentication.
+const AnonymousUserID = "anonymous"
+
+// AnonymousMiddleware serves every request as AnonymousUserID, so all browsers
+// and devices share one dataset. Unlike DevUserMiddleware it is meant for real
+// (home) deployments, so it rejects cross-origin browser writes: without a
+// session there is nothing else stopping other sites from changing the data.
+func AnonymousMiddleware() func(http.Handler) http.Handler {
+	protection := http.NewCrossOriginProtection()
+	return func(next http.Handler) http.Handler {
+		return protection.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := ContextWithUserID(r.Context(), AnonymousUserID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}))
 	}
+}
+
+func (m *Manager) handleIDToken(w http.ResponseWriter, r *http.Request, idToken *oidc.IDToken) error {
+	var claims idTokenClaims
 	if err := idToken.Claims(&claims); err != nil {
 		return err
 	}
 	if claims.Subject == "" {
 		return errors.New("id token missing sub claim")
 	}
+	userID, err := claims.userID(m.userIDClaim)
+	if err != nil {
+		return err
+	}
+	if m.previousClaim != "" {
+		if previousUserID, err := claims.userID(m.previousClaim); err == nil && previousUserID != userID {
+			if err := m.migrateUserID(r.Context(), previousUserID, userID); err != nil {
+				return fmt.Errorf("migrate user id: %w", err)
+			}
+		}
+	}
+	if m.onLogin != nil {
+		profile := Profile{Email: claims.Email, DisplayName: claims.Name, AvatarURL: claims.Picture, Locale: claims.Locale, TimeZone: claims.Zoneinfo}
+		if profile.DisplayName == "" {
+			profile.DisplayName = claims.PreferredUsername
+		}
+		if err := m.onLogin(r.Context(), userID, profile); err != nil {
+			log.Printf("auth login profile update failed: %v", err)
+		}
+	}
+	m.clearInviteCookie(w, r)
+	return m.establishSession(w, r, userID)
+}
+
+// establishSession records userID as the signed-in user.
+func (m *Manager) establishSession(w http.ResponseWriter, r *http.Request, userID string) error {
 	session, err := m.sessionStore.Get(r, baseliboidc.STDSessionCookieName)
 	if err != nil {
 		return err
 	}
+	now := m.now().Unix()
 	session.Options = cloneOptions(m.cookieOptions)
-	session.Values["user_id"] = claims.Subject
+	session.Values["user_id"] = userID
+	session.Values["user_id_claim"] = m.userIDClaim
+	session.Values["created_at"] = now
+	session.Values["last_seen_at"] = now
 	return session.Save(r, w)
 }
 
+type idTokenClaims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	Picture           string `json:"picture"`
+	Locale            string `json:"locale"`
+	Zoneinfo          string `json:"zoneinfo"`
+}
+
+// userID returns the value of the given claim. An email only identifies a
+// user once the identity provider has verified it; otherwise anyone could
+// register the address and take over the account.
+func (c idTokenClaims) userID(claim string) (string, error) {
+	var value string
+	switch claim {
+	case ClaimEmail:
+		if !c.EmailVerified {
+			return "", errors.New("id token email is not verified")
+		}
+		value = strings.ToLower(strings.TrimSpace(c.Email))
+	case ClaimPreferredUsername:
+		value = strings.TrimSpace(c.PreferredUsername)
+	default:
+		value = c.Subject
+	}
+	if value == "" {
+		return "", fmt.Errorf("id token missing %s claim", claim)
+	}
+	return value, nil
+}
+
 func (m *Manager) userIDFromSession(r *http.Request) (string, bool) {
 	session, err := m.sessionStore.Get(r, baseliboidc.STDSessionCookieName)
 	if err != nil {
@@ -185,9 +384,50 @@ func (m *Manager) userIDFromSession(r *http.Request) (string, bool) {
 	if !ok || userID == "" {
 		return "", false
 	}
+	// Sessions issued under a different identity claim hold an id the user's
+	// data may already have moved away from; force a fresh login instead.
+	claim, _ := session.Values["user_id_claim"].(string)
+	if claim == "" {
+		claim = ClaimSubject
+	}
+	if claim != m.userIDClaim {
+		return "", false
+	}
+	now := m.now()
+	if createdAt, ok := session.Values["created_at"].(int64); ok && now.Sub(time.Unix(createdAt, 0)) > m.sessionTTL {
+		return "", false
+	}
+	if lastSeenAt, ok := session.Values["last_seen_at"].(int64); ok && m.idleTimeout > 0 && now.Sub(time.Unix(lastSeenAt, 0)) > m.idleTimeout {
+		return "", false
+	}
 	return userID, true
 }
 
+// touchSession records activity on a valid session. The cookie keeps expiring
+// at the end of the absolute TTL rather than sliding with every save.
+func (m *Manager) touchSession(w http.ResponseWriter, r *http.Request) {
+	session, err := m.sessionStore.Get(r, baseliboidc.STDSessionCookieName)
+	if err != nil {
+		return
+	}
+	now := m.now()
+	if lastSeenAt, ok := session.Values["last_seen_at"].(int64); ok && now.Sub(time.Unix(lastSeenAt, 0)) < sessionTouchInterval {
+		return
+	}
+	createdAt, ok := session.Values["created_at"].(int64)
+	if !ok {
+		// Sessions from before the idle timeout start their TTL now.
+		createdAt = now.Unix()
+		session.Values["created_at"] = createdAt
+	}
+	session.Values["last_seen_at"] = now.Unix()
+	session.Options = cloneOptions(m.cookieOptions)
+	session.Options.MaxAge = max(int(m.sessionTTL.Seconds()-float64(now.Unix()-createdAt)), 1)
+	if err := session.Save(r, w); err != nil {
+		log.Printf("auth session touch failed: %v", err)
+	}
+}
+
 func parseSessionKey(raw string) ([]byte, error) {
 	if raw == "" {
 		key := make([]byte, 32)
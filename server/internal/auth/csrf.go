@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	baselibmiddleware "github.com/aggregat4/go-baselib-services/v4/middleware"
+	baseliboidc "github.com/aggregat4/go-baselib-services/v4/oidc"
+)
+
+// CSRFMode selects how cookie-authenticated requests are protected against
+// cross-site request forgery.
+type CSRFMode string
+
+const (
+	// CSRFOrigin rejects unsafe requests whose Origin host differs from the
+	// target host.
+	CSRFOrigin CSRFMode = "origin"
+	// CSRFDoubleSubmit requires unsafe requests to echo the csrf_token cookie
+	// in the X-CSRF-Token header. Cross-site pages cannot read the cookie.
+	CSRFDoubleSubmit CSRFMode = "double-submit"
+	// CSRFSameSiteStrict marks the session cookie SameSite=Strict so browsers
+	// never attach it to cross-site requests.
+	CSRFSameSiteStrict CSRFMode = "samesite-strict"
+)
+
+const (
+	CSRFCookieName = "csrf_token"
+	CSRFHeaderName = "X-CSRF-Token"
+)
+
+// ParseCSRFMode validates a CSRF mode name. Empty selects "origin".
+func ParseCSRFMode(value string) (CSRFMode, error) {
+	switch mode := CSRFMode(strings.TrimSpace(value)); mode {
+	case "":
+		return CSRFOrigin, nil
+	case CSRFOrigin, CSRFDoubleSubmit, CSRFSameSiteStrict:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unsupported csrf mode %q (want origin, double-submit, or samesite-strict)", value)
+	}
+}
+
+// CSRFMiddleware applies the configured CSRF strategy to requests that
+// carry the session cookie. Other unsafe requests must not come from a
+// cross-origin page either, since browsers attach ambient credentials such
+// as a client certificate or a network position without a cookie; only
+// calls with an Authorization header, which a page cannot forge, are exempt.
+func (m *Manager) CSRFMiddleware(next http.Handler) http.Handler {
+	origin := baselibmiddleware.CsrfMiddlewareStd(next)
+	protection := http.NewCrossOriginProtection()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.csrfMode == CSRFDoubleSubmit {
+			m.ensureCSRFCookie(w, r)
+		}
+		if isSafeMethod(r.Method) || r.Header.Get("Authorization") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !hasSessionCookie(r) {
+			if err := protection.Check(r); err != nil {
+				log.Printf("CSRF check failed: %v", err)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch m.csrfMode {
+		case CSRFDoubleSubmit:
+			if !validCSRFToken(r) {
+				log.Printf("CSRF check failed: missing or mismatched %s header", CSRFHeaderName)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		case CSRFSameSiteStrict:
+			next.ServeHTTP(w, r)
+		default:
+			origin.ServeHTTP(w, r)
+		}
+	})
+}
+
+func (m *Manager) ensureCSRFCookie(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(CSRFCookieName); err == nil && cookie.Value != "" {
+		return
+	}
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		log.Printf("csrf token generation failed: %v", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookieName,
+		Value:    base64.RawURLEncoding.EncodeToString(token),
+		Path:     "/",
+		Domain:   m.cookieOptions.Domain,
+		MaxAge:   m.cookieOptions.MaxAge,
+		Secure:   m.cookieOptions.Secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func validCSRFToken(r *http.Request) bool {
+	cookie, err := r.Cookie(CSRFCookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	header := r.Header.Get(CSRFHeaderName)
+	return subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) == 1
+}
+
+func hasSessionCookie(r *http.Request) bool {
+	_, err := r.Cookie(baseliboidc.STDSessionCookieName)
+	return err == nil
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	baseliboidc "github.com/aggregat4/go-baselib-services/v4/oidc"
+)
+
+func csrfRequest(manager *Manager, req *http.Request) int {
+	handler := manager.CSRFMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder.Code
+}
+
+func sessionCookie() *http.Cookie {
+	return &http.Cookie{Name: baseliboidc.STDSessionCookieName, Value: "session"}
+}
+
+func TestCSRFDoubleSubmitRequiresMatchingHeader(t *testing.T) {
+	manager, _ := newTestPasskeyManager(t, false)
+	manager.csrfMode = CSRFDoubleSubmit
+
+	recorder := httptest.NewRecorder()
+	manager.CSRFMiddleware(http.NotFoundHandler()).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	var token *http.Cookie
+	for _, cookie := range recorder.Result().Cookies() {
+		if cookie.Name == CSRFCookieName {
+			token = cookie
+		}
+	}
+	if token == nil || token.Value == "" || token.HttpOnly {
+		t.Fatalf("expected a script-readable csrf cookie, got %+v", token)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/sync/push", nil)
+	req.AddCookie(sessionCookie())
+	req.AddCookie(token)
+	if code := csrfRequest(manager, req); code != http.StatusForbidden {
+		t.Fatalf("expected 403 without header, got %d", code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/sync/push", nil)
+	req.AddCookie(sessionCookie())
+	req.AddCookie(token)
+	req.Header.Set(CSRFHeaderName, "forged")
+	if code := csrfRequest(manager, req); code != http.StatusForbidden {
+		t.Fatalf("expected 403 with mismatched header, got %d", code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/sync/push", nil)
+	req.AddCookie(sessionCookie())
+	req.AddCookie(token)
+	req.Header.Set(CSRFHeaderName, token.Value)
+	if code := csrfRequest(manager, req); code != http.StatusNoContent {
+		t.Fatalf("expected matching header to pass, got %d", code)
+	}
+}
+
+func TestCSRFOriginCheckExemptsOnlyTokenAuthenticatedRequests(t *testing.T) {
+	manager, _ := newTestPasskeyManager(t, false)
+
+	req := httptest.NewRequest(http.MethodPost, "http://lists.example.com/sync/push", nil)
+	req.Header.Set("Origin", "https://evil.example.net")
+	req.AddCookie(sessionCookie())
+	if code := csrfRequest(manager, req); code != http.StatusForbidden {
+		t.Fatalf("expected cross-origin cookie request to be rejected, got %d", code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "http://lists.example.com/sync/push", nil)
+	req.Header.Set("Origin", "https://evil.example.net")
+	req.Header.Set("Authorization", "Bearer token")
+	if code := csrfRequest(manager, req); code != http.StatusNoContent {
+		t.Fatalf("expected token-authenticated request to be exempt, got %d", code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "http://lists.example.com/admin/settings", nil)
+	req.Header.Set("Origin", "https://evil.example.net")
+	req.Header.Set("Sec-Fetch-Site", "cross-site")
+	if code := csrfRequest(manager, req); code != http.StatusForbidden {
+		t.Fatalf("expected cross-origin request without a cookie to be rejected, got %d", code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "http://lists.example.com/admin/settings", nil)
+	if code := csrfRequest(manager, req); code != http.StatusNoContent {
+		t.Fatalf("expected non-browser request without a cookie to pass, got %d", code)
+	}
+}
+
+func TestCSRFSameSiteStrictMarksSessionCookie(t *testing.T) {
+	manager, err := NewPasskeyManager(Config{CSRFMode: CSRFSameSiteStrict}, PasskeyConfig{
+		RPID:      "lists.example.com",
+		RPOrigins: []string{"https://lists.example.com"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("new passkey manager: %v", err)
+	}
+	recorder := httptest.NewRecorder()
+	if err := manager.establishSession(recorder, httptest.NewRequest(http.MethodPost, "/", nil), "alice"); err != nil {
+		t.Fatalf("establish session: %v", err)
+	}
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Fatalf("expected a SameSite=Strict session cookie, got %+v", cookies)
+	}
+
+	_, err = NewManager(Config{
+		IssuerURL:   "https://issuer.example.com",
+		ClientID:    "client",
+		RedirectURL: "https://lists.example.com/auth/callback",
+		CSRFMode:    CSRFSameSiteStrict,
+	})
+	if err == nil {
+		t.Fatal("expected samesite-strict to be rejected for oidc login")
+	}
+}
+
+func TestParseCSRFMode(t *testing.T) {
+	if mode, err := ParseCSRFMode(""); err != nil || mode != CSRFOrigin {
+		t.Fatalf("expected empty mode to select origin, got %q, %v", mode, err)
+	}
+	if _, err := ParseCSRFMode("tokens"); err == nil {
+		t.Fatal("expected unknown mode to be rejected")
+	}
+}
+
+func TestAnonymousMiddlewareSharesOneUserAndRejectsCrossOriginWrites(t *testing.T) {
+	handler := AnonymousMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if userID, _ := UserIDFromContext(r.Context()); userID != AnonymousUserID {
+			t.Errorf("expected user %q, got %q", AnonymousUserID, userID)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(method string, fetchSite string) int {
+		req := httptest.NewRequest(method, "/sync/push", nil)
+		if fetchSite != "" {
+			req.Header.Set("Sec-Fetch-Site", fetchSite)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	if got := serve(http.MethodPost, "same-origin"); got != http.StatusNoContent {
+		t.Fatalf("same-origin write: got %d", got)
+	}
+	if got := serve(http.MethodPost, ""); got != http.StatusNoContent {
+		t.Fatalf("non-browser write: got %d", got)
+	}
+	if got := serve(http.MethodGet, "cross-site"); got != http.StatusNoContent {
+		t.Fatalf("cross-site read: got %d", got)
+	}
+	if got := serve(http.MethodPost, "cross-site"); got != http.StatusForbidden {
+		t.Fatalf("expected cross-site write to be rejected, got %d", got)
+	}
+}
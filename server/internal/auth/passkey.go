@@ -0,0 +1,436 @@
+package auth
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"a4-tasklists/server/internal/storage"
+
+	baseliboidc "github.com/aggregat4/go-baselib-services/v4/oidc"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// Passkey mode replaces the external identity provider with WebAuthn: users
+// sign up with a username and a passkey and later log in with the passkey
+// alone (discoverable credentials). The username is the user id and doubles as
+// the WebAuthn user handle, so a login assertion identifies its user directly.
+
+// PasskeyConfig configures passkey (WebAuthn) login.
+type PasskeyConfig struct {
+	// RPID is the relying party id, normally the site's host name.
+	RPID string
+	// RPDisplayName is shown by authenticators. Defaults to "Tasklists".
+	RPDisplayName string
+	// RPOrigins lists the origins allowed to run ceremonies, e.g.
+	// https://lists.example.com.
+	RPOrigins []string
+	// AllowSignup lets anyone create an account. The first account can always
+	// be created so a fresh deployment can be claimed.
+	AllowSignup bool
+	// TOTP decides who enters a code from an authenticator app after the
+	// passkey. Empty means TOTPOptional.
+	TOTP TOTPPolicy
+}
+
+// PasskeyStore persists passkeys; storage.Store satisfies it.
+type PasskeyStore interface {
+	ListPasskeys(ctx context.Context, userID string) ([]storage.Passkey, error)
+	AddPasskey(ctx context.Context, userID string, passkey storage.Passkey) error
+	UpdatePasskey(ctx context.Context, userID string, passkey storage.Passkey) error
+	CountPasskeys(ctx context.Context) (int64, error)
+	TOTPStore
+}
+
+// passkeyClaim marks sessions established through passkey login.
+const passkeyClaim = "passkey"
+
+// maxUsernameBytes is the WebAuthn limit for user handles.
+const maxUsernameBytes = 64
+
+const (
+	ceremonySessionKey  = "webauthn_session"
+	ceremonyUsernameKey = "webauthn_username"
+)
+
+//go:embed passkey_login.html
+var passkeyLoginPage []byte
+
+type passkeyAuth struct {
+	webauthn    *webauthn.WebAuthn
+	store       PasskeyStore
+	allowSignup bool
+	displayName string
+	totpPolicy  TOTPPolicy
+	failures    totpFailures
+}
+
+// NewPasskeyManager returns a Manager that authenticates users with passkeys.
+// The OIDC fields of cfg are ignored.
+func NewPasskeyManager(cfg Config, passkeyCfg PasskeyConfig, store PasskeyStore) (*Manager, error) {
+	if passkeyCfg.RPID == "" || len(passkeyCfg.RPOrigins) == 0 {
+		return nil, errors.New("passkey relying party id and origins are required")
+	}
+	if passkeyCfg.RPDisplayName == "" {
+		passkeyCfg.RPDisplayName = "Tasklists"
+	}
+	totpPolicy, err := ParseTOTPPolicy(string(passkeyCfg.TOTP))
+	if err != nil {
+		return nil, err
+	}
+	web, err := webauthn.New(&webauthn.Config{
+		RPID:          passkeyCfg.RPID,
+		RPDisplayName: passkeyCfg.RPDisplayName,
+		RPOrigins:     passkeyCfg.RPOrigins,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("webauthn config: %w", err)
+	}
+	sessionStore, options, err := newSessionStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{
+		sessionStore:  sessionStore,
+		cookieOptions: options,
+		fallbackURL:   cfg.FallbackURL,
+		userIDClaim:   passkeyClaim,
+		onLogin:       cfg.OnLogin,
+		sessionTTL:    time.Duration(options.MaxAge) * time.Second,
+		idleTimeout:   cfg.SessionIdleTimeout,
+		now:           time.Now,
+		csrfMode:      cfg.CSRFMode,
+		passkeys: &passkeyAuth{
+			webauthn:    web,
+			store:       store,
+			allowSignup: passkeyCfg.AllowSignup,
+			displayName: passkeyCfg.RPDisplayName,
+			totpPolicy:  totpPolicy,
+		},
+	}, nil
+}
+
+// PasskeyLoginPage serves the sign-in page with the WebAuthn browser calls.
+func (m *Manager) PasskeyLoginPage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write(passkeyLoginPage)
+	}
+}
+
+// PasskeyHandler serves the registration and login ceremonies, and the TOTP
+// second factor, under /auth/passkey/.
+func (m *Manager) PasskeyHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /auth/passkey/register/begin", m.beginPasskeyRegistration)
+	mux.HandleFunc("POST /auth/passkey/register/finish", m.finishPasskeyRegistration)
+	mux.HandleFunc("POST /auth/passkey/login/begin", m.beginPasskeyLogin)
+	mux.HandleFunc("POST /auth/passkey/login/finish", m.finishPasskeyLogin)
+	m.registerTOTPRoutes(mux)
+	return mux
+}
+
+// RequireLogin sends unauthenticated requests to the passkey sign-in page
+// (or answers 401 for non-GET requests) unless skipper matches.
+func (m *Manager) RequireLogin(skipper func(r *http.Request) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if skipper(r) || m.IsAuthenticated(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.Method == http.MethodGet {
+				http.Redirect(w, r, "/auth/login", http.StatusFound)
+				return
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+	}
+}
+
+func (m *Manager) beginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writePasskeyError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	// A signed-in user adds another passkey to their own account; everyone
+	// else signs up for a new account.
+	username, signedIn := m.userIDFromSession(r)
+	if !signedIn {
+		username = strings.TrimSpace(payload.Username)
+		if username == "" || len(username) > maxUsernameBytes {
+			writePasskeyError(w, http.StatusBadRequest, fmt.Sprintf("username must be 1 to %d bytes", maxUsernameBytes))
+			return
+		}
+		allowed, err := m.passkeys.signupAllowed(r.Context())
+		if err != nil {
+			log.Printf("passkey signup check failed: %v", err)
+			writePasskeyError(w, http.StatusInternalServerError, "signup unavailable")
+			return
+		}
+		if !allowed {
+			writePasskeyError(w, http.StatusForbidden, "signup is disabled")
+			return
+		}
+	}
+	user, err := m.passkeys.loadUser(r.Context(), username)
+	if err != nil {
+		log.Printf("passkey load user failed: %v", err)
+		writePasskeyError(w, http.StatusInternalServerError, "registration unavailable")
+		return
+	}
+	if !signedIn && len(user.credentials) > 0 {
+		// Only the account holder may add passkeys to an existing account.
+		writePasskeyError(w, http.StatusConflict, "username is taken")
+		return
+	}
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.credentials))
+	for _, credential := range user.credentials {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+	creation, sessionData, err := m.passkeys.webauthn.BeginRegistration(user,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(exclusions),
+	)
+	if err != nil {
+		log.Printf("passkey begin registration failed: %v", err)
+		writePasskeyError(w, http.StatusInternalServerError, "registration unavailable")
+		return
+	}
+	if err := m.saveCeremony(w, r, username, sessionData); err != nil {
+		log.Printf("passkey save ceremony failed: %v", err)
+		writePasskeyError(w, http.StatusInternalServerError, "registration unavailable")
+		return
+	}
+	writePasskeyJSON(w, creation)
+}
+
+func (m *Manager) finishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	username, sessionData, err := m.takeCeremony(w, r)
+	if err != nil {
+		writePasskeyError(w, http.StatusBadRequest, "no registration in progress")
+		return
+	}
+	user, err := m.passkeys.loadUser(r.Context(), username)
+	if err != nil {
+		log.Printf("passkey load user failed: %v", err)
+		writePasskeyError(w, http.StatusInternalServerError, "registration unavailable")
+		return
+	}
+	if current, signedIn := m.userIDFromSession(r); (!signedIn || current != username) && len(user.credentials) > 0 {
+		// Someone else completed a signup for this username in the meantime.
+		writePasskeyError(w, http.StatusConflict, "username is taken")
+		return
+	}
+	credential, err := m.passkeys.webauthn.FinishRegistration(user, *sessionData, r)
+	if err != nil {
+		log.Printf("passkey registration rejected user=%s: %v", username, err)
+		writePasskeyError(w, http.StatusBadRequest, "passkey registration failed")
+		return
+	}
+	data, err := json.Marshal(credential)
+	if err != nil {
+		writePasskeyError(w, http.StatusInternalServerError, "registration unavailable")
+		return
+	}
+	if err := m.passkeys.store.AddPasskey(r.Context(), username, storage.Passkey{CredentialID: credential.ID, Data: data}); err != nil {
+		log.Printf("passkey store failed user=%s: %v", username, err)
+		writePasskeyError(w, http.StatusInternalServerError, "registration unavailable")
+		return
+	}
+	if current, signedIn := m.userIDFromSession(r); signedIn && current == username {
+		// Adding a passkey to the signed-in account.
+		m.completePasskeyLogin(w, r, username)
+		return
+	}
+	m.beginSecondFactor(w, r, username)
+}
+
+func (m *Manager) beginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	assertion, sessionData, err := m.passkeys.webauthn.BeginDiscoverableLogin()
+	if err != nil {
+		log.Printf("passkey begin login failed: %v", err)
+		writePasskeyError(w, http.StatusInternalServerError, "login unavailable")
+		return
+	}
+	if err := m.saveCeremony(w, r, "", sessionData); err != nil {
+		log.Printf("passkey save ceremony failed: %v", err)
+		writePasskeyError(w, http.StatusInternalServerError, "login unavailable")
+		return
+	}
+	writePasskeyJSON(w, assertion)
+}
+
+func (m *Manager) finishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	_, sessionData, err := m.takeCeremony(w, r)
+	if err != nil {
+		writePasskeyError(w, http.StatusBadRequest, "no login in progress")
+		return
+	}
+	var username string
+	credential, err := m.passkeys.webauthn.FinishDiscoverableLogin(func(_, userHandle []byte) (webauthn.User, error) {
+		username = string(userHandle)
+		return m.passkeys.loadUser(r.Context(), username)
+	}, *sessionData, r)
+	if err != nil {
+		log.Printf("passkey login rejected: %v", err)
+		writePasskeyError(w, http.StatusUnauthorized, "passkey login failed")
+		return
+	}
+	if credential.Authenticator.CloneWarning {
+		log.Printf("passkey login rejected user=%s: signature counter went backwards", username)
+		writePasskeyError(w, http.StatusUnauthorized, "passkey login failed")
+		return
+	}
+	data, err := json.Marshal(credential)
+	if err == nil {
+		err = m.passkeys.store.UpdatePasskey(r.Context(), username, storage.Passkey{CredentialID: credential.ID, Data: data})
+	}
+	if err != nil {
+		log.Printf("passkey update failed user=%s: %v", username, err)
+		writePasskeyError(w, http.StatusInternalServerError, "login unavailable")
+		return
+	}
+	m.beginSecondFactor(w, r, username)
+}
+
+func (m *Manager) completePasskeyLogin(w http.ResponseWriter, r *http.Request, username string) {
+	if !m.startPasskeySession(w, r, username) {
+		return
+	}
+	writePasskeyJSON(w, map[string]string{"redirect": m.loginRedirect()})
+}
+
+// startPasskeySession signs username in, dropping any pending second factor
+// step. It answers the request itself when the session cannot be saved.
+func (m *Manager) startPasskeySession(w http.ResponseWriter, r *http.Request, username string) bool {
+	if session, err := m.sessionStore.Get(r, baseliboidc.STDSessionCookieName); err == nil {
+		delete(session.Values, totpPendingUserKey)
+		delete(session.Values, totpPendingAtKey)
+	}
+	if err := m.establishSession(w, r, username); err != nil {
+		log.Printf("passkey session failed user=%s: %v", username, err)
+		writePasskeyError(w, http.StatusInternalServerError, "login unavailable")
+		return false
+	}
+	if m.onLogin != nil {
+		if err := m.onLogin(r.Context(), username, Profile{DisplayName: username}); err != nil {
+			log.Printf("auth login profile update failed: %v", err)
+		}
+	}
+	return true
+}
+
+func (m *Manager) loginRedirect() string {
+	if m.fallbackURL == "" {
+		return "/"
+	}
+	return m.fallbackURL
+}
+
+// saveCeremony keeps the WebAuthn challenge in the encrypted session cookie
+// between the begin and finish calls.
+func (m *Manager) saveCeremony(w http.ResponseWriter, r *http.Request, username string, data *webauthn.SessionData) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	session, err := m.sessionStore.Get(r, baseliboidc.STDSessionCookieName)
+	if err != nil {
+		return err
+	}
+	session.Options = cloneOptions(m.cookieOptions)
+	session.Values[ceremonySessionKey] = string(encoded)
+	session.Values[ceremonyUsernameKey] = username
+	return session.Save(r, w)
+}
+
+// takeCeremony returns and clears the pending ceremony, so each challenge can
+// be answered only once.
+func (m *Manager) takeCeremony(w http.ResponseWriter, r *http.Request) (string, *webauthn.SessionData, error) {
+	session, err := m.sessionStore.Get(r, baseliboidc.STDSessionCookieName)
+	if err != nil {
+		return "", nil, err
+	}
+	encoded, _ := session.Values[ceremonySessionKey].(string)
+	username, _ := session.Values[ceremonyUsernameKey].(string)
+	if encoded == "" {
+		return "", nil, errors.New("no ceremony in progress")
+	}
+	delete(session.Values, ceremonySessionKey)
+	delete(session.Values, ceremonyUsernameKey)
+	session.Options = cloneOptions(m.cookieOptions)
+	if err := session.Save(r, w); err != nil {
+		return "", nil, err
+	}
+	var data webauthn.SessionData
+	if err := json.Unmarshal([]byte(encoded), &data); err != nil {
+		return "", nil, err
+	}
+	return username, &data, nil
+}
+
+func (p *passkeyAuth) signupAllowed(ctx context.Context) (bool, error) {
+	if p.allowSignup {
+		return true, nil
+	}
+	count, err := p.store.CountPasskeys(ctx)
+	if err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
+
+func (p *passkeyAuth) loadUser(ctx context.Context, username string) (*passkeyUser, error) {
+	passkeys, err := p.store.ListPasskeys(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	user := &passkeyUser{id: username}
+	for _, passkey := range passkeys {
+		var credential webauthn.Credential
+		if err := json.Unmarshal(passkey.Data, &credential); err != nil {
+			return nil, fmt.Errorf("decode passkey: %w", err)
+		}
+		user.credentials = append(user.credentials, credential)
+	}
+	return user, nil
+}
+
+type passkeyUser struct {
+	id          string
+	credentials []webauthn.Credential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte                         { return []byte(u.id) }
+func (u *passkeyUser) WebAuthnName() string                       { return u.id }
+func (u *passkeyUser) WebAuthnDisplayName() string                { return u.id }
+func (u *passkeyUser) WebAuthnIcon() string                       { return "" }
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+func writePasskeyJSON(w http.ResponseWriter, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(payload)
+}
+
+func writePasskeyError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+func newTestPasskeyManager(t *testing.T, allowSignup bool) (*Manager, *storage.MemoryStore) {
+	t.Helper()
+	store := storage.NewMemoryStore()
+	manager, err := NewPasskeyManager(Config{}, PasskeyConfig{
+		RPID:        "lists.example.com",
+		RPOrigins:   []string{"https://lists.example.com"},
+		AllowSignup: allowSignup,
+	}, store)
+	if err != nil {
+		t.Fatalf("new passkey manager: %v", err)
+	}
+	return manager, store
+}
+
+func beginRegistration(handler http.Handler, username string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/auth/passkey/register/begin", strings.NewReader(`{"username":"`+username+`"}`))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestPasskeyFirstSignupAllowedThenClosed(t *testing.T) {
+	manager, store := newTestPasskeyManager(t, false)
+	handler := manager.PasskeyHandler()
+
+	resp := beginRegistration(handler, "alice")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("first signup status: got %d: %s", resp.Code, resp.Body)
+	}
+	if !strings.Contains(resp.Body.String(), `"challenge"`) {
+		t.Fatalf("expected creation options, got %s", resp.Body)
+	}
+
+	if err := store.AddPasskey(context.Background(), "alice", storage.Passkey{CredentialID: []byte("cred-1"), Data: []byte(`{"ID":"Y3JlZC0x"}`)}); err != nil {
+		t.Fatalf("add passkey: %v", err)
+	}
+	if resp := beginRegistration(handler, "bob"); resp.Code != http.StatusForbidden {
+		t.Fatalf("signup after first account status: got %d", resp.Code)
+	}
+}
+
+func TestPasskeySignupRejectsTakenUsername(t *testing.T) {
+	manager, store := newTestPasskeyManager(t, true)
+	if err := store.AddPasskey(context.Background(), "alice", storage.Passkey{CredentialID: []byte("cred-1"), Data: []byte(`{"ID":"Y3JlZC0x"}`)}); err != nil {
+		t.Fatalf("add passkey: %v", err)
+	}
+	if resp := beginRegistration(manager.PasskeyHandler(), "alice"); resp.Code != http.StatusConflict {
+		t.Fatalf("taken username status: got %d", resp.Code)
+	}
+}
+
+func TestRequireLoginRedirectsToPasskeyPage(t *testing.T) {
+	manager, _ := newTestPasskeyManager(t, false)
+	handler := manager.RequireLogin(func(r *http.Request) bool { return r.URL.Path == "/healthz" })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if recorder.Code != http.StatusFound || recorder.Header().Get("Location") != "/auth/login" {
+		t.Fatalf("expected redirect to login, got %d %q", recorder.Code, recorder.Header().Get("Location"))
+	}
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("skipped path status: got %d", recorder.Code)
+	}
+}
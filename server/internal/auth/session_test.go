@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestSessionManager(t *testing.T, now *time.Time) *Manager {
+	t.Helper()
+	manager, _ := newTestPasskeyManager(t, false)
+	manager.sessionTTL = 30 * 24 * time.Hour
+	manager.idleTimeout = 14 * 24 * time.Hour
+	manager.now = func() time.Time { return *now }
+	return manager
+}
+
+func loginCookies(t *testing.T, manager *Manager, userID string) []*http.Cookie {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/auth/passkey/login/finish", nil)
+	if err := manager.establishSession(recorder, req, userID); err != nil {
+		t.Fatalf("establish session: %v", err)
+	}
+	return recorder.Result().Cookies()
+}
+
+// requestAs sends cookies through WithUser and returns the resolved user id
+// along with any refreshed cookies.
+func requestAs(manager *Manager, cookies []*http.Cookie) (string, []*http.Cookie) {
+	var userID string
+	handler := manager.WithUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ = UserIDFromContext(r.Context())
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/sync/pull", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if refreshed := recorder.Result().Cookies(); len(refreshed) > 0 {
+		cookies = refreshed
+	}
+	return userID, cookies
+}
+
+func TestSessionExpiresAfterIdleTimeout(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	manager := newTestSessionManager(t, &now)
+	cookies := loginCookies(t, manager, "alice")
+
+	now = now.Add(13 * 24 * time.Hour)
+	if userID, _ := requestAs(manager, cookies); userID != "alice" {
+		t.Fatalf("expected session within idle timeout to be valid, got %q", userID)
+	}
+	now = now.Add(15 * 24 * time.Hour)
+	if userID, _ := requestAs(manager, cookies); userID != "" {
+		t.Fatalf("expected idle session to expire, got %q", userID)
+	}
+}
+
+func TestSessionActivitySlidesIdleTimeoutUntilAbsoluteTTL(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	manager := newTestSessionManager(t, &now)
+	cookies := loginCookies(t, manager, "alice")
+
+	var userID string
+	for range 3 {
+		now = now.Add(11 * 24 * time.Hour)
+		userID, cookies = requestAs(manager, cookies)
+		if now.Sub(time.Unix(1_700_000_000, 0)) <= manager.sessionTTL && userID != "alice" {
+			t.Fatalf("expected active session to stay valid at %s, got %q", now, userID)
+		}
+	}
+	if userID != "" {
+		t.Fatalf("expected session to expire after the absolute TTL, got %q", userID)
+	}
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// Signup describes an OIDC login for Config.Admit, which decides whether it
+// may proceed before anything is stored for the user.
+type Signup struct {
+	UserID string
+	// PreviousUserID is the user's id under the previous user id claim, when
+	// one is configured and differs.
+	PreviousUserID string
+	Subject        string
+	Email          string
+	EmailVerified  bool
+	// InviteCode is the code the browser brought from the signup link.
+	InviteCode string
+}
+
+// ErrSignupRefused marks Admit errors that keep someone out, as opposed to
+// failures to decide. The error text is shown to them.
+var ErrSignupRefused = errors.New("signup refused")
+
+// inviteCookieName carries an invite code through the identity provider's
+// login, which drops anything else the browser brought.
+const inviteCookieName = "signup_invite"
+
+// inviteCookieMaxAge is long enough to sign up at the identity provider.
+const inviteCookieMaxAge = time.Hour
+
+// SignupHandler serves the link in invitations, /signup?invite=CODE: it keeps
+// the code in a cookie and sends the browser on to log in, where Admit sees
+// it. The code is not checked here, so the link gives nothing away.
+func (m *Manager) SignupHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if code := r.URL.Query().Get("invite"); code != "" {
+			http.SetCookie(w, &http.Cookie{
+				Name:     inviteCookieName,
+				Value:    code,
+				Path:     "/",
+				Domain:   m.cookieOptions.Domain,
+				MaxAge:   int(inviteCookieMaxAge.Seconds()),
+				HttpOnly: true,
+				Secure:   m.cookieOptions.Secure,
+				// Lax, so the cookie comes along on the identity provider's
+				// redirect back.
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		http.Redirect(w, r, "/", http.StatusFound)
+	}
+}
+
+// admitIDToken asks Admit whether the login of idToken may proceed.
+func (m *Manager) admitIDToken(r *http.Request, idToken *oidc.IDToken) error {
+	if m.admit == nil {
+		return nil
+	}
+	var claims idTokenClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return err
+	}
+	return m.admitClaims(r, claims)
+}
+
+func (m *Manager) admitClaims(r *http.Request, claims idTokenClaims) error {
+	userID, err := claims.userID(m.userIDClaim)
+	if err != nil {
+		return err
+	}
+	signup := Signup{UserID: userID, Subject: claims.Subject, Email: claims.Email, EmailVerified: claims.EmailVerified}
+	if m.previousClaim != "" {
+		if previousUserID, err := claims.userID(m.previousClaim); err == nil && previousUserID != userID {
+			signup.PreviousUserID = previousUserID
+		}
+	}
+	if cookie, err := r.Cookie(inviteCookieName); err == nil {
+		signup.InviteCode = cookie.Value
+	}
+	return m.admit(r.Context(), signup)
+}
+
+// refuseLogin answers a refused login, or reports that err is not a refusal.
+func refuseLogin(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, ErrSignupRefused) {
+		return false
+	}
+	log.Printf("auth login refused: %v", err)
+	http.Error(w, err.Error(), http.StatusForbidden)
+	return true
+}
+
+// clearInviteCookie removes a redeemed invite code.
+func (m *Manager) clearInviteCookie(w http.ResponseWriter, r *http.Request) {
+	if _, err := r.Cookie(inviteCookieName); err != nil {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: inviteCookieName, Path: "/", Domain: m.cookieOptions.Domain, MaxAge: -1, HttpOnly: true, Secure: m.cookieOptions.Secure, SameSite: http.SameSiteLaxMode})
+}
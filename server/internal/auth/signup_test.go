@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSignupLinkKeepsInviteCodeForAdmit(t *testing.T) {
+	manager, _ := newTestPasskeyManager(t, false)
+	resp := httptest.NewRecorder()
+	manager.SignupHandler().ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/signup?invite=abc", nil))
+	if resp.Code != http.StatusFound || resp.Header().Get("Location") != "/" {
+		t.Fatalf("expected a redirect to log in, got %d %q", resp.Code, resp.Header().Get("Location"))
+	}
+	cookies := resp.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != inviteCookieName || cookies[0].Value != "abc" || !cookies[0].HttpOnly {
+		t.Fatalf("unexpected cookies %+v", cookies)
+	}
+
+	var got Signup
+	manager.admit = func(ctx context.Context, signup Signup) error {
+		got = signup
+		return fmt.Errorf("%w: not today", ErrSignupRefused)
+	}
+	manager.userIDClaim = ClaimEmail
+	manager.previousClaim = ClaimSubject
+	req := httptest.NewRequest(http.MethodGet, "/auth/callback", nil)
+	req.AddCookie(cookies[0])
+	err := manager.admitClaims(req, idTokenClaims{Subject: "sub-1", Email: "Ann@Example.com", EmailVerified: true})
+	if got != (Signup{UserID: "ann@example.com", PreviousUserID: "sub-1", Subject: "sub-1", Email: "Ann@Example.com", EmailVerified: true, InviteCode: "abc"}) {
+		t.Fatalf("unexpected signup %+v", got)
+	}
+	refused := httptest.NewRecorder()
+	if !refuseLogin(refused, err) || refused.Code != http.StatusForbidden {
+		t.Fatalf("expected a refusal to be answered with 403, got %d", refused.Code)
+	}
+	if refuseLogin(httptest.NewRecorder(), fmt.Errorf("store down")) {
+		t.Fatal("expected other errors not to be treated as refusals")
+	}
+}
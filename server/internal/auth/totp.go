@@ -0,0 +1,517 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"a4-tasklists/server/internal/storage"
+
+	baseliboidc "github.com/aggregat4/go-baselib-services/v4/oidc"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+// A passkey login can be followed by a TOTP code from an authenticator app
+// before the session is established. Between the two steps the session cookie
+// only names the pending user, which authenticates nothing. Users enroll by
+// scanning a QR code and confirming a first code, and get single-use recovery
+// codes for when the app is lost.
+
+// TOTPPolicy decides who has to enter a TOTP code after the passkey.
+type TOTPPolicy string
+
+const (
+	// TOTPOff disables TOTP; enrollment endpoints answer 404.
+	TOTPOff TOTPPolicy = "off"
+	// TOTPOptional asks users who enrolled for a code.
+	TOTPOptional TOTPPolicy = "optional"
+	// TOTPRequired makes every user enroll before their first session and
+	// refuses to turn TOTP off.
+	TOTPRequired TOTPPolicy = "required"
+)
+
+// ParseTOTPPolicy validates a TOTP policy name. Empty selects TOTPOptional.
+func ParseTOTPPolicy(value string) (TOTPPolicy, error) {
+	switch policy := TOTPPolicy(strings.ToLower(strings.TrimSpace(value))); policy {
+	case "":
+		return TOTPOptional, nil
+	case TOTPOff, TOTPOptional, TOTPRequired:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unsupported totp policy %q (want off, optional, or required)", value)
+	}
+}
+
+// TOTPStore persists TOTP factors; storage.Store satisfies it.
+type TOTPStore interface {
+	GetTOTPFactor(ctx context.Context, userID string) (storage.TOTPFactor, error)
+	SetTOTPFactor(ctx context.Context, userID string, factor storage.TOTPFactor) error
+	DeleteTOTPFactor(ctx context.Context, userID string) error
+	AcceptTOTPStep(ctx context.Context, userID string, step int64) (bool, error)
+	UseTOTPRecoveryCode(ctx context.Context, userID string, codeHash string) (bool, error)
+}
+
+const (
+	totpPeriod = 30
+	// totpSkew is how many time steps before and after the current one are
+	// accepted, for clocks that drift.
+	totpSkew = 1
+	// totpPendingTTL is how long a passkey login waits for its code.
+	totpPendingTTL = 5 * time.Minute
+	// Wrong codes are limited per user; after totpMaxFailures within
+	// totpFailureWindow further attempts are refused until it has passed.
+	totpMaxFailures   = 5
+	totpFailureWindow = 15 * time.Minute
+
+	recoveryCodeCount = 10
+)
+
+const (
+	totpPendingUserKey = "totp_pending_user"
+	totpPendingAtKey   = "totp_pending_at"
+)
+
+// Second factor steps answered to the login page after the passkey.
+const (
+	totpStepVerify = "verify"
+	totpStepEnroll = "enroll"
+)
+
+var totpValidateOpts = totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+
+// totpFailures counts wrong codes per user.
+type totpFailures struct {
+	mu    sync.Mutex
+	users map[string]totpFailureCount
+}
+
+type totpFailureCount struct {
+	count int
+	since time.Time
+}
+
+func (f *totpFailures) blocked(username string, now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entry, ok := f.users[username]
+	if !ok || now.Sub(entry.since) >= totpFailureWindow {
+		return false
+	}
+	return entry.count >= totpMaxFailures
+}
+
+func (f *totpFailures) record(username string, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.users == nil {
+		f.users = make(map[string]totpFailureCount)
+	}
+	entry := f.users[username]
+	if now.Sub(entry.since) >= totpFailureWindow {
+		entry = totpFailureCount{since: now}
+	}
+	entry.count++
+	f.users[username] = entry
+}
+
+func (f *totpFailures) reset(username string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.users, username)
+}
+
+// beginSecondFactor finishes the passkey step of a login. It establishes the
+// session when no code is needed, and otherwise records the pending user and
+// tells the page whether to ask for a code or to enroll first.
+func (m *Manager) beginSecondFactor(w http.ResponseWriter, r *http.Request, username string) {
+	step, err := m.secondFactorStep(r.Context(), username)
+	if err != nil {
+		log.Printf("totp lookup failed user=%s: %v", username, err)
+		writePasskeyError(w, http.StatusInternalServerError, "login unavailable")
+		return
+	}
+	if step == "" {
+		m.completePasskeyLogin(w, r, username)
+		return
+	}
+	session, err := m.sessionStore.Get(r, baseliboidc.STDSessionCookieName)
+	if err == nil {
+		session.Options = cloneOptions(m.cookieOptions)
+		session.Values[totpPendingUserKey] = username
+		session.Values[totpPendingAtKey] = m.now().Unix()
+		err = session.Save(r, w)
+	}
+	if err != nil {
+		log.Printf("totp pending login failed user=%s: %v", username, err)
+		writePasskeyError(w, http.StatusInternalServerError, "login unavailable")
+		return
+	}
+	writePasskeyJSON(w, map[string]string{"totp": step})
+}
+
+// secondFactorStep returns totpStepVerify for users with a confirmed factor,
+// totpStepEnroll for users who have to enroll, and "" when no code is needed.
+func (m *Manager) secondFactorStep(ctx context.Context, username string) (string, error) {
+	if m.passkeys.totpPolicy == TOTPOff {
+		return "", nil
+	}
+	factor, err := m.passkeys.store.GetTOTPFactor(ctx, username)
+	if err != nil && !errors.Is(err, storage.ErrTOTPFactorNotFound) {
+		return "", err
+	}
+	switch {
+	case err == nil && factor.Confirmed:
+		return totpStepVerify, nil
+	case m.passkeys.totpPolicy == TOTPRequired:
+		return totpStepEnroll, nil
+	default:
+		return "", nil
+	}
+}
+
+// pendingTOTPUser returns the user whose passkey login waits for a code.
+func (m *Manager) pendingTOTPUser(r *http.Request) (string, bool) {
+	session, err := m.sessionStore.Get(r, baseliboidc.STDSessionCookieName)
+	if err != nil {
+		return "", false
+	}
+	username, _ := session.Values[totpPendingUserKey].(string)
+	startedAt, _ := session.Values[totpPendingAtKey].(int64)
+	if username == "" || m.now().Sub(time.Unix(startedAt, 0)) > totpPendingTTL {
+		return "", false
+	}
+	return username, true
+}
+
+// registerTOTPRoutes adds TOTP verification, enrollment and removal to the
+// passkey mux.
+func (m *Manager) registerTOTPRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /auth/passkey/totp", m.handleTOTPStatus)
+	mux.HandleFunc("POST /auth/passkey/totp/verify", m.handleTOTPVerify)
+	mux.HandleFunc("POST /auth/passkey/totp/enroll/begin", m.handleTOTPEnrollBegin)
+	mux.HandleFunc("POST /auth/passkey/totp/enroll/finish", m.handleTOTPEnrollFinish)
+	mux.HandleFunc("POST /auth/passkey/totp/disable", m.handleTOTPDisable)
+}
+
+// handleTOTPStatus tells a signed-in user whether TOTP is set up.
+func (m *Manager) handleTOTPStatus(w http.ResponseWriter, r *http.Request) {
+	username, signedIn := m.userIDFromSession(r)
+	if !signedIn {
+		writePasskeyError(w, http.StatusUnauthorized, "not signed in")
+		return
+	}
+	status := struct {
+		Policy            TOTPPolicy `json:"policy"`
+		Enrolled          bool       `json:"enrolled"`
+		RecoveryCodesLeft int        `json:"recoveryCodesLeft"`
+	}{Policy: m.passkeys.totpPolicy}
+	factor, err := m.passkeys.store.GetTOTPFactor(r.Context(), username)
+	switch {
+	case err == nil:
+		status.Enrolled = factor.Confirmed
+		if factor.Confirmed {
+			status.RecoveryCodesLeft = len(factor.RecoveryCodeHashes)
+		}
+	case !errors.Is(err, storage.ErrTOTPFactorNotFound):
+		log.Printf("totp lookup failed user=%s: %v", username, err)
+		writePasskeyError(w, http.StatusInternalServerError, "totp unavailable")
+		return
+	}
+	writePasskeyJSON(w, status)
+}
+
+// handleTOTPVerify checks the code, or a recovery code, of a pending login.
+func (m *Manager) handleTOTPVerify(w http.ResponseWriter, r *http.Request) {
+	username, pending := m.pendingTOTPUser(r)
+	if !pending {
+		writePasskeyError(w, http.StatusBadRequest, "no login in progress")
+		return
+	}
+	code, ok := decodeTOTPCode(w, r)
+	if !ok {
+		return
+	}
+	factor, err := m.passkeys.store.GetTOTPFactor(r.Context(), username)
+	if err != nil || !factor.Confirmed {
+		if err != nil && !errors.Is(err, storage.ErrTOTPFactorNotFound) {
+			log.Printf("totp lookup failed user=%s: %v", username, err)
+		}
+		writePasskeyError(w, http.StatusBadRequest, "no login in progress")
+		return
+	}
+	if !m.checkTOTPCode(w, r, username, factor, code) {
+		return
+	}
+	m.completePasskeyLogin(w, r, username)
+}
+
+// totpSubject returns the user an enrollment is for: the signed-in user, or
+// the pending login of a user who has to enroll before getting a session.
+func (m *Manager) totpSubject(r *http.Request) (string, bool, bool) {
+	if username, signedIn := m.userIDFromSession(r); signedIn {
+		return username, false, true
+	}
+	if username, pending := m.pendingTOTPUser(r); pending {
+		return username, true, true
+	}
+	return "", false, false
+}
+
+func (m *Manager) handleTOTPEnrollBegin(w http.ResponseWriter, r *http.Request) {
+	if m.passkeys.totpPolicy == TOTPOff {
+		writePasskeyError(w, http.StatusNotFound, "totp is disabled")
+		return
+	}
+	username, _, ok := m.totpSubject(r)
+	if !ok {
+		writePasskeyError(w, http.StatusUnauthorized, "not signed in")
+		return
+	}
+	// A confirmed factor is only replaced after turning it off with a code,
+	// otherwise a passkey alone could swap in the attacker's app.
+	existing, err := m.passkeys.store.GetTOTPFactor(r.Context(), username)
+	if err != nil && !errors.Is(err, storage.ErrTOTPFactorNotFound) {
+		log.Printf("totp lookup failed user=%s: %v", username, err)
+		writePasskeyError(w, http.StatusInternalServerError, "totp unavailable")
+		return
+	}
+	if err == nil && existing.Confirmed {
+		writePasskeyError(w, http.StatusConflict, "totp is already set up")
+		return
+	}
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: m.passkeys.displayName, AccountName: username})
+	if err != nil {
+		log.Printf("totp generate failed: %v", err)
+		writePasskeyError(w, http.StatusInternalServerError, "totp unavailable")
+		return
+	}
+	qr, err := totpQRCode(key)
+	if err != nil {
+		log.Printf("totp qr code failed: %v", err)
+		writePasskeyError(w, http.StatusInternalServerError, "totp unavailable")
+		return
+	}
+	if err := m.passkeys.store.SetTOTPFactor(r.Context(), username, storage.TOTPFactor{Secret: key.Secret()}); err != nil {
+		log.Printf("totp store failed user=%s: %v", username, err)
+		writePasskeyError(w, http.StatusInternalServerError, "totp unavailable")
+		return
+	}
+	writePasskeyJSON(w, map[string]string{
+		"secret": key.Secret(),
+		"uri":    key.URL(),
+		"qrCode": qr,
+	})
+}
+
+// handleTOTPEnrollFinish confirms the factor with a first code and hands out
+// the recovery codes, which are shown only this once.
+func (m *Manager) handleTOTPEnrollFinish(w http.ResponseWriter, r *http.Request) {
+	username, pending, ok := m.totpSubject(r)
+	if !ok {
+		writePasskeyError(w, http.StatusUnauthorized, "not signed in")
+		return
+	}
+	code, ok := decodeTOTPCode(w, r)
+	if !ok {
+		return
+	}
+	factor, err := m.passkeys.store.GetTOTPFactor(r.Context(), username)
+	if err != nil || factor.Confirmed {
+		if err != nil && !errors.Is(err, storage.ErrTOTPFactorNotFound) {
+			log.Printf("totp lookup failed user=%s: %v", username, err)
+		}
+		writePasskeyError(w, http.StatusBadRequest, "no enrollment in progress")
+		return
+	}
+	now := m.now()
+	if m.passkeys.failures.blocked(username, now) {
+		writePasskeyError(w, http.StatusTooManyRequests, "too many wrong codes, try again later")
+		return
+	}
+	step, matched := matchTOTPCode(factor.Secret, code, now)
+	if !matched {
+		m.passkeys.failures.record(username, now)
+		writePasskeyError(w, http.StatusUnauthorized, "wrong code")
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		log.Printf("totp recovery codes failed: %v", err)
+		writePasskeyError(w, http.StatusInternalServerError, "totp unavailable")
+		return
+	}
+	factor.Confirmed = true
+	factor.LastStep = step
+	factor.RecoveryCodeHashes = hashes
+	if err := m.passkeys.store.SetTOTPFactor(r.Context(), username, factor); err != nil {
+		log.Printf("totp store failed user=%s: %v", username, err)
+		writePasskeyError(w, http.StatusInternalServerError, "totp unavailable")
+		return
+	}
+	m.passkeys.failures.reset(username)
+	if pending && !m.startPasskeySession(w, r, username) {
+		return
+	}
+	writePasskeyJSON(w, map[string]any{"recoveryCodes": codes, "redirect": m.loginRedirect()})
+}
+
+// handleTOTPDisable removes the signed-in user's factor after checking a
+// current code or recovery code.
+func (m *Manager) handleTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	username, signedIn := m.userIDFromSession(r)
+	if !signedIn {
+		writePasskeyError(w, http.StatusUnauthorized, "not signed in")
+		return
+	}
+	if m.passkeys.totpPolicy == TOTPRequired {
+		writePasskeyError(w, http.StatusForbidden, "totp is required")
+		return
+	}
+	code, ok := decodeTOTPCode(w, r)
+	if !ok {
+		return
+	}
+	factor, err := m.passkeys.store.GetTOTPFactor(r.Context(), username)
+	if err != nil || !factor.Confirmed {
+		if err != nil && !errors.Is(err, storage.ErrTOTPFactorNotFound) {
+			log.Printf("totp lookup failed user=%s: %v", username, err)
+		}
+		writePasskeyError(w, http.StatusNotFound, "totp is not set up")
+		return
+	}
+	if !m.checkTOTPCode(w, r, username, factor, code) {
+		return
+	}
+	if err := m.passkeys.store.DeleteTOTPFactor(r.Context(), username); err != nil {
+		log.Printf("totp delete failed user=%s: %v", username, err)
+		writePasskeyError(w, http.StatusInternalServerError, "totp unavailable")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkTOTPCode accepts a code from the user's app, each time step once, or
+// an unused recovery code. It answers the request itself
+// when the code is refused.
+func (m *Manager) checkTOTPCode(w http.ResponseWriter, r *http.Request, username string, factor storage.TOTPFactor, code string) bool {
+	now := m.now()
+	if m.passkeys.failures.blocked(username, now) {
+		writePasskeyError(w, http.StatusTooManyRequests, "too many wrong codes, try again later")
+		return false
+	}
+	accepted, err := m.acceptTOTPCode(r.Context(), username, factor, code, now)
+	if err != nil {
+		log.Printf("totp check failed user=%s: %v", username, err)
+		writePasskeyError(w, http.StatusInternalServerError, "totp unavailable")
+		return false
+	}
+	if !accepted {
+		m.passkeys.failures.record(username, now)
+		log.Printf("totp code rejected user=%s", username)
+		writePasskeyError(w, http.StatusUnauthorized, "wrong code")
+		return false
+	}
+	m.passkeys.failures.reset(username)
+	return true
+}
+
+func (m *Manager) acceptTOTPCode(ctx context.Context, username string, factor storage.TOTPFactor, code string, now time.Time) (bool, error) {
+	if step, matched := matchTOTPCode(factor.Secret, code, now); matched {
+		return m.passkeys.store.AcceptTOTPStep(ctx, username, step)
+	}
+	normalized := normalizeRecoveryCode(code)
+	if len(normalized) != recoveryCodeLength {
+		return false, nil
+	}
+	return m.passkeys.store.UseTOTPRecoveryCode(ctx, username, hashRecoveryCode(normalized))
+}
+
+// matchTOTPCode returns the time step whose code equals code, checking the
+// current step and totpSkew steps on either side.
+func matchTOTPCode(secret string, code string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != otp.DigitsSix.Length() {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totpValidateOpts)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// recoveryCodeLength is the length of a recovery code without its dash.
+const recoveryCodeLength = 10
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns fresh recovery codes, formatted for display, and
+// the hashes to store.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		raw := make([]byte, 8)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(raw))[:recoveryCodeLength]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+}
+
+func hashRecoveryCode(normalized string) string {
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// totpQRCode renders the key's otpauth URI as a PNG data URL for the page.
+func totpQRCode(key *otp.Key) (string, error) {
+	img, err := key.Image(200, 200)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func decodeTOTPCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var payload struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || strings.TrimSpace(payload.Code) == "" {
+		writePasskeyError(w, http.StatusBadRequest, "code is required")
+		return "", false
+	}
+	return payload.Code, true
+}
@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"a4-tasklists/server/internal/storage"
+
+	"github.com/pquerna/otp/totp"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+func newTestTOTPManager(t *testing.T, policy TOTPPolicy) (*Manager, *storage.MemoryStore, *time.Time) {
+	t.Helper()
+	store := storage.NewMemoryStore()
+	manager, err := NewPasskeyManager(Config{}, PasskeyConfig{
+		RPID:      "lists.example.com",
+		RPOrigins: []string{"https://lists.example.com"},
+		TOTP:      policy,
+	}, store)
+	if err != nil {
+		t.Fatalf("new passkey manager: %v", err)
+	}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	manager.now = func() time.Time { return now }
+	return manager, store, &now
+}
+
+func enrollTestFactor(t *testing.T, store *storage.MemoryStore, username string, recoveryCodes ...string) {
+	t.Helper()
+	hashes := make([]string, 0, len(recoveryCodes))
+	for _, code := range recoveryCodes {
+		hashes = append(hashes, hashRecoveryCode(normalizeRecoveryCode(code)))
+	}
+	factor := storage.TOTPFactor{Secret: testTOTPSecret, Confirmed: true, RecoveryCodeHashes: hashes}
+	if err := store.SetTOTPFactor(context.Background(), username, factor); err != nil {
+		t.Fatalf("set totp factor: %v", err)
+	}
+}
+
+func testTOTPCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	code, err := totp.GenerateCodeCustom(secret, at, totpValidateOpts)
+	if err != nil {
+		t.Fatalf("generate code: %v", err)
+	}
+	return code
+}
+
+// passkeyStep stands in for a successful passkey ceremony and returns the
+// response with the cookies it set.
+func passkeyStep(t *testing.T, manager *Manager, username string) *httptest.ResponseRecorder {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	manager.beginSecondFactor(recorder, httptest.NewRequest(http.MethodPost, "/auth/passkey/login/finish", nil), username)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("passkey step status: got %d: %s", recorder.Code, recorder.Body)
+	}
+	return recorder
+}
+
+func postWithCookies(handler http.Handler, path string, body string, from *httptest.ResponseRecorder) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	for _, cookie := range from.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func authenticated(manager *Manager, from *httptest.ResponseRecorder) bool {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range from.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	return manager.IsAuthenticated(req)
+}
+
+func TestTOTPLoginWaitsForCode(t *testing.T) {
+	manager, store, now := newTestTOTPManager(t, TOTPOptional)
+	enrollTestFactor(t, store, "alice")
+	handler := manager.PasskeyHandler()
+
+	pending := passkeyStep(t, manager, "alice")
+	if !strings.Contains(pending.Body.String(), `"totp":"verify"`) {
+		t.Fatalf("expected a code prompt, got %s", pending.Body)
+	}
+	if authenticated(manager, pending) {
+		t.Fatalf("passkey alone established a session")
+	}
+	if resp := postWithCookies(handler, "/auth/passkey/totp/verify", `{"code":"000000"}`, pending); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong code status: got %d", resp.Code)
+	}
+
+	code := testTOTPCode(t, testTOTPSecret, *now)
+	resp := postWithCookies(handler, "/auth/passkey/totp/verify", `{"code":"`+code+`"}`, pending)
+	if resp.Code != http.StatusOK || !authenticated(manager, resp) {
+		t.Fatalf("valid code status: got %d: %s", resp.Code, resp.Body)
+	}
+
+	// The same code cannot be used for a second login.
+	again := passkeyStep(t, manager, "alice")
+	if resp := postWithCookies(handler, "/auth/passkey/totp/verify", `{"code":"`+code+`"}`, again); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("replayed code status: got %d", resp.Code)
+	}
+}
+
+func TestTOTPPendingLoginExpires(t *testing.T) {
+	manager, store, now := newTestTOTPManager(t, TOTPOptional)
+	enrollTestFactor(t, store, "alice")
+
+	pending := passkeyStep(t, manager, "alice")
+	*now = now.Add(totpPendingTTL + time.Second)
+	code := testTOTPCode(t, testTOTPSecret, *now)
+	if resp := postWithCookies(manager.PasskeyHandler(), "/auth/passkey/totp/verify", `{"code":"`+code+`"}`, pending); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expired login status: got %d", resp.Code)
+	}
+}
+
+func TestTOTPRecoveryCodeWorksOnce(t *testing.T) {
+	manager, store, _ := newTestTOTPManager(t, TOTPOptional)
+	enrollTestFactor(t, store, "alice", "abcde-fghij")
+	handler := manager.PasskeyHandler()
+
+	resp := postWithCookies(handler, "/auth/passkey/totp/verify", `{"code":"ABCDE-FGHIJ"}`, passkeyStep(t, manager, "alice"))
+	if resp.Code != http.StatusOK || !authenticated(manager, resp) {
+		t.Fatalf("recovery code status: got %d: %s", resp.Code, resp.Body)
+	}
+	resp = postWithCookies(handler, "/auth/passkey/totp/verify", `{"code":"abcdefghij"}`, passkeyStep(t, manager, "alice"))
+	if resp.Code != http.StatusUnauthorized {
+		t.Fatalf("reused recovery code status: got %d", resp.Code)
+	}
+}
+
+func TestTOTPLimitsWrongCodes(t *testing.T) {
+	manager, store, now := newTestTOTPManager(t, TOTPOptional)
+	enrollTestFactor(t, store, "alice")
+	handler := manager.PasskeyHandler()
+
+	pending := passkeyStep(t, manager, "alice")
+	for range totpMaxFailures {
+		postWithCookies(handler, "/auth/passkey/totp/verify", `{"code":"000000"}`, pending)
+	}
+	code := testTOTPCode(t, testTOTPSecret, *now)
+	if resp := postWithCookies(handler, "/auth/passkey/totp/verify", `{"code":"`+code+`"}`, pending); resp.Code != http.StatusTooManyRequests {
+		t.Fatalf("status after too many wrong codes: got %d", resp.Code)
+	}
+}
+
+func TestTOTPOptionalWithoutFactorSignsIn(t *testing.T) {
+	manager, _, _ := newTestTOTPManager(t, TOTPOptional)
+	resp := passkeyStep(t, manager, "alice")
+	if !strings.Contains(resp.Body.String(), `"redirect"`) || !authenticated(manager, resp) {
+		t.Fatalf("expected a session, got %s", resp.Body)
+	}
+}
+
+func TestTOTPRequiredEnrollsBeforeSession(t *testing.T) {
+	manager, store, now := newTestTOTPManager(t, TOTPRequired)
+	handler := manager.PasskeyHandler()
+
+	pending := passkeyStep(t, manager, "alice")
+	if !strings.Contains(pending.Body.String(), `"totp":"enroll"`) || authenticated(manager, pending) {
+		t.Fatalf("expected an enrollment prompt without a session, got %s", pending.Body)
+	}
+	begin := postWithCookies(handler, "/auth/passkey/totp/enroll/begin", "", pending)
+	var key struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+		QRCode string `json:"qrCode"`
+	}
+	if err := json.Unmarshal(begin.Body.Bytes(), &key); err != nil || begin.Code != http.StatusOK {
+		t.Fatalf("enroll begin: %d %s", begin.Code, begin.Body)
+	}
+	if !strings.HasPrefix(key.URI, "otpauth://totp/") || !strings.HasPrefix(key.QRCode, "data:image/png;base64,") {
+		t.Fatalf("unexpected provisioning data: %+v", key)
+	}
+
+	code := testTOTPCode(t, key.Secret, *now)
+	finish := postWithCookies(handler, "/auth/passkey/totp/enroll/finish", `{"code":"`+code+`"}`, pending)
+	var result struct {
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}
+	if err := json.Unmarshal(finish.Body.Bytes(), &result); err != nil || finish.Code != http.StatusOK {
+		t.Fatalf("enroll finish: %d %s", finish.Code, finish.Body)
+	}
+	if len(result.RecoveryCodes) != recoveryCodeCount || !authenticated(manager, finish) {
+		t.Fatalf("expected recovery codes and a session, got %s", finish.Body)
+	}
+	factor, err := store.GetTOTPFactor(context.Background(), "alice")
+	if err != nil || !factor.Confirmed {
+		t.Fatalf("factor not confirmed: %+v %v", factor, err)
+	}
+
+	// A confirmed factor cannot be replaced or removed under the policy.
+	if resp := postWithCookies(handler, "/auth/passkey/totp/enroll/begin", "", finish); resp.Code != http.StatusConflict {
+		t.Fatalf("re-enroll status: got %d", resp.Code)
+	}
+	if resp := postWithCookies(handler, "/auth/passkey/totp/disable", `{"code":"`+result.RecoveryCodes[0]+`"}`, finish); resp.Code != http.StatusForbidden {
+		t.Fatalf("disable status: got %d", resp.Code)
+	}
+}
+
+func TestTOTPPendingLoginCannotReplaceFactor(t *testing.T) {
+	manager, store, _ := newTestTOTPManager(t, TOTPOptional)
+	enrollTestFactor(t, store, "alice")
+
+	pending := passkeyStep(t, manager, "alice")
+	if resp := postWithCookies(manager.PasskeyHandler(), "/auth/passkey/totp/enroll/begin", "", pending); resp.Code != http.StatusConflict {
+		t.Fatalf("enroll during pending login status: got %d", resp.Code)
+	}
+	factor, err := store.GetTOTPFactor(context.Background(), "alice")
+	if err != nil || factor.Secret != testTOTPSecret {
+		t.Fatalf("factor changed: %+v %v", factor, err)
+	}
+}
+
+func TestParseTOTPPolicy(t *testing.T) {
+	if policy, err := ParseTOTPPolicy(""); err != nil || policy != TOTPOptional {
+		t.Fatalf("default policy: %q %v", policy, err)
+	}
+	if policy, err := ParseTOTPPolicy("Required"); err != nil || policy != TOTPRequired {
+		t.Fatalf("required policy: %q %v", policy, err)
+	}
+	if _, err := ParseTOTPPolicy("sometimes"); err == nil {
+		t.Fatalf("expected an error for an unknown policy")
+	}
+}
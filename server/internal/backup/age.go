@@ -0,0 +1,81 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// Backups are encrypted in the age format (https://age-encryption.org/v1)
+// to X25519 recipients, so they can be decrypted with the age tool and the
+// server needs only the public keys. Archives are streamed through
+// filippo.io/age as they are packed and unpacked.
+
+// AgeRecipient is an age X25519 public key ("age1...").
+type AgeRecipient struct {
+	key *age.X25519Recipient
+}
+
+// AgeIdentity is an age X25519 private key ("AGE-SECRET-KEY-1...").
+type AgeIdentity struct {
+	key *age.X25519Identity
+}
+
+// ParseAgeRecipient parses an "age1..." public key.
+func ParseAgeRecipient(s string) (AgeRecipient, error) {
+	key, err := age.ParseX25519Recipient(strings.TrimSpace(s))
+	if err != nil {
+		return AgeRecipient{}, fmt.Errorf("invalid age recipient %q", s)
+	}
+	return AgeRecipient{key: key}, nil
+}
+
+// ParseAgeIdentity parses an "AGE-SECRET-KEY-1..." private key. It also
+// accepts the contents of a key file written by age-keygen: comment lines
+// are skipped and the first key is used.
+func ParseAgeIdentity(s string) (AgeIdentity, error) {
+	for line := range strings.Lines(s) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := age.ParseX25519Identity(line)
+		if err != nil {
+			return AgeIdentity{}, errors.New("invalid age identity")
+		}
+		return AgeIdentity{key: key}, nil
+	}
+	return AgeIdentity{}, errors.New("no age identity found")
+}
+
+// Recipient returns the public key of the identity.
+func (i AgeIdentity) Recipient() AgeRecipient {
+	return AgeRecipient{key: i.key.Recipient()}
+}
+
+func (r AgeRecipient) String() string {
+	return r.key.String()
+}
+
+// ageEncrypt returns a writer that encrypts to w so that any of recipients
+// can decrypt it. The file is only complete once the writer is closed.
+func ageEncrypt(w io.Writer, recipients []AgeRecipient) (io.WriteCloser, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no age recipients")
+	}
+	keys := make([]age.Recipient, 0, len(recipients))
+	for _, recipient := range recipients {
+		keys = append(keys, recipient.key)
+	}
+	return age.Encrypt(w, keys...)
+}
+
+// ageDecrypt returns a reader of r decrypted with identity. The payload is
+// authenticated chunk by chunk as it is read, so callers must read to the
+// end to know the whole file was intact.
+func ageDecrypt(r io.Reader, identity AgeIdentity) (io.Reader, error) {
+	return age.Decrypt(r, identity.key)
+}
@@ -0,0 +1,125 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"testing"
+)
+
+// A key pair from age-keygen and a file encrypted to it with the age tool.
+const (
+	testAgeIdentity  = "# created: 2026-10-16\nAGE-SECRET-KEY-1K2RTWGPEM0TZTF8ARNQCPJHGAN3NNJWGSA6YQYK3TRAFX7APRE3S0PS4WW\n"
+	testAgeRecipient = "age18k24xlgathmc6wxpfd87qvj5jw73cetd4fsnnx7s26lu0nj4v3hsxxrgz6"
+	// testAgeOther is a recipient whose identity the tests do not have.
+	testAgeOther = "age12hkr509ajwxltt86zuhlvg46jymq9ue2rgrkzm2ll8klpy99jvfstu0urp"
+	testAgeFile  = "YWdlLWVuY3J5cHRpb24ub3JnL3YxCi0+IFgyNTUxOSBiNEJZc2pTQ2xOdzBJTTRJam5pazJWdHZreVdYSHFMZkhCKzVoWUtCYlE0CmpHQmtZc1hpRDFZdnNBTjIwRFd4UkVlOGlnRkw5WkNQMDVlNzdJakVFMGsKLS0tIDBTcHVOazYrbWlmUlVWbnpjUDNWb2EvYlc0MGxZVHMrZThubTFNTE43WkEKS17ItN3gr+X5kFbBZnSDx2RQVuzTZ9kMil4U1egfa7s/42N7Lf0W1I99aueP7DaULA=="
+)
+
+func testIdentity(t *testing.T) AgeIdentity {
+	t.Helper()
+	identity, err := ParseAgeIdentity(testAgeIdentity)
+	if err != nil {
+		t.Fatalf("parse identity: %v", err)
+	}
+	return identity
+}
+
+// sealAge and openAge run the streaming helpers over whole buffers.
+func sealAge(recipients []AgeRecipient, plaintext []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := ageEncrypt(&buf, recipients)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func openAge(identity AgeIdentity, data []byte) ([]byte, error) {
+	r, err := ageDecrypt(bytes.NewReader(data), identity)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestParseAgeKeys(t *testing.T) {
+	identity := testIdentity(t)
+	if got := identity.Recipient().String(); got != testAgeRecipient {
+		t.Fatalf("expected recipient %s, got %s", testAgeRecipient, got)
+	}
+	recipient, err := ParseAgeRecipient(testAgeRecipient)
+	if err != nil || recipient.String() != testAgeRecipient {
+		t.Fatalf("parse recipient: %v %s", err, recipient)
+	}
+	// One changed character breaks the bech32 checksum.
+	if _, err := ParseAgeRecipient(testAgeRecipient[:len(testAgeRecipient)-1] + "7"); err == nil {
+		t.Fatal("expected a corrupted recipient to be rejected")
+	}
+	if _, err := ParseAgeRecipient("age-secret-key-1k2rtwgpem0tztf8arnqcpjhgan3nnjwgsa6yqyk3trafx7apre3s0ps4ww"); err == nil {
+		t.Fatal("expected an identity to be rejected as a recipient")
+	}
+}
+
+func TestAgeDecryptsFileFromAgeTool(t *testing.T) {
+	data, _ := base64.StdEncoding.DecodeString(testAgeFile)
+	plaintext, err := openAge(testIdentity(t), data)
+	if err != nil || string(plaintext) != "tasklists backup\n" {
+		t.Fatalf("decrypt: %q %v", plaintext, err)
+	}
+}
+
+func TestAgeRoundTrip(t *testing.T) {
+	identity := testIdentity(t)
+	other, err := ParseAgeRecipient(testAgeOther)
+	if err != nil {
+		t.Fatalf("parse recipient: %v", err)
+	}
+	// Payloads are sealed in 64 KiB chunks.
+	for _, size := range []int{0, 1, 64 << 10, 64<<10 + 1, 3 * 64 << 10} {
+		plaintext := make([]byte, size)
+		_, _ = rand.Read(plaintext)
+		data, err := sealAge([]AgeRecipient{other, identity.Recipient()}, plaintext)
+		if err != nil {
+			t.Fatalf("encrypt %d bytes: %v", size, err)
+		}
+		got, err := openAge(identity, data)
+		if err != nil || !bytes.Equal(got, plaintext) {
+			t.Fatalf("round trip of %d bytes failed: %v", size, err)
+		}
+	}
+}
+
+func TestAgeRejectsTamperingAndOtherIdentities(t *testing.T) {
+	identity := testIdentity(t)
+	data, err := sealAge([]AgeRecipient{identity.Recipient()}, []byte("backup"))
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	tampered := bytes.Clone(data)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := openAge(identity, tampered); err == nil {
+		t.Fatal("expected a changed payload to be rejected")
+	}
+	if _, err := openAge(identity, data[:len(data)-1]); err == nil {
+		t.Fatal("expected a truncated payload to be rejected")
+	}
+	other, err := ParseAgeRecipient(testAgeOther)
+	if err != nil {
+		t.Fatalf("parse recipient: %v", err)
+	}
+	data, err = sealAge([]AgeRecipient{other}, []byte("backup"))
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if _, err := openAge(identity, data); err == nil {
+		t.Fatal("expected a file for another recipient to be rejected")
+	}
+}
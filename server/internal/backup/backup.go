@@ -0,0 +1,554 @@
+// Package backup writes scheduled, compressed copies of the database to a
+// directory or an S3 bucket and restores them.
+//
+// A backup is a gzip-compressed tar of the files storage writes with
+// Backup: the database, and in the per-user layout every user file. A
+// manifest in the archive lists their checksums. With age recipients
+// configured, the archive is encrypted before it leaves the server, so the
+// storage provider cannot read it. After it is uploaded, the backup is
+// downloaded again, its checksum compared, and (when it can be decrypted)
+// every file integrity-checked, so a listed backup is known to restore. An
+// index object next to the backups lists them; the blob stores have no
+// listing of their own.
+//
+// Restoring replaces the database, which cannot happen under a running
+// server. Stage unpacks a backup next to the database, and ApplyStaged puts
+// it in place when the server starts next.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"a4-tasklists/server/internal/blobstore"
+	"a4-tasklists/server/internal/storage"
+)
+
+// indexKey names the object listing the backups.
+const indexKey = "index.json"
+
+var (
+	// ErrRunning is returned by Run while a backup is already being written.
+	ErrRunning = errors.New("backup already running")
+	// ErrNotFound is returned for backup ids that are not in the index.
+	ErrNotFound = errors.New("backup not found")
+	// ErrNoIdentity is returned when an encrypted backup has to be read
+	// without an age identity configured.
+	ErrNoIdentity = errors.New("backup is encrypted and no age identity is configured")
+)
+
+// manifestName is the first entry of every archive.
+const manifestName = "manifest.json"
+
+// Manifest lists the files of a backup with their checksums. A restore
+// checks them, so files that were corrupted, truncated, or taken from another
+// backup are noticed before they replace the database.
+type Manifest struct {
+	ID        string         `json:"id"`
+	CreatedAt int64          `json:"createdAt"`
+	Files     []ManifestFile `json:"files"`
+}
+
+// ManifestFile is one file of a backup.
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// Source writes consistent copies of the database files into a directory
+// and returns their slash-separated names relative to it. The SQLite stores
+// of both layouts implement it.
+type Source interface {
+	Backup(ctx context.Context, dir string) ([]string, error)
+}
+
+// Backup describes one stored backup.
+type Backup struct {
+	ID        string `json:"id"`
+	Key       string `json:"key"`
+	CreatedAt int64  `json:"createdAt"`
+	// Bytes is the compressed size.
+	Bytes  int64    `json:"bytes"`
+	SHA256 string   `json:"sha256"`
+	Files  []string `json:"files"`
+	// Encrypted backups are age files; Key ends in .age.
+	Encrypted bool `json:"encrypted,omitempty"`
+	// Duration covers writing, uploading, and verifying the backup.
+	Duration time.Duration `json:"duration"`
+}
+
+// Retention decides which backups are deleted after a new one was written.
+// Zero fields do not limit; the newest backup is always kept.
+type Retention struct {
+	// Keep is how many backups are kept.
+	Keep int
+	// MaxAge is how old a backup may get.
+	MaxAge time.Duration
+}
+
+// Config configures a Manager.
+type Config struct {
+	Source      Source
+	Destination blobstore.Store
+	// Schedule decides when Loop writes backups.
+	Schedule  *Schedule
+	Retention Retention
+	// StageDir is where Stage unpacks a backup for ApplyStaged.
+	StageDir string
+	// Recipients, when set, are the age public keys backups are encrypted
+	// to.
+	Recipients []AgeRecipient
+	// Identity, when set, decrypts backups for verification and restore.
+	// Without it, encrypted backups are verified before encryption only.
+	Identity *AgeIdentity
+}
+
+// Manager writes, lists, and stages backups. All methods accept a nil
+// Manager; List then returns nothing and the others fail.
+type Manager struct {
+	cfg Config
+	now func() time.Time
+
+	// running serializes runs and index updates.
+	running sync.Mutex
+
+	mu        sync.Mutex
+	retention Retention
+}
+
+func New(cfg Config) *Manager {
+	return &Manager{cfg: cfg, now: time.Now, retention: cfg.Retention}
+}
+
+// SetRetention replaces the retention rules, e.g. after a settings change.
+// They apply from the next backup on.
+func (m *Manager) SetRetention(retention Retention) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retention = retention
+}
+
+// Next returns when Loop writes the next backup.
+func (m *Manager) Next() time.Time {
+	if m == nil || m.cfg.Schedule == nil {
+		return time.Time{}
+	}
+	return m.cfg.Schedule.Next(m.now())
+}
+
+// Encrypted reports whether backups are encrypted to age recipients.
+func (m *Manager) Encrypted() bool {
+	return m != nil && len(m.cfg.Recipients) > 0
+}
+
+// Loop writes a backup whenever the schedule is due, until ctx is done.
+func (m *Manager) Loop(ctx context.Context) {
+	if m == nil || m.cfg.Schedule == nil {
+		return
+	}
+	for {
+		next := m.Next()
+		if next.IsZero() {
+			log.Printf("backup schedule %q never matches; scheduled backups are off", m.cfg.Schedule)
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if _, err := m.Run(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("backup error: %v", err)
+		}
+	}
+}
+
+// List returns the backups, newest first.
+func (m *Manager) List(ctx context.Context) ([]Backup, error) {
+	if m == nil {
+		return nil, nil
+	}
+	backups, err := m.readIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(backups)
+	return backups, nil
+}
+
+// Run writes, uploads, and verifies a backup now and then applies the
+// retention rules. A backup that fails verification is deleted again.
+func (m *Manager) Run(ctx context.Context) (Backup, error) {
+	if m == nil {
+		return Backup{}, errors.New("backups are not configured")
+	}
+	if !m.running.TryLock() {
+		return Backup{}, ErrRunning
+	}
+	defer m.running.Unlock()
+
+	started := m.now()
+	backup := Backup{ID: started.UTC().Format("20060102T150405Z"), CreatedAt: started.Unix()}
+	backup.Key = "tasklists-" + backup.ID + ".tar.gz"
+	dir, err := os.MkdirTemp("", "tasklists-backup-*")
+	if err != nil {
+		return Backup{}, fmt.Errorf("backup: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	if backup.Files, err = m.cfg.Source.Backup(ctx, dir); err != nil {
+		return Backup{}, err
+	}
+	// Checked before the upload as well, since an encrypted backup can only
+	// be checked again with an identity.
+	if err := verifyFiles(ctx, dir, backup.Files); err != nil {
+		return Backup{}, err
+	}
+	var archive bytes.Buffer
+	if len(m.cfg.Recipients) > 0 {
+		if err := packEncrypted(&archive, m.cfg.Recipients, dir, backup); err != nil {
+			return Backup{}, fmt.Errorf("encrypt backup: %w", err)
+		}
+		backup.Key += ".age"
+		backup.Encrypted = true
+	} else if err := pack(&archive, dir, backup); err != nil {
+		return Backup{}, fmt.Errorf("backup: %w", err)
+	}
+	sum := sha256.Sum256(archive.Bytes())
+	backup.Bytes, backup.SHA256 = int64(archive.Len()), hex.EncodeToString(sum[:])
+	if err := m.cfg.Destination.Put(ctx, backup.Key, archive.Bytes()); err != nil {
+		return Backup{}, fmt.Errorf("upload backup: %w", err)
+	}
+	if err := m.verify(ctx, backup); err != nil {
+		_ = m.cfg.Destination.Delete(ctx, backup.Key)
+		return Backup{}, err
+	}
+	backup.Duration = m.now().Sub(started)
+
+	backups, err := m.readIndex(ctx)
+	if err != nil {
+		return Backup{}, err
+	}
+	backups = append(backups, backup)
+	m.mu.Lock()
+	retention := m.retention
+	m.mu.Unlock()
+	kept, expired := retention.apply(backups, m.now())
+	if err := m.writeIndex(ctx, kept); err != nil {
+		return Backup{}, err
+	}
+	// The index no longer lists expired backups, so one that fails to
+	// delete is only left behind, never listed without its object.
+	for _, old := range expired {
+		if err := m.cfg.Destination.Delete(ctx, old.Key); err != nil {
+			log.Printf("backup retention error id=%s: %v", old.ID, err)
+		}
+	}
+	log.Printf("backup written id=%s files=%d bytes=%d duration=%s expired=%d", backup.ID, len(backup.Files), backup.Bytes, backup.Duration, len(expired))
+	return backup, nil
+}
+
+// Stage downloads and verifies the backup id and unpacks it into the stage
+// directory, replacing a restore staged before. ApplyStaged puts it in place
+// on the next start.
+func (m *Manager) Stage(ctx context.Context, id string) (Backup, error) {
+	if m == nil || m.cfg.StageDir == "" {
+		return Backup{}, errors.New("restoring backups is not configured")
+	}
+	backups, err := m.readIndex(ctx)
+	if err != nil {
+		return Backup{}, err
+	}
+	i := slices.IndexFunc(backups, func(backup Backup) bool { return backup.ID == id })
+	if i < 0 {
+		return Backup{}, ErrNotFound
+	}
+	backup := backups[i]
+	if backup.Encrypted && m.cfg.Identity == nil {
+		return Backup{}, ErrNoIdentity
+	}
+	if err := os.RemoveAll(m.cfg.StageDir); err != nil {
+		return Backup{}, fmt.Errorf("stage backup: %w", err)
+	}
+	if err := m.open(ctx, backup, m.cfg.StageDir); err != nil {
+		return Backup{}, err
+	}
+	// The marker is written last: ApplyStaged ignores a stage without it.
+	marker, err := json.Marshal(backup)
+	if err != nil {
+		return Backup{}, err
+	}
+	if err := os.WriteFile(filepath.Join(m.cfg.StageDir, stagedMarker), marker, 0o600); err != nil {
+		return Backup{}, fmt.Errorf("stage backup: %w", err)
+	}
+	log.Printf("backup staged for restore id=%s dir=%s", backup.ID, m.cfg.StageDir)
+	return backup, nil
+}
+
+// verify downloads backup again and checks its files, or only its checksum
+// when it cannot be decrypted here.
+func (m *Manager) verify(ctx context.Context, backup Backup) error {
+	if backup.Encrypted && m.cfg.Identity == nil {
+		_, err := m.download(ctx, backup)
+		return err
+	}
+	dir, err := os.MkdirTemp("", "tasklists-verify-*")
+	if err != nil {
+		return fmt.Errorf("verify backup: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	return m.open(ctx, backup, dir)
+}
+
+// open downloads and decrypts backup, unpacks it into dir, and checks the
+// files against the manifest and their integrity.
+func (m *Manager) open(ctx context.Context, backup Backup, dir string) error {
+	archive, err := m.download(ctx, backup)
+	if err != nil {
+		return err
+	}
+	var reader io.Reader = bytes.NewReader(archive)
+	if backup.Encrypted {
+		if m.cfg.Identity == nil {
+			return ErrNoIdentity
+		}
+		if reader, err = ageDecrypt(reader, *m.cfg.Identity); err != nil {
+			return fmt.Errorf("decrypt backup %s: %w", backup.ID, err)
+		}
+	}
+	if err := unpack(reader, dir); err != nil {
+		return fmt.Errorf("unpack backup %s: %w", backup.ID, err)
+	}
+	if err := checkManifest(dir, backup); err != nil {
+		return fmt.Errorf("verify backup %s: %w", backup.ID, err)
+	}
+	return verifyFiles(ctx, dir, backup.Files)
+}
+
+// checkManifest compares the unpacked files with the manifest. Backups
+// written before manifests existed have none; encrypted ones always do.
+func checkManifest(dir string, backup Backup) error {
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if errors.Is(err, os.ErrNotExist) && !backup.Encrypted {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read manifest: %w", err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("read manifest: %w", err)
+	}
+	if manifest.ID != backup.ID {
+		return fmt.Errorf("manifest belongs to backup %s", manifest.ID)
+	}
+	if len(manifest.Files) != len(backup.Files) {
+		return errors.New("manifest and index list different files")
+	}
+	for i, file := range manifest.Files {
+		if file.Name != backup.Files[i] {
+			return errors.New("manifest and index list different files")
+		}
+		sum, size, err := fileChecksum(filepath.Join(dir, filepath.FromSlash(file.Name)))
+		if err != nil {
+			return err
+		}
+		if sum != file.SHA256 || size != file.Bytes {
+			return fmt.Errorf("%s does not match the manifest", file.Name)
+		}
+	}
+	return nil
+}
+
+func fileChecksum(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() { _ = file.Close() }()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// download fetches backup and checks its checksum.
+func (m *Manager) download(ctx context.Context, backup Backup) ([]byte, error) {
+	archive, err := m.cfg.Destination.Get(ctx, backup.Key)
+	if err != nil {
+		return nil, fmt.Errorf("download backup %s: %w", backup.ID, err)
+	}
+	sum := sha256.Sum256(archive)
+	if hex.EncodeToString(sum[:]) != backup.SHA256 {
+		return nil, fmt.Errorf("backup %s does not match its checksum", backup.ID)
+	}
+	return archive, nil
+}
+
+func verifyFiles(ctx context.Context, dir string, files []string) error {
+	for _, name := range files {
+		if err := storage.VerifyBackupFile(ctx, filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			return fmt.Errorf("verify backup: %w", err)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) readIndex(ctx context.Context) ([]Backup, error) {
+	data, err := m.cfg.Destination.Get(ctx, indexKey)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read backup index: %w", err)
+	}
+	var backups []Backup
+	if err := json.Unmarshal(data, &backups); err != nil {
+		return nil, fmt.Errorf("read backup index: %w", err)
+	}
+	return backups, nil
+}
+
+func (m *Manager) writeIndex(ctx context.Context, backups []Backup) error {
+	data, err := json.Marshal(backups)
+	if err != nil {
+		return err
+	}
+	if err := m.cfg.Destination.Put(ctx, indexKey, data); err != nil {
+		return fmt.Errorf("write backup index: %w", err)
+	}
+	return nil
+}
+
+// apply splits backups, oldest first, into the ones to keep and the ones to
+// delete.
+func (r Retention) apply(backups []Backup, now time.Time) (kept []Backup, expired []Backup) {
+	for i, backup := range backups {
+		newest := i == len(backups)-1
+		tooMany := r.Keep > 0 && len(backups)-i > r.Keep
+		tooOld := r.MaxAge > 0 && now.Sub(time.Unix(backup.CreatedAt, 0)) > r.MaxAge
+		if !newest && (tooMany || tooOld) {
+			expired = append(expired, backup)
+		} else {
+			kept = append(kept, backup)
+		}
+	}
+	return kept, expired
+}
+
+// pack writes the manifest and the files of backup below dir into a
+// gzip-compressed tar.
+func pack(w io.Writer, dir string, backup Backup) error {
+	manifest := Manifest{ID: backup.ID, CreatedAt: backup.CreatedAt}
+	for _, name := range backup.Files {
+		sum, size, err := fileChecksum(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, ManifestFile{Name: name, Bytes: size, SHA256: sum})
+	}
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	if err := tw.WriteHeader(&tar.Header{Name: manifestName, Mode: 0o600, Size: int64(len(manifestData)), ModTime: time.Unix(backup.CreatedAt, 0)}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifestData); err != nil {
+		return err
+	}
+	for _, name := range backup.Files {
+		file, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+		info, err := file.Stat()
+		if err == nil {
+			err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: info.Size(), ModTime: info.ModTime()})
+		}
+		if err == nil {
+			_, err = io.Copy(tw, file)
+		}
+		_ = file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// packEncrypted writes the archive of pack to w, encrypted to recipients.
+func packEncrypted(w io.Writer, recipients []AgeRecipient, dir string, backup Backup) error {
+	encrypted, err := ageEncrypt(w, recipients)
+	if err != nil {
+		return err
+	}
+	if err := pack(encrypted, dir, backup); err != nil {
+		return err
+	}
+	return encrypted.Close()
+}
+
+// unpack extracts a backup written by pack into dir. It reads the archive
+// to its end, so an encrypted one is authenticated in full.
+func unpack(archive io.Reader, dir string) error {
+	zr, err := gzip.NewReader(archive)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(zr)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			_, err = io.Copy(io.Discard, zr)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg || !filepath.IsLocal(filepath.FromSlash(header.Name)) {
+			return fmt.Errorf("unexpected entry %q in backup", header.Name)
+		}
+		path := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, tr)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"a4-tasklists/server/internal/blobstore"
+	"a4-tasklists/server/internal/storage"
+)
+
+func newStore(t *testing.T, path string) *storage.SQLiteStore {
+	t.Helper()
+	store, err := storage.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := store.Init(context.Background()); err != nil {
+		t.Fatalf("init sqlite: %v", err)
+	}
+	return store
+}
+
+func insertOp(t *testing.T, store storage.Store, clock int64) {
+	t.Helper()
+	op := storage.Op{Scope: "list", Resource: "list-1", Actor: "a", Clock: clock, Payload: []byte(`{"type":"insert"}`)}
+	if _, err := store.InsertOps(context.Background(), "user-1", []storage.Op{op}); err != nil {
+		t.Fatalf("insert ops: %v", err)
+	}
+}
+
+func TestRunVerifiesAndAppliesRetention(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t, filepath.Join(t.TempDir(), "data.db"))
+	defer func() { _ = store.Close() }()
+	insertOp(t, store, 1)
+	destination := blobstore.NewMemory()
+	manager := New(Config{Source: store, Destination: destination, Retention: Retention{Keep: 2}})
+	now := time.Date(2026, 3, 14, 3, 0, 0, 0, time.UTC)
+	manager.now = func() time.Time { return now }
+
+	var ids []string
+	for range 3 {
+		backup, err := manager.Run(ctx)
+		if err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		if backup.Bytes == 0 || backup.SHA256 == "" || len(backup.Files) != 1 {
+			t.Fatalf("unexpected backup: %+v", backup)
+		}
+		ids = append(ids, backup.ID)
+		now = now.Add(24 * time.Hour)
+	}
+	backups, err := manager.List(ctx)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(backups) != 2 || backups[0].ID != ids[2] || backups[1].ID != ids[1] {
+		t.Fatalf("expected the two newest backups, newest first, got %+v", backups)
+	}
+	if _, err := destination.Get(ctx, "tasklists-"+ids[0]+".tar.gz"); !errors.Is(err, blobstore.ErrNotFound) {
+		t.Fatalf("expected the oldest backup to be deleted, got %v", err)
+	}
+}
+
+func TestRetentionKeepsNewestBackup(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	backups := []Backup{{ID: "a", CreatedAt: now.Add(-72 * time.Hour).Unix()}, {ID: "b", CreatedAt: now.Add(-48 * time.Hour).Unix()}}
+	kept, expired := Retention{MaxAge: time.Hour}.apply(backups, now)
+	if len(kept) != 1 || kept[0].ID != "b" || len(expired) != 1 || expired[0].ID != "a" {
+		t.Fatalf("expected only the newest backup to survive, kept=%+v expired=%+v", kept, expired)
+	}
+}
+
+func TestRunDeletesBackupThatFailsVerification(t *testing.T) {
+	ctx := context.Background()
+	destination := blobstore.NewMemory()
+	manager := New(Config{Source: brokenSource{}, Destination: destination})
+	if _, err := manager.Run(ctx); err == nil {
+		t.Fatal("expected verification to fail")
+	}
+	if keys := destination.Keys(); len(keys) != 0 {
+		t.Fatalf("expected nothing to be left behind, got %v", keys)
+	}
+}
+
+// brokenSource writes a file that is not a database.
+type brokenSource struct{}
+
+func (brokenSource) Backup(_ context.Context, dir string) ([]string, error) {
+	return []string{storage.BackupMainFile}, os.WriteFile(filepath.Join(dir, storage.BackupMainFile), []byte("garbage"), 0o600)
+}
+
+func TestStageAndApplyRestoresDatabase(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "data.db")
+	store := newStore(t, dbPath)
+	insertOp(t, store, 1)
+	manager := New(Config{Source: store, Destination: blobstore.NewMemory(), StageDir: dbPath + ".restore"})
+	backup, err := manager.Run(ctx)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	insertOp(t, store, 2)
+	if _, err := manager.Stage(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := manager.Stage(ctx, backup.ID); err != nil {
+		t.Fatalf("stage: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	applied, ok, err := ApplyStaged(dbPath+".restore", dbPath, "")
+	if err != nil || !ok || applied.ID != backup.ID {
+		t.Fatalf("apply staged: %+v %v %v", applied, ok, err)
+	}
+	restored := newStore(t, dbPath)
+	defer func() { _ = restored.Close() }()
+	ops, _, err := restored.GetOpsSince(ctx, "user-1", 0)
+	if err != nil || len(ops) != 1 {
+		t.Fatalf("expected the op from before the backup only, got %d: %v", len(ops), err)
+	}
+	matches, _ := filepath.Glob(dbPath + ".pre-restore-*/data.db")
+	if len(matches) != 1 {
+		t.Fatalf("expected the replaced database to be kept aside, got %v", matches)
+	}
+	if _, ok, err := ApplyStaged(dbPath+".restore", dbPath, ""); ok || err != nil {
+		t.Fatalf("expected nothing left to apply, got %v %v", ok, err)
+	}
+}
+
+func TestEncryptedBackupNeedsIdentityToStage(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t, filepath.Join(t.TempDir(), "data.db"))
+	defer func() { _ = store.Close() }()
+	insertOp(t, store, 1)
+	identity := testIdentity(t)
+	destination := blobstore.NewMemory()
+	stageDir := filepath.Join(t.TempDir(), "restore")
+	manager := New(Config{Source: store, Destination: destination, StageDir: stageDir, Recipients: []AgeRecipient{identity.Recipient()}})
+	backup, err := manager.Run(ctx)
+	if err != nil {
+		t.Fatalf("run without identity: %v", err)
+	}
+	if !backup.Encrypted || filepath.Ext(backup.Key) != ".age" {
+		t.Fatalf("expected an encrypted backup, got %+v", backup)
+	}
+	data, err := destination.Get(ctx, backup.Key)
+	if err != nil || !bytes.HasPrefix(data, []byte("age-encryption.org/v1\n")) {
+		t.Fatalf("expected an age file: %v", err)
+	}
+	if _, err := manager.Stage(ctx, backup.ID); !errors.Is(err, ErrNoIdentity) {
+		t.Fatalf("expected ErrNoIdentity, got %v", err)
+	}
+
+	manager = New(Config{Source: store, Destination: destination, StageDir: stageDir, Recipients: []AgeRecipient{identity.Recipient()}, Identity: &identity})
+	if _, err := manager.Stage(ctx, backup.ID); err != nil {
+		t.Fatalf("stage: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(stageDir, storage.BackupMainFile)); err != nil {
+		t.Fatalf("expected the database to be staged: %v", err)
+	}
+}
+
+func TestStageRejectsArchiveOfAnotherBackup(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t, filepath.Join(t.TempDir(), "data.db"))
+	defer func() { _ = store.Close() }()
+	destination := blobstore.NewMemory()
+	manager := New(Config{Source: store, Destination: destination, StageDir: filepath.Join(t.TempDir(), "restore")})
+	now := time.Date(2026, 3, 14, 3, 0, 0, 0, time.UTC)
+	manager.now = func() time.Time { return now }
+	first, err := manager.Run(ctx)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	now = now.Add(time.Hour)
+	insertOp(t, store, 1)
+	second, err := manager.Run(ctx)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	// Swap the archives and fix up the index checksums, as someone with
+	// write access to the destination could.
+	archive, _ := destination.Get(ctx, first.Key)
+	if err := destination.Put(ctx, second.Key, archive); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	backups, err := manager.readIndex(ctx)
+	if err != nil {
+		t.Fatalf("read index: %v", err)
+	}
+	backups[1].SHA256, backups[1].Bytes = first.SHA256, first.Bytes
+	if err := manager.writeIndex(ctx, backups); err != nil {
+		t.Fatalf("write index: %v", err)
+	}
+	if _, err := manager.Stage(ctx, second.ID); err == nil || !strings.Contains(err.Error(), "manifest") {
+		t.Fatalf("expected the manifest to reject the swapped archive, got %v", err)
+	}
+}
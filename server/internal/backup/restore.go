@@ -0,0 +1,85 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+// stagedMarker names the file in the stage directory that marks a complete
+// stage and describes the staged backup.
+const stagedMarker = "backup.json"
+
+// ApplyStaged puts a backup staged by Stage in place of the database at
+// dbPath and, in the per-user layout, of the user files in userDir. It must
+// run before the database is opened. The files it replaces are moved to a
+// directory named after dbPath and the time, so the restore can be undone by
+// hand. It reports whether a backup was applied.
+func ApplyStaged(stageDir string, dbPath string, userDir string) (Backup, bool, error) {
+	data, err := os.ReadFile(filepath.Join(stageDir, stagedMarker))
+	if errors.Is(err, os.ErrNotExist) {
+		return Backup{}, false, nil
+	}
+	if err != nil {
+		return Backup{}, false, fmt.Errorf("read staged restore: %w", err)
+	}
+	var backup Backup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return Backup{}, false, fmt.Errorf("read staged restore: %w", err)
+	}
+	hasUsers := false
+	for _, name := range backup.Files {
+		if strings.HasPrefix(name, storage.BackupUserDir+"/") {
+			hasUsers = true
+		}
+	}
+	if hasUsers && userDir == "" {
+		return Backup{}, false, fmt.Errorf("staged backup %s has per-user databases but SERVER_DB_USER_DIR is not set", backup.ID)
+	}
+
+	aside := fmt.Sprintf("%s.pre-restore-%s", dbPath, time.Now().UTC().Format("20060102T150405Z"))
+	if err := os.MkdirAll(aside, 0o700); err != nil {
+		return Backup{}, false, fmt.Errorf("apply staged restore: %w", err)
+	}
+	// A WAL left next to the old database would be applied to the restored
+	// one, so it moves aside with it.
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if err := moveIfExists(dbPath+suffix, filepath.Join(aside, filepath.Base(dbPath)+suffix)); err != nil {
+			return Backup{}, false, err
+		}
+	}
+	if userDir != "" {
+		if err := moveIfExists(userDir, filepath.Join(aside, storage.BackupUserDir)); err != nil {
+			return Backup{}, false, err
+		}
+		if err := os.MkdirAll(userDir, 0o700); err != nil {
+			return Backup{}, false, fmt.Errorf("apply staged restore: %w", err)
+		}
+	}
+	for _, name := range backup.Files {
+		target := dbPath
+		if name != storage.BackupMainFile {
+			target = filepath.Join(userDir, filepath.Base(name))
+		}
+		if err := os.Rename(filepath.Join(stageDir, filepath.FromSlash(name)), target); err != nil {
+			return Backup{}, false, fmt.Errorf("apply staged restore: %w", err)
+		}
+	}
+	if err := os.RemoveAll(stageDir); err != nil {
+		return Backup{}, false, fmt.Errorf("apply staged restore: %w", err)
+	}
+	return backup, true, nil
+}
+
+func moveIfExists(from string, to string) error {
+	if err := os.Rename(from, to); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("apply staged restore: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package backup
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	from := time.Date(2026, 3, 14, 10, 30, 0, 0, time.UTC) // a Saturday
+	for _, tc := range []struct {
+		expr string
+		want time.Time
+	}{
+		{"@daily", time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2026, 3, 15, 3, 0, 0, 0, time.UTC)},
+		{"*/20 * * * *", time.Date(2026, 3, 14, 10, 40, 0, 0, time.UTC)},
+		{"15 8-18/4 * * *", time.Date(2026, 3, 14, 12, 15, 0, 0, time.UTC)},
+		{"0 2 * * 1-5", time.Date(2026, 3, 16, 2, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 4 * * 7", time.Date(2026, 3, 15, 4, 0, 0, 0, time.UTC)},
+		// Either day field matches when both are restricted.
+		{"0 0 20 * 1", time.Date(2026, 3, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	} {
+		schedule, err := ParseSchedule(tc.expr)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tc.expr, err)
+		}
+		if got := schedule.Next(from); !got.Equal(tc.want) {
+			t.Errorf("%q: got %s, want %s", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestParseScheduleRejectsInvalidExpressions(t *testing.T) {
+	for _, expr := range []string{"", "0 3 * *", "60 * * * *", "0 24 * * *", "0 0 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseSchedule(expr); err == nil {
+			t.Errorf("expected %q to be rejected", expr)
+		}
+	}
+}
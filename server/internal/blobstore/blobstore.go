@@ -0,0 +1,27 @@
+// Package blobstore stores large opaque objects outside the SQLite database.
+//
+// Snapshot blobs can grow to many megabytes for large datasets. Keeping them
+// in an object store keeps the SQLite file small (fast backups, cheap WAL
+// checkpoints) while the database only tracks a reference and checksum.
+package blobstore
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned when an object does not exist.
+var ErrNotFound = errors.New("blob not found")
+
+// Store is a minimal key/value object store.
+type Store interface {
+	// Put stores data under key, replacing any existing object.
+	Put(ctx context.Context, key string, data []byte) error
+
+	// Get returns the object stored under key or ErrNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Delete removes the object stored under key. Deleting a missing object is
+	// not an error.
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,45 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	root := filepath.Join(t.TempDir(), "blobs")
+	store, err := NewDir(root)
+	if err != nil {
+		t.Fatalf("new dir: %v", err)
+	}
+	if err := store.Put(ctx, "ops/1/abc", []byte("v1")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err := store.Put(ctx, "ops/1/abc", []byte("v2")); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	if data, err := store.Get(ctx, "ops/1/abc"); err != nil || string(data) != "v2" {
+		t.Fatalf("get: %q %v", data, err)
+	}
+	entries, err := os.ReadDir(filepath.Join(root, "ops", "1"))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected only the object file, got %v (%v)", entries, err)
+	}
+	if err := store.Delete(ctx, "ops/1/abc"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := store.Delete(ctx, "ops/1/abc"); err != nil {
+		t.Fatalf("deleting a missing object: %v", err)
+	}
+	if _, err := store.Get(ctx, "ops/1/abc"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	for _, key := range []string{"../escape", "/etc/passwd", ""} {
+		if err := store.Put(ctx, key, []byte("x")); err == nil {
+			t.Fatalf("expected key %q to be rejected", key)
+		}
+	}
+}
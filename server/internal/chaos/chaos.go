@@ -0,0 +1,241 @@
+// Package chaos injects failures into /sync/* requests so client developers
+// can exercise offline and retry handling against a local server.
+//
+// It is configured with a comma-separated spec such as
+//
+//	latency=200ms-2s,errors=0.1,conflicts=0.05,drops=0.05
+//
+// where latency is a fixed delay or a min-max range added to every sync
+// request, and the others are the fraction of requests that fail that way:
+//
+//   - errors answers 500 without reaching the server.
+//   - conflicts makes push and pull present an unknown dataset generation
+//     key, so the server answers with its real 409 and snapshot. Pushes
+//     with a binary (CBOR) body are left alone.
+//   - drops lets the server handle the request and then closes the
+//     connection without a response, so pushed ops are stored although the
+//     client never hears back.
+//
+// It is a development tool and must never be enabled in production.
+package chaos
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConflictGenerationKey replaces the client's dataset generation key in
+// requests chosen for a conflict. No dataset ever uses it.
+const ConflictGenerationKey = "chaos-conflict"
+
+// Config describes the failures to inject. The zero value injects nothing.
+type Config struct {
+	MinLatency time.Duration
+	MaxLatency time.Duration
+	// ErrorRate, ConflictRate and DropRate are fractions between 0 and 1.
+	// Each request fails in at most one way.
+	ErrorRate    float64
+	ConflictRate float64
+	DropRate     float64
+}
+
+// Enabled reports whether cfg injects anything.
+func (cfg Config) Enabled() bool {
+	return cfg.MaxLatency > 0 || cfg.ErrorRate > 0 || cfg.ConflictRate > 0 || cfg.DropRate > 0
+}
+
+// String renders cfg in the spec format.
+func (cfg Config) String() string {
+	latency := cfg.MinLatency.String()
+	if cfg.MaxLatency != cfg.MinLatency {
+		latency += "-" + cfg.MaxLatency.String()
+	}
+	return fmt.Sprintf("latency=%s,errors=%g,conflicts=%g,drops=%g", latency, cfg.ErrorRate, cfg.ConflictRate, cfg.DropRate)
+}
+
+// Parse builds a Config from a spec.
+func Parse(spec string) (Config, error) {
+	var cfg Config
+	for entry := range strings.SplitSeq(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return Config{}, fmt.Errorf("invalid chaos setting %q (want name=value)", entry)
+		}
+		value = strings.TrimSpace(value)
+		var err error
+		switch name = strings.TrimSpace(name); name {
+		case "latency":
+			cfg.MinLatency, cfg.MaxLatency, err = parseLatency(value)
+		case "errors":
+			cfg.ErrorRate, err = parseRate(value)
+		case "conflicts":
+			cfg.ConflictRate, err = parseRate(value)
+		case "drops":
+			cfg.DropRate, err = parseRate(value)
+		default:
+			return Config{}, fmt.Errorf("unknown chaos setting %q (known: latency, errors, conflicts, drops)", name)
+		}
+		if err != nil {
+			return Config{}, fmt.Errorf("chaos setting %q: %w", entry, err)
+		}
+	}
+	if cfg.ErrorRate+cfg.ConflictRate+cfg.DropRate > 1 {
+		return Config{}, fmt.Errorf("chaos rates add up to more than 1")
+	}
+	return cfg, nil
+}
+
+func parseLatency(value string) (time.Duration, time.Duration, error) {
+	minValue, maxValue, isRange := strings.Cut(value, "-")
+	minLatency, err := time.ParseDuration(strings.TrimSpace(minValue))
+	if err != nil {
+		return 0, 0, err
+	}
+	maxLatency := minLatency
+	if isRange {
+		if maxLatency, err = time.ParseDuration(strings.TrimSpace(maxValue)); err != nil {
+			return 0, 0, err
+		}
+	}
+	if minLatency < 0 || maxLatency < minLatency {
+		return 0, 0, fmt.Errorf("invalid latency range")
+	}
+	return minLatency, maxLatency, nil
+}
+
+func parseRate(value string) (float64, error) {
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("rate must be between 0 and 1")
+	}
+	return rate, nil
+}
+
+// Injector applies a Config to requests.
+type Injector struct {
+	cfg Config
+	// random returns a number in [0, 1). Tests replace it.
+	random func() float64
+}
+
+// New returns an injector for cfg.
+func New(cfg Config) *Injector {
+	return &Injector{cfg: cfg, random: rand.Float64}
+}
+
+// Middleware injects failures into /sync/* requests and passes everything
+// else through untouched.
+func (c *Injector) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/sync/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !c.delay(r) {
+			return
+		}
+		// One draw decides the failure, so the rates are exact fractions of
+		// all sync requests.
+		draw := c.random()
+		switch {
+		case draw < c.cfg.ErrorRate:
+			log.Printf("chaos inject=error path=%s", r.URL.Path)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = io.WriteString(w, `{"error":"chaos: injected server error"}`+"\n")
+			return
+		case draw < c.cfg.ErrorRate+c.cfg.ConflictRate:
+			if injected, ok := withConflict(r); ok {
+				log.Printf("chaos inject=conflict path=%s", r.URL.Path)
+				r = injected
+			}
+		case draw < c.cfg.ErrorRate+c.cfg.ConflictRate+c.cfg.DropRate:
+			// A stream never completes, so there is no response to drop.
+			if r.URL.Path != "/sync/stream" {
+				log.Printf("chaos inject=drop path=%s", r.URL.Path)
+				next.ServeHTTP(discardWriter{header: http.Header{}}, r)
+				panic(http.ErrAbortHandler)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// delay sleeps for the configured latency. It returns false if the client
+// went away meanwhile.
+func (c *Injector) delay(r *http.Request) bool {
+	latency := c.cfg.MinLatency
+	if spread := c.cfg.MaxLatency - c.cfg.MinLatency; spread > 0 {
+		latency += time.Duration(c.random() * float64(spread))
+	}
+	if latency <= 0 {
+		return true
+	}
+	timer := time.NewTimer(latency)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// withConflict returns r with its dataset generation key replaced by
+// ConflictGenerationKey. Only pull queries and JSON push bodies carry a key
+// that can be replaced.
+func withConflict(r *http.Request) (*http.Request, bool) {
+	switch r.URL.Path {
+	case "/sync/pull":
+		query := r.URL.Query()
+		query.Set("datasetGenerationKey", ConflictGenerationKey)
+		injected := r.Clone(r.Context())
+		injected.URL = &url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		injected.RequestURI = injected.URL.RequestURI()
+		return injected, true
+	case "/sync/push":
+		if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			return r, false
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return r, false
+		}
+		var payload map[string]json.RawMessage
+		if err := json.Unmarshal(body, &payload); err != nil {
+			// Let the server reject the body as usual.
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			return r, false
+		}
+		payload["datasetGenerationKey"], _ = json.Marshal(ConflictGenerationKey)
+		body, _ = json.Marshal(payload)
+		injected := r.Clone(r.Context())
+		injected.Body = io.NopCloser(bytes.NewReader(body))
+		injected.ContentLength = int64(len(body))
+		return injected, true
+	default:
+		return r, false
+	}
+}
+
+// discardWriter swallows the response of a dropped request.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w discardWriter) Header() http.Header         { return w.header }
+func (w discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w discardWriter) WriteHeader(int)             {}
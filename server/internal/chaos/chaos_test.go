@@ -0,0 +1,96 @@
+package chaos
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse("latency=100ms-2s, errors=0.1,conflicts=0.05,drops=0.05")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := Config{MinLatency: 100 * time.Millisecond, MaxLatency: 2 * time.Second, ErrorRate: 0.1, ConflictRate: 0.05, DropRate: 0.05}
+	if cfg != want {
+		t.Fatalf("got %+v, want %+v", cfg, want)
+	}
+	if cfg, err := Parse(""); err != nil || cfg.Enabled() {
+		t.Fatalf("empty spec should disable chaos: %+v %v", cfg, err)
+	}
+	for _, spec := range []string{"errors=2", "latency=2s-1s", "timeouts=0.1", "errors", "errors=0.6,drops=0.6"} {
+		if _, err := Parse(spec); err == nil {
+			t.Fatalf("expected %q to be rejected", spec)
+		}
+	}
+}
+
+// recordingHandler remembers the last request it served.
+type recordingHandler struct {
+	calls   int
+	request *http.Request
+	body    map[string]any
+}
+
+func (h *recordingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.calls++
+	h.request = r
+	h.body = nil
+	_ = json.NewDecoder(r.Body).Decode(&h.body)
+	w.WriteHeader(http.StatusOK)
+}
+
+func newInjector(cfg Config, draw float64) *Injector {
+	injector := New(cfg)
+	injector.random = func() float64 { return draw }
+	return injector
+}
+
+func TestMiddlewareInjectsErrorsAndConflicts(t *testing.T) {
+	cfg := Config{ErrorRate: 0.1, ConflictRate: 0.1, DropRate: 0.1}
+
+	next := &recordingHandler{}
+	recorder := httptest.NewRecorder()
+	newInjector(cfg, 0.05).Middleware(next).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/sync/pull", nil))
+	if recorder.Code != http.StatusInternalServerError || next.calls != 0 {
+		t.Fatalf("expected an injected 500 before the server, got %d with %d calls", recorder.Code, next.calls)
+	}
+
+	conflict := newInjector(cfg, 0.15).Middleware(next)
+	conflict.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/sync/pull?since=3&clientId=c&datasetGenerationKey=real", nil))
+	query := next.request.URL.Query()
+	if query.Get("datasetGenerationKey") != ConflictGenerationKey || query.Get("since") != "3" || query.Get("clientId") != "c" {
+		t.Fatalf("unexpected pull query: %s", next.request.URL.RawQuery)
+	}
+	push := httptest.NewRequest(http.MethodPost, "/sync/push", strings.NewReader(`{"clientId":"c","datasetGenerationKey":"real","ops":[]}`))
+	push.Header.Set("Content-Type", "application/json")
+	conflict.ServeHTTP(httptest.NewRecorder(), push)
+	if next.body["datasetGenerationKey"] != ConflictGenerationKey || next.body["clientId"] != "c" {
+		t.Fatalf("unexpected push body: %+v", next.body)
+	}
+
+	recorder = httptest.NewRecorder()
+	newInjector(cfg, 0).Middleware(next).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/lists", nil))
+	if recorder.Code != http.StatusOK || next.request.URL.Path != "/lists" {
+		t.Fatalf("expected non-sync requests to pass, got %d", recorder.Code)
+	}
+}
+
+func TestMiddlewareDropsResponsesAfterTheServerHandledThem(t *testing.T) {
+	next := &recordingHandler{}
+	handler := newInjector(Config{DropRate: 1}, 0.5).Middleware(next)
+	defer func() {
+		if err, _ := recover().(error); !errors.Is(err, http.ErrAbortHandler) {
+			t.Fatalf("expected the connection to be aborted, got %v", err)
+		}
+		if next.calls != 1 {
+			t.Fatalf("expected the server to handle the dropped request, got %d calls", next.calls)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/sync/push", strings.NewReader(`{}`)))
+	t.Fatalf("expected the response to be dropped")
+}
@@ -0,0 +1,408 @@
+// Package compaction folds a user's op log into a fresh snapshot generation.
+//
+// Clients restore from the snapshot on their next pull (the generation key no
+// longer matches, so the server answers 409 with the new snapshot), which keeps
+// bootstrap and replay costs bounded as the op log grows.
+package compaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/quarantine"
+	"a4-tasklists/server/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// Thresholds configure when a generation is compacted automatically. A zero
+// value disables that threshold; both zero disables auto-compaction.
+type Thresholds struct {
+	MaxOps     int64
+	MaxOpBytes int64
+}
+
+// Enabled reports whether any threshold is configured.
+func (t Thresholds) Enabled() bool {
+	return t.MaxOps > 0 || t.MaxOpBytes > 0
+}
+
+// Exceeded reports whether stats cross any configured threshold.
+func (t Thresholds) Exceeded(stats storage.OpStats) bool {
+	if t.MaxOps > 0 && stats.Count >= t.MaxOps {
+		return true
+	}
+	return t.MaxOpBytes > 0 && stats.Bytes >= t.MaxOpBytes
+}
+
+// Result describes a compaction run.
+type Result struct {
+	Compacted                    bool          `json:"compacted"`
+	PreviousDatasetGenerationKey string        `json:"previousDatasetGenerationKey"`
+	DatasetGenerationKey         string        `json:"datasetGenerationKey"`
+	FoldedOps                    int64         `json:"foldedOps"`
+	FoldedBytes                  int64         `json:"foldedBytes"`
+	SnapshotBytes                int           `json:"snapshotBytes"`
+	Duration                     time.Duration `json:"duration"`
+	// UnpulledOps are the folded ops some client had not pulled yet. Such
+	// clients restore from the new snapshot instead of catching up.
+	UnpulledOps int64 `json:"unpulledOps"`
+}
+
+// Triggers of a compaction run.
+const (
+	// TriggerAuto means the op log crossed a threshold after a push.
+	TriggerAuto = "auto"
+	// TriggerManual means an operator asked for the compaction.
+	TriggerManual = "manual"
+)
+
+// maxRuns bounds the run history kept in memory.
+const maxRuns = 50
+
+// Run records a compaction attempt that got past the threshold check.
+type Run struct {
+	UserID    string `json:"userId"`
+	Trigger   string `json:"trigger"`
+	StartedAt int64  `json:"startedAt"`
+	Result
+	Error string `json:"error,omitempty"`
+}
+
+// ErrRunning is returned by Compact while the user's op log is already being
+// compacted.
+var ErrRunning = errors.New("compaction already running")
+
+// Compactor runs compactions, at most one per user at a time.
+type Compactor struct {
+	store   storage.Store
+	timeout time.Duration
+
+	mu         sync.Mutex
+	thresholds Thresholds
+	quarantine *quarantine.Tracker
+	compacted  func(userID string)
+	running    map[string]struct{}
+	runs       []Run
+	wg         sync.WaitGroup
+}
+
+func New(store storage.Store, thresholds Thresholds) *Compactor {
+	return &Compactor{
+		store:      store,
+		thresholds: thresholds,
+		timeout:    time.Minute,
+		running:    make(map[string]struct{}),
+	}
+}
+
+// Thresholds returns the configured auto-compaction thresholds.
+func (c *Compactor) Thresholds() Thresholds {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.thresholds
+}
+
+// SetThresholds replaces the auto-compaction thresholds, e.g. after a
+// configuration reload.
+func (c *Compactor) SetThresholds(thresholds Thresholds) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.thresholds = thresholds
+}
+
+// Quarantine returns the tracker compactions report failing ops to, if any.
+func (c *Compactor) Quarantine() *quarantine.Tracker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.quarantine
+}
+
+// SetQuarantine makes compactions quarantine the ops that fail to
+// materialize through tracker, instead of folding them away unnoticed.
+func (c *Compactor) SetQuarantine(tracker *quarantine.Tracker) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.quarantine = tracker
+}
+
+// OnCompacted makes every successful compaction call fn with the user's id,
+// e.g. to mirror the new generation. fn runs on the compaction's goroutine
+// and must not block.
+func (c *Compactor) OnCompacted(fn func(userID string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.compacted = fn
+}
+
+// Trigger checks the user's thresholds in the background and compacts when
+// they are exceeded. It never blocks the caller and coalesces concurrent
+// triggers for the same user.
+func (c *Compactor) Trigger(userID string) {
+	if c == nil || !c.Thresholds().Enabled() {
+		return
+	}
+	if !c.acquire(userID) {
+		return
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer c.release(userID)
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		defer cancel()
+		if _, err := c.maybeCompact(ctx, userID); err != nil {
+			if errors.Is(err, storage.ErrDatasetGenerationChanged) {
+				log.Printf("snapshot compaction skipped user=%s: op log changed during compaction", userID)
+				return
+			}
+			log.Printf("snapshot compaction error user=%s: %v", userID, err)
+		}
+	}()
+}
+
+// Wait blocks until background compactions have finished.
+func (c *Compactor) Wait() {
+	if c == nil {
+		return
+	}
+	c.wg.Wait()
+}
+
+// MaybeCompact compacts synchronously when the thresholds are exceeded.
+func (c *Compactor) MaybeCompact(ctx context.Context, userID string) (Result, error) {
+	if !c.acquire(userID) {
+		return Result{}, nil
+	}
+	defer c.release(userID)
+	return c.maybeCompact(ctx, userID)
+}
+
+func (c *Compactor) maybeCompact(ctx context.Context, userID string) (Result, error) {
+	stats, err := c.store.GetOpStats(ctx, userID)
+	if err != nil {
+		return Result{}, err
+	}
+	if !c.Thresholds().Exceeded(stats) {
+		return Result{}, nil
+	}
+	return c.record(ctx, userID, TriggerAuto)
+}
+
+// Compact unconditionally folds the op log into a new generation.
+func (c *Compactor) Compact(ctx context.Context, userID string) (Result, error) {
+	if !c.acquire(userID) {
+		return Result{}, ErrRunning
+	}
+	defer c.release(userID)
+	return c.record(ctx, userID, TriggerManual)
+}
+
+// Preview describes what compacting a user's op log now would do. It is the
+// outcome of a dry run that folds the op log without storing anything.
+type Preview struct {
+	DatasetGenerationKey string `json:"datasetGenerationKey"`
+	FoldedOps            int64  `json:"foldedOps"`
+	FoldedBytes          int64  `json:"foldedBytes"`
+	SnapshotBytes        int    `json:"snapshotBytes"`
+	UnpulledOps          int64  `json:"unpulledOps"`
+	// RejectedOps fail to materialize. A run quarantines them when a
+	// quarantine is configured and otherwise folds them away.
+	RejectedOps int64 `json:"rejectedOps"`
+	// ResyncClients are the clients that have not pulled every folded op and
+	// would restore from the snapshot instead of catching up.
+	ResyncClients []string `json:"resyncClients"`
+	// EstimatedDuration is how long the dry run took. A run does the same
+	// work plus one write, so it takes about as long.
+	EstimatedDuration time.Duration `json:"estimatedDuration"`
+}
+
+// Preview folds the op log like Compact would but leaves the snapshot, the
+// op log, and the quarantine untouched. It does not count as a run.
+func (c *Compactor) Preview(ctx context.Context, userID string) (Preview, error) {
+	f, err := c.fold(ctx, userID)
+	if err != nil {
+		return Preview{}, err
+	}
+	clients, err := c.store.ListClients(ctx, userID)
+	if err != nil {
+		return Preview{}, err
+	}
+	resync := []string{}
+	for _, client := range clients {
+		if client.LastSeenServerSeq < f.serverSeq {
+			resync = append(resync, client.ClientID)
+		}
+	}
+	return Preview{
+		DatasetGenerationKey: f.snapshot.DatasetGenerationKey,
+		FoldedOps:            f.foldedOps,
+		FoldedBytes:          f.foldedBytes,
+		SnapshotBytes:        len(f.blob),
+		UnpulledOps:          f.unpulledOps,
+		RejectedOps:          int64(len(f.rejections)),
+		ResyncClients:        resync,
+		EstimatedDuration:    time.Since(f.started),
+	}, nil
+}
+
+// Runs returns the most recent compaction runs, newest first. The history is
+// kept in memory and starts over on restart.
+func (c *Compactor) Runs() []Run {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	runs := slices.Clone(c.runs)
+	slices.Reverse(runs)
+	return runs
+}
+
+// record compacts and adds the attempt to the run history.
+func (c *Compactor) record(ctx context.Context, userID string, trigger string) (Result, error) {
+	run := Run{UserID: userID, Trigger: trigger, StartedAt: time.Now().Unix()}
+	result, err := c.compact(ctx, userID)
+	run.Result = result
+	if err != nil {
+		run.Error = err.Error()
+	}
+	c.mu.Lock()
+	if len(c.runs) == maxRuns {
+		c.runs = slices.Delete(c.runs, 0, 1)
+	}
+	c.runs = append(c.runs, run)
+	compacted := c.compacted
+	c.mu.Unlock()
+	if err == nil && compacted != nil {
+		compacted(userID)
+	}
+	return result, err
+}
+
+// fold is the outcome of replaying a user's op log onto its snapshot.
+type fold struct {
+	snapshot storage.Snapshot
+	blob     string
+	started  time.Time
+	// serverSeq is the latest serverSeq overall, lastApplied the latest
+	// among the ops that applied.
+	serverSeq, lastApplied              int64
+	foldedOps, foldedBytes, unpulledOps int64
+	rejections                          []materialize.Rejection
+	unpulled                            func(storage.Op) bool
+	minCursor                           int64
+	hasClients                          bool
+}
+
+// fold replays the op log and encodes the resulting snapshot without storing
+// anything.
+func (c *Compactor) fold(ctx context.Context, userID string) (fold, error) {
+	f := fold{started: time.Now()}
+	snapshot, err := c.store.GetSnapshot(ctx, userID)
+	if err != nil {
+		return fold{}, err
+	}
+	f.snapshot = snapshot
+	replay, err := materialize.NewReplay(snapshot.Blob)
+	if err != nil {
+		return fold{}, fmt.Errorf("materialize: %w", err)
+	}
+	f.minCursor, f.hasClients, err = c.store.MinClientCursor(ctx, userID)
+	if err != nil {
+		return fold{}, err
+	}
+	f.unpulled = func(op storage.Op) bool { return f.hasClients && op.ServerSeq > f.minCursor }
+	// The op log is streamed, so only counts are kept.
+	f.serverSeq, err = c.store.ForEachOpSince(ctx, userID, 0, func(op storage.Op) error {
+		f.foldedOps++
+		f.foldedBytes += int64(len(op.Payload))
+		if f.unpulled(op) {
+			f.unpulledOps++
+		}
+		if replay.Apply(op) {
+			f.lastApplied = op.ServerSeq
+		}
+		return nil
+	})
+	if err != nil {
+		return fold{}, err
+	}
+	f.rejections = replay.Rejections()
+	f.blob, err = materialize.EncodeSnapshot(replay.State(), f.started)
+	if err != nil {
+		return fold{}, fmt.Errorf("encode snapshot: %w", err)
+	}
+	return f, nil
+}
+
+func (c *Compactor) compact(ctx context.Context, userID string) (Result, error) {
+	f, err := c.fold(ctx, userID)
+	if err != nil {
+		return Result{}, err
+	}
+	// Folding drops the ops that failed to apply, so keep them for the
+	// operator instead. They leave the op log, which the precondition below
+	// has to expect.
+	serverSeq := f.serverSeq
+	if quarantined := c.Quarantine().Quarantine(ctx, userID, f.rejections); len(quarantined) > 0 {
+		serverSeq = f.lastApplied
+		for _, rejection := range f.rejections {
+			if slices.Contains(quarantined, rejection.Op.ServerSeq) {
+				f.foldedOps--
+				f.foldedBytes -= int64(len(rejection.Op.Payload))
+				if f.unpulled(rejection.Op) {
+					f.unpulledOps--
+				}
+			} else {
+				serverSeq = max(serverSeq, rejection.Op.ServerSeq)
+			}
+		}
+	}
+	result := Result{
+		Compacted:                    true,
+		PreviousDatasetGenerationKey: f.snapshot.DatasetGenerationKey,
+		DatasetGenerationKey:         uuid.NewString(),
+		FoldedOps:                    f.foldedOps,
+		FoldedBytes:                  f.foldedBytes,
+		UnpulledOps:                  f.unpulledOps,
+		SnapshotBytes:                len(f.blob),
+	}
+	if err := c.store.ReplaceSnapshotIf(ctx, userID, storage.Snapshot{
+		DatasetGenerationKey:       result.DatasetGenerationKey,
+		Blob:                       f.blob,
+		ParentDatasetGenerationKey: f.snapshot.DatasetGenerationKey,
+	}, storage.SnapshotPrecondition{
+		DatasetGenerationKey: f.snapshot.DatasetGenerationKey,
+		MaxServerSeq:         serverSeq,
+		CheckServerSeq:       true,
+	}); err != nil {
+		return Result{}, err
+	}
+	result.Duration = time.Since(f.started)
+	log.Printf("snapshot compaction user=%s generation=%s->%s folded_ops=%d folded_bytes=%d unpulled_ops=%d snapshot_bytes=%d duration=%s",
+		userID, result.PreviousDatasetGenerationKey, result.DatasetGenerationKey, result.FoldedOps, result.FoldedBytes, result.UnpulledOps, result.SnapshotBytes, result.Duration)
+	return result, nil
+}
+
+func (c *Compactor) acquire(userID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.running[userID]; ok {
+		return false
+	}
+	c.running[userID] = struct{}{}
+	return true
+}
+
+func (c *Compactor) release(userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.running, userID)
+}
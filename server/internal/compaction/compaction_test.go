@@ -0,0 +1,304 @@
+package compaction
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"testing"
+
+	"a4-tasklists/server/internal/blobstore"
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/quarantine"
+	"a4-tasklists/server/internal/storage"
+)
+
+func newStore(t *testing.T) *storage.SQLiteStore {
+	t.Helper()
+	store, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := store.Init(context.Background()); err != nil {
+		t.Fatalf("init sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func seedOps(t *testing.T, store storage.Store, userID string) {
+	t.Helper()
+	if _, err := store.InsertOps(context.Background(), userID, []storage.Op{
+		{Scope: "registry", Resource: "registry", Actor: "a", Clock: 1, Payload: []byte(`{"type":"createList","listId":"list-1","payload":{"title":"Inbox","pos":[{"digit":512,"actor":"a"}]}}`)},
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 2, Payload: []byte(`{"type":"insert","itemId":"item-1","payload":{"data":{"text":"milk"},"pos":[{"digit":512,"actor":"a"}]}}`)},
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 3, Payload: []byte(`{"type":"addTag","itemId":"item-1","payload":{"tag":"dairy"}}`)},
+	}); err != nil {
+		t.Fatalf("insert ops: %v", err)
+	}
+}
+
+func TestMaybeCompactBelowThreshold(t *testing.T) {
+	store := newStore(t)
+	seedOps(t, store, "user-1")
+	compactor := New(store, Thresholds{MaxOps: 10})
+	result, err := compactor.MaybeCompact(context.Background(), "user-1")
+	if err != nil {
+		t.Fatalf("maybe compact: %v", err)
+	}
+	if result.Compacted {
+		t.Fatalf("should not compact below threshold")
+	}
+}
+
+func TestCompactCountsOpsSlowestClientHasNotPulled(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t)
+	seedOps(t, store, "user-1")
+	ops, serverSeq, err := store.GetOpsSince(ctx, "user-1", 0)
+	if err != nil {
+		t.Fatalf("get ops: %v", err)
+	}
+	for clientID, cursor := range map[string]int64{"phone": serverSeq, "laptop": ops[0].ServerSeq} {
+		if err := store.UpdateClientCursor(ctx, "user-1", clientID, cursor); err != nil {
+			t.Fatalf("update cursor: %v", err)
+		}
+	}
+	result, err := New(store, Thresholds{}).Compact(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if result.FoldedOps != 3 || result.UnpulledOps != 2 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+}
+
+func TestCompactRemovesOffloadedPayloads(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	blobDir := t.TempDir()
+	blobs, err := blobstore.NewDir(blobDir)
+	if err != nil {
+		t.Fatalf("blob dir: %v", err)
+	}
+	store.StorePayloadsIn(blobs)
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	// The second batch only repeats ops the store already has.
+	seedOps(t, store, "user-1")
+	seedOps(t, store, "user-1")
+	if files := blobFiles(t, filepath.Join(blobDir, "ops")); len(files) != 3 {
+		t.Fatalf("expected one object per stored op, got %v", files)
+	}
+
+	if _, err := New(store, Thresholds{}).Compact(ctx, "user-1"); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if files := blobFiles(t, filepath.Join(blobDir, "ops")); len(files) != 0 {
+		t.Fatalf("expected compaction to remove the payload objects, got %v", files)
+	}
+	if _, err := store.GetSnapshot(ctx, "user-1"); err != nil {
+		t.Fatalf("get snapshot: %v", err)
+	}
+}
+
+func blobFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk %s: %v", dir, err)
+	}
+	return files
+}
+
+func TestMaybeCompactFoldsOpLog(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t)
+	seedOps(t, store, "user-1")
+	before, err := store.GetActiveDatasetGenerationKey(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("active key: %v", err)
+	}
+	compactor := New(store, Thresholds{MaxOps: 3})
+	result, err := compactor.MaybeCompact(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("maybe compact: %v", err)
+	}
+	if !result.Compacted || result.FoldedOps != 3 || result.PreviousDatasetGenerationKey != before {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	ops, _, err := store.GetOpsSince(ctx, "user-1", 0)
+	if err != nil {
+		t.Fatalf("get ops: %v", err)
+	}
+	if len(ops) != 0 {
+		t.Fatalf("ops should be folded, got %d", len(ops))
+	}
+	snapshot, err := store.GetSnapshot(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("get snapshot: %v", err)
+	}
+	if snapshot.DatasetGenerationKey != result.DatasetGenerationKey {
+		t.Fatalf("generation not switched: %s", snapshot.DatasetGenerationKey)
+	}
+	lineage, err := store.GetGenerationLineage(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("lineage: %v", err)
+	}
+	if len(lineage) != 2 || lineage[1] != before {
+		t.Fatalf("compacted generation should descend from %s: %v", before, lineage)
+	}
+	state, err := materialize.Build(snapshot.Blob, nil)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	list, ok := state.FindList("list-1")
+	if !ok || len(list.Items) != 1 || list.Items[0].Text != "milk" {
+		t.Fatalf("unexpected compacted state: %+v", state)
+	}
+	tags, err := store.ListTags(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("list tags: %v", err)
+	}
+	if len(tags) != 1 || tags[0].Tag != "dairy" {
+		t.Fatalf("tags should survive compaction: %+v", tags)
+	}
+}
+
+func TestCompactQuarantinesOpsItCannotApply(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t)
+	seedOps(t, store, "user-1")
+	if _, err := store.InsertOps(ctx, "user-1", []storage.Op{
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 4, Payload: []byte(`{"type":"update","itemId":42}`)},
+	}); err != nil {
+		t.Fatalf("insert ops: %v", err)
+	}
+	compactor := New(store, Thresholds{})
+	compactor.SetQuarantine(quarantine.New(store, quarantine.DefaultThreshold))
+	result, err := compactor.Compact(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if !result.Compacted || result.FoldedOps != 3 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	quarantined, err := store.ListQuarantinedOps(ctx)
+	if err != nil {
+		t.Fatalf("list quarantined ops: %v", err)
+	}
+	if len(quarantined) != 1 || quarantined[0].Clock != 4 {
+		t.Fatalf("expected the bad op in quarantine, got %+v", quarantined)
+	}
+}
+
+func TestPreviewLeavesOpLogAndRunsUntouched(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t)
+	seedOps(t, store, "user-1")
+	ops, serverSeq, err := store.GetOpsSince(ctx, "user-1", 0)
+	if err != nil {
+		t.Fatalf("get ops: %v", err)
+	}
+	for clientID, cursor := range map[string]int64{"phone": serverSeq, "laptop": ops[0].ServerSeq} {
+		if err := store.UpdateClientCursor(ctx, "user-1", clientID, cursor); err != nil {
+			t.Fatalf("update cursor: %v", err)
+		}
+	}
+	before, err := store.GetSnapshot(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("get snapshot: %v", err)
+	}
+	compactor := New(store, Thresholds{})
+	preview, err := compactor.Preview(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("preview: %v", err)
+	}
+	if preview.DatasetGenerationKey != before.DatasetGenerationKey || preview.FoldedOps != 3 || preview.UnpulledOps != 2 || preview.SnapshotBytes == 0 || preview.RejectedOps != 0 {
+		t.Fatalf("unexpected preview: %+v", preview)
+	}
+	if len(preview.ResyncClients) != 1 || preview.ResyncClients[0] != "laptop" {
+		t.Fatalf("expected only the lagging client to resync, got %v", preview.ResyncClients)
+	}
+	after, err := store.GetSnapshot(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("get snapshot: %v", err)
+	}
+	stats, err := store.GetOpStats(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("op stats: %v", err)
+	}
+	if after.DatasetGenerationKey != before.DatasetGenerationKey || stats.Count != 3 || len(compactor.Runs()) != 0 {
+		t.Fatalf("a preview should not compact: generation=%s ops=%d runs=%d", after.DatasetGenerationKey, stats.Count, len(compactor.Runs()))
+	}
+	result, err := compactor.Compact(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if result.FoldedOps != preview.FoldedOps || result.UnpulledOps != preview.UnpulledOps {
+		t.Fatalf("preview %+v does not match the run %+v", preview, result)
+	}
+}
+
+func TestRunsRecordCompactionAttempts(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t)
+	seedOps(t, store, "user-1")
+	compactor := New(store, Thresholds{MaxOps: 3})
+	if _, err := compactor.MaybeCompact(ctx, "user-1"); err != nil {
+		t.Fatalf("maybe compact: %v", err)
+	}
+	// Below the threshold nothing is attempted or recorded.
+	if _, err := compactor.MaybeCompact(ctx, "user-1"); err != nil {
+		t.Fatalf("maybe compact: %v", err)
+	}
+	if _, err := compactor.Compact(ctx, "user-1"); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	runs := compactor.Runs()
+	if len(runs) != 2 {
+		t.Fatalf("expected two runs, got %+v", runs)
+	}
+	if runs[0].Trigger != TriggerManual || runs[0].FoldedOps != 0 || runs[1].Trigger != TriggerAuto || runs[1].FoldedOps != 3 || runs[1].UserID != "user-1" || runs[1].Error != "" {
+		t.Fatalf("unexpected runs, newest first: %+v", runs)
+	}
+	for range maxRuns {
+		if _, err := compactor.Compact(ctx, "user-1"); err != nil {
+			t.Fatalf("compact: %v", err)
+		}
+	}
+	if runs := compactor.Runs(); len(runs) != maxRuns {
+		t.Fatalf("expected the history to be capped at %d, got %d", maxRuns, len(runs))
+	}
+}
+
+func TestOnCompactedFollowsSuccessfulRuns(t *testing.T) {
+	ctx := context.Background()
+	store := newStore(t)
+	seedOps(t, store, "user-1")
+	compactor := New(store, Thresholds{MaxOps: 3})
+	var compacted []string
+	compactor.OnCompacted(func(userID string) { compacted = append(compacted, userID) })
+	if _, err := compactor.MaybeCompact(ctx, "user-1"); err != nil {
+		t.Fatalf("maybe compact: %v", err)
+	}
+	// Below the threshold nothing is compacted.
+	if _, err := compactor.MaybeCompact(ctx, "user-1"); err != nil {
+		t.Fatalf("maybe compact: %v", err)
+	}
+	if len(compacted) != 1 || compacted[0] != "user-1" {
+		t.Fatalf("expected one call for user-1, got %v", compacted)
+	}
+}
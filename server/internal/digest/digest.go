@@ -0,0 +1,278 @@
+// Package digest emails users who opted in a periodic summary of the items
+// added to and completed in their lists.
+//
+// Each run walks the users with a digest frequency and, once their next send
+// time has come, materializes their active generation to name the items
+// changed since the previous digest. Send times are in the user's time zone:
+// daily digests go out at SendHour, weekly ones at SendHour on Monday.
+// Activity that compaction folded into a snapshot before a digest went out is
+// not reported, nor is activity in lists the user muted. The email is written
+// with the "digest" mail templates.
+package digest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"a4-tasklists/server/internal/i18n"
+	"a4-tasklists/server/internal/mail"
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/storage"
+)
+
+// SendHour is the local hour digests go out at, so they arrive with the
+// morning's mail.
+const SendHour = 7
+
+// Period returns how often digests of the given frequency go out, or 0 for an
+// unknown frequency.
+func Period(frequency string) time.Duration {
+	switch frequency {
+	case storage.DigestDaily:
+		return 24 * time.Hour
+	case storage.DigestWeekly:
+		return 7 * 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+// LastSendTime returns the latest send time of the frequency at or before now
+// in location. It counts calendar days, so it stays at SendHour across
+// daylight saving changes.
+func LastSendTime(frequency string, now time.Time, location *time.Location) time.Time {
+	local := now.In(location)
+	send := time.Date(local.Year(), local.Month(), local.Day(), SendHour, 0, 0, 0, location)
+	if send.After(local) {
+		send = time.Date(local.Year(), local.Month(), local.Day()-1, SendHour, 0, 0, 0, location)
+	}
+	if frequency == storage.DigestWeekly {
+		sinceMonday := (int(send.Weekday()) + 6) % 7
+		send = time.Date(send.Year(), send.Month(), send.Day()-sinceMonday, SendHour, 0, 0, 0, location)
+	}
+	return send
+}
+
+// location returns the named time zone, or the server's for an empty or
+// unknown name.
+func location(name string) *time.Location {
+	if name == "" {
+		return time.Local
+	}
+	zone, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("digest ignoring unknown time zone %q: %v", name, err)
+		return time.Local
+	}
+	return zone
+}
+
+// List is one list's section of a digest.
+type List struct {
+	Title     string
+	Added     []string
+	Completed []string
+}
+
+// Digest is the activity reported to one user.
+type Digest struct {
+	Frequency string
+	Lists     []List
+}
+
+// Empty reports whether there is nothing to send.
+func (d Digest) Empty() bool {
+	return len(d.Lists) == 0
+}
+
+// Runner sends due digests.
+type Runner struct {
+	store     storage.Store
+	sender    mail.Sender
+	messages  *i18n.Catalog
+	templates *mail.Templates
+	now       func() time.Time
+}
+
+// New returns a runner writing digests with templates (nil for the built-in
+// ones) in each user's language from messages; nil messages write English.
+func New(store storage.Store, sender mail.Sender, messages *i18n.Catalog, templates *mail.Templates) *Runner {
+	if templates == nil {
+		templates = mail.Builtin()
+	}
+	return &Runner{store: store, sender: sender, messages: messages, templates: templates, now: time.Now}
+}
+
+// Run calls RunOnce every interval until ctx is done.
+func (r *Runner) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sent, err := r.RunOnce(ctx)
+			if err != nil {
+				log.Printf("digest run error: %v", err)
+			}
+			if sent > 0 {
+				log.Printf("digests sent count=%d", sent)
+			}
+		}
+	}
+}
+
+// RunOnce sends every digest that is due and returns how many went out. A
+// failure for one user does not stop the others; the joined errors are
+// returned.
+//
+// A subscription that has never been sent only records its starting point, so
+// the first digest covers the time up to the next send time. Users without
+// activity or without an email address are skipped but still advance, so
+// nothing piles up.
+func (r *Runner) RunOnce(ctx context.Context) (int, error) {
+	subscriptions, err := r.store.ListDigestSubscriptions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	now := r.now()
+	sent := 0
+	var errs []error
+	for _, subscription := range subscriptions {
+		ok, err := r.process(ctx, subscription, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("user %s: %w", subscription.UserID, err))
+			continue
+		}
+		if ok {
+			sent++
+		}
+	}
+	return sent, errors.Join(errs...)
+}
+
+func (r *Runner) process(ctx context.Context, subscription storage.DigestSettings, now time.Time) (bool, error) {
+	if Period(subscription.Frequency) == 0 {
+		return false, fmt.Errorf("unknown digest frequency %q", subscription.Frequency)
+	}
+	if subscription.LastSentAt == 0 {
+		stats, err := r.store.GetOpStats(ctx, subscription.UserID)
+		if err != nil {
+			return false, err
+		}
+		return false, r.store.MarkDigestSent(ctx, subscription.UserID, now.Unix(), stats.MaxServerSeq)
+	}
+	if subscription.LastSentAt >= LastSendTime(subscription.Frequency, now, location(subscription.TimeZone)).Unix() {
+		return false, nil
+	}
+	digest, serverSeq, err := r.Build(ctx, subscription.UserID, subscription.LastServerSeq)
+	if err != nil {
+		return false, err
+	}
+	digest.Frequency = subscription.Frequency
+	profile, err := r.store.GetUserProfile(ctx, subscription.UserID)
+	if err != nil {
+		return false, err
+	}
+	delivered := false
+	if !digest.Empty() && profile.Email != "" {
+		msg, err := Compose(r.templates, digest, r.messages.Printer(profile.Locale))
+		if err != nil {
+			return false, fmt.Errorf("compose digest: %w", err)
+		}
+		if err := r.sender.Send(ctx, profile.Email, msg); err != nil {
+			return false, fmt.Errorf("send digest: %w", err)
+		}
+		delivered = true
+	}
+	return delivered, r.store.MarkDigestSent(ctx, subscription.UserID, now.Unix(), serverSeq)
+}
+
+// Build collects the items added and completed after since, named by the
+// user's current state. Items removed in the meantime and lists the user
+// muted are left out. It returns the serverSeq the digest covers.
+func (r *Runner) Build(ctx context.Context, userID string, since int64) (Digest, int64, error) {
+	snapshot, err := r.store.GetSnapshot(ctx, userID)
+	if err != nil {
+		return Digest{}, 0, err
+	}
+	muted, err := r.store.ListMutedLists(ctx, userID)
+	if err != nil {
+		return Digest{}, 0, err
+	}
+	ops, serverSeq, err := r.store.GetOpsSince(ctx, userID, 0)
+	if err != nil {
+		return Digest{}, 0, err
+	}
+	state, err := materialize.Build(snapshot.Blob, ops)
+	if err != nil {
+		return Digest{}, 0, fmt.Errorf("materialize state: %w", err)
+	}
+	recent := make([]storage.Op, 0)
+	for _, op := range ops {
+		if op.ServerSeq > since {
+			recent = append(recent, op)
+		}
+	}
+	digest := Digest{Lists: make([]List, 0)}
+	for _, activity := range materialize.Activity(recent) {
+		list, ok := state.FindList(activity.ListID)
+		if !ok || slices.Contains(muted, activity.ListID) {
+			continue
+		}
+		items := make(map[string]materialize.Item, len(list.Items))
+		for _, item := range list.Items {
+			items[item.ID] = item
+		}
+		section := List{Title: list.Title}
+		for _, itemID := range activity.Added {
+			if item, ok := items[itemID]; ok {
+				section.Added = append(section.Added, item.Text)
+			}
+		}
+		for _, itemID := range activity.Completed {
+			if item, ok := items[itemID]; ok && item.Done {
+				section.Completed = append(section.Completed, item.Text)
+			}
+		}
+		if len(section.Added)+len(section.Completed) > 0 {
+			digest.Lists = append(digest.Lists, section)
+		}
+	}
+	return digest, serverSeq, nil
+}
+
+// view is what the digest templates see.
+type view struct {
+	Frequency string
+	Added     int
+	Completed int
+	Lists     []List
+}
+
+// Compose renders the digest as an email in the language of p.
+func Compose(templates *mail.Templates, digest Digest, p i18n.Printer) (mail.Message, error) {
+	data := view{Frequency: digest.Frequency, Lists: make([]List, len(digest.Lists))}
+	for i, list := range digest.Lists {
+		if list.Title == "" {
+			list.Title = p.Text("Untitled list")
+		}
+		data.Lists[i] = list
+		data.Added += len(list.Added)
+		data.Completed += len(list.Completed)
+	}
+	return templates.Render("digest", p, data)
+}
+
+// Sample returns a digest to preview the templates with.
+func Sample() Digest {
+	return Digest{Frequency: storage.DigestDaily, Lists: []List{
+		{Title: "Groceries", Added: []string{"Oat milk", "Apples"}, Completed: []string{"Coffee beans"}},
+		{Title: "Weekend", Completed: []string{"Book the train"}},
+	}}
+}
@@ -0,0 +1,185 @@
+package digest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"a4-tasklists/server/internal/i18n"
+	"a4-tasklists/server/internal/mail"
+	"a4-tasklists/server/internal/storage"
+)
+
+type sentMail struct {
+	to      string
+	subject string
+	body    string
+	html    string
+}
+
+type recordingSender struct {
+	sent []sentMail
+}
+
+func (s *recordingSender) Send(_ context.Context, to string, msg mail.Message) error {
+	s.sent = append(s.sent, sentMail{to: to, subject: msg.Subject, body: msg.Text, html: msg.HTML})
+	return nil
+}
+
+func insertOps(t *testing.T, store storage.Store, ops ...storage.Op) {
+	t.Helper()
+	if _, err := store.InsertOps(context.Background(), "user-1", ops); err != nil {
+		t.Fatalf("insert ops: %v", err)
+	}
+}
+
+func TestRunOnceSendsDueDigestWithNewActivityOnly(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	if err := store.UpdateUserProfile(ctx, "user-1", storage.UserProfile{Email: "ada@example.com", TimeZone: "UTC"}); err != nil {
+		t.Fatalf("update profile: %v", err)
+	}
+	if err := store.SetDigestFrequency(ctx, "user-1", storage.DigestDaily); err != nil {
+		t.Fatalf("set frequency: %v", err)
+	}
+	insertOps(t, store,
+		storage.Op{Scope: "registry", Resource: "registry", Actor: "a", Clock: 1, Payload: []byte(`{"type":"createList","listId":"list-1","payload":{"title":"Groceries","pos":[{"digit":512,"actor":"a"}]}}`)},
+		storage.Op{Scope: "list", Resource: "list-1", Actor: "a", Clock: 2, Payload: []byte(`{"type":"insert","itemId":"item-1","payload":{"data":{"text":"milk"},"pos":[{"digit":512,"actor":"a"}]}}`)},
+	)
+
+	sender := &recordingSender{}
+	runner := New(store, sender, nil, nil)
+	// Tuesday 22:13 UTC.
+	now := time.Unix(1_700_000_000, 0)
+	runner.now = func() time.Time { return now }
+
+	// The first run only records where the digest starts.
+	if sent, err := runner.RunOnce(ctx); err != nil || sent != 0 {
+		t.Fatalf("first run: sent=%d err=%v", sent, err)
+	}
+	insertOps(t, store,
+		storage.Op{Scope: "list", Resource: "list-1", Actor: "a", Clock: 3, Payload: []byte(`{"type":"insert","itemId":"item-2","payload":{"data":{"text":"eggs"},"pos":[{"digit":600,"actor":"a"}]}}`)},
+		storage.Op{Scope: "list", Resource: "list-1", Actor: "a", Clock: 4, Payload: []byte(`{"type":"update","itemId":"item-1","payload":{"data":{"done":true}}}`)},
+	)
+	now = now.Add(8 * time.Hour)
+	if sent, err := runner.RunOnce(ctx); err != nil || sent != 0 {
+		t.Fatalf("run before the send hour: sent=%d err=%v", sent, err)
+	}
+
+	now = now.Add(time.Hour)
+	if sent, err := runner.RunOnce(ctx); err != nil || sent != 1 {
+		t.Fatalf("due run: sent=%d err=%v", sent, err)
+	}
+	if len(sender.sent) != 1 {
+		t.Fatalf("expected one mail, got %+v", sender.sent)
+	}
+	mail := sender.sent[0]
+	if mail.to != "ada@example.com" || mail.subject != "Your daily list digest: 1 added, 1 completed" {
+		t.Fatalf("unexpected mail header: %+v", mail)
+	}
+	if mail.body != "Groceries\n\nAdded:\n- eggs\n\nCompleted:\n- milk\n\n-- \nYou get this email because you turned on list digests. To stop them, turn digests off in the settings of the app.\n" {
+		t.Fatalf("unexpected body: %q", mail.body)
+	}
+
+	// Nothing new happened, so the next period passes without mail.
+	now = now.Add(24 * time.Hour)
+	if sent, err := runner.RunOnce(ctx); err != nil || sent != 0 {
+		t.Fatalf("idle run: sent=%d err=%v", sent, err)
+	}
+}
+
+func TestBuildLeavesOutMutedLists(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	insertOps(t, store,
+		storage.Op{Scope: "registry", Resource: "registry", Actor: "a", Clock: 1, Payload: []byte(`{"type":"createList","listId":"list-1","payload":{"title":"Groceries","pos":[{"digit":512,"actor":"a"}]}}`)},
+		storage.Op{Scope: "registry", Resource: "registry", Actor: "a", Clock: 2, Payload: []byte(`{"type":"createList","listId":"list-2","payload":{"title":"Family chat","pos":[{"digit":600,"actor":"a"}]}}`)},
+		storage.Op{Scope: "list", Resource: "list-1", Actor: "a", Clock: 3, Payload: []byte(`{"type":"insert","itemId":"item-1","payload":{"data":{"text":"milk"},"pos":[{"digit":512,"actor":"a"}]}}`)},
+		storage.Op{Scope: "list", Resource: "list-2", Actor: "a", Clock: 4, Payload: []byte(`{"type":"insert","itemId":"item-2","payload":{"data":{"text":"call grandma"},"pos":[{"digit":512,"actor":"a"}]}}`)},
+	)
+	if err := store.SetListMuted(ctx, "user-1", "list-2", true); err != nil {
+		t.Fatalf("mute list: %v", err)
+	}
+	digest, _, err := New(store, &recordingSender{}, nil, nil).Build(ctx, "user-1", 0)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if len(digest.Lists) != 1 || digest.Lists[0].Title != "Groceries" {
+		t.Fatalf("expected only the unmuted list, got %+v", digest.Lists)
+	}
+}
+
+func TestDigestsGoOutInTheUsersTimeZone(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	for userID, timeZone := range map[string]string{"user-1": "Europe/Berlin", "user-2": "America/Los_Angeles"} {
+		if err := store.UpdateUserProfile(ctx, userID, storage.UserProfile{Email: userID + "@example.com", TimeZone: timeZone}); err != nil {
+			t.Fatalf("update profile: %v", err)
+		}
+		if err := store.SetDigestFrequency(ctx, userID, storage.DigestDaily); err != nil {
+			t.Fatalf("set frequency: %v", err)
+		}
+		if err := store.MarkDigestSent(ctx, userID, time.Date(2024, 3, 4, 16, 0, 0, 0, time.UTC).Unix(), 0); err != nil {
+			t.Fatalf("mark sent: %v", err)
+		}
+		if _, err := store.InsertOps(ctx, userID, []storage.Op{
+			{Scope: "registry", Resource: "registry", Actor: "a-" + userID, Clock: 1, Payload: []byte(`{"type":"createList","listId":"list-1","payload":{"title":"Groceries","pos":[{"digit":512,"actor":"a"}]}}`)},
+			{Scope: "list", Resource: "list-1", Actor: "a-" + userID, Clock: 2, Payload: []byte(`{"type":"insert","itemId":"item-1","payload":{"data":{"text":"milk"},"pos":[{"digit":512,"actor":"a"}]}}`)},
+		}); err != nil {
+			t.Fatalf("insert ops: %v", err)
+		}
+	}
+	sender := &recordingSender{}
+	runner := New(store, sender, nil, nil)
+	// 07:30 in Berlin is still the previous evening in Los Angeles.
+	runner.now = func() time.Time { return time.Date(2024, 3, 5, 6, 30, 0, 0, time.UTC) }
+	if sent, err := runner.RunOnce(ctx); err != nil || sent != 1 || sender.sent[0].to != "user-1@example.com" {
+		t.Fatalf("expected only the Berlin digest, got sent=%d err=%v %+v", sent, err, sender.sent)
+	}
+	runner.now = func() time.Time { return time.Date(2024, 3, 5, 15, 0, 0, 0, time.UTC) }
+	if sent, err := runner.RunOnce(ctx); err != nil || sent != 1 || sender.sent[1].to != "user-2@example.com" {
+		t.Fatalf("expected the Los Angeles digest at 07:00 local, got sent=%d err=%v %+v", sent, err, sender.sent)
+	}
+}
+
+func TestLastSendTimeFollowsLocalCalendar(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	cases := []struct {
+		frequency string
+		now       time.Time
+		want      time.Time
+	}{
+		{storage.DigestDaily, time.Date(2024, 3, 31, 8, 0, 0, 0, berlin), time.Date(2024, 3, 31, 7, 0, 0, 0, berlin)},
+		{storage.DigestDaily, time.Date(2024, 3, 31, 6, 59, 0, 0, berlin), time.Date(2024, 3, 30, 7, 0, 0, 0, berlin)},
+		// Sunday after the switch to summer time: the Monday before, at 07:00
+		// winter time.
+		{storage.DigestWeekly, time.Date(2024, 3, 31, 8, 0, 0, 0, berlin), time.Date(2024, 3, 25, 7, 0, 0, 0, berlin)},
+		{storage.DigestWeekly, time.Date(2024, 4, 1, 7, 0, 0, 0, berlin), time.Date(2024, 4, 1, 7, 0, 0, 0, berlin)},
+	}
+	for _, c := range cases {
+		if got := LastSendTime(c.frequency, c.now, berlin); !got.Equal(c.want) {
+			t.Errorf("LastSendTime(%s, %s) = %s, want %s", c.frequency, c.now, got, c.want)
+		}
+	}
+}
+
+func TestComposeWritesTheUsersLanguage(t *testing.T) {
+	digest := Digest{Frequency: storage.DigestWeekly, Lists: []List{{Added: []string{"Milch"}}}}
+	msg, err := Compose(mail.Builtin(), digest, i18n.Builtin().Printer("de-DE"))
+	if err != nil {
+		t.Fatalf("compose: %v", err)
+	}
+	if msg.Subject != "Deine wöchentliche Listenübersicht: 1 hinzugefügt, 0 erledigt" {
+		t.Fatalf("unexpected subject %q", msg.Subject)
+	}
+	if !strings.HasPrefix(msg.Text, "Unbenannte Liste\n\nHinzugefügt:\n- Milch\n") {
+		t.Fatalf("unexpected body %q", msg.Text)
+	}
+	if !strings.Contains(msg.HTML, `<html lang="de">`) || !strings.Contains(msg.HTML, "<li>Milch</li>") {
+		t.Fatalf("unexpected HTML %q", msg.HTML)
+	}
+}
@@ -0,0 +1,190 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"a4-tasklists/server/internal/blobstore"
+)
+
+// Destinations an export can be pushed to.
+const (
+	// DestinationWebDAV PUTs the export into a WebDAV collection (Nextcloud,
+	// ownCloud, a NAS, ...). The target is the collection URL, which must
+	// exist.
+	DestinationWebDAV = "webdav"
+	// DestinationS3 stores the export in an S3-compatible bucket. The target
+	// is https://endpoint/bucket, optionally followed by a key prefix.
+	DestinationS3 = "s3"
+	// DestinationGit commits the export to a branch of a Git repository,
+	// pushed over https. The target is the repository URL.
+	DestinationGit = "git"
+)
+
+// Config is the sealed part of a destination: its credentials and the
+// settings that go with them. It is never returned to clients.
+type Config struct {
+	// Username and Password authenticate to WebDAV and Git. For Git hosts
+	// the password is usually an access token.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// AccessKeyID and SecretAccessKey authenticate to S3; Region defaults to
+	// us-east-1.
+	AccessKeyID     string `json:"accessKeyId,omitempty"`
+	SecretAccessKey string `json:"secretAccessKey,omitempty"`
+	Region          string `json:"region,omitempty"`
+	// Branch is the Git branch the export is committed to; it defaults to
+	// main.
+	Branch string `json:"branch,omitempty"`
+}
+
+func (c Config) validate(destination string) error {
+	switch destination {
+	case DestinationS3:
+		if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+			return errors.New("s3 needs an access key id and secret access key")
+		}
+	case DestinationGit:
+		if c.Password == "" {
+			return errors.New("git needs a password or access token")
+		}
+		if c.Branch != "" && (strings.HasPrefix(c.Branch, "-") || strings.ContainsAny(c.Branch, " ~^:?*[\\") || strings.Contains(c.Branch, "..")) {
+			return fmt.Errorf("invalid git branch %q", c.Branch)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) putWebDAV(ctx context.Context, target *url.URL, config Config, name string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target.JoinPath(name).String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType(name))
+	if config.Username != "" || config.Password != "" {
+		req.SetBasicAuth(config.Username, config.Password)
+	}
+	resp, err := r.dial.client().Do(req)
+	if err != nil {
+		return fmt.Errorf("webdav put: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webdav put: %s", resp.Status)
+	}
+	return nil
+}
+
+func (r *Runner) putS3(ctx context.Context, target *url.URL, config Config, name string, data []byte) error {
+	bucket, prefix, _ := strings.Cut(strings.Trim(target.Path, "/"), "/")
+	store, err := blobstore.NewS3(blobstore.S3Config{
+		Endpoint:        target.Scheme + "://" + target.Host,
+		Region:          config.Region,
+		Bucket:          bucket,
+		AccessKeyID:     config.AccessKeyID,
+		SecretAccessKey: config.SecretAccessKey,
+		HTTPClient:      r.dial.client(),
+	})
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, path.Join(prefix, name), data)
+}
+
+func contentType(name string) string {
+	if path.Ext(name) == ".md" {
+		return "text/markdown; charset=utf-8"
+	}
+	return "application/json"
+}
+
+// dialGuard keeps exports from reaching the server's own network unless
+// private targets are allowed: user-supplied URLs must not become a way to
+// probe internal services.
+type dialGuard struct {
+	allowPrivate bool
+}
+
+// client returns an HTTP client that refuses to connect to non-public
+// addresses. The check runs on the resolved address of every connection,
+// redirects included, so DNS cannot route around it.
+func (g *dialGuard) client() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(_ string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil {
+				return err
+			}
+			return g.checkAddr(addr)
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Transport: transport, Timeout: 5 * time.Minute}
+}
+
+// checkHost rejects a host that is a non-public IP literal or localhost.
+func (g *dialGuard) checkHost(host string) error {
+	if g.allowPrivate {
+		return nil
+	}
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return fmt.Errorf("target %s is not a public address", host)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return g.checkAddr(addr)
+	}
+	return nil
+}
+
+// checkResolved resolves host and rejects it if any of its addresses is not
+// public. It is for clients the guard cannot hook into, such as git.
+func (g *dialGuard) checkResolved(ctx context.Context, host string) error {
+	if err := g.checkHost(host); err != nil || g.allowPrivate {
+		return err
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if err := g.checkAddr(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *dialGuard) checkAddr(addr netip.Addr) error {
+	if g.allowPrivate {
+		return nil
+	}
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() || sharedAddressSpace.Contains(addr) {
+		return fmt.Errorf("target address %s is not public", addr)
+	}
+	return nil
+}
+
+// sharedAddressSpace is carrier-grade NAT space (RFC 6598), which
+// netip.Addr.IsPrivate does not cover.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
@@ -0,0 +1,459 @@
+// Package export pushes users' lists to storage they control on a cadence,
+// as a personal off-server backup.
+//
+// A schedule names a format (JSON or Markdown), a cadence, and a destination:
+// a WebDAV collection, an S3 bucket, or a Git repository. The destination
+// configuration, including its credentials, is sealed with a server key
+// before it is stored and only opened to run an export. Each run renders the
+// user's current lists into one file and replaces the previous copy at the
+// destination. Git keeps the history as commits; in Markdown it mirrors each
+// list as a file of its own, so the history diffs per list.
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"a4-tasklists/server/internal/i18n"
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/storage"
+)
+
+// Formats an export can be rendered in.
+const (
+	FormatJSON     = "json"
+	FormatMarkdown = "markdown"
+)
+
+// Cadences a schedule can run on.
+const (
+	CadenceHourly = "hourly"
+	CadenceDaily  = "daily"
+	CadenceWeekly = "weekly"
+	// CadenceCompaction runs after each compaction of the user's op log, so
+	// every snapshot generation is mirrored.
+	CadenceCompaction = "compaction"
+)
+
+// compactionTimeout bounds the exports that follow one compaction.
+const compactionTimeout = 10 * time.Minute
+
+var (
+	// ErrRunning is returned when an export of the schedule is in progress.
+	ErrRunning = errors.New("this export is already running")
+	// ErrInvalid wraps problems with a schedule a user asked for.
+	ErrInvalid = errors.New("invalid export schedule")
+)
+
+// Period returns how often a schedule of the given cadence runs, or 0 for an
+// unknown cadence and CadenceCompaction.
+func Period(cadence string) time.Duration {
+	switch cadence {
+	case CadenceHourly:
+		return time.Hour
+	case CadenceDaily:
+		return 24 * time.Hour
+	case CadenceWeekly:
+		return 7 * 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+// FileName is the name the export is stored under at the destination.
+func FileName(format string) string {
+	if format == FormatMarkdown {
+		return "lists.md"
+	}
+	return "lists.json"
+}
+
+// Render renders state in format. The output only depends on the lists, so
+// an unchanged dataset renders to the same bytes.
+func Render(format string, state materialize.State, p i18n.Printer) ([]byte, error) {
+	switch format {
+	case FormatJSON:
+		if state.Lists == nil {
+			state.Lists = []materialize.List{}
+		}
+		data, err := json.MarshalIndent(state, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	case FormatMarkdown:
+		return renderMarkdown(state, p), nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// MirrorFiles renders each list as a Markdown file of its own, named after
+// its title. Names are made unique in list order.
+func MirrorFiles(state materialize.State, p i18n.Printer) map[string][]byte {
+	files := make(map[string][]byte, len(state.Lists))
+	for _, list := range state.Lists {
+		slug := fileSlug(list.Title)
+		name := slug + ".md"
+		for n := 2; files[name] != nil; n++ {
+			name = fmt.Sprintf("%s-%d.md", slug, n)
+		}
+		var out bytes.Buffer
+		writeMarkdownList(&out, list, p)
+		files[name] = out.Bytes()
+	}
+	return files
+}
+
+// fileSlug turns a title into a portable file name: lower case letters and
+// digits joined by dashes.
+func fileSlug(title string) string {
+	var slug []rune
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && len(slug) > 0 {
+				slug = append(slug, '-')
+			}
+			slug = append(slug, r)
+			dash = false
+		} else {
+			dash = true
+		}
+		if len(slug) >= 60 {
+			break
+		}
+	}
+	if len(slug) == 0 {
+		return "untitled"
+	}
+	return string(slug)
+}
+
+// renderMarkdown writes each list as a section of task list items. Notes are
+// indented under their item and tags follow the text as #tag.
+func renderMarkdown(state materialize.State, p i18n.Printer) []byte {
+	var out bytes.Buffer
+	for i, list := range state.Lists {
+		if i > 0 {
+			out.WriteString("\n")
+		}
+		writeMarkdownList(&out, list, p)
+	}
+	return out.Bytes()
+}
+
+func writeMarkdownList(out *bytes.Buffer, list materialize.List, p i18n.Printer) {
+	title := list.Title
+	if title == "" {
+		title = p.Text("Untitled list")
+	}
+	fmt.Fprintf(out, "# %s\n\n", title)
+	for _, item := range list.Items {
+		mark := " "
+		if item.Done {
+			mark = "x"
+		}
+		fmt.Fprintf(out, "- [%s] %s", mark, strings.ReplaceAll(item.Text, "\n", " "))
+		for _, tag := range item.Tags {
+			fmt.Fprintf(out, " #%s", tag)
+		}
+		out.WriteString("\n")
+		if note := strings.TrimSpace(item.Note); note != "" {
+			for line := range strings.SplitSeq(note, "\n") {
+				fmt.Fprintf(out, "  %s\n", strings.TrimRight(line, " \t\r"))
+			}
+		}
+	}
+}
+
+// Options configure a Runner.
+type Options struct {
+	// AllowPrivateTargets lets destinations resolve to loopback, private and
+	// link-local addresses and use plain http, for destinations on the
+	// server's own network. Off, users cannot make the server reach into it.
+	AllowPrivateTargets bool
+	// Messages translates Markdown exports into the user's language; nil
+	// writes English.
+	Messages *i18n.Catalog
+}
+
+// Runner runs due exports.
+type Runner struct {
+	store   storage.Store
+	sealer  *Sealer
+	options Options
+	dial    *dialGuard
+	now     func() time.Time
+
+	mu      sync.Mutex
+	running map[string]bool
+	wg      sync.WaitGroup
+}
+
+func New(store storage.Store, sealer *Sealer, options Options) *Runner {
+	return &Runner{
+		store:   store,
+		sealer:  sealer,
+		options: options,
+		dial:    &dialGuard{allowPrivate: options.AllowPrivateTargets},
+		now:     time.Now,
+		running: make(map[string]bool),
+	}
+}
+
+// Prepare validates a schedule the user asked for and seals its destination
+// configuration into it. schedule.ID must be set; the sealed configuration
+// is bound to it. Problems with the request wrap ErrInvalid.
+func (r *Runner) Prepare(schedule storage.ExportSchedule, config Config) (storage.ExportSchedule, error) {
+	if schedule.Format != FormatJSON && schedule.Format != FormatMarkdown {
+		return storage.ExportSchedule{}, fmt.Errorf("%w: format must be json or markdown", ErrInvalid)
+	}
+	if Period(schedule.Cadence) == 0 && schedule.Cadence != CadenceCompaction {
+		return storage.ExportSchedule{}, fmt.Errorf("%w: cadence must be hourly, daily, weekly or compaction", ErrInvalid)
+	}
+	target, err := r.parseTarget(schedule.Destination, schedule.Target)
+	if err != nil {
+		return storage.ExportSchedule{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if err := config.validate(schedule.Destination); err != nil {
+		return storage.ExportSchedule{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	// The stored target is shown back to the user; credentials in it would
+	// leak past the sealed configuration.
+	if target.User != nil {
+		return storage.ExportSchedule{}, fmt.Errorf("%w: put credentials in the credential fields, not the target URL", ErrInvalid)
+	}
+	plaintext, err := json.Marshal(config)
+	if err != nil {
+		return storage.ExportSchedule{}, err
+	}
+	schedule.SealedConfig, err = r.sealer.Seal(schedule.ID, plaintext)
+	if err != nil {
+		return storage.ExportSchedule{}, err
+	}
+	schedule.Target = target.String()
+	return schedule, nil
+}
+
+// Run calls RunOnce every interval until ctx is done.
+func (r *Runner) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			exported, err := r.RunOnce(ctx)
+			if err != nil {
+				log.Printf("export run error: %v", err)
+			}
+			if exported > 0 {
+				log.Printf("exports pushed count=%d", exported)
+			}
+		}
+	}
+}
+
+// RunOnce runs every export that is due and returns how many succeeded. A
+// new schedule is due at once; after that, compaction schedules only run
+// through Compacted. A failing export is recorded on its schedule and retried
+// at its next period; it does not stop the others, and the joined errors are
+// returned.
+func (r *Runner) RunOnce(ctx context.Context) (int, error) {
+	schedules, err := r.store.ListAllExportSchedules(ctx)
+	if err != nil {
+		return 0, err
+	}
+	now := r.now()
+	exported := 0
+	var errs []error
+	for _, schedule := range schedules {
+		period := Period(schedule.Cadence)
+		if schedule.Cadence == CadenceCompaction && schedule.LastRunAt != 0 {
+			continue
+		}
+		if period == 0 && schedule.Cadence != CadenceCompaction {
+			errs = append(errs, fmt.Errorf("user %s export %s: unknown cadence %q", schedule.UserID, schedule.ID, schedule.Cadence))
+			continue
+		}
+		if schedule.LastRunAt != 0 && now.Before(time.Unix(schedule.LastRunAt, 0).Add(period)) {
+			continue
+		}
+		if _, err := r.run(ctx, schedule); err != nil {
+			if !errors.Is(err, ErrRunning) {
+				errs = append(errs, fmt.Errorf("user %s export %s: %w", schedule.UserID, schedule.ID, err))
+			}
+			continue
+		}
+		exported++
+	}
+	return exported, errors.Join(errs...)
+}
+
+// Compacted runs the user's compaction schedules in the background. It is
+// meant for compaction.Compactor.OnCompacted.
+func (r *Runner) Compacted(userID string) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), compactionTimeout)
+		defer cancel()
+		schedules, err := r.store.ListExportSchedules(ctx, userID)
+		if err != nil {
+			log.Printf("export after compaction error user=%s: %v", userID, err)
+			return
+		}
+		for _, schedule := range schedules {
+			if schedule.Cadence != CadenceCompaction {
+				continue
+			}
+			if _, err := r.run(ctx, schedule); err != nil && !errors.Is(err, ErrRunning) {
+				log.Printf("export after compaction error user=%s export=%s: %v", userID, schedule.ID, err)
+			}
+		}
+	}()
+}
+
+// Wait blocks until the exports started by Compacted have finished.
+func (r *Runner) Wait() {
+	r.wg.Wait()
+}
+
+// RunNow runs one of the user's exports right away, whether or not it is
+// due, and returns the schedule with the outcome recorded.
+func (r *Runner) RunNow(ctx context.Context, userID string, scheduleID string) (storage.ExportSchedule, error) {
+	schedules, err := r.store.ListExportSchedules(ctx, userID)
+	if err != nil {
+		return storage.ExportSchedule{}, err
+	}
+	for _, schedule := range schedules {
+		if schedule.ID == scheduleID {
+			return r.run(ctx, schedule)
+		}
+	}
+	return storage.ExportSchedule{}, storage.ErrExportScheduleNotFound
+}
+
+// run exports once and records the outcome. The returned error is the
+// export's; the schedule carries it as LastError too.
+func (r *Runner) run(ctx context.Context, schedule storage.ExportSchedule) (storage.ExportSchedule, error) {
+	key := schedule.UserID + "/" + schedule.ID
+	r.mu.Lock()
+	if r.running[key] {
+		r.mu.Unlock()
+		return schedule, ErrRunning
+	}
+	r.running[key] = true
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.running, key)
+		r.mu.Unlock()
+	}()
+
+	exportErr := r.export(ctx, schedule)
+	schedule.LastRunAt, schedule.LastError = r.now().Unix(), ""
+	if exportErr != nil {
+		schedule.LastError = exportErr.Error()
+	}
+	if err := r.store.MarkExportRun(ctx, schedule.UserID, schedule.ID, schedule.LastRunAt, schedule.LastError); err != nil {
+		return schedule, errors.Join(exportErr, err)
+	}
+	return schedule, exportErr
+}
+
+func (r *Runner) export(ctx context.Context, schedule storage.ExportSchedule) error {
+	plaintext, err := r.sealer.Open(schedule.ID, schedule.SealedConfig)
+	if err != nil {
+		return err
+	}
+	var config Config
+	if err := json.Unmarshal(plaintext, &config); err != nil {
+		return fmt.Errorf("decode destination config: %w", err)
+	}
+	target, err := r.parseTarget(schedule.Destination, schedule.Target)
+	if err != nil {
+		return err
+	}
+	state, err := r.state(ctx, schedule.UserID)
+	if err != nil {
+		return err
+	}
+	profile, err := r.store.GetUserProfile(ctx, schedule.UserID)
+	if err != nil {
+		return err
+	}
+	printer := r.options.Messages.Printer(profile.Locale)
+	if schedule.Destination == DestinationGit && schedule.Format == FormatMarkdown {
+		return r.pushGit(ctx, target, config, mirrorDir, MirrorFiles(state, printer))
+	}
+	data, err := Render(schedule.Format, state, printer)
+	if err != nil {
+		return err
+	}
+	name := FileName(schedule.Format)
+	switch schedule.Destination {
+	case DestinationWebDAV:
+		return r.putWebDAV(ctx, target, config, name, data)
+	case DestinationS3:
+		return r.putS3(ctx, target, config, name, data)
+	case DestinationGit:
+		return r.pushGit(ctx, target, config, "", map[string][]byte{name: data})
+	default:
+		return fmt.Errorf("unknown export destination %q", schedule.Destination)
+	}
+}
+
+// state materializes the user's current lists.
+func (r *Runner) state(ctx context.Context, userID string) (materialize.State, error) {
+	snapshot, err := r.store.GetSnapshot(ctx, userID)
+	if err != nil {
+		return materialize.State{}, err
+	}
+	ops, _, err := r.store.GetOpsSince(ctx, userID, 0)
+	if err != nil {
+		return materialize.State{}, err
+	}
+	state, err := materialize.Build(snapshot.Blob, ops)
+	if err != nil {
+		return materialize.State{}, fmt.Errorf("materialize state: %w", err)
+	}
+	return state, nil
+}
+
+// parseTarget checks a destination URL. Plain http is only allowed with
+// AllowPrivateTargets; the addresses a host resolves to are checked when
+// it is contacted.
+func (r *Runner) parseTarget(destination string, target string) (*url.URL, error) {
+	switch destination {
+	case DestinationWebDAV, DestinationS3, DestinationGit:
+	default:
+		return nil, errors.New("destination must be webdav, s3 or git")
+	}
+	parsed, err := url.Parse(strings.TrimSpace(target))
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("target must be an absolute URL, got %q", target)
+	}
+	if parsed.Scheme != "https" && (parsed.Scheme != "http" || !r.options.AllowPrivateTargets) {
+		return nil, errors.New("target must be an https URL")
+	}
+	if err := r.dial.checkHost(parsed.Hostname()); err != nil {
+		return nil, err
+	}
+	if destination == DestinationS3 && strings.Trim(parsed.Path, "/") == "" {
+		return nil, errors.New("s3 target must name a bucket: https://endpoint/bucket[/prefix]")
+	}
+	parsed.RawQuery, parsed.Fragment = "", ""
+	return parsed, nil
+}
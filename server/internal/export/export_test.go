@@ -0,0 +1,269 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"a4-tasklists/server/internal/i18n"
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/storage"
+)
+
+const testKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+
+func newTestRunner(t *testing.T, options Options) (*Runner, *storage.MemoryStore) {
+	t.Helper()
+	sealer, err := NewSealer(testKey)
+	if err != nil {
+		t.Fatalf("sealer: %v", err)
+	}
+	store := storage.NewMemoryStore()
+	if _, err := store.InsertOps(context.Background(), "user-1", []storage.Op{
+		{Scope: "registry", Resource: "registry", Actor: "a", Clock: 1, Payload: []byte(`{"type":"createList","listId":"list-1","payload":{"title":"Groceries","pos":[{"digit":512,"actor":"a"}]}}`)},
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 2, Payload: []byte(`{"type":"insert","itemId":"item-1","payload":{"data":{"text":"milk"},"pos":[{"digit":512,"actor":"a"}]}}`)},
+	}); err != nil {
+		t.Fatalf("insert ops: %v", err)
+	}
+	return New(store, sealer, options), store
+}
+
+func createSchedule(t *testing.T, runner *Runner, store storage.Store, schedule storage.ExportSchedule, config Config) {
+	t.Helper()
+	prepared, err := runner.Prepare(schedule, config)
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	if err := store.CreateExportSchedule(context.Background(), "user-1", prepared); err != nil {
+		t.Fatalf("create schedule: %v", err)
+	}
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	state := materialize.State{Lists: []materialize.List{
+		{Title: "Groceries", Items: []materialize.Item{
+			{Text: "Milk", Tags: []string{"dairy"}},
+			{Text: "Eggs", Done: true, Note: "free range\nsix"},
+		}},
+		{Items: []materialize.Item{{Text: "Call plumber"}}},
+	}}
+	got, err := Render(FormatMarkdown, state, i18n.Printer{})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	want := "# Groceries\n\n- [ ] Milk #dairy\n- [x] Eggs\n  free range\n  six\n\n# Untitled list\n\n- [ ] Call plumber\n"
+	if string(got) != want {
+		t.Fatalf("unexpected markdown:\n%s", got)
+	}
+	german, err := Render(FormatMarkdown, state, i18n.Builtin().Printer("de-AT"))
+	if err != nil || !strings.Contains(string(german), "# Unbenannte Liste\n") {
+		t.Fatalf("expected the untitled list in German:\n%s", german)
+	}
+	if got, err := Render(FormatJSON, materialize.State{}, i18n.Printer{}); err != nil || string(got) != "{\n  \"lists\": []\n}\n" {
+		t.Fatalf("unexpected empty json: %q %v", got, err)
+	}
+}
+
+func TestMirrorFilesAreNamedAfterLists(t *testing.T) {
+	files := MirrorFiles(materialize.State{Lists: []materialize.List{
+		{Title: "Groceries / Weekend!", Items: []materialize.Item{{Text: "Milk"}}},
+		{Title: "groceries weekend"},
+		{Title: "★"},
+	}}, i18n.Printer{})
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"groceries-weekend-2.md", "groceries-weekend.md", "untitled.md"}) {
+		t.Fatalf("unexpected file names %v", names)
+	}
+	if got := string(files["groceries-weekend.md"]); got != "# Groceries / Weekend!\n\n- [ ] Milk\n" {
+		t.Fatalf("unexpected file %q", got)
+	}
+}
+
+func TestCompactionSchedulesFollowCompactions(t *testing.T) {
+	var mu sync.Mutex
+	puts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		puts++
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	runner, store := newTestRunner(t, Options{AllowPrivateTargets: true})
+	createSchedule(t, runner, store, storage.ExportSchedule{ID: "export-1", Format: FormatJSON, Cadence: CadenceCompaction, Destination: DestinationWebDAV, Target: server.URL}, Config{})
+
+	// A new schedule runs once right away, then only after compactions.
+	for range 2 {
+		if _, err := runner.RunOnce(context.Background()); err != nil {
+			t.Fatalf("run once: %v", err)
+		}
+	}
+	if puts != 1 {
+		t.Fatalf("expected one export before any compaction, got %d", puts)
+	}
+	runner.Compacted("user-1")
+	runner.Compacted("user-2")
+	runner.Wait()
+	if puts != 2 {
+		t.Fatalf("expected the compaction to export again, got %d", puts)
+	}
+}
+
+func TestSealerBindsSchedule(t *testing.T) {
+	sealer, err := NewSealer(testKey)
+	if err != nil {
+		t.Fatalf("sealer: %v", err)
+	}
+	sealed, err := sealer.Seal("export-1", []byte("secret"))
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if strings.Contains(string(sealed), "secret") {
+		t.Fatal("expected the config to be encrypted")
+	}
+	if plaintext, err := sealer.Open("export-1", sealed); err != nil || string(plaintext) != "secret" {
+		t.Fatalf("open: %q %v", plaintext, err)
+	}
+	if _, err := sealer.Open("export-2", sealed); err == nil {
+		t.Fatal("expected a config sealed for another schedule not to open")
+	}
+	if _, err := NewSealer("c2hvcnQ="); err == nil {
+		t.Fatal("expected a short key to be rejected")
+	}
+}
+
+func TestPrepareValidates(t *testing.T) {
+	runner, _ := newTestRunner(t, Options{})
+	valid := storage.ExportSchedule{ID: "export-1", Format: FormatMarkdown, Cadence: CadenceDaily, Destination: DestinationWebDAV, Target: "https://dav.example/lists?x=1"}
+	prepared, err := runner.Prepare(valid, Config{Username: "ada", Password: "pw"})
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	if prepared.Target != "https://dav.example/lists" || len(prepared.SealedConfig) == 0 || strings.Contains(string(prepared.SealedConfig), "pw") {
+		t.Fatalf("unexpected prepared schedule: %+v", prepared)
+	}
+	for name, tc := range map[string]struct {
+		change func(*storage.ExportSchedule)
+		config Config
+	}{
+		"format":            {func(s *storage.ExportSchedule) { s.Format = "csv" }, Config{}},
+		"cadence":           {func(s *storage.ExportSchedule) { s.Cadence = "monthly" }, Config{}},
+		"plain http":        {func(s *storage.ExportSchedule) { s.Target = "http://dav.example/lists" }, Config{}},
+		"loopback":          {func(s *storage.ExportSchedule) { s.Target = "https://127.0.0.1/lists" }, Config{}},
+		"private":           {func(s *storage.ExportSchedule) { s.Target = "https://[fd00::1]/lists" }, Config{}},
+		"localhost":         {func(s *storage.ExportSchedule) { s.Target = "https://localhost:8443/" }, Config{}},
+		"credentials":       {func(s *storage.ExportSchedule) { s.Target = "https://ada:pw@dav.example/" }, Config{}},
+		"s3 without bucket": {func(s *storage.ExportSchedule) { s.Destination, s.Target = DestinationS3, "https://s3.example" }, Config{AccessKeyID: "a", SecretAccessKey: "b"}},
+		"s3 without keys":   {func(s *storage.ExportSchedule) { s.Destination, s.Target = DestinationS3, "https://s3.example/bucket" }, Config{}},
+		"git without token": {func(s *storage.ExportSchedule) { s.Destination = DestinationGit }, Config{}},
+		"git branch":        {func(s *storage.ExportSchedule) { s.Destination = DestinationGit }, Config{Password: "t", Branch: "--upload-pack=x"}},
+	} {
+		schedule := valid
+		tc.change(&schedule)
+		if _, err := runner.Prepare(schedule, tc.config); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: expected ErrInvalid, got %v", name, err)
+		}
+	}
+}
+
+func TestRunOncePushesDueExports(t *testing.T) {
+	var mu sync.Mutex
+	uploads := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/dav/lists.md" {
+			if user, password, ok := r.BasicAuth(); !ok || user != "ada" || password != "pw" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		if r.URL.Path == "/bucket/backups/lists.json" && !strings.Contains(r.Header.Get("Authorization"), "Credential=key-id/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		uploads[r.Method+" "+r.URL.Path] = string(body)
+		if strings.HasPrefix(r.URL.Path, "/dav/") {
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+
+	runner, store := newTestRunner(t, Options{AllowPrivateTargets: true})
+	now := time.Unix(1_700_000_000, 0)
+	runner.now = func() time.Time { return now }
+	createSchedule(t, runner, store, storage.ExportSchedule{ID: "export-1", Format: FormatMarkdown, Cadence: CadenceDaily, Destination: DestinationWebDAV, Target: server.URL + "/dav"}, Config{Username: "ada", Password: "pw"})
+	createSchedule(t, runner, store, storage.ExportSchedule{ID: "export-2", Format: FormatJSON, Cadence: CadenceHourly, Destination: DestinationS3, Target: server.URL + "/bucket/backups"}, Config{AccessKeyID: "key-id", SecretAccessKey: "secret"})
+
+	if exported, err := runner.RunOnce(context.Background()); err != nil || exported != 2 {
+		t.Fatalf("first run: exported=%d err=%v", exported, err)
+	}
+	if got := uploads["PUT /dav/lists.md"]; got != "# Groceries\n\n- [ ] milk\n" {
+		t.Fatalf("unexpected webdav upload %q", got)
+	}
+	if got := uploads["PUT /bucket/backups/lists.json"]; !strings.Contains(got, `"text": "milk"`) {
+		t.Fatalf("unexpected s3 upload %q", got)
+	}
+
+	// Two hours later only the hourly export is due.
+	now = now.Add(2 * time.Hour)
+	clear(uploads)
+	if exported, err := runner.RunOnce(context.Background()); err != nil || exported != 1 {
+		t.Fatalf("second run: exported=%d err=%v", exported, err)
+	}
+	if _, ok := uploads["PUT /bucket/backups/lists.json"]; !ok || len(uploads) != 1 {
+		t.Fatalf("expected only the hourly export, got %v", uploads)
+	}
+}
+
+func TestRunNowRecordsFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInsufficientStorage)
+	}))
+	defer server.Close()
+	runner, store := newTestRunner(t, Options{AllowPrivateTargets: true})
+	createSchedule(t, runner, store, storage.ExportSchedule{ID: "export-1", Format: FormatJSON, Cadence: CadenceDaily, Destination: DestinationWebDAV, Target: server.URL}, Config{})
+
+	schedule, err := runner.RunNow(context.Background(), "user-1", "export-1")
+	if err == nil || !strings.Contains(err.Error(), "507") {
+		t.Fatalf("expected the upload to fail, got %v", err)
+	}
+	if schedule.LastRunAt == 0 || !strings.Contains(schedule.LastError, "507") {
+		t.Fatalf("expected the failure on the schedule, got %+v", schedule)
+	}
+	stored, _ := store.ListExportSchedules(context.Background(), "user-1")
+	if len(stored) != 1 || stored[0].LastError != schedule.LastError {
+		t.Fatalf("expected the failure to be stored, got %+v", stored)
+	}
+	if _, err := runner.RunNow(context.Background(), "user-2", "export-1"); !errors.Is(err, storage.ErrExportScheduleNotFound) {
+		t.Fatalf("expected another user's schedule to be unknown, got %v", err)
+	}
+}
+
+func TestDialGuardRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	guard := &dialGuard{}
+	resp, err := guard.client().Get(server.URL)
+	if err == nil {
+		_ = resp.Body.Close()
+		t.Fatal("expected a loopback connection to be refused")
+	}
+	if err := guard.checkResolved(context.Background(), "localhost"); err == nil {
+		t.Fatal("expected localhost to be refused")
+	}
+}
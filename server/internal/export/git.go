@@ -0,0 +1,151 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gitTimeout bounds one push, clone to push.
+const gitTimeout = 5 * time.Minute
+
+// mirrorDir holds the per-list files of a Markdown mirror in the repository,
+// away from anything else the user keeps there.
+const mirrorDir = "lists"
+
+// pushGit commits files to the branch and pushes them. With dir set, files
+// are put in dir and replace everything in it, so lists that are gone are
+// removed. Only the tip of the branch is fetched, so a long history stays on
+// the remote; an unchanged export makes no commit.
+//
+// git runs with an empty configuration, no prompts, and no redirects. It
+// does its own DNS lookups, so the host is checked beforehand; a name that
+// changes its address in between is not caught.
+func (r *Runner) pushGit(ctx context.Context, target *url.URL, config Config, dir string, files map[string][]byte) error {
+	ctx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+	if err := r.dial.checkResolved(ctx, target.Hostname()); err != nil {
+		return err
+	}
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		return fmt.Errorf("git exports need the git command: %w", err)
+	}
+	work, err := os.MkdirTemp("", "export-git-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(work) }()
+
+	branch := config.Branch
+	if branch == "" {
+		branch = "main"
+	}
+	ref := "refs/heads/" + branch
+	remote := target.String()
+	g := gitCommand{path: gitPath, dir: work, env: r.gitEnv(work, config)}
+	if _, err := g.run(ctx, "init", "-q"); err != nil {
+		return err
+	}
+	heads, err := g.run(ctx, "ls-remote", "--heads", remote, ref)
+	if err != nil {
+		return err
+	}
+	exists := strings.TrimSpace(heads) != ""
+	if exists {
+		if _, err := g.run(ctx, "fetch", "-q", "--depth", "1", remote, ref); err != nil {
+			return err
+		}
+		if _, err := g.run(ctx, "checkout", "-q", "FETCH_HEAD"); err != nil {
+			return err
+		}
+	}
+	if dir != "" {
+		if _, err := g.run(ctx, "rm", "-r", "-q", "--ignore-unmatch", "--", dir); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Join(work, dir), 0o755); err != nil {
+			return err
+		}
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(work, dir, name), data, 0o644); err != nil {
+			return err
+		}
+	}
+	if _, err := g.run(ctx, "add", "-A", "--", "."); err != nil {
+		return err
+	}
+	if exists {
+		if _, err := g.run(ctx, "diff", "--cached", "--quiet"); err == nil {
+			return nil
+		}
+	}
+	if _, err := g.run(ctx, "commit", "-q", "-m", "Export lists"); err != nil {
+		return err
+	}
+	_, err = g.run(ctx, "push", "-q", remote, "HEAD:"+ref)
+	return err
+}
+
+// gitEnv isolates git from the server's own configuration and passes the
+// credentials as a header, so they appear in neither the URL nor the
+// process arguments.
+func (r *Runner) gitEnv(dir string, config Config) []string {
+	protocols := "https"
+	if r.options.AllowPrivateTargets {
+		protocols = "https:http"
+	}
+	username := config.Username
+	if username == "" {
+		username = "export"
+	}
+	credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + config.Password))
+	return []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + dir,
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_CONFIG_GLOBAL=" + os.DevNull,
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_ALLOW_PROTOCOL=" + protocols,
+		"GIT_AUTHOR_NAME=Lists export",
+		"GIT_AUTHOR_EMAIL=export@localhost",
+		"GIT_COMMITTER_NAME=Lists export",
+		"GIT_COMMITTER_EMAIL=export@localhost",
+		"GIT_CONFIG_COUNT=2",
+		"GIT_CONFIG_KEY_0=http.followRedirects",
+		"GIT_CONFIG_VALUE_0=false",
+		"GIT_CONFIG_KEY_1=http.extraHeader",
+		"GIT_CONFIG_VALUE_1=Authorization: Basic " + credentials,
+	}
+}
+
+type gitCommand struct {
+	path string
+	dir  string
+	env  []string
+}
+
+// run runs git with args and returns its output. Errors carry what git
+// printed, which names the failing step.
+func (g gitCommand) run(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, g.path, args...)
+	cmd.Dir = g.dir
+	cmd.Env = g.env
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], message)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return stdout.String(), nil
+}
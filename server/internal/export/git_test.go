@@ -0,0 +1,122 @@
+package export
+
+import (
+	"context"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+// gitServer serves bare repositories under root over smart HTTP, checking
+// for the given password.
+func gitServer(t *testing.T, root string, password string) *httptest.Server {
+	t.Helper()
+	execPath, err := exec.Command("git", "--exec-path").Output()
+	if err != nil {
+		t.Skipf("git is not available: %v", err)
+	}
+	backend := &cgi.Handler{
+		Path: filepath.Join(strings.TrimSpace(string(execPath)), "git-http-backend"),
+		Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, got, ok := r.BasicAuth(); !ok || got != password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		backend.ServeHTTP(w, r)
+	}))
+}
+
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestGitMirrorCommitsChanges(t *testing.T) {
+	root := t.TempDir()
+	repo := filepath.Join(root, "lists.git")
+	git(t, root, "init", "-q", "--bare", repo)
+	git(t, repo, "config", "http.receivepack", "true")
+	server := gitServer(t, root, "token")
+	defer server.Close()
+
+	runner, store := newTestRunner(t, Options{AllowPrivateTargets: true})
+	createSchedule(t, runner, store, storage.ExportSchedule{ID: "export-1", Format: FormatMarkdown, Cadence: CadenceDaily, Destination: DestinationGit, Target: server.URL + "/lists.git"}, Config{Password: "token", Branch: "backup"})
+
+	ctx := context.Background()
+	if _, err := runner.RunNow(ctx, "user-1", "export-1"); err != nil {
+		t.Fatalf("first export: %v", err)
+	}
+	if got := git(t, repo, "show", "backup:lists/groceries.md"); got != "# Groceries\n\n- [ ] milk" {
+		t.Fatalf("unexpected mirrored file %q", got)
+	}
+	// Nothing changed, so nothing is committed.
+	if _, err := runner.RunNow(ctx, "user-1", "export-1"); err != nil {
+		t.Fatalf("second export: %v", err)
+	}
+	if count := git(t, repo, "rev-list", "--count", "backup"); count != "1" {
+		t.Fatalf("expected an unchanged export not to commit, got %s commits", count)
+	}
+	if _, err := store.InsertOps(ctx, "user-1", []storage.Op{
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 3, Payload: []byte(`{"type":"update","itemId":"item-1","payload":{"data":{"done":true}}}`)},
+		{Scope: "registry", Resource: "registry", Actor: "a", Clock: 4, Payload: []byte(`{"type":"createList","listId":"list-2","payload":{"title":"Packing","pos":[{"digit":600,"actor":"a"}]}}`)},
+	}); err != nil {
+		t.Fatalf("insert ops: %v", err)
+	}
+	if _, err := runner.RunNow(ctx, "user-1", "export-1"); err != nil {
+		t.Fatalf("third export: %v", err)
+	}
+	if count := git(t, repo, "rev-list", "--count", "backup"); count != "2" {
+		t.Fatalf("expected a change to be committed on top, got %s commits", count)
+	}
+	if got := git(t, repo, "show", "backup:lists/groceries.md"); !strings.Contains(got, "- [x] milk") {
+		t.Fatalf("unexpected mirrored file %q", got)
+	}
+	if files := git(t, repo, "ls-tree", "-r", "--name-only", "backup"); files != "lists/groceries.md\nlists/packing.md" {
+		t.Fatalf("expected a file per list, got %q", files)
+	}
+
+	// A removed list's file goes too.
+	if _, err := store.InsertOps(ctx, "user-1", []storage.Op{
+		{Scope: "registry", Resource: "registry", Actor: "a", Clock: 5, Payload: []byte(`{"type":"removeList","listId":"list-1"}`)},
+	}); err != nil {
+		t.Fatalf("insert ops: %v", err)
+	}
+	if _, err := runner.RunNow(ctx, "user-1", "export-1"); err != nil {
+		t.Fatalf("fourth export: %v", err)
+	}
+	if files := git(t, repo, "ls-tree", "-r", "--name-only", "backup"); files != "lists/packing.md" {
+		t.Fatalf("expected the removed list's file to be deleted, got %q", files)
+	}
+}
+
+func TestGitExportRejectsWrongCredentials(t *testing.T) {
+	root := t.TempDir()
+	git(t, root, "init", "-q", "--bare", filepath.Join(root, "lists.git"))
+	server := gitServer(t, root, "token")
+	defer server.Close()
+
+	runner, store := newTestRunner(t, Options{AllowPrivateTargets: true})
+	createSchedule(t, runner, store, storage.ExportSchedule{ID: "export-1", Format: FormatJSON, Cadence: CadenceDaily, Destination: DestinationGit, Target: server.URL + "/lists.git"}, Config{Password: "wrong"})
+	schedule, err := runner.RunNow(context.Background(), "user-1", "export-1")
+	if err == nil || schedule.LastError == "" {
+		t.Fatalf("expected the push to fail, got %+v %v", schedule, err)
+	}
+	if strings.Contains(schedule.LastError, "wrong") {
+		t.Fatalf("expected the error not to leak credentials: %s", schedule.LastError)
+	}
+}
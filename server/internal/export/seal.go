@@ -0,0 +1,66 @@
+package export
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sealVersion prefixes sealed configurations so the scheme can change.
+const sealVersion = 1
+
+// Sealer encrypts destination configurations with AES-256-GCM. The schedule
+// id is authenticated with them, so a sealed configuration copied to another
+// schedule does not open. The user id is not: it changes when a user is
+// migrated to a new id, and schedule ids are unique across users anyway.
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+// NewSealer takes a base64-encoded 32-byte key, e.g. from
+// `openssl rand -base64 32`.
+func NewSealer(key string) (*Sealer, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return nil, fmt.Errorf("export key is not base64: %w", err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("export key must be 32 bytes, got %d", len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead: aead}, nil
+}
+
+// Seal encrypts plaintext for the schedule.
+func (s *Sealer) Seal(scheduleID string, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := append([]byte{sealVersion}, nonce...)
+	return s.aead.Seal(sealed, nonce, plaintext, []byte(scheduleID)), nil
+}
+
+// Open decrypts what Seal returned for the same schedule.
+func (s *Sealer) Open(scheduleID string, sealed []byte) ([]byte, error) {
+	nonceSize := s.aead.NonceSize()
+	if len(sealed) < 1+nonceSize || sealed[0] != sealVersion {
+		return nil, errors.New("unsupported sealed export config")
+	}
+	plaintext, err := s.aead.Open(nil, sealed[1:1+nonceSize], sealed[1+nonceSize:], []byte(scheduleID))
+	if err != nil {
+		return nil, errors.New("cannot open sealed export config; was SERVER_EXPORT_KEY changed?")
+	}
+	return plaintext, nil
+}
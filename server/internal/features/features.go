@@ -0,0 +1,115 @@
+// Package features gates optional server capabilities per deployment and per
+// user, so experimental behavior can be rolled out gradually.
+//
+// Flags are configured with a comma-separated spec such as
+//
+//	binary-transport=off,binary-transport@alice=on
+//
+// where "name" or "name=on" enables a flag for everyone, "name=off" disables
+// it, and "name@user=on|off" overrides the deployment value for one user.
+package features
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Known flags.
+const (
+	// BinaryTransport allows CBOR request and response bodies on /sync/*.
+	BinaryTransport = "binary-transport"
+	// ChunkedSnapshot allows bootstrap to omit the snapshot in favor of
+	// ranged downloads from GET /sync/snapshot.
+	ChunkedSnapshot = "chunked-snapshot"
+	// GraphQL serves read-only queries on /graphql.
+	GraphQL = "graphql"
+	// CompressedOps allows pulls to deliver large op payloads
+	// zstd-compressed.
+	CompressedOps = "compressed-ops"
+)
+
+// defaults lists every known flag with its value when not configured.
+var defaults = map[string]bool{
+	BinaryTransport: true,
+	ChunkedSnapshot: true,
+	GraphQL:         true,
+	CompressedOps:   true,
+}
+
+// Flags resolves feature flags for a user. The zero value and nil both use
+// the defaults.
+type Flags struct {
+	deployment map[string]bool
+	users      map[string]map[string]bool
+}
+
+// Parse builds flags from a spec. Unknown flag names are rejected so typos do
+// not silently leave a feature in its default state.
+func Parse(spec string) (*Flags, error) {
+	flags := &Flags{
+		deployment: map[string]bool{},
+		users:      map[string]map[string]bool{},
+	}
+	for entry := range strings.SplitSeq(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, hasValue := strings.Cut(entry, "=")
+		enabled := true
+		if hasValue {
+			switch strings.ToLower(strings.TrimSpace(value)) {
+			case "on", "true", "1":
+			case "off", "false", "0":
+				enabled = false
+			default:
+				return nil, fmt.Errorf("feature %q: invalid value %q (want on or off)", entry, value)
+			}
+		}
+		name, userID, perUser := strings.Cut(strings.TrimSpace(name), "@")
+		if _, ok := defaults[name]; !ok {
+			return nil, fmt.Errorf("unknown feature %q (known: %s)", name, strings.Join(Known(), ", "))
+		}
+		if !perUser {
+			flags.deployment[name] = enabled
+			continue
+		}
+		if userID == "" {
+			return nil, fmt.Errorf("feature %q: empty user id", entry)
+		}
+		if flags.users[userID] == nil {
+			flags.users[userID] = map[string]bool{}
+		}
+		flags.users[userID][name] = enabled
+	}
+	return flags, nil
+}
+
+// Known returns the names of all known flags, sorted.
+func Known() []string {
+	return slices.Sorted(maps.Keys(defaults))
+}
+
+// Enabled reports whether flag name is on for userID.
+func (f *Flags) Enabled(name string, userID string) bool {
+	if f != nil {
+		if enabled, ok := f.users[userID][name]; ok {
+			return enabled
+		}
+		if enabled, ok := f.deployment[name]; ok {
+			return enabled
+		}
+	}
+	return defaults[name]
+}
+
+// For returns every known flag resolved for userID.
+func (f *Flags) For(userID string) map[string]bool {
+	resolved := make(map[string]bool, len(defaults))
+	for name := range defaults {
+		resolved[name] = f.Enabled(name, userID)
+	}
+	return resolved
+}
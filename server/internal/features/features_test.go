@@ -0,0 +1,41 @@
+package features
+
+import "testing"
+
+func TestParseResolvesUserOverridesOverDeploymentValues(t *testing.T) {
+	flags, err := Parse("binary-transport=off, binary-transport@alice=on, chunked-snapshot@bob=off")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	cases := []struct {
+		name   string
+		userID string
+		want   bool
+	}{
+		{BinaryTransport, "alice", true},
+		{BinaryTransport, "bob", false},
+		{ChunkedSnapshot, "alice", true},
+		{ChunkedSnapshot, "bob", false},
+	}
+	for _, tc := range cases {
+		if got := flags.Enabled(tc.name, tc.userID); got != tc.want {
+			t.Errorf("%s for %s: expected %v, got %v", tc.name, tc.userID, tc.want, got)
+		}
+	}
+}
+
+func TestNilFlagsUseDefaults(t *testing.T) {
+	var flags *Flags
+	resolved := flags.For("alice")
+	if len(resolved) != len(Known()) || !resolved[BinaryTransport] || !resolved[ChunkedSnapshot] {
+		t.Fatalf("expected defaults, got %v", resolved)
+	}
+}
+
+func TestParseRejectsInvalidSpecs(t *testing.T) {
+	for _, spec := range []string{"delta-sync", "binary-transport=maybe", "binary-transport@=on"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("expected %q to be rejected", spec)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+// Package fleet aggregates what the server knows about its sync clients into
+// the numbers an operator needs to decide when to force compaction or retire
+// a protocol version.
+//
+// Client records (cursor, last activity, latest heartbeat) come from storage
+// and cover every known client. Sync outcomes such as conflicts and resets,
+// and the clients stuck in conflict loops, are only counted in memory by
+// Counters and start over on restart.
+package fleet
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+// UnknownVersion stands for clients that never reported an app version.
+const UnknownVersion = "unknown"
+
+// maxProtocolVersionLabels bounds how many distinct protocol versions are
+// counted, so arbitrary request headers cannot grow the counters.
+const maxProtocolVersionLabels = 16
+
+// DefaultStuckConflicts is how many conflicts in a row flag a client as
+// stuck in a conflict loop unless configured otherwise: it keeps sending a
+// stale generation key instead of adopting the one its conflicts carried.
+const DefaultStuckConflicts = 5
+
+// maxConflictStreaks bounds how many clients with a conflict streak are
+// tracked. When full, the streak whose latest conflict is oldest goes first.
+const maxConflictStreaks = 10000
+
+// maxStuckClients bounds the stuck clients listed in Activity.
+const maxStuckClients = 10
+
+// Counters counts sync outcomes since the server started. All methods accept
+// a nil Counters and then do nothing.
+type Counters struct {
+	started time.Time
+
+	pushes             atomic.Int64
+	pulls              atomic.Int64
+	conflicts          atomic.Int64
+	divergentConflicts atomic.Int64
+	resets             atomic.Int64
+	resetConflicts     atomic.Int64
+	forcedResyncs      atomic.Int64
+	stuckConflicts     int
+
+	mu               sync.Mutex
+	protocolVersions map[string]int64
+	streaks          map[streakKey]conflictStreak
+}
+
+// streakKey identifies a client across users.
+type streakKey struct {
+	userID   string
+	clientID string
+}
+
+// conflictStreak is a client's run of conflicts since its key last matched.
+type conflictStreak struct {
+	conflicts int
+	first     time.Time
+	last      time.Time
+}
+
+// NewCounters returns counters starting now that flag clients as stuck after
+// stuckConflicts conflicts in a row, or never when it is zero.
+func NewCounters(stuckConflicts int) *Counters {
+	return &Counters{
+		started:          time.Now(),
+		stuckConflicts:   stuckConflicts,
+		protocolVersions: make(map[string]int64),
+		streaks:          make(map[streakKey]conflictStreak),
+	}
+}
+
+// Request counts a /sync/* request announcing protocolVersion, including
+// requests later rejected for it.
+func (c *Counters) Request(protocolVersion int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	label := strconv.Itoa(protocolVersion)
+	if _, ok := c.protocolVersions[label]; !ok && len(c.protocolVersions) >= maxProtocolVersionLabels {
+		label = "other"
+	}
+	c.protocolVersions[label]++
+}
+
+// Push counts a successful push.
+func (c *Counters) Push() {
+	if c != nil {
+		c.pushes.Add(1)
+	}
+}
+
+// Pull counts a successful pull.
+func (c *Counters) Pull() {
+	if c != nil {
+		c.pulls.Add(1)
+	}
+}
+
+// Conflict counts a request of a client rejected for a stale generation key;
+// divergent ones require the client to resync fully. It returns how many
+// conflicts in a row the client has had, including this one, and whether
+// that makes it stuck.
+func (c *Counters) Conflict(userID, clientID string, divergent bool) (int, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.conflicts.Add(1)
+	if divergent {
+		c.divergentConflicts.Add(1)
+	}
+	now := time.Now()
+	key := streakKey{userID: userID, clientID: clientID}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	streak, ok := c.streaks[key]
+	if !ok {
+		if len(c.streaks) >= maxConflictStreaks {
+			c.evictStreak()
+		}
+		streak.first = now
+	}
+	streak.conflicts++
+	streak.last = now
+	c.streaks[key] = streak
+	return streak.conflicts, c.stuck(streak.conflicts)
+}
+
+// stuck reports whether conflicts in a row make a client stuck.
+func (c *Counters) stuck(conflicts int) bool {
+	return c.stuckConflicts > 0 && conflicts >= c.stuckConflicts
+}
+
+// Matched ends the conflict streak of a client whose generation key matched
+// again.
+func (c *Counters) Matched(userID, clientID string) {
+	if c == nil {
+		return
+	}
+	key := streakKey{userID: userID, clientID: clientID}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.streaks, key)
+}
+
+// evictStreak drops the streak whose latest conflict is oldest. Callers must
+// hold c.mu.
+func (c *Counters) evictStreak() {
+	var oldest streakKey
+	var oldestAt time.Time
+	for key, streak := range c.streaks {
+		if oldestAt.IsZero() || streak.last.Before(oldestAt) {
+			oldest, oldestAt = key, streak.last
+		}
+	}
+	delete(c.streaks, oldest)
+}
+
+// Reset counts a dataset reset that was applied.
+func (c *Counters) Reset() {
+	if c != nil {
+		c.resets.Add(1)
+	}
+}
+
+// ForcedResync counts a stuck client that was made to resync.
+func (c *Counters) ForcedResync() {
+	if c != nil {
+		c.forcedResyncs.Add(1)
+	}
+}
+
+// ResetConflict counts a reset rejected because the generation changed.
+func (c *Counters) ResetConflict() {
+	if c != nil {
+		c.resetConflicts.Add(1)
+	}
+}
+
+// Activity is a snapshot of the counters.
+type Activity struct {
+	// Since is when counting started, in Unix seconds.
+	Since              int64            `json:"since"`
+	Pushes             int64            `json:"pushes"`
+	Pulls              int64            `json:"pulls"`
+	Conflicts          int64            `json:"conflicts"`
+	DivergentConflicts int64            `json:"divergentConflicts"`
+	Resets             int64            `json:"resets"`
+	ResetConflicts     int64            `json:"resetConflicts"`
+	ForcedResyncs      int64            `json:"forcedResyncs"`
+	ProtocolVersions   map[string]int64 `json:"protocolVersions"`
+	// StuckClients are up to ten clients stuck in a conflict loop, longest
+	// streak first.
+	StuckClients []StuckClient `json:"stuckClients"`
+	// ConflictRate and ResetRate relate conflicts and resets to successful
+	// pushes and pulls.
+	ConflictRate float64 `json:"conflictRate"`
+	ResetRate    float64 `json:"resetRate"`
+}
+
+// StuckClient is a client whose requests keep conflicting.
+type StuckClient struct {
+	UserID    string `json:"userId"`
+	ClientID  string `json:"clientId"`
+	Conflicts int    `json:"conflicts"`
+	// Since and LastConflict are the first and latest conflict of the
+	// streak, in Unix seconds.
+	Since        int64 `json:"since"`
+	LastConflict int64 `json:"lastConflict"`
+}
+
+// Activity returns the current counts.
+func (c *Counters) Activity() Activity {
+	if c == nil {
+		return Activity{ProtocolVersions: map[string]int64{}, StuckClients: []StuckClient{}}
+	}
+	activity := Activity{
+		Since:              c.started.Unix(),
+		Pushes:             c.pushes.Load(),
+		Pulls:              c.pulls.Load(),
+		Conflicts:          c.conflicts.Load(),
+		DivergentConflicts: c.divergentConflicts.Load(),
+		Resets:             c.resets.Load(),
+		ResetConflicts:     c.resetConflicts.Load(),
+		ForcedResyncs:      c.forcedResyncs.Load(),
+	}
+	c.mu.Lock()
+	activity.ProtocolVersions = make(map[string]int64, len(c.protocolVersions))
+	for label, count := range c.protocolVersions {
+		activity.ProtocolVersions[label] = count
+	}
+	activity.StuckClients = make([]StuckClient, 0)
+	for key, streak := range c.streaks {
+		if c.stuck(streak.conflicts) {
+			activity.StuckClients = append(activity.StuckClients, StuckClient{
+				UserID:       key.userID,
+				ClientID:     key.clientID,
+				Conflicts:    streak.conflicts,
+				Since:        streak.first.Unix(),
+				LastConflict: streak.last.Unix(),
+			})
+		}
+	}
+	c.mu.Unlock()
+	slices.SortFunc(activity.StuckClients, func(a, b StuckClient) int {
+		return cmp.Or(cmp.Compare(b.Conflicts, a.Conflicts), cmp.Compare(a.UserID, b.UserID), cmp.Compare(a.ClientID, b.ClientID))
+	})
+	if len(activity.StuckClients) > maxStuckClients {
+		activity.StuckClients = activity.StuckClients[:maxStuckClients]
+	}
+	if syncs := activity.Pushes + activity.Pulls; syncs > 0 {
+		activity.ConflictRate = float64(activity.Conflicts) / float64(syncs)
+		activity.ResetRate = float64(activity.Resets) / float64(syncs)
+	}
+	return activity
+}
+
+// Bucket counts the clients up to a bound. Buckets are not cumulative.
+type Bucket struct {
+	// Le is the inclusive upper bound, or "+Inf" for the last bucket.
+	Le      string `json:"le"`
+	Clients int    `json:"clients"`
+}
+
+// VersionCount is how many clients last reported an app version.
+type VersionCount struct {
+	Version string `json:"version"`
+	Clients int    `json:"clients"`
+}
+
+// Laggard is a client trailing its user's op log.
+type Laggard struct {
+	UserID    string `json:"userId"`
+	ClientID  string `json:"clientId"`
+	Lag       int64  `json:"lag"`
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+// Report is the fleet overview served to operators.
+type Report struct {
+	Clients     int            `json:"clients"`
+	Users       int            `json:"users"`
+	AppVersions []VersionCount `json:"appVersions"`
+	// CursorLag buckets clients by how many serverSeqs their cursor trails
+	// the latest op of their user's active generation.
+	CursorLag []Bucket `json:"cursorLag"`
+	// LastSeen buckets clients by how long ago they last synced or sent a
+	// heartbeat.
+	LastSeen []Bucket `json:"lastSeen"`
+	// Laggards are the clients trailing furthest, furthest first. They hold
+	// back compaction the most.
+	Laggards []Laggard `json:"laggards"`
+	Activity Activity  `json:"activity"`
+}
+
+// Bounds of the report's buckets and its laggard list.
+var (
+	lagBounds      = []int64{0, 10, 100, 1000, 10000}
+	lastSeenBounds = []struct {
+		label string
+		age   time.Duration
+	}{
+		{"1h", time.Hour},
+		{"24h", 24 * time.Hour},
+		{"7d", 7 * 24 * time.Hour},
+		{"30d", 30 * 24 * time.Hour},
+	}
+	maxLaggards = 10
+)
+
+// Summarize builds the report for clients as of now.
+func Summarize(clients []storage.FleetClient, activity Activity, now time.Time) Report {
+	report := Report{
+		Clients:     len(clients),
+		AppVersions: make([]VersionCount, 0),
+		CursorLag:   make([]Bucket, len(lagBounds)+1),
+		LastSeen:    make([]Bucket, len(lastSeenBounds)+1),
+		Laggards:    make([]Laggard, 0),
+		Activity:    activity,
+	}
+	for i, bound := range lagBounds {
+		report.CursorLag[i].Le = strconv.FormatInt(bound, 10)
+	}
+	report.CursorLag[len(lagBounds)].Le = "+Inf"
+	for i, bound := range lastSeenBounds {
+		report.LastSeen[i].Le = bound.label
+	}
+	report.LastSeen[len(lastSeenBounds)].Le = "+Inf"
+
+	users := make(map[string]struct{})
+	versions := make(map[string]int)
+	for _, client := range clients {
+		users[client.UserID] = struct{}{}
+		version := client.AppVersion
+		if version == "" {
+			version = UnknownVersion
+		}
+		versions[version]++
+
+		lag := client.Lag()
+		report.CursorLag[bucketIndex(len(lagBounds), func(i int) bool { return lag <= lagBounds[i] })].Clients++
+		lastSeen := client.LastSeen()
+		age := now.Sub(time.Unix(lastSeen, 0))
+		report.LastSeen[bucketIndex(len(lastSeenBounds), func(i int) bool { return age <= lastSeenBounds[i].age })].Clients++
+		if lag > 0 {
+			report.Laggards = append(report.Laggards, Laggard{UserID: client.UserID, ClientID: client.ClientID, Lag: lag, UpdatedAt: lastSeen})
+		}
+	}
+	report.Users = len(users)
+	for version, count := range versions {
+		report.AppVersions = append(report.AppVersions, VersionCount{Version: version, Clients: count})
+	}
+	slices.SortFunc(report.AppVersions, func(a, b VersionCount) int {
+		return cmp.Or(cmp.Compare(b.Clients, a.Clients), cmp.Compare(a.Version, b.Version))
+	})
+	slices.SortStableFunc(report.Laggards, func(a, b Laggard) int { return cmp.Compare(b.Lag, a.Lag) })
+	if len(report.Laggards) > maxLaggards {
+		report.Laggards = report.Laggards[:maxLaggards]
+	}
+	return report
+}
+
+// bucketIndex returns the first of n bounds that fits, or n for the overflow
+// bucket.
+func bucketIndex(n int, fits func(int) bool) int {
+	for i := range n {
+		if fits(i) {
+			return i
+		}
+	}
+	return n
+}
@@ -0,0 +1,133 @@
+package fleet
+
+import (
+	"testing"
+	"time"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestSummarizeBucketsClients(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	client := func(userID, clientID, version string, cursor, latest int64, age time.Duration) storage.FleetClient {
+		return storage.FleetClient{
+			UserID: userID,
+			Client: storage.Client{
+				ClientID:          clientID,
+				LastSeenServerSeq: cursor,
+				UpdatedAt:         now.Add(-age).Unix(),
+				ClientHeartbeat:   storage.ClientHeartbeat{AppVersion: version},
+			},
+			LatestServerSeq: latest,
+		}
+	}
+	report := Summarize([]storage.FleetClient{
+		client("user-1", "phone", "1.5.0", 50, 50, time.Minute),
+		client("user-1", "laptop", "1.4.0", 45, 50, 2*time.Hour),
+		client("user-2", "tablet", "1.5.0", 0, 20000, 40*24*time.Hour),
+		client("user-3", "old", "", 900, 1000, 3*24*time.Hour),
+	}, Activity{}, now)
+
+	if report.Clients != 4 || report.Users != 3 {
+		t.Fatalf("unexpected totals: %+v", report)
+	}
+	wantVersions := []VersionCount{{"1.5.0", 2}, {"1.4.0", 1}, {UnknownVersion, 1}}
+	if len(report.AppVersions) != len(wantVersions) {
+		t.Fatalf("unexpected versions: %+v", report.AppVersions)
+	}
+	for i, want := range wantVersions {
+		if report.AppVersions[i] != want {
+			t.Fatalf("unexpected versions: %+v", report.AppVersions)
+		}
+	}
+	wantLag := []Bucket{{"0", 1}, {"10", 1}, {"100", 1}, {"1000", 0}, {"10000", 0}, {"+Inf", 1}}
+	wantLastSeen := []Bucket{{"1h", 1}, {"24h", 1}, {"7d", 1}, {"30d", 0}, {"+Inf", 1}}
+	for name, buckets := range map[string][2][]Bucket{"lag": {report.CursorLag, wantLag}, "last seen": {report.LastSeen, wantLastSeen}} {
+		got, want := buckets[0], buckets[1]
+		if len(got) != len(want) {
+			t.Fatalf("unexpected %s buckets: %+v", name, got)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("unexpected %s buckets: %+v", name, got)
+			}
+		}
+	}
+	if len(report.Laggards) != 3 || report.Laggards[0].ClientID != "tablet" || report.Laggards[0].Lag != 20000 || report.Laggards[2].ClientID != "laptop" {
+		t.Fatalf("unexpected laggards: %+v", report.Laggards)
+	}
+}
+
+func TestCountersReportRates(t *testing.T) {
+	var disabled *Counters
+	disabled.Push()
+	disabled.Request(1)
+	if activity := disabled.Activity(); activity.Pushes != 0 {
+		t.Fatalf("nil counters should count nothing: %+v", activity)
+	}
+
+	counters := NewCounters(DefaultStuckConflicts)
+	for range 3 {
+		counters.Push()
+		counters.Request(1)
+	}
+	counters.Pull()
+	counters.Conflict("user-1", "phone", false)
+	counters.Conflict("user-1", "phone", true)
+	counters.Reset()
+	counters.ResetConflict()
+	counters.ForcedResync()
+	counters.Request(2)
+	for version := range 2 * maxProtocolVersionLabels {
+		counters.Request(100 + version)
+	}
+	activity := counters.Activity()
+	if activity.Pushes != 3 || activity.Pulls != 1 || activity.Conflicts != 2 || activity.DivergentConflicts != 1 || activity.Resets != 1 || activity.ResetConflicts != 1 || activity.ForcedResyncs != 1 {
+		t.Fatalf("unexpected counts: %+v", activity)
+	}
+	if activity.ConflictRate != 0.5 || activity.ResetRate != 0.25 {
+		t.Fatalf("unexpected rates: %+v", activity)
+	}
+	if activity.ProtocolVersions["1"] != 3 || activity.ProtocolVersions["2"] != 1 || len(activity.ProtocolVersions) != maxProtocolVersionLabels+1 {
+		t.Fatalf("unexpected protocol versions: %+v", activity.ProtocolVersions)
+	}
+}
+
+func TestCountersFlagClientsStuckInConflicts(t *testing.T) {
+	counters := NewCounters(DefaultStuckConflicts)
+	for i := range DefaultStuckConflicts - 1 {
+		if got, stuck := counters.Conflict("user-1", "phone", false); got != i+1 || stuck {
+			t.Fatalf("expected conflict %d in a row, got %d (stuck %t)", i+1, got, stuck)
+		}
+		counters.Conflict("user-2", "phone", false)
+	}
+	if stuck := counters.Activity().StuckClients; len(stuck) != 0 {
+		t.Fatalf("expected no stuck clients yet, got %+v", stuck)
+	}
+
+	// A matching key ends the streak; the other client keeps conflicting.
+	counters.Matched("user-1", "phone")
+	if got, _ := counters.Conflict("user-1", "phone", false); got != 1 {
+		t.Fatalf("expected the streak to start over, got %d", got)
+	}
+	if got, stuck := counters.Conflict("user-2", "phone", true); got != DefaultStuckConflicts || !stuck {
+		t.Fatalf("expected %d conflicts in a row to be stuck, got %d (stuck %t)", DefaultStuckConflicts, got, stuck)
+	}
+	stuck := counters.Activity().StuckClients
+	if len(stuck) != 1 || stuck[0].UserID != "user-2" || stuck[0].ClientID != "phone" || stuck[0].Conflicts != DefaultStuckConflicts || stuck[0].Since == 0 {
+		t.Fatalf("unexpected stuck clients: %+v", stuck)
+	}
+
+	var disabled *Counters
+	if got, _ := disabled.Conflict("user-1", "phone", false); got != 0 {
+		t.Fatalf("nil counters should count nothing, got %d", got)
+	}
+	disabled.Matched("user-1", "phone")
+
+	never := NewCounters(0)
+	for range 2 * DefaultStuckConflicts {
+		if _, stuck := never.Conflict("user-1", "phone", false); stuck {
+			t.Fatal("a zero threshold should never flag a client")
+		}
+	}
+}
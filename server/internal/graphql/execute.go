@@ -0,0 +1,357 @@
+// Package graphql executes GraphQL queries against a schema of resolver
+// functions. It covers the query language integrators use day to day
+// (operations, variables, aliases, fragments, @skip and @include) without
+// type-checking or introspection: each field declares the arguments it
+// accepts and its resolver checks their values.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+// Object is an object type whose fields can be selected.
+type Object struct {
+	Name   string
+	Fields map[string]*Field
+}
+
+// Field resolves one field of an Object.
+type Field struct {
+	// Type is the object type of the resolved value (or of its elements when
+	// the value is a slice); nil for scalars, which must not have a selection.
+	Type *Object
+	// Args lists the accepted argument names.
+	Args []string
+	// Resolve returns the field's value for the parent Source.
+	Resolve func(p ResolveParams) (any, error)
+}
+
+// ResolveParams are passed to Field.Resolve.
+type ResolveParams struct {
+	Context context.Context
+	Source  any
+	Args    map[string]any
+}
+
+// Schema is the entry point for queries.
+type Schema struct {
+	Query *Object
+}
+
+// Request is a GraphQL request as sent over HTTP.
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// Error is a request or field error. Path locates a field error in data.
+type Error struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+// Response is the result of Execute. Data is nil when the request could not
+// be executed at all.
+type Response struct {
+	Data   *OrderedMap `json:"data,omitempty"`
+	Errors []Error     `json:"errors,omitempty"`
+}
+
+// OrderedMap is a JSON object that keeps the order of the selection.
+type OrderedMap struct {
+	keys   []string
+	values map[string]any
+}
+
+func newOrderedMap() *OrderedMap {
+	return &OrderedMap{values: map[string]any{}}
+}
+
+func (m *OrderedMap) set(key string, value any) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// Get returns the value of key.
+func (m *OrderedMap) Get(key string) (any, bool) {
+	value, ok := m.values[key]
+	return value, ok
+}
+
+func (m *OrderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		encodedKey, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(encodedKey)
+		buf.WriteByte(':')
+		encodedValue, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(encodedValue)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Execute runs the requested query operation with root as the source of the
+// query's top-level fields.
+func Execute(ctx context.Context, schema Schema, root any, req Request) Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return Response{Errors: []Error{{Message: "parse: " + err.Error()}}}
+	}
+	op, err := selectOperation(doc, req.OperationName)
+	if err != nil {
+		return Response{Errors: []Error{{Message: err.Error()}}}
+	}
+	if op.kind != "query" {
+		// Changes go through the sync protocol or the REST facade.
+		return Response{Errors: []Error{{Message: op.kind + " operations are not supported"}}}
+	}
+	variables := map[string]any{}
+	for _, definition := range op.variables {
+		value, ok := req.Variables[definition.name]
+		switch {
+		case ok && value != nil:
+		case definition.hasDefault:
+			value = definition.defaultValue
+		case definition.required:
+			return Response{Errors: []Error{{Message: fmt.Sprintf("variable $%s is required", definition.name)}}}
+		}
+		variables[definition.name] = value
+	}
+	e := &executor{ctx: ctx, doc: doc, variables: variables}
+	data := e.selectionSet(schema.Query, root, op.selections, nil)
+	return Response{Data: data, Errors: e.errors}
+}
+
+func selectOperation(doc *document, name string) (*operation, error) {
+	if name == "" {
+		if len(doc.operations) > 1 {
+			return nil, fmt.Errorf("operationName is required when the document has several operations")
+		}
+		return doc.operations[0], nil
+	}
+	for _, op := range doc.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+type executor struct {
+	ctx       context.Context
+	doc       *document
+	variables map[string]any
+	errors    []Error
+}
+
+func (e *executor) fail(path []any, format string, args ...any) {
+	e.errors = append(e.errors, Error{Message: fmt.Sprintf(format, args...), Path: slices.Clone(path)})
+}
+
+// collectedField is every selection of one response key, merged.
+type collectedField struct {
+	key        string
+	selections []*selection
+}
+
+func (e *executor) collectFields(obj *Object, selections []*selection, fields []*collectedField, visited map[string]bool) []*collectedField {
+	for _, sel := range selections {
+		if !e.included(sel.directives) {
+			continue
+		}
+		switch {
+		case sel.spread != "":
+			if visited[sel.spread] {
+				continue
+			}
+			visited[sel.spread] = true
+			frag, ok := e.doc.fragments[sel.spread]
+			if !ok || frag.typeCondition != obj.Name {
+				continue
+			}
+			fields = e.collectFields(obj, frag.selections, fields, visited)
+		case sel.inline:
+			if sel.typeCondition != "" && sel.typeCondition != obj.Name {
+				continue
+			}
+			fields = e.collectFields(obj, sel.selections, fields, visited)
+		default:
+			i := slices.IndexFunc(fields, func(field *collectedField) bool { return field.key == sel.alias })
+			if i < 0 {
+				fields = append(fields, &collectedField{key: sel.alias})
+				i = len(fields) - 1
+			}
+			fields[i].selections = append(fields[i].selections, sel)
+		}
+	}
+	return fields
+}
+
+// included applies @skip(if:) and @include(if:).
+func (e *executor) included(directives []directive) bool {
+	for _, d := range directives {
+		condition, _ := e.resolveValue(d.arguments["if"]).(bool)
+		if (d.name == "skip" && condition) || (d.name == "include" && !condition) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *executor) selectionSet(obj *Object, source any, selections []*selection, path []any) *OrderedMap {
+	result := newOrderedMap()
+	for _, collected := range e.collectFields(obj, selections, nil, map[string]bool{}) {
+		fieldPath := append(slices.Clone(path), collected.key)
+		sel := collected.selections[0]
+		if sel.name == "__typename" {
+			result.set(collected.key, obj.Name)
+			continue
+		}
+		field, ok := obj.Fields[sel.name]
+		if !ok {
+			e.fail(fieldPath, "unknown field %q on %s", sel.name, obj.Name)
+			result.set(collected.key, nil)
+			continue
+		}
+		args := make(map[string]any, len(sel.arguments))
+		var badArg string
+		for name, value := range sel.arguments {
+			if !slices.Contains(field.Args, name) {
+				badArg = name
+				break
+			}
+			args[name] = e.resolveValue(value)
+		}
+		if badArg != "" {
+			e.fail(fieldPath, "unknown argument %q on %s.%s", badArg, obj.Name, sel.name)
+			result.set(collected.key, nil)
+			continue
+		}
+		value, err := field.Resolve(ResolveParams{Context: e.ctx, Source: source, Args: args})
+		if err != nil {
+			e.fail(fieldPath, "%s", err.Error())
+			result.set(collected.key, nil)
+			continue
+		}
+		var subselections []*selection
+		for _, merged := range collected.selections {
+			subselections = append(subselections, merged.selections...)
+		}
+		result.set(collected.key, e.complete(field, sel.name, value, subselections, fieldPath))
+	}
+	return result
+}
+
+// complete shapes a resolved value for the response: scalars are returned
+// as they are, objects (and slices of them) are narrowed to the selection.
+func (e *executor) complete(field *Field, name string, value any, selections []*selection, path []any) any {
+	if field.Type == nil {
+		if len(selections) > 0 {
+			e.fail(path, "field %q is a scalar and has no subfields", name)
+			return nil
+		}
+		return value
+	}
+	if len(selections) == 0 {
+		e.fail(path, "field %q needs a selection of subfields", name)
+		return nil
+	}
+	if value == nil {
+		return nil
+	}
+	if v := reflect.ValueOf(value); v.Kind() == reflect.Slice {
+		items := make([]any, v.Len())
+		for i := range v.Len() {
+			items[i] = e.selectionSet(field.Type, v.Index(i).Interface(), selections, append(path, i))
+		}
+		return items
+	}
+	return e.selectionSet(field.Type, value, selections, path)
+}
+
+// resolveValue replaces variable references in an argument value.
+func (e *executor) resolveValue(value any) any {
+	switch v := value.(type) {
+	case variableRef:
+		return e.variables[string(v)]
+	case []any:
+		resolved := make([]any, len(v))
+		for i, item := range v {
+			resolved[i] = e.resolveValue(item)
+		}
+		return resolved
+	case map[string]any:
+		resolved := make(map[string]any, len(v))
+		for key, item := range v {
+			resolved[key] = e.resolveValue(item)
+		}
+		return resolved
+	}
+	return value
+}
+
+// StringArg returns the string argument name; ok is false when it is absent
+// or null.
+func StringArg(args map[string]any, name string) (string, bool, error) {
+	value, ok := args[name]
+	if !ok || value == nil {
+		return "", false, nil
+	}
+	s, isString := value.(string)
+	if !isString {
+		return "", false, fmt.Errorf("argument %q must be a string", name)
+	}
+	return s, true, nil
+}
+
+// BoolArg returns the boolean argument name; ok is false when it is absent or
+// null.
+func BoolArg(args map[string]any, name string) (bool, bool, error) {
+	value, ok := args[name]
+	if !ok || value == nil {
+		return false, false, nil
+	}
+	b, isBool := value.(bool)
+	if !isBool {
+		return false, false, fmt.Errorf("argument %q must be a boolean", name)
+	}
+	return b, true, nil
+}
+
+// IntArg returns the integer argument name; ok is false when it is absent or
+// null. Variables decoded from JSON arrive as float64 and are accepted when
+// integral.
+func IntArg(args map[string]any, name string) (int64, bool, error) {
+	value, ok := args[name]
+	if !ok || value == nil {
+		return 0, false, nil
+	}
+	switch n := value.(type) {
+	case int64:
+		return n, true, nil
+	case float64:
+		if n == float64(int64(n)) {
+			return int64(n), true, nil
+		}
+	}
+	return 0, false, fmt.Errorf("argument %q must be an integer", name)
+}
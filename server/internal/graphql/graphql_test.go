@@ -0,0 +1,108 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type testBook struct {
+	Title  string
+	Pages  int
+	Author *testAuthor
+}
+
+type testAuthor struct {
+	Name string
+}
+
+func testSchema() Schema {
+	author := &Object{Name: "Author", Fields: map[string]*Field{
+		"name": {Resolve: func(p ResolveParams) (any, error) { return p.Source.(*testAuthor).Name, nil }},
+	}}
+	book := &Object{Name: "Book", Fields: map[string]*Field{
+		"title": {Resolve: func(p ResolveParams) (any, error) { return p.Source.(testBook).Title, nil }},
+		"pages": {Resolve: func(p ResolveParams) (any, error) { return p.Source.(testBook).Pages, nil }},
+		"author": {Type: author, Resolve: func(p ResolveParams) (any, error) {
+			if a := p.Source.(testBook).Author; a != nil {
+				return a, nil
+			}
+			return nil, nil
+		}},
+	}}
+	books := []testBook{
+		{Title: "Dune", Pages: 412, Author: &testAuthor{Name: "Herbert"}},
+		{Title: "Anonymous", Pages: 90},
+	}
+	query := &Object{Name: "Query", Fields: map[string]*Field{
+		"books": {Type: book, Args: []string{"minPages"}, Resolve: func(p ResolveParams) (any, error) {
+			minPages, _, err := IntArg(p.Args, "minPages")
+			if err != nil {
+				return nil, err
+			}
+			matched := make([]testBook, 0)
+			for _, b := range books {
+				if int64(b.Pages) >= minPages {
+					matched = append(matched, b)
+				}
+			}
+			return matched, nil
+		}},
+	}}
+	return Schema{Query: query}
+}
+
+func execute(t *testing.T, req Request) string {
+	t.Helper()
+	encoded, err := json.Marshal(Execute(context.Background(), testSchema(), nil, req))
+	if err != nil {
+		t.Fatalf("encode response: %v", err)
+	}
+	return string(encoded)
+}
+
+func TestExecuteSelectsFieldsInOrder(t *testing.T) {
+	got := execute(t, Request{
+		Query: `
+			query Long($min: Int = 0, $withAuthor: Boolean!) {
+				long: books(minPages: $min) { ...BookFields author @include(if: $withAuthor) { name } }
+			}
+			# trailing comment
+			fragment BookFields on Book { pages, title, __typename }
+		`,
+		Variables: map[string]any{"min": float64(100), "withAuthor": true},
+	})
+	want := `{"data":{"long":[{"pages":412,"title":"Dune","__typename":"Book","author":{"name":"Herbert"}}]}}`
+	if got != want {
+		t.Fatalf("got %s\nwant %s", got, want)
+	}
+	got = execute(t, Request{Query: `{ books { title author { name } } }`})
+	want = `{"data":{"books":[{"title":"Dune","author":{"name":"Herbert"}},{"title":"Anonymous","author":null}]}}`
+	if got != want {
+		t.Fatalf("got %s\nwant %s", got, want)
+	}
+}
+
+func TestExecuteReportsErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		req  Request
+		want string
+	}{
+		{"parse", Request{Query: `{ books { title }`}, `{"errors":[{"message":"parse: unexpected end of document"}]}`},
+		{"mutation", Request{Query: `mutation { books { title } }`}, `{"errors":[{"message":"mutation operations are not supported"}]}`},
+		{"missing variable", Request{Query: `query ($min: Int!) { books(minPages: $min) { title } }`}, `{"errors":[{"message":"variable $min is required"}]}`},
+		{"unknown field", Request{Query: `{ books { isbn } }`}, `"message":"unknown field \"isbn\" on Book","path":["books",0,"isbn"]`},
+		{"unknown argument", Request{Query: `{ books(limit: 1) { title } }`}, `{"data":{"books":null},"errors":[{"message":"unknown argument \"limit\" on Query.books","path":["books"]}]}`},
+		{"bad argument", Request{Query: `{ books(minPages: "many") { title } }`}, `"message":"argument \"minPages\" must be an integer"`},
+		{"missing selection", Request{Query: `{ books }`}, `"message":"field \"books\" needs a selection of subfields"`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := execute(t, tc.req); !strings.Contains(got, tc.want) {
+				t.Fatalf("got %s\nwant it to contain %s", got, tc.want)
+			}
+		})
+	}
+}
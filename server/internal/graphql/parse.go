@@ -0,0 +1,592 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// document is a parsed executable GraphQL document.
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	kind       string // query, mutation or subscription
+	name       string
+	variables  []variableDefinition
+	selections []*selection
+}
+
+type variableDefinition struct {
+	name         string
+	required     bool
+	defaultValue any
+	hasDefault   bool
+}
+
+type fragment struct {
+	typeCondition string
+	selections    []*selection
+}
+
+// selection is a field, a fragment spread (spread set) or an inline fragment
+// (inline set).
+type selection struct {
+	alias      string
+	name       string
+	arguments  map[string]any
+	directives []directive
+	selections []*selection
+
+	spread        string
+	inline        bool
+	typeCondition string
+}
+
+type directive struct {
+	name      string
+	arguments map[string]any
+}
+
+// variableRef is a $variable in a value position, resolved at execution.
+type variableRef string
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunct
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+type parser struct {
+	src string
+	pos int
+	tok token
+}
+
+func parse(src string) (*document, error) {
+	p := &parser{src: src}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	doc := &document{fragments: map[string]*fragment{}}
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.peek(tokenPunct, "{"):
+			selections, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &operation{kind: "query", selections: selections})
+		case p.peek(tokenName, "query"), p.peek(tokenName, "mutation"), p.peek(tokenName, "subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.peek(tokenName, "fragment"):
+			name, frag, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.fragments[name]; ok {
+				return nil, fmt.Errorf("fragment %q is defined more than once", name)
+			}
+			doc.fragments[name] = frag
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("document has no operation")
+	}
+	return doc, nil
+}
+
+func (p *parser) operation() (*operation, error) {
+	op := &operation{kind: p.tok.value}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokenName {
+		op.name = p.tok.value
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek(tokenPunct, "(") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		for !p.peek(tokenPunct, ")") {
+			definition, err := p.variableDefinition()
+			if err != nil {
+				return nil, err
+			}
+			op.variables = append(op.variables, definition)
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	selections, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.selections = selections
+	return op, nil
+}
+
+func (p *parser) variableDefinition() (variableDefinition, error) {
+	if err := p.expect(tokenPunct, "$"); err != nil {
+		return variableDefinition{}, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return variableDefinition{}, err
+	}
+	if err := p.expect(tokenPunct, ":"); err != nil {
+		return variableDefinition{}, err
+	}
+	required, err := p.typeReference()
+	if err != nil {
+		return variableDefinition{}, err
+	}
+	definition := variableDefinition{name: name, required: required}
+	if p.peek(tokenPunct, "=") {
+		if err := p.next(); err != nil {
+			return variableDefinition{}, err
+		}
+		value, err := p.value(true)
+		if err != nil {
+			return variableDefinition{}, err
+		}
+		definition.defaultValue, definition.hasDefault = value, true
+	}
+	return definition, nil
+}
+
+// typeReference skips a type such as [String!]! and reports whether the
+// outermost type is non-null. Values are checked by the resolvers, not here.
+func (p *parser) typeReference() (bool, error) {
+	if p.peek(tokenPunct, "[") {
+		if err := p.next(); err != nil {
+			return false, err
+		}
+		if _, err := p.typeReference(); err != nil {
+			return false, err
+		}
+		if err := p.expect(tokenPunct, "]"); err != nil {
+			return false, err
+		}
+	} else if _, err := p.name(); err != nil {
+		return false, err
+	}
+	if p.peek(tokenPunct, "!") {
+		return true, p.next()
+	}
+	return false, nil
+}
+
+func (p *parser) fragment() (string, *fragment, error) {
+	if err := p.next(); err != nil {
+		return "", nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return "", nil, err
+	}
+	if err := p.expect(tokenName, "on"); err != nil {
+		return "", nil, err
+	}
+	typeCondition, err := p.name()
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := p.directives(); err != nil {
+		return "", nil, err
+	}
+	selections, err := p.selectionSet()
+	if err != nil {
+		return "", nil, err
+	}
+	return name, &fragment{typeCondition: typeCondition, selections: selections}, nil
+}
+
+func (p *parser) selectionSet() ([]*selection, error) {
+	if err := p.expect(tokenPunct, "{"); err != nil {
+		return nil, err
+	}
+	selections := make([]*selection, 0)
+	for !p.peek(tokenPunct, "}") {
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, sel)
+	}
+	if len(selections) == 0 {
+		return nil, fmt.Errorf("empty selection set at offset %d", p.tok.pos)
+	}
+	return selections, p.next()
+}
+
+func (p *parser) selection() (*selection, error) {
+	if p.peek(tokenPunct, "...") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		sel := &selection{}
+		if p.tok.kind == tokenName && p.tok.value != "on" {
+			sel.spread = p.tok.value
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		} else {
+			sel.inline = true
+			if p.peek(tokenName, "on") {
+				if err := p.next(); err != nil {
+					return nil, err
+				}
+				typeCondition, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				sel.typeCondition = typeCondition
+			}
+		}
+		directives, err := p.directives()
+		if err != nil {
+			return nil, err
+		}
+		sel.directives = directives
+		if sel.inline {
+			if sel.selections, err = p.selectionSet(); err != nil {
+				return nil, err
+			}
+		}
+		return sel, nil
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	sel := &selection{alias: name, name: name}
+	if p.peek(tokenPunct, ":") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if sel.name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if sel.arguments, err = p.arguments(); err != nil {
+		return nil, err
+	}
+	if sel.directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if p.peek(tokenPunct, "{") {
+		if sel.selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+func (p *parser) arguments() (map[string]any, error) {
+	arguments := map[string]any{}
+	if !p.peek(tokenPunct, "(") {
+		return arguments, nil
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	for !p.peek(tokenPunct, ")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenPunct, ":"); err != nil {
+			return nil, err
+		}
+		value, err := p.value(false)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := arguments[name]; ok {
+			return nil, fmt.Errorf("argument %q is given more than once", name)
+		}
+		arguments[name] = value
+	}
+	return arguments, p.next()
+}
+
+func (p *parser) directives() ([]directive, error) {
+	var directives []directive
+	for p.peek(tokenPunct, "@") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		arguments, err := p.arguments()
+		if err != nil {
+			return nil, err
+		}
+		directives = append(directives, directive{name: name, arguments: arguments})
+	}
+	return directives, nil
+}
+
+// value parses an input value. Enum values are returned as strings.
+func (p *parser) value(constant bool) (any, error) {
+	tok := p.tok
+	switch {
+	case tok.kind == tokenPunct && tok.value == "$" && !constant:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		return variableRef(name), err
+	case tok.kind == tokenPunct && tok.value == "[":
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		list := make([]any, 0)
+		for !p.peek(tokenPunct, "]") {
+			item, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, p.next()
+	case tok.kind == tokenPunct && tok.value == "{":
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		object := map[string]any{}
+		for !p.peek(tokenPunct, "}") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(tokenPunct, ":"); err != nil {
+				return nil, err
+			}
+			if object[name], err = p.value(constant); err != nil {
+				return nil, err
+			}
+		}
+		return object, p.next()
+	case tok.kind == tokenInt:
+		value, err := strconv.ParseInt(tok.value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid int %q at offset %d", tok.value, tok.pos)
+		}
+		return value, p.next()
+	case tok.kind == tokenFloat:
+		value, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float %q at offset %d", tok.value, tok.pos)
+		}
+		return value, p.next()
+	case tok.kind == tokenString:
+		return tok.value, p.next()
+	case tok.kind == tokenName:
+		var value any
+		switch tok.value {
+		case "true":
+			value = true
+		case "false":
+			value = false
+		case "null":
+			value = nil
+		default:
+			value = tok.value
+		}
+		return value, p.next()
+	}
+	return nil, p.unexpected()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokenName {
+		return "", p.unexpected()
+	}
+	name := p.tok.value
+	return name, p.next()
+}
+
+func (p *parser) peek(kind tokenKind, value string) bool {
+	return p.tok.kind == kind && p.tok.value == value
+}
+
+func (p *parser) expect(kind tokenKind, value string) error {
+	if !p.peek(kind, value) {
+		return p.unexpected()
+	}
+	return p.next()
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokenEOF {
+		return fmt.Errorf("unexpected end of document")
+	}
+	return fmt.Errorf("unexpected %q at offset %d", p.tok.value, p.tok.pos)
+}
+
+// next advances to the next token, skipping whitespace, commas and comments.
+func (p *parser) next() error {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' && p.src[p.pos] != '\r' {
+				p.pos++
+			}
+			continue
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != ',' {
+			break
+		}
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokenEOF, pos: start}
+		return nil
+	}
+	c := p.src[p.pos]
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.pos += 3
+		p.tok = token{kind: tokenPunct, value: "...", pos: start}
+	case strings.IndexByte("!$()[]{}:=@|&", c) >= 0:
+		p.pos++
+		p.tok = token{kind: tokenPunct, value: string(c), pos: start}
+	case c == '_' || isLetter(c):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || isLetter(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok = token{kind: tokenName, value: p.src[start:p.pos], pos: start}
+	case c == '-' || isDigit(c):
+		return p.number()
+	case c == '"':
+		return p.string()
+	default:
+		return fmt.Errorf("unexpected character %q at offset %d", c, start)
+	}
+	return nil
+}
+
+func (p *parser) number() error {
+	start := p.pos
+	kind := tokenInt
+	if p.src[p.pos] == '-' {
+		p.pos++
+	}
+	digits := func() {
+		for p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+			p.pos++
+		}
+	}
+	digits()
+	if p.pos < len(p.src) && p.src[p.pos] == '.' {
+		kind = tokenFloat
+		p.pos++
+		digits()
+	}
+	if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+		kind = tokenFloat
+		p.pos++
+		if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+			p.pos++
+		}
+		digits()
+	}
+	p.tok = token{kind: kind, value: p.src[start:p.pos], pos: start}
+	return nil
+}
+
+// string reads a quoted string. Block strings are not supported.
+func (p *parser) string() error {
+	start := p.pos
+	if strings.HasPrefix(p.src[p.pos:], `"""`) {
+		return fmt.Errorf("block strings are not supported (offset %d)", start)
+	}
+	p.pos++
+	var value strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			p.tok = token{kind: tokenString, value: value.String(), pos: start}
+			return nil
+		case c == '\n' || c == '\r':
+			return fmt.Errorf("unterminated string at offset %d", start)
+		case c == '\\':
+			if p.pos+1 >= len(p.src) {
+				return fmt.Errorf("unterminated string at offset %d", start)
+			}
+			escape := p.src[p.pos+1]
+			p.pos += 2
+			switch escape {
+			case '"', '\\', '/':
+				value.WriteByte(escape)
+			case 'b':
+				value.WriteByte('\b')
+			case 'f':
+				value.WriteByte('\f')
+			case 'n':
+				value.WriteByte('\n')
+			case 'r':
+				value.WriteByte('\r')
+			case 't':
+				value.WriteByte('\t')
+			case 'u':
+				if p.pos+4 > len(p.src) {
+					return fmt.Errorf("invalid unicode escape at offset %d", p.pos)
+				}
+				code, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 32)
+				if err != nil {
+					return fmt.Errorf("invalid unicode escape at offset %d", p.pos)
+				}
+				value.WriteRune(rune(code))
+				p.pos += 4
+			default:
+				return fmt.Errorf("invalid escape \\%c at offset %d", escape, p.pos-2)
+			}
+		default:
+			value.WriteByte(c)
+			p.pos++
+		}
+	}
+	return fmt.Errorf("unterminated string at offset %d", start)
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,227 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"a4-tasklists/server/internal/fleet"
+	"a4-tasklists/server/internal/storage"
+)
+
+// Admin routes act on any user's data and carry no user authentication of
+// their own. They must only be mounted behind a network restriction such as
+// the ipfilter middleware.
+
+// RegisterAdminRoutes adds the operator endpoints under /admin/.
+func (s *Server) RegisterAdminRoutes(mux *http.ServeMux) {
+	mux.Handle("/admin", http.RedirectHandler("/admin/", http.StatusMovedPermanently))
+	mux.HandleFunc("/admin/{$}", s.handleAdminUI)
+	mux.HandleFunc("/admin/users", s.handleAdminUsers)
+	mux.HandleFunc("/admin/users/{id}", s.handleAdminUser)
+	mux.HandleFunc("/admin/users/{id}/compact", s.handleAdminCompact)
+	mux.HandleFunc("/admin/jobs", s.handleAdminJobs)
+	mux.HandleFunc("/admin/backups", s.handleAdminBackups)
+	mux.HandleFunc("/admin/backups/{id}/restore", s.handleAdminRestoreBackup)
+	mux.HandleFunc("/admin/clients", s.handleAdminClients)
+	mux.HandleFunc("/admin/clients/hint", s.handleAdminClientHint)
+	mux.HandleFunc("/admin/fleet", s.handleAdminFleet)
+	mux.HandleFunc("/admin/fleet/clients", s.handleAdminFleetClients)
+	mux.HandleFunc("/admin/oauth/apps", s.handleAdminOAuthApps)
+	mux.HandleFunc("/admin/oauth/apps/{id}", s.handleAdminOAuthApp)
+	mux.HandleFunc("/admin/reload", s.handleAdminReload)
+	mux.HandleFunc("/admin/settings", s.handleAdminSettings)
+	mux.HandleFunc("/admin/settings/changes", s.handleAdminSettingChanges)
+	mux.HandleFunc("/admin/settings/{key}", s.handleAdminSetting)
+	mux.HandleFunc("/admin/invites", s.handleAdminInvites)
+	mux.HandleFunc("/admin/invites/{id}", s.handleAdminInvite)
+	mux.HandleFunc("/admin/emails", s.handleAdminEmails)
+	mux.HandleFunc("/admin/emails/{name}", s.handleAdminEmailPreview)
+	mux.HandleFunc("/admin/quarantine", s.handleAdminQuarantine)
+	mux.HandleFunc("/admin/quarantine/{seq}", s.handleAdminQuarantinedOp)
+	mux.HandleFunc("/admin/quarantine/{seq}/restore", s.handleAdminRestoreQuarantinedOp)
+}
+
+// ReloadResult reports the settings a configuration reload changed.
+type ReloadResult struct {
+	// Applied lists changed settings that took effect.
+	Applied []string `json:"applied"`
+	// RestartRequired lists changed settings that only take effect after a
+	// restart.
+	RestartRequired []string `json:"restartRequired"`
+}
+
+// handleAdminReload re-reads the reloadable settings, like SIGHUP does.
+func (s *Server) handleAdminReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+	if s.reload == nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "configuration reload is not available"})
+		return
+	}
+	result, err := s.reload()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleAdminClients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "userId is required"})
+		return
+	}
+	clients, err := s.store.ListClients(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{"clients": clients})
+}
+
+// handleAdminFleet summarizes all clients and the sync outcomes since start.
+func (s *Server) handleAdminFleet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	clients, err := s.store.ListFleetClients(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, fleet.Summarize(clients, s.fleet.Activity(), time.Now()))
+}
+
+// handleAdminFleetClients pages through the clients of all users (or of
+// ?userId=) sorted by ?order= (id, lastSeen or lag). ?after= takes the next
+// cursor of the previous page.
+func (s *Server) handleAdminFleetClients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	values := r.URL.Query()
+	query := storage.ClientQuery{UserID: values.Get("userId"), Order: values.Get("order"), After: values.Get("after")}
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > storage.MaxClientPageSize {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("limit must be between 1 and %d", storage.MaxClientPageSize)})
+			return
+		}
+		query.Limit = limit
+	}
+	page, err := s.store.ListClientsPage(r.Context(), query)
+	if errors.Is(err, storage.ErrInvalidCursor) || errors.Is(err, storage.ErrUnknownClientOrder) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// handleAdminClientHint shows a client's pending hint on GET ?userId=
+// &clientId= and sets it on POST {userId, clientId, hint}, unless If-Match
+// names another version of the pending hint.
+func (s *Server) handleAdminClientHint(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		UserID   string `json:"userId"`
+		ClientID string `json:"clientId"`
+		Hint     string `json:"hint"`
+	}
+	switch r.Method {
+	case http.MethodGet:
+		payload.UserID, payload.ClientID = r.URL.Query().Get("userId"), r.URL.Query().Get("clientId")
+	case http.MethodPost:
+		if err := decodeJSON(r, &payload); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	default:
+		methodNotAllowed(w)
+		return
+	}
+	if payload.UserID == "" || payload.ClientID == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "userId and clientId are required"})
+		return
+	}
+	if r.Method == http.MethodGet {
+		client, err := s.findClient(r, payload.UserID, payload.ClientID)
+		if errors.Is(err, storage.ErrClientNotFound) {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		payload.Hint = client.Hint
+		setETag(w, resourceVersion(payload))
+		writeJSON(w, http.StatusOK, payload)
+		return
+	}
+	switch payload.Hint {
+	case "", storage.ClientHintResync, storage.ClientHintUpgrade:
+	default:
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "hint must be resync, upgrade, or empty"})
+		return
+	}
+	s.adminWrites.Lock()
+	defer s.adminWrites.Unlock()
+	if r.Header.Get("If-Match") != "" {
+		client, err := s.findClient(r, payload.UserID, payload.ClientID)
+		if errors.Is(err, storage.ErrClientNotFound) {
+			writeJSON(w, http.StatusPreconditionFailed, errorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		current := payload
+		current.Hint = client.Hint
+		if version := resourceVersion(current); !ifMatchSatisfied(r, version) {
+			writePreconditionFailed(w, version)
+			return
+		}
+	}
+	err := s.store.SetClientHint(r.Context(), payload.UserID, payload.ClientID, payload.Hint)
+	if errors.Is(err, storage.ErrClientNotFound) {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("admin client hint user=%s client=%s hint=%q", payload.UserID, payload.ClientID, payload.Hint)
+	setETag(w, resourceVersion(payload))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) findClient(r *http.Request, userID string, clientID string) (storage.Client, error) {
+	clients, err := s.store.ListClients(r.Context(), userID)
+	if err != nil {
+		return storage.Client{}, err
+	}
+	for _, client := range clients {
+		if client.ClientID == clientID {
+			return client, nil
+		}
+	}
+	return storage.Client{}, storage.ErrClientNotFound
+}
@@ -0,0 +1,204 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	"a4-tasklists/server/internal/fleet"
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestAdminClientHintIsDeliveredOnceOnPull(t *testing.T) {
+	server := NewServer(newTestStore(t))
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	server.RegisterAdminRoutes(mux)
+	bootstrap := fetchBootstrap(t, mux)
+	pullPath := "/sync/pull?clientId=client-1&datasetGenerationKey=" + url.QueryEscape(bootstrap.DatasetGenerationKey)
+	pullHint := func() string {
+		t.Helper()
+		resp := doRequest(t, mux, http.MethodGet, pullPath, nil)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("pull status: got %d", resp.Code)
+		}
+		var payload struct {
+			ClientHint string `json:"clientHint"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode pull: %v", err)
+		}
+		return payload.ClientHint
+	}
+
+	hintBody := []byte(`{"userId":"user-1","clientId":"client-1","hint":"resync"}`)
+	if resp := doRequest(t, mux, http.MethodPost, "/admin/clients/hint", hintBody); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown client, got %d", resp.Code)
+	}
+	if hint := pullHint(); hint != "" {
+		t.Fatalf("expected no hint before one is set, got %q", hint)
+	}
+	if resp := doRequest(t, mux, http.MethodPost, "/admin/clients/hint", hintBody); resp.Code != http.StatusNoContent {
+		t.Fatalf("set hint status: got %d", resp.Code)
+	}
+
+	resp := doRequest(t, mux, http.MethodGet, "/admin/clients?userId=user-1", nil)
+	var listed struct {
+		Clients []struct {
+			ClientID string `json:"clientId"`
+			Hint     string `json:"hint"`
+		} `json:"clients"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+		t.Fatalf("decode clients: %v", err)
+	}
+	if len(listed.Clients) != 1 || listed.Clients[0].Hint != "resync" {
+		t.Fatalf("expected pending hint in client list, got %+v", listed.Clients)
+	}
+
+	resp = doRequest(t, mux, http.MethodGet, "/admin/clients/hint?userId=user-1&clientId=client-1", nil)
+	pending := resp.Header().Get("ETag")
+	var shown struct {
+		Hint string `json:"hint"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&shown); err != nil || resp.Code != http.StatusOK || pending == "" || shown.Hint != "resync" {
+		t.Fatalf("expected the pending hint with an ETag, got %d %q %+v (%v)", resp.Code, pending, shown, err)
+	}
+	clearBody := []byte(`{"userId":"user-1","clientId":"client-1","hint":""}`)
+	if resp := doRequestWithHeaders(t, mux, http.MethodPost, "/admin/clients/hint", clearBody, map[string]string{"If-Match": `"other"`}); resp.Code != http.StatusPreconditionFailed || resp.Header().Get("ETag") != pending {
+		t.Fatalf("expected a hint change against another version to be refused, got %d", resp.Code)
+	}
+
+	if hint := pullHint(); hint != "resync" {
+		t.Fatalf("expected resync hint, got %q", hint)
+	}
+	// The pull took the hint the operator saw, so clearing it is stale.
+	if resp := doRequestWithHeaders(t, mux, http.MethodPost, "/admin/clients/hint", clearBody, map[string]string{"If-Match": pending}); resp.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected a stale hint change to be refused, got %d", resp.Code)
+	}
+	if hint := pullHint(); hint != "" {
+		t.Fatalf("expected hint to be delivered once, got %q", hint)
+	}
+
+	bad := []byte(`{"userId":"user-1","clientId":"client-1","hint":"explode"}`)
+	if resp := doRequest(t, mux, http.MethodPost, "/admin/clients/hint", bad); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown hint, got %d", resp.Code)
+	}
+}
+
+func TestAdminReload(t *testing.T) {
+	mux := http.NewServeMux()
+	NewServer(newTestStore(t)).RegisterAdminRoutes(mux)
+	if resp := doRequest(t, mux, http.MethodPost, "/admin/reload", nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without a reload hook, got %d", resp.Code)
+	}
+
+	var next ReloadResult
+	var failure error
+	server := NewServerWithConfig(newTestStore(t), Config{Reload: func() (ReloadResult, error) { return next, failure }})
+	mux = http.NewServeMux()
+	server.RegisterAdminRoutes(mux)
+	next = ReloadResult{Applied: []string{"SERVER_FEATURES"}, RestartRequired: []string{"PORT"}}
+	resp := doRequest(t, mux, http.MethodPost, "/admin/reload", nil)
+	var result ReloadResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("decode reload: %v", err)
+	}
+	if resp.Code != http.StatusOK || len(result.Applied) != 1 || len(result.RestartRequired) != 1 {
+		t.Fatalf("unexpected reload response %d %+v", resp.Code, result)
+	}
+	failure = errors.New("SERVER_FEATURES: unknown feature")
+	if resp := doRequest(t, mux, http.MethodPost, "/admin/reload", nil); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected a failed reload to be reported, got %d", resp.Code)
+	}
+}
+
+func TestAdminFleetSummarizesClientsAndActivity(t *testing.T) {
+	server := NewServer(newTestStore(t))
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	server.RegisterAdminRoutes(mux)
+	bootstrap := fetchBootstrap(t, mux)
+	push, _ := json.Marshal(map[string]any{"clientId": "laptop", "datasetGenerationKey": bootstrap.DatasetGenerationKey, "ops": []map[string]any{
+		{"scope": "list", "resourceId": "list-1", "actor": "actor-1", "clock": 1, "payload": map[string]any{"type": "insert", "itemId": "item-1"}},
+	}})
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/push", push); resp.Code != http.StatusOK {
+		t.Fatalf("push status: got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodGet, "/sync/pull?clientId=phone&datasetGenerationKey=dataset-unknown", nil); resp.Code != http.StatusConflict {
+		t.Fatalf("expected a conflict for an unknown key, got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/heartbeat", []byte(`{"clientId":"phone","appVersion":"1.4.0"}`)); resp.Code != http.StatusOK {
+		t.Fatalf("heartbeat status: got %d", resp.Code)
+	}
+
+	resp := doRequest(t, mux, http.MethodGet, "/admin/fleet", nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("fleet status: got %d", resp.Code)
+	}
+	var report fleet.Report
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("decode fleet: %v", err)
+	}
+	if report.Clients != 2 || report.Users != 1 {
+		t.Fatalf("unexpected totals: %+v", report)
+	}
+	if len(report.AppVersions) != 2 || report.AppVersions[0] != (fleet.VersionCount{Version: "1.4.0", Clients: 1}) {
+		t.Fatalf("unexpected app versions: %+v", report.AppVersions)
+	}
+	if len(report.Laggards) != 1 || report.Laggards[0].ClientID != "phone" || report.Laggards[0].Lag != 1 {
+		t.Fatalf("expected the phone to trail by one op, got %+v", report.Laggards)
+	}
+	activity := report.Activity
+	if activity.Pushes != 1 || activity.Conflicts != 1 || activity.DivergentConflicts != 1 || activity.ProtocolVersions["1"] != 4 {
+		t.Fatalf("unexpected activity: %+v", activity)
+	}
+}
+
+func TestAdminFleetClientsPagesByLag(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	seq, err := store.InsertOps(ctx, "user-1", []storage.Op{{Scope: "list", Resource: "list-1", Actor: "actor-1", Clock: 1, Payload: json.RawMessage(`{}`)}})
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	for clientID, cursor := range map[string]int64{"laptop": seq, "phone": 0, "tablet": seq} {
+		if err := store.UpdateClientCursor(ctx, "user-1", clientID, cursor); err != nil {
+			t.Fatalf("update cursor: %v", err)
+		}
+	}
+	mux := http.NewServeMux()
+	NewServer(store).RegisterAdminRoutes(mux)
+
+	var clientIDs []string
+	path := "/admin/fleet/clients?order=lag&limit=2"
+	for path != "" {
+		resp := doRequest(t, mux, http.MethodGet, path, nil)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("fleet clients status: got %d", resp.Code)
+		}
+		var page storage.ClientPage
+		if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			t.Fatalf("decode page: %v", err)
+		}
+		for _, client := range page.Clients {
+			clientIDs = append(clientIDs, client.ClientID)
+		}
+		path = ""
+		if page.Next != "" {
+			path = "/admin/fleet/clients?order=lag&limit=2&after=" + url.QueryEscape(page.Next)
+		}
+	}
+	if !slices.Equal(clientIDs, []string{"phone", "laptop", "tablet"}) {
+		t.Fatalf("unexpected clients: %v", clientIDs)
+	}
+	for _, path := range []string{"/admin/fleet/clients?order=size", "/admin/fleet/clients?limit=0", "/admin/fleet/clients?after=nope"} {
+		if resp := doRequest(t, mux, http.MethodGet, path, nil); resp.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", path, resp.Code)
+		}
+	}
+}
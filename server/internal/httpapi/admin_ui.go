@@ -0,0 +1,153 @@
+package httpapi
+
+import (
+	_ "embed"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+
+	"a4-tasklists/server/internal/compaction"
+	"a4-tasklists/server/internal/storage"
+)
+
+// The admin UI is a single page under /admin/, separate from the end-user
+// app. It only calls the admin API, so it is reachable exactly where that is.
+
+//go:embed admin.html
+var adminPage []byte
+
+func (s *Server) handleAdminUI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// The compaction and reset buttons must not be clickable from a frame.
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	_, _ = w.Write(adminPage)
+}
+
+func (s *Server) handleAdminUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	users, err := s.store.ListUsers(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	var thresholds compaction.Thresholds
+	if s.compaction != nil {
+		thresholds = s.compaction.Thresholds()
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{
+		"users": users,
+		"thresholds": jsonResponse{
+			"maxOps":     thresholds.MaxOps,
+			"maxOpBytes": thresholds.MaxOpBytes,
+		},
+	})
+}
+
+// findUser returns the summary of a user with a dataset. Looking users up
+// this way keeps admin reads from creating users that do not exist.
+func (s *Server) findUser(r *http.Request, userID string) (storage.UserSummary, bool, error) {
+	users, err := s.store.ListUsers(r.Context())
+	if err != nil {
+		return storage.UserSummary{}, false, err
+	}
+	i := slices.IndexFunc(users, func(user storage.UserSummary) bool { return user.UserID == userID })
+	if i < 0 {
+		return storage.UserSummary{}, false, nil
+	}
+	return users[i], true, nil
+}
+
+func (s *Server) handleAdminUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	user, ok, err := s.findUser(r, r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "user not found"})
+		return
+	}
+	lineage, err := s.store.GetGenerationLineage(r.Context(), user.UserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	clients, err := s.store.ListClients(r.Context(), user.UserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		storage.UserSummary
+		Lineage []string         `json:"lineage"`
+		Clients []storage.Client `json:"clients"`
+	}{user, lineage, clients})
+}
+
+// handleAdminCompact folds a user's op log into a new generation right away,
+// regardless of the thresholds. With ?dryRun=true it only previews the run.
+func (s *Server) handleAdminCompact(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+	if s.compaction == nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "compaction is not available"})
+		return
+	}
+	user, ok, err := s.findUser(r, r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "user not found"})
+		return
+	}
+	if r.URL.Query().Get("dryRun") == "true" {
+		preview, err := s.compaction.Preview(r.Context(), user.UserID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, preview)
+		return
+	}
+	result, err := s.compaction.Compact(r.Context(), user.UserID)
+	if errors.Is(err, compaction.ErrRunning) || errors.Is(err, storage.ErrDatasetGenerationChanged) {
+		writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("admin compaction user=%s generation=%s->%s", user.UserID, result.PreviousDatasetGenerationKey, result.DatasetGenerationKey)
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleAdminJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	runs := s.compaction.Runs()
+	if runs == nil {
+		runs = []compaction.Run{}
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{"compactions": runs})
+}
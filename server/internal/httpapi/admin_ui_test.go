@@ -0,0 +1,114 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"a4-tasklists/server/internal/compaction"
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestAdminUIServesPageAndUserEndpoints(t *testing.T) {
+	store := newTestStore(t)
+	compactor := compaction.New(store, compaction.Thresholds{MaxOps: 100})
+	server := NewServerWithConfig(store, Config{Compaction: compactor})
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	server.RegisterAdminRoutes(mux)
+	bootstrap := fetchBootstrap(t, mux)
+	push, _ := json.Marshal(map[string]any{"clientId": "laptop", "datasetGenerationKey": bootstrap.DatasetGenerationKey, "ops": []map[string]any{
+		{"scope": "list", "resourceId": "list-1", "actor": "actor-1", "clock": 1, "payload": map[string]any{"type": "insert", "itemId": "item-1"}},
+	}})
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/push", push); resp.Code != http.StatusOK {
+		t.Fatalf("push status: got %d", resp.Code)
+	}
+	// The push checked the thresholds in the background.
+	compactor.Wait()
+
+	resp := doRequest(t, mux, http.MethodGet, "/admin/", nil)
+	if resp.Code != http.StatusOK || !strings.HasPrefix(resp.Header().Get("Content-Type"), "text/html") || !strings.Contains(resp.Body.String(), "Tasklists admin") {
+		t.Fatalf("unexpected admin page: %d %q", resp.Code, resp.Header().Get("Content-Type"))
+	}
+	if resp := doRequest(t, mux, http.MethodGet, "/admin", nil); resp.Code != http.StatusMovedPermanently || resp.Header().Get("Location") != "/admin/" {
+		t.Fatalf("expected a redirect to /admin/, got %d %q", resp.Code, resp.Header().Get("Location"))
+	}
+
+	resp = doRequest(t, mux, http.MethodGet, "/admin/users", nil)
+	var listed struct {
+		Users      []storage.UserSummary `json:"users"`
+		Thresholds struct {
+			MaxOps int64 `json:"maxOps"`
+		} `json:"thresholds"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+		t.Fatalf("decode users: %v", err)
+	}
+	if len(listed.Users) != 1 || listed.Users[0].UserID != "user-1" || listed.Users[0].OpCount != 1 || listed.Thresholds.MaxOps != 100 {
+		t.Fatalf("unexpected users: %+v", listed)
+	}
+
+	resp = doRequest(t, mux, http.MethodGet, "/admin/users/user-1", nil)
+	var detail struct {
+		DatasetGenerationKey string           `json:"datasetGenerationKey"`
+		Lineage              []string         `json:"lineage"`
+		Clients              []storage.Client `json:"clients"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&detail); err != nil {
+		t.Fatalf("decode user: %v", err)
+	}
+	if detail.DatasetGenerationKey != bootstrap.DatasetGenerationKey || len(detail.Lineage) != 1 || len(detail.Clients) != 1 {
+		t.Fatalf("unexpected user detail: %+v", detail)
+	}
+	if resp := doRequest(t, mux, http.MethodGet, "/admin/users/nobody", nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown user, got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodPost, "/admin/users/nobody/compact", nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 when compacting an unknown user, got %d", resp.Code)
+	}
+
+	resp = doRequest(t, mux, http.MethodPost, "/admin/users/user-1/compact?dryRun=true", nil)
+	var preview compaction.Preview
+	if err := json.NewDecoder(resp.Body).Decode(&preview); err != nil || resp.Code != http.StatusOK {
+		t.Fatalf("preview: %d %v %s", resp.Code, err, resp.Body.String())
+	}
+	if preview.FoldedOps != 1 || preview.DatasetGenerationKey != bootstrap.DatasetGenerationKey || preview.ResyncClients == nil {
+		t.Fatalf("unexpected preview: %+v", preview)
+	}
+
+	resp = doRequest(t, mux, http.MethodPost, "/admin/users/user-1/compact", nil)
+	var result compaction.Result
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || resp.Code != http.StatusOK {
+		t.Fatalf("compact: %d %v %s", resp.Code, err, resp.Body.String())
+	}
+	if !result.Compacted || result.FoldedOps != 1 || result.PreviousDatasetGenerationKey != bootstrap.DatasetGenerationKey {
+		t.Fatalf("unexpected compaction: %+v", result)
+	}
+	resp = doRequest(t, mux, http.MethodGet, "/admin/jobs", nil)
+	var jobs struct {
+		Compactions []compaction.Run `json:"compactions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jobs); err != nil {
+		t.Fatalf("decode jobs: %v", err)
+	}
+	if len(jobs.Compactions) != 1 || jobs.Compactions[0].Trigger != compaction.TriggerManual || jobs.Compactions[0].UserID != "user-1" {
+		t.Fatalf("unexpected jobs: %+v", jobs)
+	}
+}
+
+func TestAdminCompactNeedsACompactor(t *testing.T) {
+	server := NewServer(newTestStore(t))
+	mux := http.NewServeMux()
+	server.RegisterAdminRoutes(mux)
+	if resp := doRequest(t, mux, http.MethodPost, "/admin/users/user-1/compact", nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without a compactor, got %d", resp.Code)
+	}
+	resp := doRequest(t, mux, http.MethodGet, "/admin/jobs", nil)
+	var jobs struct {
+		Compactions []compaction.Run `json:"compactions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jobs); err != nil || jobs.Compactions == nil || len(jobs.Compactions) != 0 {
+		t.Fatalf("expected an empty run list, got %+v, %v", jobs, err)
+	}
+}
@@ -0,0 +1,212 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/storage"
+)
+
+// Archived lists stay in the dataset but are left out of bootstrap, pull, and
+// snapshot responses for clients speaking archivedListsProtocolVersion or
+// later, unless they ask for them with ?archived=include. Older clients keep
+// receiving everything. Responses name the archived lists (archivedLists) so
+// clients can drop local copies. Their cursors move past the ops left out, so
+// restoring a list asks every client to resync.
+
+// handleArchiveList archives or restores a list (PUT {archived}).
+func (s *Server) handleArchiveList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	var payload struct {
+		Archived bool `json:"archived"`
+	}
+	if err := decodeJSON(r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	listID := r.PathValue("id")
+	var restored bool
+	if payload.Archived {
+		state, err := s.loadState(r.Context(), userID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if _, ok := state.FindList(listID); !ok {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: materialize.ErrListNotFound.Error()})
+			return
+		}
+	} else {
+		archived, err := s.store.ListArchivedLists(r.Context(), userID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		restored = slices.Contains(archived, listID)
+	}
+	if err := s.store.SetListArchived(r.Context(), userID, listID, payload.Archived); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if restored {
+		if err := s.resyncClients(r.Context(), userID); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resyncClients asks every client of the user to bootstrap with its next
+// pull.
+func (s *Server) resyncClients(ctx context.Context, userID string) error {
+	clients, err := s.store.ListClients(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, client := range clients {
+		if err := s.store.SetClientHint(ctx, userID, client.ClientID, storage.ClientHintResync); err != nil && !errors.Is(err, storage.ErrClientNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleArchivedLists lists the user's archived lists that still exist.
+func (s *Server) handleArchivedLists(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	listIDs, err := s.store.ListArchivedLists(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	lists := make([]listSummary, 0, len(listIDs))
+	if len(listIDs) > 0 {
+		state, err := s.loadState(r.Context(), userID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		for _, listID := range listIDs {
+			if list, ok := state.FindList(listID); ok {
+				lists = append(lists, listSummary{ListID: list.ID, Title: list.Title, ItemCount: len(list.Items)})
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{"lists": lists})
+}
+
+// archivedView holds the archived list ids for a sync response. Hide is false
+// when the client opted in to archived lists.
+type archivedView struct {
+	ListIDs []string
+	Hide    bool
+}
+
+// loadArchivedView hides archived lists unless the request opts in.
+func (s *Server) loadArchivedView(r *http.Request, userID string) (archivedView, error) {
+	return s.archivedView(r, userID, true)
+}
+
+// loadSyncArchivedView hides archived lists from sync responses only for
+// clients that negotiated archivedListsProtocolVersion.
+func (s *Server) loadSyncArchivedView(r *http.Request, userID string) (archivedView, error) {
+	return s.archivedView(r, userID, syncProtocolVersion(r) >= archivedListsProtocolVersion)
+}
+
+func (s *Server) archivedView(r *http.Request, userID string, hideByDefault bool) (archivedView, error) {
+	listIDs, err := s.store.ListArchivedLists(r.Context(), userID)
+	if err != nil {
+		return archivedView{}, err
+	}
+	hide := hideByDefault && len(listIDs) > 0 && r.URL.Query().Get("archived") != "include"
+	return archivedView{ListIDs: listIDs, Hide: hide}, nil
+}
+
+// annotate adds the archived list ids to a sync response.
+func (v archivedView) annotate(payload jsonResponse) {
+	if len(v.ListIDs) > 0 {
+		payload["archivedLists"] = v.ListIDs
+	}
+}
+
+// filterOps drops ops that belong to archived lists: their list and comment
+// scope ops and their registry entries.
+func (v archivedView) filterOps(ops []storage.Op) []storage.Op {
+	if !v.Hide {
+		return ops
+	}
+	return slices.DeleteFunc(ops, v.hides)
+}
+
+// hides reports whether op belongs to a hidden archived list.
+func (v archivedView) hides(op storage.Op) bool {
+	if !v.Hide {
+		return false
+	}
+	return slices.Contains(v.ListIDs, materialize.OpListID(op))
+}
+
+// filterSnapshot removes archived lists from a snapshot blob and leaves every
+// other field untouched.
+func (v archivedView) filterSnapshot(blob string) (string, error) {
+	if !v.Hide || blob == "" {
+		return blob, nil
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(blob), &doc); err != nil {
+		return "", fmt.Errorf("decode snapshot: %w", err)
+	}
+	var data map[string]json.RawMessage
+	if raw, ok := doc["data"]; !ok || json.Unmarshal(raw, &data) != nil {
+		return blob, nil
+	}
+	var lists []json.RawMessage
+	if raw, ok := data["lists"]; !ok || json.Unmarshal(raw, &lists) != nil {
+		return blob, nil
+	}
+	kept := make([]json.RawMessage, 0, len(lists))
+	for _, list := range lists {
+		var header struct {
+			ListID string `json:"listId"`
+		}
+		if json.Unmarshal(list, &header) == nil && slices.Contains(v.ListIDs, header.ListID) {
+			continue
+		}
+		kept = append(kept, list)
+	}
+	if len(kept) == len(lists) {
+		return blob, nil
+	}
+	var err error
+	if data["lists"], err = json.Marshal(kept); err != nil {
+		return "", err
+	}
+	if doc["data"], err = json.Marshal(data); err != nil {
+		return "", err
+	}
+	filtered, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	return string(filtered), nil
+}
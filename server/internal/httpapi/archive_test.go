@@ -0,0 +1,180 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+// archivedListsHeaders announces the protocol version that hides archived
+// lists.
+var archivedListsHeaders = map[string]string{SyncProtocolVersionHeader: strconv.Itoa(archivedListsProtocolVersion)}
+
+func TestArchivedListsAreLeftOutOfSyncUnlessIncluded(t *testing.T) {
+	store := newTestStore(t)
+	mux := http.NewServeMux()
+	NewServer(store).RegisterRoutes(mux)
+	ctx := context.Background()
+	if err := store.ReplaceSnapshot(ctx, "user-1", storage.Snapshot{
+		DatasetGenerationKey: "gen-1",
+		Blob:                 `{"schema":"net.aggregat4.tasklist.snapshot@v1","appVersion":"1.2","data":{"lists":[{"listId":"old","title":"2019 trip","items":[]},{"listId":"live","title":"Groceries","items":[]}]}}`,
+	}); err != nil {
+		t.Fatalf("replace snapshot: %v", err)
+	}
+	body, _ := json.Marshal(map[string]any{
+		"clientId":             "client-a",
+		"datasetGenerationKey": "gen-1",
+		"ops": []map[string]any{
+			{"scope": "list", "resourceId": "old", "actor": "actor-a", "clock": 1, "payload": map[string]any{"type": "insert", "itemId": "item-1", "payload": map[string]any{"data": map[string]any{"text": "tickets"}}}},
+			{"scope": "list", "resourceId": "live", "actor": "actor-a", "clock": 2, "payload": map[string]any{"type": "insert", "itemId": "item-2", "payload": map[string]any{"data": map[string]any{"text": "milk"}}}},
+			{"scope": "registry", "resourceId": "registry", "actor": "actor-a", "clock": 3, "payload": map[string]any{"type": "renameList", "listId": "old", "payload": map[string]any{"title": "Trip"}}},
+		},
+	})
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/push", body); resp.Code != http.StatusOK {
+		t.Fatalf("push status: got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodPut, "/lists/old/archive", []byte(`{"archived":true}`)); resp.Code != http.StatusNoContent {
+		t.Fatalf("archive status: got %d", resp.Code)
+	}
+
+	type syncResponse struct {
+		Snapshot string `json:"snapshot"`
+		Ops      []struct {
+			ResourceID string `json:"resourceId"`
+		} `json:"ops"`
+		ArchivedLists []string `json:"archivedLists"`
+	}
+	fetchAs := func(path string, headers map[string]string) syncResponse {
+		t.Helper()
+		resp := doRequestWithHeaders(t, mux, http.MethodGet, path, nil, headers)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("%s status: got %d", path, resp.Code)
+		}
+		var payload syncResponse
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode %s: %v", path, err)
+		}
+		return payload
+	}
+	fetch := func(path string) syncResponse {
+		t.Helper()
+		return fetchAs(path, archivedListsHeaders)
+	}
+	bootstrap := fetch("/sync/bootstrap")
+	if strings.Contains(bootstrap.Snapshot, `"old"`) || !strings.Contains(bootstrap.Snapshot, `"appVersion":"1.2"`) {
+		t.Fatalf("expected the archived list to be removed from the snapshot only, got %s", bootstrap.Snapshot)
+	}
+	if len(bootstrap.Ops) != 1 || bootstrap.Ops[0].ResourceID != "live" {
+		t.Fatalf("expected only the live list's op, got %+v", bootstrap.Ops)
+	}
+	if !slices.Equal(bootstrap.ArchivedLists, []string{"old"}) {
+		t.Fatalf("expected archivedLists, got %v", bootstrap.ArchivedLists)
+	}
+	if pulled := fetch("/sync/pull?clientId=client-b&datasetGenerationKey=gen-1"); len(pulled.Ops) != 1 {
+		t.Fatalf("expected pull to skip archived ops, got %+v", pulled.Ops)
+	}
+	included := fetch("/sync/bootstrap?archived=include")
+	if !strings.Contains(included.Snapshot, `"old"`) || len(included.Ops) != 3 {
+		t.Fatalf("expected archived data when included, got %+v", included)
+	}
+	// Version 1 clients predate archiving and keep receiving everything.
+	legacy := fetchAs("/sync/bootstrap", nil)
+	if !strings.Contains(legacy.Snapshot, `"old"`) || len(legacy.Ops) != 3 || !slices.Equal(legacy.ArchivedLists, []string{"old"}) {
+		t.Fatalf("expected a version 1 client to receive archived data, got %+v", legacy)
+	}
+
+	resp := doRequest(t, mux, http.MethodGet, "/lists/archived", nil)
+	var listed struct {
+		Lists []listSummary `json:"lists"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+		t.Fatalf("decode archived lists: %v", err)
+	}
+	if len(listed.Lists) != 1 || listed.Lists[0] != (listSummary{ListID: "old", Title: "Trip", ItemCount: 1}) {
+		t.Fatalf("unexpected archived lists: %+v", listed.Lists)
+	}
+	if resp := doRequest(t, mux, http.MethodPut, "/lists/old/archive", []byte(`{"archived":false}`)); resp.Code != http.StatusNoContent {
+		t.Fatalf("restore status: got %d", resp.Code)
+	}
+	if restored := fetch("/sync/bootstrap"); len(restored.Ops) != 3 || restored.ArchivedLists != nil {
+		t.Fatalf("expected restored list to sync again, got %+v", restored)
+	}
+	if resp := doRequest(t, mux, http.MethodPut, "/lists/missing/archive", []byte(`{"archived":true}`)); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown list, got %d", resp.Code)
+	}
+}
+
+func TestRestoringAListResyncsClientsThatSkippedItsOps(t *testing.T) {
+	store := newTestStore(t)
+	mux := http.NewServeMux()
+	NewServer(store).RegisterRoutes(mux)
+	ctx := context.Background()
+	if err := store.ReplaceSnapshot(ctx, "user-1", storage.Snapshot{
+		DatasetGenerationKey: "gen-1",
+		Blob:                 `{"data":{"lists":[{"listId":"old","title":"2019 trip","items":[]}]}}`,
+	}); err != nil {
+		t.Fatalf("replace snapshot: %v", err)
+	}
+	type pullResponse struct {
+		ServerSeq int64 `json:"serverSeq"`
+		Ops       []struct {
+			ResourceID string `json:"resourceId"`
+		} `json:"ops"`
+		ClientHint string `json:"clientHint"`
+	}
+	pull := func(since int64) pullResponse {
+		t.Helper()
+		resp := doRequestWithHeaders(t, mux, http.MethodGet, "/sync/pull?clientId=client-b&datasetGenerationKey=gen-1&since="+strconv.FormatInt(since, 10), nil, archivedListsHeaders)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("pull status: got %d", resp.Code)
+		}
+		var payload pullResponse
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode pull: %v", err)
+		}
+		return payload
+	}
+	cursor := pull(0).ServerSeq
+
+	if resp := doRequest(t, mux, http.MethodPut, "/lists/old/archive", []byte(`{"archived":true}`)); resp.Code != http.StatusNoContent {
+		t.Fatalf("archive status: got %d", resp.Code)
+	}
+	body, _ := json.Marshal(map[string]any{
+		"clientId":             "client-a",
+		"datasetGenerationKey": "gen-1",
+		"ops": []map[string]any{
+			{"scope": "list", "resourceId": "old", "actor": "actor-a", "clock": 1, "payload": map[string]any{"type": "insert", "itemId": "item-1", "payload": map[string]any{"data": map[string]any{"text": "tickets"}}}},
+		},
+	})
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/push", body); resp.Code != http.StatusOK {
+		t.Fatalf("push status: got %d", resp.Code)
+	}
+	hidden := pull(cursor)
+	if len(hidden.Ops) != 0 || hidden.ServerSeq <= cursor || hidden.ClientHint != "" {
+		t.Fatalf("expected the archived list's op to be skipped, got %+v", hidden)
+	}
+	cursor = hidden.ServerSeq
+
+	if resp := doRequest(t, mux, http.MethodPut, "/lists/old/archive", []byte(`{"archived":false}`)); resp.Code != http.StatusNoContent {
+		t.Fatalf("restore status: got %d", resp.Code)
+	}
+	// The cursor is past the skipped op, so only a bootstrap can bring it.
+	if restored := pull(cursor); restored.ClientHint != storage.ClientHintResync {
+		t.Fatalf("expected a resync hint after the restore, got %+v", restored)
+	}
+	if again := pull(cursor); again.ClientHint != "" {
+		t.Fatalf("expected the hint to be delivered once, got %+v", again)
+	}
+	if resp := doRequest(t, mux, http.MethodPut, "/lists/old/archive", []byte(`{"archived":false}`)); resp.Code != http.StatusNoContent {
+		t.Fatalf("restore status: got %d", resp.Code)
+	}
+	if unchanged := pull(cursor); unchanged.ClientHint != "" {
+		t.Fatalf("expected no resync for a list that was not archived, got %+v", unchanged)
+	}
+}
@@ -0,0 +1,81 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"a4-tasklists/server/internal/backup"
+)
+
+// Backups are written on a schedule by the backup package. These admin
+// endpoints list them, write one on demand, and stage one for restore on the
+// next start.
+
+// handleAdminBackups lists the backups on GET and writes one now on POST.
+func (s *Server) handleAdminBackups(w http.ResponseWriter, r *http.Request) {
+	if s.backups == nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "backups are not configured"})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		backups, err := s.backups.List(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if backups == nil {
+			backups = []backup.Backup{}
+		}
+		payload := jsonResponse{"backups": backups}
+		if next := s.backups.Next(); !next.IsZero() {
+			payload["nextRunAt"] = next.Unix()
+		}
+		writeJSON(w, http.StatusOK, payload)
+	case http.MethodPost:
+		// A backup that was started finishes even if the operator gives up
+		// waiting for it.
+		written, err := s.backups.Run(context.WithoutCancel(r.Context()))
+		if errors.Is(err, backup.ErrRunning) {
+			writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, written)
+	default:
+		methodNotAllowed(w)
+	}
+}
+
+// handleAdminRestoreBackup stages a backup; the server restores it when it
+// starts next.
+func (s *Server) handleAdminRestoreBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+	if s.backups == nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "backups are not configured"})
+		return
+	}
+	staged, err := s.backups.Stage(r.Context(), r.PathValue("id"))
+	if errors.Is(err, backup.ErrNotFound) {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+		return
+	}
+	if errors.Is(err, backup.ErrNoIdentity) {
+		writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("admin restore staged backup=%s", staged.ID)
+	writeJSON(w, http.StatusAccepted, jsonResponse{"staged": staged, "restartRequired": true})
+}
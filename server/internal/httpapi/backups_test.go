@@ -0,0 +1,63 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"a4-tasklists/server/internal/backup"
+	"a4-tasklists/server/internal/blobstore"
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestAdminBackupsRunListAndStage(t *testing.T) {
+	// Backups copy SQLite files, which the memory store has none of.
+	store, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "data.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := store.Init(context.Background()); err != nil {
+		t.Fatalf("init sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	stageDir := filepath.Join(t.TempDir(), "restore")
+	manager := backup.New(backup.Config{Source: store, Destination: blobstore.NewMemory(), StageDir: stageDir})
+	server := NewServerWithConfig(store, Config{Backups: manager})
+	mux := http.NewServeMux()
+	server.RegisterAdminRoutes(mux)
+
+	resp := doRequest(t, mux, http.MethodPost, "/admin/backups", nil)
+	var written backup.Backup
+	if err := json.NewDecoder(resp.Body).Decode(&written); err != nil || resp.Code != http.StatusOK || written.ID == "" {
+		t.Fatalf("run backup: %d %v %s", resp.Code, err, resp.Body.String())
+	}
+	resp = doRequest(t, mux, http.MethodGet, "/admin/backups", nil)
+	var listed struct {
+		Backups []backup.Backup `json:"backups"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil || len(listed.Backups) != 1 || listed.Backups[0].ID != written.ID {
+		t.Fatalf("unexpected listing: %v %+v", err, listed)
+	}
+
+	if resp := doRequest(t, mux, http.MethodPost, "/admin/backups/nope/restore", nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown backup, got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodPost, "/admin/backups/"+written.ID+"/restore", nil); resp.Code != http.StatusAccepted {
+		t.Fatalf("stage restore: %d %s", resp.Code, resp.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(stageDir, "main.db")); err != nil {
+		t.Fatalf("expected the database to be staged: %v", err)
+	}
+}
+
+func TestAdminBackupsNeedConfiguration(t *testing.T) {
+	server := NewServer(newTestStore(t))
+	mux := http.NewServeMux()
+	server.RegisterAdminRoutes(mux)
+	if resp := doRequest(t, mux, http.MethodGet, "/admin/backups", nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without backups, got %d", resp.Code)
+	}
+}
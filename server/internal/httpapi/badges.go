@@ -0,0 +1,87 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/storage"
+)
+
+type listBadge struct {
+	ListID    string `json:"listId"`
+	Title     string `json:"title"`
+	Added     int    `json:"added"`
+	Completed int    `json:"completed"`
+}
+
+// handleBadges reports, per visible list, how many items were added and
+// completed since the client's cursor. Changes made by the client itself are
+// not counted.
+func (s *Server) handleBadges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	clientID := r.URL.Query().Get("clientId")
+	if clientID == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "clientId is required"})
+		return
+	}
+	clients, err := s.store.ListClients(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	// A client without a cursor has seen nothing yet.
+	var since int64
+	for _, client := range clients {
+		if client.ClientID == clientID {
+			since = client.LastSeenServerSeq
+		}
+	}
+	snapshot, err := s.store.GetSnapshot(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	ops, serverSeq, err := s.store.GetOpsSince(r.Context(), userID, 0)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	state, err := s.quarantine.Build(r.Context(), userID, snapshot.Blob, ops)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("materialize state: %w", err))
+		return
+	}
+	unseen := make([]storage.Op, 0)
+	for _, op := range ops {
+		if op.ServerSeq > since && op.ClientID != clientID {
+			unseen = append(unseen, op)
+		}
+	}
+	changes := make(map[string]materialize.ResourceChange)
+	for _, change := range materialize.Summarize(unseen).Resources {
+		if change.Scope == materialize.ScopeList {
+			changes[change.ResourceID] = change
+		}
+	}
+	badges := make([]listBadge, 0)
+	for _, list := range state.Lists {
+		change, ok := changes[list.ID]
+		if !ok || change.Added+change.Completed == 0 {
+			continue
+		}
+		badges = append(badges, listBadge{ListID: list.ID, Title: list.Title, Added: change.Added, Completed: change.Completed})
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{
+		"since":     since,
+		"serverSeq": serverSeq,
+		"lists":     badges,
+	})
+}
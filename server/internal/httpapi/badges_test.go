@@ -0,0 +1,61 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestBadgesCountOtherClientsChangesSinceCursor(t *testing.T) {
+	mux := newTestMux(t)
+	bootstrap := fetchBootstrap(t, mux)
+	pullPath := "/sync/pull?since=0&datasetGenerationKey=" + bootstrap.DatasetGenerationKey + "&clientId="
+	if resp := doRequest(t, mux, http.MethodGet, pullPath+"client-b", nil); resp.Code != http.StatusOK {
+		t.Fatalf("pull status: got %d", resp.Code)
+	}
+	body, _ := json.Marshal(map[string]any{
+		"clientId":             "client-a",
+		"datasetGenerationKey": bootstrap.DatasetGenerationKey,
+		"ops": []map[string]any{
+			{"scope": "registry", "resourceId": "registry", "actor": "actor-a", "clock": 1, "payload": map[string]any{"type": "createList", "listId": "list-1", "payload": map[string]any{"title": "Groceries"}}},
+			{"scope": "list", "resourceId": "list-1", "actor": "actor-a", "clock": 2, "payload": map[string]any{"type": "insert", "itemId": "item-1", "payload": map[string]any{"data": map[string]any{"text": "milk"}}}},
+			{"scope": "list", "resourceId": "list-1", "actor": "actor-a", "clock": 3, "payload": map[string]any{"type": "insert", "itemId": "item-2", "payload": map[string]any{"data": map[string]any{"text": "eggs"}}}},
+			{"scope": "list", "resourceId": "list-1", "actor": "actor-a", "clock": 4, "payload": map[string]any{"type": "update", "itemId": "item-1", "payload": map[string]any{"data": map[string]any{"done": true}}}},
+		},
+	})
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/push", body); resp.Code != http.StatusOK {
+		t.Fatalf("push status: got %d", resp.Code)
+	}
+
+	type badges struct {
+		Lists []listBadge `json:"lists"`
+	}
+	fetch := func(clientID string) badges {
+		t.Helper()
+		resp := doRequest(t, mux, http.MethodGet, "/badges?clientId="+clientID, nil)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("badges status: got %d", resp.Code)
+		}
+		var payload badges
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode badges: %v", err)
+		}
+		return payload
+	}
+	got := fetch("client-b")
+	if len(got.Lists) != 1 || got.Lists[0] != (listBadge{ListID: "list-1", Title: "Groceries", Added: 2, Completed: 1}) {
+		t.Fatalf("unexpected badges: %+v", got)
+	}
+	if got := fetch("client-a"); len(got.Lists) != 0 {
+		t.Fatalf("expected no badges for own changes, got %+v", got)
+	}
+	if resp := doRequest(t, mux, http.MethodGet, pullPath+"client-b", nil); resp.Code != http.StatusOK {
+		t.Fatalf("pull status: got %d", resp.Code)
+	}
+	if got := fetch("client-b"); len(got.Lists) != 0 {
+		t.Fatalf("expected badges to clear after pull, got %+v", got)
+	}
+	if resp := doRequest(t, mux, http.MethodGet, "/badges", nil); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without clientId, got %d", resp.Code)
+	}
+}
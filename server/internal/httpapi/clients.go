@@ -0,0 +1,183 @@
+package httpapi
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+func (s *Server) handleClients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	clients, err := s.store.ListClients(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{"clients": clients})
+}
+
+// handleRetireClient lets a user release a device they no longer use. The
+// client's cursor stops counting immediately; with revoke the client id can
+// never sync again.
+func (s *Server) handleRetireClient(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	var payload struct {
+		ClientID string `json:"clientId"`
+		Revoke   bool   `json:"revoke"`
+	}
+	if err := decodeJSON(r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if payload.ClientID == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "clientId is required"})
+		return
+	}
+	err := s.store.RetireClient(r.Context(), userID, payload.ClientID, payload.Revoke)
+	if errors.Is(err, storage.ErrClientNotFound) {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("client retired client=%s revoke=%t", payload.ClientID, payload.Revoke)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Heartbeat advisories tell a client what it should do next.
+const (
+	// advisoryResync asks the client to discard local sync state and
+	// bootstrap, like the resync client hint.
+	advisoryResync = "resync"
+	// advisoryUpgrade asks the client to reload to pick up a newer version.
+	advisoryUpgrade = "upgrade"
+)
+
+// handleHeartbeat records what a client reports about itself (app version,
+// clock skew, applied cursor) for fleet visibility and answers with the
+// server time and advisories. It never changes the client's cursor.
+func (s *Server) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	var payload struct {
+		ClientID             string `json:"clientId"`
+		DatasetGenerationKey string `json:"datasetGenerationKey"`
+		AppVersion           string `json:"appVersion"`
+		ClockSkewMs          int64  `json:"clockSkewMs"`
+		Cursor               int64  `json:"cursor"`
+	}
+	if err := decodeJSON(r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if payload.ClientID == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "clientId is required"})
+		return
+	}
+	if payload.Cursor < 0 {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "cursor must be a non-negative integer"})
+		return
+	}
+	if !s.ensureClientAllowed(w, r, userID, payload.ClientID) {
+		return
+	}
+	ctx := r.Context()
+	now := time.Now()
+	heartbeat := storage.ClientHeartbeat{
+		AppVersion:        payload.AppVersion,
+		ClockSkewMs:       payload.ClockSkewMs,
+		ReportedServerSeq: payload.Cursor,
+		HeartbeatAt:       now.Unix(),
+	}
+	if err := s.store.RecordClientHeartbeat(ctx, userID, payload.ClientID, heartbeat); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	datasetGenerationKey, err := s.store.GetActiveDatasetGenerationKey(ctx, userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	stats, err := s.store.GetOpStats(ctx, userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	advisories := make([]string, 0, 2)
+	// A stale key that only missed a compaction is recovered by the next
+	// pull; only a divergent one means local state must go.
+	if payload.DatasetGenerationKey != "" && payload.DatasetGenerationKey != datasetGenerationKey {
+		lineage, err := s.generationLineage(ctx, userID, payload.DatasetGenerationKey)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if lineage == lineageDivergent {
+			advisories = append(advisories, advisoryResync)
+		}
+	}
+	if version, err := strconv.Atoi(strings.TrimSpace(r.Header.Get(SyncProtocolVersionHeader))); err == nil && version < MaxSyncProtocolVersion {
+		advisories = append(advisories, advisoryUpgrade)
+	}
+	// Taken last so a failed heartbeat does not swallow the hint.
+	hint, err := s.store.TakeClientHint(ctx, userID, payload.ClientID)
+	if err != nil {
+		log.Printf("sync heartbeat hint error client=%s: %v", payload.ClientID, err)
+	}
+	if hint != "" && !slices.Contains(advisories, hint) {
+		log.Printf("sync heartbeat delivered hint=%s client=%s", hint, payload.ClientID)
+		advisories = append(advisories, hint)
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{
+		"serverTime":           now.UnixMilli(),
+		"serverSeq":            stats.MaxServerSeq,
+		"datasetGenerationKey": datasetGenerationKey,
+		"advisories":           advisories,
+	})
+}
+
+// ensureClientAllowed answers 410 Gone for client ids the user revoked.
+func (s *Server) ensureClientAllowed(w http.ResponseWriter, r *http.Request, userID string, clientID string) bool {
+	return clientAllowed(w, s.store.CheckClient(r.Context(), userID, clientID))
+}
+
+// clientAllowed answers a failed client check and reports whether it passed.
+func clientAllowed(w http.ResponseWriter, err error) bool {
+	if errors.Is(err, storage.ErrClientRevoked) {
+		writeJSON(w, http.StatusGone, errorResponse{Error: err.Error()})
+		return false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return false
+	}
+	return true
+}
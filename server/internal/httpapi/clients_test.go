@@ -0,0 +1,134 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestRetireClientReleasesCursorAndRevokes(t *testing.T) {
+	mux := newTestMux(t)
+	bootstrap := fetchBootstrap(t, mux)
+	pullPath := func(clientID string) string {
+		return "/sync/pull?clientId=" + clientID + "&datasetGenerationKey=" + url.QueryEscape(bootstrap.DatasetGenerationKey)
+	}
+	for _, clientID := range []string{"phone", "laptop"} {
+		if resp := doRequest(t, mux, http.MethodGet, pullPath(clientID), nil); resp.Code != http.StatusOK {
+			t.Fatalf("pull status: got %d", resp.Code)
+		}
+	}
+
+	if resp := doRequest(t, mux, http.MethodPost, "/clients/retire", []byte(`{"clientId":"phone"}`)); resp.Code != http.StatusNoContent {
+		t.Fatalf("retire status: got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodPost, "/clients/retire", []byte(`{"clientId":"laptop","revoke":true}`)); resp.Code != http.StatusNoContent {
+		t.Fatalf("revoke status: got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodPost, "/clients/retire", []byte(`{"clientId":"tablet"}`)); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown client, got %d", resp.Code)
+	}
+
+	resp := doRequest(t, mux, http.MethodGet, "/clients", nil)
+	var listed struct {
+		Clients []json.RawMessage `json:"clients"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+		t.Fatalf("decode clients: %v", err)
+	}
+	if len(listed.Clients) != 0 {
+		t.Fatalf("expected retired clients to be released, got %s", listed.Clients)
+	}
+
+	if resp := doRequest(t, mux, http.MethodGet, pullPath("laptop"), nil); resp.Code != http.StatusGone {
+		t.Fatalf("expected revoked client pull to get 410, got %d", resp.Code)
+	}
+	push, _ := json.Marshal(map[string]any{
+		"clientId":             "laptop",
+		"datasetGenerationKey": bootstrap.DatasetGenerationKey,
+		"ops":                  []any{},
+	})
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/push", push); resp.Code != http.StatusGone {
+		t.Fatalf("expected revoked client push to get 410, got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodGet, pullPath("phone"), nil); resp.Code != http.StatusOK {
+		t.Fatalf("expected retired but not revoked client to sync again, got %d", resp.Code)
+	}
+}
+
+func TestHeartbeatRecordsReportAndReturnsAdvisories(t *testing.T) {
+	server := NewServer(newTestStore(t))
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	server.RegisterAdminRoutes(mux)
+	bootstrap := fetchBootstrap(t, mux)
+	type heartbeatResponse struct {
+		ServerTime           int64    `json:"serverTime"`
+		ServerSeq            int64    `json:"serverSeq"`
+		DatasetGenerationKey string   `json:"datasetGenerationKey"`
+		Advisories           []string `json:"advisories"`
+	}
+	heartbeat := func(datasetGenerationKey string) heartbeatResponse {
+		t.Helper()
+		body, _ := json.Marshal(map[string]any{"clientId": "phone", "datasetGenerationKey": datasetGenerationKey, "appVersion": "1.4.0", "clockSkewMs": -1500, "cursor": 0})
+		resp := doRequest(t, mux, http.MethodPost, "/sync/heartbeat", body)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("heartbeat status: got %d: %s", resp.Code, resp.Body.String())
+		}
+		var payload heartbeatResponse
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode heartbeat: %v", err)
+		}
+		return payload
+	}
+
+	push, _ := json.Marshal(map[string]any{"clientId": "laptop", "datasetGenerationKey": bootstrap.DatasetGenerationKey, "ops": []map[string]any{
+		{"scope": "list", "resourceId": "list-1", "actor": "actor-1", "clock": 1, "payload": map[string]any{"type": "insert", "itemId": "item-1"}},
+	}})
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/push", push); resp.Code != http.StatusOK {
+		t.Fatalf("push status: got %d", resp.Code)
+	}
+	got := heartbeat(bootstrap.DatasetGenerationKey)
+	if got.ServerSeq == 0 || got.DatasetGenerationKey != bootstrap.DatasetGenerationKey || len(got.Advisories) != 0 {
+		t.Fatalf("unexpected heartbeat response: %+v", got)
+	}
+	if drift := time.Since(time.UnixMilli(got.ServerTime)); drift < 0 || drift > time.Minute {
+		t.Fatalf("unexpected server time %d", got.ServerTime)
+	}
+
+	resp := doRequest(t, mux, http.MethodGet, "/admin/clients?userId=user-1", nil)
+	var listed struct {
+		Clients []storage.Client `json:"clients"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+		t.Fatalf("decode clients: %v", err)
+	}
+	var phone storage.Client
+	for _, client := range listed.Clients {
+		if client.ClientID == "phone" {
+			phone = client
+		}
+	}
+	if phone.AppVersion != "1.4.0" || phone.ClockSkewMs != -1500 || phone.HeartbeatAt == 0 {
+		t.Fatalf("expected the heartbeat on the listed client, got %+v", listed.Clients)
+	}
+
+	// A pending hint is delivered once, and a divergent key asks for a resync.
+	if resp := doRequest(t, mux, http.MethodPost, "/admin/clients/hint", []byte(`{"userId":"user-1","clientId":"phone","hint":"upgrade"}`)); resp.Code != http.StatusNoContent {
+		t.Fatalf("hint status: got %d", resp.Code)
+	}
+	if got := heartbeat("dataset-unknown"); !slices.Equal(got.Advisories, []string{"resync", "upgrade"}) {
+		t.Fatalf("expected resync and upgrade advisories, got %v", got.Advisories)
+	}
+	if got := heartbeat(bootstrap.DatasetGenerationKey); len(got.Advisories) != 0 {
+		t.Fatalf("expected the hint to be delivered once, got %v", got.Advisories)
+	}
+
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/heartbeat", []byte(`{"appVersion":"1.4.0"}`)); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without clientId, got %d", resp.Code)
+	}
+}
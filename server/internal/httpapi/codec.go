@@ -0,0 +1,155 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"a4-tasklists/server/internal/features"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Sync payloads are JSON by default. Clients on slow links may instead send
+// push bodies as CBOR (Content-Type: application/cbor) and ask for CBOR
+// bootstrap/pull/push responses (Accept: application/cbor). CBOR is a
+// transport encoding only: the server transcodes to and from the JSON data
+// model, so op payloads stay opaque JSON in storage and JSON clients see the
+// same values.
+
+const (
+	contentTypeJSON = "application/json"
+	contentTypeCBOR = "application/cbor"
+)
+
+var (
+	cborDecMode = mustCBORDecMode()
+	cborEncMode = mustCBOREncMode()
+)
+
+func mustCBORDecMode() cbor.DecMode {
+	mode, err := cbor.DecOptions{
+		DefaultMapType:  reflect.TypeOf(map[string]any(nil)),
+		MaxNestedLevels: 64,
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return mode
+}
+
+func mustCBOREncMode() cbor.EncMode {
+	mode, err := cbor.EncOptions{}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return mode
+}
+
+// decodeBody decodes a request body according to its Content-Type, applying
+// the same strict field checks as decodeJSON.
+func decodeBody(r *http.Request, target any) error {
+	if mediaType(r.Header.Get("Content-Type")) != contentTypeCBOR {
+		return decodeJSON(r, target)
+	}
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("read body: %w", err)
+	}
+	var value any
+	if err := cborDecMode.Unmarshal(raw, &value); err != nil {
+		return fmt.Errorf("decode cbor: %w", err)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("transcode cbor: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(target)
+}
+
+// writeNegotiated writes payload as CBOR when the request accepts it and the
+// binary-transport feature is on for the user, and as JSON otherwise.
+func (s *Server) writeNegotiated(w http.ResponseWriter, r *http.Request, status int, payload any) {
+	if !acceptsCBOR(r) || !s.featureEnabled(r, features.BinaryTransport) {
+		writeJSON(w, status, payload)
+		return
+	}
+	encoded, err := encodeCBOR(payload)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeCBOR)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(status)
+	_, _ = w.Write(encoded)
+}
+
+// encodeCBOR renders payload through its JSON representation so field names
+// and raw op payloads match the JSON transport exactly.
+func encodeCBOR(payload any) ([]byte, error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("encode payload: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("transcode payload: %w", err)
+	}
+	result, err := cborEncMode.Marshal(cborValue(value))
+	if err != nil {
+		return nil, fmt.Errorf("encode cbor: %w", err)
+	}
+	return result, nil
+}
+
+// cborValue converts json.Number values so integers (serverSeq, clocks) are
+// encoded as CBOR integers rather than floats.
+func cborValue(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for key, item := range v {
+			v[key] = cborValue(item)
+		}
+		return v
+	case []any:
+		for i, item := range v {
+			v[i] = cborValue(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func acceptsCBOR(r *http.Request) bool {
+	for part := range strings.SplitSeq(r.Header.Get("Accept"), ",") {
+		if mediaType(part) == contentTypeCBOR {
+			return true
+		}
+	}
+	return false
+}
+
+func mediaType(header string) string {
+	parsed, _, err := mime.ParseMediaType(strings.TrimSpace(header))
+	if err != nil {
+		return ""
+	}
+	return parsed
+}
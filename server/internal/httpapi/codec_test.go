@@ -0,0 +1,88 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+func TestCBORPushAndPull(t *testing.T) {
+	mux := newTestMux(t)
+	bootstrap := fetchBootstrap(t, mux)
+
+	body, err := cbor.Marshal(map[string]any{
+		"clientId":             "client-1",
+		"datasetGenerationKey": bootstrap.DatasetGenerationKey,
+		"ops": []any{map[string]any{
+			"scope":      "list",
+			"resourceId": "list-1",
+			"actor":      "actor-1",
+			"clock":      1,
+			"payload":    map[string]any{"type": "insert", "itemId": "item-1", "payload": map[string]any{"data": map[string]any{"text": "milk", "done": false}}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("encode cbor: %v", err)
+	}
+	resp := doRequestWithHeaders(t, mux, http.MethodPost, "/sync/push", body, map[string]string{
+		"Content-Type": contentTypeCBOR,
+		"Accept":       contentTypeCBOR,
+	})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("push status: got %d: %s", resp.Code, resp.Body.String())
+	}
+	if got := resp.Header().Get("Content-Type"); got != contentTypeCBOR {
+		t.Fatalf("push content type: got %q", got)
+	}
+	var pushed struct {
+		ServerSeq int64 `cbor:"serverSeq"`
+	}
+	if err := cbor.Unmarshal(resp.Body.Bytes(), &pushed); err != nil {
+		t.Fatalf("decode push: %v", err)
+	}
+	if pushed.ServerSeq == 0 {
+		t.Fatalf("serverSeq should advance")
+	}
+
+	resp = doRequestWithHeaders(t, mux, http.MethodGet, "/sync/pull?since=0&clientId=client-2&datasetGenerationKey="+bootstrap.DatasetGenerationKey, nil, map[string]string{"Accept": contentTypeCBOR})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("pull status: got %d", resp.Code)
+	}
+	var pulled struct {
+		ServerSeq int64 `cbor:"serverSeq"`
+		Ops       []struct {
+			Clock   int64          `cbor:"clock"`
+			Payload map[string]any `cbor:"payload"`
+		} `cbor:"ops"`
+	}
+	if err := cbor.Unmarshal(resp.Body.Bytes(), &pulled); err != nil {
+		t.Fatalf("decode pull: %v", err)
+	}
+	if pulled.ServerSeq != pushed.ServerSeq || len(pulled.Ops) != 1 || pulled.Ops[0].Clock != 1 || pulled.Ops[0].Payload["itemId"] != "item-1" {
+		t.Fatalf("unexpected cbor pull: %+v", pulled)
+	}
+
+	resp = doRequest(t, mux, http.MethodGet, "/sync/pull?since=0&clientId=client-3&datasetGenerationKey="+bootstrap.DatasetGenerationKey, nil)
+	var jsonPull struct {
+		Ops []struct {
+			Payload json.RawMessage `json:"payload"`
+		} `json:"ops"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jsonPull); err != nil {
+		t.Fatalf("decode json pull: %v", err)
+	}
+	if len(jsonPull.Ops) != 1 || !json.Valid(jsonPull.Ops[0].Payload) {
+		t.Fatalf("cbor push should be readable as json: %+v", jsonPull)
+	}
+}
+
+func TestCBORPushRejectsUnknownFields(t *testing.T) {
+	mux := newTestMux(t)
+	body, _ := cbor.Marshal(map[string]any{"clientId": "client-1", "bogus": true})
+	resp := doRequestWithHeaders(t, mux, http.MethodPost, "/sync/push", body, map[string]string{"Content-Type": contentTypeCBOR})
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("status: got %d", resp.Code)
+	}
+}
@@ -0,0 +1,63 @@
+package httpapi
+
+import (
+	"net/http"
+	"slices"
+
+	"a4-tasklists/server/internal/materialize"
+)
+
+type itemComment struct {
+	ItemID string `json:"itemId"`
+	materialize.Comment
+}
+
+// handleComments returns the comments on a list's visible items, optionally
+// narrowed to one item (?itemId=). Comments are written as "comment" scope ops
+// through /sync/push like any other change.
+func (s *Server) handleComments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	state, err := s.loadState(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	list, ok := state.FindList(r.PathValue("id"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: materialize.ErrListNotFound.Error()})
+		return
+	}
+	itemID := r.URL.Query().Get("itemId")
+	comments := make([]itemComment, 0)
+	actors := make([]string, 0)
+	for _, item := range list.Items {
+		if itemID != "" && item.ID != itemID {
+			continue
+		}
+		for _, comment := range item.Comments {
+			comments = append(comments, itemComment{ItemID: item.ID, Comment: comment})
+			if !slices.Contains(actors, comment.Actor) {
+				actors = append(actors, comment.Actor)
+			}
+		}
+	}
+	payload := jsonResponse{"comments": comments}
+	if len(actors) > 0 {
+		attribution, err := s.store.GetActorAttribution(r.Context(), userID, actors)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if len(attribution) > 0 {
+			payload["actors"] = attribution
+		}
+	}
+	writeJSON(w, http.StatusOK, payload)
+}
@@ -0,0 +1,50 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestCommentsAreReadFromCommentScopeOps(t *testing.T) {
+	mux := newTestMux(t)
+	bootstrap := fetchBootstrap(t, mux)
+	body, _ := json.Marshal(map[string]any{
+		"clientId":             "client-a",
+		"datasetGenerationKey": bootstrap.DatasetGenerationKey,
+		"ops": []map[string]any{
+			{"scope": "registry", "resourceId": "registry", "actor": "actor-a", "clock": 1, "payload": map[string]any{"type": "createList", "listId": "list-1", "payload": map[string]any{"title": "Trip"}}},
+			{"scope": "list", "resourceId": "list-1", "actor": "actor-a", "clock": 2, "payload": map[string]any{"type": "insert", "itemId": "item-1", "payload": map[string]any{"data": map[string]any{"text": "tent"}}}},
+			{"scope": "list", "resourceId": "list-1", "actor": "actor-a", "clock": 3, "payload": map[string]any{"type": "insert", "itemId": "item-2", "payload": map[string]any{"data": map[string]any{"text": "stove"}}}},
+			{"scope": "comment", "resourceId": "list-1", "actor": "actor-a", "clock": 4, "payload": map[string]any{"type": "addComment", "itemId": "item-1", "commentId": "c-1", "payload": map[string]any{"text": "the big one"}}},
+			{"scope": "comment", "resourceId": "list-1", "actor": "actor-a", "clock": 5, "payload": map[string]any{"type": "addComment", "itemId": "item-2", "commentId": "c-2", "payload": map[string]any{"text": "gas?"}}},
+		},
+	})
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/push", body); resp.Code != http.StatusOK {
+		t.Fatalf("push status: got %d", resp.Code)
+	}
+
+	fetch := func(path string) []itemComment {
+		t.Helper()
+		resp := doRequest(t, mux, http.MethodGet, path, nil)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("comments status: got %d", resp.Code)
+		}
+		var payload struct {
+			Comments []itemComment `json:"comments"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode comments: %v", err)
+		}
+		return payload.Comments
+	}
+	if got := fetch("/lists/list-1/comments"); len(got) != 2 || got[0].ItemID != "item-1" || got[1].Text != "gas?" {
+		t.Fatalf("unexpected comments: %+v", got)
+	}
+	if got := fetch("/lists/list-1/comments?itemId=item-2"); len(got) != 1 || got[0].ID != "c-2" {
+		t.Fatalf("unexpected item comments: %+v", got)
+	}
+	if resp := doRequest(t, mux, http.MethodGet, "/lists/missing/comments", nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown list, got %d", resp.Code)
+	}
+}
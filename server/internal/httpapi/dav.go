@@ -0,0 +1,230 @@
+package httpapi
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"a4-tasklists/server/internal/export"
+	"a4-tasklists/server/internal/i18n"
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/storage"
+)
+
+// davPrefix is where the read-only WebDAV mount of the lists lives.
+const davPrefix = "/dav/"
+
+// davAllow lists the methods the mount answers; everything that would change
+// a file is refused.
+const davAllow = "OPTIONS, GET, HEAD, PROPFIND"
+
+// davFile is one file or collection of the mount. Collections have no data.
+type davFile struct {
+	name        string
+	contentType string
+	data        []byte
+	collection  bool
+}
+
+// isDAVPath reports whether path is served by the WebDAV mount.
+func isDAVPath(path string) bool {
+	return path == "/dav" || strings.HasPrefix(path, davPrefix)
+}
+
+// handleDAV serves the user's lists as read-only files, so they can be
+// browsed from file managers and editors:
+//
+//	/dav/lists.json    every list as JSON
+//	/dav/lists.md      every list as Markdown
+//	/dav/lists/        one Markdown file per list
+//
+// File managers only speak Basic auth, so the mount takes an API token with
+// the read scope as the password (any username). A list token sees its list
+// only. The files are rendered from the current state on every request.
+func (s *Server) handleDAV(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/dav" {
+		http.Redirect(w, r, davPrefix, http.StatusMovedPermanently)
+		return
+	}
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("DAV", "1")
+		w.Header().Set("Allow", davAllow)
+		w.WriteHeader(http.StatusOK)
+		return
+	case http.MethodGet, http.MethodHead, "PROPFIND":
+	default:
+		w.Header().Set("Allow", davAllow)
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "the WebDAV mount is read-only"})
+		return
+	}
+	token, ok := s.apiToken(w, r)
+	if !ok {
+		return
+	}
+	if !token.HasScope(storage.TokenScopeRead) {
+		writeJSON(w, http.StatusForbidden, errorResponse{Error: "token lacks the read scope"})
+		return
+	}
+	state, err := s.loadState(r.Context(), token.UserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if token.ListID != "" {
+		state.Lists = slices.DeleteFunc(state.Lists, func(list materialize.List) bool { return list.ID != token.ListID })
+	}
+	profile, err := s.store.GetUserProfile(r.Context(), token.UserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	tree, err := davTree(state, s.messages.Printer(profile.Locale))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, davPrefix), "/")
+	file, ok := tree[name]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "no such file"})
+		return
+	}
+	if r.Method == "PROPFIND" {
+		writePropfind(w, r, tree, name, file)
+		return
+	}
+	if file.collection {
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "cannot GET a collection; use PROPFIND"})
+		return
+	}
+	etag := davETag(file.data)
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", file.contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(file.data)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(file.data)
+	}
+}
+
+// davTree renders the mount, keyed by path below /dav/ ("" is the root).
+func davTree(state materialize.State, p i18n.Printer) (map[string]davFile, error) {
+	tree := map[string]davFile{
+		"":      {collection: true},
+		"lists": {name: "lists", collection: true},
+	}
+	for _, format := range []string{export.FormatJSON, export.FormatMarkdown} {
+		data, err := export.Render(format, state, p)
+		if err != nil {
+			return nil, err
+		}
+		name := export.FileName(format)
+		tree[name] = davFile{name: name, contentType: davContentType(name), data: data}
+	}
+	for name, data := range export.MirrorFiles(state, p) {
+		tree["lists/"+name] = davFile{name: name, contentType: davContentType(name), data: data}
+	}
+	return tree, nil
+}
+
+func davContentType(name string) string {
+	if strings.HasSuffix(name, ".json") {
+		return "application/json"
+	}
+	return "text/markdown; charset=utf-8"
+}
+
+// davETag changes whenever the rendered file does.
+func davETag(data []byte) string {
+	return `"` + sha256Hex(data)[:32] + `"`
+}
+
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"DAV: multistatus"`
+	Responses []davResponse `xml:"response"`
+}
+
+type davResponse struct {
+	Href     string      `xml:"href"`
+	Propstat davPropstat `xml:"propstat"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"prop"`
+	Status string  `xml:"status"`
+}
+
+type davProp struct {
+	DisplayName   string          `xml:"displayname"`
+	ResourceType  davResourceType `xml:"resourcetype"`
+	ContentType   string          `xml:"getcontenttype,omitempty"`
+	ContentLength *int            `xml:"getcontentlength,omitempty"`
+	ETag          string          `xml:"getetag,omitempty"`
+}
+
+type davResourceType struct {
+	Collection *struct{} `xml:"collection,omitempty"`
+}
+
+// writePropfind answers PROPFIND with all properties of the file and, for a
+// collection at Depth 1, of its members. Infinite depth is refused as
+// RFC 4918 allows.
+func writePropfind(w http.ResponseWriter, r *http.Request, tree map[string]davFile, name string, file davFile) {
+	depth := r.Header.Get("Depth")
+	if depth == "" || depth == "infinity" {
+		writeJSON(w, http.StatusForbidden, errorResponse{Error: "Depth: infinity is not supported; use 0 or 1"})
+		return
+	}
+	if depth != "0" && depth != "1" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "Depth must be 0 or 1"})
+		return
+	}
+	result := davMultistatus{Responses: []davResponse{davEntry(name, file)}}
+	if depth == "1" && file.collection {
+		var members []string
+		for member := range tree {
+			if member != "" && member != name && path.Dir("/"+member) == path.Clean("/"+name) {
+				members = append(members, member)
+			}
+		}
+		slices.Sort(members)
+		for _, member := range members {
+			result.Responses = append(result.Responses, davEntry(member, tree[member]))
+		}
+	}
+	data, err := xml.Marshal(result)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(data)
+}
+
+func davEntry(name string, file davFile) davResponse {
+	href := davPrefix + name
+	prop := davProp{DisplayName: file.name}
+	if file.collection {
+		if name != "" {
+			href += "/"
+		}
+		prop.ResourceType.Collection = &struct{}{}
+	} else {
+		length := len(file.data)
+		prop.ContentType = file.contentType
+		prop.ContentLength = &length
+		prop.ETag = davETag(file.data)
+	}
+	return davResponse{Href: (&url.URL{Path: href}).EscapedPath(), Propstat: davPropstat{Prop: prop, Status: "HTTP/1.1 200 OK"}}
+}
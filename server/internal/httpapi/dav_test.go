@@ -0,0 +1,155 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDAVMountServesListsReadOnly(t *testing.T) {
+	store := newTestStore(t)
+	server := NewServer(store)
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	sessions := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := server.WithAPITokens(mux, sessions)
+
+	createList := func(title string) string {
+		t.Helper()
+		resp := doRequest(t, mux, http.MethodPost, "/lists", []byte(`{"title":"`+title+`"}`))
+		var created struct {
+			ListID string `json:"listId"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+			t.Fatalf("decode list: %v", err)
+		}
+		return created.ListID
+	}
+	groceries := createList("Groceries")
+	createList("Packing")
+	if resp := doRequest(t, mux, http.MethodPost, "/lists/"+groceries+"/items", []byte(`{"items":[{"text":"milk"}]}`)); resp.Code != http.StatusCreated {
+		t.Fatalf("create item: %d %s", resp.Code, resp.Body.String())
+	}
+	createToken := func(body string) string {
+		t.Helper()
+		resp := doRequest(t, mux, http.MethodPost, "/auth/tokens", []byte(body))
+		var created struct {
+			Secret string `json:"secret"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+			t.Fatalf("decode token: %v", err)
+		}
+		return created.Secret
+	}
+	read := createToken(`{"name":"Finder","scopes":["read"]}`)
+	add := createToken(`{"name":"Shortcut","scopes":["add"]}`)
+	list := createToken(`{"name":"Groceries","scopes":["read"],"listId":"` + groceries + `"}`)
+
+	dav := func(method, path, secret, depth string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, nil)
+		if secret != "" {
+			req.SetBasicAuth("me", secret)
+		}
+		if depth != "" {
+			req.Header.Set("Depth", depth)
+		}
+		resp := httptest.NewRecorder()
+		if secret == "" {
+			// Without credentials the request reaches the mount through the
+			// session middleware, which skips /dav/.
+			mux.ServeHTTP(resp, req)
+		} else {
+			handler.ServeHTTP(resp, req)
+		}
+		return resp
+	}
+	hrefs := func(resp *httptest.ResponseRecorder) []string {
+		t.Helper()
+		if resp.Code != http.StatusMultiStatus {
+			t.Fatalf("expected 207, got %d %s", resp.Code, resp.Body.String())
+		}
+		var result davMultistatus
+		if err := xml.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+			t.Fatalf("decode multistatus: %v", err)
+		}
+		var hrefs []string
+		for _, response := range result.Responses {
+			hrefs = append(hrefs, response.Href)
+		}
+		return hrefs
+	}
+
+	resp := dav("PROPFIND", "/dav/", "", "1")
+	if resp.Code != http.StatusUnauthorized || !strings.HasPrefix(resp.Header().Get("WWW-Authenticate"), "Basic") {
+		t.Fatalf("expected a Basic challenge, got %d %q", resp.Code, resp.Header().Get("WWW-Authenticate"))
+	}
+	if resp := dav("PROPFIND", "/dav/", "lat_wrong", "1"); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected an unknown token to be rejected, got %d", resp.Code)
+	}
+	if resp := dav("PROPFIND", "/dav/", add, "1"); resp.Code != http.StatusForbidden {
+		t.Fatalf("expected a token without the read scope to be rejected, got %d", resp.Code)
+	}
+	if resp := dav(http.MethodOptions, "/dav/", "", ""); resp.Header().Get("DAV") != "1" || resp.Header().Get("Allow") != davAllow {
+		t.Fatalf("unexpected OPTIONS: %d %v", resp.Code, resp.Header())
+	}
+
+	if got := strings.Join(hrefs(dav("PROPFIND", "/dav/", read, "1")), " "); got != "/dav/ /dav/lists/ /dav/lists.json /dav/lists.md" {
+		t.Fatalf("unexpected root: %s", got)
+	}
+	if got := strings.Join(hrefs(dav("PROPFIND", "/dav/lists/", read, "1")), " "); got != "/dav/lists/ /dav/lists/groceries.md /dav/lists/packing.md" {
+		t.Fatalf("unexpected lists collection: %s", got)
+	}
+	if got := strings.Join(hrefs(dav("PROPFIND", "/dav/lists.md", read, "0")), " "); got != "/dav/lists.md" {
+		t.Fatalf("unexpected file: %s", got)
+	}
+	if resp := dav("PROPFIND", "/dav/", read, "infinity"); resp.Code != http.StatusForbidden {
+		t.Fatalf("expected infinite depth to be refused, got %d", resp.Code)
+	}
+
+	resp = dav(http.MethodGet, "/dav/lists/groceries.md", read, "")
+	if resp.Code != http.StatusOK || resp.Body.String() != "# Groceries\n\n- [ ] milk\n" || resp.Header().Get("Content-Type") != "text/markdown; charset=utf-8" {
+		t.Fatalf("unexpected file: %d %q %v", resp.Code, resp.Body.String(), resp.Header())
+	}
+	req := httptest.NewRequest(http.MethodGet, "/dav/lists/groceries.md", nil)
+	req.SetBasicAuth("me", read)
+	req.Header.Set("If-None-Match", resp.Header().Get("ETag"))
+	cached := httptest.NewRecorder()
+	handler.ServeHTTP(cached, req)
+	if cached.Code != http.StatusNotModified {
+		t.Fatalf("expected an unchanged file to be 304, got %d", cached.Code)
+	}
+	if resp := dav(http.MethodGet, "/dav/lists.json", read, ""); resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `"title": "Packing"`) {
+		t.Fatalf("unexpected JSON: %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := dav(http.MethodGet, "/dav/nope.md", read, ""); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected unknown file to be 404, got %d", resp.Code)
+	}
+	for _, method := range []string{http.MethodPut, http.MethodDelete, "MKCOL", "MOVE", "PROPPATCH", "LOCK"} {
+		if resp := dav(method, "/dav/lists.md", read, ""); resp.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("expected %s to be refused, got %d", method, resp.Code)
+		}
+	}
+
+	// A list token sees its list only.
+	if got := strings.Join(hrefs(dav("PROPFIND", "/dav/lists/", list, "1")), " "); got != "/dav/lists/ /dav/lists/groceries.md" {
+		t.Fatalf("unexpected lists for a list token: %s", got)
+	}
+	if resp := dav(http.MethodGet, "/dav/lists.md", list, ""); strings.Contains(resp.Body.String(), "Packing") {
+		t.Fatalf("expected other lists to be hidden: %s", resp.Body.String())
+	}
+
+	// Basic credentials are only taken by the mount.
+	req = httptest.NewRequest(http.MethodGet, "/lists", nil)
+	req.SetBasicAuth("me", read)
+	elsewhere := httptest.NewRecorder()
+	handler.ServeHTTP(elsewhere, req)
+	if elsewhere.Code != http.StatusTeapot {
+		t.Fatalf("expected Basic credentials outside /dav/ to go to sessions, got %d", elsewhere.Code)
+	}
+}
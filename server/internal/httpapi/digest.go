@@ -0,0 +1,64 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+// handleDigest reads (GET) or changes (PUT {frequency}) the user's email
+// digest preference. Frequency is "daily", "weekly", or "" to opt out.
+func (s *Server) handleDigest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	if r.Method == http.MethodPut {
+		var payload struct {
+			Frequency string `json:"frequency"`
+		}
+		if err := decodeJSON(r, &payload); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		switch payload.Frequency {
+		case "", storage.DigestDaily, storage.DigestWeekly:
+		default:
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "frequency must be daily, weekly, or empty"})
+			return
+		}
+		if payload.Frequency != "" {
+			if !s.digests {
+				writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: "email digests are not configured"})
+				return
+			}
+			profile, err := s.store.GetUserProfile(r.Context(), userID)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			if profile.Email == "" {
+				writeJSON(w, http.StatusConflict, errorResponse{Error: "an email address is required for digests"})
+				return
+			}
+		}
+		if err := s.store.SetDigestFrequency(r.Context(), userID, payload.Frequency); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	settings, err := s.store.GetDigestSettings(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{
+		"available":  s.digests,
+		"frequency":  settings.Frequency,
+		"lastSentAt": settings.LastSentAt,
+	})
+}
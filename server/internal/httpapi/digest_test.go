@@ -0,0 +1,55 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestDigestPreferenceRequiresMailAndEmail(t *testing.T) {
+	store := newTestStore(t)
+	mux := http.NewServeMux()
+	NewServer(store).RegisterRoutes(mux)
+	if resp := doRequest(t, mux, http.MethodPut, "/me/digest", []byte(`{"frequency":"daily"}`)); resp.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without a mail sender, got %d", resp.Code)
+	}
+
+	mux = http.NewServeMux()
+	NewServerWithConfig(store, Config{Digests: true}).RegisterRoutes(mux)
+	if resp := doRequest(t, mux, http.MethodPut, "/me/digest", []byte(`{"frequency":"hourly"}`)); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown frequency, got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodPut, "/me/digest", []byte(`{"frequency":"daily"}`)); resp.Code != http.StatusConflict {
+		t.Fatalf("expected 409 without an email address, got %d", resp.Code)
+	}
+	if err := store.UpdateUserProfile(context.Background(), "user-1", storage.UserProfile{Email: "ada@example.com"}); err != nil {
+		t.Fatalf("update profile: %v", err)
+	}
+	resp := doRequest(t, mux, http.MethodPut, "/me/digest", []byte(`{"frequency":"weekly"}`))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("put status: got %d", resp.Code)
+	}
+	var payload struct {
+		Available bool   `json:"available"`
+		Frequency string `json:"frequency"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode digest: %v", err)
+	}
+	if !payload.Available || payload.Frequency != storage.DigestWeekly {
+		t.Fatalf("unexpected digest settings: %+v", payload)
+	}
+	if resp := doRequest(t, mux, http.MethodPut, "/me/digest", []byte(`{"frequency":""}`)); resp.Code != http.StatusOK {
+		t.Fatalf("opt-out status: got %d", resp.Code)
+	}
+	settings, err := store.GetDigestSettings(context.Background(), "user-1")
+	if err != nil {
+		t.Fatalf("get digest settings: %v", err)
+	}
+	if settings.Frequency != "" {
+		t.Fatalf("expected opt-out to clear the frequency, got %+v", settings)
+	}
+}
@@ -0,0 +1,75 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"a4-tasklists/server/internal/digest"
+	"a4-tasklists/server/internal/i18n"
+	"a4-tasklists/server/internal/mail"
+	"a4-tasklists/server/internal/storage"
+)
+
+// Email previews render the server's emails with sample content, so operators
+// can check their templates (SERVER_EMAIL_TEMPLATE_DIR) without waiting for a
+// digest to go out.
+
+// emailPreviews renders each email with sample content.
+var emailPreviews = map[string]func(templates *mail.Templates, p i18n.Printer, r *http.Request) (mail.Message, error){
+	"digest": func(templates *mail.Templates, p i18n.Printer, r *http.Request) (mail.Message, error) {
+		sample := digest.Sample()
+		if r.URL.Query().Get("frequency") == storage.DigestWeekly {
+			sample.Frequency = storage.DigestWeekly
+		}
+		return digest.Compose(templates, sample, p)
+	},
+}
+
+// handleAdminEmails lists the emails that can be previewed and the languages
+// they can be previewed in.
+func (s *Server) handleAdminEmails(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	if s.emailTemplates == nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "email templates are not configured"})
+		return
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{"emails": s.emailTemplates.Names(), "languages": s.messages.Languages()})
+}
+
+// handleAdminEmailPreview renders an email with sample content in ?locale=
+// (default English). It answers the subject and both bodies as JSON, or with
+// ?part=html or ?part=text just that body, to open in a browser.
+func (s *Server) handleAdminEmailPreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	if s.emailTemplates == nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "email templates are not configured"})
+		return
+	}
+	preview, ok := emailPreviews[r.PathValue("name")]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "no such email"})
+		return
+	}
+	msg, err := preview(s.emailTemplates, s.messages.Printer(r.URL.Query().Get("locale")), r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	switch r.URL.Query().Get("part") {
+	case "":
+		writeJSON(w, http.StatusOK, msg)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(msg.HTML))
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(msg.Text))
+	default:
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "part must be html or text"})
+	}
+}
@@ -0,0 +1,48 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"a4-tasklists/server/internal/i18n"
+	"a4-tasklists/server/internal/mail"
+)
+
+func TestAdminEmailPreview(t *testing.T) {
+	mux := http.NewServeMux()
+	NewServerWithConfig(newTestStore(t), Config{Messages: i18n.Builtin(), EmailTemplates: mail.Builtin()}).RegisterAdminRoutes(mux)
+
+	resp := doRequest(t, mux, http.MethodGet, "/admin/emails", nil)
+	var index struct {
+		Emails    []string `json:"emails"`
+		Languages []string `json:"languages"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+		t.Fatalf("decode index: %v", err)
+	}
+	if strings.Join(index.Emails, ",") != "digest" || strings.Join(index.Languages, ",") != "en,de" {
+		t.Fatalf("unexpected index: %+v", index)
+	}
+
+	resp = doRequest(t, mux, http.MethodGet, "/admin/emails/digest?locale=de&frequency=weekly", nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("preview status: %d %s", resp.Code, resp.Body.String())
+	}
+	var msg mail.Message
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		t.Fatalf("decode preview: %v", err)
+	}
+	if !strings.HasPrefix(msg.Subject, "Deine wöchentliche Listenübersicht") || !strings.Contains(msg.Text, "Erledigt:") || !strings.Contains(msg.HTML, `lang="de"`) {
+		t.Fatalf("unexpected preview: %+v", msg)
+	}
+
+	resp = doRequest(t, mux, http.MethodGet, "/admin/emails/digest?part=html", nil)
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != "text/html; charset=utf-8" || !strings.HasPrefix(resp.Body.String(), "<!DOCTYPE html>") {
+		t.Fatalf("unexpected HTML part: %d %v", resp.Code, resp.Header())
+	}
+	if resp := doRequest(t, mux, http.MethodGet, "/admin/emails/welcome", nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected an unknown email to be 404, got %d", resp.Code)
+	}
+}
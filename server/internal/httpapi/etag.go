@@ -0,0 +1,63 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Optimistic concurrency for mutations follows plain HTTP semantics: reads
+// expose the current version as an ETag, and mutations may send it back in
+// If-Match. A mismatch means someone else changed the resource in between, so
+// the mutation is rejected with 412 instead of silently overwriting it.
+
+// quoteETag renders a version token as a strong entity tag.
+func quoteETag(version string) string {
+	return `"` + version + `"`
+}
+
+// setETag exposes the current version of the resource on the response.
+func setETag(w http.ResponseWriter, version string) {
+	w.Header().Set("ETag", quoteETag(version))
+}
+
+// ifMatchSatisfied reports whether the request's If-Match header (if any)
+// matches the current version. Requests without If-Match are allowed through so
+// existing clients keep working.
+func ifMatchSatisfied(r *http.Request, version string) bool {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		return true
+	}
+	current := quoteETag(version)
+	for candidate := range strings.SplitSeq(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == current {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchAny reports whether the request's If-Match is "*", which any current
+// version satisfies.
+func ifMatchAny(r *http.Request) bool {
+	return strings.TrimSpace(r.Header.Get("If-Match")) == "*"
+}
+
+// resourceVersion derives a version from a resource's JSON form, for
+// resources that do not carry one of their own.
+func resourceVersion(resource any) string {
+	encoded, err := json.Marshal(resource)
+	if err != nil {
+		return ""
+	}
+	return sha256Hex(encoded)[:16]
+}
+
+// writePreconditionFailed responds with 412 and the current version so the
+// caller can re-read and retry deliberately.
+func writePreconditionFailed(w http.ResponseWriter, version string) {
+	setETag(w, version)
+	writeJSON(w, http.StatusPreconditionFailed, errorResponse{Error: "resource was modified concurrently"})
+}
@@ -0,0 +1,88 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestBootstrapExposesETag(t *testing.T) {
+	mux := newTestMux(t)
+	resp := doRequest(t, mux, http.MethodGet, "/sync/bootstrap", nil)
+	var payload bootstrapResponse
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got := resp.Header().Get("ETag"); got != `"`+payload.DatasetGenerationKey+`"` {
+		t.Fatalf("etag: got %q", got)
+	}
+}
+
+func TestResetHonorsIfMatch(t *testing.T) {
+	mux := newTestMux(t)
+	bootstrap := fetchBootstrap(t, mux)
+	snapshot := `{"schema":"net.aggregat4.tasklist.snapshot@v1","data":{"lists":[]}}`
+
+	first, _ := json.Marshal(map[string]any{"clientId": "client-1", "datasetGenerationKey": "dataset-a", "snapshot": snapshot})
+	resp := doRequestWithHeaders(t, mux, http.MethodPost, "/sync/reset", first, map[string]string{"If-Match": `"` + bootstrap.DatasetGenerationKey + `"`})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("first reset status: got %d", resp.Code)
+	}
+
+	second, _ := json.Marshal(map[string]any{"clientId": "client-2", "datasetGenerationKey": "dataset-b", "snapshot": snapshot})
+	resp = doRequestWithHeaders(t, mux, http.MethodPost, "/sync/reset", second, map[string]string{"If-Match": `"` + bootstrap.DatasetGenerationKey + `"`})
+	if resp.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale reset status: got %d", resp.Code)
+	}
+	if got := resp.Header().Get("ETag"); got != `"dataset-a"` {
+		t.Fatalf("etag: got %q", got)
+	}
+}
+
+// racingResetStore lets another reset land right after the active generation
+// is read, as a concurrent import from another device would.
+type racingResetStore struct {
+	storage.Store
+	race func()
+}
+
+func (s racingResetStore) GetActiveDatasetGenerationKey(ctx context.Context, userID string) (string, error) {
+	key, err := s.Store.GetActiveDatasetGenerationKey(ctx, userID)
+	if s.race != nil {
+		s.race()
+	}
+	return key, err
+}
+
+func TestResetIfMatchHoldsUntilTheWrite(t *testing.T) {
+	store := newTestStore(t)
+	snapshot := `{"schema":"net.aggregat4.tasklist.snapshot@v1","data":{"lists":[]}}`
+	if err := store.ReplaceSnapshot(context.Background(), "user-1", storage.Snapshot{DatasetGenerationKey: "dataset-a", Blob: snapshot}); err != nil {
+		t.Fatalf("replace snapshot: %v", err)
+	}
+	raced := false
+	racing := racingResetStore{Store: store}
+	racing.race = func() {
+		if raced {
+			return
+		}
+		raced = true
+		if err := store.ReplaceSnapshot(context.Background(), "user-1", storage.Snapshot{DatasetGenerationKey: "dataset-b", Blob: snapshot}); err != nil {
+			t.Errorf("concurrent reset: %v", err)
+		}
+	}
+	mux := http.NewServeMux()
+	NewServer(racing).RegisterRoutes(mux)
+
+	body, _ := json.Marshal(map[string]any{"clientId": "client-1", "datasetGenerationKey": "dataset-c", "snapshot": snapshot})
+	resp := doRequestWithHeaders(t, mux, http.MethodPost, "/sync/reset", body, map[string]string{"If-Match": `"dataset-a"`})
+	if resp.Code != http.StatusPreconditionFailed || resp.Header().Get("ETag") != `"dataset-b"` {
+		t.Fatalf("expected the reset to lose against the concurrent one, got %d %q", resp.Code, resp.Header().Get("ETag"))
+	}
+	if active, err := store.GetActiveDatasetGenerationKey(context.Background(), "user-1"); err != nil || active != "dataset-b" {
+		t.Fatalf("expected the concurrent reset to stay active, got %q (%v)", active, err)
+	}
+}
@@ -0,0 +1,141 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"a4-tasklists/server/internal/export"
+	"a4-tasklists/server/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// maxExportSchedules caps the schedules of one user.
+const maxExportSchedules = 10
+
+// handleExports lists the user's export schedules on GET and adds one on
+// POST. Destination credentials are write-only: they are sealed on the way
+// in and never returned.
+func (s *Server) handleExports(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		schedules, err := s.store.ListExportSchedules(r.Context(), userID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, jsonResponse{"available": s.exports != nil, "schedules": schedules})
+	case http.MethodPost:
+		if s.exports == nil {
+			writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: "scheduled exports are not configured"})
+			return
+		}
+		var payload struct {
+			Format      string        `json:"format"`
+			Cadence     string        `json:"cadence"`
+			Destination string        `json:"destination"`
+			Target      string        `json:"target"`
+			Config      export.Config `json:"config"`
+		}
+		if err := decodeJSON(r, &payload); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		existing, err := s.store.ListExportSchedules(r.Context(), userID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if len(existing) >= maxExportSchedules {
+			writeJSON(w, http.StatusConflict, errorResponse{Error: "at most 10 export schedules per user"})
+			return
+		}
+		schedule, err := s.exports.Prepare(storage.ExportSchedule{
+			ID:          "export-" + uuid.NewString(),
+			Format:      payload.Format,
+			Cadence:     payload.Cadence,
+			Destination: payload.Destination,
+			Target:      payload.Target,
+			CreatedAt:   time.Now().Unix(),
+		}, payload.Config)
+		if errors.Is(err, export.ErrInvalid) {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if err := s.store.CreateExportSchedule(r.Context(), userID, schedule); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		log.Printf("export schedule created schedule=%s destination=%s cadence=%s", schedule.ID, schedule.Destination, schedule.Cadence)
+		writeJSON(w, http.StatusCreated, schedule)
+	default:
+		methodNotAllowed(w)
+	}
+}
+
+// handleDeleteExport removes one of the user's export schedules. What was
+// already exported stays at the destination.
+func (s *Server) handleDeleteExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	if err := s.store.DeleteExportSchedule(r.Context(), userID, r.PathValue("id")); err != nil {
+		if errors.Is(err, storage.ErrExportScheduleNotFound) {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("export schedule deleted schedule=%s", r.PathValue("id"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRunExport runs one of the user's exports now, so a new destination
+// can be checked without waiting for its cadence. A failing destination
+// answers 502 with the schedule, which carries the error.
+func (s *Server) handleRunExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	if s.exports == nil {
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: "scheduled exports are not configured"})
+		return
+	}
+	// An export that was started finishes even if the user gives up waiting
+	// for it, so its outcome is recorded.
+	schedule, err := s.exports.RunNow(context.WithoutCancel(r.Context()), userID, r.PathValue("id"))
+	switch {
+	case errors.Is(err, storage.ErrExportScheduleNotFound):
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+	case errors.Is(err, export.ErrRunning):
+		writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error()})
+	case err != nil && schedule.LastError != "":
+		writeJSON(w, http.StatusBadGateway, jsonResponse{"error": schedule.LastError, "schedule": schedule})
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		writeJSON(w, http.StatusOK, schedule)
+	}
+}
@@ -0,0 +1,104 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"a4-tasklists/server/internal/export"
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestExportSchedules(t *testing.T) {
+	store := newTestStore(t)
+	mux := http.NewServeMux()
+	NewServer(store).RegisterRoutes(mux)
+	body := []byte(`{"format":"markdown","cadence":"daily","destination":"webdav","target":"https://dav.example/lists","config":{"username":"ada","password":"pw"}}`)
+	if resp := doRequest(t, mux, http.MethodPost, "/me/exports", body); resp.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without an export key, got %d", resp.Code)
+	}
+
+	var uploaded string
+	dav := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "ada" || password != "pw" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		uploaded = r.Method + " " + r.URL.Path
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer dav.Close()
+	sealer, err := export.NewSealer("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	if err != nil {
+		t.Fatalf("sealer: %v", err)
+	}
+	mux = http.NewServeMux()
+	NewServerWithConfig(store, Config{Exports: export.New(store, sealer, export.Options{AllowPrivateTargets: true})}).RegisterRoutes(mux)
+	if resp := doRequest(t, mux, http.MethodPost, "/me/exports", []byte(`{"format":"csv","cadence":"daily","destination":"webdav","target":"https://dav.example/"}`)); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown format, got %d", resp.Code)
+	}
+	body = []byte(`{"format":"markdown","cadence":"daily","destination":"webdav","target":"` + dav.URL + `/lists","config":{"username":"ada","password":"pw"}}`)
+	resp := doRequest(t, mux, http.MethodPost, "/me/exports", body)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("create status: got %d %s", resp.Code, resp.Body.String())
+	}
+	if strings.Contains(resp.Body.String(), "pw") {
+		t.Fatalf("expected credentials not to be returned: %s", resp.Body.String())
+	}
+	var created storage.ExportSchedule
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode schedule: %v", err)
+	}
+
+	resp = doRequest(t, mux, http.MethodPost, "/me/exports/"+created.ID+"/run", nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("run status: got %d %s", resp.Code, resp.Body.String())
+	}
+	if uploaded != "PUT /lists/lists.md" {
+		t.Fatalf("unexpected upload %q", uploaded)
+	}
+
+	resp = doRequest(t, mux, http.MethodGet, "/me/exports", nil)
+	var listed struct {
+		Available bool                     `json:"available"`
+		Schedules []storage.ExportSchedule `json:"schedules"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+		t.Fatalf("decode schedules: %v", err)
+	}
+	if !listed.Available || len(listed.Schedules) != 1 || listed.Schedules[0].LastRunAt == 0 || listed.Schedules[0].LastError != "" {
+		t.Fatalf("unexpected schedules: %+v", listed)
+	}
+
+	if resp := doRequest(t, mux, http.MethodDelete, "/me/exports/"+created.ID, nil); resp.Code != http.StatusNoContent {
+		t.Fatalf("delete status: got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodPost, "/me/exports/"+created.ID+"/run", nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected a deleted schedule to be unknown, got %d", resp.Code)
+	}
+}
+
+func TestRunExportReportsDestinationErrors(t *testing.T) {
+	store := newTestStore(t)
+	dav := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer dav.Close()
+	sealer, err := export.NewSealer("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	if err != nil {
+		t.Fatalf("sealer: %v", err)
+	}
+	mux := http.NewServeMux()
+	NewServerWithConfig(store, Config{Exports: export.New(store, sealer, export.Options{AllowPrivateTargets: true})}).RegisterRoutes(mux)
+	resp := doRequest(t, mux, http.MethodPost, "/me/exports", []byte(`{"format":"json","cadence":"weekly","destination":"webdav","target":"`+dav.URL+`"}`))
+	var created storage.ExportSchedule
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode schedule: %v", err)
+	}
+	resp = doRequest(t, mux, http.MethodPost, "/me/exports/"+created.ID+"/run", nil)
+	if resp.Code != http.StatusBadGateway || !strings.Contains(resp.Body.String(), "403") {
+		t.Fatalf("expected 502 with the destination's answer, got %d %s", resp.Code, resp.Body.String())
+	}
+}
@@ -0,0 +1,32 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"a4-tasklists/server/internal/auth"
+	"a4-tasklists/server/internal/features"
+)
+
+// SetFeatures replaces the feature flags, e.g. after a configuration reload.
+// Requests in flight keep the flags they already resolved.
+func (s *Server) SetFeatures(flags *features.Flags) {
+	s.features.Store(flags)
+}
+
+func (s *Server) handleFeatures(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{"features": s.features.Load().For(userID)})
+}
+
+// featureEnabled resolves a feature flag for the request's user.
+func (s *Server) featureEnabled(r *http.Request, name string) bool {
+	userID, _ := auth.UserIDFromContext(r.Context())
+	return s.features.Load().Enabled(name, userID)
+}
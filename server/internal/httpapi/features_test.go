@@ -0,0 +1,62 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"a4-tasklists/server/internal/features"
+)
+
+func newFeatureTestMux(t *testing.T, spec string) *http.ServeMux {
+	t.Helper()
+	flags, err := features.Parse(spec)
+	if err != nil {
+		t.Fatalf("parse features: %v", err)
+	}
+	server := NewServerWithConfig(newTestStore(t), Config{Features: flags})
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	return mux
+}
+
+func TestFeaturesReportsFlagsForCurrentUser(t *testing.T) {
+	mux := newFeatureTestMux(t, "binary-transport=off,chunked-snapshot@user-1=off")
+	resp := doRequest(t, mux, http.MethodGet, "/features", nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("features status: got %d", resp.Code)
+	}
+	var payload struct {
+		Features map[string]bool `json:"features"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode features: %v", err)
+	}
+	if payload.Features[features.BinaryTransport] || payload.Features[features.ChunkedSnapshot] {
+		t.Fatalf("expected both flags off for user-1, got %v", payload.Features)
+	}
+}
+
+func TestDisabledFeaturesFallBackToPlainProtocol(t *testing.T) {
+	mux := newFeatureTestMux(t, "binary-transport=off,chunked-snapshot=off")
+
+	resp := doRequestWithHeaders(t, mux, http.MethodGet, "/sync/bootstrap?snapshot=chunked", nil, map[string]string{"Accept": contentTypeCBOR})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("bootstrap status: got %d", resp.Code)
+	}
+	if contentType := resp.Header().Get("Content-Type"); mediaType(contentType) != contentTypeJSON {
+		t.Fatalf("expected JSON response with binary transport off, got %q", contentType)
+	}
+	var payload map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode bootstrap: %v", err)
+	}
+	if _, ok := payload["snapshot"]; !ok {
+		t.Fatalf("expected inline snapshot with chunked snapshots off, got %v", payload)
+	}
+
+	resp = doRequestWithHeaders(t, mux, http.MethodPost, "/sync/push", []byte{0xa0}, map[string]string{"Content-Type": contentTypeCBOR})
+	if resp.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected CBOR push to be refused, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,258 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+
+	"a4-tasklists/server/internal/features"
+	"a4-tasklists/server/internal/graphql"
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/storage"
+)
+
+// graphRoot is the source of the top-level query fields. The materialized
+// state is loaded on first use and shared by all fields of one request.
+type graphRoot struct {
+	s      *Server
+	r      *http.Request
+	userID string
+
+	state    *materialize.State
+	archived []string
+}
+
+func (root *graphRoot) load() (materialize.State, []string, error) {
+	if root.state == nil {
+		state, err := root.s.loadState(root.r.Context(), root.userID)
+		if err != nil {
+			return materialize.State{}, nil, err
+		}
+		archived, err := root.s.store.ListArchivedLists(root.r.Context(), root.userID)
+		if err != nil {
+			return materialize.State{}, nil, err
+		}
+		root.state, root.archived = &state, archived
+	}
+	return *root.state, root.archived, nil
+}
+
+type graphList struct {
+	materialize.List
+	Archived bool
+}
+
+type graphActivity struct {
+	ServerSeq int64
+	OpCount   int
+	Lists     []graphListActivity
+}
+
+type graphListActivity struct {
+	ListID    string
+	Added     []materialize.Item
+	Completed []materialize.Item
+}
+
+var graphSchema = newGraphSchema()
+
+func newGraphSchema() graphql.Schema {
+	comment := &graphql.Object{Name: "Comment", Fields: map[string]*graphql.Field{
+		"id":    {Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(materialize.Comment).ID, nil }},
+		"text":  {Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(materialize.Comment).Text, nil }},
+		"actor": {Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(materialize.Comment).Actor, nil }},
+		"clock": {Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(materialize.Comment).Clock, nil }},
+	}}
+	item := &graphql.Object{Name: "Item", Fields: map[string]*graphql.Field{
+		"id":     {Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(materialize.Item).ID, nil }},
+		"listId": {Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(materialize.Item).ListID, nil }},
+		"text":   {Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(materialize.Item).Text, nil }},
+		"done":   {Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(materialize.Item).Done, nil }},
+		"note":   {Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(materialize.Item).Note, nil }},
+		"tags":   {Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(materialize.Item).Tags, nil }},
+		"comments": {Type: comment, Resolve: func(p graphql.ResolveParams) (any, error) {
+			if comments := p.Source.(materialize.Item).Comments; comments != nil {
+				return comments, nil
+			}
+			return []materialize.Comment{}, nil
+		}},
+	}}
+	list := &graphql.Object{Name: "List", Fields: map[string]*graphql.Field{
+		"id":        {Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(graphList).ID, nil }},
+		"title":     {Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(graphList).Title, nil }},
+		"itemCount": {Resolve: func(p graphql.ResolveParams) (any, error) { return len(p.Source.(graphList).Items), nil }},
+		"archived":  {Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(graphList).Archived, nil }},
+		"items": {Type: item, Args: []string{"done", "tag"}, Resolve: func(p graphql.ResolveParams) (any, error) {
+			return filterGraphItems(p.Source.(graphList).Items, p.Args)
+		}},
+	}}
+	tag := &graphql.Object{Name: "Tag", Fields: map[string]*graphql.Field{
+		"tag":   {Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(storage.TagCount).Tag, nil }},
+		"count": {Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(storage.TagCount).Count, nil }},
+	}}
+	listActivity := &graphql.Object{Name: "ListActivity", Fields: map[string]*graphql.Field{
+		"listId":    {Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(graphListActivity).ListID, nil }},
+		"added":     {Type: item, Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(graphListActivity).Added, nil }},
+		"completed": {Type: item, Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(graphListActivity).Completed, nil }},
+	}}
+	activity := &graphql.Object{Name: "Activity", Fields: map[string]*graphql.Field{
+		"serverSeq": {Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(graphActivity).ServerSeq, nil }},
+		"opCount":   {Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(graphActivity).OpCount, nil }},
+		"lists":     {Type: listActivity, Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(graphActivity).Lists, nil }},
+	}}
+	query := &graphql.Object{Name: "Query", Fields: map[string]*graphql.Field{
+		"lists": {Type: list, Args: []string{"archived"}, Resolve: func(p graphql.ResolveParams) (any, error) {
+			includeArchived, _, err := graphql.BoolArg(p.Args, "archived")
+			if err != nil {
+				return nil, err
+			}
+			state, archived, err := p.Source.(*graphRoot).load()
+			if err != nil {
+				return nil, err
+			}
+			lists := make([]graphList, 0, len(state.Lists))
+			for _, l := range state.Lists {
+				isArchived := slices.Contains(archived, l.ID)
+				if isArchived && !includeArchived {
+					continue
+				}
+				lists = append(lists, graphList{List: l, Archived: isArchived})
+			}
+			return lists, nil
+		}},
+		"list": {Type: list, Args: []string{"id"}, Resolve: func(p graphql.ResolveParams) (any, error) {
+			listID, ok, err := graphql.StringArg(p.Args, "id")
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, errors.New("argument \"id\" is required")
+			}
+			state, archived, err := p.Source.(*graphRoot).load()
+			if err != nil {
+				return nil, err
+			}
+			l, ok := state.FindList(listID)
+			if !ok {
+				return nil, nil
+			}
+			return graphList{List: l, Archived: slices.Contains(archived, l.ID)}, nil
+		}},
+		"items": {Type: item, Args: []string{"done", "tag"}, Resolve: func(p graphql.ResolveParams) (any, error) {
+			state, _, err := p.Source.(*graphRoot).load()
+			if err != nil {
+				return nil, err
+			}
+			return filterGraphItems(state.Items(), p.Args)
+		}},
+		"tags": {Type: tag, Resolve: func(p graphql.ResolveParams) (any, error) {
+			root := p.Source.(*graphRoot)
+			return root.s.store.ListTags(p.Context, root.userID)
+		}},
+		"activity": {Type: activity, Args: []string{"since"}, Resolve: func(p graphql.ResolveParams) (any, error) {
+			since, _, err := graphql.IntArg(p.Args, "since")
+			if err != nil {
+				return nil, err
+			}
+			if since < 0 {
+				return nil, errors.New("argument \"since\" must not be negative")
+			}
+			root := p.Source.(*graphRoot)
+			ops, serverSeq, err := root.s.store.GetOpsSince(p.Context, root.userID, since)
+			if err != nil {
+				return nil, err
+			}
+			state, _, err := root.load()
+			if err != nil {
+				return nil, err
+			}
+			items := make(map[string]materialize.Item)
+			for _, it := range state.Items() {
+				items[it.ID] = it
+			}
+			present := func(ids []string) []materialize.Item {
+				found := make([]materialize.Item, 0, len(ids))
+				for _, id := range ids {
+					if it, ok := items[id]; ok {
+						found = append(found, it)
+					}
+				}
+				return found
+			}
+			result := graphActivity{ServerSeq: serverSeq, OpCount: len(ops), Lists: make([]graphListActivity, 0)}
+			for _, changed := range materialize.Activity(ops) {
+				result.Lists = append(result.Lists, graphListActivity{
+					ListID:    changed.ListID,
+					Added:     present(changed.Added),
+					Completed: present(changed.Completed),
+				})
+			}
+			return result, nil
+		}},
+	}}
+	return graphql.Schema{Query: query}
+}
+
+// filterGraphItems applies the done and tag arguments shared by item fields.
+func filterGraphItems(items []materialize.Item, args map[string]any) ([]materialize.Item, error) {
+	done, filterDone, err := graphql.BoolArg(args, "done")
+	if err != nil {
+		return nil, err
+	}
+	tag, _, err := graphql.StringArg(args, "tag")
+	if err != nil {
+		return nil, err
+	}
+	tag = storage.NormalizeTag(tag)
+	return slices.DeleteFunc(slices.Clone(items), func(it materialize.Item) bool {
+		return (filterDone && it.Done != done) || (tag != "" && !slices.Contains(it.Tags, tag))
+	}), nil
+}
+
+// handleGraphQL serves read-only GraphQL queries over the materialized state,
+// as POST {query, operationName, variables} or GET ?query=.
+func (s *Server) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req graphql.Request
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		req.Query = query.Get("query")
+		req.OperationName = query.Get("operationName")
+		if variables := query.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				writeJSON(w, http.StatusBadRequest, errorResponse{Error: "variables must be a JSON object"})
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := decodeJSON(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	default:
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	if !s.featureEnabled(r, features.GraphQL) {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "graphql is not enabled"})
+		return
+	}
+	if req.Query == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "query is required"})
+		return
+	}
+	response := graphql.Execute(r.Context(), graphSchema, &graphRoot{s: s, r: r, userID: userID}, req)
+	status := http.StatusOK
+	if response.Data == nil {
+		status = http.StatusBadRequest
+	} else if len(response.Errors) > 0 {
+		log.Printf("graphql field errors=%d first=%q", len(response.Errors), response.Errors[0].Message)
+	}
+	writeJSON(w, status, response)
+}
@@ -0,0 +1,61 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGraphQLQueriesMaterializedState(t *testing.T) {
+	mux := newTestMux(t)
+	resp := doRequest(t, mux, http.MethodPost, "/lists", []byte(`{"title":"Groceries","items":[{"text":"milk"},{"text":"eggs"}]}`))
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("create list status: got %d", resp.Code)
+	}
+	var created struct {
+		ListID  string   `json:"listId"`
+		ItemIDs []string `json:"itemIds"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode create list: %v", err)
+	}
+	if resp := doRequest(t, mux, http.MethodPatch, "/items/"+created.ItemIDs[0], []byte(`{"done":true}`)); resp.Code != http.StatusOK {
+		t.Fatalf("patch status: got %d", resp.Code)
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"query": `query Open($id: ID!) {
+			list(id: $id) { title itemCount open: items(done: false) { text } }
+			activity(since: 0) { opCount lists { completed { text } } }
+			missing: list(id: "nope") { title }
+		}`,
+		"variables": map[string]any{"id": created.ListID},
+	})
+	resp = doRequest(t, mux, http.MethodPost, "/graphql", body)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("graphql status: got %d body=%s", resp.Code, resp.Body.String())
+	}
+	var got map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("decode graphql: %v", err)
+	}
+	encoded, _ := json.Marshal(got)
+	want := `{"data":{"activity":{"lists":[{"completed":[{"text":"milk"}]}],"opCount":4},"list":{"itemCount":2,"open":[{"text":"eggs"}],"title":"Groceries"},"missing":null}}`
+	if string(encoded) != want {
+		t.Fatalf("got %s\nwant %s", encoded, want)
+	}
+
+	resp = doRequest(t, mux, http.MethodPost, "/graphql", []byte(`{"query":"mutation { lists { id } }"}`))
+	if resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "mutation operations are not supported") {
+		t.Fatalf("expected mutation to be rejected, got %d %s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestGraphQLIsGatedByFeatureFlag(t *testing.T) {
+	mux := newFeatureTestMux(t, "graphql=off")
+	resp := doRequest(t, mux, http.MethodGet, "/graphql?query=%7Blists%7Bid%7D%7D", nil)
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 with graphql off, got %d", resp.Code)
+	}
+}
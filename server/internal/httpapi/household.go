@@ -0,0 +1,240 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"a4-tasklists/server/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// Household members are restricted accounts, such as a child's, that a user
+// creates for people without a login of their own. A member's devices are
+// paired from the user's browser and get tokens that only reach the member's
+// lists: the items there can be read, added and edited, nothing else.
+
+type householdMemberContextKey struct{}
+
+// memberTokenScopes are the scopes of tokens issued to household members.
+// memberAllows decides what those tokens reach; the scopes only describe it.
+var memberTokenScopes = []string{storage.TokenScopeRead, storage.TokenScopeAdd, storage.TokenScopeComplete}
+
+// householdMember returns the member whose token authenticated the request.
+func householdMember(r *http.Request) (storage.HouseholdMember, bool) {
+	member, ok := r.Context().Value(householdMemberContextKey{}).(storage.HouseholdMember)
+	return member, ok
+}
+
+// memberAllows checks a household member's token against a request: the
+// member's own view, and reading and changing items of the member's lists.
+// Items are changed through PATCH /items/{id}, whose handler checks the list.
+func memberAllows(member storage.HouseholdMember, r *http.Request) error {
+	path := r.URL.Path
+	if path == "/household/me" && r.Method == http.MethodGet {
+		return nil
+	}
+	if strings.HasPrefix(path, "/items/") && r.Method == http.MethodPatch {
+		return nil
+	}
+	if listID, ok := strings.CutSuffix(strings.TrimPrefix(path, "/lists/"), "/items"); ok && strings.HasPrefix(path, "/lists/") && !strings.Contains(listID, "/") {
+		if slices.Contains(member.ListIDs, listID) {
+			return nil
+		}
+		return fmt.Errorf("list %s is not shared with %s", listID, member.Name)
+	}
+	return fmt.Errorf("household members cannot use %s %s", r.Method, path)
+}
+
+// withHouseholdMember loads the member of a member token and checks the
+// request against it, answering 401 or 403 itself.
+func (s *Server) withHouseholdMember(w http.ResponseWriter, r *http.Request, token storage.APIToken) (context.Context, bool) {
+	member, err := s.store.GetHouseholdMember(r.Context(), token.UserID, token.MemberID)
+	if errors.Is(err, storage.ErrHouseholdMemberNotFound) {
+		writeJSON(w, http.StatusUnauthorized, errorResponse{Error: err.Error()})
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	if err := memberAllows(member, r); err != nil {
+		writeJSON(w, http.StatusForbidden, errorResponse{Error: err.Error()})
+		return nil, false
+	}
+	return context.WithValue(r.Context(), householdMemberContextKey{}, member), true
+}
+
+// handleHouseholdMembers lists (GET) or creates (POST {name, listIds}) the
+// user's household members.
+func (s *Server) handleHouseholdMembers(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		members, err := s.store.ListHouseholdMembers(r.Context(), userID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, jsonResponse{"members": members})
+	case http.MethodPost:
+		member, ok := s.decodeHouseholdMember(w, r, userID)
+		if !ok {
+			return
+		}
+		member.ID = "member-" + uuid.NewString()
+		member.CreatedAt = time.Now().Unix()
+		if err := s.store.CreateHouseholdMember(r.Context(), userID, member); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, jsonResponse{"member": member})
+	default:
+		methodNotAllowed(w)
+	}
+}
+
+// handleHouseholdMember replaces (PUT {name, listIds}) or deletes one of the
+// user's household members. Deleting a member revokes its tokens.
+func (s *Server) handleHouseholdMember(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	memberID := r.PathValue("id")
+	switch r.Method {
+	case http.MethodPut:
+		member, ok := s.decodeHouseholdMember(w, r, userID)
+		if !ok {
+			return
+		}
+		member.ID = memberID
+		err := s.store.UpdateHouseholdMember(r.Context(), userID, member)
+		if errors.Is(err, storage.ErrHouseholdMemberNotFound) {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		member, err = s.store.GetHouseholdMember(r.Context(), userID, memberID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, jsonResponse{"member": member})
+	case http.MethodDelete:
+		err := s.store.DeleteHouseholdMember(r.Context(), userID, memberID)
+		if errors.Is(err, storage.ErrHouseholdMemberNotFound) {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w)
+	}
+}
+
+// decodeHouseholdMember reads {name, listIds}, checking that every list
+// exists. It answers 400 itself.
+func (s *Server) decodeHouseholdMember(w http.ResponseWriter, r *http.Request, userID string) (storage.HouseholdMember, bool) {
+	var payload struct {
+		Name    string   `json:"name"`
+		ListIDs []string `json:"listIds"`
+	}
+	if err := decodeJSON(r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return storage.HouseholdMember{}, false
+	}
+	member := storage.HouseholdMember{Name: strings.TrimSpace(payload.Name), ListIDs: []string{}}
+	if member.Name == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "name is required"})
+		return storage.HouseholdMember{}, false
+	}
+	state, err := s.loadState(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return storage.HouseholdMember{}, false
+	}
+	for _, listID := range payload.ListIDs {
+		if _, ok := state.FindList(listID); !ok {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "unknown list " + listID})
+			return storage.HouseholdMember{}, false
+		}
+		if !slices.Contains(member.ListIDs, listID) {
+			member.ListIDs = append(member.ListIDs, listID)
+		}
+	}
+	return member, true
+}
+
+// handleHouseholdMemberPairing issues a pairing code (POST) whose token
+// belongs to the household member, so the member's device is set up from the
+// user's browser.
+func (s *Server) handleHouseholdMemberPairing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	member, err := s.store.GetHouseholdMember(r.Context(), userID, r.PathValue("id"))
+	if errors.Is(err, storage.ErrHouseholdMemberNotFound) {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.writePairing(w, r, pairing{userID: userID, name: member.Name, scopes: memberTokenScopes, memberID: member.ID})
+}
+
+// handleHouseholdMe shows a household member's device who it is and which
+// lists it can use.
+func (s *Server) handleHouseholdMe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	member, ok := householdMember(r)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "only household member tokens have a household view"})
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	state, err := s.loadState(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	type memberList struct {
+		ID    string `json:"id"`
+		Title string `json:"title"`
+	}
+	lists := make([]memberList, 0, len(member.ListIDs))
+	for _, listID := range member.ListIDs {
+		if list, ok := state.FindList(listID); ok {
+			lists = append(lists, memberList{ID: list.ID, Title: list.Title})
+		}
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{"member": member, "lists": lists})
+}
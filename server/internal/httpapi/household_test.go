@@ -0,0 +1,142 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestHouseholdMembersOnlyReachTheirLists(t *testing.T) {
+	store := newTestStore(t)
+	server := NewServer(store)
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	sessions := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := server.WithAPITokens(mux, sessions)
+
+	createList := func(title string) string {
+		t.Helper()
+		resp := doRequest(t, mux, http.MethodPost, "/lists", []byte(`{"title":"`+title+`","items":[{"text":"first"}]}`))
+		var created struct {
+			ListID  string   `json:"listId"`
+			ItemIDs []string `json:"itemIds"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+			t.Fatalf("decode list: %v", err)
+		}
+		return created.ListID
+	}
+	chores, groceries := createList("Chores"), createList("Groceries")
+
+	if resp := doRequest(t, mux, http.MethodPost, "/household/members", []byte(`{"name":"Sam","listIds":["nope"]}`)); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected an unknown list to be rejected, got %d", resp.Code)
+	}
+	resp := doRequest(t, mux, http.MethodPost, "/household/members", []byte(`{"name":"Sam","listIds":["`+chores+`","`+chores+`"]}`))
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("create member: %d %s", resp.Code, resp.Body.String())
+	}
+	var created struct {
+		Member storage.HouseholdMember `json:"member"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode member: %v", err)
+	}
+	member := created.Member
+	if len(member.ListIDs) != 1 || member.ListIDs[0] != chores {
+		t.Fatalf("unexpected member %+v", member)
+	}
+
+	// The member's device is paired from the browser.
+	resp = doRequest(t, mux, http.MethodPost, "/household/members/"+member.ID+"/pairing", nil)
+	var pairing struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pairing); err != nil || resp.Code != http.StatusCreated {
+		t.Fatalf("pair member: %d %s", resp.Code, resp.Body.String())
+	}
+	resp = doRequest(t, mux, http.MethodPost, "/auth/pairing/redeem", []byte(`{"code":"`+pairing.Code+`"}`))
+	var redeemed struct {
+		Token     storage.APIToken `json:"token"`
+		Secret    string           `json:"secret"`
+		Bootstrap json.RawMessage  `json:"bootstrap"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&redeemed); err != nil || resp.Code != http.StatusOK {
+		t.Fatalf("redeem: %d %s", resp.Code, resp.Body.String())
+	}
+	if redeemed.Token.MemberID != member.ID || redeemed.Token.Name != "Sam" || redeemed.Bootstrap != nil {
+		t.Fatalf("unexpected member token %+v (bootstrap %s)", redeemed.Token, redeemed.Bootstrap)
+	}
+
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		req.Header.Set("Authorization", "Bearer "+redeemed.Secret)
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+	resp = call(http.MethodGet, "/household/me", "")
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `"title": "Chores"`) || strings.Contains(resp.Body.String(), "Groceries") {
+		t.Fatalf("unexpected household view: %d %s", resp.Code, resp.Body.String())
+	}
+	resp = call(http.MethodGet, "/lists/"+chores+"/items", "")
+	var items struct {
+		Items []struct {
+			ID string `json:"id"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil || resp.Code != http.StatusOK || len(items.Items) != 1 {
+		t.Fatalf("read chores: %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := call(http.MethodPost, "/lists/"+chores+"/items", `{"items":[{"text":"feed the cat"}]}`); resp.Code != http.StatusCreated {
+		t.Fatalf("add to chores: %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := call(http.MethodPatch, "/items/"+items.Items[0].ID, `{"listId":"`+chores+`","done":true}`); resp.Code != http.StatusOK {
+		t.Fatalf("check off chore: %d %s", resp.Code, resp.Body.String())
+	}
+	for _, denied := range []struct{ method, path, body string }{
+		{http.MethodGet, "/lists/" + groceries + "/items", ""},
+		{http.MethodPatch, "/items/" + items.Items[0].ID, `{"done":false}`},
+		{http.MethodGet, "/lists", ""},
+		{http.MethodGet, "/sync/bootstrap", ""},
+		{http.MethodPost, "/household/members", `{"name":"Me"}`},
+		{http.MethodGet, "/auth/tokens", ""},
+		{http.MethodGet, "/dav/lists.md", ""},
+	} {
+		if resp := call(denied.method, denied.path, denied.body); resp.Code != http.StatusForbidden {
+			t.Fatalf("expected %s %s to be refused, got %d %s", denied.method, denied.path, resp.Code, resp.Body.String())
+		}
+	}
+
+	// Sharing another list takes effect on the next request.
+	if resp := doRequest(t, mux, http.MethodPut, "/household/members/"+member.ID, []byte(`{"name":"Sam","listIds":["`+chores+`","`+groceries+`"]}`)); resp.Code != http.StatusOK {
+		t.Fatalf("update member: %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := call(http.MethodGet, "/lists/"+groceries+"/items", ""); resp.Code != http.StatusOK {
+		t.Fatalf("expected the newly shared list to be readable, got %d", resp.Code)
+	}
+
+	// Deleting the member revokes its devices.
+	if resp := doRequest(t, mux, http.MethodDelete, "/household/members/"+member.ID, nil); resp.Code != http.StatusNoContent {
+		t.Fatalf("delete member: %d", resp.Code)
+	}
+	if resp := call(http.MethodGet, "/household/me", ""); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a deleted member's token to be rejected, got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodDelete, "/household/members/"+member.ID, nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected deleting twice to be 404, got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodGet, "/household/me", nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected a session to have no household view, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,172 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"a4-tasklists/server/internal/importer"
+	"a4-tasklists/server/internal/materialize"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// maxImportBytes caps an uploaded export. Takeout zips hold attachments
+	// too, which are skipped but still uploaded.
+	maxImportBytes = 32 << 20
+	// maxImportItems caps how many items one import may create.
+	maxImportItems = 10000
+)
+
+// importedList is a list of an import preview or result.
+type importedList struct {
+	// ListID is the replaced list, or the new one once it was created.
+	ListID string `json:"listId,omitempty"`
+	Title  string `json:"title"`
+	// Action is "create" or "replace".
+	Action    string `json:"action"`
+	ItemCount int    `json:"itemCount"`
+	// RemovedItemCount counts the items a replace removes.
+	RemovedItemCount int             `json:"removedItemCount,omitempty"`
+	Items            []importer.Item `json:"items,omitempty"`
+}
+
+// handleImport turns an export of another app (POST body: the file) into
+// lists; ?strategy= decides what happens to lists whose title the user
+// already has. With ?dryRun=true it only answers what would be done, so the
+// user can check the result before committing it.
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	format, err := importer.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	strategy, err := importer.ParseStrategy(r.URL.Query().Get("strategy"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err != nil {
+		writeJSON(w, http.StatusRequestEntityTooLarge, errorResponse{Error: fmt.Sprintf("exports are limited to %d MiB", maxImportBytes>>20)})
+		return
+	}
+	lists, format, err := importer.Parse(format, data)
+	if errors.Is(err, importer.ErrTooLarge) {
+		writeJSON(w, http.StatusRequestEntityTooLarge, errorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("read export: %v", err)})
+		return
+	}
+	if len(lists) == 0 {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "the export holds no notes or reminders"})
+		return
+	}
+	itemCount := 0
+	for _, list := range lists {
+		itemCount += len(list.Items)
+	}
+	if itemCount > maxImportItems {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("at most %d items per import", maxImportItems)})
+		return
+	}
+
+	g, release, err := s.newGenerator(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer release()
+	existing := make([]importer.Existing, 0)
+	for _, list := range g.State().Lists {
+		existing = append(existing, importer.Existing{ID: list.ID, Title: list.Title})
+	}
+	targets := importer.Plan(strategy, existing, lists)
+	imported := make([]importedList, 0, len(lists))
+	for i, list := range lists {
+		result, err := importList(g, list, targets[i])
+		if err != nil {
+			writeGenerateError(w, err)
+			return
+		}
+		imported = append(imported, result)
+	}
+
+	if r.URL.Query().Get("dryRun") == "true" {
+		// New list ids are made up again on the real run.
+		for i := range imported {
+			if imported[i].Action == "create" {
+				imported[i].ListID = ""
+			}
+		}
+		writeJSON(w, http.StatusOK, jsonResponse{
+			"format":    format,
+			"strategy":  strategy,
+			"lists":     imported,
+			"itemCount": itemCount,
+			"opCount":   len(g.Ops()),
+		})
+		return
+	}
+	serverSeq, err := s.insertServerOps(r.Context(), userID, g.Ops())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	for i := range imported {
+		imported[i].Items = nil
+	}
+	log.Printf("import applied format=%s strategy=%s lists=%d items=%d ops=%d", format, strategy, len(imported), itemCount, len(g.Ops()))
+	writeJSON(w, http.StatusCreated, jsonResponse{
+		"format":    format,
+		"strategy":  strategy,
+		"lists":     imported,
+		"itemCount": itemCount,
+		"opCount":   len(g.Ops()),
+		"serverSeq": serverSeq,
+	})
+}
+
+// importList generates the ops that put list at target: a new list, or the
+// replaced list with its visible items removed first.
+func importList(g *materialize.Generator, list importer.List, target importer.Target) (importedList, error) {
+	result := importedList{Title: target.Title, Action: "create", ItemCount: len(list.Items), Items: list.Items}
+	if target.ReplaceListID != "" {
+		existing, ok := g.State().FindList(target.ReplaceListID)
+		if !ok {
+			return importedList{}, materialize.ErrListNotFound
+		}
+		for _, item := range existing.Items {
+			if err := g.RemoveItem(existing.ID, item.ID); err != nil {
+				return importedList{}, err
+			}
+		}
+		result.ListID, result.Title, result.Action, result.RemovedItemCount = existing.ID, existing.Title, "replace", len(existing.Items)
+	} else {
+		result.ListID = "list-" + uuid.NewString()
+		if err := g.CreateList(result.ListID, target.Title); err != nil {
+			return importedList{}, err
+		}
+	}
+	items := make([]materialize.NewItem, 0, len(list.Items))
+	for _, item := range list.Items {
+		items = append(items, materialize.NewItem{ID: "task-" + uuid.NewString(), Text: item.Text, Note: item.Note, Done: item.Done, Tags: item.Tags})
+	}
+	if err := g.AppendItems(result.ListID, items); err != nil {
+		return importedList{}, err
+	}
+	return result, nil
+}
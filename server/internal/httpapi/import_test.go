@@ -0,0 +1,136 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+const keepNoteJSON = `{"title":"Groceries","listContent":[{"text":"Milk","isChecked":false},{"text":"Eggs","isChecked":true}],"labels":[{"name":"Food"}],"isTrashed":false}`
+
+func TestImportPreviewsThenCreatesLists(t *testing.T) {
+	mux := newTestMux(t)
+	type result struct {
+		Format string `json:"format"`
+		Lists  []struct {
+			ListID    string `json:"listId"`
+			Title     string `json:"title"`
+			ItemCount int    `json:"itemCount"`
+			Items     []struct {
+				Text string   `json:"text"`
+				Done bool     `json:"done"`
+				Tags []string `json:"tags"`
+			} `json:"items"`
+		} `json:"lists"`
+		OpCount int `json:"opCount"`
+	}
+
+	resp := doRequest(t, mux, http.MethodPost, "/import?dryRun=true", []byte(keepNoteJSON))
+	var preview result
+	if err := json.NewDecoder(resp.Body).Decode(&preview); err != nil || resp.Code != http.StatusOK {
+		t.Fatalf("preview: %d %v %s", resp.Code, err, resp.Body.String())
+	}
+	// createList, two inserts, and an addTag per item.
+	if preview.Format != "keep" || len(preview.Lists) != 1 || preview.Lists[0].ListID != "" || len(preview.Lists[0].Items) != 2 || !preview.Lists[0].Items[1].Done || preview.OpCount != 5 {
+		t.Fatalf("unexpected preview: %+v", preview)
+	}
+	if lists := listTitles(t, mux); len(lists) != 0 {
+		t.Fatalf("expected the preview to create nothing, got %v", lists)
+	}
+
+	resp = doRequest(t, mux, http.MethodPost, "/import", []byte(keepNoteJSON))
+	var created result
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil || resp.Code != http.StatusCreated || len(created.Lists) != 1 || created.Lists[0].ListID == "" {
+		t.Fatalf("import: %d %v %s", resp.Code, err, resp.Body.String())
+	}
+	if lists := listTitles(t, mux); len(lists) != 1 || lists[0] != "Groceries" {
+		t.Fatalf("expected the imported list, got %v", lists)
+	}
+	resp = doRequest(t, mux, http.MethodGet, "/items?tag=food", nil)
+	var tagged struct {
+		Items []struct {
+			Text string `json:"text"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tagged); err != nil || len(tagged.Items) != 2 {
+		t.Fatalf("expected both items tagged with the label, got %v %+v", err, tagged)
+	}
+}
+
+func TestImportRejectsUnknownExports(t *testing.T) {
+	mux := newTestMux(t)
+	if resp := doRequest(t, mux, http.MethodPost, "/import", []byte("hello")); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unrecognized data, got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodPost, "/import?format=evernote", []byte(keepNoteJSON)); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown format, got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodPost, "/import?format=reminders", []byte(keepNoteJSON)); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a Keep note read as reminders, got %d", resp.Code)
+	}
+}
+
+// listTitles returns the titles of the visible lists.
+func listTitles(t *testing.T, mux *http.ServeMux) []string {
+	t.Helper()
+	resp := doRequest(t, mux, http.MethodGet, "/lists", nil)
+	var payload struct {
+		Lists []struct {
+			Title string `json:"title"`
+		} `json:"lists"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode lists: %v", err)
+	}
+	titles := make([]string, 0, len(payload.Lists))
+	for _, list := range payload.Lists {
+		titles = append(titles, list.Title)
+	}
+	return titles
+}
+
+func TestImportStrategies(t *testing.T) {
+	mux := newTestMux(t)
+	doRequest(t, mux, http.MethodPost, "/lists", []byte(`{"title":"Groceries","items":[{"text":"Bread"},{"text":"Butter"}]}`))
+	type result struct {
+		Lists []struct {
+			ListID           string `json:"listId"`
+			Title            string `json:"title"`
+			Action           string `json:"action"`
+			RemovedItemCount int    `json:"removedItemCount"`
+		} `json:"lists"`
+	}
+	importWith := func(query string) result {
+		t.Helper()
+		resp := doRequest(t, mux, http.MethodPost, "/import?"+query, []byte(keepNoteJSON))
+		var decoded result
+		if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil || len(decoded.Lists) != 1 {
+			t.Fatalf("import %s: %d %v %s", query, resp.Code, err, resp.Body.String())
+		}
+		return decoded
+	}
+
+	if got := importWith("strategy=suffix&dryRun=true"); got.Lists[0].Title != "Groceries (2)" || got.Lists[0].Action != "create" {
+		t.Fatalf("expected a numbered title, got %+v", got)
+	}
+	preview := importWith("strategy=replace&dryRun=true")
+	if preview.Lists[0].Action != "replace" || preview.Lists[0].ListID == "" || preview.Lists[0].RemovedItemCount != 2 {
+		t.Fatalf("expected the existing list to be replaced, got %+v", preview)
+	}
+	importWith("strategy=replace")
+	if lists := listTitles(t, mux); len(lists) != 1 {
+		t.Fatalf("expected no new list, got %v", lists)
+	}
+	resp := doRequest(t, mux, http.MethodGet, "/lists/"+preview.Lists[0].ListID+"/items", nil)
+	var items struct {
+		Items []struct {
+			Text string `json:"text"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil || len(items.Items) != 2 || items.Items[0].Text != "Milk" {
+		t.Fatalf("expected the imported items only, got %v %+v", err, items)
+	}
+	if resp := doRequest(t, mux, http.MethodPost, "/import?strategy=merge", []byte(keepNoteJSON)); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown strategy, got %d", resp.Code)
+	}
+}
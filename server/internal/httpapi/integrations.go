@@ -0,0 +1,233 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/storage"
+)
+
+// Integration routes follow the conventions of automation services such as
+// Zapier and IFTTT: API-key (bearer token) auth, polling triggers that return
+// a bare JSON array newest first with a unique id per entry, and actions that
+// return the created object. They authenticate with API tokens.
+
+// maxTriggerEntries caps a polling trigger response; automation services only
+// look for entries they have not seen since their last poll.
+const maxTriggerEntries = 50
+
+// integrationItem is an item as returned to automation services. ID is unique
+// per trigger event, so an item completed twice triggers twice.
+type integrationItem struct {
+	ID        string   `json:"id"`
+	ItemID    string   `json:"itemId"`
+	ListID    string   `json:"listId"`
+	ListTitle string   `json:"listTitle"`
+	Text      string   `json:"text"`
+	Note      string   `json:"note"`
+	Done      bool     `json:"done"`
+	Tags      []string `json:"tags"`
+	ServerSeq int64    `json:"serverSeq,omitempty"`
+}
+
+func newIntegrationItem(item materialize.Item, listTitle string) integrationItem {
+	if item.Tags == nil {
+		item.Tags = []string{}
+	}
+	return integrationItem{
+		ID:        item.ID,
+		ItemID:    item.ID,
+		ListID:    item.ListID,
+		ListTitle: listTitle,
+		Text:      item.Text,
+		Note:      item.Note,
+		Done:      item.Done,
+		Tags:      item.Tags,
+	}
+}
+
+// integrationToken authenticates an integration request and checks that the
+// token carries scope.
+func (s *Server) integrationToken(w http.ResponseWriter, r *http.Request, method string, scope string) (storage.APIToken, bool) {
+	if r.Method != method {
+		methodNotAllowed(w)
+		return storage.APIToken{}, false
+	}
+	token, ok := s.apiToken(w, r)
+	if !ok {
+		return storage.APIToken{}, false
+	}
+	if scope != "" && !token.HasScope(scope) {
+		writeJSON(w, http.StatusForbidden, errorResponse{Error: fmt.Sprintf("token lacks the %s scope", scope)})
+		return storage.APIToken{}, false
+	}
+	return token, true
+}
+
+// handleIntegrationMe identifies the token, which automation services call to
+// test a connection and label it.
+func (s *Server) handleIntegrationMe(w http.ResponseWriter, r *http.Request) {
+	token, ok := s.integrationToken(w, r, http.MethodGet, "")
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{"id": token.ID, "name": token.Name, "scopes": token.Scopes})
+}
+
+// handleIntegrationLists returns the lists (not archived) for list pickers.
+func (s *Server) handleIntegrationLists(w http.ResponseWriter, r *http.Request) {
+	token, ok := s.integrationToken(w, r, http.MethodGet, storage.TokenScopeRead)
+	if !ok {
+		return
+	}
+	state, err := s.loadState(r.Context(), token.UserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	archived, err := s.store.ListArchivedLists(r.Context(), token.UserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	type integrationList struct {
+		ID    string `json:"id"`
+		Title string `json:"title"`
+	}
+	lists := make([]integrationList, 0, len(state.Lists))
+	for _, list := range state.Lists {
+		if !slices.Contains(archived, list.ID) && token.AllowsList(list.ID) {
+			lists = append(lists, integrationList{ID: list.ID, Title: list.Title})
+		}
+	}
+	writeJSON(w, http.StatusOK, lists)
+}
+
+// handleItemTrigger serves the new-items and completed-items polling triggers
+// (?listId= narrows to one list). Entries come from the op log, so only
+// events since the last compaction are reported, and only for items that
+// still exist (and, for completions, are still done).
+func (s *Server) handleItemTrigger(w http.ResponseWriter, r *http.Request) {
+	token, ok := s.integrationToken(w, r, http.MethodGet, storage.TokenScopeRead)
+	if !ok {
+		return
+	}
+	var kind string
+	switch r.PathValue("trigger") {
+	case "new-items":
+		kind = materialize.ItemAdded
+	case "completed-items":
+		kind = materialize.ItemCompleted
+	default:
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "unknown trigger"})
+		return
+	}
+	listID := r.URL.Query().Get("listId")
+	if listID != "" && !token.AllowsList(listID) {
+		writeJSON(w, http.StatusForbidden, errorResponse{Error: "token is restricted to list " + token.ListID})
+		return
+	}
+	if token.ListID != "" {
+		listID = token.ListID
+	}
+	snapshot, err := s.store.GetSnapshot(r.Context(), token.UserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	ops, _, err := s.store.GetOpsSince(r.Context(), token.UserID, 0)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	state, err := s.quarantine.Build(r.Context(), token.UserID, snapshot.Blob, ops)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("materialize state: %w", err))
+		return
+	}
+	items := make(map[string]integrationItem)
+	for _, list := range state.Lists {
+		for _, item := range list.Items {
+			items[item.ID] = newIntegrationItem(item, list.Title)
+		}
+	}
+	events := materialize.ItemEvents(ops)
+	entries := make([]integrationItem, 0)
+	seen := make(map[string]struct{})
+	for i := len(events) - 1; i >= 0 && len(entries) < maxTriggerEntries; i-- {
+		event := events[i]
+		if event.Kind != kind || (listID != "" && event.ListID != listID) {
+			continue
+		}
+		item, ok := items[event.ItemID]
+		if !ok || item.ListID != event.ListID || (kind == materialize.ItemCompleted && !item.Done) {
+			continue
+		}
+		if kind == materialize.ItemCompleted {
+			item.ID = fmt.Sprintf("%s-%d", item.ItemID, event.ServerSeq)
+		}
+		if _, dup := seen[item.ID]; dup {
+			continue
+		}
+		seen[item.ID] = struct{}{}
+		item.ServerSeq = event.ServerSeq
+		entries = append(entries, item)
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// handleCreateItemAction appends one item to a list (POST {listId, text,
+// note}) and returns it.
+func (s *Server) handleCreateItemAction(w http.ResponseWriter, r *http.Request) {
+	token, ok := s.integrationToken(w, r, http.MethodPost, storage.TokenScopeAdd)
+	if !ok {
+		return
+	}
+	var payload struct {
+		ListID string `json:"listId"`
+		Text   string `json:"text"`
+		Note   string `json:"note"`
+	}
+	if err := decodeJSON(r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	payload.Text = strings.TrimSpace(payload.Text)
+	if payload.ListID == "" || payload.Text == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "listId and text are required"})
+		return
+	}
+	if !token.AllowsList(payload.ListID) {
+		writeJSON(w, http.StatusForbidden, errorResponse{Error: "token is restricted to list " + token.ListID})
+		return
+	}
+	itemIDs, _, serverSeq, err := s.appendItems(r.Context(), token.UserID, payload.ListID, []restItem{{Text: payload.Text, Note: payload.Note}})
+	if errors.Is(err, materialize.ErrListNotFound) {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	state, err := s.loadState(r.Context(), token.UserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	list, _ := state.FindList(payload.ListID)
+	i := slices.IndexFunc(list.Items, func(item materialize.Item) bool { return item.ID == itemIDs[0] })
+	if i < 0 {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("created item %s not found", itemIDs[0]))
+		return
+	}
+	item := newIntegrationItem(list.Items[i], list.Title)
+	item.ServerSeq = serverSeq
+	log.Printf("integration item created token=%s list=%s", token.ID, payload.ListID)
+	writeJSON(w, http.StatusCreated, item)
+}
@@ -0,0 +1,88 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestIntegrationTriggersAndActions(t *testing.T) {
+	mux := newTestMux(t)
+	resp := doRequest(t, mux, http.MethodPost, "/lists", []byte(`{"title":"Groceries","items":[{"text":"milk"},{"text":"eggs"}]}`))
+	var list struct {
+		ListID  string   `json:"listId"`
+		ItemIDs []string `json:"itemIds"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("decode create list: %v", err)
+	}
+	resp = doRequest(t, mux, http.MethodPost, "/auth/tokens", []byte(`{"name":"Zapier","scopes":["read"]}`))
+	var created struct {
+		Secret string `json:"secret"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode token: %v", err)
+	}
+	call := func(method string, path string, body string, target any) int {
+		t.Helper()
+		var payload []byte
+		if body != "" {
+			payload = []byte(body)
+		}
+		resp := doRequestWithHeaders(t, mux, method, path, payload, map[string]string{"Authorization": "Bearer " + created.Secret})
+		if target != nil && resp.Code < 300 {
+			if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
+				t.Fatalf("decode %s: %v", path, err)
+			}
+		}
+		return resp.Code
+	}
+
+	var me struct {
+		Name string `json:"name"`
+	}
+	if code := call(http.MethodGet, "/integrations/me", "", &me); code != http.StatusOK || me.Name != "Zapier" {
+		t.Fatalf("unexpected auth test: %d %+v", code, me)
+	}
+	if resp := doRequest(t, mux, http.MethodGet, "/integrations/me", nil); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", resp.Code)
+	}
+
+	var added []integrationItem
+	if code := call(http.MethodGet, "/integrations/triggers/new-items?listId="+list.ListID, "", &added); code != http.StatusOK {
+		t.Fatalf("new-items status: got %d", code)
+	}
+	if len(added) != 2 || added[0].Text != "eggs" || added[0].ID != list.ItemIDs[1] || added[0].ListTitle != "Groceries" {
+		t.Fatalf("expected newest item first, got %+v", added)
+	}
+
+	if resp := doRequest(t, mux, http.MethodPatch, "/items/"+list.ItemIDs[0], []byte(`{"done":true}`)); resp.Code != http.StatusOK {
+		t.Fatalf("complete item status: got %d", resp.Code)
+	}
+	var completed []integrationItem
+	call(http.MethodGet, "/integrations/triggers/completed-items", "", &completed)
+	if len(completed) != 1 || completed[0].ItemID != list.ItemIDs[0] || completed[0].ID == completed[0].ItemID || !completed[0].Done {
+		t.Fatalf("unexpected completed items: %+v", completed)
+	}
+	if code := call(http.MethodGet, "/integrations/triggers/deleted-items", "", nil); code != http.StatusNotFound {
+		t.Fatalf("expected unknown trigger to be 404, got %d", code)
+	}
+
+	if code := call(http.MethodPost, "/integrations/actions/create-item", `{"listId":"`+list.ListID+`","text":"bread"}`, nil); code != http.StatusForbidden {
+		t.Fatalf("expected create-item to need the add scope, got %d", code)
+	}
+	resp = doRequest(t, mux, http.MethodPost, "/auth/tokens", []byte(`{"name":"Zapier","scopes":["add"]}`))
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode token: %v", err)
+	}
+	var item integrationItem
+	if code := call(http.MethodPost, "/integrations/actions/create-item", `{"listId":"`+list.ListID+`","text":"bread","note":"rye"}`, &item); code != http.StatusCreated {
+		t.Fatalf("create-item status: got %d", code)
+	}
+	if item.ID == "" || item.Text != "bread" || item.Note != "rye" || item.ListTitle != "Groceries" {
+		t.Fatalf("unexpected created item: %+v", item)
+	}
+	if code := call(http.MethodPost, "/integrations/actions/create-item", `{"listId":"nope","text":"bread"}`, nil); code != http.StatusNotFound {
+		t.Fatalf("expected unknown list to be 404, got %d", code)
+	}
+}
@@ -0,0 +1,139 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"a4-tasklists/server/internal/signup"
+	"a4-tasklists/server/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// Invites let people sign up where signup is gated (SERVER_SIGNUP_*). The
+// code is only shown when the invite is created.
+
+// maxInviteUses bounds the uses of one invite, so a leaked link cannot open
+// signup to everyone.
+const maxInviteUses = 1000
+
+// handleAdminInvites lists the invites on GET and creates one on POST
+// {note, maxUses, expiresInSeconds}.
+func (s *Server) handleAdminInvites(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		invites, err := s.store.ListInvites(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, jsonResponse{"invites": invites})
+	case http.MethodPost:
+		var payload struct {
+			Note             string `json:"note"`
+			MaxUses          int    `json:"maxUses"`
+			ExpiresInSeconds int64  `json:"expiresInSeconds"`
+		}
+		if err := decodeJSON(r, &payload); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if payload.MaxUses == 0 {
+			payload.MaxUses = 1
+		}
+		if payload.MaxUses < 1 || payload.MaxUses > maxInviteUses {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "maxUses must be between 1 and 1000"})
+			return
+		}
+		if payload.ExpiresInSeconds < 0 {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "expiresInSeconds must not be negative"})
+			return
+		}
+		code, hash, err := signup.NewCode()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		now := time.Now().Unix()
+		invite := storage.Invite{ID: "invite-" + uuid.NewString(), Note: strings.TrimSpace(payload.Note), MaxUses: payload.MaxUses, CreatedAt: now}
+		if payload.ExpiresInSeconds > 0 {
+			invite.ExpiresAt = now + payload.ExpiresInSeconds
+		}
+		if err := s.store.CreateInvite(r.Context(), invite, hash); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		log.Printf("admin invite created invite=%s max_uses=%d", invite.ID, invite.MaxUses)
+		writeJSON(w, http.StatusCreated, jsonResponse{"invite": invite, "code": code, "path": "/signup?invite=" + url.QueryEscape(code)})
+	default:
+		methodNotAllowed(w)
+	}
+}
+
+// handleAdminInvite shows an invite with its ETag on GET and revokes it on
+// DELETE, unless If-Match names another version.
+func (s *Server) handleAdminInvite(w http.ResponseWriter, r *http.Request) {
+	inviteID := r.PathValue("id")
+	switch r.Method {
+	case http.MethodGet:
+		invite, err := s.findInvite(r.Context(), inviteID)
+		if errors.Is(err, storage.ErrInviteNotFound) {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: "invite not found"})
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		setETag(w, resourceVersion(invite))
+		writeJSON(w, http.StatusOK, invite)
+	case http.MethodDelete:
+		s.adminWrites.Lock()
+		defer s.adminWrites.Unlock()
+		if r.Header.Get("If-Match") != "" {
+			invite, err := s.findInvite(r.Context(), inviteID)
+			if errors.Is(err, storage.ErrInviteNotFound) {
+				writeJSON(w, http.StatusPreconditionFailed, errorResponse{Error: "invite not found"})
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			if version := resourceVersion(invite); !ifMatchSatisfied(r, version) {
+				writePreconditionFailed(w, version)
+				return
+			}
+		}
+		err := s.store.DeleteInvite(r.Context(), inviteID)
+		if errors.Is(err, storage.ErrInviteNotFound) {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: "invite not found"})
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w)
+	}
+}
+
+func (s *Server) findInvite(ctx context.Context, inviteID string) (storage.Invite, error) {
+	invites, err := s.store.ListInvites(ctx)
+	if err != nil {
+		return storage.Invite{}, err
+	}
+	for _, invite := range invites {
+		if invite.ID == inviteID {
+			return invite, nil
+		}
+	}
+	return storage.Invite{}, storage.ErrInviteNotFound
+}
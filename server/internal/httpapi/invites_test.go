@@ -0,0 +1,81 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"a4-tasklists/server/internal/signup"
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestAdminInvitesShowCodeOnceAndRevoke(t *testing.T) {
+	store := newTestStore(t)
+	server := NewServer(store)
+	mux := http.NewServeMux()
+	server.RegisterAdminRoutes(mux)
+
+	for _, body := range []string{`{"maxUses":-1}`, `{"maxUses":1001}`, `{"expiresInSeconds":-5}`} {
+		if resp := doRequest(t, mux, http.MethodPost, "/admin/invites", []byte(body)); resp.Code != http.StatusBadRequest {
+			t.Fatalf("expected %s to be rejected, got %d", body, resp.Code)
+		}
+	}
+	resp := doRequest(t, mux, http.MethodPost, "/admin/invites", []byte(`{"note":" for Ann ","expiresInSeconds":3600}`))
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("create invite: %d %s", resp.Code, resp.Body.String())
+	}
+	var created struct {
+		Invite storage.Invite `json:"invite"`
+		Code   string         `json:"code"`
+		Path   string         `json:"path"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode invite: %v", err)
+	}
+	if created.Invite.Note != "for Ann" || created.Invite.MaxUses != 1 || created.Invite.ExpiresAt-created.Invite.CreatedAt != 3600 {
+		t.Fatalf("unexpected invite %+v", created.Invite)
+	}
+	if created.Code == "" || created.Path != "/signup?invite="+created.Code {
+		t.Fatalf("unexpected code %q and path %q", created.Code, created.Path)
+	}
+	unused := doRequest(t, mux, http.MethodGet, "/admin/invites/"+created.Invite.ID, nil).Header().Get("ETag")
+	if unused == "" {
+		t.Fatalf("expected the invite to have an ETag")
+	}
+	if _, err := store.RedeemInvite(context.Background(), signup.HashCode(created.Code), time.Now().Unix()); err != nil {
+		t.Fatalf("expected the code to redeem the invite: %v", err)
+	}
+
+	resp = doRequest(t, mux, http.MethodGet, "/admin/invites", nil)
+	if resp.Code != http.StatusOK || strings.Contains(resp.Body.String(), created.Code) {
+		t.Fatalf("expected the listing to leave the code out: %d %s", resp.Code, resp.Body.String())
+	}
+	var listed struct {
+		Invites []storage.Invite `json:"invites"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+		t.Fatalf("decode invites: %v", err)
+	}
+	if len(listed.Invites) != 1 || listed.Invites[0].Uses != 1 {
+		t.Fatalf("unexpected invites %+v", listed.Invites)
+	}
+
+	// The invite was used since the operator looked at it.
+	stale := map[string]string{"If-Match": unused}
+	if resp := doRequestWithHeaders(t, mux, http.MethodDelete, "/admin/invites/"+created.Invite.ID, nil, stale); resp.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected a stale revoke to be refused, got %d", resp.Code)
+	}
+	used := doRequest(t, mux, http.MethodGet, "/admin/invites/"+created.Invite.ID, nil).Header().Get("ETag")
+	if used == unused {
+		t.Fatalf("expected a use to change the ETag")
+	}
+	if resp := doRequestWithHeaders(t, mux, http.MethodDelete, "/admin/invites/"+created.Invite.ID, nil, map[string]string{"If-Match": used}); resp.Code != http.StatusNoContent {
+		t.Fatalf("revoke invite: %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodDelete, "/admin/invites/"+created.Invite.ID, nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected a revoked invite to be gone, got %d", resp.Code)
+	}
+}
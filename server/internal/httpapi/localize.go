@@ -0,0 +1,94 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"a4-tasklists/server/internal/i18n"
+)
+
+// LocalizeErrors adds a translated "message" to JSON error responses for
+// clients whose Accept-Language has a catalog. "error" stays in English, so
+// programs and logs keep matching it; "message" is for showing to people.
+// Errors without a translation are left alone.
+func LocalizeErrors(messages *i18n.Catalog, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		printer := messages.Printer(i18n.AcceptLanguage(r.Header.Get("Accept-Language"))...)
+		if printer.Language() == i18n.English {
+			next.ServeHTTP(w, r)
+			return
+		}
+		localizing := &localizingWriter{ResponseWriter: w}
+		next.ServeHTTP(localizing, r)
+		if localizing.held {
+			localizing.release(printer)
+		}
+	})
+}
+
+// localizingWriter holds back JSON error responses so they can be
+// translated; everything else passes straight through.
+type localizingWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	held        bool
+	status      int
+	body        bytes.Buffer
+}
+
+func (w *localizingWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if status >= http.StatusBadRequest && mediaType(w.Header().Get("Content-Type")) == "application/json" {
+		w.held, w.status = true, status
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *localizingWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.held {
+		return w.body.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush lets streaming responses through; error responses are short and are
+// held until the handler returns.
+func (w *localizingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok && !w.held {
+		flusher.Flush()
+	}
+}
+
+func (w *localizingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// release writes the held response, with a message when its error has a
+// translation.
+func (w *localizingWriter) release(printer i18n.Printer) {
+	body := w.body.Bytes()
+	var payload map[string]any
+	if err := json.Unmarshal(body, &payload); err == nil {
+		if text, ok := payload["error"].(string); ok {
+			if message, ok := printer.Lookup(text); ok {
+				payload["message"] = message
+				if encoded, err := json.MarshalIndent(payload, "", "  "); err == nil {
+					body = append(encoded, '\n')
+					w.Header().Set("Content-Language", printer.Language())
+				}
+			}
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(w.status)
+	_, _ = w.ResponseWriter.Write(body)
+}
@@ -0,0 +1,46 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"a4-tasklists/server/internal/auth"
+	"a4-tasklists/server/internal/i18n"
+)
+
+func TestLocalizeErrorsAddsATranslatedMessage(t *testing.T) {
+	handler := LocalizeErrors(i18n.Builtin(), newTestMux(t))
+	request := func(method, path, language string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, nil)
+		req = req.WithContext(auth.ContextWithUserID(req.Context(), "user-1"))
+		req.Header.Set("Accept-Language", language)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+	decode := func(resp *httptest.ResponseRecorder) map[string]any {
+		t.Helper()
+		var payload map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		return payload
+	}
+
+	resp := request(http.MethodDelete, "/me", "de-CH, en;q=0.5")
+	if resp.Code != http.StatusMethodNotAllowed || resp.Header().Get("Content-Language") != "de" {
+		t.Fatalf("unexpected response: %d %v", resp.Code, resp.Header())
+	}
+	if payload := decode(resp); payload["error"] != "method not allowed" || payload["message"] != "Methode nicht erlaubt" {
+		t.Fatalf("expected the English error and a German message, got %v", payload)
+	}
+	if payload := decode(request(http.MethodDelete, "/me", "en-US, de;q=0.8")); payload["message"] != nil {
+		t.Fatalf("expected no message for English, got %v", payload)
+	}
+	if resp := request(http.MethodGet, "/me", "de"); resp.Code != http.StatusOK || resp.Header().Get("Content-Language") != "" {
+		t.Fatalf("expected successful responses to pass through, got %d %v", resp.Code, resp.Header())
+	}
+}
@@ -0,0 +1,295 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/storage"
+)
+
+// mcpProtocolVersion is the Model Context Protocol revision served on /mcp.
+const mcpProtocolVersion = "2025-06-18"
+
+// maxAssistantItems caps the items one add_items call may create.
+const maxAssistantItems = 100
+
+// JSON-RPC 2.0 error codes used by /mcp.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// errToolInput marks tool failures the assistant can correct, which are
+// reported as tool results rather than protocol errors.
+var errToolInput = errors.New("invalid tool input")
+
+// assistantTool is a list operation offered to assistants. Tools are only
+// listed and callable with a token carrying their scope.
+type assistantTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+	scope       string
+	call        func(s *Server, ctx context.Context, token storage.APIToken, arguments json.RawMessage) (any, error)
+}
+
+var assistantTools = []assistantTool{
+	{
+		Name:        "list_lists",
+		Description: "List the user's task lists with their ids, titles and item counts.",
+		InputSchema: map[string]any{"type": "object", "properties": map[string]any{}},
+		scope:       storage.TokenScopeRead,
+		call: func(s *Server, ctx context.Context, token storage.APIToken, arguments json.RawMessage) (any, error) {
+			if err := decodeToolArguments(arguments, &struct{}{}); err != nil {
+				return nil, err
+			}
+			state, err := s.loadState(ctx, token.UserID)
+			if err != nil {
+				return nil, err
+			}
+			archived, err := s.store.ListArchivedLists(ctx, token.UserID)
+			if err != nil {
+				return nil, err
+			}
+			lists := make([]listSummary, 0, len(state.Lists))
+			for _, list := range state.Lists {
+				if !slices.Contains(archived, list.ID) && token.AllowsList(list.ID) {
+					lists = append(lists, listSummary{ListID: list.ID, Title: list.Title, ItemCount: len(list.Items)})
+				}
+			}
+			return jsonResponse{"lists": lists}, nil
+		},
+	},
+	{
+		Name:        "get_list",
+		Description: "Get the items of one list in order, optionally only open (done=false) or completed (done=true) ones.",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"listId": map[string]any{"type": "string"},
+				"done":   map[string]any{"type": "boolean"},
+			},
+			"required": []string{"listId"},
+		},
+		scope: storage.TokenScopeRead,
+		call: func(s *Server, ctx context.Context, token storage.APIToken, arguments json.RawMessage) (any, error) {
+			var args struct {
+				ListID string `json:"listId"`
+				Done   *bool  `json:"done"`
+			}
+			if err := decodeToolArguments(arguments, &args); err != nil {
+				return nil, err
+			}
+			state, err := s.loadState(ctx, token.UserID)
+			if err != nil {
+				return nil, err
+			}
+			list, ok := state.FindList(args.ListID)
+			if !ok || !token.AllowsList(list.ID) {
+				return nil, fmt.Errorf("%w: %w", errToolInput, materialize.ErrListNotFound)
+			}
+			items := slices.DeleteFunc(list.Items, func(item materialize.Item) bool {
+				return args.Done != nil && item.Done != *args.Done
+			})
+			return jsonResponse{"listId": list.ID, "title": list.Title, "items": items}, nil
+		},
+	},
+	{
+		Name:        "add_items",
+		Description: "Add items to the end of a list. Returns the new item ids.",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"listId": map[string]any{"type": "string"},
+				"items":  map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "minItems": 1, "maxItems": maxAssistantItems},
+			},
+			"required": []string{"listId", "items"},
+		},
+		scope: storage.TokenScopeAdd,
+		call: func(s *Server, ctx context.Context, token storage.APIToken, arguments json.RawMessage) (any, error) {
+			var args struct {
+				ListID string   `json:"listId"`
+				Items  []string `json:"items"`
+			}
+			if err := decodeToolArguments(arguments, &args); err != nil {
+				return nil, err
+			}
+			if len(args.Items) == 0 || len(args.Items) > maxAssistantItems {
+				return nil, fmt.Errorf("%w: between 1 and %d items are required", errToolInput, maxAssistantItems)
+			}
+			if !token.AllowsList(args.ListID) {
+				return nil, fmt.Errorf("%w: %w", errToolInput, materialize.ErrListNotFound)
+			}
+			items := make([]restItem, 0, len(args.Items))
+			for _, text := range args.Items {
+				items = append(items, restItem{Text: text})
+			}
+			itemIDs, _, serverSeq, err := s.appendItems(ctx, token.UserID, args.ListID, items)
+			if errors.Is(err, materialize.ErrListNotFound) {
+				return nil, fmt.Errorf("%w: %w", errToolInput, err)
+			}
+			if err != nil {
+				return nil, err
+			}
+			return jsonResponse{"itemIds": itemIDs, "serverSeq": serverSeq}, nil
+		},
+	},
+	{
+		Name:        "set_item_done",
+		Description: "Mark an item as completed, or as open again with done=false.",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"itemId": map[string]any{"type": "string"},
+				"done":   map[string]any{"type": "boolean", "default": true},
+			},
+			"required": []string{"itemId"},
+		},
+		scope: storage.TokenScopeComplete,
+		call: func(s *Server, ctx context.Context, token storage.APIToken, arguments json.RawMessage) (any, error) {
+			args := struct {
+				ItemID string `json:"itemId"`
+				Done   bool   `json:"done"`
+			}{Done: true}
+			if err := decodeToolArguments(arguments, &args); err != nil {
+				return nil, err
+			}
+			// A list token only finds items of its list.
+			_, serverSeq, err := s.updateItem(ctx, token.UserID, token.ListID, args.ItemID, materialize.ItemChange{Done: &args.Done})
+			if errors.Is(err, materialize.ErrItemNotFound) || errors.Is(err, materialize.ErrListNotFound) {
+				return nil, fmt.Errorf("%w: %w", errToolInput, err)
+			}
+			if err != nil {
+				return nil, err
+			}
+			return jsonResponse{"itemId": args.ItemID, "done": args.Done, "serverSeq": serverSeq}, nil
+		},
+	},
+}
+
+func decodeToolArguments(arguments json.RawMessage, target any) error {
+	if len(arguments) == 0 || string(arguments) == "null" {
+		arguments = json.RawMessage("{}")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(arguments))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); err != nil {
+		return fmt.Errorf("%w: %w", errToolInput, err)
+	}
+	return nil
+}
+
+// handleMCP serves the Model Context Protocol over HTTP: one JSON-RPC message
+// per POST, answered with a JSON body (no event streams). Requests must carry
+// an API token, which selects the user, the tools offered and the lists they
+// reach.
+func (s *Server) handleMCP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+	token, ok := s.apiToken(w, r)
+	if !ok {
+		return
+	}
+	var req rpcRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: rpcParseError, Message: err.Error()}})
+		return
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		writeJSON(w, http.StatusBadRequest, rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: rpcInvalidRequest, Message: "expected a JSON-RPC 2.0 request"}})
+		return
+	}
+	if len(req.ID) == 0 {
+		// Notifications (such as notifications/initialized) need no answer.
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	result, rpcErr := s.mcpCall(r.Context(), token, req)
+	writeJSON(w, http.StatusOK, rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rpcErr})
+}
+
+func (s *Server) mcpCall(ctx context.Context, token storage.APIToken, req rpcRequest) (any, *rpcError) {
+	switch req.Method {
+	case "initialize":
+		return jsonResponse{
+			"protocolVersion": mcpProtocolVersion,
+			"capabilities":    jsonResponse{"tools": jsonResponse{}},
+			"serverInfo":      jsonResponse{"name": "tasklists", "version": "1"},
+		}, nil
+	case "ping":
+		return jsonResponse{}, nil
+	case "tools/list":
+		tools := make([]assistantTool, 0, len(assistantTools))
+		for _, tool := range assistantTools {
+			if slices.Contains(token.Scopes, tool.scope) {
+				tools = append(tools, tool)
+			}
+		}
+		return jsonResponse{"tools": tools}, nil
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+		i := slices.IndexFunc(assistantTools, func(tool assistantTool) bool { return tool.Name == params.Name })
+		if i < 0 {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: "unknown tool " + params.Name}
+		}
+		tool := assistantTools[i]
+		if !slices.Contains(token.Scopes, tool.scope) {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("tool %s requires the %s scope", tool.Name, tool.scope)}
+		}
+		log.Printf("assistant tool call token=%s tool=%s", token.ID, tool.Name)
+		result, err := tool.call(s, ctx, token, params.Arguments)
+		if errors.Is(err, errToolInput) {
+			return jsonResponse{"content": []jsonResponse{{"type": "text", "text": err.Error()}}, "isError": true}, nil
+		}
+		if err != nil {
+			log.Printf("assistant tool error token=%s tool=%s: %v", token.ID, tool.Name, err)
+			return nil, &rpcError{Code: rpcInternalError, Message: err.Error()}
+		}
+		text, err := json.Marshal(result)
+		if err != nil {
+			return nil, &rpcError{Code: rpcInternalError, Message: err.Error()}
+		}
+		return jsonResponse{
+			"content":           []jsonResponse{{"type": "text", "text": string(text)}},
+			"structuredContent": result,
+		}, nil
+	}
+	return nil, &rpcError{Code: rpcMethodNotFound, Message: "method not found: " + req.Method}
+}
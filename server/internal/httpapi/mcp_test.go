@@ -0,0 +1,89 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMCPToolsAreScopedByAPIToken(t *testing.T) {
+	mux := newTestMux(t)
+	resp := doRequest(t, mux, http.MethodPost, "/lists", []byte(`{"title":"Groceries","items":[{"text":"milk"}]}`))
+	var list struct {
+		ListID  string   `json:"listId"`
+		ItemIDs []string `json:"itemIds"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("decode create list: %v", err)
+	}
+	if resp := doRequest(t, mux, http.MethodPost, "/auth/tokens", []byte(`{"name":"Speaker","scopes":["read","write"]}`)); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected unknown scope to be rejected, got %d", resp.Code)
+	}
+	resp = doRequest(t, mux, http.MethodPost, "/auth/tokens", []byte(`{"name":"Speaker","scopes":["read","add"]}`))
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("create token status: got %d", resp.Code)
+	}
+	var created struct {
+		Token struct {
+			ID string `json:"id"`
+		} `json:"token"`
+		Secret string `json:"secret"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode token: %v", err)
+	}
+
+	rpc := func(secret string, body string) (int, map[string]any) {
+		t.Helper()
+		resp := doRequestWithHeaders(t, mux, http.MethodPost, "/mcp", []byte(body), map[string]string{"Authorization": "Bearer " + secret})
+		var decoded map[string]any
+		if resp.Code == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+				t.Fatalf("decode rpc response: %v", err)
+			}
+		}
+		return resp.Code, decoded
+	}
+	if code, _ := rpc("lat_wrong", `{"jsonrpc":"2.0","id":1,"method":"ping"}`); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for unknown token, got %d", code)
+	}
+	if code, _ := rpc(created.Secret, `{"jsonrpc":"2.0","method":"notifications/initialized"}`); code != http.StatusAccepted {
+		t.Fatalf("expected 202 for notification, got %d", code)
+	}
+
+	_, listed := rpc(created.Secret, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+	var names []string
+	for _, tool := range listed["result"].(map[string]any)["tools"].([]any) {
+		names = append(names, tool.(map[string]any)["name"].(string))
+	}
+	if strings.Join(names, ",") != "list_lists,get_list,add_items" {
+		t.Fatalf("unexpected tools: %v", names)
+	}
+
+	_, added := rpc(created.Secret, `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"add_items","arguments":{"listId":"`+list.ListID+`","items":["eggs","bread"]}}}`)
+	result := added["result"].(map[string]any)
+	if result["isError"] != nil || len(result["structuredContent"].(map[string]any)["itemIds"].([]any)) != 2 {
+		t.Fatalf("unexpected add_items result: %v", added)
+	}
+	_, got := rpc(created.Secret, `{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"get_list","arguments":{"listId":"`+list.ListID+`","done":false}}}`)
+	items := got["result"].(map[string]any)["structuredContent"].(map[string]any)["items"].([]any)
+	if len(items) != 3 || items[2].(map[string]any)["text"] != "bread" {
+		t.Fatalf("unexpected get_list result: %v", got)
+	}
+	_, missing := rpc(created.Secret, `{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"get_list","arguments":{"listId":"nope"}}}`)
+	if missing["result"].(map[string]any)["isError"] != true {
+		t.Fatalf("expected a tool error for an unknown list, got %v", missing)
+	}
+	_, denied := rpc(created.Secret, `{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"set_item_done","arguments":{"itemId":"`+list.ItemIDs[0]+`"}}}`)
+	if denied["error"].(map[string]any)["code"] != float64(rpcInvalidParams) {
+		t.Fatalf("expected set_item_done to need the complete scope, got %v", denied)
+	}
+
+	if resp := doRequest(t, mux, http.MethodDelete, "/auth/tokens/"+created.Token.ID, nil); resp.Code != http.StatusNoContent {
+		t.Fatalf("revoke status: got %d", resp.Code)
+	}
+	if code, _ := rpc(created.Secret, `{"jsonrpc":"2.0","id":6,"method":"ping"}`); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 after revoke, got %d", code)
+	}
+}
@@ -0,0 +1,200 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"a4-tasklists/server/internal/i18n"
+	"a4-tasklists/server/internal/materialize"
+)
+
+func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	profile, err := s.store.GetUserProfile(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{
+		"userId":      userID,
+		"email":       profile.Email,
+		"displayName": profile.DisplayName,
+		"avatarUrl":   profile.AvatarURL,
+		"locale":      profile.Locale,
+		"timeZone":    profile.TimeZone,
+	})
+}
+
+// handleTimeZone reads (GET) or changes (PUT {timeZone, hint}) the user's
+// time zone, an IANA name such as "Europe/Berlin", or "" for the server's.
+// With hint set, the zone is only stored when the user has none yet: clients
+// send what the browser reports on every start, without overriding a choice.
+func (s *Server) handleTimeZone(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	profile, err := s.store.GetUserProfile(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if r.Method == http.MethodPut {
+		var payload struct {
+			TimeZone string `json:"timeZone"`
+			Hint     bool   `json:"hint"`
+		}
+		if err := decodeJSON(r, &payload); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if _, err := loadTimeZone(payload.TimeZone); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+			return
+		}
+		if !payload.Hint || profile.TimeZone == "" {
+			if err := s.store.SetTimeZone(r.Context(), userID, payload.TimeZone); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			profile.TimeZone = payload.TimeZone
+		}
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{"timeZone": profile.TimeZone})
+}
+
+// handleLocale reads (GET) or changes (PUT {locale, hint}) the language of
+// the user's emails and exports, a BCP 47 tag such as "de", or "" for
+// English. With hint set, the language is only stored when the user has none
+// yet, like the time zone.
+func (s *Server) handleLocale(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	profile, err := s.store.GetUserProfile(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if r.Method == http.MethodPut {
+		var payload struct {
+			Locale string `json:"locale"`
+			Hint   bool   `json:"hint"`
+		}
+		if err := decodeJSON(r, &payload); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if payload.Locale != "" && !i18n.ValidTag(payload.Locale) {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "locale must be a language tag such as \"de\" or \"pt-BR\""})
+			return
+		}
+		if !payload.Hint || profile.Locale == "" {
+			if err := s.store.SetLocale(r.Context(), userID, payload.Locale); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			profile.Locale = payload.Locale
+		}
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{"locale": profile.Locale, "language": s.messages.Match(profile.Locale)})
+}
+
+// handleMutedLists lists the user's muted lists that still exist. Muted lists
+// are left out of digests; clients that notify about changes themselves read
+// them here too.
+func (s *Server) handleMutedLists(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	listIDs, err := s.store.ListMutedLists(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	lists := make([]listSummary, 0, len(listIDs))
+	if len(listIDs) > 0 {
+		state, err := s.loadState(r.Context(), userID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		for _, listID := range listIDs {
+			if list, ok := state.FindList(listID); ok {
+				lists = append(lists, listSummary{ListID: list.ID, Title: list.Title, ItemCount: len(list.Items)})
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{"lists": lists})
+}
+
+// handleMuteList mutes or unmutes notifications about a list (PUT {muted}).
+func (s *Server) handleMuteList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	var payload struct {
+		Muted bool `json:"muted"`
+	}
+	if err := decodeJSON(r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	listID := r.PathValue("id")
+	if payload.Muted {
+		state, err := s.loadState(r.Context(), userID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if _, ok := state.FindList(listID); !ok {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: materialize.ErrListNotFound.Error()})
+			return
+		}
+	}
+	if err := s.store.SetListMuted(r.Context(), userID, listID, payload.Muted); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadTimeZone returns the location named by an IANA time zone name, or the
+// server's zone for "".
+func loadTimeZone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	return location, nil
+}
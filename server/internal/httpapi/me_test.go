@@ -0,0 +1,158 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"a4-tasklists/server/internal/i18n"
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestMeReturnsProfile(t *testing.T) {
+	store := storage.NewMemoryStore()
+	if err := store.UpdateUserProfile(context.Background(), "user-1", storage.UserProfile{Email: "alice@example.com", DisplayName: "Alice", Locale: "de"}); err != nil {
+		t.Fatalf("update profile: %v", err)
+	}
+	mux := http.NewServeMux()
+	NewServer(store).RegisterRoutes(mux)
+
+	resp := doRequest(t, mux, http.MethodGet, "/me", nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("me status: got %d", resp.Code)
+	}
+	var payload struct {
+		UserID      string `json:"userId"`
+		Email       string `json:"email"`
+		DisplayName string `json:"displayName"`
+		Locale      string `json:"locale"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode me: %v", err)
+	}
+	if payload.UserID != "user-1" || payload.Email != "alice@example.com" || payload.DisplayName != "Alice" || payload.Locale != "de" {
+		t.Fatalf("unexpected profile: %+v", payload)
+	}
+}
+
+func TestTimeZonePreference(t *testing.T) {
+	mux := newTestMux(t)
+	put := func(body string) (int, string) {
+		t.Helper()
+		resp := doRequest(t, mux, http.MethodPut, "/me/timezone", []byte(body))
+		var payload struct {
+			TimeZone string `json:"timeZone"`
+		}
+		if resp.Code == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+				t.Fatalf("decode time zone: %v", err)
+			}
+		}
+		return resp.Code, payload.TimeZone
+	}
+
+	if code, got := put(`{"timeZone":"Europe/Berlin","hint":true}`); code != http.StatusOK || got != "Europe/Berlin" {
+		t.Fatalf("expected a hint to set an unset time zone, got %d %q", code, got)
+	}
+	if code, got := put(`{"timeZone":"America/Chicago"}`); code != http.StatusOK || got != "America/Chicago" {
+		t.Fatalf("expected the time zone to change, got %d %q", code, got)
+	}
+	if code, got := put(`{"timeZone":"Asia/Tokyo","hint":true}`); code != http.StatusOK || got != "America/Chicago" {
+		t.Fatalf("expected a hint to keep the chosen time zone, got %d %q", code, got)
+	}
+	for _, body := range []string{`{"timeZone":"Mars/Olympus"}`, `{"timeZone":"Local"}`} {
+		if code, _ := put(body); code != http.StatusBadRequest {
+			t.Fatalf("expected %s to be rejected, got %d", body, code)
+		}
+	}
+	resp := doRequest(t, mux, http.MethodGet, "/me", nil)
+	var me struct {
+		TimeZone string `json:"timeZone"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&me); err != nil {
+		t.Fatalf("decode me: %v", err)
+	}
+	if me.TimeZone != "America/Chicago" {
+		t.Fatalf("expected /me to report the time zone, got %q", me.TimeZone)
+	}
+	if code, got := put(`{"timeZone":""}`); code != http.StatusOK || got != "" {
+		t.Fatalf("expected the time zone to be cleared, got %d %q", code, got)
+	}
+}
+
+func TestLocalePreference(t *testing.T) {
+	store := newTestStore(t)
+	mux := http.NewServeMux()
+	NewServerWithConfig(store, Config{Messages: i18n.Builtin()}).RegisterRoutes(mux)
+	put := func(body string) (int, string, string) {
+		t.Helper()
+		resp := doRequest(t, mux, http.MethodPut, "/me/locale", []byte(body))
+		var payload struct {
+			Locale   string `json:"locale"`
+			Language string `json:"language"`
+		}
+		if resp.Code == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+				t.Fatalf("decode locale: %v", err)
+			}
+		}
+		return resp.Code, payload.Locale, payload.Language
+	}
+
+	if code, got, language := put(`{"locale":"de-AT","hint":true}`); code != http.StatusOK || got != "de-AT" || language != "de" {
+		t.Fatalf("expected a hint to set an unset locale, got %d %q %q", code, got, language)
+	}
+	if code, got, _ := put(`{"locale":"fr","hint":true}`); code != http.StatusOK || got != "de-AT" {
+		t.Fatalf("expected a hint to keep the chosen locale, got %d %q", code, got)
+	}
+	if code, got, language := put(`{"locale":"fr"}`); code != http.StatusOK || got != "fr" || language != "en" {
+		t.Fatalf("expected the locale to change, got %d %q %q", code, got, language)
+	}
+	if code, _, _ := put(`{"locale":"not a tag"}`); code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid tag to be rejected, got %d", code)
+	}
+	if code, got, _ := put(`{"locale":""}`); code != http.StatusOK || got != "" {
+		t.Fatalf("expected the locale to be cleared, got %d %q", code, got)
+	}
+}
+
+func TestMutedListsPreference(t *testing.T) {
+	store := newTestStore(t)
+	mux := http.NewServeMux()
+	NewServer(store).RegisterRoutes(mux)
+	resp := doRequest(t, mux, http.MethodPost, "/lists", []byte(`{"title":"Family"}`))
+	var created struct {
+		ListID string `json:"listId"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode list: %v", err)
+	}
+	muted := func() []listSummary {
+		t.Helper()
+		resp := doRequest(t, mux, http.MethodGet, "/me/muted-lists", nil)
+		var payload struct {
+			Lists []listSummary `json:"lists"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode muted lists: %v", err)
+		}
+		return payload.Lists
+	}
+
+	if resp := doRequest(t, mux, http.MethodPut, "/me/muted-lists/nope", []byte(`{"muted":true}`)); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected muting an unknown list to be 404, got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodPut, "/me/muted-lists/"+created.ListID, []byte(`{"muted":true}`)); resp.Code != http.StatusNoContent {
+		t.Fatalf("mute list: %d %s", resp.Code, resp.Body.String())
+	}
+	if lists := muted(); len(lists) != 1 || lists[0].ListID != created.ListID || lists[0].Title != "Family" {
+		t.Fatalf("unexpected muted lists %+v", lists)
+	}
+	if resp := doRequest(t, mux, http.MethodPut, "/me/muted-lists/"+created.ListID, []byte(`{"muted":false}`)); resp.Code != http.StatusNoContent {
+		t.Fatalf("unmute list: %d", resp.Code)
+	}
+	if lists := muted(); len(lists) != 0 {
+		t.Fatalf("expected no muted lists, got %+v", lists)
+	}
+}
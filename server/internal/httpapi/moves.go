@@ -0,0 +1,95 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/storage"
+)
+
+// Push diagnostics describe problems the server found, and what it did about
+// them, in an otherwise accepted push.
+const (
+	diagnosticMoveTargetMissing = "move-target-missing"
+	diagnosticMoveSourceMissing = "move-source-missing"
+)
+
+// Actions reported with push diagnostics.
+const (
+	// diagnosticRepaired means the server dropped ops to keep data intact.
+	diagnosticRepaired = "repaired"
+	// diagnosticAccepted means the ops were stored unchanged.
+	diagnosticAccepted = "accepted"
+)
+
+type pushDiagnostic struct {
+	Code         string `json:"code"`
+	Action       string `json:"action"`
+	ItemID       string `json:"itemId"`
+	SourceListID string `json:"sourceListId"`
+	TargetListID string `json:"targetListId"`
+	Message      string `json:"message"`
+}
+
+// checkMoves validates items moved between lists in a push batch. A move into
+// a list that does not exist once the batch is applied would make the item
+// disappear, so its remove op is dropped and the item stays in the source
+// list. A move out of an unknown list loses nothing (the insert carries the
+// item) and is accepted. Lists of the user's own dataset are always writable
+// by the user's actors, which BindActors enforces.
+func (s *Server) checkMoves(ctx context.Context, userID string, batch []storage.Op) ([]storage.Op, []pushDiagnostic, error) {
+	moves := materialize.FindMoves(batch)
+	if len(moves) == 0 {
+		return batch, nil, nil
+	}
+	snapshot, err := s.store.GetSnapshot(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	ops, _, err := s.store.GetOpsSince(ctx, userID, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	before, err := s.quarantine.Build(ctx, userID, snapshot.Blob, ops)
+	if err != nil {
+		return nil, nil, fmt.Errorf("materialize state: %w", err)
+	}
+	after, err := materialize.Build(snapshot.Blob, append(ops, batch...))
+	if err != nil {
+		return nil, nil, fmt.Errorf("materialize state: %w", err)
+	}
+	exists := func(state materialize.State, listID string) bool {
+		_, ok := state.FindList(listID)
+		return ok
+	}
+	diagnostics := make([]pushDiagnostic, 0)
+	dropped := make(map[int]struct{})
+	for _, move := range moves {
+		diagnostic := pushDiagnostic{ItemID: move.ItemID, SourceListID: move.SourceListID, TargetListID: move.TargetListID}
+		switch {
+		case !exists(after, move.TargetListID):
+			dropped[move.RemoveIndex] = struct{}{}
+			diagnostic.Code = diagnosticMoveTargetMissing
+			diagnostic.Action = diagnosticRepaired
+			diagnostic.Message = "target list does not exist; the item was kept in its source list"
+		case !exists(before, move.SourceListID) && !exists(after, move.SourceListID):
+			diagnostic.Code = diagnosticMoveSourceMissing
+			diagnostic.Action = diagnosticAccepted
+			diagnostic.Message = "source list does not exist; the item was added to the target list"
+		default:
+			continue
+		}
+		diagnostics = append(diagnostics, diagnostic)
+	}
+	if len(dropped) == 0 {
+		return batch, diagnostics, nil
+	}
+	kept := make([]storage.Op, 0, len(batch)-len(dropped))
+	for i, op := range batch {
+		if _, ok := dropped[i]; !ok {
+			kept = append(kept, op)
+		}
+	}
+	return kept, diagnostics, nil
+}
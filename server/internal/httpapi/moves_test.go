@@ -0,0 +1,68 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestPushRepairsMovesIntoMissingLists(t *testing.T) {
+	store := newTestStore(t)
+	server := NewServer(store)
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	bootstrap := fetchBootstrap(t, mux)
+	push := func(ops ...map[string]any) []pushDiagnostic {
+		t.Helper()
+		body, _ := json.Marshal(map[string]any{
+			"clientId":             "client-a",
+			"datasetGenerationKey": bootstrap.DatasetGenerationKey,
+			"ops":                  ops,
+		})
+		resp := doRequest(t, mux, http.MethodPost, "/sync/push", body)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("push status: got %d", resp.Code)
+		}
+		var payload struct {
+			Diagnostics []pushDiagnostic `json:"diagnostics"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode push: %v", err)
+		}
+		return payload.Diagnostics
+	}
+	insert := func(listID string, clock int) map[string]any {
+		return map[string]any{"scope": "list", "resourceId": listID, "actor": "actor-a", "clock": clock, "payload": map[string]any{"type": "insert", "itemId": "item-1", "payload": map[string]any{"data": map[string]any{"text": "milk"}}}}
+	}
+	remove := func(listID string, clock int) map[string]any {
+		return map[string]any{"scope": "list", "resourceId": listID, "actor": "actor-a", "clock": clock, "payload": map[string]any{"type": "remove", "itemId": "item-1"}}
+	}
+	createList := func(listID string, clock int) map[string]any {
+		return map[string]any{"scope": "registry", "resourceId": "registry", "actor": "actor-a", "clock": clock, "payload": map[string]any{"type": "createList", "listId": listID, "payload": map[string]any{"title": listID}}}
+	}
+	if got := push(createList("list-a", 1), insert("list-a", 2)); len(got) != 0 {
+		t.Fatalf("expected no diagnostics, got %+v", got)
+	}
+
+	got := push(remove("list-a", 3), insert("list-gone", 4))
+	if len(got) != 1 || got[0].Code != diagnosticMoveTargetMissing || got[0].Action != diagnosticRepaired || got[0].TargetListID != "list-gone" {
+		t.Fatalf("expected a repaired dangling move, got %+v", got)
+	}
+	state, err := server.loadState(context.Background(), "user-1")
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	if list, _ := state.FindList("list-a"); len(list.Items) != 1 {
+		t.Fatalf("expected the item to stay in its source list, got %+v", list)
+	}
+
+	// A target created in the same batch is valid.
+	if got := push(createList("list-b", 5), remove("list-a", 6), insert("list-b", 7)); len(got) != 0 {
+		t.Fatalf("expected no diagnostics for a valid move, got %+v", got)
+	}
+	got = push(remove("list-unknown", 8), insert("list-a", 9))
+	if len(got) != 1 || got[0].Code != diagnosticMoveSourceMissing || got[0].Action != diagnosticAccepted {
+		t.Fatalf("expected an accepted move from an unknown list, got %+v", got)
+	}
+}
@@ -0,0 +1,441 @@
+package httpapi
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"a4-tasklists/server/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// The server acts as an OAuth2 authorization server for apps the operator
+// registered under /admin/oauth/apps. Apps use the authorization code flow
+// with PKCE (S256, required for every app) and receive an API token limited to
+// the scopes the user approved, so the API token middleware enforces them like
+// any other token.
+
+const (
+	// oauthConsentTTL bounds how long a consent page stays answerable.
+	oauthConsentTTL = 10 * time.Minute
+	// oauthCodeTTL bounds how long an app may take to redeem its code.
+	oauthCodeTTL = time.Minute
+	// oauthTokenTTL is the lifetime of tokens issued to apps. There are no
+	// refresh tokens; apps ask the user again once a token expires.
+	oauthTokenTTL = 90 * 24 * time.Hour
+)
+
+//go:embed oauth_consent.html
+var oauthConsentSource string
+
+var oauthConsentPage = template.Must(template.New("consent").Parse(oauthConsentSource))
+
+// scopeDescriptions explain API token scopes on the consent page.
+var scopeDescriptions = map[string]string{
+	storage.TokenScopeRead:     "Read your lists and items",
+	storage.TokenScopeAdd:      "Add items to your lists",
+	storage.TokenScopeComplete: "Check and uncheck items",
+	storage.TokenScopeQuickAdd: "Add items through quick-add",
+	storage.TokenScopeAdmin:    "Do anything you can do when signed in",
+}
+
+// oauthGrant is an authorization request between consent and token
+// issuance.
+type oauthGrant struct {
+	userID      string
+	appID       string
+	redirectURI string
+	state       string
+	challenge   string
+	scopes      []string
+	expiresAt   time.Time
+}
+
+// oauthGrants holds pending consent requests and unredeemed authorization
+// codes. Both live for minutes at most, so they are kept in memory; a restart
+// only makes users approve again.
+type oauthGrants struct {
+	mu      sync.Mutex
+	pending map[string]oauthGrant
+	codes   map[string]oauthGrant
+}
+
+func newOAuthGrants() *oauthGrants {
+	return &oauthGrants{pending: make(map[string]oauthGrant), codes: make(map[string]oauthGrant)}
+}
+
+// put stores grant under a new random key, dropping expired entries.
+func (g *oauthGrants) put(entries map[string]oauthGrant, grant oauthGrant) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	key := base64.RawURLEncoding.EncodeToString(raw)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	for k, existing := range entries {
+		if now.After(existing.expiresAt) {
+			delete(entries, k)
+		}
+	}
+	entries[key] = grant
+	return key, nil
+}
+
+// take removes and returns the grant stored under key if it has not expired.
+func (g *oauthGrants) take(entries map[string]oauthGrant, key string) (oauthGrant, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	grant, ok := entries[key]
+	delete(entries, key)
+	return grant, ok && time.Now().Before(grant.expiresAt)
+}
+
+// redirectWith returns redirectURI with params added to its query.
+func redirectWith(redirectURI string, params url.Values) string {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	query := target.Query()
+	for key, values := range params {
+		for _, value := range values {
+			if value != "" {
+				query.Add(key, value)
+			}
+		}
+	}
+	target.RawQuery = query.Encode()
+	return target.String()
+}
+
+// validRedirectURI accepts absolute URIs without fragments: https, http for
+// loopback development, and custom schemes for native apps.
+func validRedirectURI(raw string) bool {
+	target, err := url.Parse(raw)
+	if err != nil || !target.IsAbs() || target.Fragment != "" {
+		return false
+	}
+	switch target.Scheme {
+	case "http":
+		host := target.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	case "javascript", "data", "file", "vbscript":
+		return false
+	}
+	return true
+}
+
+// handleOAuthAuthorize shows the consent page for an authorization request
+// (GET) and records the user's answer (POST {request, approve}), returning
+// where to send the browser next.
+func (s *Server) handleOAuthAuthorize(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		s.startOAuthConsent(w, r, userID)
+	case http.MethodPost:
+		var payload struct {
+			Request string `json:"request"`
+			Approve bool   `json:"approve"`
+		}
+		if err := decodeJSON(r, &payload); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		grant, ok := s.oauth.take(s.oauth.pending, payload.Request)
+		if !ok || grant.userID != userID {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "consent request expired or unknown"})
+			return
+		}
+		if !payload.Approve {
+			log.Printf("oauth consent denied app=%s", grant.appID)
+			writeJSON(w, http.StatusOK, jsonResponse{"redirect": redirectWith(grant.redirectURI, url.Values{"error": {"access_denied"}, "state": {grant.state}})})
+			return
+		}
+		grant.expiresAt = time.Now().Add(oauthCodeTTL)
+		code, err := s.oauth.put(s.oauth.codes, grant)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		log.Printf("oauth consent granted app=%s scopes=%s", grant.appID, strings.Join(grant.scopes, ","))
+		writeJSON(w, http.StatusOK, jsonResponse{"redirect": redirectWith(grant.redirectURI, url.Values{"code": {code}, "state": {grant.state}})})
+	default:
+		methodNotAllowed(w)
+	}
+}
+
+func (s *Server) startOAuthConsent(w http.ResponseWriter, r *http.Request, userID string) {
+	query := r.URL.Query()
+	app, err := s.store.GetOAuthApp(r.Context(), query.Get("client_id"))
+	if errors.Is(err, storage.ErrOAuthAppNotFound) {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "unknown client_id"})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	redirectURI := query.Get("redirect_uri")
+	if !slices.Contains(app.RedirectURIs, redirectURI) {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "redirect_uri is not registered for this app"})
+		return
+	}
+	// With a trusted redirect URI, further errors go back to the app.
+	fail := func(code string, description string) {
+		http.Redirect(w, r, redirectWith(redirectURI, url.Values{"error": {code}, "error_description": {description}, "state": {query.Get("state")}}), http.StatusFound)
+	}
+	if query.Get("response_type") != "code" {
+		fail("unsupported_response_type", "only response_type=code is supported")
+		return
+	}
+	if query.Get("code_challenge") == "" || query.Get("code_challenge_method") != "S256" {
+		fail("invalid_request", "PKCE with code_challenge_method=S256 is required")
+		return
+	}
+	scopes := strings.Fields(query.Get("scope"))
+	if len(scopes) == 0 {
+		scopes = app.Scopes
+	}
+	descriptions := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if !slices.Contains(app.Scopes, scope) {
+			fail("invalid_scope", "scope "+scope+" is not allowed for this app")
+			return
+		}
+		descriptions = append(descriptions, scopeDescriptions[scope])
+	}
+	request, err := s.oauth.put(s.oauth.pending, oauthGrant{
+		userID:      userID,
+		appID:       app.ID,
+		redirectURI: redirectURI,
+		state:       query.Get("state"),
+		challenge:   query.Get("code_challenge"),
+		scopes:      slices.Compact(slices.Sorted(slices.Values(scopes))),
+		expiresAt:   time.Now().Add(oauthConsentTTL),
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// The page must not be framed, or another site could trick the user into
+	// clicking Allow.
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	if err := oauthConsentPage.Execute(w, map[string]any{
+		"App":     app.Name,
+		"Scopes":  descriptions,
+		"Days":    int(oauthTokenTTL.Hours() / 24),
+		"Request": request,
+	}); err != nil {
+		log.Printf("oauth consent page error: %v", err)
+	}
+}
+
+// writeOAuthError answers the token endpoint in the RFC 6749 error format.
+func writeOAuthError(w http.ResponseWriter, status int, code string, description string) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, jsonResponse{"error": code, "error_description": description})
+}
+
+// handleOAuthToken redeems an authorization code for an API token (form
+// fields grant_type=authorization_code, code, redirect_uri, client_id,
+// code_verifier, and client_secret for confidential apps, which may also use
+// HTTP Basic authentication).
+func (s *Server) handleOAuthToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if grantType := r.PostForm.Get("grant_type"); grantType != "authorization_code" {
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+	clientID, clientSecret, basic := r.BasicAuth()
+	if !basic {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	app, err := s.store.GetOAuthApp(r.Context(), clientID)
+	if errors.Is(err, storage.ErrOAuthAppNotFound) {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "unknown client_id")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if app.SecretHash != "" && subtle.ConstantTimeCompare([]byte(sha256Hex([]byte(clientSecret))), []byte(app.SecretHash)) != 1 {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+	grant, ok := s.oauth.take(s.oauth.codes, r.PostForm.Get("code"))
+	if !ok || grant.appID != app.ID || grant.redirectURI != r.PostForm.Get("redirect_uri") {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "code is invalid, expired, or was issued to another app or redirect_uri")
+		return
+	}
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(verifier[:])), []byte(grant.challenge)) != 1 {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "code_verifier does not match code_challenge")
+		return
+	}
+	token, secret, err := s.issueAPIToken(r.Context(), grant.userID, storage.APIToken{
+		Name:      app.Name,
+		Scopes:    grant.scopes,
+		ExpiresAt: time.Now().Add(oauthTokenTTL).Unix(),
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("oauth token issued app=%s token=%s", app.ID, token.ID)
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, jsonResponse{
+		"access_token": secret,
+		"token_type":   "Bearer",
+		"expires_in":   int64(oauthTokenTTL.Seconds()),
+		"scope":        strings.Join(token.Scopes, " "),
+	})
+}
+
+// handleAdminOAuthApps lists (GET) or registers (POST {name, redirectUris,
+// scopes, confidential}) OAuth apps. A confidential app's client secret is
+// only returned on registration.
+func (s *Server) handleAdminOAuthApps(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		apps, err := s.store.ListOAuthApps(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, jsonResponse{"apps": apps})
+	case http.MethodPost:
+		var payload struct {
+			Name         string   `json:"name"`
+			RedirectURIs []string `json:"redirectUris"`
+			Scopes       []string `json:"scopes"`
+			Confidential bool     `json:"confidential"`
+		}
+		if err := decodeJSON(r, &payload); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		payload.Name = strings.TrimSpace(payload.Name)
+		if payload.Name == "" || len(payload.RedirectURIs) == 0 || len(payload.Scopes) == 0 {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "name, redirectUris and scopes are required"})
+			return
+		}
+		for _, redirectURI := range payload.RedirectURIs {
+			if !validRedirectURI(redirectURI) {
+				writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid redirect uri " + redirectURI})
+				return
+			}
+		}
+		for _, scope := range payload.Scopes {
+			if !slices.Contains(storage.TokenScopes, scope) {
+				writeJSON(w, http.StatusBadRequest, errorResponse{Error: "unknown scope " + scope + " (want " + strings.Join(storage.TokenScopes, ", ") + ")"})
+				return
+			}
+		}
+		app := storage.OAuthApp{
+			ID:           "app-" + uuid.NewString(),
+			Name:         payload.Name,
+			RedirectURIs: payload.RedirectURIs,
+			Scopes:       slices.Compact(slices.Sorted(slices.Values(payload.Scopes))),
+			CreatedAt:    time.Now().Unix(),
+		}
+		response := jsonResponse{"app": app}
+		if payload.Confidential {
+			raw := make([]byte, 32)
+			if _, err := rand.Read(raw); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			secret := base64.RawURLEncoding.EncodeToString(raw)
+			app.SecretHash = sha256Hex([]byte(secret))
+			response["clientSecret"] = secret
+		}
+		if err := s.store.CreateOAuthApp(r.Context(), app); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		log.Printf("admin oauth app registered app=%s confidential=%t", app.ID, payload.Confidential)
+		writeJSON(w, http.StatusCreated, response)
+	default:
+		methodNotAllowed(w)
+	}
+}
+
+// handleAdminOAuthApp shows an OAuth app with its ETag on GET and deletes it
+// on DELETE, unless If-Match names another version. Tokens already issued to
+// it stay valid until they expire or their users revoke them.
+func (s *Server) handleAdminOAuthApp(w http.ResponseWriter, r *http.Request) {
+	appID := r.PathValue("id")
+	switch r.Method {
+	case http.MethodGet:
+		app, err := s.store.GetOAuthApp(r.Context(), appID)
+		if errors.Is(err, storage.ErrOAuthAppNotFound) {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		setETag(w, resourceVersion(app))
+		writeJSON(w, http.StatusOK, app)
+	case http.MethodDelete:
+		s.adminWrites.Lock()
+		defer s.adminWrites.Unlock()
+		if r.Header.Get("If-Match") != "" {
+			app, err := s.store.GetOAuthApp(r.Context(), appID)
+			if errors.Is(err, storage.ErrOAuthAppNotFound) {
+				writeJSON(w, http.StatusPreconditionFailed, errorResponse{Error: err.Error()})
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			if version := resourceVersion(app); !ifMatchSatisfied(r, version) {
+				writePreconditionFailed(w, version)
+				return
+			}
+		}
+		if err := s.store.DeleteOAuthApp(r.Context(), appID); err != nil {
+			if errors.Is(err, storage.ErrOAuthAppNotFound) {
+				writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+				return
+			}
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		log.Printf("admin oauth app deleted app=%s", appID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w)
+	}
+}
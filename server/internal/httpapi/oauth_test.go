@@ -0,0 +1,164 @@
+package httpapi
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestOAuthAuthorizationCodeFlowIssuesScopedToken(t *testing.T) {
+	server := NewServer(newTestStore(t))
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	server.RegisterAdminRoutes(mux)
+	handler := server.WithAPITokens(mux, mux)
+
+	resp := doRequest(t, mux, http.MethodPost, "/admin/oauth/apps", []byte(`{"name":"Planner","redirectUris":["https://planner.example/callback"],"scopes":["read","add"],"confidential":true}`))
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("register app: got %d %s", resp.Code, resp.Body.String())
+	}
+	var registered struct {
+		App struct {
+			ID string `json:"id"`
+		} `json:"app"`
+		ClientSecret string `json:"clientSecret"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&registered); err != nil {
+		t.Fatalf("decode app: %v", err)
+	}
+	if resp := doRequest(t, mux, http.MethodPost, "/admin/oauth/apps", []byte(`{"name":"Evil","redirectUris":["http://evil.example/callback"],"scopes":["read"]}`)); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected plain http redirect uri to be rejected, got %d", resp.Code)
+	}
+
+	verifier := "a-long-random-verifier-string-kept-by-the-app-0123456789"
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	authorize := func(redirectURI string, scope string) *httptest.ResponseRecorder {
+		t.Helper()
+		query := url.Values{
+			"response_type":         {"code"},
+			"client_id":             {registered.App.ID},
+			"redirect_uri":          {redirectURI},
+			"scope":                 {scope},
+			"state":                 {"xyz"},
+			"code_challenge":        {challenge},
+			"code_challenge_method": {"S256"},
+		}
+		return doRequest(t, mux, http.MethodGet, "/oauth/authorize?"+query.Encode(), nil)
+	}
+	if resp := authorize("https://attacker.example/callback", "read"); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected unregistered redirect uri to be rejected, got %d", resp.Code)
+	}
+	if resp := authorize("https://planner.example/callback", "admin"); resp.Code != http.StatusFound || !strings.Contains(resp.Header().Get("Location"), "error=invalid_scope") {
+		t.Fatalf("expected invalid_scope redirect, got %d %s", resp.Code, resp.Header().Get("Location"))
+	}
+
+	consent := func(approve bool) string {
+		t.Helper()
+		resp := authorize("https://planner.example/callback", "read")
+		if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), "Allow Planner") {
+			t.Fatalf("consent page: got %d %s", resp.Code, resp.Body.String())
+		}
+		request := resp.Body.String()
+		start := strings.Index(request, `const request = "`) + len(`const request = "`)
+		request = request[start : start+strings.Index(request[start:], `"`)]
+		body, _ := json.Marshal(map[string]any{"request": request, "approve": approve})
+		answer := doRequest(t, mux, http.MethodPost, "/oauth/authorize", body)
+		if answer.Code != http.StatusOK {
+			t.Fatalf("consent answer: got %d %s", answer.Code, answer.Body.String())
+		}
+		var payload struct {
+			Redirect string `json:"redirect"`
+		}
+		if err := json.NewDecoder(answer.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode consent answer: %v", err)
+		}
+		target, err := url.Parse(payload.Redirect)
+		if err != nil || target.Host != "planner.example" || target.Query().Get("state") != "xyz" {
+			t.Fatalf("unexpected redirect %q", payload.Redirect)
+		}
+		return target.Query().Get("code") + target.Query().Get("error")
+	}
+	if got := consent(false); got != "access_denied" {
+		t.Fatalf("expected access_denied after denying, got %q", got)
+	}
+
+	redeem := func(code string, verifier string, secret string) *httptest.ResponseRecorder {
+		t.Helper()
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {"https://planner.example/callback"},
+			"client_id":     {registered.App.ID},
+			"client_secret": {secret},
+			"code_verifier": {verifier},
+		}
+		return doRequestWithHeaders(t, mux, http.MethodPost, "/oauth/token", []byte(form.Encode()), map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+	}
+	code := consent(true)
+	if resp := redeem(code, verifier, "wrong"); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected wrong client secret to be rejected, got %d", resp.Code)
+	}
+	if resp := redeem(code, "not-the-verifier", registered.ClientSecret); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected wrong code verifier to be rejected, got %d", resp.Code)
+	}
+	// A failed PKCE check burns the code.
+	if resp := redeem(code, verifier, registered.ClientSecret); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected a used code to be rejected, got %d", resp.Code)
+	}
+
+	resp = redeem(consent(true), verifier, registered.ClientSecret)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("token: got %d %s", resp.Code, resp.Body.String())
+	}
+	var issued struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		Scope       string `json:"scope"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&issued); err != nil {
+		t.Fatalf("decode token: %v", err)
+	}
+	if issued.TokenType != "Bearer" || issued.Scope != "read" {
+		t.Fatalf("unexpected token response %+v", issued)
+	}
+
+	call := func(method string, path string, body string) int {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+issued.AccessToken)
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+	if got := call(http.MethodGet, "/lists", ""); got != http.StatusOK {
+		t.Fatalf("expected issued token to read, got %d", got)
+	}
+	if got := call(http.MethodPost, "/lists", `{"title":"Work"}`); got != http.StatusForbidden {
+		t.Fatalf("expected issued token to be limited to read, got %d", got)
+	}
+
+	resp = doRequest(t, mux, http.MethodGet, "/admin/oauth/apps/"+registered.App.ID, nil)
+	version := resp.Header().Get("ETag")
+	if resp.Code != http.StatusOK || version == "" {
+		t.Fatalf("expected the app with an ETag, got %d %q", resp.Code, version)
+	}
+	if resp := doRequestWithHeaders(t, mux, http.MethodDelete, "/admin/oauth/apps/"+registered.App.ID, nil, map[string]string{"If-Match": `"other"`}); resp.Code != http.StatusPreconditionFailed || resp.Header().Get("ETag") != version {
+		t.Fatalf("expected a delete of another version to be refused, got %d", resp.Code)
+	}
+	if resp := doRequestWithHeaders(t, mux, http.MethodDelete, "/admin/oauth/apps/"+registered.App.ID, nil, map[string]string{"If-Match": version}); resp.Code != http.StatusNoContent {
+		t.Fatalf("delete app: got %d", resp.Code)
+	}
+	if resp := doRequestWithHeaders(t, mux, http.MethodDelete, "/admin/oauth/apps/"+registered.App.ID, nil, map[string]string{"If-Match": version}); resp.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected a conditional delete of a deleted app to be refused, got %d", resp.Code)
+	}
+	if resp := authorize("https://planner.example/callback", "read"); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected deleted app to be unknown, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,208 @@
+package httpapi
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+// A signed-in browser onboards a new device by showing a QR code: the device
+// scans it and redeems the code for an API token and the bootstrap in one
+// request, so nobody types credentials on a phone.
+
+// pairingTTL bounds how long a pairing code can be redeemed.
+const pairingTTL = 5 * time.Minute
+
+// pairingScheme is the URL scheme of QR payloads, for the apps to register.
+const pairingScheme = "tasklists"
+
+// pairing is an unredeemed pairing code and the token it turns into.
+type pairing struct {
+	userID    string
+	name      string
+	scopes    []string
+	listID    string
+	memberID  string
+	expiresAt time.Time
+}
+
+// pairings holds unredeemed pairing codes. They live for minutes, so they are
+// kept in memory like OAuth codes; a restart only means showing a new QR code.
+type pairings struct {
+	mu    sync.Mutex
+	codes map[string]pairing
+}
+
+func newPairings() *pairings {
+	return &pairings{codes: make(map[string]pairing)}
+}
+
+// put stores entry under a new random code, dropping expired codes.
+func (p *pairings) put(entry pairing) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	code := base64.RawURLEncoding.EncodeToString(raw)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for k, existing := range p.codes {
+		if now.After(existing.expiresAt) {
+			delete(p.codes, k)
+		}
+	}
+	p.codes[code] = entry
+	return code, nil
+}
+
+// take removes and returns the pairing stored under code if it has not
+// expired, so every code is redeemed at most once.
+func (p *pairings) take(code string) (pairing, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry, ok := p.codes[code]
+	delete(p.codes, code)
+	return entry, ok && time.Now().Before(entry.expiresAt)
+}
+
+// handlePairing issues a pairing code (POST {name, scopes, listId}) for the
+// signed-in user. scopes default to admin, which a syncing client needs. The
+// answer carries the QR payload for the browser to render:
+//
+//	tasklists://pair?code=…&server=https%3A%2F%2Flists.example.com
+func (s *Server) handlePairing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	var payload struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+		ListID string   `json:"listId"`
+	}
+	if err := decodeJSON(r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name == "" {
+		payload.Name = "Paired device"
+	}
+	if len(payload.Scopes) == 0 {
+		payload.Scopes = []string{storage.TokenScopeAdmin}
+	}
+	scopes, err := tokenScopes(payload.Scopes)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	if payload.ListID != "" {
+		state, err := s.loadState(r.Context(), userID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if _, ok := state.FindList(payload.ListID); !ok {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "unknown list " + payload.ListID})
+			return
+		}
+	}
+	s.writePairing(w, r, pairing{userID: userID, name: payload.Name, scopes: scopes, listID: payload.ListID})
+}
+
+// writePairing stores entry under a new code and answers 201 with the code
+// and its QR payload.
+func (s *Server) writePairing(w http.ResponseWriter, r *http.Request, entry pairing) {
+	entry.expiresAt = time.Now().Add(pairingTTL)
+	code, err := s.pairings.put(entry)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	server := requestOrigin(r)
+	qr := url.URL{Scheme: pairingScheme, Opaque: "//pair", RawQuery: url.Values{"server": {server}, "code": {code}}.Encode()}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusCreated, jsonResponse{
+		"code":      code,
+		"server":    server,
+		"qr":        qr.String(),
+		"expiresAt": entry.expiresAt.Unix(),
+	})
+}
+
+// handlePairingRedeem exchanges a pairing code (POST {code, name}) for an API
+// token and, when the token may bootstrap, the bootstrap of the user's data.
+// It needs no session: the code is the credential. name, when given, replaces
+// the name chosen in the browser, so the device can name itself.
+func (s *Server) handlePairingRedeem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+	var payload struct {
+		Code string `json:"code"`
+		Name string `json:"name"`
+	}
+	if err := decodeJSON(r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	entry, ok := s.pairings.take(payload.Code)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "pairing code is invalid, expired, or already used"})
+		return
+	}
+	name := strings.TrimSpace(payload.Name)
+	if name == "" {
+		name = entry.name
+	}
+	token, secret, err := s.issueAPIToken(r.Context(), entry.userID, storage.APIToken{
+		Name:     name,
+		Scopes:   entry.scopes,
+		ListID:   entry.listID,
+		MemberID: entry.memberID,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("device paired token=%s", token.ID)
+	response := jsonResponse{"token": token, "secret": secret}
+	// The bootstrap holds every list, so it only comes along for tokens that
+	// could fetch it from /sync/bootstrap themselves.
+	if token.ListID == "" && token.MemberID == "" && (token.HasScope(storage.TokenScopeAdmin) || token.HasScope(storage.TokenScopeRead)) {
+		bootstrap, err := s.bootstrapPayload(r, entry.userID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		response["bootstrap"] = bootstrap
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, response)
+}
+
+// requestOrigin returns the origin the browser reached the server at, which
+// is where the paired device should connect to as well.
+func requestOrigin(r *http.Request) string {
+	if origin := r.Header.Get("Origin"); origin != "" && origin != "null" {
+		return origin
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,116 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestPairingExchangesCodeForTokenAndBootstrap(t *testing.T) {
+	store := newTestStore(t)
+	server := NewServer(store)
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	sessions := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := server.WithAPITokens(mux, sessions)
+
+	resp := doRequest(t, mux, http.MethodPost, "/lists", []byte(`{"title":"Groceries"}`))
+	var list struct {
+		ListID string `json:"listId"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("decode list: %v", err)
+	}
+
+	pair := func(body string) string {
+		t.Helper()
+		resp := doRequestWithHeaders(t, mux, http.MethodPost, "/auth/pairing", []byte(body), map[string]string{"Origin": "https://lists.example.com"})
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("create pairing %s: got %d %s", body, resp.Code, resp.Body.String())
+		}
+		var created struct {
+			Code   string `json:"code"`
+			Server string `json:"server"`
+			QR     string `json:"qr"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+			t.Fatalf("decode pairing: %v", err)
+		}
+		qr, err := url.Parse(created.QR)
+		if err != nil || qr.Scheme != "tasklists" || qr.Query().Get("code") != created.Code || qr.Query().Get("server") != "https://lists.example.com" {
+			t.Fatalf("unexpected QR payload %q", created.QR)
+		}
+		return created.Code
+	}
+	redeem := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/auth/pairing/redeem", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	type redeemed struct {
+		Token struct {
+			Name   string   `json:"name"`
+			Scopes []string `json:"scopes"`
+		} `json:"token"`
+		Secret    string          `json:"secret"`
+		Bootstrap json.RawMessage `json:"bootstrap"`
+	}
+
+	code := pair(`{"name":"Phone"}`)
+	resp = redeem(`{"code":"` + code + `","name":"Pixel"}`)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("redeem: got %d %s", resp.Code, resp.Body.String())
+	}
+	var device redeemed
+	if err := json.NewDecoder(resp.Body).Decode(&device); err != nil {
+		t.Fatalf("decode redeem: %v", err)
+	}
+	if device.Token.Name != "Pixel" || len(device.Token.Scopes) != 1 || device.Token.Scopes[0] != "admin" {
+		t.Fatalf("unexpected token %+v", device.Token)
+	}
+	var bootstrap struct {
+		DatasetGenerationKey string            `json:"datasetGenerationKey"`
+		Ops                  []json.RawMessage `json:"ops"`
+		ServerSeq            int64             `json:"serverSeq"`
+	}
+	if err := json.Unmarshal(device.Bootstrap, &bootstrap); err != nil || bootstrap.DatasetGenerationKey == "" || len(bootstrap.Ops) == 0 || bootstrap.ServerSeq == 0 {
+		t.Fatalf("unexpected bootstrap %s (%v)", device.Bootstrap, err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/lists", nil)
+	req.Header.Set("Authorization", "Bearer "+device.Secret)
+	authed := httptest.NewRecorder()
+	handler.ServeHTTP(authed, req)
+	if authed.Code != http.StatusOK {
+		t.Fatalf("expected the paired token to work, got %d %s", authed.Code, authed.Body.String())
+	}
+
+	// Codes are single-use.
+	if resp := redeem(`{"code":"` + code + `"}`); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected a used code to be rejected, got %d", resp.Code)
+	}
+	if resp := redeem(`{"code":"nope"}`); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected an unknown code to be rejected, got %d", resp.Code)
+	}
+
+	// A list token does not get the bootstrap, which holds every list.
+	resp = redeem(`{"code":"` + pair(`{"scopes":["read"],"listId":"`+list.ListID+`"}`) + `"}`)
+	var listDevice redeemed
+	if err := json.NewDecoder(resp.Body).Decode(&listDevice); err != nil {
+		t.Fatalf("decode redeem: %v", err)
+	}
+	if listDevice.Secret == "" || listDevice.Bootstrap != nil || listDevice.Token.Name != "Paired device" {
+		t.Fatalf("unexpected list pairing %+v", listDevice)
+	}
+
+	if resp := doRequest(t, mux, http.MethodPost, "/auth/pairing", []byte(`{"scopes":["everything"]}`)); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected an unknown scope to be rejected, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,124 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/storage"
+)
+
+// Ops that keep failing to materialize are quarantined (see the quarantine
+// package). These admin endpoints let an operator inspect them, put them back
+// into the op log (optionally with a fixed payload), or discard them.
+
+func (s *Server) handleAdminQuarantine(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	ops, err := s.store.ListQuarantinedOps(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{"ops": ops})
+}
+
+func (s *Server) handleAdminQuarantinedOp(w http.ResponseWriter, r *http.Request) {
+	serverSeq, err := strconv.ParseInt(r.PathValue("seq"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "seq must be a serverSeq"})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		op, err := s.store.GetQuarantinedOp(r.Context(), serverSeq)
+		if errors.Is(err, storage.ErrOpNotFound) {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, op)
+	case http.MethodDelete:
+		err := s.store.DeleteQuarantinedOp(r.Context(), serverSeq)
+		if errors.Is(err, storage.ErrOpNotFound) {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		log.Printf("admin quarantined op discarded server_seq=%d", serverSeq)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w)
+	}
+}
+
+// handleAdminRestoreQuarantinedOp puts a quarantined op back into its user's
+// op log, replacing its payload when the body carries one. The op gets a new
+// serverSeq, so clients pull it like any new op.
+func (s *Server) handleAdminRestoreQuarantinedOp(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+	serverSeq, err := strconv.ParseInt(r.PathValue("seq"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "seq must be a serverSeq"})
+		return
+	}
+	var payload struct {
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := decodeJSON(r, &payload); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	quarantined, err := s.store.GetQuarantinedOp(r.Context(), serverSeq)
+	if errors.Is(err, storage.ErrOpNotFound) {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	op := quarantined.Op
+	op.ServerSeq = 0
+	if len(payload.Payload) > 0 {
+		op.Payload = payload.Payload
+	}
+	if err := materialize.ValidateOp(op); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// Without a snapshot the build cannot fail, only reject the op.
+	if _, rejections, _ := materialize.BuildChecked("", []storage.Op{op}); len(rejections) > 0 {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("op still fails to materialize: %s", rejections[0].Reason)})
+		return
+	}
+	newServerSeq, err := s.store.InsertOps(r.Context(), quarantined.UserID, []storage.Op{op})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	// Inserting first keeps the op if deleting fails; restoring again is
+	// harmless because inserts are deduplicated.
+	if err := s.store.DeleteQuarantinedOp(r.Context(), serverSeq); err != nil && !errors.Is(err, storage.ErrOpNotFound) {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("admin quarantined op restored user=%s server_seq=%d new_server_seq=%d fixed=%t", quarantined.UserID, serverSeq, newServerSeq, len(payload.Payload) > 0)
+	writeJSON(w, http.StatusOK, jsonResponse{"serverSeq": newServerSeq})
+}
@@ -0,0 +1,95 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"a4-tasklists/server/internal/quarantine"
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestPoisonOpIsQuarantinedAndRestored(t *testing.T) {
+	store := newTestStore(t)
+	server := NewServerWithConfig(store, Config{Quarantine: quarantine.New(store, 2)})
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	server.RegisterAdminRoutes(mux)
+	bootstrap := fetchBootstrap(t, mux)
+	body, _ := json.Marshal(map[string]any{
+		"clientId":             "client-1",
+		"datasetGenerationKey": bootstrap.DatasetGenerationKey,
+		"ops": []map[string]any{
+			{"scope": "list", "resourceId": "list-1", "actor": "actor-1", "clock": 1, "payload": map[string]any{"type": "insert", "itemId": "item-1", "payload": map[string]any{"data": map[string]any{"text": "milk"}}}},
+			{"scope": "list", "resourceId": "list-1", "actor": "actor-1", "clock": 2, "payload": map[string]any{"type": "update", "itemId": 42}},
+		},
+	})
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/push", body); resp.Code != http.StatusOK {
+		t.Fatalf("push status: got %d", resp.Code)
+	}
+	pull := func() []storage.Op {
+		t.Helper()
+		resp := doRequest(t, mux, http.MethodGet, "/sync/pull?since=0&clientId=client-2&datasetGenerationKey="+url.QueryEscape(bootstrap.DatasetGenerationKey), nil)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("pull status: got %d", resp.Code)
+		}
+		var payload struct {
+			Ops []storage.Op `json:"ops"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode pull: %v", err)
+		}
+		return payload.Ops
+	}
+	listQuarantine := func() []storage.QuarantinedOp {
+		t.Helper()
+		resp := doRequest(t, mux, http.MethodGet, "/admin/quarantine", nil)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("quarantine status: got %d", resp.Code)
+		}
+		var payload struct {
+			Ops []storage.QuarantinedOp `json:"ops"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode quarantine: %v", err)
+		}
+		return payload.Ops
+	}
+
+	// The first failure is only counted; the second reaches the threshold.
+	for range 2 {
+		if resp := doRequest(t, mux, http.MethodGet, "/lists", nil); resp.Code != http.StatusOK {
+			t.Fatalf("lists status: got %d", resp.Code)
+		}
+	}
+	quarantined := listQuarantine()
+	if len(quarantined) != 1 || quarantined[0].UserID != "user-1" || quarantined[0].Clock != 2 || quarantined[0].Reason == "" {
+		t.Fatalf("unexpected quarantine: %+v", quarantined)
+	}
+	if ops := pull(); len(ops) != 1 || ops[0].Clock != 1 {
+		t.Fatalf("quarantined op must not be pulled: %+v", ops)
+	}
+
+	path := "/admin/quarantine/" + strconv.FormatInt(quarantined[0].ServerSeq, 10)
+	if resp := doRequest(t, mux, http.MethodGet, path, nil); resp.Code != http.StatusOK {
+		t.Fatalf("get quarantined op status: got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodPost, path+"/restore", nil); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 restoring the unchanged op, got %d", resp.Code)
+	}
+	fixed := []byte(`{"payload":{"type":"update","itemId":"item-1","payload":{"data":{"done":true}}}}`)
+	if resp := doRequest(t, mux, http.MethodPost, path+"/restore", fixed); resp.Code != http.StatusOK {
+		t.Fatalf("restore status: got %d: %s", resp.Code, resp.Body.String())
+	}
+	if ops := pull(); len(ops) != 2 || ops[1].Clock != 2 || ops[1].ServerSeq <= quarantined[0].ServerSeq {
+		t.Fatalf("expected the fixed op with a new serverSeq, got %+v", ops)
+	}
+	if len(listQuarantine()) != 0 {
+		t.Fatal("restored op must leave the quarantine")
+	}
+	if resp := doRequest(t, mux, http.MethodDelete, path, nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 discarding a restored op, got %d", resp.Code)
+	}
+}
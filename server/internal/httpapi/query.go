@@ -0,0 +1,160 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/storage"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 500
+)
+
+// page is a window into a query result selected by ?limit=&offset=.
+type page struct {
+	Limit  int
+	Offset int
+}
+
+func parsePage(query url.Values) (page, error) {
+	p := page{Limit: defaultPageLimit}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return page{}, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		p.Limit = limit
+	}
+	if value := query.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return page{}, errors.New("offset must be a non-negative integer")
+		}
+		p.Offset = offset
+	}
+	return p, nil
+}
+
+// paginate slices entries to the page and adds total and, when more entries
+// follow, nextOffset to payload.
+func paginate[T any](entries []T, p page, payload jsonResponse) []T {
+	payload["total"] = len(entries)
+	start := min(p.Offset, len(entries))
+	end := min(start+p.Limit, len(entries))
+	if end < len(entries) {
+		payload["nextOffset"] = end
+	}
+	return entries[start:end]
+}
+
+// handleLists serves GET (query) and POST (create) on /lists.
+func (s *Server) handleLists(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleQueryLists(w, r)
+	case http.MethodPost:
+		s.handleCreateList(w, r)
+	default:
+		methodNotAllowed(w)
+	}
+}
+
+// handleListItems serves GET (query) and POST (create) on /lists/{id}/items.
+func (s *Server) handleListItems(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleQueryItems(w, r)
+	case http.MethodPost:
+		s.handleCreateItems(w, r)
+	default:
+		methodNotAllowed(w)
+	}
+}
+
+// handleQueryLists pages through the visible lists in order. Archived lists
+// are left out unless ?archived=include, as in sync responses.
+func (s *Server) handleQueryLists(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	p, err := parsePage(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	state, err := s.loadState(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	archived, err := s.loadArchivedView(r, userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	lists := make([]listSummary, 0, len(state.Lists))
+	for _, list := range state.Lists {
+		if archived.Hide && slices.Contains(archived.ListIDs, list.ID) {
+			continue
+		}
+		lists = append(lists, listSummary{ListID: list.ID, Title: list.Title, ItemCount: len(list.Items)})
+	}
+	payload := jsonResponse{}
+	payload["lists"] = paginate(lists, p, payload)
+	archived.annotate(payload)
+	writeJSON(w, http.StatusOK, payload)
+}
+
+// handleQueryItems pages through a list's items in order, optionally filtered
+// by ?done=true|false and ?tag=.
+func (s *Server) handleQueryItems(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	p, err := parsePage(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var done *bool
+	switch query.Get("done") {
+	case "":
+	case "true", "false":
+		value := query.Get("done") == "true"
+		done = &value
+	default:
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "done must be true or false"})
+		return
+	}
+	if query.Has("dueBefore") {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "dueBefore is not supported: items have no due dates"})
+		return
+	}
+	tag := storage.NormalizeTag(query.Get("tag"))
+	state, err := s.loadState(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	list, ok := state.FindList(r.PathValue("id"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: materialize.ErrListNotFound.Error()})
+		return
+	}
+	items := slices.DeleteFunc(list.Items, func(item materialize.Item) bool {
+		return (done != nil && item.Done != *done) || (tag != "" && !slices.Contains(item.Tags, tag))
+	})
+	payload := jsonResponse{"listId": list.ID, "title": list.Title}
+	payload["items"] = paginate(items, p, payload)
+	writeJSON(w, http.StatusOK, payload)
+}
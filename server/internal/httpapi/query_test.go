@@ -0,0 +1,97 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"a4-tasklists/server/internal/materialize"
+)
+
+func TestQueryListsAndItemsFiltersAndPages(t *testing.T) {
+	mux := newTestMux(t)
+	bootstrap := fetchBootstrap(t, mux)
+	createList := func(body string) (string, []string) {
+		t.Helper()
+		resp := doRequest(t, mux, http.MethodPost, "/lists", []byte(body))
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("create list status: got %d", resp.Code)
+		}
+		var created struct {
+			ListID  string   `json:"listId"`
+			ItemIDs []string `json:"itemIds"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+			t.Fatalf("decode create list: %v", err)
+		}
+		return created.ListID, created.ItemIDs
+	}
+	listID, itemIDs := createList(`{"title":"Groceries","items":[{"text":"milk","done":true},{"text":"eggs"},{"text":"bread"},{"text":"butter"}]}`)
+	archivedID, _ := createList(`{"title":"Old"}`)
+	body, _ := json.Marshal(map[string]any{
+		"clientId":             "client-a",
+		"datasetGenerationKey": bootstrap.DatasetGenerationKey,
+		"ops": []map[string]any{
+			{"scope": "list", "resourceId": listID, "actor": "actor-a", "clock": 100, "payload": map[string]any{"type": "addTag", "itemId": itemIDs[2], "payload": map[string]any{"tag": "Bakery"}}},
+		},
+	})
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/push", body); resp.Code != http.StatusOK {
+		t.Fatalf("push status: got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodPut, "/lists/"+archivedID+"/archive", []byte(`{"archived":true}`)); resp.Code != http.StatusNoContent {
+		t.Fatalf("archive status: got %d", resp.Code)
+	}
+
+	var lists struct {
+		Lists         []listSummary `json:"lists"`
+		Total         int           `json:"total"`
+		ArchivedLists []string      `json:"archivedLists"`
+	}
+	resp := doRequest(t, mux, http.MethodGet, "/lists", nil)
+	if err := json.NewDecoder(resp.Body).Decode(&lists); err != nil {
+		t.Fatalf("decode lists: %v", err)
+	}
+	if lists.Total != 1 || lists.Lists[0] != (listSummary{ListID: listID, Title: "Groceries", ItemCount: 4}) || len(lists.ArchivedLists) != 1 {
+		t.Fatalf("unexpected lists: %+v", lists)
+	}
+	resp = doRequest(t, mux, http.MethodGet, "/lists?archived=include", nil)
+	if err := json.NewDecoder(resp.Body).Decode(&lists); err != nil {
+		t.Fatalf("decode lists: %v", err)
+	}
+	if lists.Total != 2 {
+		t.Fatalf("expected archived list to be included, got %+v", lists)
+	}
+
+	type itemsPage struct {
+		Items      []materialize.Item `json:"items"`
+		Total      int                `json:"total"`
+		NextOffset *int               `json:"nextOffset"`
+	}
+	fetch := func(query string) itemsPage {
+		t.Helper()
+		resp := doRequest(t, mux, http.MethodGet, "/lists/"+listID+"/items"+query, nil)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("items%s status: got %d", query, resp.Code)
+		}
+		var got itemsPage
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatalf("decode items: %v", err)
+		}
+		return got
+	}
+	if got := fetch("?done=false&limit=2"); got.Total != 3 || len(got.Items) != 2 || got.Items[0].Text != "eggs" || got.NextOffset == nil || *got.NextOffset != 2 {
+		t.Fatalf("unexpected first page: %+v", got)
+	}
+	if got := fetch("?done=false&limit=2&offset=2"); len(got.Items) != 1 || got.Items[0].Text != "butter" || got.NextOffset != nil {
+		t.Fatalf("unexpected second page: %+v", got)
+	}
+	if got := fetch("?tag=bakery"); got.Total != 1 || got.Items[0].ID != itemIDs[2] {
+		t.Fatalf("unexpected tag filter result: %+v", got)
+	}
+	if resp := doRequest(t, mux, http.MethodGet, "/lists/"+listID+"/items?limit=0", nil); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for limit=0, got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodGet, "/lists/missing/items", nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown list, got %d", resp.Code)
+	}
+}
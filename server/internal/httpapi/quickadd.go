@@ -0,0 +1,172 @@
+package httpapi
+
+import (
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/quickadd"
+	"a4-tasklists/server/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// maxQuickAddBytes caps a quick-add body, which holds a single line of text.
+const maxQuickAddBytes = 4 << 10
+
+// quickAddRequest is a quick-add body. Plain text and form bodies only carry
+// the text; listId and tz may also be given as query parameters.
+type quickAddRequest struct {
+	Text   string `json:"text"`
+	ListID string `json:"listId"`
+	TZ     string `json:"tz"`
+}
+
+// readQuickAdd reads a JSON, form or plain text quick-add body.
+func readQuickAdd(w http.ResponseWriter, r *http.Request) (quickAddRequest, error) {
+	req := quickAddRequest{ListID: r.URL.Query().Get("listId"), TZ: r.URL.Query().Get("tz")}
+	r.Body = http.MaxBytesReader(w, r.Body, maxQuickAddBytes)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		if err := decodeJSON(r, &req); err != nil {
+			return req, err
+		}
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		req.Text = r.FormValue("text")
+		if listID := r.FormValue("listId"); listID != "" {
+			req.ListID = listID
+		}
+		if tz := r.FormValue("tz"); tz != "" {
+			req.TZ = tz
+		}
+	default:
+		text, err := io.ReadAll(r.Body)
+		if err != nil {
+			return req, err
+		}
+		req.Text = string(text)
+	}
+	return req, nil
+}
+
+// quickAddList picks the list for an entry: the first hashtag naming a list
+// (which is then not used as a tag), else listID, else the first list that is
+// not archived.
+func quickAddList(state materialize.State, archived []string, entry *quickadd.Entry, listID string) (materialize.List, bool) {
+	key := func(s string) string {
+		return strings.NewReplacer(" ", "", "-", "", "_", "").Replace(strings.ToLower(s))
+	}
+	for i, tag := range entry.Hashtags {
+		for _, list := range state.Lists {
+			if key(list.Title) == key(tag) {
+				entry.Hashtags = slices.Delete(entry.Hashtags, i, i+1)
+				return list, true
+			}
+		}
+	}
+	if listID != "" {
+		return state.FindList(listID)
+	}
+	for _, list := range state.Lists {
+		if !slices.Contains(archived, list.ID) {
+			return list, true
+		}
+	}
+	return materialize.List{}, false
+}
+
+// handleQuickAdd appends one item from a line of text such as "milk tomorrow
+// #groceries", for phone shortcuts. It needs an API token with the add or
+// quick-add scope; a token restricted to one list always adds to that list.
+// Items have no due date field, so a parsed due date goes in the note.
+func (s *Server) handleQuickAdd(w http.ResponseWriter, r *http.Request) {
+	token, ok := s.integrationToken(w, r, http.MethodPost, "")
+	if !ok {
+		return
+	}
+	if !token.HasScope(storage.TokenScopeAdd) && !token.HasScope(storage.TokenScopeQuickAdd) {
+		writeJSON(w, http.StatusForbidden, errorResponse{Error: "token lacks the add or quick-add scope"})
+		return
+	}
+	req, err := readQuickAdd(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.TZ == "" {
+		profile, err := s.store.GetUserProfile(r.Context(), token.UserID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		req.TZ = profile.TimeZone
+	}
+	location, err := loadTimeZone(req.TZ)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	entry := quickadd.Parse(req.Text, time.Now().In(location))
+	if entry.Text == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "text is required"})
+		return
+	}
+	g, release, err := s.newGenerator(r.Context(), token.UserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer release()
+	archived, err := s.store.ListArchivedLists(r.Context(), token.UserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	var list materialize.List
+	if token.ListID != "" {
+		if req.ListID != "" && !token.AllowsList(req.ListID) {
+			writeJSON(w, http.StatusForbidden, errorResponse{Error: "token is restricted to list " + token.ListID})
+			return
+		}
+		list, ok = g.State().FindList(token.ListID)
+	} else {
+		list, ok = quickAddList(g.State(), archived, &entry, req.ListID)
+	}
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: materialize.ErrListNotFound.Error()})
+		return
+	}
+	item := materialize.NewItem{ID: "task-" + uuid.NewString(), Text: entry.Text, Tags: entry.Hashtags}
+	if entry.Due != "" {
+		item.Note = "due " + entry.Due
+	}
+	if err := g.AppendItems(list.ID, []materialize.NewItem{item}); err != nil {
+		writeGenerateError(w, err)
+		return
+	}
+	serverSeq, err := s.insertServerOps(r.Context(), token.UserID, g.Ops())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("quick-add item created token=%s list=%s tags=%d", token.ID, list.ID, len(item.Tags))
+	tags := item.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	writeJSON(w, http.StatusCreated, jsonResponse{
+		"itemId":    item.ID,
+		"listId":    list.ID,
+		"listTitle": list.Title,
+		"text":      item.Text,
+		"tags":      tags,
+		"due":       entry.Due,
+		"serverSeq": serverSeq,
+	})
+}
@@ -0,0 +1,80 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"a4-tasklists/server/internal/materialize"
+)
+
+func TestQuickAddParsesListHashtagAndDueDate(t *testing.T) {
+	mux := newTestMux(t)
+	doRequest(t, mux, http.MethodPost, "/lists", []byte(`{"title":"Inbox"}`))
+	resp := doRequest(t, mux, http.MethodPost, "/lists", []byte(`{"title":"Groceries"}`))
+	var list struct {
+		ListID string `json:"listId"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("decode create list: %v", err)
+	}
+	resp = doRequest(t, mux, http.MethodPost, "/auth/tokens", []byte(`{"name":"Shortcut","scopes":["add"]}`))
+	var created struct {
+		Secret string `json:"secret"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode token: %v", err)
+	}
+	type result struct {
+		ListTitle string   `json:"listTitle"`
+		Text      string   `json:"text"`
+		Tags      []string `json:"tags"`
+		Due       string   `json:"due"`
+	}
+	quickAdd := func(path string, contentType string, body string) (int, result) {
+		t.Helper()
+		headers := map[string]string{"Authorization": "Bearer " + created.Secret}
+		if contentType != "" {
+			headers["Content-Type"] = contentType
+		}
+		resp := doRequestWithHeaders(t, mux, http.MethodPost, path, []byte(body), headers)
+		var decoded result
+		if resp.Code == http.StatusCreated {
+			if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+				t.Fatalf("decode quick-add: %v", err)
+			}
+		}
+		return resp.Code, decoded
+	}
+
+	code, got := quickAdd("/quick-add?tz=UTC", "text/plain", "milk tomorrow #groceries #Fridge")
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format("2006-01-02")
+	if code != http.StatusCreated || got.ListTitle != "Groceries" || got.Text != "milk" || strings.Join(got.Tags, ",") != "fridge" || got.Due != tomorrow {
+		t.Fatalf("unexpected quick-add: %d %+v", code, got)
+	}
+	if code, got := quickAdd("/quick-add", "application/json", `{"text":"call the plumber"}`); code != http.StatusCreated || got.ListTitle != "Inbox" || got.Due != "" {
+		t.Fatalf("expected the first list without a list hashtag, got %d %+v", code, got)
+	}
+	if code, got := quickAdd("/quick-add", "application/x-www-form-urlencoded", "text=bread+%23groceries"); code != http.StatusCreated || got.ListTitle != "Groceries" {
+		t.Fatalf("unexpected form quick-add: %d %+v", code, got)
+	}
+	if code, _ := quickAdd("/quick-add?tz=Nowhere/Special", "text/plain", "milk"); code != http.StatusBadRequest {
+		t.Fatalf("expected unknown time zone to be rejected, got %d", code)
+	}
+	if code, _ := quickAdd("/quick-add?listId=nope", "text/plain", "milk"); code != http.StatusNotFound {
+		t.Fatalf("expected unknown list to be 404, got %d", code)
+	}
+
+	resp = doRequest(t, mux, http.MethodGet, "/lists/"+list.ListID+"/items", nil)
+	var groceries struct {
+		Items []materialize.Item `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&groceries); err != nil {
+		t.Fatalf("decode items: %v", err)
+	}
+	if items := groceries.Items; len(items) != 2 || items[0].Note != "due "+tomorrow || items[0].Tags[0] != "fridge" {
+		t.Fatalf("unexpected groceries: %+v", items)
+	}
+}
@@ -0,0 +1,288 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// maxRESTItems caps how many items one REST request may create, so a single
+// call cannot append an unbounded batch to the op log.
+const maxRESTItems = 500
+
+// restItem is an item in a REST request body.
+type restItem struct {
+	Text string `json:"text"`
+	Note string `json:"note"`
+	Done bool   `json:"done"`
+}
+
+// newItems assigns client-style ids to items.
+func newItems(items []restItem) ([]materialize.NewItem, []string) {
+	created := make([]materialize.NewItem, 0, len(items))
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		id := "task-" + uuid.NewString()
+		created = append(created, materialize.NewItem{ID: id, Text: item.Text, Note: item.Note, Done: item.Done})
+		ids = append(ids, id)
+	}
+	return created, ids
+}
+
+// newGenerator loads the user's active snapshot and streams the ops logged
+// after it into a generator signed by the user's server actor, with clocks
+// above any seen so far. The actor is shared by every request for the user,
+// so s.serverWrites stays held until release is called, once the generated
+// ops are stored.
+func (s *Server) newGenerator(ctx context.Context, userID string) (g *materialize.Generator, release func(), err error) {
+	s.serverWrites.Lock()
+	defer func() {
+		if err != nil {
+			s.serverWrites.Unlock()
+		}
+	}()
+	actor, err := s.store.ServerActor(ctx, userID, "server-"+uuid.NewString())
+	if err != nil {
+		return nil, nil, err
+	}
+	snapshot, err := s.store.GetSnapshot(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	g, err = materialize.NewGenerator(snapshot.Blob, nil, actor)
+	if err != nil {
+		return nil, nil, fmt.Errorf("materialize state: %w", err)
+	}
+	if _, err = s.store.ForEachOpSince(ctx, userID, 0, func(op storage.Op) error {
+		g.Observe(op)
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+	return g, s.serverWrites.Unlock, nil
+}
+
+// insertServerOps stores ops the server generated, binding their actor to the
+// "server" client first like a push would. Notes are checked in place, so ops
+// returned to the caller carry the stored notes.
+func (s *Server) insertServerOps(ctx context.Context, userID string, ops []storage.Op) (int64, error) {
+	for i, op := range ops {
+		checked, err := s.notes.CheckOp(op)
+		if err != nil {
+			return 0, err
+		}
+		ops[i] = checked
+	}
+	if err := s.store.BindActors(ctx, userID, "server", opActors(ops)); err != nil {
+		return 0, err
+	}
+	serverSeq, err := s.store.InsertOps(ctx, userID, ops)
+	if err != nil {
+		return 0, err
+	}
+	s.compaction.Trigger(userID)
+	return serverSeq, nil
+}
+
+// writeGenerateError maps generator errors to responses.
+func writeGenerateError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, materialize.ErrListNotFound), errors.Is(err, materialize.ErrItemNotFound):
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// appendItems appends items to the end of listID and stores the ops.
+func (s *Server) appendItems(ctx context.Context, userID string, listID string, items []restItem) ([]string, []storage.Op, int64, error) {
+	g, release, err := s.newGenerator(ctx, userID)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer release()
+	created, itemIDs := newItems(items)
+	if err := g.AppendItems(listID, created); err != nil {
+		return nil, nil, 0, err
+	}
+	serverSeq, err := s.insertServerOps(ctx, userID, g.Ops())
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return itemIDs, g.Ops(), serverSeq, nil
+}
+
+// updateItem changes an item and stores the op. An empty listID looks the
+// item up across all lists.
+func (s *Server) updateItem(ctx context.Context, userID string, listID string, itemID string, change materialize.ItemChange) ([]storage.Op, int64, error) {
+	g, release, err := s.newGenerator(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer release()
+	if listID == "" {
+		for _, item := range g.State().Items() {
+			if item.ID == itemID {
+				listID = item.ListID
+				break
+			}
+		}
+		if listID == "" {
+			return nil, 0, materialize.ErrItemNotFound
+		}
+	}
+	if err := g.UpdateItem(listID, itemID, change); err != nil {
+		return nil, 0, err
+	}
+	serverSeq, err := s.insertServerOps(ctx, userID, g.Ops())
+	if err != nil {
+		return nil, 0, err
+	}
+	return g.Ops(), serverSeq, nil
+}
+
+// handleCreateList creates a list, optionally with items, from a plain REST
+// request (POST {title, items}).
+func (s *Server) handleCreateList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	var payload struct {
+		Title string     `json:"title"`
+		Items []restItem `json:"items"`
+	}
+	if err := decodeJSON(r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if payload.Title == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "title is required"})
+		return
+	}
+	if len(payload.Items) > maxRESTItems {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("at most %d items per request", maxRESTItems)})
+		return
+	}
+	g, release, err := s.newGenerator(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer release()
+	listID := "list-" + uuid.NewString()
+	items, itemIDs := newItems(payload.Items)
+	if err := g.CreateList(listID, payload.Title); err != nil {
+		writeGenerateError(w, err)
+		return
+	}
+	if err := g.AppendItems(listID, items); err != nil {
+		writeGenerateError(w, err)
+		return
+	}
+	serverSeq, err := s.insertServerOps(r.Context(), userID, g.Ops())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("rest list created list=%s items=%d", listID, len(items))
+	writeJSON(w, http.StatusCreated, jsonResponse{
+		"listId":    listID,
+		"itemIds":   itemIDs,
+		"ops":       g.Ops(),
+		"serverSeq": serverSeq,
+	})
+}
+
+// handleCreateItems appends items to the end of a list (POST {items}).
+func (s *Server) handleCreateItems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	var payload struct {
+		Items []restItem `json:"items"`
+	}
+	if err := decodeJSON(r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(payload.Items) == 0 {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "items are required"})
+		return
+	}
+	if len(payload.Items) > maxRESTItems {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("at most %d items per request", maxRESTItems)})
+		return
+	}
+	listID := r.PathValue("id")
+	itemIDs, ops, serverSeq, err := s.appendItems(r.Context(), userID, listID, payload.Items)
+	if err != nil {
+		writeGenerateError(w, err)
+		return
+	}
+	log.Printf("rest items created list=%s items=%d", listID, len(itemIDs))
+	writeJSON(w, http.StatusCreated, jsonResponse{
+		"itemIds":   itemIDs,
+		"ops":       ops,
+		"serverSeq": serverSeq,
+	})
+}
+
+// handlePatchItem changes an item's text, note or done flag (PATCH {listId,
+// text, note, done}). listId is optional; without it the item is looked up
+// across all lists.
+func (s *Server) handlePatchItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	var payload struct {
+		ListID string  `json:"listId"`
+		Text   *string `json:"text"`
+		Note   *string `json:"note"`
+		Done   *bool   `json:"done"`
+	}
+	if err := decodeJSON(r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if payload.Text == nil && payload.Note == nil && payload.Done == nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "text, note or done is required"})
+		return
+	}
+	// Household members name the list, so the item is only looked up there.
+	if member, ok := householdMember(r); ok && !slices.Contains(member.ListIDs, payload.ListID) {
+		writeJSON(w, http.StatusForbidden, errorResponse{Error: "household members must name one of their lists as listId"})
+		return
+	}
+	ops, serverSeq, err := s.updateItem(r.Context(), userID, payload.ListID, r.PathValue("id"), materialize.ItemChange{Text: payload.Text, Note: payload.Note, Done: payload.Done})
+	if err != nil {
+		writeGenerateError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{
+		"ops":       ops,
+		"serverSeq": serverSeq,
+	})
+}
@@ -0,0 +1,148 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestRESTFacadeCreatesListsAndItemsAsOps(t *testing.T) {
+	store := newTestStore(t)
+	mux := http.NewServeMux()
+	NewServer(store).RegisterRoutes(mux)
+	bootstrap := fetchBootstrap(t, mux)
+
+	if resp := doRequest(t, mux, http.MethodPost, "/lists", []byte(`{"title":""}`)); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without title, got %d", resp.Code)
+	}
+	resp := doRequest(t, mux, http.MethodPost, "/lists", []byte(`{"title":"Groceries","items":[{"text":"milk"},{"text":"eggs","note":"a dozen"}]}`))
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("create list status: got %d", resp.Code)
+	}
+	var created struct {
+		ListID    string            `json:"listId"`
+		ItemIDs   []string          `json:"itemIds"`
+		Ops       []json.RawMessage `json:"ops"`
+		ServerSeq int64             `json:"serverSeq"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode create list: %v", err)
+	}
+	if created.ListID == "" || len(created.ItemIDs) != 2 || len(created.Ops) != 3 || created.ServerSeq != 3 {
+		t.Fatalf("unexpected create list response: %+v", created)
+	}
+
+	resp = doRequest(t, mux, http.MethodPost, "/lists/"+created.ListID+"/items", []byte(`{"items":[{"text":"bread"}]}`))
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("create items status: got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodPost, "/lists/missing/items", []byte(`{"items":[{"text":"bread"}]}`)); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown list, got %d", resp.Code)
+	}
+
+	resp = doRequest(t, mux, http.MethodPatch, "/items/"+created.ItemIDs[0], []byte(`{"done":true}`))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("patch status: got %d", resp.Code)
+	}
+	var patched struct {
+		Ops       []json.RawMessage `json:"ops"`
+		ServerSeq int64             `json:"serverSeq"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&patched); err != nil {
+		t.Fatalf("decode patch: %v", err)
+	}
+	if len(patched.Ops) != 1 || patched.ServerSeq != 5 {
+		t.Fatalf("unexpected patch response: %+v", patched)
+	}
+	if resp := doRequest(t, mux, http.MethodPatch, "/items/missing", []byte(`{"done":true}`)); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown item, got %d", resp.Code)
+	}
+
+	// Sync clients see the same result through the op log.
+	resp = doRequest(t, mux, http.MethodGet, "/sync/pull?since=0&clientId=client-a&datasetGenerationKey="+bootstrap.DatasetGenerationKey, nil)
+	var pulled struct {
+		Ops []json.RawMessage `json:"ops"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pulled); err != nil {
+		t.Fatalf("decode pull: %v", err)
+	}
+	if len(pulled.Ops) != 5 {
+		t.Fatalf("expected 5 ops, got %d", len(pulled.Ops))
+	}
+	state, err := NewServer(store).loadState(context.Background(), "user-1")
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	list, ok := state.FindList(created.ListID)
+	if !ok || list.Title != "Groceries" || len(list.Items) != 3 {
+		t.Fatalf("unexpected list: %+v", list)
+	}
+	want := []materialize.Item{
+		{ID: created.ItemIDs[0], Text: "milk", Done: true},
+		{ID: created.ItemIDs[1], Text: "eggs", Note: "a dozen"},
+		{Text: "bread"},
+	}
+	for i, item := range list.Items {
+		if (want[i].ID != "" && item.ID != want[i].ID) || item.Text != want[i].Text || item.Done != want[i].Done || item.Note != want[i].Note {
+			t.Fatalf("item %d: got %+v, want %+v", i, item, want[i])
+		}
+	}
+}
+
+// slowSnapshotStore delays snapshot reads, so concurrent writers all read the
+// state before any of them stores its ops unless they are serialized.
+type slowSnapshotStore struct {
+	storage.Store
+}
+
+func (s slowSnapshotStore) GetSnapshot(ctx context.Context, userID string) (storage.Snapshot, error) {
+	time.Sleep(10 * time.Millisecond)
+	return s.Store.GetSnapshot(ctx, userID)
+}
+
+func TestRESTWritesShareOneServerActor(t *testing.T) {
+	store := newTestStore(t)
+	mux := http.NewServeMux()
+	NewServer(slowSnapshotStore{store}).RegisterRoutes(mux)
+
+	resp := doRequest(t, mux, http.MethodPost, "/lists", []byte(`{"title":"Groceries"}`))
+	var created struct {
+		ListID string `json:"listId"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil || resp.Code != http.StatusCreated {
+		t.Fatalf("create list: %d %v", resp.Code, err)
+	}
+	// Concurrent writes sign with the same actor, so they must not take the
+	// same clocks and be dropped as duplicates.
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			doRequest(t, mux, http.MethodPost, "/lists/"+created.ListID+"/items", []byte(`{"items":[{"text":"milk"}]}`))
+		})
+	}
+	wg.Wait()
+
+	ops, _, err := store.GetOpsSince(context.Background(), "user-1", 0)
+	if err != nil || len(ops) != 9 {
+		t.Fatalf("expected 9 ops, got %d (%v)", len(ops), err)
+	}
+	for _, op := range ops {
+		if op.Actor != ops[0].Actor || !strings.HasPrefix(op.Actor, "server-") {
+			t.Fatalf("expected one server actor, got %q and %q", ops[0].Actor, op.Actor)
+		}
+	}
+	state, err := NewServer(store).loadState(context.Background(), "user-1")
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	if list, ok := state.FindList(created.ListID); !ok || len(list.Items) != 8 {
+		t.Fatalf("unexpected list: %+v", list)
+	}
+}
@@ -1,16 +1,35 @@
 package httpapi
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"slices"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"a4-tasklists/server/internal/auth"
+	"a4-tasklists/server/internal/backup"
+	"a4-tasklists/server/internal/compaction"
+	"a4-tasklists/server/internal/export"
+	"a4-tasklists/server/internal/features"
+	"a4-tasklists/server/internal/fleet"
+	"a4-tasklists/server/internal/i18n"
+	"a4-tasklists/server/internal/mail"
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/notes"
+	"a4-tasklists/server/internal/outbox"
+	"a4-tasklists/server/internal/quarantine"
+	"a4-tasklists/server/internal/spool"
+	"a4-tasklists/server/internal/stats"
 	"a4-tasklists/server/internal/storage"
+	"a4-tasklists/server/internal/traffic"
 )
 
 type jsonResponse map[string]any
@@ -19,20 +38,239 @@ type errorResponse struct {
 	Error string `json:"error"`
 }
 
+// Config holds optional behavior for the sync API. The zero value matches the
+// plain protocol with all optional features disabled.
+type Config struct {
+	// Compaction, when set, is triggered after pushes so large op logs are
+	// folded into a fresh snapshot generation in the background.
+	Compaction *compaction.Compactor
+
+	// SnapshotChunkBytes caps the size of a single GET /sync/snapshot
+	// response. Zero selects a 1 MiB default.
+	SnapshotChunkBytes int
+
+	// Features gates optional capabilities per deployment and user. Nil uses
+	// the feature defaults.
+	Features *features.Flags
+
+	// Reload, when set, re-reads the reloadable settings for POST
+	// /admin/reload.
+	Reload func() (ReloadResult, error)
+
+	// CompressMinBytes is the payload size from which pulls that ask for
+	// compressed payloads get them compressed. Zero selects
+	// storage.DefaultCompressMinBytes.
+	CompressMinBytes int
+
+	// Notes limits the size of item notes and sanitizes them in pushed ops,
+	// uploaded snapshots, and ops the server generates. The zero value allows
+	// notes.DefaultMaxBytes.
+	Notes notes.Policy
+
+	// SignatureWindow is how far the timestamp of a signed request may be
+	// from the server's clock. Zero selects five minutes.
+	SignatureWindow time.Duration
+
+	// Settings, when set, serves /admin/settings for changing settings at
+	// runtime.
+	Settings RuntimeSettings
+
+	// Digests reports that a mail sender is configured, which users need
+	// before they can opt into email digests.
+	Digests bool
+
+	// AuthMode names how users are authenticated (SERVER_AUTH_MODE), reported
+	// by /healthz. Empty means OIDC.
+	AuthMode string
+
+	// Quarantine, when set, moves ops that keep failing to materialize out of
+	// the op log.
+	Quarantine *quarantine.Tracker
+
+	// Traffic, when set, records anonymized push and pull exchanges for
+	// replay.
+	Traffic *traffic.Recorder
+
+	// Stats, when set, counts pushed ops for the daily stats export.
+	Stats *stats.Exporter
+
+	// Fleet counts sync outcomes for /admin/fleet and decides when a client
+	// is stuck in a conflict loop. Nil counts with fleet.DefaultStuckConflicts.
+	Fleet *fleet.Counters
+
+	// Outbox delivers the side effects recorded with writes; the server adds
+	// its stream fan-out to it and dispatches after its own writes. Nil uses
+	// a dispatcher of the server's own, which nothing runs in the background.
+	Outbox *outbox.Dispatcher
+
+	// Spool, when set, journals pushes that fail because storage is degraded
+	// and acknowledges them with 202; ReplaySpooled applies them later.
+	Spool *spool.Journal
+
+	// Backups, when set, serves /admin/backups for listing, running, and
+	// restoring backups.
+	Backups *backup.Manager
+
+	// Exports, when set, lets users schedule exports of their lists to
+	// WebDAV, S3 and Git destinations and runs them on demand.
+	Exports *export.Runner
+
+	// Messages translates error messages and rendered lists; nil leaves them
+	// in English.
+	Messages *i18n.Catalog
+
+	// EmailTemplates, when set, serves previews of the emails under
+	// /admin/emails.
+	EmailTemplates *mail.Templates
+}
+
 type Server struct {
-	store storage.Store
+	store              storage.Store
+	compaction         *compaction.Compactor
+	snapshotChunkBytes int
+	compressMinBytes   int
+	notes              notes.Policy
+	features           atomic.Pointer[features.Flags]
+	digests            bool
+	oauth              *oauthGrants
+	authMode           string
+	reload             func() (ReloadResult, error)
+	settings           RuntimeSettings
+	signatures         *signatureCache
+	pairings           *pairings
+	signatureWindow    time.Duration
+	quarantine         *quarantine.Tracker
+	streams            *streamHub
+	fleet              *fleet.Counters
+	traffic            *traffic.Recorder
+	stats              *stats.Exporter
+	spool              *spool.Journal
+	backups            *backup.Manager
+	exports            *export.Runner
+	messages           *i18n.Catalog
+	emailTemplates     *mail.Templates
+	// adminWrites serializes admin changes that honor If-Match, so no other
+	// change lands between the check and the write.
+	adminWrites sync.Mutex
+	// serverWrites serializes the ops the server generates, which share one
+	// actor per user, so two requests cannot sign ops with the same clock.
+	serverWrites sync.Mutex
 }
 
 func NewServer(store storage.Store) *Server {
-	return &Server{store: store}
+	return NewServerWithConfig(store, Config{})
+}
+
+func NewServerWithConfig(store storage.Store, cfg Config) *Server {
+	chunkBytes := cfg.SnapshotChunkBytes
+	if chunkBytes <= 0 {
+		chunkBytes = defaultSnapshotChunkBytes
+	}
+	authMode := cfg.AuthMode
+	if authMode == "" {
+		authMode = "oidc"
+	}
+	signatureWindow := cfg.SignatureWindow
+	if signatureWindow <= 0 {
+		signatureWindow = defaultSignatureWindow
+	}
+	compressMinBytes := cfg.CompressMinBytes
+	if compressMinBytes <= 0 {
+		compressMinBytes = storage.DefaultCompressMinBytes
+	}
+	fleetCounters := cfg.Fleet
+	if fleetCounters == nil {
+		fleetCounters = fleet.NewCounters(fleet.DefaultStuckConflicts)
+	}
+	dispatcher := cfg.Outbox
+	if dispatcher == nil {
+		dispatcher = outbox.New(store)
+	}
+	streams := newStreamHub()
+	dispatcher.Handle(streams.deliver)
+	s := &Server{
+		store:              dispatchingStore{Store: store, outbox: dispatcher},
+		compaction:         cfg.Compaction,
+		snapshotChunkBytes: chunkBytes,
+		compressMinBytes:   compressMinBytes,
+		notes:              cfg.Notes,
+		digests:            cfg.Digests,
+		oauth:              newOAuthGrants(),
+		authMode:           authMode,
+		reload:             cfg.Reload,
+		settings:           cfg.Settings,
+		signatures:         newSignatureCache(),
+		pairings:           newPairings(),
+		signatureWindow:    signatureWindow,
+		quarantine:         cfg.Quarantine,
+		streams:            streams,
+		fleet:              fleetCounters,
+		traffic:            cfg.Traffic,
+		stats:              cfg.Stats,
+		spool:              cfg.Spool,
+		backups:            cfg.Backups,
+		exports:            cfg.Exports,
+		messages:           cfg.Messages,
+		emailTemplates:     cfg.EmailTemplates,
+	}
+	s.features.Store(cfg.Features)
+	return s
 }
 
 func (s *Server) RegisterRoutes(mux *http.ServeMux) {
-	mux.HandleFunc("/sync/bootstrap", s.handleBootstrap)
-	mux.HandleFunc("/sync/push", s.handlePush)
-	mux.HandleFunc("/sync/pull", s.handlePull)
-	mux.HandleFunc("/sync/reset", s.handleReset)
-	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/sync/bootstrap", s.requireSyncProtocolVersion(s.handleBootstrap))
+	mux.HandleFunc("/sync/push", s.requireSyncProtocolVersion(s.recordTraffic(traffic.KindPush, s.handlePush)))
+	mux.HandleFunc("/sync/pull", s.requireSyncProtocolVersion(s.recordTraffic(traffic.KindPull, s.handlePull)))
+	mux.HandleFunc("/sync/stream", s.requireSyncProtocolVersion(s.handleStream))
+	mux.HandleFunc("/sync/heartbeat", s.requireSyncProtocolVersion(s.handleHeartbeat))
+	mux.HandleFunc("/sync/reset", s.requireSyncProtocolVersion(s.handleReset))
+	mux.HandleFunc("/sync/snapshot", s.requireSyncProtocolVersion(s.handleSnapshot))
+	mux.HandleFunc("/tags", s.handleTags)
+	mux.HandleFunc("/items", s.handleItems)
+	mux.HandleFunc("/usage", s.handleUsage)
+	mux.HandleFunc("/me", s.handleMe)
+	mux.HandleFunc("/me/digest", s.handleDigest)
+	mux.HandleFunc("/me/timezone", s.handleTimeZone)
+	mux.HandleFunc("/me/locale", s.handleLocale)
+	mux.HandleFunc("/me/muted-lists", s.handleMutedLists)
+	mux.HandleFunc("/me/muted-lists/{id}", s.handleMuteList)
+	mux.HandleFunc("/me/exports", s.handleExports)
+	mux.HandleFunc("/me/exports/{id}", s.handleDeleteExport)
+	mux.HandleFunc("/me/exports/{id}/run", s.handleRunExport)
+	mux.HandleFunc("/auth/tokens", s.handleAPITokens)
+	mux.HandleFunc("/auth/tokens/{id}", s.handleRevokeAPIToken)
+	mux.HandleFunc("/auth/pairing", s.handlePairing)
+	mux.HandleFunc("/auth/pairing/redeem", s.handlePairingRedeem)
+	mux.HandleFunc("/household/members", s.handleHouseholdMembers)
+	mux.HandleFunc("/household/members/{id}", s.handleHouseholdMember)
+	mux.HandleFunc("/household/members/{id}/pairing", s.handleHouseholdMemberPairing)
+	mux.HandleFunc("/household/me", s.handleHouseholdMe)
+	mux.HandleFunc("/oauth/authorize", s.handleOAuthAuthorize)
+	mux.HandleFunc("/oauth/token", s.handleOAuthToken)
+	mux.HandleFunc("/mcp", s.handleMCP)
+	mux.HandleFunc("/integrations/me", s.handleIntegrationMe)
+	mux.HandleFunc("/integrations/lists", s.handleIntegrationLists)
+	mux.HandleFunc("/integrations/triggers/{trigger}", s.handleItemTrigger)
+	mux.HandleFunc("/integrations/actions/create-item", s.handleCreateItemAction)
+	mux.HandleFunc("/quick-add", s.handleQuickAdd)
+	mux.HandleFunc("/dav", s.handleDAV)
+	mux.HandleFunc("/dav/", s.handleDAV)
+	mux.HandleFunc("/import", s.handleImport)
+	mux.HandleFunc("/features", s.handleFeatures)
+	mux.HandleFunc("/badges", s.handleBadges)
+	mux.HandleFunc("/templates", s.handleTemplates)
+	mux.HandleFunc("/lists", s.handleLists)
+	mux.HandleFunc("/lists/{id}/items", s.handleListItems)
+	mux.HandleFunc("/items/{id}", s.handlePatchItem)
+	mux.HandleFunc("/lists/{id}/template", s.handleListTemplate)
+	mux.HandleFunc("/lists/{id}/duplicate", s.handleDuplicateList)
+	mux.HandleFunc("/lists/{id}/comments", s.handleComments)
+	mux.HandleFunc("/lists/{id}/archive", s.handleArchiveList)
+	mux.HandleFunc("/lists/archived", s.handleArchivedLists)
+	mux.HandleFunc("/graphql", s.handleGraphQL)
+	mux.HandleFunc("/clients", s.handleClients)
+	mux.HandleFunc("/clients/retire", s.handleRetireClient)
+	mux.HandleFunc("/healthz", s.HandleHealthz)
 }
 
 func (s *Server) handleBootstrap(w http.ResponseWriter, r *http.Request) {
@@ -49,17 +287,124 @@ func (s *Server) handleBootstrap(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	archived, err := s.loadSyncArchivedView(r, userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	blob, err := archived.filterSnapshot(snapshot.Blob)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	setETag(w, snapshot.DatasetGenerationKey)
+	payload := jsonResponse{
+		"datasetGenerationKey": snapshot.DatasetGenerationKey,
+		"snapshot":             blob,
+		"protocolVersion":      MaxSyncProtocolVersion,
+	}
+	archived.annotate(payload)
+	if r.URL.Query().Get("snapshot") == "chunked" && s.featureEnabled(r, features.ChunkedSnapshot) {
+		delete(payload, "snapshot")
+		payload["snapshotBytes"] = len(blob)
+		payload["snapshotSha256"] = sha256Hex([]byte(blob))
+		payload["snapshotChunkBytes"] = s.snapshotChunkBytes
+	}
+	if !acceptsCBOR(r) || !s.featureEnabled(r, features.BinaryTransport) {
+		s.streamBootstrap(w, r, userID, payload, archived)
+		return
+	}
 	ops, serverSeq, err := s.store.GetOpsSince(r.Context(), userID, 0)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, jsonResponse{
+	payload["serverSeq"] = serverSeq
+	payload["ops"] = archived.filterOps(ops)
+	s.writeNegotiated(w, r, http.StatusOK, payload)
+}
+
+// bootstrapPayload builds the payload of GET /sync/bootstrap in memory,
+// without the chunked, binary and streaming variants, for responses that hand
+// out a bootstrap along with something else.
+func (s *Server) bootstrapPayload(r *http.Request, userID string) (jsonResponse, error) {
+	snapshot, err := s.store.GetSnapshot(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	archived, err := s.loadSyncArchivedView(r, userID)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := archived.filterSnapshot(snapshot.Blob)
+	if err != nil {
+		return nil, err
+	}
+	ops, serverSeq, err := s.store.GetOpsSince(r.Context(), userID, 0)
+	if err != nil {
+		return nil, err
+	}
+	payload := jsonResponse{
 		"datasetGenerationKey": snapshot.DatasetGenerationKey,
-		"snapshot":             snapshot.Blob,
+		"snapshot":             blob,
+		"protocolVersion":      MaxSyncProtocolVersion,
+		"ops":                  archived.filterOps(ops),
 		"serverSeq":            serverSeq,
-		"ops":                  ops,
+	}
+	archived.annotate(payload)
+	return payload, nil
+}
+
+// streamBootstrap writes a JSON bootstrap response while the op log is read,
+// so bootstrapping a long op log does not hold it in memory. The response
+// starts with the first op; a store error after that can only abort it, which
+// the client sees as a failed request. serverSeq follows the ops since it is
+// only known at the end.
+func (s *Server) streamBootstrap(w http.ResponseWriter, r *http.Request, userID string, payload jsonResponse, archived archivedView) {
+	head, err := json.Marshal(payload)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	var body *bufio.Writer
+	start := func() {
+		if body != nil {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		body = bufio.NewWriter(w)
+		_, _ = body.Write(head[:len(head)-1])
+		_, _ = body.WriteString(`,"ops":[`)
+	}
+	first := true
+	serverSeq, err := s.store.ForEachOpSince(r.Context(), userID, 0, func(op storage.Op) error {
+		if archived.hides(op) {
+			return nil
+		}
+		encoded, err := json.Marshal(op)
+		if err != nil {
+			return err
+		}
+		start()
+		if !first {
+			_ = body.WriteByte(',')
+		}
+		first = false
+		_, err = body.Write(encoded)
+		return err
 	})
+	if err != nil {
+		if body == nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		log.Printf("sync bootstrap aborted mid-response: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+	start()
+	_, _ = fmt.Fprintf(body, "],\"serverSeq\":%d}\n", serverSeq)
+	_ = body.Flush()
 }
 
 func (s *Server) handlePush(w http.ResponseWriter, r *http.Request) {
@@ -76,7 +421,11 @@ func (s *Server) handlePush(w http.ResponseWriter, r *http.Request) {
 		DatasetGenerationKey string       `json:"datasetGenerationKey"`
 		Ops                  []storage.Op `json:"ops"`
 	}
-	if err := decodeJSON(r, &payload); err != nil {
+	if mediaType(r.Header.Get("Content-Type")) == contentTypeCBOR && !s.featureEnabled(r, features.BinaryTransport) {
+		writeJSON(w, http.StatusUnsupportedMediaType, errorResponse{Error: "binary transport is not enabled"})
+		return
+	}
+	if err := decodeBody(r, &payload); err != nil {
 		log.Printf("sync push decode error: %v", err)
 		writeError(w, http.StatusBadRequest, err)
 		return
@@ -85,29 +434,107 @@ func (s *Server) handlePush(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "clientId is required"})
 		return
 	}
+	// A client check that failed because storage is degraded is answered
+	// below, once the push is known to be valid and can be spooled.
+	clientErr := s.store.CheckClient(r.Context(), userID, payload.ClientID)
+	if !spool.Spoolable(clientErr) && !clientAllowed(w, clientErr) {
+		return
+	}
 	if payload.DatasetGenerationKey == "" {
 		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "datasetGenerationKey is required"})
 		return
 	}
-	datasetGenerationKey, ok := s.ensureDatasetMatch(r.Context(), userID, payload.DatasetGenerationKey, w)
+	for i, op := range payload.Ops {
+		if err := materialize.ValidateOp(op); err != nil {
+			log.Printf("sync push invalid op client=%s: %v", payload.ClientID, err)
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		checked, err := s.notes.CheckOp(op)
+		if err != nil {
+			log.Printf("sync push rejected note client=%s actor=%s clock=%d: %v", payload.ClientID, op.Actor, op.Clock, err)
+			writeError(w, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		payload.Ops[i] = checked
+	}
+	received := spool.Entry{
+		UserID:               userID,
+		ClientID:             payload.ClientID,
+		DatasetGenerationKey: payload.DatasetGenerationKey,
+		Ops:                  slices.Clone(payload.Ops),
+	}
+	if clientErr != nil {
+		s.failPush(w, r, received, clientErr)
+		return
+	}
+	datasetGenerationKey, ok, err := s.datasetMatch(r, userID, payload.ClientID, payload.DatasetGenerationKey, w)
+	if err != nil {
+		s.failPush(w, r, received, err)
+		return
+	}
 	if !ok {
 		return
 	}
+	if err := s.store.BindActors(r.Context(), userID, payload.ClientID, opActors(payload.Ops)); err != nil {
+		if errors.Is(err, storage.ErrActorNotOwned) {
+			log.Printf("sync push rejected actor client=%s: %v", payload.ClientID, err)
+			writeError(w, http.StatusForbidden, err)
+			return
+		}
+		log.Printf("sync push actor error client=%s: %v", payload.ClientID, err)
+		s.failPush(w, r, received, err)
+		return
+	}
+	ops, diagnostics, err := s.checkMoves(r.Context(), userID, payload.Ops)
+	if err != nil {
+		log.Printf("sync push move check error client=%s: %v", payload.ClientID, err)
+		s.failPush(w, r, received, err)
+		return
+	}
+	if dropped := len(payload.Ops) - len(ops); dropped > 0 {
+		log.Printf("sync push repaired dangling moves client=%s dropped_ops=%d", payload.ClientID, dropped)
+	}
+	payload.Ops = ops
+	for i := range payload.Ops {
+		payload.Ops[i].ClientID = payload.ClientID
+	}
 	serverSeq, err := s.store.InsertOps(r.Context(), userID, payload.Ops)
 	if err != nil {
 		log.Printf("sync push insert error client=%s ops=%d: %v", payload.ClientID, len(payload.Ops), err)
-		writeError(w, http.StatusInternalServerError, err)
+		s.failPush(w, r, received, err)
 		return
 	}
 	if err := s.store.UpdateClientCursor(r.Context(), userID, payload.ClientID, serverSeq); err != nil {
+		// The ops are stored, so an error would only make the client push
+		// them again. A cursor left behind merely holds back compaction
+		// until the next pull moves it.
 		log.Printf("sync push cursor error client=%s seq=%d: %v", payload.ClientID, serverSeq, err)
-		writeError(w, http.StatusInternalServerError, err)
-		return
 	}
-	writeJSON(w, http.StatusOK, jsonResponse{
+	if len(payload.Ops) > 0 {
+		s.compaction.Trigger(userID)
+	}
+	response := jsonResponse{
 		"serverSeq":            serverSeq,
 		"datasetGenerationKey": datasetGenerationKey,
-	})
+	}
+	if len(diagnostics) > 0 {
+		response["diagnostics"] = diagnostics
+	}
+	s.fleet.Push()
+	s.stats.Ops(len(payload.Ops))
+	s.writeNegotiated(w, r, http.StatusOK, response)
+}
+
+// opActors returns the distinct actor ids of ops in first-seen order.
+func opActors(ops []storage.Op) []string {
+	actors := make([]string, 0, 1)
+	for _, op := range ops {
+		if !slices.Contains(actors, op.Actor) {
+			actors = append(actors, op.Actor)
+		}
+	}
+	return actors
 }
 
 func (s *Server) handlePull(w http.ResponseWriter, r *http.Request) {
@@ -124,12 +551,15 @@ func (s *Server) handlePull(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "clientId is required"})
 		return
 	}
+	if !s.ensureClientAllowed(w, r, userID, clientID) {
+		return
+	}
 	datasetGenerationKey := r.URL.Query().Get("datasetGenerationKey")
 	if datasetGenerationKey == "" {
 		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "datasetGenerationKey is required"})
 		return
 	}
-	currentDatasetGenerationKey, ok := s.ensureDatasetMatch(r.Context(), userID, datasetGenerationKey, w)
+	currentDatasetGenerationKey, ok := s.ensureDatasetMatch(r, userID, clientID, datasetGenerationKey, w)
 	if !ok {
 		return
 	}
@@ -143,22 +573,84 @@ func (s *Server) handlePull(w http.ResponseWriter, r *http.Request) {
 		}
 		since = parsed
 	}
+	var summaryMode bool
+	switch r.URL.Query().Get("mode") {
+	case "", "ops":
+	case "summary":
+		summaryMode = true
+	default:
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "mode must be ops or summary"})
+		return
+	}
+	var compress bool
+	switch r.URL.Query().Get("payloadEncoding") {
+	case "":
+	case storage.PayloadEncodingZstd:
+		compress = s.featureEnabled(r, features.CompressedOps)
+	default:
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "payloadEncoding must be zstd"})
+		return
+	}
 	ops, serverSeq, err := s.store.GetOpsSince(r.Context(), userID, since)
 	if err != nil {
 		log.Printf("sync pull error client=%s since=%d: %v", clientID, since, err)
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	if r.URL.Query().Get("omitOwn") == "true" {
+		// The client applied its own ops when it made them; serverSeq still
+		// advances past them.
+		ops = slices.DeleteFunc(ops, func(op storage.Op) bool { return op.ClientID == clientID })
+	}
+	archived, err := s.loadSyncArchivedView(r, userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	ops = archived.filterOps(ops)
 	if err := s.store.UpdateClientCursor(r.Context(), userID, clientID, serverSeq); err != nil {
 		log.Printf("sync pull cursor error client=%s seq=%d: %v", clientID, serverSeq, err)
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, jsonResponse{
+	payload := jsonResponse{
 		"serverSeq":            serverSeq,
 		"datasetGenerationKey": currentDatasetGenerationKey,
 		"ops":                  ops,
-	})
+	}
+	archived.annotate(payload)
+	if summaryMode {
+		// Lightweight consumers get counts per resource instead of raw ops.
+		delete(payload, "ops")
+		payload["summary"] = materialize.Summarize(ops)
+	}
+	if len(ops) > 0 && !summaryMode {
+		attribution, err := s.store.GetActorAttribution(r.Context(), userID, opActors(ops))
+		if err != nil {
+			log.Printf("sync pull attribution error client=%s: %v", clientID, err)
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if len(attribution) > 0 {
+			payload["actors"] = attribution
+		}
+	}
+	// Taken last so a failed pull does not swallow the hint.
+	hint, err := s.store.TakeClientHint(r.Context(), userID, clientID)
+	if err != nil {
+		log.Printf("sync pull hint error client=%s: %v", clientID, err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if hint != "" {
+		log.Printf("sync pull delivered hint=%s client=%s", hint, clientID)
+		payload["clientHint"] = hint
+	}
+	if compress && !summaryMode {
+		payload["ops"] = storage.CompressOps(ops, s.compressMinBytes)
+	}
+	s.fleet.Pull()
+	s.writeNegotiated(w, r, http.StatusOK, payload)
 }
 
 func (s *Server) handleReset(w http.ResponseWriter, r *http.Request) {
@@ -174,6 +666,10 @@ func (s *Server) handleReset(w http.ResponseWriter, r *http.Request) {
 		ClientID             string `json:"clientId"`
 		DatasetGenerationKey string `json:"datasetGenerationKey"`
 		Snapshot             string `json:"snapshot"`
+		// ExpectedPreviousDatasetGenerationKey, when set, must still be the
+		// active generation, so two devices importing at once cannot clobber
+		// each other.
+		ExpectedPreviousDatasetGenerationKey string `json:"expectedPreviousDatasetGenerationKey"`
 	}
 	if err := decodeJSON(r, &payload); err != nil {
 		log.Printf("sync reset decode error: %v", err)
@@ -184,65 +680,276 @@ func (s *Server) handleReset(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "clientId is required"})
 		return
 	}
+	if !s.ensureClientAllowed(w, r, userID, payload.ClientID) {
+		return
+	}
 	if payload.DatasetGenerationKey == "" {
 		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "datasetGenerationKey is required"})
 		return
 	}
-	if err := s.store.ReplaceSnapshot(r.Context(), userID, storage.Snapshot{
+	snapshot, err := s.notes.CheckSnapshot(payload.Snapshot)
+	if err != nil {
+		log.Printf("sync reset rejected note client=%s: %v", payload.ClientID, err)
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	precondition := storage.SnapshotPrecondition{DatasetGenerationKey: payload.ExpectedPreviousDatasetGenerationKey}
+	// The generation that satisfied If-Match must still be active when the
+	// snapshot is replaced, so the write carries it as its precondition.
+	var matchedDatasetGenerationKey string
+	if r.Header.Get("If-Match") != "" {
+		activeDatasetGenerationKey, err := s.store.GetActiveDatasetGenerationKey(r.Context(), userID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !ifMatchSatisfied(r, activeDatasetGenerationKey) {
+			s.fleet.ResetConflict()
+			writePreconditionFailed(w, activeDatasetGenerationKey)
+			return
+		}
+		if !ifMatchAny(r) {
+			matchedDatasetGenerationKey = activeDatasetGenerationKey
+		}
+	}
+	if matchedDatasetGenerationKey != "" {
+		if precondition.DatasetGenerationKey != "" && precondition.DatasetGenerationKey != matchedDatasetGenerationKey {
+			s.fleet.ResetConflict()
+			s.writeResetGenerationChanged(r.Context(), userID, payload.ExpectedPreviousDatasetGenerationKey, w)
+			return
+		}
+		precondition.DatasetGenerationKey = matchedDatasetGenerationKey
+	}
+	if err := s.store.ReplaceSnapshotIf(r.Context(), userID, storage.Snapshot{
 		DatasetGenerationKey: payload.DatasetGenerationKey,
-		Blob:                 payload.Snapshot,
-	}); err != nil {
+		Blob:                 snapshot,
+	}, precondition); err != nil {
 		if errors.Is(err, storage.ErrDatasetGenerationKeyExists) {
 			writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error()})
 			return
 		}
+		if errors.Is(err, storage.ErrDatasetGenerationChanged) {
+			s.fleet.ResetConflict()
+			if matchedDatasetGenerationKey != "" {
+				activeDatasetGenerationKey, err := s.store.GetActiveDatasetGenerationKey(r.Context(), userID)
+				if err != nil {
+					writeError(w, http.StatusInternalServerError, err)
+					return
+				}
+				writePreconditionFailed(w, activeDatasetGenerationKey)
+				return
+			}
+			s.writeResetGenerationChanged(r.Context(), userID, payload.ExpectedPreviousDatasetGenerationKey, w)
+			return
+		}
 		log.Printf("sync reset error client=%s: %v", payload.ClientID, err)
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	s.fleet.Reset()
+	setETag(w, payload.DatasetGenerationKey)
 	writeJSON(w, http.StatusOK, jsonResponse{
 		"serverSeq":            int64(0),
 		"datasetGenerationKey": payload.DatasetGenerationKey,
 	})
 }
 
-func handleHealthz(w http.ResponseWriter, r *http.Request) {
+// writeResetGenerationChanged rejects a reset whose expected previous
+// generation is no longer active. Like a dataset mismatch on push/pull it
+// answers 409 with the current generation so the client can re-bootstrap.
+func (s *Server) writeResetGenerationChanged(ctx context.Context, userID string, expectedDatasetGenerationKey string, w http.ResponseWriter) {
+	snapshot, err := s.store.GetSnapshot(ctx, userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	lineage, err := s.generationLineage(ctx, userID, expectedDatasetGenerationKey)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusConflict, jsonResponse{
+		"error":                storage.ErrDatasetGenerationChanged.Error(),
+		"datasetGenerationKey": snapshot.DatasetGenerationKey,
+		"snapshot":             snapshot.Blob,
+		"lineage":              lineage,
+	})
+}
+
+// HandleHealthz reports liveness and the authentication mode, so a
+// deployment that serves everyone one shared dataset is easy to spot. It is
+// exported so separate (admin) listeners can answer health checks too.
+func (s *Server) HandleHealthz(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		methodNotAllowed(w)
 		return
 	}
 	writeJSON(w, http.StatusOK, jsonResponse{
-		"status": "ok",
-		"time":   time.Now().UTC().Format(time.RFC3339),
+		"status":    "ok",
+		"time":      time.Now().UTC().Format(time.RFC3339),
+		"authMode":  s.authMode,
+		"anonymous": s.authMode == "none",
 	})
 }
 
-func (s *Server) ensureDatasetMatch(ctx context.Context, userID string, clientDatasetGenerationKey string, w http.ResponseWriter) (string, bool) {
-	datasetGenerationKey, err := s.store.GetActiveDatasetGenerationKey(ctx, userID)
+func (s *Server) ensureDatasetMatch(r *http.Request, userID string, clientID string, clientDatasetGenerationKey string, w http.ResponseWriter) (string, bool) {
+	datasetGenerationKey, ok, err := s.datasetMatch(r, userID, clientID, clientDatasetGenerationKey, w)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return "", false
 	}
+	return datasetGenerationKey, ok
+}
+
+// datasetMatch is ensureDatasetMatch for callers that handle storage errors
+// themselves: those are returned instead of written.
+func (s *Server) datasetMatch(r *http.Request, userID string, clientID string, clientDatasetGenerationKey string, w http.ResponseWriter) (string, bool, error) {
+	ctx := r.Context()
+	datasetGenerationKey, err := s.store.GetActiveDatasetGenerationKey(ctx, userID)
+	if err != nil {
+		return "", false, err
+	}
 	if clientDatasetGenerationKey == datasetGenerationKey {
-		return datasetGenerationKey, true
+		s.fleet.Matched(userID, clientID)
+		return datasetGenerationKey, true, nil
 	}
 	snapshot, err := s.store.GetSnapshot(ctx, userID)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err)
-		return "", false
+		return "", false, err
 	}
-	writeJSON(w, http.StatusConflict, jsonResponse{
+	lineage, err := s.generationLineage(ctx, userID, clientDatasetGenerationKey)
+	if err != nil {
+		return "", false, err
+	}
+	conflicts, stuck := s.fleet.Conflict(userID, clientID, lineage == lineageDivergent)
+	details := newConflictDetails(snapshot, lineage, conflicts, stuck, time.Now())
+	if stuck {
+		return datasetGenerationKey, false, s.forceResync(w, r, userID, clientID, lineage, details)
+	}
+	archived, err := s.loadSyncArchivedView(r, userID)
+	if err != nil {
+		return "", false, err
+	}
+	blob, err := archived.filterSnapshot(snapshot.Blob)
+	if err != nil {
+		return "", false, err
+	}
+	payload := jsonResponse{
 		"datasetGenerationKey": snapshot.DatasetGenerationKey,
-		"snapshot":             snapshot.Blob,
-	})
-	return datasetGenerationKey, false
+		"snapshot":             blob,
+		"lineage":              lineage,
+		"conflict":             details,
+	}
+	archived.annotate(payload)
+	s.writeNegotiated(w, r, http.StatusConflict, payload)
+	return datasetGenerationKey, false, nil
+}
+
+// conflictCodeForcedResync marks the 409 of a forced resync, which carries
+// the whole bootstrap. 412 is left to failed If-Match preconditions.
+const conflictCodeForcedResync = "forced-resync"
+
+// forceResync answers a client stuck in a conflict loop with a 409 that
+// carries the whole bootstrap, so it can replace its local state without a
+// further request, and alerts the operator.
+func (s *Server) forceResync(w http.ResponseWriter, r *http.Request, userID string, clientID string, lineage string, details conflictDetails) error {
+	payload, err := s.bootstrapPayload(r, userID)
+	if err != nil {
+		return err
+	}
+	payload["code"] = conflictCodeForcedResync
+	payload["lineage"] = lineage
+	payload["conflict"] = details
+	s.fleet.ForcedResync()
+	log.Printf("ALERT: forcing resync of client stuck in conflict loop user=%s client=%s conflicts=%d lineage=%s; see stuckClients under /admin/fleet",
+		userID, clientID, details.Conflicts, lineage)
+	s.writeNegotiated(w, r, http.StatusConflict, payload)
+	return nil
+}
+
+// Recovery actions recommended with generation conflicts.
+const (
+	// conflictActionRebase asks the client to adopt the key and snapshot of
+	// the conflict and push its unsent ops again.
+	conflictActionRebase = "rebase"
+	// conflictActionResync asks the client to discard its sync state and
+	// restore from GET /sync/bootstrap.
+	conflictActionResync = "resync"
+)
+
+// conflictDetails tells a client why its generation key was stale and how to
+// recover, escalating to a full resync once it keeps conflicting.
+type conflictDetails struct {
+	// GenerationAgeSeconds is how long the active generation has been active.
+	GenerationAgeSeconds int64 `json:"generationAgeSeconds"`
+	// Compaction reports whether a compaction replaced the client's
+	// generation, as opposed to a reset or import.
+	Compaction bool   `json:"compaction"`
+	Action     string `json:"action"`
+	// Conflicts counts the client's conflicts in a row, including this one.
+	Conflicts int `json:"conflicts"`
+	// Stuck flags a client caught in a conflict loop, which is forced to
+	// resync.
+	Stuck bool `json:"stuck"`
+}
+
+func newConflictDetails(snapshot storage.Snapshot, lineage string, conflicts int, stuck bool, now time.Time) conflictDetails {
+	details := conflictDetails{
+		GenerationAgeSeconds: max(0, int64(now.Sub(time.Unix(snapshot.CreatedAt, 0))/time.Second)),
+		Compaction:           lineage == lineageAncestor,
+		Action:               conflictActionResync,
+		Conflicts:            conflicts,
+		Stuck:                stuck,
+	}
+	// A client that keeps conflicting after rebasing evidently cannot, so it
+	// is told to start over instead.
+	if details.Compaction && !details.Stuck {
+		details.Action = conflictActionRebase
+	}
+	return details
+}
+
+// Lineage values reported with generation conflicts.
+const (
+	// lineageAncestor means the client's key is an ancestor of the active
+	// generation: it only missed a compaction and its data is still contained
+	// in the active snapshot.
+	lineageAncestor = "ancestor"
+	// lineageDivergent means the active generation came from an import the
+	// client has never seen, so it must fully resync.
+	lineageDivergent = "divergent"
+)
+
+// generationLineage classifies a stale client key relative to the active
+// generation.
+func (s *Server) generationLineage(ctx context.Context, userID string, clientDatasetGenerationKey string) (string, error) {
+	lineage, err := s.store.GetGenerationLineage(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if len(lineage) > 1 && slices.Contains(lineage[1:], clientDatasetGenerationKey) {
+		return lineageAncestor, nil
+	}
+	return lineageDivergent, nil
 }
 
 func methodNotAllowed(w http.ResponseWriter) {
 	writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
 }
 
+// writeError writes err as JSON. Server errors caused by a busy or
+// unavailable database become 503 with Retry-After, so clients retry them
+// instead of treating them as failures.
 func writeError(w http.ResponseWriter, status int, err error) {
+	if status >= http.StatusInternalServerError && storage.IsTransient(err) {
+		w.Header().Set("Retry-After", "1")
+		status = http.StatusServiceUnavailable
+	}
+	// Notes the server writes for a request come from the request, so an
+	// oversized one is the caller's error wherever it surfaces.
+	if status >= http.StatusInternalServerError && errors.Is(err, notes.ErrTooLarge) {
+		status = http.StatusRequestEntityTooLarge
+	}
 	writeJSON(w, status, errorResponse{Error: err.Error()})
 }
 
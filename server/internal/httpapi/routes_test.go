@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
-	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 
 	"a4-tasklists/server/internal/auth"
+	"a4-tasklists/server/internal/fleet"
+	"a4-tasklists/server/internal/notes"
 	"a4-tasklists/server/internal/storage"
 )
 
@@ -21,32 +24,24 @@ type bootstrapResponse struct {
 }
 
 type pushCursorStore struct {
+	*storage.MemoryStore
 	lastCursorClientID string
 	lastCursorUserID   string
 	lastCursorSeq      int64
+	cursorErr          error
 }
 
-func (s *pushCursorStore) Init(context.Context) error { return nil }
-func (s *pushCursorStore) Close() error               { return nil }
 func (s *pushCursorStore) InsertOps(context.Context, string, []storage.Op) (int64, error) {
 	return 42, nil
 }
-func (s *pushCursorStore) GetOpsSince(context.Context, string, int64) ([]storage.Op, int64, error) {
-	return nil, 0, nil
-}
 func (s *pushCursorStore) GetActiveDatasetGenerationKey(context.Context, string) (string, error) {
 	return "dataset-1", nil
 }
-func (s *pushCursorStore) GetSnapshot(context.Context, string) (storage.Snapshot, error) {
-	return storage.Snapshot{DatasetGenerationKey: "dataset-1", Blob: "{}"}, nil
-}
-func (s *pushCursorStore) ReplaceSnapshot(context.Context, string, storage.Snapshot) error { return nil }
-func (s *pushCursorStore) TouchClient(context.Context, string, string) error               { return nil }
 func (s *pushCursorStore) UpdateClientCursor(_ context.Context, userID string, clientID string, serverSeq int64) error {
 	s.lastCursorUserID = userID
 	s.lastCursorClientID = clientID
 	s.lastCursorSeq = serverSeq
-	return nil
+	return s.cursorErr
 }
 
 func newTestMux(t *testing.T) *http.ServeMux {
@@ -60,17 +55,7 @@ func newTestMux(t *testing.T) *http.ServeMux {
 
 func newTestStore(t *testing.T) storage.Store {
 	t.Helper()
-	dir := t.TempDir()
-	path := filepath.Join(dir, "test.db")
-	store, err := storage.OpenSQLite(path)
-	if err != nil {
-		t.Fatalf("open sqlite: %v", err)
-	}
-	if err := store.Init(t.Context()); err != nil {
-		t.Fatalf("init sqlite: %v", err)
-	}
-	t.Cleanup(func() { _ = store.Close() })
-	return store
+	return storage.NewMemoryStore()
 }
 
 func TestBootstrapEmpty(t *testing.T) {
@@ -100,6 +85,48 @@ func TestBootstrapEmpty(t *testing.T) {
 	}
 }
 
+// failingOpsStore fails ForEachOpSince after delivering the given number of
+// ops.
+type failingOpsStore struct {
+	*storage.MemoryStore
+	after int
+}
+
+func (s *failingOpsStore) ForEachOpSince(ctx context.Context, userID string, since int64, fn func(storage.Op) error) (int64, error) {
+	ops, _, err := s.GetOpsSince(ctx, userID, since)
+	if err != nil {
+		return 0, err
+	}
+	for _, op := range ops[:min(s.after, len(ops))] {
+		if err := fn(op); err != nil {
+			return 0, err
+		}
+	}
+	return 0, errors.New("disk gone")
+}
+
+func TestBootstrapStreamFailures(t *testing.T) {
+	store := &failingOpsStore{MemoryStore: storage.NewMemoryStore()}
+	if _, err := store.InsertOps(context.Background(), "user-1", []storage.Op{
+		{Scope: "list", Resource: "list-1", Actor: "actor-1", Clock: 1, Payload: json.RawMessage(`{}`)},
+	}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	mux := http.NewServeMux()
+	NewServer(store).RegisterRoutes(mux)
+	if resp := doRequest(t, mux, http.MethodGet, "/sync/bootstrap", nil); resp.Code != http.StatusInternalServerError {
+		t.Fatalf("a failure before the first op should answer 500, got %d", resp.Code)
+	}
+
+	store.after = 1
+	defer func() {
+		if recovered := recover(); recovered != http.ErrAbortHandler {
+			t.Fatalf("a failure after the first op should abort the response, got %v", recovered)
+		}
+	}()
+	doRequest(t, mux, http.MethodGet, "/sync/bootstrap", nil)
+}
+
 func TestPushPullRoundTrip(t *testing.T) {
 	mux := newTestMux(t)
 
@@ -213,6 +240,36 @@ func TestPushMissingClientID(t *testing.T) {
 	}
 }
 
+func TestPushRejectsOpsOutsideTheRegisteredScopes(t *testing.T) {
+	mux := newTestMux(t)
+	bootstrap := fetchBootstrap(t, mux)
+
+	for _, tc := range []struct{ scope, resourceID, want string }{
+		{"attachment", "list-1", "want one of comment, list, registry"},
+		{"registry", "list-1", "registry ops need resourceId"},
+	} {
+		body, _ := json.Marshal(map[string]any{
+			"clientId":             "client-1",
+			"datasetGenerationKey": bootstrap.DatasetGenerationKey,
+			"ops": []map[string]any{
+				{"scope": "list", "resourceId": "list-1", "actor": "actor-1", "clock": 1, "payload": map[string]any{"type": "insert", "itemId": "item-1"}},
+				{"scope": tc.scope, "resourceId": tc.resourceID, "actor": "actor-1", "clock": 2, "payload": map[string]any{"type": "createList", "listId": "list-1"}},
+			},
+		})
+		resp := doRequest(t, mux, http.MethodPost, "/sync/push", body)
+		if resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), tc.want) {
+			t.Fatalf("%s: expected 400 with %q, got %d %s", tc.scope, tc.want, resp.Code, resp.Body.String())
+		}
+	}
+	resp := doRequest(t, mux, http.MethodGet, "/sync/pull?since=0&clientId=client-2&datasetGenerationKey="+bootstrap.DatasetGenerationKey, nil)
+	var pulled struct {
+		Ops []storage.Op `json:"ops"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pulled); err != nil || len(pulled.Ops) != 0 {
+		t.Fatalf("expected a rejected push to store nothing, got %+v (%v)", pulled.Ops, err)
+	}
+}
+
 func TestResetSnapshot(t *testing.T) {
 	mux := newTestMux(t)
 
@@ -289,12 +346,20 @@ func fetchBootstrap(t *testing.T, mux *http.ServeMux) bootstrapResponse {
 }
 
 func doRequest(t *testing.T, mux *http.ServeMux, method, path string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	return doRequestWithHeaders(t, mux, method, path, body, nil)
+}
+
+func doRequestWithHeaders(t *testing.T, mux *http.ServeMux, method, path string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
 	t.Helper()
 	req := httptest.NewRequest(method, path, bytes.NewReader(body))
 	req = req.WithContext(auth.ContextWithUserID(req.Context(), "user-1"))
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
 	recorder := httptest.NewRecorder()
 	mux.ServeHTTP(recorder, req)
 	return recorder
@@ -308,6 +373,23 @@ func TestHealthz(t *testing.T) {
 	}
 }
 
+func TestHealthzReportsAnonymousMode(t *testing.T) {
+	server := NewServerWithConfig(newTestStore(t), Config{AuthMode: "none"})
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	resp := doRequest(t, mux, http.MethodGet, "/healthz", nil)
+	var payload struct {
+		AuthMode  string `json:"authMode"`
+		Anonymous bool   `json:"anonymous"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode healthz: %v", err)
+	}
+	if payload.AuthMode != "none" || !payload.Anonymous {
+		t.Fatalf("expected anonymous mode to be reported, got %+v", payload)
+	}
+}
+
 func TestTwoClientsSync(t *testing.T) {
 	mux := newTestMux(t)
 	bootstrap := fetchBootstrap(t, mux)
@@ -357,8 +439,273 @@ func TestTwoClientsSync(t *testing.T) {
 	}
 }
 
+func TestPullOmitOwnSkipsClientsOwnOps(t *testing.T) {
+	mux := newTestMux(t)
+	bootstrap := fetchBootstrap(t, mux)
+	body, _ := json.Marshal(map[string]any{
+		"clientId":             "client-a",
+		"datasetGenerationKey": bootstrap.DatasetGenerationKey,
+		"ops": []map[string]any{
+			{"scope": "registry", "resourceId": "registry", "actor": "actor-a", "clock": 1, "payload": map[string]any{"type": "createList", "listId": "list-1"}},
+		},
+	})
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/push", body); resp.Code != http.StatusOK {
+		t.Fatalf("push status: got %d", resp.Code)
+	}
+	pull := func(clientID string) (int64, int) {
+		t.Helper()
+		resp := doRequest(t, mux, http.MethodGet, "/sync/pull?since=0&omitOwn=true&clientId="+clientID+"&datasetGenerationKey="+bootstrap.DatasetGenerationKey, nil)
+		var payload struct {
+			ServerSeq int64        `json:"serverSeq"`
+			Ops       []storage.Op `json:"ops"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode pull: %v", err)
+		}
+		return payload.ServerSeq, len(payload.Ops)
+	}
+	ownSeq, ownOps := pull("client-a")
+	if ownSeq == 0 || ownOps != 0 {
+		t.Fatalf("expected own ops omitted with advanced serverSeq, got seq=%d ops=%d", ownSeq, ownOps)
+	}
+	if otherSeq, otherOps := pull("client-b"); otherSeq != ownSeq || otherOps != 1 {
+		t.Fatalf("expected other client to receive the op, got seq=%d ops=%d", otherSeq, otherOps)
+	}
+}
+
+func TestPullOmitOwnAdvancesTheCursorPastOmittedOps(t *testing.T) {
+	store := newTestStore(t)
+	mux := http.NewServeMux()
+	NewServer(store).RegisterRoutes(mux)
+	bootstrap := fetchBootstrap(t, mux)
+	push := func(clientID string, clock int) {
+		t.Helper()
+		body, _ := json.Marshal(map[string]any{
+			"clientId":             clientID,
+			"datasetGenerationKey": bootstrap.DatasetGenerationKey,
+			"ops": []map[string]any{
+				{"scope": "list", "resourceId": "list-1", "actor": "actor-" + clientID, "clock": clock, "payload": map[string]any{"type": "insert", "itemId": "item-" + strconv.Itoa(clock)}},
+			},
+		})
+		if resp := doRequest(t, mux, http.MethodPost, "/sync/push", body); resp.Code != http.StatusOK {
+			t.Fatalf("push status: got %d", resp.Code)
+		}
+	}
+	pull := func(since int64) (int64, []storage.Op) {
+		t.Helper()
+		resp := doRequest(t, mux, http.MethodGet, "/sync/pull?since="+strconv.FormatInt(since, 10)+"&omitOwn=true&clientId=client-a&datasetGenerationKey="+bootstrap.DatasetGenerationKey, nil)
+		var payload struct {
+			ServerSeq int64        `json:"serverSeq"`
+			Ops       []storage.Op `json:"ops"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode pull: %v", err)
+		}
+		return payload.ServerSeq, payload.Ops
+	}
+
+	push("client-a", 1)
+	push("client-a", 2)
+	cursor, ops := pull(0)
+	if cursor != 2 || len(ops) != 0 {
+		t.Fatalf("expected both own ops omitted and the cursor past them, got seq=%d ops=%+v", cursor, ops)
+	}
+	clients, err := store.ListClients(context.Background(), "user-1")
+	if err != nil || len(clients) != 1 || clients[0].LastSeenServerSeq != cursor {
+		t.Fatalf("expected the stored cursor at %d, got %+v (%v)", cursor, clients, err)
+	}
+
+	push("client-b", 3)
+	push("client-a", 4)
+	next, ops := pull(cursor)
+	if next != 4 || len(ops) != 1 || ops[0].ServerSeq != 3 || ops[0].Actor != "actor-client-b" {
+		t.Fatalf("expected only the other client's op after the cursor, got seq=%d ops=%+v", next, ops)
+	}
+	if again, ops := pull(next); again != next || len(ops) != 0 {
+		t.Fatalf("expected nothing new, got seq=%d ops=%+v", again, ops)
+	}
+}
+
+func TestPullCompressesLargePayloadsOnRequest(t *testing.T) {
+	mux := newTestMux(t)
+	bootstrap := fetchBootstrap(t, mux)
+	note := strings.Repeat("remember the long note ", 400)
+	body, _ := json.Marshal(map[string]any{
+		"clientId":             "client-a",
+		"datasetGenerationKey": bootstrap.DatasetGenerationKey,
+		"ops": []map[string]any{
+			{"scope": "list", "resourceId": "list-1", "actor": "actor-a", "clock": 1, "payload": map[string]any{"type": "insert", "itemId": "item-1", "payload": map[string]any{"data": map[string]any{"note": note}}}},
+			{"scope": "list", "resourceId": "list-1", "actor": "actor-a", "clock": 2, "payload": map[string]any{"type": "insert", "itemId": "item-2"}},
+		},
+	})
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/push", body); resp.Code != http.StatusOK {
+		t.Fatalf("push status: got %d", resp.Code)
+	}
+	pull := func(query string) []storage.Op {
+		t.Helper()
+		resp := doRequest(t, mux, http.MethodGet, "/sync/pull?since=0&clientId=client-b&datasetGenerationKey="+bootstrap.DatasetGenerationKey+query, nil)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("pull status: got %d %s", resp.Code, resp.Body.String())
+		}
+		var payload struct {
+			Ops []storage.Op `json:"ops"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode pull: %v", err)
+		}
+		return payload.Ops
+	}
+	plain := pull("")
+	if len(plain) != 2 || plain[0].PayloadEncoding != "" {
+		t.Fatalf("expected plain payloads without the parameter, got %+v", plain)
+	}
+	compressed := pull("&payloadEncoding=zstd")
+	if len(compressed) != 2 || compressed[0].PayloadEncoding != storage.PayloadEncodingZstd || compressed[1].PayloadEncoding != "" {
+		t.Fatalf("expected only the large payload compressed, got %+v", compressed)
+	}
+	if len(compressed[0].Payload) >= len(plain[0].Payload) {
+		t.Fatalf("expected the compressed payload to be smaller: %d >= %d", len(compressed[0].Payload), len(plain[0].Payload))
+	}
+	// Responses are indented, so payloads are compared compacted.
+	decoded, err := storage.DecompressOp(compressed[0])
+	var want, got bytes.Buffer
+	if err != nil || json.Compact(&want, plain[0].Payload) != nil || json.Compact(&got, decoded.Payload) != nil || got.String() != want.String() {
+		t.Fatalf("expected the compressed payload to decode to the plain one (%v)", err)
+	}
+	if resp := doRequest(t, mux, http.MethodGet, "/sync/pull?since=0&payloadEncoding=gzip&clientId=client-b&datasetGenerationKey="+bootstrap.DatasetGenerationKey, nil); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected an unknown encoding to be rejected, got %d", resp.Code)
+	}
+
+	// Compressed ops are only ever delivered, never accepted.
+	body, _ = json.Marshal(map[string]any{
+		"clientId":             "client-b",
+		"datasetGenerationKey": bootstrap.DatasetGenerationKey,
+		"ops":                  []storage.Op{compressed[0]},
+	})
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/push", body); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected a compressed push to be rejected, got %d", resp.Code)
+	}
+}
+
+func TestNotesAreLimitedAndSanitizedOnEveryWritePath(t *testing.T) {
+	server := NewServerWithConfig(newTestStore(t), Config{Notes: notes.Policy{MaxBytes: 256}})
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	bootstrap := fetchBootstrap(t, mux)
+	push := func(clock int, note string) *httptest.ResponseRecorder {
+		t.Helper()
+		body, _ := json.Marshal(map[string]any{
+			"clientId":             "client-a",
+			"datasetGenerationKey": bootstrap.DatasetGenerationKey,
+			"ops": []map[string]any{
+				{"scope": "list", "resourceId": "list-1", "actor": "actor-a", "clock": clock, "payload": map[string]any{"type": "update", "itemId": "item-1", "payload": map[string]any{"data": map[string]any{"note": note}}}},
+			},
+		})
+		return doRequest(t, mux, http.MethodPost, "/sync/push", body)
+	}
+	// pulledNotes returns the notes of the ops after since, in order.
+	pulledNotes := func(since string) []string {
+		t.Helper()
+		resp := doRequest(t, mux, http.MethodGet, "/sync/pull?since="+since+"&clientId=client-b&datasetGenerationKey="+bootstrap.DatasetGenerationKey, nil)
+		var pulled struct {
+			Ops []storage.Op `json:"ops"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&pulled); err != nil {
+			t.Fatalf("decode pull: %v", err)
+		}
+		var found []string
+		for _, op := range pulled.Ops {
+			var payload struct {
+				Payload struct {
+					Data struct {
+						Note *string `json:"note"`
+					} `json:"data"`
+				} `json:"payload"`
+			}
+			if json.Unmarshal(op.Payload, &payload) == nil && payload.Payload.Data.Note != nil {
+				found = append(found, *payload.Payload.Data.Note)
+			}
+		}
+		return found
+	}
+	if resp := push(1, `see <a href="javascript:alert(1)" onclick="x()">this</a><script>steal()</script>`); resp.Code != http.StatusOK {
+		t.Fatalf("push status: got %d %s", resp.Code, resp.Body.String())
+	}
+	if got := pulledNotes("0"); len(got) != 1 || got[0] != "see <a>this</a>" {
+		t.Fatalf("expected the note to be pulled sanitized, got %q", got)
+	}
+	if resp := push(2, strings.Repeat("x", 257)); resp.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected an oversized note to be rejected, got %d", resp.Code)
+	}
+
+	resp := doRequest(t, mux, http.MethodPost, "/lists", []byte(`{"title":"Groceries","items":[{"text":"milk","note":"<iframe src=x></iframe>fresh"}]}`))
+	var created struct {
+		ItemIDs []string `json:"itemIds"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil || resp.Code != http.StatusCreated {
+		t.Fatalf("create list: %d %v", resp.Code, err)
+	}
+	if resp := doRequest(t, mux, http.MethodPatch, "/items/"+created.ItemIDs[0], []byte(`{"note":"`+strings.Repeat("x", 257)+`"}`)); resp.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected an oversized REST note to be rejected, got %d %s", resp.Code, resp.Body.String())
+	}
+	if got := pulledNotes("1"); len(got) != 1 || got[0] != "fresh" {
+		t.Fatalf("expected the REST note to be stored sanitized, got %q", got)
+	}
+
+	snapshot := `{"schema":"net.aggregat4.tasklist.snapshot@v1","data":{"lists":[{"listId":"list-1","items":[{"id":"item-1","note":"` + strings.Repeat("x", 257) + `"}]}]}}`
+	body, _ := json.Marshal(map[string]any{"clientId": "client-a", "datasetGenerationKey": "dataset-next", "snapshot": snapshot})
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/reset", body); resp.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected a snapshot with an oversized note to be rejected, got %d", resp.Code)
+	}
+}
+
+func TestPullSummaryModeReturnsCountsInsteadOfOps(t *testing.T) {
+	mux := newTestMux(t)
+	bootstrap := fetchBootstrap(t, mux)
+	body, _ := json.Marshal(map[string]any{
+		"clientId":             "client-a",
+		"datasetGenerationKey": bootstrap.DatasetGenerationKey,
+		"ops": []map[string]any{
+			{"scope": "list", "resourceId": "list-1", "actor": "actor-a", "clock": 1, "payload": map[string]any{"type": "insert", "itemId": "item-1"}},
+			{"scope": "list", "resourceId": "list-1", "actor": "actor-a", "clock": 2, "payload": map[string]any{"type": "update", "itemId": "item-1", "payload": map[string]any{"data": map[string]any{"done": true}}}},
+		},
+	})
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/push", body); resp.Code != http.StatusOK {
+		t.Fatalf("push status: got %d", resp.Code)
+	}
+	resp := doRequest(t, mux, http.MethodGet, "/sync/pull?since=0&mode=summary&clientId=widget&datasetGenerationKey="+bootstrap.DatasetGenerationKey, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("pull status: got %d", resp.Code)
+	}
+	var payload struct {
+		ServerSeq int64           `json:"serverSeq"`
+		Ops       json.RawMessage `json:"ops"`
+		Summary   struct {
+			OpCount   int `json:"opCount"`
+			Resources []struct {
+				ResourceID string `json:"resourceId"`
+				Added      int    `json:"added"`
+				Completed  int    `json:"completed"`
+			} `json:"resources"`
+			Lists []string `json:"lists"`
+		} `json:"summary"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode pull: %v", err)
+	}
+	if payload.Ops != nil || payload.ServerSeq == 0 || payload.Summary.OpCount != 2 {
+		t.Fatalf("unexpected summary pull: %+v", payload)
+	}
+	if len(payload.Summary.Resources) != 1 || payload.Summary.Resources[0].Added != 1 || payload.Summary.Resources[0].Completed != 1 || len(payload.Summary.Lists) != 1 {
+		t.Fatalf("unexpected summary: %+v", payload.Summary)
+	}
+	if resp := doRequest(t, mux, http.MethodGet, "/sync/pull?mode=diff&clientId=widget&datasetGenerationKey="+bootstrap.DatasetGenerationKey, nil); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown mode, got %d", resp.Code)
+	}
+}
+
 func TestPushUpdatesClientCursor(t *testing.T) {
-	store := &pushCursorStore{}
+	store := &pushCursorStore{MemoryStore: storage.NewMemoryStore()}
 	server := NewServer(store)
 	mux := http.NewServeMux()
 	server.RegisterRoutes(mux)
@@ -391,3 +738,268 @@ func TestPushUpdatesClientCursor(t *testing.T) {
 		t.Fatalf("cursor seq mismatch: got %d", store.lastCursorSeq)
 	}
 }
+
+func TestPushSucceedsWhenOnlyTheCursorUpdateFails(t *testing.T) {
+	store := &pushCursorStore{MemoryStore: storage.NewMemoryStore(), cursorErr: errors.New("disk I/O error")}
+	server := NewServer(store)
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+
+	requestBody, _ := json.Marshal(map[string]any{
+		"clientId":             "client-1",
+		"datasetGenerationKey": "dataset-1",
+		"ops": []map[string]any{
+			{"scope": "list", "resourceId": "list-1", "actor": "actor-1", "clock": 1, "payload": map[string]any{"type": "insert", "itemId": "item-1"}},
+		},
+	})
+	resp := doRequest(t, mux, http.MethodPost, "/sync/push", requestBody)
+	var payload struct {
+		ServerSeq int64 `json:"serverSeq"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil || resp.Code != http.StatusOK || payload.ServerSeq != 42 {
+		t.Fatalf("expected the stored ops to be acknowledged, got %d %+v (%v)", resp.Code, payload, err)
+	}
+}
+
+func TestResetRejectsStaleExpectedPreviousGeneration(t *testing.T) {
+	mux := newTestMux(t)
+	bootstrap := fetchBootstrap(t, mux)
+	snapshot := `{"schema":"net.aggregat4.tasklist.snapshot@v1","data":{"lists":[]}}`
+
+	first, _ := json.Marshal(map[string]any{
+		"clientId":                             "client-1",
+		"datasetGenerationKey":                 "dataset-a",
+		"snapshot":                             snapshot,
+		"expectedPreviousDatasetGenerationKey": bootstrap.DatasetGenerationKey,
+	})
+	resp := doRequest(t, mux, http.MethodPost, "/sync/reset", first)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("first reset status: got %d", resp.Code)
+	}
+
+	second, _ := json.Marshal(map[string]any{
+		"clientId":                             "client-2",
+		"datasetGenerationKey":                 "dataset-b",
+		"snapshot":                             snapshot,
+		"expectedPreviousDatasetGenerationKey": bootstrap.DatasetGenerationKey,
+	})
+	resp = doRequest(t, mux, http.MethodPost, "/sync/reset", second)
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("stale reset status: got %d", resp.Code)
+	}
+	var payload bootstrapResponse
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if payload.DatasetGenerationKey != "dataset-a" || payload.Snapshot != snapshot {
+		t.Fatalf("unexpected conflict payload: %+v", payload)
+	}
+	if current := fetchBootstrap(t, mux); current.DatasetGenerationKey != "dataset-a" {
+		t.Fatalf("stale reset replaced dataset: %s", current.DatasetGenerationKey)
+	}
+}
+
+func TestPullDatasetMismatchReportsLineage(t *testing.T) {
+	store := storage.NewMemoryStore()
+	server := NewServer(store)
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	root := fetchBootstrap(t, mux).DatasetGenerationKey
+	snapshot := `{"schema":"net.aggregat4.tasklist.snapshot@v1","data":{"lists":[]}}`
+	if err := store.ReplaceSnapshot(context.Background(), "user-1", storage.Snapshot{DatasetGenerationKey: "compacted", Blob: snapshot, ParentDatasetGenerationKey: root}); err != nil {
+		t.Fatalf("replace snapshot: %v", err)
+	}
+
+	for _, tc := range []struct {
+		clientKey string
+		want      string
+	}{
+		{root, "ancestor"},
+		{"unrelated", "divergent"},
+	} {
+		resp := doRequest(t, mux, http.MethodGet, "/sync/pull?since=0&clientId=client-1&datasetGenerationKey="+tc.clientKey, nil)
+		if resp.Code != http.StatusConflict {
+			t.Fatalf("status: got %d", resp.Code)
+		}
+		var payload struct {
+			DatasetGenerationKey string `json:"datasetGenerationKey"`
+			Lineage              string `json:"lineage"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if payload.DatasetGenerationKey != "compacted" || payload.Lineage != tc.want {
+			t.Fatalf("client key %s: got %+v, want lineage %s", tc.clientKey, payload, tc.want)
+		}
+	}
+}
+
+func TestClientHintSurvivesCompaction(t *testing.T) {
+	store := storage.NewMemoryStore()
+	mux := http.NewServeMux()
+	NewServer(store).RegisterRoutes(mux)
+	root := fetchBootstrap(t, mux).DatasetGenerationKey
+	if resp := doRequest(t, mux, http.MethodGet, "/sync/pull?since=0&clientId=client-1&datasetGenerationKey="+root, nil); resp.Code != http.StatusOK {
+		t.Fatalf("pull status: got %d", resp.Code)
+	}
+	ctx := context.Background()
+	if err := store.SetClientHint(ctx, "user-1", "client-1", storage.ClientHintResync); err != nil {
+		t.Fatalf("set hint: %v", err)
+	}
+	snapshot := `{"schema":"net.aggregat4.tasklist.snapshot@v1","data":{"lists":[]}}`
+	if err := store.ReplaceSnapshot(ctx, "user-1", storage.Snapshot{DatasetGenerationKey: "compacted", Blob: snapshot, ParentDatasetGenerationKey: root}); err != nil {
+		t.Fatalf("replace snapshot: %v", err)
+	}
+
+	resp := doRequest(t, mux, http.MethodGet, "/sync/pull?since=0&clientId=client-1&datasetGenerationKey=compacted", nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("pull after compaction status: got %d", resp.Code)
+	}
+	var payload struct {
+		ClientHint string `json:"clientHint"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if payload.ClientHint != storage.ClientHintResync {
+		t.Fatalf("expected the hint set before compaction, got %q", payload.ClientHint)
+	}
+}
+
+func TestDatasetMismatchForcesResyncOfClientsStuckInConflicts(t *testing.T) {
+	store := storage.NewMemoryStore()
+	server := NewServer(store)
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	server.RegisterAdminRoutes(mux)
+	root := fetchBootstrap(t, mux).DatasetGenerationKey
+	snapshot := `{"schema":"net.aggregat4.tasklist.snapshot@v1","data":{"lists":[]}}`
+	if err := store.ReplaceSnapshot(context.Background(), "user-1", storage.Snapshot{DatasetGenerationKey: "compacted", Blob: snapshot, ParentDatasetGenerationKey: root}); err != nil {
+		t.Fatalf("replace snapshot: %v", err)
+	}
+
+	type conflictResponse struct {
+		Code                 string            `json:"code"`
+		DatasetGenerationKey string            `json:"datasetGenerationKey"`
+		Ops                  []json.RawMessage `json:"ops"`
+		ServerSeq            *int64            `json:"serverSeq"`
+		Conflict             conflictDetails   `json:"conflict"`
+	}
+	pull := func(clientKey string) (int, conflictResponse) {
+		t.Helper()
+		resp := doRequest(t, mux, http.MethodGet, "/sync/pull?since=0&clientId=client-1&datasetGenerationKey="+clientKey, nil)
+		var payload conflictResponse
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return resp.Code, payload
+	}
+	for i := 1; i < fleet.DefaultStuckConflicts; i++ {
+		code, payload := pull(root)
+		conflict := payload.Conflict
+		if code != http.StatusConflict || !conflict.Compaction || conflict.Conflicts != i || conflict.Stuck || conflict.Action != "rebase" || conflict.GenerationAgeSeconds < 0 {
+			t.Fatalf("conflict %d: got %d %+v", i, code, conflict)
+		}
+		if payload.ServerSeq != nil || payload.Code != "" {
+			t.Fatalf("conflict %d: a plain conflict should not carry the op log", i)
+		}
+	}
+
+	// The next conflict is one too many: the client gets the whole bootstrap.
+	code, payload := pull(root)
+	if code != http.StatusConflict || payload.Code != "forced-resync" || payload.DatasetGenerationKey != "compacted" || payload.ServerSeq == nil || payload.Ops == nil {
+		t.Fatalf("expected a forced resync, got %d %+v", code, payload)
+	}
+	if conflict := payload.Conflict; !conflict.Stuck || conflict.Action != "resync" || conflict.Conflicts != fleet.DefaultStuckConflicts {
+		t.Fatalf("unexpected forced resync details %+v", conflict)
+	}
+
+	resp := doRequest(t, mux, http.MethodGet, "/admin/fleet", nil)
+	var report fleet.Report
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("decode fleet: %v", err)
+	}
+	if stuck := report.Activity.StuckClients; len(stuck) != 1 || stuck[0].ClientID != "client-1" || report.Activity.ForcedResyncs != 1 {
+		t.Fatalf("expected the client to be flagged, got %+v", report.Activity)
+	}
+
+	// Adopting the active key ends the streak.
+	if code, _ := pull("compacted"); code != http.StatusOK {
+		t.Fatalf("pull with the active key: got %d", code)
+	}
+	if code, payload := pull("unrelated"); code != http.StatusConflict || payload.Conflict.Conflicts != 1 || payload.Conflict.Compaction || payload.Conflict.Action != "resync" {
+		t.Fatalf("divergent conflict: got %d %+v", code, payload.Conflict)
+	}
+
+	// Without a threshold, clients are never forced.
+	lenient := http.NewServeMux()
+	NewServerWithConfig(store, Config{Fleet: fleet.NewCounters(0)}).RegisterRoutes(lenient)
+	for range 2 * fleet.DefaultStuckConflicts {
+		if resp := doRequest(t, lenient, http.MethodGet, "/sync/pull?since=0&clientId=client-1&datasetGenerationKey="+root, nil); resp.Code != http.StatusConflict {
+			t.Fatalf("expected only conflicts without a threshold, got %d", resp.Code)
+		}
+	}
+}
+
+func TestPushRejectsActorOfAnotherUser(t *testing.T) {
+	store := storage.NewMemoryStore()
+	if err := store.BindActors(context.Background(), "user-2", "client-2", []string{"actor-1"}); err != nil {
+		t.Fatalf("bind actor: %v", err)
+	}
+	mux := http.NewServeMux()
+	NewServer(store).RegisterRoutes(mux)
+	bootstrap := fetchBootstrap(t, mux)
+
+	body, _ := json.Marshal(map[string]any{
+		"clientId":             "client-1",
+		"datasetGenerationKey": bootstrap.DatasetGenerationKey,
+		"ops": []map[string]any{
+			{"scope": "list", "resourceId": "list-1", "actor": "actor-1", "clock": 1, "payload": map[string]any{"type": "insert", "itemId": "item-1"}},
+		},
+	})
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/push", body); resp.Code != http.StatusForbidden {
+		t.Fatalf("push status: got %d", resp.Code)
+	}
+	ops, _, err := store.GetOpsSince(context.Background(), "user-1", 0)
+	if err != nil {
+		t.Fatalf("get ops: %v", err)
+	}
+	if len(ops) != 0 {
+		t.Fatalf("spoofed ops were stored: %+v", ops)
+	}
+}
+
+func TestPullAttributesOtherUsersActors(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	if err := store.BindActors(ctx, "user-2", "client-2", []string{"actor-2"}); err != nil {
+		t.Fatalf("bind actor: %v", err)
+	}
+	if err := store.UpdateUserProfile(ctx, "user-2", storage.UserProfile{DisplayName: "Bob"}); err != nil {
+		t.Fatalf("update profile: %v", err)
+	}
+	// Until lists can be shared, ops by another user's actor only reach a
+	// dataset through the store directly.
+	if _, err := store.InsertOps(ctx, "user-1", []storage.Op{
+		{Scope: "list", Resource: "list-1", Actor: "actor-2", Clock: 1, Payload: []byte(`{"type":"insert","itemId":"item-1"}`)},
+	}); err != nil {
+		t.Fatalf("insert ops: %v", err)
+	}
+	mux := http.NewServeMux()
+	NewServer(store).RegisterRoutes(mux)
+	bootstrap := fetchBootstrap(t, mux)
+
+	resp := doRequest(t, mux, http.MethodGet, "/sync/pull?since=0&clientId=client-1&datasetGenerationKey="+bootstrap.DatasetGenerationKey, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("pull status: got %d", resp.Code)
+	}
+	var payload struct {
+		Actors map[string]storage.UserProfile `json:"actors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode pull: %v", err)
+	}
+	if payload.Actors["actor-2"].DisplayName != "Bob" {
+		t.Fatalf("unexpected attribution: %+v", payload.Actors)
+	}
+}
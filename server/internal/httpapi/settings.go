@@ -0,0 +1,195 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+// Where the value of a Setting comes from.
+const (
+	SettingSourceDefault     = "default"
+	SettingSourceEnvironment = "environment"
+	SettingSourceFile        = "file"
+	SettingSourceDatabase    = "database"
+)
+
+// Setting is a setting that can be changed at runtime.
+type Setting struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	// Source is where Value comes from; see the SettingSource constants.
+	Source string `json:"source"`
+	// Default is the value without a stored one, from the config file or
+	// the environment. Empty means the built-in default.
+	Default   string `json:"default"`
+	UpdatedAt int64  `json:"updatedAt,omitempty"`
+	UpdatedBy string `json:"updatedBy,omitempty"`
+}
+
+var (
+	// ErrUnknownSetting is returned for settings that cannot be changed at
+	// runtime.
+	ErrUnknownSetting = errors.New("setting cannot be changed at runtime")
+	// ErrInvalidSetting wraps the reason a value was refused.
+	ErrInvalidSetting = errors.New("invalid setting")
+)
+
+// RuntimeSettings stores settings that override the environment and the
+// config file and applies them to the running server.
+type RuntimeSettings interface {
+	// Settings returns the settings that can be changed, with their current
+	// values.
+	Settings() []Setting
+	// SetSetting stores and applies a value, or returns ErrUnknownSetting or
+	// an error wrapping ErrInvalidSetting.
+	SetSetting(ctx context.Context, key string, value string, by string) (Setting, error)
+	// ResetSetting removes the stored value, or returns
+	// storage.ErrSettingNotFound when there is none.
+	ResetSetting(ctx context.Context, key string, by string) (Setting, error)
+}
+
+// maxSettingChanges caps GET /admin/settings/changes.
+const maxSettingChanges = 500
+
+func (s *Server) handleAdminSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	if s.settings == nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "runtime settings are not available"})
+		return
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{"settings": s.settings.Settings()})
+}
+
+// handleAdminSetting shows a setting on GET, stores a value on PUT {value,
+// by} and removes the stored value on DELETE [?by=]. Changes are recorded as
+// made by "by", or by the client address. Responses carry the setting's ETag,
+// and PUT and DELETE with a stale If-Match are refused with 412.
+func (s *Server) handleAdminSetting(w http.ResponseWriter, r *http.Request) {
+	if s.settings == nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "runtime settings are not available"})
+		return
+	}
+	key := r.PathValue("key")
+	var (
+		setting Setting
+		err     error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		var ok bool
+		if setting, ok = s.findSetting(key); !ok {
+			err = ErrUnknownSetting
+		}
+	case http.MethodPut:
+		var payload struct {
+			Value *string `json:"value"`
+			By    string  `json:"by"`
+		}
+		if err := decodeJSON(r, &payload); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if payload.Value == nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "value is required"})
+			return
+		}
+		s.adminWrites.Lock()
+		defer s.adminWrites.Unlock()
+		if !s.settingMatches(w, r, key) {
+			return
+		}
+		setting, err = s.settings.SetSetting(r.Context(), key, *payload.Value, changedBy(r, payload.By))
+	case http.MethodDelete:
+		s.adminWrites.Lock()
+		defer s.adminWrites.Unlock()
+		if !s.settingMatches(w, r, key) {
+			return
+		}
+		setting, err = s.settings.ResetSetting(r.Context(), key, changedBy(r, r.URL.Query().Get("by")))
+	default:
+		methodNotAllowed(w)
+		return
+	}
+	switch {
+	case errors.Is(err, ErrUnknownSetting):
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: ErrUnknownSetting.Error()})
+	case errors.Is(err, storage.ErrSettingNotFound):
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "setting is not stored"})
+	case errors.Is(err, ErrInvalidSetting):
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		setETag(w, resourceVersion(setting))
+		writeJSON(w, http.StatusOK, setting)
+	}
+}
+
+func (s *Server) findSetting(key string) (Setting, bool) {
+	for _, setting := range s.settings.Settings() {
+		if setting.Key == key {
+			return setting, true
+		}
+	}
+	return Setting{}, false
+}
+
+// settingMatches answers 412 and returns false when the request's If-Match
+// does not name the setting's current version. Unknown settings are left to
+// the change to refuse.
+func (s *Server) settingMatches(w http.ResponseWriter, r *http.Request, key string) bool {
+	setting, ok := s.findSetting(key)
+	if !ok {
+		return true
+	}
+	if version := resourceVersion(setting); !ifMatchSatisfied(r, version) {
+		writePreconditionFailed(w, version)
+		return false
+	}
+	return true
+}
+
+// handleAdminSettingChanges returns the recorded setting changes, newest
+// first, up to ?limit= (default 100).
+func (s *Server) handleAdminSettingChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxSettingChanges {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "limit must be between 1 and 500"})
+			return
+		}
+		limit = parsed
+	}
+	changes, err := s.store.ListSettingChanges(r.Context(), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{"changes": changes})
+}
+
+// changedBy names who changes a setting: by when given, otherwise the client
+// address.
+func changedBy(r *http.Request, by string) string {
+	if by = strings.TrimSpace(by); by != "" {
+		return by
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
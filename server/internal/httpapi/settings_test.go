@@ -0,0 +1,86 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+// fakeSettings keeps one runtime setting in memory.
+type fakeSettings struct {
+	setting Setting
+}
+
+func (f *fakeSettings) Settings() []Setting {
+	return []Setting{f.setting}
+}
+
+func (f *fakeSettings) SetSetting(_ context.Context, key string, value string, by string) (Setting, error) {
+	if key != f.setting.Key {
+		return Setting{}, ErrUnknownSetting
+	}
+	if value == "" {
+		return Setting{}, fmt.Errorf("%w: empty value", ErrInvalidSetting)
+	}
+	f.setting.Value, f.setting.Source, f.setting.UpdatedBy = value, SettingSourceDatabase, by
+	return f.setting, nil
+}
+
+func (f *fakeSettings) ResetSetting(_ context.Context, key string, by string) (Setting, error) {
+	if key != f.setting.Key {
+		return Setting{}, ErrUnknownSetting
+	}
+	if f.setting.Source != SettingSourceDatabase {
+		return Setting{}, storage.ErrSettingNotFound
+	}
+	f.setting = Setting{Key: key, Value: f.setting.Default, Source: SettingSourceDefault, Default: f.setting.Default}
+	return f.setting, nil
+}
+
+func TestAdminSettingChangesHonorIfMatch(t *testing.T) {
+	settings := &fakeSettings{setting: Setting{Key: "SERVER_SNAPSHOT_MAX_OPS", Value: "1000", Source: SettingSourceDefault, Default: "1000"}}
+	mux := http.NewServeMux()
+	NewServerWithConfig(newTestStore(t), Config{Settings: settings}).RegisterAdminRoutes(mux)
+	path := "/admin/settings/SERVER_SNAPSHOT_MAX_OPS"
+
+	resp := doRequest(t, mux, http.MethodGet, path, nil)
+	read := resp.Header().Get("ETag")
+	if resp.Code != http.StatusOK || read == "" {
+		t.Fatalf("expected the setting with an ETag, got %d %q", resp.Code, read)
+	}
+
+	// Another operator changes the setting after the first one read it.
+	resp = doRequestWithHeaders(t, mux, http.MethodPut, path, []byte(`{"value":"5000","by":"bob"}`), map[string]string{"If-Match": read})
+	changed := resp.Header().Get("ETag")
+	if resp.Code != http.StatusOK || changed == "" || changed == read {
+		t.Fatalf("expected the change to apply with a new ETag, got %d %q", resp.Code, changed)
+	}
+	if got := doRequest(t, mux, http.MethodGet, path, nil).Header().Get("ETag"); got != changed {
+		t.Fatalf("expected GET to report the changed ETag %q, got %q", changed, got)
+	}
+
+	resp = doRequestWithHeaders(t, mux, http.MethodPut, path, []byte(`{"value":"200","by":"ann"}`), map[string]string{"If-Match": read})
+	if resp.Code != http.StatusPreconditionFailed || resp.Header().Get("ETag") != changed {
+		t.Fatalf("expected a stale PUT to be refused with the current ETag, got %d %q", resp.Code, resp.Header().Get("ETag"))
+	}
+	resp = doRequestWithHeaders(t, mux, http.MethodDelete, path+"?by=ann", nil, map[string]string{"If-Match": read})
+	if resp.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected a stale DELETE to be refused, got %d", resp.Code)
+	}
+	if settings.setting.Value != "5000" || settings.setting.UpdatedBy != "bob" {
+		t.Fatalf("expected the refused changes to leave the setting alone, got %+v", settings.setting)
+	}
+
+	resp = doRequestWithHeaders(t, mux, http.MethodDelete, path+"?by=ann", nil, map[string]string{"If-Match": changed})
+	var setting Setting
+	if err := json.NewDecoder(resp.Body).Decode(&setting); err != nil || resp.Code != http.StatusOK || setting.Source != SettingSourceDefault {
+		t.Fatalf("expected a current DELETE to apply, got %d %+v (%v)", resp.Code, setting, err)
+	}
+	if resp := doRequest(t, mux, http.MethodPut, path, []byte(`{"value":"300"}`)); resp.Code != http.StatusOK {
+		t.Fatalf("expected a PUT without If-Match to apply, got %d", resp.Code)
+	}
+}
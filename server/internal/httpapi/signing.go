@@ -0,0 +1,154 @@
+package httpapi
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+// Server-to-server callers, such as a mail ingestion bridge, can sign requests
+// with a signed API token instead of sending its secret:
+//
+//	X-Signature-Token:     token-…
+//	X-Signature-Timestamp: 1700000000
+//	X-Signature:           hex(HMAC-SHA256(key, timestamp \n method \n request URI \n hex(SHA-256(body))))
+//
+// The key is derived from the token's secret with its own label (see
+// SigningKey), so it is not the secret hash that bearer lookups use. A
+// signature is accepted once, within the replay window around its timestamp,
+// so a captured request can neither be altered nor replayed. Accepted
+// signatures are only remembered in memory: after a restart, a request signed
+// less than one window earlier can be replayed once more.
+const (
+	signatureTokenHeader     = "X-Signature-Token"
+	signatureTimestampHeader = "X-Signature-Timestamp"
+	signatureHeader          = "X-Signature"
+)
+
+// defaultSignatureWindow is how far a signature's timestamp may be from the
+// server's clock.
+const defaultSignatureWindow = 5 * time.Minute
+
+// maxSignedBodyBytes caps the bodies of signed requests, which are read in
+// full to check the signature.
+const maxSignedBodyBytes = maxImportBytes
+
+// hasSignature reports whether the request is signed.
+func hasSignature(r *http.Request) bool {
+	return r.Header.Get(signatureHeader) != ""
+}
+
+// signingKeyLabel separates the signing key from other values derived from
+// a token's secret.
+const signingKeyLabel = "request-signing"
+
+// SigningKey derives the key a signed token's requests are signed with:
+// hex(HMAC-SHA256(secret, "request-signing")).
+func SigningKey(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = io.WriteString(mac, signingKeyLabel)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest computes the X-Signature of a request with the given key (see
+// SigningKey).
+func SignRequest(key string, timestamp int64, method string, requestURI string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(key))
+	_, _ = io.WriteString(mac, strconv.FormatInt(timestamp, 10)+"\n"+method+"\n"+requestURI+"\n"+hex.EncodeToString(bodyHash[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedToken authenticates a signed request. It answers 401 itself when the
+// token, timestamp or signature does not check out, and leaves the body in
+// place for the handler.
+func (s *Server) signedToken(w http.ResponseWriter, r *http.Request) (storage.APIToken, bool) {
+	reject := func(message string) (storage.APIToken, bool) {
+		w.Header().Set("WWW-Authenticate", `Signature realm="api"`)
+		writeJSON(w, http.StatusUnauthorized, errorResponse{Error: message})
+		return storage.APIToken{}, false
+	}
+	tokenID := r.Header.Get(signatureTokenHeader)
+	timestamp, err := strconv.ParseInt(r.Header.Get(signatureTimestampHeader), 10, 64)
+	if tokenID == "" || err != nil {
+		return reject("signed requests need X-Signature-Token and X-Signature-Timestamp")
+	}
+	now := time.Now()
+	if skew := now.Sub(time.Unix(timestamp, 0)).Abs(); skew > s.signatureWindow {
+		return reject("signature timestamp is outside the replay window")
+	}
+	token, signingKey, err := s.store.SignedAPIToken(r.Context(), tokenID, now.Unix())
+	if errors.Is(err, storage.ErrAPITokenNotFound) {
+		return reject(err.Error())
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return storage.APIToken{}, false
+	}
+	if signingKey == "" {
+		return reject("this token has no signing key; create a new signed token")
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSignedBodyBytes))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return storage.APIToken{}, false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	signature := r.Header.Get(signatureHeader)
+	expected := SignRequest(signingKey, timestamp, r.Method, r.URL.RequestURI(), body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		log.Printf("API token signature rejected token=%s method=%s path=%s", token.ID, r.Method, r.URL.Path)
+		return reject("invalid signature")
+	}
+	if !s.signatures.remember(signature, time.Unix(timestamp, 0).Add(s.signatureWindow)) {
+		log.Printf("API token signature replayed token=%s method=%s path=%s", token.ID, r.Method, r.URL.Path)
+		return reject("signature was already used")
+	}
+	if token, err = s.store.UseSignedAPIToken(r.Context(), token.ID, now.Unix()); err != nil {
+		if errors.Is(err, storage.ErrAPITokenNotFound) {
+			return reject(err.Error())
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return storage.APIToken{}, false
+	}
+	return token, true
+}
+
+// signatureCache remembers accepted signatures until their timestamps leave
+// the replay window. It lives in memory only and starts empty on every
+// restart.
+type signatureCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newSignatureCache() *signatureCache {
+	return &signatureCache{seen: make(map[string]time.Time)}
+}
+
+// remember records signature until expires and reports whether it was new.
+func (c *signatureCache) remember(signature string, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for seen, until := range c.seen {
+		if now.After(until) {
+			delete(c.seen, seen)
+		}
+	}
+	if _, ok := c.seen[signature]; ok {
+		return false
+	}
+	c.seen[signature] = expires
+	return true
+}
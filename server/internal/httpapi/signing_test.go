@@ -0,0 +1,76 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedRequestsAreCheckedAndNotReplayable(t *testing.T) {
+	server := NewServer(newTestStore(t))
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	handler := server.WithAPITokens(mux, http.NotFoundHandler())
+
+	resp := doRequest(t, mux, http.MethodPost, "/auth/tokens", []byte(`{"name":"Mail bridge","scopes":["admin"],"signed":true}`))
+	var created struct {
+		Token struct {
+			ID     string `json:"id"`
+			Signed bool   `json:"signed"`
+		} `json:"token"`
+		Secret string `json:"secret"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil || !created.Token.Signed {
+		t.Fatalf("unexpected token: %+v %v", created, err)
+	}
+	key := SigningKey(created.Secret)
+
+	send := func(timestamp int64, body string, signature string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/lists?source=mail", strings.NewReader(body))
+		req.Header.Set(signatureTokenHeader, created.Token.ID)
+		req.Header.Set(signatureTimestampHeader, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(signatureHeader, signature)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+	now := time.Now().Unix()
+	body := `{"title":"Inbox"}`
+	signature := SignRequest(key, now, http.MethodPost, "/lists?source=mail", []byte(body))
+
+	if resp := send(now, `{"title":"Evil"}`, signature); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected an altered body to be rejected, got %d", resp.Code)
+	}
+	if resp := send(now, body, signature); resp.Code != http.StatusCreated {
+		t.Fatalf("expected the signed request to be served, got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := send(now, body, signature); resp.Code != http.StatusUnauthorized || !strings.Contains(resp.Body.String(), "already used") {
+		t.Fatalf("expected a replay to be rejected, got %d %s", resp.Code, resp.Body.String())
+	}
+	old := now - int64(defaultSignatureWindow/time.Second) - 1
+	if resp := send(old, body, SignRequest(key, old, http.MethodPost, "/lists?source=mail", []byte(body))); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a stale timestamp to be rejected, got %d", resp.Code)
+	}
+	if resp := send(now, body, SignRequest("wrong", now, http.MethodPost, "/lists?source=mail", []byte(body))); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a wrong key to be rejected, got %d", resp.Code)
+	}
+	// The secret hash the server keeps for lookups does not sign requests.
+	hashed := sha256Hex([]byte(created.Secret))
+	if resp := send(now, `{"title":"Hashed"}`, SignRequest(hashed, now, http.MethodPost, "/lists?source=mail", []byte(`{"title":"Hashed"}`))); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the secret hash to be rejected as a key, got %d", resp.Code)
+	}
+
+	// The secret itself is no use as a bearer token.
+	req := httptest.NewRequest(http.MethodGet, "/lists", nil)
+	req.Header.Set("Authorization", "Bearer "+created.Secret)
+	bearer := httptest.NewRecorder()
+	handler.ServeHTTP(bearer, req)
+	if bearer.Code != http.StatusUnauthorized {
+		t.Fatalf("expected bearer use of a signed token to be rejected, got %d", bearer.Code)
+	}
+}
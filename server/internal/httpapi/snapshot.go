@@ -0,0 +1,130 @@
+package httpapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Large snapshots can be fetched in resumable chunks instead of inline in the
+// bootstrap response. A client calls GET /sync/bootstrap?snapshot=chunked to
+// receive the generation metadata, ops, and the snapshot's size and SHA-256,
+// then downloads the snapshot from GET /sync/snapshot with Range requests.
+// Each chunk carries its own checksum, so a client on a flaky connection can
+// verify what it has and resume from the last good offset. Responses are capped
+// at the configured chunk size so a single request cannot stream a huge
+// snapshot in one go.
+
+const defaultSnapshotChunkBytes = 1 << 20
+
+const (
+	snapshotSHA256Header = "X-Snapshot-Sha256"
+	chunkSHA256Header    = "X-Chunk-Sha256"
+)
+
+var errInvalidRange = errors.New("invalid range")
+
+func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	datasetGenerationKey := r.URL.Query().Get("datasetGenerationKey")
+	if datasetGenerationKey == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "datasetGenerationKey is required"})
+		return
+	}
+	snapshot, err := s.store.GetSnapshot(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if snapshot.DatasetGenerationKey != datasetGenerationKey {
+		// The snapshot being downloaded was replaced; partial data is useless, so
+		// the client restarts from a fresh bootstrap.
+		writeJSON(w, http.StatusConflict, jsonResponse{
+			"error":                "dataset generation changed",
+			"datasetGenerationKey": snapshot.DatasetGenerationKey,
+		})
+		return
+	}
+	archived, err := s.loadSyncArchivedView(r, userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	filtered, err := archived.filterSnapshot(snapshot.Blob)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	blob := []byte(filtered)
+	total := int64(len(blob))
+	start, end, err := parseByteRange(r.Header.Get("Range"), total)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", total))
+		writeJSON(w, http.StatusRequestedRangeNotSatisfiable, errorResponse{Error: err.Error()})
+		return
+	}
+	if limit := start + int64(s.snapshotChunkBytes) - 1; end > limit {
+		end = limit
+	}
+	chunk := blob[start : end+1]
+
+	setETag(w, snapshot.DatasetGenerationKey)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(chunk)))
+	w.Header().Set(snapshotSHA256Header, sha256Hex(blob))
+	w.Header().Set(chunkSHA256Header, sha256Hex(chunk))
+	status := http.StatusOK
+	if start > 0 || end < total-1 {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, total))
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(chunk)
+}
+
+// parseByteRange parses a single "bytes=start-" or "bytes=start-end" range
+// against a resource of size total. An empty header selects the whole
+// resource.
+func parseByteRange(header string, total int64) (int64, int64, error) {
+	if header == "" {
+		return 0, max(total-1, 0), nil
+	}
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, errInvalidRange
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, errInvalidRange
+	}
+	start, err := strconv.ParseInt(strings.TrimSpace(first), 10, 64)
+	if err != nil || start < 0 || start >= total {
+		return 0, 0, errInvalidRange
+	}
+	end := total - 1
+	if last = strings.TrimSpace(last); last != "" {
+		parsed, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || parsed < start {
+			return 0, 0, errInvalidRange
+		}
+		end = min(parsed, total-1)
+	}
+	return start, end, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,94 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestChunkedSnapshotDownloadResumes(t *testing.T) {
+	store := storage.NewMemoryStore()
+	snapshot := `{"schema":"net.aggregat4.tasklist.snapshot@v1","data":{"lists":[{"id":"list-1","title":"` + strings.Repeat("x", 40) + `"}]}}`
+	if err := store.ReplaceSnapshot(context.Background(), "user-1", storage.Snapshot{DatasetGenerationKey: "dataset-a", Blob: snapshot}); err != nil {
+		t.Fatalf("replace snapshot: %v", err)
+	}
+	mux := http.NewServeMux()
+	NewServerWithConfig(store, Config{SnapshotChunkBytes: 32}).RegisterRoutes(mux)
+
+	resp := doRequest(t, mux, http.MethodGet, "/sync/bootstrap?snapshot=chunked", nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("bootstrap status: got %d", resp.Code)
+	}
+	var meta struct {
+		Snapshot           *string `json:"snapshot"`
+		SnapshotBytes      int     `json:"snapshotBytes"`
+		SnapshotSHA256     string  `json:"snapshotSha256"`
+		SnapshotChunkBytes int     `json:"snapshotChunkBytes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		t.Fatalf("decode bootstrap: %v", err)
+	}
+	if meta.Snapshot != nil || meta.SnapshotBytes != len(snapshot) || meta.SnapshotSHA256 != sha256Hex([]byte(snapshot)) || meta.SnapshotChunkBytes != 32 {
+		t.Fatalf("unexpected bootstrap metadata: %+v", meta)
+	}
+
+	var downloaded []byte
+	for len(downloaded) < meta.SnapshotBytes {
+		resp := doRequestWithHeaders(t, mux, http.MethodGet, "/sync/snapshot?datasetGenerationKey=dataset-a", nil, map[string]string{
+			"Range": "bytes=" + strconv.Itoa(len(downloaded)) + "-",
+		})
+		if resp.Code != http.StatusPartialContent {
+			t.Fatalf("chunk status at %d: got %d", len(downloaded), resp.Code)
+		}
+		chunk := resp.Body.Bytes()
+		if len(chunk) == 0 || len(chunk) > 32 {
+			t.Fatalf("unexpected chunk size %d", len(chunk))
+		}
+		if resp.Header().Get(chunkSHA256Header) != sha256Hex(chunk) {
+			t.Fatalf("chunk checksum mismatch at %d", len(downloaded))
+		}
+		downloaded = append(downloaded, chunk...)
+	}
+	if string(downloaded) != snapshot {
+		t.Fatalf("downloaded snapshot mismatch: %q", downloaded)
+	}
+
+	resp = doRequestWithHeaders(t, mux, http.MethodGet, "/sync/snapshot?datasetGenerationKey=dataset-a", nil, map[string]string{
+		"Range": "bytes=" + strconv.Itoa(len(snapshot)) + "-",
+	})
+	if resp.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("out of range status: got %d", resp.Code)
+	}
+	resp = doRequest(t, mux, http.MethodGet, "/sync/snapshot?datasetGenerationKey=dataset-old", nil)
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("stale generation status: got %d", resp.Code)
+	}
+}
+
+func TestParseByteRange(t *testing.T) {
+	cases := []struct {
+		header     string
+		start, end int64
+		ok         bool
+	}{
+		{"", 0, 9, true},
+		{"bytes=0-", 0, 9, true},
+		{"bytes=4-6", 4, 6, true},
+		{"bytes=4-100", 4, 9, true},
+		{"bytes=10-", 0, 0, false},
+		{"bytes=6-4", 0, 0, false},
+		{"bytes=0-1,4-5", 0, 0, false},
+		{"items=0-", 0, 0, false},
+	}
+	for _, tc := range cases {
+		start, end, err := parseByteRange(tc.header, 10)
+		if (err == nil) != tc.ok || (tc.ok && (start != tc.start || end != tc.end)) {
+			t.Fatalf("parseByteRange(%q) = %d, %d, %v", tc.header, start, end, err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"a4-tasklists/server/internal/spool"
+)
+
+// failPush answers a push that failed with a storage error. When a spool is
+// configured and storage is only degraded, the push is journaled and
+// acknowledged with 202 instead: the client can drop the ops from its outbox
+// because ReplaySpooled applies them once storage recovers.
+func (s *Server) failPush(w http.ResponseWriter, r *http.Request, entry spool.Entry, err error) {
+	if s.spool != nil && spool.Spoolable(err) {
+		entry.SpooledAt = time.Now()
+		spoolErr := s.spool.Append(entry)
+		if spoolErr == nil {
+			log.Printf("sync push spooled client=%s ops=%d pending=%d: %v", entry.ClientID, len(entry.Ops), s.spool.Pending(), err)
+			s.writeNegotiated(w, r, http.StatusAccepted, jsonResponse{
+				"spooled":              true,
+				"datasetGenerationKey": entry.DatasetGenerationKey,
+			})
+			return
+		}
+		log.Printf("sync push spool error client=%s: %v", entry.ClientID, spoolErr)
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
+
+// ReplaySpooled applies a spooled push the way handlePush would have. A push
+// made against a generation that was compacted since is applied to the active
+// generation, as the client would have done after its 409; one made against a
+// generation that an import replaced is rejected, since the user discarded
+// that data. The client cursor is left alone because the client never saw the
+// resulting serverSeq.
+func (s *Server) ReplaySpooled(ctx context.Context, entry spool.Entry) error {
+	if err := s.store.CheckClient(ctx, entry.UserID, entry.ClientID); err != nil {
+		return err
+	}
+	datasetGenerationKey, err := s.store.GetActiveDatasetGenerationKey(ctx, entry.UserID)
+	if err != nil {
+		return err
+	}
+	if entry.DatasetGenerationKey != datasetGenerationKey {
+		lineage, err := s.generationLineage(ctx, entry.UserID, entry.DatasetGenerationKey)
+		if err != nil {
+			return err
+		}
+		if lineage == lineageDivergent {
+			return fmt.Errorf("dataset generation %s was replaced by an import", entry.DatasetGenerationKey)
+		}
+	}
+	if err := s.store.BindActors(ctx, entry.UserID, entry.ClientID, opActors(entry.Ops)); err != nil {
+		return err
+	}
+	ops, _, err := s.checkMoves(ctx, entry.UserID, entry.Ops)
+	if err != nil {
+		return err
+	}
+	for i := range ops {
+		ops[i].ClientID = entry.ClientID
+	}
+	if _, err := s.store.InsertOps(ctx, entry.UserID, ops); err != nil {
+		return err
+	}
+	if len(ops) > 0 {
+		s.compaction.Trigger(entry.UserID)
+	}
+	s.fleet.Push()
+	s.stats.Ops(len(ops))
+	return nil
+}
@@ -0,0 +1,100 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"a4-tasklists/server/internal/spool"
+	"a4-tasklists/server/internal/storage"
+)
+
+// degradedStore fails InsertOps with storage.ErrUnavailable while down is set.
+type degradedStore struct {
+	storage.Store
+	down *atomic.Bool
+}
+
+func (s degradedStore) InsertOps(ctx context.Context, userID string, ops []storage.Op) (int64, error) {
+	if s.down.Load() {
+		return 0, storage.ErrUnavailable
+	}
+	return s.Store.InsertOps(ctx, userID, ops)
+}
+
+func TestPushIsSpooledWhileStorageIsDegraded(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	down := &atomic.Bool{}
+	path := filepath.Join(t.TempDir(), "spool.jsonl")
+	journal, err := spool.Open(path)
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	server := NewServerWithConfig(degradedStore{Store: store, down: down}, Config{Spool: journal})
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	bootstrap := fetchBootstrap(t, mux)
+	body, _ := json.Marshal(map[string]any{
+		"clientId":             "client-1",
+		"datasetGenerationKey": bootstrap.DatasetGenerationKey,
+		"ops": []map[string]any{
+			{"scope": "list", "resourceId": "list-1", "actor": "actor-1", "clock": 1, "payload": map[string]any{"type": "insert", "itemId": "item-1"}},
+		},
+	})
+
+	down.Store(true)
+	resp := doRequest(t, mux, http.MethodPost, "/sync/push", body)
+	if resp.Code != http.StatusAccepted {
+		t.Fatalf("push status: got %d: %s", resp.Code, resp.Body.String())
+	}
+	var payload struct {
+		Spooled              bool   `json:"spooled"`
+		DatasetGenerationKey string `json:"datasetGenerationKey"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil || !payload.Spooled || payload.DatasetGenerationKey != bootstrap.DatasetGenerationKey {
+		t.Fatalf("unexpected response: %+v (%v)", payload, err)
+	}
+	if journal.Pending() != 1 {
+		t.Fatalf("expected one spooled push, got %d", journal.Pending())
+	}
+
+	// Without a spool the same failure stays a 503.
+	plain := http.NewServeMux()
+	NewServer(degradedStore{Store: store, down: down}).RegisterRoutes(plain)
+	if resp := doRequest(t, plain, http.MethodPost, "/sync/push", body); resp.Code != http.StatusServiceUnavailable {
+		t.Fatalf("push without spool: got %d", resp.Code)
+	}
+
+	if applied, err := journal.Replay(ctx, server.ReplaySpooled); applied != 0 || !spool.Spoolable(err) {
+		t.Fatalf("replay while down: applied=%d err=%v", applied, err)
+	}
+	down.Store(false)
+	if applied, err := journal.Replay(ctx, server.ReplaySpooled); applied != 1 || err != nil {
+		t.Fatalf("replay: applied=%d err=%v", applied, err)
+	}
+	ops, _, err := store.GetOpsSince(ctx, "user-1", 0)
+	if err != nil || len(ops) != 1 || ops[0].ClientID != "client-1" {
+		t.Fatalf("expected the spooled op to be stored: %+v (%v)", ops, err)
+	}
+	if journal.Pending() != 0 {
+		t.Fatalf("expected an empty spool, got %d", journal.Pending())
+	}
+
+	// A push against a generation nobody knows cannot be applied anymore.
+	if err := journal.Append(spool.Entry{UserID: "user-1", ClientID: "client-1", DatasetGenerationKey: "replaced", Ops: ops}); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if applied, err := journal.Replay(ctx, server.ReplaySpooled); applied != 0 || err != nil {
+		t.Fatalf("replay: applied=%d err=%v", applied, err)
+	}
+	rejected, err := os.ReadFile(path + ".rejected")
+	if err != nil || !strings.Contains(string(rejected), `"replaced"`) {
+		t.Fatalf("expected the entry in the rejected file: %q (%v)", rejected, err)
+	}
+}
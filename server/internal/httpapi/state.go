@@ -0,0 +1,26 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+
+	"a4-tasklists/server/internal/materialize"
+)
+
+// loadState materializes the user's active dataset generation from the stored
+// snapshot and the op log replayed on top of it.
+func (s *Server) loadState(ctx context.Context, userID string) (materialize.State, error) {
+	snapshot, err := s.store.GetSnapshot(ctx, userID)
+	if err != nil {
+		return materialize.State{}, err
+	}
+	ops, _, err := s.store.GetOpsSince(ctx, userID, 0)
+	if err != nil {
+		return materialize.State{}, err
+	}
+	state, err := s.quarantine.Build(ctx, userID, snapshot.Blob, ops)
+	if err != nil {
+		return materialize.State{}, fmt.Errorf("materialize state: %w", err)
+	}
+	return state, nil
+}
@@ -0,0 +1,356 @@
+package httpapi
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"a4-tasklists/server/internal/outbox"
+	"a4-tasklists/server/internal/storage"
+)
+
+// GET /sync/stream delivers the same ops as repeated pulls over one
+// Server-Sent Events connection. Every event carries a resume token as its
+// id; a client that reconnects with it (browsers send Last-Event-ID on their
+// own), or with the last serverSeq it received, first gets the ops it missed
+// from storage and then live ones, without gaps or duplicates.
+//
+// Ordering: a stream keeps a cursor like a pulling client does and only ever
+// sends ops after it, so ops arrive in strictly increasing serverSeq order on
+// each connection and none are skipped.
+//
+// A client can also name the connection with connectionId. When such a
+// connection closes cleanly, the server saves the serverSeq of the last event
+// it delivered, and a reconnect with the same connectionId and no explicit
+// position resumes right after it. The cursor lives in the store, so this
+// works on any instance sharing it and the client needs to remember nothing.
+
+// streamPollInterval bounds how long a stream misses changes whose outbox
+// events are not delivered yet, and is the keepalive period.
+const streamPollInterval = 15 * time.Second
+
+// maxConnectionIDLength bounds the connectionId of a stream.
+const maxConnectionIDLength = 128
+
+// streamCursorSaveTimeout bounds saving the cursor of a closed stream.
+const streamCursorSaveTimeout = 5 * time.Second
+
+// streamHub wakes a user's streams when their ops may have changed.
+type streamHub struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan struct{}]struct{}
+}
+
+func newStreamHub() *streamHub {
+	return &streamHub{subscribers: make(map[string]map[chan struct{}]struct{})}
+}
+
+func (h *streamHub) subscribe(userID string) (<-chan struct{}, func()) {
+	wake := make(chan struct{}, 1)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[chan struct{}]struct{})
+	}
+	h.subscribers[userID][wake] = struct{}{}
+	return wake, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subscribers[userID], wake)
+		if len(h.subscribers[userID]) == 0 {
+			delete(h.subscribers, userID)
+		}
+	}
+}
+
+func (h *streamHub) notify(userID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for wake := range h.subscribers[userID] {
+		select {
+		case wake <- struct{}{}:
+		default:
+			// A wakeup is already pending.
+		}
+	}
+}
+
+// deliver is the outbox handler that wakes the streams of the event's user.
+func (h *streamHub) deliver(_ context.Context, event storage.OutboxEvent) error {
+	h.notify(event.UserID)
+	return nil
+}
+
+// dispatchingStore delivers outbox events right after the writes that record
+// them commit, whichever handler makes them. Events it fails to deliver stay
+// in the outbox for the dispatcher's next round.
+type dispatchingStore struct {
+	storage.Store
+	outbox *outbox.Dispatcher
+}
+
+func (s dispatchingStore) InsertOps(ctx context.Context, userID string, ops []storage.Op) (int64, error) {
+	serverSeq, err := s.Store.InsertOps(ctx, userID, ops)
+	if err == nil {
+		s.dispatch(ctx)
+	}
+	return serverSeq, err
+}
+
+func (s dispatchingStore) ReplaceSnapshot(ctx context.Context, userID string, snapshot storage.Snapshot) error {
+	err := s.Store.ReplaceSnapshot(ctx, userID, snapshot)
+	if err == nil {
+		s.dispatch(ctx)
+	}
+	return err
+}
+
+func (s dispatchingStore) ReplaceSnapshotIf(ctx context.Context, userID string, snapshot storage.Snapshot, precondition storage.SnapshotPrecondition) error {
+	err := s.Store.ReplaceSnapshotIf(ctx, userID, snapshot, precondition)
+	if err == nil {
+		s.dispatch(ctx)
+	}
+	return err
+}
+
+func (s dispatchingStore) dispatch(ctx context.Context) {
+	if err := s.outbox.Dispatch(ctx); err != nil {
+		log.Printf("outbox delivery error: %v", err)
+	}
+}
+
+// encodeResumeToken returns the opaque token for a position in a generation.
+func encodeResumeToken(datasetGenerationKey string, serverSeq int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(serverSeq, 10) + ":" + datasetGenerationKey))
+}
+
+func decodeResumeToken(token string) (string, int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", 0, errors.New("invalid resume token")
+	}
+	seq, key, ok := strings.Cut(string(raw), ":")
+	serverSeq, err := strconv.ParseInt(seq, 10, 64)
+	if !ok || err != nil || serverSeq < 0 || key == "" {
+		return "", 0, errors.New("invalid resume token")
+	}
+	return key, serverSeq, nil
+}
+
+// streamResumePosition returns the generation and cursor a stream starts
+// from. A resume position comes from Last-Event-ID or the resume parameter and
+// is either a token from an earlier event or the last received serverSeq; the
+// latter, like since, needs datasetGenerationKey. Without any of them, saved
+// is where the same connection stopped last time, used unless
+// datasetGenerationKey names another generation.
+func streamResumePosition(r *http.Request, saved *storage.StreamCursor) (string, int64, error) {
+	query := r.URL.Query()
+	position := r.Header.Get("Last-Event-ID")
+	if position == "" {
+		position = query.Get("resume")
+	}
+	if position == "" {
+		position = query.Get("since")
+	} else if _, err := strconv.ParseInt(position, 10, 64); err != nil {
+		return decodeResumeToken(position)
+	}
+	datasetGenerationKey := query.Get("datasetGenerationKey")
+	if position == "" && saved != nil && (datasetGenerationKey == "" || datasetGenerationKey == saved.DatasetGenerationKey) {
+		return saved.DatasetGenerationKey, saved.ServerSeq, nil
+	}
+	if datasetGenerationKey == "" {
+		return "", 0, errors.New("datasetGenerationKey or a resume token is required")
+	}
+	if position == "" {
+		return datasetGenerationKey, 0, nil
+	}
+	cursor, err := strconv.ParseInt(position, 10, 64)
+	if err != nil || cursor < 0 {
+		return "", 0, errors.New("since must be a non-negative integer")
+	}
+	return datasetGenerationKey, cursor, nil
+}
+
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	clientID := r.URL.Query().Get("clientId")
+	if clientID == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "clientId is required"})
+		return
+	}
+	if !s.ensureClientAllowed(w, r, userID, clientID) {
+		return
+	}
+	connectionID := r.URL.Query().Get("connectionId")
+	if len(connectionID) > maxConnectionIDLength {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "connectionId is too long"})
+		return
+	}
+	var saved *storage.StreamCursor
+	if connectionID != "" {
+		cursor, err := s.store.GetStreamCursor(r.Context(), userID, clientID, connectionID)
+		if err == nil {
+			saved = &cursor
+		} else if !errors.Is(err, storage.ErrStreamCursorNotFound) {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	datasetGenerationKey, cursor, err := streamResumePosition(r, saved)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, ok := s.ensureDatasetMatch(r, userID, clientID, datasetGenerationKey, w); !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "streaming is not supported"})
+		return
+	}
+
+	wake, unsubscribe := s.streams.subscribe(userID)
+	defer unsubscribe()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	// Tell EventSource how soon to reconnect after the connection drops.
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+	log.Printf("sync stream opened client=%s since=%d", clientID, cursor)
+
+	ticker := time.NewTicker(streamPollInterval)
+	defer ticker.Stop()
+	for {
+		next, open, err := s.streamOps(r, w, userID, clientID, datasetGenerationKey, cursor)
+		if err != nil {
+			if r.Context().Err() == nil {
+				log.Printf("sync stream error client=%s since=%d: %v", clientID, cursor, err)
+			}
+			return
+		}
+		flusher.Flush()
+		if !open {
+			return
+		}
+		cursor = next
+		select {
+		case <-r.Context().Done():
+			// The client went away between events, so cursor is exactly what
+			// the connection delivered.
+			if connectionID != "" {
+				s.saveStreamCursor(r, userID, storage.StreamCursor{
+					ClientID:             clientID,
+					ConnectionID:         connectionID,
+					DatasetGenerationKey: datasetGenerationKey,
+					ServerSeq:            cursor,
+				})
+			}
+			return
+		case <-wake:
+		case <-ticker.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		}
+	}
+}
+
+// saveStreamCursor stores where a closed connection stopped. The request's
+// context is already cancelled, so the write gets one of its own.
+func (s *Server) saveStreamCursor(r *http.Request, userID string, cursor storage.StreamCursor) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), streamCursorSaveTimeout)
+	defer cancel()
+	if err := s.store.SaveStreamCursor(ctx, userID, cursor); err != nil {
+		log.Printf("sync stream cursor error client=%s connection=%s: %v", cursor.ClientID, cursor.ConnectionID, err)
+	}
+}
+
+// streamOps sends the ops after cursor as one event and returns the new
+// cursor. It reports false when the stream has to end because the active
+// generation changed, after sending a reset event.
+func (s *Server) streamOps(r *http.Request, w http.ResponseWriter, userID string, clientID string, datasetGenerationKey string, cursor int64) (int64, bool, error) {
+	ctx := r.Context()
+	ops, serverSeq, err := s.store.GetOpsSince(ctx, userID, cursor)
+	if err != nil {
+		return 0, false, err
+	}
+	// Generation keys never repeat, so when the key still matches after the
+	// read, the ops belong to the client's generation.
+	current, err := s.store.GetActiveDatasetGenerationKey(ctx, userID)
+	if err != nil {
+		return 0, false, err
+	}
+	if current != datasetGenerationKey {
+		log.Printf("sync stream reset client=%s generation=%s->%s", clientID, datasetGenerationKey, current)
+		return 0, false, writeStreamEvent(w, "reset", "", jsonResponse{"datasetGenerationKey": current})
+	}
+	if serverSeq <= cursor {
+		return cursor, true, nil
+	}
+	// The store returns ops after cursor in serverSeq order; this guard keeps
+	// the per-connection guarantee even if it did not.
+	ops = slices.DeleteFunc(ops, func(op storage.Op) bool { return op.ServerSeq <= cursor })
+	slices.SortStableFunc(ops, func(a, b storage.Op) int { return cmp.Compare(a.ServerSeq, b.ServerSeq) })
+	if r.URL.Query().Get("omitOwn") == "true" {
+		ops = slices.DeleteFunc(ops, func(op storage.Op) bool { return op.ClientID == clientID })
+	}
+	archived, err := s.loadSyncArchivedView(r, userID)
+	if err != nil {
+		return 0, false, err
+	}
+	ops = archived.filterOps(ops)
+	payload := jsonResponse{
+		"serverSeq":            serverSeq,
+		"datasetGenerationKey": datasetGenerationKey,
+		"ops":                  ops,
+	}
+	archived.annotate(payload)
+	if len(ops) > 0 {
+		attribution, err := s.store.GetActorAttribution(ctx, userID, opActors(ops))
+		if err != nil {
+			return 0, false, err
+		}
+		if len(attribution) > 0 {
+			payload["actors"] = attribution
+		}
+	}
+	if err := writeStreamEvent(w, "ops", encodeResumeToken(datasetGenerationKey, serverSeq), payload); err != nil {
+		return 0, false, err
+	}
+	if err := s.store.UpdateClientCursor(ctx, userID, clientID, serverSeq); err != nil {
+		return 0, false, err
+	}
+	return serverSeq, true, nil
+}
+
+func writeStreamEvent(w http.ResponseWriter, event string, id string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	if id != "" {
+		fmt.Fprintf(&b, "id: %s\n", id)
+	}
+	fmt.Fprintf(&b, "event: %s\ndata: %s\n\n", event, data)
+	_, err = w.Write([]byte(b.String()))
+	return err
+}
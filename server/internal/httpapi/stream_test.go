@@ -0,0 +1,294 @@
+package httpapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"a4-tasklists/server/internal/auth"
+	"a4-tasklists/server/internal/outbox"
+	"a4-tasklists/server/internal/storage"
+)
+
+type streamEvent struct {
+	ID    string
+	Event string
+	Data  struct {
+		ServerSeq            int64        `json:"serverSeq"`
+		DatasetGenerationKey string       `json:"datasetGenerationKey"`
+		Ops                  []storage.Op `json:"ops"`
+	}
+}
+
+// openStream connects to /sync/stream on a real server, since streaming
+// needs a connection that is flushed while the handler runs.
+func openStream(t *testing.T, server *httptest.Server, query string, lastEventID string) func() streamEvent {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/sync/stream?"+query, nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("stream status %d content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	events := make(chan streamEvent)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		var event streamEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				event.ID = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				event.Event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.Data)
+			case line == "" && event.Event != "":
+				events <- event
+				event = streamEvent{}
+			}
+		}
+	}()
+	return func() streamEvent {
+		t.Helper()
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatal("stream closed")
+			}
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a stream event")
+		}
+		return streamEvent{}
+	}
+}
+
+// newStreamServer serves mux over a real connection as user-1 and returns a
+// helper that pushes one list op per clock.
+func newStreamServer(t *testing.T) (*httptest.Server, *http.ServeMux, string, func(clocks ...int64)) {
+	t.Helper()
+	mux := newTestMux(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, r.WithContext(auth.ContextWithUserID(r.Context(), "user-1")))
+	}))
+	t.Cleanup(server.Close)
+	bootstrap := fetchBootstrap(t, mux)
+	push := func(clocks ...int64) {
+		t.Helper()
+		ops := make([]map[string]any, 0, len(clocks))
+		for _, clock := range clocks {
+			ops = append(ops, map[string]any{"scope": "list", "resourceId": "list-1", "actor": "actor-1", "clock": clock, "payload": map[string]any{"type": "insert", "itemId": "item-1"}})
+		}
+		body, _ := json.Marshal(map[string]any{"clientId": "client-1", "datasetGenerationKey": bootstrap.DatasetGenerationKey, "ops": ops})
+		if resp := doRequest(t, mux, http.MethodPost, "/sync/push", body); resp.Code != http.StatusOK {
+			t.Fatalf("push status: got %d", resp.Code)
+		}
+	}
+	return server, mux, bootstrap.DatasetGenerationKey, push
+}
+
+func clocks(event streamEvent) []int64 {
+	var clocks []int64
+	for _, op := range event.Data.Ops {
+		clocks = append(clocks, op.Clock)
+	}
+	return clocks
+}
+
+func TestStreamDeliversOpsInOrderAndResumes(t *testing.T) {
+	server, mux, datasetGenerationKey, push := newStreamServer(t)
+
+	push(1, 2)
+	query := "clientId=client-2&datasetGenerationKey=" + url.QueryEscape(datasetGenerationKey)
+	next := openStream(t, server, query, "")
+	first := next()
+	if first.Event != "ops" || first.ID == "" || len(first.Data.Ops) != 2 || first.Data.Ops[0].ServerSeq >= first.Data.Ops[1].ServerSeq {
+		t.Fatalf("unexpected first event: %+v", first)
+	}
+	push(3)
+	push(4, 5)
+	var seen []int64
+	last := first
+	for len(seen) < 3 {
+		event := next()
+		for _, op := range event.Data.Ops {
+			if op.ServerSeq <= last.Data.ServerSeq {
+				t.Fatalf("op %d delivered after serverSeq %d", op.ServerSeq, last.Data.ServerSeq)
+			}
+		}
+		seen = append(seen, clocks(event)...)
+		last = event
+	}
+	if len(seen) != 3 || seen[0] != 3 || seen[1] != 4 || seen[2] != 5 {
+		t.Fatalf("expected ops 3, 4, 5 in order, got %v", seen)
+	}
+
+	// Resuming after the first event redelivers exactly what followed it.
+	resumed := openStream(t, server, "clientId=client-2", first.ID)()
+	if got := clocks(resumed); len(got) != 3 || got[0] != 3 || got[2] != 5 || resumed.ID != last.ID {
+		t.Fatalf("unexpected resumed event: %+v", resumed)
+	}
+
+	// Resetting the dataset ends the stream with a reset event.
+	body, _ := json.Marshal(map[string]any{"clientId": "client-1", "datasetGenerationKey": "gen-2", "snapshot": ""})
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/reset", body); resp.Code != http.StatusOK {
+		t.Fatalf("reset status: got %d: %s", resp.Code, resp.Body.String())
+	}
+	if event := next(); event.Event != "reset" || event.Data.DatasetGenerationKey != "gen-2" {
+		t.Fatalf("expected a reset event, got %+v", event)
+	}
+}
+
+func TestStreamResumesFromLastReceivedServerSeq(t *testing.T) {
+	server, _, datasetGenerationKey, push := newStreamServer(t)
+	push(1)
+	push(2)
+	full := openStream(t, server, "clientId=client-2&datasetGenerationKey="+url.QueryEscape(datasetGenerationKey), "")()
+	if len(full.Data.Ops) != 2 {
+		t.Fatalf("expected both ops, got %+v", full)
+	}
+
+	// The connection dropped after op 1: the missed op 2 is replayed from
+	// storage, then live ops follow without repeating it.
+	lastReceived := strconv.FormatInt(full.Data.Ops[0].ServerSeq, 10)
+	next := openStream(t, server, "clientId=client-2&datasetGenerationKey="+url.QueryEscape(datasetGenerationKey), lastReceived)
+	if got := clocks(next()); len(got) != 1 || got[0] != 2 {
+		t.Fatalf("expected the missed op 2, got %v", got)
+	}
+	push(3)
+	if got := clocks(next()); len(got) != 1 || got[0] != 3 {
+		t.Fatalf("expected the live op 3, got %v", got)
+	}
+}
+
+func TestStreamRejectsBadResumeTokens(t *testing.T) {
+	mux := newTestMux(t)
+	fetchBootstrap(t, mux)
+	if resp := doRequestWithHeaders(t, mux, http.MethodGet, "/sync/stream?clientId=client-1", nil, map[string]string{"Last-Event-ID": "not a token"}); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a malformed token, got %d", resp.Code)
+	}
+	if resp := doRequestWithHeaders(t, mux, http.MethodGet, "/sync/stream?clientId=client-1", nil, map[string]string{"Last-Event-ID": "3"}); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a serverSeq without datasetGenerationKey, got %d", resp.Code)
+	}
+	stale := encodeResumeToken("gen-old", 3)
+	if resp := doRequestWithHeaders(t, mux, http.MethodGet, "/sync/stream?clientId=client-1", nil, map[string]string{"Last-Event-ID": stale}); resp.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a token of another generation, got %d", resp.Code)
+	}
+}
+
+func TestStreamWakesForOutboxEventsOfBackgroundWrites(t *testing.T) {
+	store := storage.NewMemoryStore()
+	dispatcher := outbox.New(store)
+	mux := http.NewServeMux()
+	NewServerWithConfig(store, Config{Outbox: dispatcher}).RegisterRoutes(mux)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, r.WithContext(auth.ContextWithUserID(r.Context(), "user-1")))
+	}))
+	t.Cleanup(server.Close)
+	root := fetchBootstrap(t, mux).DatasetGenerationKey
+	if _, err := store.InsertOps(context.Background(), "user-1", []storage.Op{{Scope: "list", Resource: "list-1", Actor: "actor-1", Clock: 1, Payload: []byte(`{}`)}}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	next := openStream(t, server, "clientId=client-1&datasetGenerationKey="+url.QueryEscape(root), "")
+	// The stream waits for changes once it delivered what was there.
+	if event := next(); event.Event != "ops" || len(event.Data.Ops) != 1 {
+		t.Fatalf("expected the stored op, got %+v", event)
+	}
+
+	// A compaction writes to the store directly; the dispatcher's next round
+	// wakes the stream instead of its poll.
+	snapshot := `{"schema":"net.aggregat4.tasklist.snapshot@v1","data":{"lists":[]}}`
+	if err := store.ReplaceSnapshot(context.Background(), "user-1", storage.Snapshot{DatasetGenerationKey: "compacted", Blob: snapshot, ParentDatasetGenerationKey: root}); err != nil {
+		t.Fatalf("replace snapshot: %v", err)
+	}
+	if err := dispatcher.Dispatch(context.Background()); err != nil {
+		t.Fatalf("dispatch: %v", err)
+	}
+	if event := next(); event.Event != "reset" || event.Data.DatasetGenerationKey != "compacted" {
+		t.Fatalf("expected a reset event, got %+v", event)
+	}
+	if pending, err := store.ListOutbox(context.Background(), 10); err != nil || len(pending) != 0 {
+		t.Fatalf("expected the outbox to be drained, got %+v (%v)", pending, err)
+	}
+}
+
+func TestStreamConnectionResumesFromSavedCursorOnAnotherInstance(t *testing.T) {
+	store := storage.NewMemoryStore()
+	instance := func() (*httptest.Server, *http.ServeMux) {
+		mux := http.NewServeMux()
+		NewServer(store).RegisterRoutes(mux)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mux.ServeHTTP(w, r.WithContext(auth.ContextWithUserID(r.Context(), "user-1")))
+		}))
+		t.Cleanup(server.Close)
+		return server, mux
+	}
+	first, mux := instance()
+	second, _ := instance()
+	root := fetchBootstrap(t, mux).DatasetGenerationKey
+	insert := func(clock int64) {
+		t.Helper()
+		if _, err := store.InsertOps(context.Background(), "user-1", []storage.Op{{Scope: "list", Resource: "list-1", Actor: "actor-1", Clock: clock, Payload: []byte(`{}`)}}); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	insert(1)
+	next := openStream(t, first, "clientId=client-1&connectionId=tab-1&datasetGenerationKey="+url.QueryEscape(root), "")
+	delivered := next()
+	if len(delivered.Data.Ops) != 1 {
+		t.Fatalf("expected the stored op, got %+v", delivered)
+	}
+
+	// The connection closes; its cursor is saved in the shared store.
+	first.CloseClientConnections()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cursor, err := store.GetStreamCursor(context.Background(), "user-1", "client-1", "tab-1")
+		if err == nil {
+			if cursor.ServerSeq != delivered.Data.ServerSeq || cursor.DatasetGenerationKey != root {
+				t.Fatalf("unexpected saved cursor %+v", cursor)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cursor was not saved: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Reconnecting elsewhere with the connection id alone delivers only what
+	// the connection has not seen.
+	insert(2)
+	if got := clocks(openStream(t, second, "clientId=client-1&connectionId=tab-1", "")()); len(got) != 1 || got[0] != 2 {
+		t.Fatalf("expected only op 2, got %v", got)
+	}
+	// An explicit position still wins over the saved cursor.
+	if got := clocks(openStream(t, second, "clientId=client-1&connectionId=tab-1&since=0&datasetGenerationKey="+url.QueryEscape(root), "")()); len(got) != 2 {
+		t.Fatalf("expected since=0 to replay both ops, got %v", got)
+	}
+	// Other connections of the client have no cursor of their own yet.
+	req := httptest.NewRequest(http.MethodGet, "/sync/stream?clientId=client-1&connectionId=tab-2", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req.WithContext(auth.ContextWithUserID(req.Context(), "user-1")))
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected a new connection without position to be rejected, got %d", resp.Code)
+	}
+}
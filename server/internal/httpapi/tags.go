@@ -0,0 +1,69 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/storage"
+)
+
+func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	tags, err := s.store.ListTags(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{
+		"tags": tags,
+	})
+}
+
+func (s *Server) handleItems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	tag := storage.NormalizeTag(r.URL.Query().Get("tag"))
+	if tag == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "tag is required"})
+		return
+	}
+	tagged, err := s.store.ListTaggedItems(r.Context(), userID, tag)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	items := make([]materialize.Item, 0, len(tagged))
+	if len(tagged) > 0 {
+		state, err := s.loadState(r.Context(), userID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		wanted := make(map[storage.TaggedItem]struct{}, len(tagged))
+		for _, item := range tagged {
+			wanted[item] = struct{}{}
+		}
+		for _, item := range state.Items() {
+			if _, ok := wanted[storage.TaggedItem{ListID: item.ListID, ItemID: item.ID}]; ok {
+				items = append(items, item)
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{
+		"tag":   tag,
+		"items": items,
+	})
+}
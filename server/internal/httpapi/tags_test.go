@@ -0,0 +1,64 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestTagsAndItemsByTag(t *testing.T) {
+	mux := newTestMux(t)
+	bootstrap := fetchBootstrap(t, mux)
+	body, _ := json.Marshal(map[string]any{
+		"clientId":             "client-1",
+		"datasetGenerationKey": bootstrap.DatasetGenerationKey,
+		"ops": []map[string]any{
+			{"scope": "registry", "resourceId": "registry", "actor": "actor-1", "clock": 1, "payload": map[string]any{"type": "createList", "listId": "list-1", "payload": map[string]any{"title": "Shopping"}}},
+			{"scope": "list", "resourceId": "list-1", "actor": "actor-1", "clock": 2, "payload": map[string]any{"type": "insert", "itemId": "item-1", "payload": map[string]any{"data": map[string]any{"text": "milk"}}}},
+			{"scope": "list", "resourceId": "list-1", "actor": "actor-1", "clock": 3, "payload": map[string]any{"type": "insert", "itemId": "item-2", "payload": map[string]any{"data": map[string]any{"text": "bread"}}}},
+			{"scope": "list", "resourceId": "list-1", "actor": "actor-1", "clock": 4, "payload": map[string]any{"type": "addTag", "itemId": "item-1", "payload": map[string]any{"tag": "dairy"}}},
+		},
+	})
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/push", body); resp.Code != http.StatusOK {
+		t.Fatalf("push status: got %d", resp.Code)
+	}
+
+	tagsResp := doRequest(t, mux, http.MethodGet, "/tags", nil)
+	if tagsResp.Code != http.StatusOK {
+		t.Fatalf("tags status: got %d", tagsResp.Code)
+	}
+	var tagsPayload struct {
+		Tags []storage.TagCount `json:"tags"`
+	}
+	if err := json.NewDecoder(tagsResp.Body).Decode(&tagsPayload); err != nil {
+		t.Fatalf("decode tags: %v", err)
+	}
+	if len(tagsPayload.Tags) != 1 || tagsPayload.Tags[0].Tag != "dairy" || tagsPayload.Tags[0].Count != 1 {
+		t.Fatalf("unexpected tags: %+v", tagsPayload.Tags)
+	}
+
+	itemsResp := doRequest(t, mux, http.MethodGet, "/items?tag=%23Dairy", nil)
+	if itemsResp.Code != http.StatusOK {
+		t.Fatalf("items status: got %d", itemsResp.Code)
+	}
+	var itemsPayload struct {
+		Items []materialize.Item `json:"items"`
+	}
+	if err := json.NewDecoder(itemsResp.Body).Decode(&itemsPayload); err != nil {
+		t.Fatalf("decode items: %v", err)
+	}
+	if len(itemsPayload.Items) != 1 || itemsPayload.Items[0].Text != "milk" {
+		t.Fatalf("unexpected items: %+v", itemsPayload.Items)
+	}
+}
+
+func TestItemsRequiresTag(t *testing.T) {
+	mux := newTestMux(t)
+	resp := doRequest(t, mux, http.MethodGet, "/items", nil)
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("status: got %d", resp.Code)
+	}
+}
@@ -0,0 +1,137 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"a4-tasklists/server/internal/materialize"
+
+	"github.com/google/uuid"
+)
+
+type listSummary struct {
+	ListID    string `json:"listId"`
+	Title     string `json:"title"`
+	ItemCount int    `json:"itemCount"`
+}
+
+// handleTemplates lists the user's template lists that still exist.
+func (s *Server) handleTemplates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	listIDs, err := s.store.ListTemplates(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	templates := make([]listSummary, 0, len(listIDs))
+	if len(listIDs) > 0 {
+		state, err := s.loadState(r.Context(), userID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		for _, listID := range listIDs {
+			if list, ok := state.FindList(listID); ok {
+				templates = append(templates, listSummary{ListID: list.ID, Title: list.Title, ItemCount: len(list.Items)})
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, jsonResponse{"templates": templates})
+}
+
+// handleListTemplate marks or unmarks a list as a template (PUT {template}).
+func (s *Server) handleListTemplate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	var payload struct {
+		Template bool `json:"template"`
+	}
+	if err := decodeJSON(r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	listID := r.PathValue("id")
+	if payload.Template {
+		state, err := s.loadState(r.Context(), userID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if _, ok := state.FindList(listID); !ok {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: materialize.ErrListNotFound.Error()})
+			return
+		}
+	}
+	if err := s.store.SetListTemplate(r.Context(), userID, listID, payload.Template); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDuplicateList creates a copy of a list, with all items unchecked, by
+// appending the ops a client would have pushed. Other clients (including the
+// caller) pick the new list up on their next pull.
+func (s *Server) handleDuplicateList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	var payload struct {
+		Title string `json:"title"`
+	}
+	if err := decodeJSON(r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	g, release, err := s.newGenerator(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer release()
+	listID := "list-" + uuid.NewString()
+	err = g.DuplicateList(r.PathValue("id"), materialize.Copy{
+		ListID:    listID,
+		Title:     payload.Title,
+		NewItemID: func() string { return "task-" + uuid.NewString() },
+	})
+	if errors.Is(err, materialize.ErrListNotFound) {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("duplicate list: %w", err))
+		return
+	}
+	copied := g.Ops()
+	serverSeq, err := s.insertServerOps(r.Context(), userID, copied)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("list duplicated source=%s list=%s items=%d", r.PathValue("id"), listID, len(copied)-1)
+	writeJSON(w, http.StatusCreated, jsonResponse{
+		"listId":    listID,
+		"serverSeq": serverSeq,
+	})
+}
@@ -0,0 +1,90 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestTemplateDuplicationCreatesUncheckedCopy(t *testing.T) {
+	mux := newTestMux(t)
+	bootstrap := fetchBootstrap(t, mux)
+	body, _ := json.Marshal(map[string]any{
+		"clientId":             "client-a",
+		"datasetGenerationKey": bootstrap.DatasetGenerationKey,
+		"ops": []map[string]any{
+			{"scope": "registry", "resourceId": "registry", "actor": "actor-a", "clock": 1, "payload": map[string]any{"type": "createList", "listId": "list-1", "payload": map[string]any{"title": "Packing"}}},
+			{"scope": "list", "resourceId": "list-1", "actor": "actor-a", "clock": 2, "payload": map[string]any{"type": "insert", "itemId": "item-1", "payload": map[string]any{"data": map[string]any{"text": "passport"}}}},
+			{"scope": "list", "resourceId": "list-1", "actor": "actor-a", "clock": 3, "payload": map[string]any{"type": "update", "itemId": "item-1", "payload": map[string]any{"data": map[string]any{"done": true}}}},
+		},
+	})
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/push", body); resp.Code != http.StatusOK {
+		t.Fatalf("push status: got %d", resp.Code)
+	}
+
+	if resp := doRequest(t, mux, http.MethodPut, "/lists/missing/template", []byte(`{"template":true}`)); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown list, got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodPut, "/lists/list-1/template", []byte(`{"template":true}`)); resp.Code != http.StatusNoContent {
+		t.Fatalf("template status: got %d", resp.Code)
+	}
+	resp := doRequest(t, mux, http.MethodGet, "/templates", nil)
+	var listed struct {
+		Templates []listSummary `json:"templates"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+		t.Fatalf("decode templates: %v", err)
+	}
+	if len(listed.Templates) != 1 || listed.Templates[0] != (listSummary{ListID: "list-1", Title: "Packing", ItemCount: 1}) {
+		t.Fatalf("unexpected templates: %+v", listed.Templates)
+	}
+
+	resp = doRequest(t, mux, http.MethodPost, "/lists/list-1/duplicate", []byte(`{"title":"Packing: Rome"}`))
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("duplicate status: got %d", resp.Code)
+	}
+	var created struct {
+		ListID    string `json:"listId"`
+		ServerSeq int64  `json:"serverSeq"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode duplicate: %v", err)
+	}
+	if created.ListID == "" || created.ServerSeq != 5 {
+		t.Fatalf("unexpected duplicate response: %+v", created)
+	}
+
+	resp = doRequest(t, mux, http.MethodGet, "/sync/pull?since=3&clientId=client-a&omitOwn=true&datasetGenerationKey="+bootstrap.DatasetGenerationKey, nil)
+	var pulled struct {
+		Ops []struct {
+			Scope      string          `json:"scope"`
+			ResourceID string          `json:"resourceId"`
+			Payload    json.RawMessage `json:"payload"`
+		} `json:"ops"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pulled); err != nil {
+		t.Fatalf("decode pull: %v", err)
+	}
+	if len(pulled.Ops) != 2 || pulled.Ops[0].Scope != "registry" || pulled.Ops[1].ResourceID != created.ListID {
+		t.Fatalf("expected the copy's ops to reach the caller, got %+v", pulled.Ops)
+	}
+	var insert struct {
+		Type    string `json:"type"`
+		Payload struct {
+			Data struct {
+				Text string `json:"text"`
+				Done bool   `json:"done"`
+			} `json:"data"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(pulled.Ops[1].Payload, &insert); err != nil {
+		t.Fatalf("decode insert: %v", err)
+	}
+	if insert.Type != "insert" || insert.Payload.Data.Text != "passport" || insert.Payload.Data.Done {
+		t.Fatalf("unexpected copied item: %s", pulled.Ops[1].Payload)
+	}
+
+	if resp := doRequest(t, mux, http.MethodPost, "/lists/missing/duplicate", []byte(`{}`)); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 duplicating unknown list, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,278 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"a4-tasklists/server/internal/auth"
+	"a4-tasklists/server/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// apiTokenPrefix marks API token secrets so they are easy to recognize in
+// logs and secret scanners.
+const apiTokenPrefix = "lat_"
+
+type apiTokenContextKey struct{}
+
+// handleAPITokens lists (GET) or creates (POST {name, scopes, listId,
+// expiresAt, signed}) the user's API tokens. The secret is only returned on
+// creation.
+func (s *Server) handleAPITokens(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		tokens, err := s.store.ListAPITokens(r.Context(), userID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, jsonResponse{"tokens": tokens})
+	case http.MethodPost:
+		var payload struct {
+			Name      string   `json:"name"`
+			Scopes    []string `json:"scopes"`
+			ListID    string   `json:"listId"`
+			ExpiresAt int64    `json:"expiresAt"`
+			Signed    bool     `json:"signed"`
+		}
+		if err := decodeJSON(r, &payload); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		payload.Name = strings.TrimSpace(payload.Name)
+		if payload.Name == "" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "name is required"})
+			return
+		}
+		scopes, err := tokenScopes(payload.Scopes)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+			return
+		}
+		if payload.ExpiresAt != 0 && payload.ExpiresAt <= time.Now().Unix() {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "expiresAt must be in the future"})
+			return
+		}
+		if payload.ListID != "" {
+			state, err := s.loadState(r.Context(), userID)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			if _, ok := state.FindList(payload.ListID); !ok {
+				writeJSON(w, http.StatusBadRequest, errorResponse{Error: "unknown list " + payload.ListID})
+				return
+			}
+		}
+		token, secret, err := s.issueAPIToken(r.Context(), userID, storage.APIToken{
+			Name:      payload.Name,
+			Scopes:    scopes,
+			ListID:    payload.ListID,
+			ExpiresAt: payload.ExpiresAt,
+			Signed:    payload.Signed,
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, jsonResponse{"token": token, "secret": secret})
+	default:
+		methodNotAllowed(w)
+	}
+}
+
+// tokenScopes checks requested token scopes and drops duplicates.
+func tokenScopes(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	scopes := make([]string, 0, len(requested))
+	for _, scope := range requested {
+		if !slices.Contains(storage.TokenScopes, scope) {
+			return nil, errors.New("unknown scope " + scope + " (want " + strings.Join(storage.TokenScopes, ", ") + ")")
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// issueAPIToken assigns token an id and creation time, stores it under a new
+// secret and returns both.
+func (s *Server) issueAPIToken(ctx context.Context, userID string, token storage.APIToken) (storage.APIToken, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return storage.APIToken{}, "", err
+	}
+	secret := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	token.ID = "token-" + uuid.NewString()
+	token.CreatedAt = time.Now().Unix()
+	var signingKey string
+	if token.Signed {
+		signingKey = SigningKey(secret)
+	}
+	if err := s.store.CreateAPIToken(ctx, userID, token, sha256Hex([]byte(secret)), signingKey); err != nil {
+		return storage.APIToken{}, "", err
+	}
+	log.Printf("API token created token=%s scopes=%s list=%s", token.ID, strings.Join(token.Scopes, ","), token.ListID)
+	return token, secret, nil
+}
+
+// handleRevokeAPIToken deletes one of the user's API tokens.
+func (s *Server) handleRevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		methodNotAllowed(w)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	if err := s.store.RevokeAPIToken(r.Context(), userID, r.PathValue("id")); err != nil {
+		if errors.Is(err, storage.ErrAPITokenNotFound) {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("API token revoked token=%s", r.PathValue("id"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// bearerSecret returns the API token secret the request carries, if any.
+// The WebDAV mount also takes it as a Basic auth password, which is all file
+// managers can send; it is read-only, so a browser replaying cached Basic
+// credentials cannot change anything.
+func bearerSecret(r *http.Request) (string, bool) {
+	if isDAVPath(r.URL.Path) {
+		if _, secret, ok := r.BasicAuth(); ok {
+			return secret, strings.HasPrefix(secret, apiTokenPrefix)
+		}
+	}
+	secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return secret, ok && strings.HasPrefix(secret, apiTokenPrefix)
+}
+
+// apiToken authenticates the request's bearer token or signature, unless
+// WithAPITokens already did. It answers 401 itself when the token is missing,
+// unknown or expired.
+func (s *Server) apiToken(w http.ResponseWriter, r *http.Request) (storage.APIToken, bool) {
+	if token, ok := r.Context().Value(apiTokenContextKey{}).(storage.APIToken); ok {
+		return token, true
+	}
+	// File managers only ask for credentials when challenged with Basic.
+	challenge, invalid := `Bearer realm="api"`, `Bearer realm="api", error="invalid_token"`
+	if isDAVPath(r.URL.Path) {
+		challenge, invalid = `Basic realm="lists"`, `Basic realm="lists"`
+	}
+	if hasSignature(r) {
+		return s.signedToken(w, r)
+	}
+	secret, ok := bearerSecret(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", challenge)
+		writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "API token required"})
+		return storage.APIToken{}, false
+	}
+	token, err := s.store.UseAPIToken(r.Context(), sha256Hex([]byte(secret)), time.Now().Unix())
+	if errors.Is(err, storage.ErrAPITokenNotFound) {
+		w.Header().Set("WWW-Authenticate", invalid)
+		writeJSON(w, http.StatusUnauthorized, errorResponse{Error: err.Error()})
+		return storage.APIToken{}, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return storage.APIToken{}, false
+	}
+	if token.Signed {
+		w.Header().Set("WWW-Authenticate", `Signature realm="api"`)
+		writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "this token only accepts signed requests"})
+		return storage.APIToken{}, false
+	}
+	return token, true
+}
+
+// isTokenRoute reports whether path is served to API tokens only; those
+// handlers check scopes and lists themselves.
+func isTokenRoute(path string) bool {
+	return path == "/mcp" || path == "/quick-add" || strings.HasPrefix(path, "/integrations/") || isDAVPath(path)
+}
+
+// tokenAllows checks an API token against a request to a route outside the
+// token routes: admin tokens may do what a session may, read tokens may only
+// GET outside /sync (pulls and streams move the client's cursor and take its
+// hints), and list tokens only reach /lists/{id}/… of their list. Operator
+// (/admin), login (/auth) and OAuth (/oauth) routes stay closed, except token
+// management for admin tokens.
+func tokenAllows(token storage.APIToken, r *http.Request) error {
+	path := r.URL.Path
+	if isTokenRoute(path) {
+		return nil
+	}
+	if underPath(path, "/admin") || underPath(path, "/oauth") || (underPath(path, "/auth") && !underPath(path, "/auth/tokens")) {
+		return fmt.Errorf("API tokens cannot be used for %s", path)
+	}
+	if token.ListID != "" && path != "/lists/"+token.ListID && !strings.HasPrefix(path, "/lists/"+token.ListID+"/") {
+		return fmt.Errorf("token is restricted to list %s", token.ListID)
+	}
+	if token.HasScope(storage.TokenScopeAdmin) {
+		return nil
+	}
+	if token.HasScope(storage.TokenScopeRead) && (r.Method == http.MethodGet || r.Method == http.MethodHead) && !underPath(path, "/auth") && !underPath(path, "/sync") {
+		return nil
+	}
+	return fmt.Errorf("token scopes do not allow %s %s", r.Method, path)
+}
+
+// underPath reports whether path is root or lies below it.
+func underPath(path, root string) bool {
+	return path == root || strings.HasPrefix(path, root+"/")
+}
+
+// WithAPITokens serves requests that carry an API token with next, acting as
+// the token's user once tokenAllows accepts the request, and all other
+// requests with sessions (next behind the session middleware). Token requests
+// bypass the session and CSRF checks since browsers never attach bearer
+// tokens or signatures on their own.
+func (s *Server) WithAPITokens(next http.Handler, sessions http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := bearerSecret(r); !ok && !hasSignature(r) {
+			sessions.ServeHTTP(w, r)
+			return
+		}
+		token, ok := s.apiToken(w, r)
+		if !ok {
+			return
+		}
+		if token.MemberID != "" {
+			memberCtx, ok := s.withHouseholdMember(w, r, token)
+			if !ok {
+				log.Printf("API token denied token=%s member=%s method=%s path=%s", token.ID, token.MemberID, r.Method, r.URL.Path)
+				return
+			}
+			r = r.WithContext(memberCtx)
+		} else if err := tokenAllows(token, r); err != nil {
+			log.Printf("API token denied token=%s method=%s path=%s", token.ID, r.Method, r.URL.Path)
+			writeJSON(w, http.StatusForbidden, errorResponse{Error: err.Error()})
+			return
+		}
+		ctx := auth.ContextWithUserID(r.Context(), token.UserID)
+		ctx = context.WithValue(ctx, apiTokenContextKey{}, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
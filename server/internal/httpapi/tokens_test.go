@@ -0,0 +1,139 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestAPITokensAreEnforcedByMiddleware(t *testing.T) {
+	store := newTestStore(t)
+	server := NewServer(store)
+	mux := http.NewServeMux()
+	server.RegisterRoutes(mux)
+	sessions := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := server.WithAPITokens(mux, sessions)
+
+	createList := func(title string) string {
+		t.Helper()
+		resp := doRequest(t, mux, http.MethodPost, "/lists", []byte(`{"title":"`+title+`"}`))
+		var created struct {
+			ListID string `json:"listId"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+			t.Fatalf("decode create list: %v", err)
+		}
+		return created.ListID
+	}
+	groceries, chores := createList("Groceries"), createList("Chores")
+	createToken := func(body string) (string, string) {
+		t.Helper()
+		resp := doRequest(t, mux, http.MethodPost, "/auth/tokens", []byte(body))
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("create token %s: got %d %s", body, resp.Code, resp.Body.String())
+		}
+		var created struct {
+			Token struct {
+				ID string `json:"id"`
+			} `json:"token"`
+			Secret string `json:"secret"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+			t.Fatalf("decode token: %v", err)
+		}
+		return created.Token.ID, created.Secret
+	}
+	call := func(secret string, method string, path string, body string) int {
+		t.Helper()
+		req := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		if secret != "" {
+			req.Header.Set("Authorization", "Bearer "+secret)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	if resp := doRequest(t, mux, http.MethodPost, "/auth/tokens", []byte(`{"name":"Old","scopes":["read"],"expiresAt":1}`)); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected a past expiry to be rejected, got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodPost, "/auth/tokens", []byte(`{"name":"Nope","scopes":["read"],"listId":"missing"}`)); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected an unknown list to be rejected, got %d", resp.Code)
+	}
+
+	_, read := createToken(`{"name":"Dashboard","scopes":["read"]}`)
+	_, list := createToken(`{"name":"Fridge display","scopes":["read"],"listId":"` + groceries + `"}`)
+	_, quick := createToken(`{"name":"Shortcut","scopes":["quick-add"]}`)
+	_, choresQuick := createToken(`{"name":"Chores shortcut","scopes":["quick-add"],"listId":"` + chores + `"}`)
+	adminID, admin := createToken(`{"name":"Backup script","scopes":["admin"],"expiresAt":` + strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10) + `}`)
+
+	cases := []struct {
+		name   string
+		secret string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"no token uses sessions", "", http.MethodGet, "/lists", "", http.StatusTeapot},
+		{"unknown token", "lat_unknown", http.MethodGet, "/lists", "", http.StatusUnauthorized},
+		{"read may GET", read, http.MethodGet, "/lists", "", http.StatusOK},
+		{"read may not POST", read, http.MethodPost, "/lists", `{"title":"Work"}`, http.StatusForbidden},
+		{"read may not manage tokens", read, http.MethodGet, "/auth/tokens", "", http.StatusForbidden},
+		{"read may not pull", read, http.MethodGet, "/sync/pull?clientId=dashboard", "", http.StatusForbidden},
+		{"read may not stream", read, http.MethodGet, "/sync/stream?clientId=dashboard", "", http.StatusForbidden},
+		{"list token reads its list", list, http.MethodGet, "/lists/" + groceries + "/items", "", http.StatusOK},
+		{"list token cannot see other lists", list, http.MethodGet, "/lists/" + chores + "/items", "", http.StatusForbidden},
+		{"list token cannot enumerate lists", list, http.MethodGet, "/lists", "", http.StatusForbidden},
+		{"quick-add token cannot read", quick, http.MethodGet, "/lists", "", http.StatusForbidden},
+		{"quick-add token adds", quick, http.MethodPost, "/quick-add", `{"text":"milk #groceries"}`, http.StatusCreated},
+		{"list quick-add token adds to its list", choresQuick, http.MethodPost, "/quick-add", `{"text":"mop #groceries"}`, http.StatusCreated},
+		{"list quick-add token cannot pick another list", choresQuick, http.MethodPost, "/quick-add", `{"text":"mop","listId":"` + groceries + `"}`, http.StatusForbidden},
+		{"admin may write", admin, http.MethodPost, "/lists", `{"title":"Work"}`, http.StatusCreated},
+		{"admin may manage tokens", admin, http.MethodGet, "/auth/tokens", "", http.StatusOK},
+		{"admin may not use operator routes", admin, http.MethodGet, "/admin/clients?userId=user-1", "", http.StatusForbidden},
+		{"admin may not use the operator root", admin, http.MethodGet, "/admin", "", http.StatusForbidden},
+		{"admin may not use the OAuth root", admin, http.MethodGet, "/oauth", "", http.StatusForbidden},
+		{"admin may not use the login root", admin, http.MethodGet, "/auth", "", http.StatusForbidden},
+		{"admin may sync", admin, http.MethodGet, "/sync/bootstrap", "", http.StatusOK},
+	}
+	for _, tc := range cases {
+		if got := call(tc.secret, tc.method, tc.path, tc.body); got != tc.want {
+			t.Errorf("%s: %s %s got %d, want %d", tc.name, tc.method, tc.path, got, tc.want)
+		}
+	}
+
+	tokens, err := store.ListAPITokens(context.Background(), "user-1")
+	if err != nil {
+		t.Fatalf("list tokens: %v", err)
+	}
+	for _, token := range tokens {
+		if token.LastUsedAt == 0 {
+			t.Errorf("expected last use of %s to be recorded", token.Name)
+		}
+	}
+
+	expired := storage.APIToken{ID: "token-expired", Name: "Expired", Scopes: []string{storage.TokenScopeAdmin}, CreatedAt: 1, ExpiresAt: 2}
+	if err := store.CreateAPIToken(context.Background(), "user-1", expired, sha256Hex([]byte("lat_expired")), ""); err != nil {
+		t.Fatalf("create expired token: %v", err)
+	}
+	if got := call("lat_expired", http.MethodGet, "/lists", ""); got != http.StatusUnauthorized {
+		t.Fatalf("expected expired token to be rejected, got %d", got)
+	}
+	if resp := doRequest(t, mux, http.MethodDelete, "/auth/tokens/"+adminID, nil); resp.Code != http.StatusNoContent {
+		t.Fatalf("revoke token status: got %d", resp.Code)
+	}
+	if got := call(admin, http.MethodGet, "/lists", ""); got != http.StatusUnauthorized {
+		t.Fatalf("expected revoked token to be rejected, got %d", got)
+	}
+}
@@ -0,0 +1,115 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"a4-tasklists/server/internal/auth"
+	"a4-tasklists/server/internal/storage"
+	"a4-tasklists/server/internal/traffic"
+)
+
+// recordTraffic records push and pull exchanges when a traffic recorder is
+// configured. The handler runs unchanged; the request body and the response
+// are copied on the side and decoded afterwards, so recording never changes
+// what the client sees.
+func (s *Server) recordTraffic(kind string, next http.HandlerFunc) http.HandlerFunc {
+	if s.traffic == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		received := time.Now()
+		var body []byte
+		if kind == traffic.KindPush && r.Body != nil {
+			var err error
+			if body, err = io.ReadAll(r.Body); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		capture := &capturingWriter{ResponseWriter: w, status: http.StatusOK}
+		next(capture, r)
+
+		userID, ok := auth.UserIDFromContext(r.Context())
+		if !ok {
+			return
+		}
+		exchange := traffic.Exchange{Kind: kind, User: userID, Status: capture.status}
+		if kind == traffic.KindPush {
+			var payload struct {
+				ClientID             string       `json:"clientId"`
+				DatasetGenerationKey string       `json:"datasetGenerationKey"`
+				Ops                  []storage.Op `json:"ops"`
+			}
+			decoded := r.Clone(r.Context())
+			decoded.Body = io.NopCloser(bytes.NewReader(body))
+			// Undecodable bodies are still recorded, without content, since
+			// the rejection is part of the workload.
+			_ = decodeBody(decoded, &payload)
+			exchange.Client, exchange.DatasetGenerationKey, exchange.Ops = payload.ClientID, payload.DatasetGenerationKey, payload.Ops
+		} else {
+			query := r.URL.Query()
+			exchange.Client = query.Get("clientId")
+			exchange.DatasetGenerationKey = query.Get("datasetGenerationKey")
+			exchange.Since, _ = strconv.ParseInt(query.Get("since"), 10, 64)
+			exchange.OmitOwn = query.Get("omitOwn") == "true"
+			exchange.Mode = query.Get("mode")
+		}
+		var response struct {
+			DatasetGenerationKey string            `json:"datasetGenerationKey"`
+			ServerSeq            int64             `json:"serverSeq"`
+			Ops                  []json.RawMessage `json:"ops"`
+		}
+		if err := decodeResponse(capture.Header().Get("Content-Type"), capture.body.Bytes(), &response); err == nil {
+			exchange.ResponseGenerationKey = response.DatasetGenerationKey
+			exchange.ServerSeq = response.ServerSeq
+			exchange.PulledOps = len(response.Ops)
+		}
+		if err := s.traffic.Record(received, exchange); err != nil {
+			log.Printf("traffic record error kind=%s: %v", kind, err)
+		}
+	}
+}
+
+// decodeResponse decodes a JSON or CBOR response body.
+func decodeResponse(contentType string, body []byte, target any) error {
+	if mediaType(contentType) == contentTypeCBOR {
+		var value any
+		if err := cborDecMode.Unmarshal(body, &value); err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		body = encoded
+	}
+	return json.Unmarshal(body, target)
+}
+
+// capturingWriter keeps a copy of the status and body written through it.
+type capturingWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *capturingWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *capturingWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
@@ -0,0 +1,65 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+	"a4-tasklists/server/internal/traffic"
+)
+
+func TestRecordedTrafficReplaysAgainstAFreshServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traffic.jsonl")
+	recorder, err := traffic.Create(path)
+	if err != nil {
+		t.Fatalf("create recorder: %v", err)
+	}
+	mux := http.NewServeMux()
+	NewServerWithConfig(newTestStore(t), Config{Traffic: recorder}).RegisterRoutes(mux)
+	bootstrap := fetchBootstrap(t, mux)
+	body, _ := json.Marshal(map[string]any{
+		"clientId":             "client-1",
+		"datasetGenerationKey": bootstrap.DatasetGenerationKey,
+		"ops": []map[string]any{
+			{"scope": "list", "resourceId": "list-1", "actor": "actor-1", "clock": 1, "payload": map[string]any{"type": "insert", "itemId": "item-1", "data": map[string]any{"text": "milk"}}},
+		},
+	})
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/push", body); resp.Code != http.StatusOK {
+		t.Fatalf("push status: got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodGet, "/sync/pull?since=0&clientId=client-2&datasetGenerationKey="+url.QueryEscape(bootstrap.DatasetGenerationKey), nil); resp.Code != http.StatusOK {
+		t.Fatalf("pull status: got %d", resp.Code)
+	}
+	if resp := doRequest(t, mux, http.MethodGet, "/sync/pull?since=0&clientId=client-3&datasetGenerationKey=stale", nil); resp.Code != http.StatusConflict {
+		t.Fatalf("stale pull status: got %d", resp.Code)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("close recorder: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open recording: %v", err)
+	}
+	defer func() { _ = file.Close() }()
+	replayMux := http.NewServeMux()
+	NewServer(storage.NewMemoryStore()).RegisterRoutes(replayMux)
+	report, err := traffic.Replay(context.Background(), replayMux, traffic.Read(file), traffic.ReplayOptions{MaxExamples: 5})
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if report.Exchanges != 3 || report.Kinds[traffic.KindPush] != 1 || report.Kinds[traffic.KindPull] != 2 {
+		t.Fatalf("unexpected exchanges: %+v", report)
+	}
+	if report.Mismatches != 0 {
+		t.Fatalf("replay should match the recording: %+v", report.Examples)
+	}
+	if report.RecordedPulledOps != 1 || report.ReplayedPulledOps != 1 {
+		t.Fatalf("pulled ops: recorded %d, replayed %d", report.RecordedPulledOps, report.ReplayedPulledOps)
+	}
+}
@@ -0,0 +1,76 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestUsageReportsActiveGeneration(t *testing.T) {
+	mux := newTestMux(t)
+	bootstrap := fetchBootstrap(t, mux)
+	body, _ := json.Marshal(map[string]any{
+		"clientId":             "client-1",
+		"datasetGenerationKey": bootstrap.DatasetGenerationKey,
+		"ops": []map[string]any{
+			{"scope": "list", "resourceId": "list-1", "actor": "actor-1", "clock": 1, "payload": map[string]any{"type": "insert", "itemId": "item-1"}},
+		},
+	})
+	if resp := doRequest(t, mux, http.MethodPost, "/sync/push", body); resp.Code != http.StatusOK {
+		t.Fatalf("push status: got %d", resp.Code)
+	}
+
+	resp := doRequest(t, mux, http.MethodGet, "/usage", nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("usage status: got %d", resp.Code)
+	}
+	var usage storage.Usage
+	if err := json.NewDecoder(resp.Body).Decode(&usage); err != nil {
+		t.Fatalf("decode usage: %v", err)
+	}
+	if usage.OpCount != 1 || usage.OpBytes == 0 || usage.SnapshotBytes != int64(len(bootstrap.Snapshot)) || usage.ClientCount != 1 {
+		t.Fatalf("unexpected usage: %+v", usage)
+	}
+
+	if resp := doRequest(t, mux, http.MethodPost, "/usage", nil); resp.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("post usage status: got %d", resp.Code)
+	}
+}
+
+// failingUsageStore fails GetUsage with err.
+type failingUsageStore struct {
+	storage.Store
+	err error
+}
+
+func (s failingUsageStore) GetUsage(context.Context, string) (storage.Usage, error) {
+	return storage.Usage{}, s.err
+}
+
+func TestTransientStorageErrorsReturn503(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		err        error
+		status     int
+		retryAfter string
+	}{
+		{"transient", storage.ErrUnavailable, http.StatusServiceUnavailable, "1"},
+		{"permanent", errors.New("disk on fire"), http.StatusInternalServerError, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			NewServer(failingUsageStore{Store: newTestStore(t), err: tc.err}).RegisterRoutes(mux)
+			resp := doRequest(t, mux, http.MethodGet, "/usage", nil)
+			if resp.Code != tc.status {
+				t.Fatalf("status: got %d, want %d", resp.Code, tc.status)
+			}
+			if got := resp.Header().Get("Retry-After"); got != tc.retryAfter {
+				t.Fatalf("Retry-After: got %q, want %q", got, tc.retryAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Sync protocol versioning lets the server evolve /sync/* payloads without
+// silently corrupting data held by clients built against an older contract.
+// Clients announce the version they speak in SyncProtocolVersionHeader; the
+// server accepts any version in [MinSyncProtocolVersion, MaxSyncProtocolVersion]
+// and answers 426 Upgrade Required otherwise. Requests without the header are
+// treated as MinSyncProtocolVersion so clients predating it keep working.
+//
+// Version 2 leaves archived lists out of sync responses unless the client asks
+// for them; version 1 clients still receive everything.
+const (
+	SyncProtocolVersionHeader = "X-Sync-Protocol-Version"
+	MinSyncProtocolVersion    = 1
+	MaxSyncProtocolVersion    = 2
+)
+
+// archivedListsProtocolVersion is the first version whose sync responses hide
+// archived lists by default.
+const archivedListsProtocolVersion = 2
+
+// syncProtocolUpgrade is the protocol token advertised in the Upgrade header of
+// 426 responses.
+const syncProtocolUpgrade = "tasklist-sync"
+
+// requireSyncProtocolVersion rejects requests announcing a protocol version
+// outside the supported range and stamps the server's version on responses.
+// Every announced version is counted for the fleet report, so operators see
+// who still speaks a version before they stop supporting it.
+func (s *Server) requireSyncProtocolVersion(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(SyncProtocolVersionHeader, strconv.Itoa(MaxSyncProtocolVersion))
+		header := strings.TrimSpace(r.Header.Get(SyncProtocolVersionHeader))
+		if header == "" {
+			s.fleet.Request(MinSyncProtocolVersion)
+			next(w, r)
+			return
+		}
+		version, err := strconv.Atoi(header)
+		if err == nil {
+			s.fleet.Request(version)
+		}
+		if err != nil || version < MinSyncProtocolVersion || version > MaxSyncProtocolVersion {
+			writeUpgradeRequired(w, header)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// syncProtocolVersion returns the version a request announced. Requests
+// that passed requireSyncProtocolVersion always have a supported one.
+func syncProtocolVersion(r *http.Request) int {
+	version, err := strconv.Atoi(strings.TrimSpace(r.Header.Get(SyncProtocolVersionHeader)))
+	if err != nil {
+		return MinSyncProtocolVersion
+	}
+	return version
+}
+
+func writeUpgradeRequired(w http.ResponseWriter, requested string) {
+	instructions := "Reload the app to update it to a supported sync protocol version."
+	if version, err := strconv.Atoi(requested); err == nil && version > MaxSyncProtocolVersion {
+		instructions = "This server is older than the app. Ask the operator to upgrade the server, or use an app version that supports the listed protocol versions."
+	}
+	w.Header().Set("Upgrade", fmt.Sprintf("%s/%d", syncProtocolUpgrade, MaxSyncProtocolVersion))
+	w.Header().Set("Connection", "Upgrade")
+	writeJSON(w, http.StatusUpgradeRequired, jsonResponse{
+		"error":              fmt.Sprintf("unsupported sync protocol version %q", requested),
+		"minProtocolVersion": MinSyncProtocolVersion,
+		"maxProtocolVersion": MaxSyncProtocolVersion,
+		"upgrade":            instructions,
+	})
+}
@@ -0,0 +1,56 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestSyncProtocolVersionHeader(t *testing.T) {
+	mux := newTestMux(t)
+	for _, tc := range []struct {
+		name    string
+		version string
+		want    int
+	}{
+		{"missing", "", http.StatusOK},
+		{"supported", strconv.Itoa(MaxSyncProtocolVersion), http.StatusOK},
+		{"too old", strconv.Itoa(MinSyncProtocolVersion - 1), http.StatusUpgradeRequired},
+		{"too new", strconv.Itoa(MaxSyncProtocolVersion + 1), http.StatusUpgradeRequired},
+		{"garbage", "v2", http.StatusUpgradeRequired},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tc.version != "" {
+				headers[SyncProtocolVersionHeader] = tc.version
+			}
+			resp := doRequestWithHeaders(t, mux, http.MethodGet, "/sync/bootstrap", nil, headers)
+			if resp.Code != tc.want {
+				t.Fatalf("status: got %d, want %d", resp.Code, tc.want)
+			}
+			if got := resp.Header().Get(SyncProtocolVersionHeader); got != strconv.Itoa(MaxSyncProtocolVersion) {
+				t.Fatalf("version header: got %q", got)
+			}
+			var payload struct {
+				ProtocolVersion    int    `json:"protocolVersion"`
+				MaxProtocolVersion int    `json:"maxProtocolVersion"`
+				Upgrade            string `json:"upgrade"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if tc.want == http.StatusOK && payload.ProtocolVersion != MaxSyncProtocolVersion {
+				t.Fatalf("bootstrap protocolVersion: got %d", payload.ProtocolVersion)
+			}
+			if tc.want == http.StatusUpgradeRequired {
+				if payload.MaxProtocolVersion != MaxSyncProtocolVersion || payload.Upgrade == "" {
+					t.Fatalf("unexpected upgrade payload: %+v", payload)
+				}
+				if resp.Header().Get("Upgrade") == "" {
+					t.Fatalf("426 must carry an Upgrade header")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+// Package i18n translates server-generated text: emails, rendered exports and
+// API error messages.
+//
+// Messages are keyed by their English text, gettext style, so the code keeps
+// readable strings and English needs no catalog. Other languages are JSON
+// objects mapping English text to its translation, one file per language:
+//
+//	{"Untitled list": "Unbenannte Liste", "Your daily list digest: %d added, %d completed": "…"}
+//
+// German ships with the server; operators add languages or adjust wording with
+// files of their own (see LoadDir). A message without a translation stays in
+// English.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// English is the source language of all messages.
+const English = "en"
+
+//go:embed catalogs/*.json
+var builtin embed.FS
+
+// tagPattern matches the BCP 47 language tags users can pick, e.g. "de" or
+// "pt-BR".
+var tagPattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// Catalog holds the translations of every language but English, keyed by
+// lower-case language tag. The zero value and nil translate nothing.
+type Catalog struct {
+	languages map[string]map[string]string
+}
+
+// Builtin returns the catalogs that ship with the server.
+func Builtin() *Catalog {
+	catalog := &Catalog{languages: map[string]map[string]string{}}
+	if err := catalog.load(builtin, "catalogs"); err != nil {
+		panic(fmt.Sprintf("i18n: builtin catalogs: %v", err))
+	}
+	return catalog
+}
+
+// LoadDir adds the catalogs in dir, one <tag>.json per language (de.json,
+// pt-BR.json). Their messages take precedence over the catalog's, so a
+// deployment can reword single messages without copying a whole language.
+func (c *Catalog) LoadDir(dir string) error {
+	return c.load(os.DirFS(dir), ".")
+}
+
+func (c *Catalog) load(fsys fs.FS, dir string) error {
+	names, err := fs.Glob(fsys, filepath.ToSlash(filepath.Join(dir, "*.json")))
+	if err != nil {
+		return err
+	}
+	if c.languages == nil {
+		c.languages = map[string]map[string]string{}
+	}
+	for _, name := range names {
+		tag := strings.TrimSuffix(filepath.Base(name), ".json")
+		if !ValidTag(tag) {
+			return fmt.Errorf("catalog %s: %q is not a language tag", name, tag)
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return fmt.Errorf("catalog %s: %w", name, err)
+		}
+		for key, translation := range messages {
+			if verbs(key) != verbs(translation) {
+				return fmt.Errorf("catalog %s: %q does not keep the placeholders of %q", name, translation, key)
+			}
+		}
+		tag = strings.ToLower(tag)
+		if c.languages[tag] == nil {
+			c.languages[tag] = map[string]string{}
+		}
+		maps.Copy(c.languages[tag], messages)
+	}
+	return nil
+}
+
+// verbs returns the formatting verbs of a message in order, so a translation
+// can be checked to take the same arguments.
+func verbs(message string) string {
+	var out []string
+	for i := 0; i < len(message)-1; i++ {
+		if message[i] != '%' {
+			continue
+		}
+		i++
+		if message[i] != '%' {
+			out = append(out, string(message[i]))
+		}
+	}
+	return strings.Join(out, "")
+}
+
+// Languages returns the tags with a catalog, English first.
+func (c *Catalog) Languages() []string {
+	var tags []string
+	if c != nil {
+		tags = slices.Sorted(maps.Keys(c.languages))
+	}
+	return append([]string{English}, tags...)
+}
+
+// Match returns the best language for tags in order of preference, falling
+// back from a region ("de-AT") to its language ("de"), and to English when
+// none has a catalog.
+func (c *Catalog) Match(tags ...string) string {
+	if c == nil {
+		return English
+	}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		for tag != "" {
+			if tag == English {
+				return English
+			}
+			if _, ok := c.languages[tag]; ok {
+				return tag
+			}
+			cut := strings.LastIndexByte(tag, '-')
+			if cut < 0 {
+				break
+			}
+			tag = tag[:cut]
+		}
+	}
+	return English
+}
+
+// Printer translates messages into one language.
+func (c *Catalog) Printer(tags ...string) Printer {
+	language := c.Match(tags...)
+	if language == English {
+		return Printer{language: English}
+	}
+	return Printer{language: language, messages: c.languages[language]}
+}
+
+// Printer translates into one language. The zero value prints English.
+type Printer struct {
+	language string
+	messages map[string]string
+}
+
+// Language returns the printer's language tag.
+func (p Printer) Language() string {
+	if p.language == "" {
+		return English
+	}
+	return p.language
+}
+
+// Lookup returns the translation of message and whether there is one.
+func (p Printer) Lookup(message string) (string, bool) {
+	translation, ok := p.messages[message]
+	return translation, ok
+}
+
+// Text returns the translation of message, or message itself.
+func (p Printer) Text(message string) string {
+	if translation, ok := p.messages[message]; ok {
+		return translation
+	}
+	return message
+}
+
+// Sprintf translates format and formats it like fmt.Sprintf.
+func (p Printer) Sprintf(format string, args ...any) string {
+	if translation, ok := p.messages[format]; ok {
+		format = translation
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// ValidTag reports whether tag looks like a BCP 47 language tag.
+func ValidTag(tag string) bool {
+	return tagPattern.MatchString(tag)
+}
+
+// AcceptLanguage returns the tags of an Accept-Language header, most
+// preferred first. Tags refused with q=0 and the wildcard are left out.
+func AcceptLanguage(header string) []string {
+	type weighted struct {
+		tag     string
+		quality float64
+	}
+	var entries []weighted
+	for part := range strings.SplitSeq(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if tag == "" || tag == "*" || quality <= 0 {
+			continue
+		}
+		entries = append(entries, weighted{tag: tag, quality: quality})
+	}
+	slices.SortStableFunc(entries, func(a, b weighted) int {
+		switch {
+		case a.quality > b.quality:
+			return -1
+		case a.quality < b.quality:
+			return 1
+		default:
+			return 0
+		}
+	})
+	tags := make([]string, len(entries))
+	for i, entry := range entries {
+		tags[i] = entry.tag
+	}
+	return tags
+}
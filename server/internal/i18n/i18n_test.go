@@ -0,0 +1,82 @@
+package i18n
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestMatchFallsBackFromRegionToLanguage(t *testing.T) {
+	catalog := Builtin()
+	for _, tc := range []struct {
+		tags []string
+		want string
+	}{
+		{[]string{"de"}, "de"},
+		{[]string{"de-AT"}, "de"},
+		{[]string{"DE-at"}, "de"},
+		{[]string{"fr", "de"}, "de"},
+		{[]string{"en-GB", "de"}, English},
+		{[]string{"fr"}, English},
+		{nil, English},
+	} {
+		if got := catalog.Match(tc.tags...); got != tc.want {
+			t.Fatalf("Match(%v) = %q, want %q", tc.tags, got, tc.want)
+		}
+	}
+	var none *Catalog
+	if got := none.Printer("de").Text("Untitled list"); got != "Untitled list" {
+		t.Fatalf("expected a nil catalog to print English, got %q", got)
+	}
+}
+
+func TestAcceptLanguageOrdersByQuality(t *testing.T) {
+	got := AcceptLanguage("fr;q=0.5, de-CH, *;q=0.1, en;q=0.8, it;q=0")
+	if want := []string{"de-CH", "en", "fr"}; !slices.Equal(got, want) {
+		t.Fatalf("AcceptLanguage = %v, want %v", got, want)
+	}
+}
+
+func TestPrinterTranslatesAndFormats(t *testing.T) {
+	p := Builtin().Printer("de")
+	if got := p.Sprintf("Your daily list digest: %d added, %d completed", 2, 1); got != "Deine tägliche Listenübersicht: 2 hinzugefügt, 1 erledigt" {
+		t.Fatalf("unexpected translation %q", got)
+	}
+	if got := p.Text("not in the catalog"); got != "not in the catalog" {
+		t.Fatalf("expected a missing translation to stay English, got %q", got)
+	}
+}
+
+func TestLoadDirOverridesAndChecksPlaceholders(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("write catalog: %v", err)
+		}
+	}
+	write("de.json", `{"Untitled list": "Liste ohne Namen"}`)
+	write("pt-BR.json", `{"Untitled list": "Lista sem título"}`)
+	catalog := Builtin()
+	if err := catalog.LoadDir(dir); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := catalog.Printer("de").Text("Untitled list"); got != "Liste ohne Namen" {
+		t.Fatalf("expected the override, got %q", got)
+	}
+	if got := catalog.Printer("de").Text("method not allowed"); got != "Methode nicht erlaubt" {
+		t.Fatalf("expected other builtin messages to stay, got %q", got)
+	}
+	if got := catalog.Printer("pt-br").Text("Untitled list"); got != "Lista sem título" {
+		t.Fatalf("expected the added language, got %q", got)
+	}
+	if got := catalog.Languages(); !slices.Equal(got, []string{"en", "de", "pt-br"}) {
+		t.Fatalf("unexpected languages %v", got)
+	}
+
+	write("fr.json", `{"Your daily list digest: %d added, %d completed": "Votre résumé : %d ajoutés"}`)
+	if err := Builtin().LoadDir(dir); err == nil {
+		t.Fatal("expected a translation dropping a placeholder to be rejected")
+	}
+}
@@ -0,0 +1,232 @@
+// Package importer reads lists exported by other apps, so users can move
+// their notes and reminders over without retyping them.
+//
+// Supported are Google Keep (a Takeout zip, or single notes as .json or
+// .html) and Apple Reminders (iCalendar files with VTODO entries, as written
+// by iCloud or export shortcuts, or a zip of them). Parse turns an export into
+// lists of items; the caller generates ops from them.
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+// Format names an export format.
+type Format string
+
+const (
+	// FormatKeep is Google Keep: Takeout notes as JSON or HTML.
+	FormatKeep Format = "keep"
+	// FormatReminders is Apple Reminders: iCalendar VTODO entries.
+	FormatReminders Format = "reminders"
+)
+
+// maxUnpackedBytes caps what Parse reads out of a zip, so a small archive
+// cannot expand without bound.
+const maxUnpackedBytes = 64 << 20
+
+var (
+	// ErrUnknownFormat is returned for data Parse does not recognize.
+	ErrUnknownFormat = errors.New("unrecognized export format")
+	// ErrTooLarge is returned for zips that unpack beyond maxUnpackedBytes.
+	ErrTooLarge = errors.New("export unpacks to more than 64 MiB")
+)
+
+// List is an imported list.
+type List struct {
+	Title string `json:"title"`
+	Items []Item `json:"items"`
+}
+
+// Item is an imported item. Tags are normalized.
+type Item struct {
+	Text string   `json:"text"`
+	Note string   `json:"note,omitempty"`
+	Done bool     `json:"done"`
+	Tags []string `json:"tags,omitempty"`
+}
+
+// ParseFormat validates a format name; empty means "detect".
+func ParseFormat(s string) (Format, error) {
+	switch format := Format(strings.ToLower(strings.TrimSpace(s))); format {
+	case "", FormatKeep, FormatReminders:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown import format %q (want keep or reminders)", s)
+	}
+}
+
+// Parse reads an export in format, or detects the format when it is empty.
+// It returns the lists in export order and the format it read.
+func Parse(format Format, data []byte) ([]List, Format, error) {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return parseZip(format, data)
+	}
+	if format == "" {
+		format = detect(data)
+	}
+	var (
+		lists []List
+		err   error
+	)
+	switch format {
+	case FormatKeep:
+		var list List
+		var ok bool
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+			list, ok, err = parseKeepJSON(data)
+		} else {
+			list, ok, err = parseKeepHTML(data)
+		}
+		if ok {
+			lists = append(lists, list)
+		}
+	case FormatReminders:
+		lists, err = parseReminders(data)
+	default:
+		return nil, "", ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return lists, format, nil
+}
+
+// detect guesses the format of a single file.
+func detect(data []byte) Format {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("BEGIN:VCALENDAR")):
+		return FormatReminders
+	case bytes.HasPrefix(trimmed, []byte("{")), bytes.HasPrefix(trimmed, []byte("<")):
+		return FormatKeep
+	default:
+		return ""
+	}
+}
+
+// parseZip reads the notes and calendars in a zip. Takeout writes each Keep
+// note as both .json and .html; the JSON is used when both are there. Other
+// files, such as attachments, are skipped.
+func parseZip(format Format, data []byte) ([]List, Format, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, "", fmt.Errorf("read zip: %w", err)
+	}
+	var jsonNotes []string
+	for _, file := range archive.File {
+		if strings.EqualFold(path.Ext(file.Name), ".json") {
+			jsonNotes = append(jsonNotes, strings.TrimSuffix(file.Name, path.Ext(file.Name)))
+		}
+	}
+	var (
+		lists  []List
+		found  Format
+		budget int64 = maxUnpackedBytes
+	)
+	for _, file := range archive.File {
+		ext := strings.ToLower(path.Ext(file.Name))
+		var fileFormat Format
+		switch ext {
+		case ".json", ".html":
+			fileFormat = FormatKeep
+		case ".ics":
+			fileFormat = FormatReminders
+		default:
+			continue
+		}
+		if (format != "" && fileFormat != format) || (ext == ".html" && slices.Contains(jsonNotes, strings.TrimSuffix(file.Name, path.Ext(file.Name)))) {
+			continue
+		}
+		content, err := readZipFile(file, &budget)
+		if err != nil {
+			return nil, "", err
+		}
+		var parsed []List
+		switch ext {
+		case ".json":
+			var list List
+			var ok bool
+			// Takeout also holds JSON that is not a note, such as
+			// Labels.json; anything that does not parse as one is skipped.
+			if list, ok, err = parseKeepJSON(content); err != nil {
+				continue
+			}
+			if ok {
+				parsed = []List{list}
+			}
+		case ".html":
+			var list List
+			var ok bool
+			if list, ok, err = parseKeepHTML(content); err != nil {
+				return nil, "", fmt.Errorf("%s: %w", file.Name, err)
+			}
+			if ok {
+				parsed = []List{list}
+			}
+		case ".ics":
+			if parsed, err = parseReminders(content); err != nil {
+				return nil, "", fmt.Errorf("%s: %w", file.Name, err)
+			}
+		}
+		if found == "" {
+			found = fileFormat
+		}
+		lists = append(lists, parsed...)
+	}
+	if found == "" {
+		return nil, "", ErrUnknownFormat
+	}
+	return lists, found, nil
+}
+
+func readZipFile(file *zip.File, budget *int64) ([]byte, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file.Name, err)
+	}
+	defer func() { _ = reader.Close() }()
+	content, err := io.ReadAll(io.LimitReader(reader, *budget+1))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file.Name, err)
+	}
+	if *budget -= int64(len(content)); *budget < 0 {
+		return nil, ErrTooLarge
+	}
+	return content, nil
+}
+
+// normalizeTags normalizes labels into tags, dropping empty ones and
+// duplicates.
+func normalizeTags(labels []string) []string {
+	var tags []string
+	for _, label := range labels {
+		// Tags are single words; labels such as "To do" become "to-do".
+		tag := strings.Join(strings.Fields(storage.NormalizeTag(label)), "-")
+		if tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// titleFrom derives a title for an untitled note from its first item.
+func titleFrom(items []Item) string {
+	if len(items) == 0 {
+		return ""
+	}
+	title := []rune(items[0].Text)
+	if len(title) > 60 {
+		return strings.TrimSpace(string(title[:60])) + "…"
+	}
+	return string(title)
+}
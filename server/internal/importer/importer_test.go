@@ -0,0 +1,192 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const keepChecklistJSON = `{
+  "color": "DEFAULT",
+  "isTrashed": false,
+  "isPinned": false,
+  "isArchived": false,
+  "listContent": [
+    {"textHtml": "Milk", "text": "Milk", "isChecked": false},
+    {"textHtml": "Eggs", "text": "Eggs", "isChecked": true},
+    {"text": " ", "isChecked": false}
+  ],
+  "title": "Groceries",
+  "labels": [{"name": "Food"}, {"name": "To do"}],
+  "userEditedTimestampUsec": 1700000000000000,
+  "createdTimestampUsec": 1690000000000000
+}`
+
+const keepTextHTML = `<?xml version="1.0" encoding="UTF-8" ?>
+<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Packing</title>
+<style type="text/css">.note .title { font-size: 1.5em; }</style></head>
+<body><div class="note DEFAULT"><div class="heading"><div class="meta-icons"></div>
+Oct 1, 2023, 10:00:00 AM</div>
+<div class="title">Packing</div>
+<div class="content">- Passport<br>[x] Charger &amp; cable<br><br>Sunscreen</div>
+<div class="chips"><span class="chip label"><span class="label-name">Travel</span></span></div>
+</div></body></html>`
+
+const keepChecklistHTML = `<html><body><div class="note DEFAULT">
+<div class="title"></div>
+<div class="content"><ul class="list"><li class="listitem"><span class="bullet">&#9744;</span>
+<span class="text">Call plumber</span>
+</li><li class="listitem checked"><span class="bullet">&#9745;</span>
+<span class="text">Pay rent</span>
+</li></ul></div></div></body></html>`
+
+const remindersICS = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Apple Inc.//Reminders//EN\r\n" +
+	"X-WR-CALNAME:Errands\r\n" +
+	"BEGIN:VTODO\r\n" +
+	"UID:1\r\n" +
+	"SUMMARY:Pick up dry cleaning\\, shirts\r\n" +
+	"DESCRIPTION:Ticket 42\\nBack counter\r\n" +
+	"CATEGORIES:Town,Weekend\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"ACTION:DISPLAY\r\n" +
+	"DESCRIPTION:Reminder\r\n" +
+	"END:VALARM\r\n" +
+	"END:VTODO\r\n" +
+	"BEGIN:VTODO\r\n" +
+	"UID:2\r\n" +
+	"SUMMARY:Return libr\r\n" +
+	" ary books\r\n" +
+	"STATUS:COMPLETED\r\n" +
+	"END:VTODO\r\n" +
+	"BEGIN:VTODO\r\n" +
+	"UID:3\r\n" +
+	"SUMMARY:Never mind\r\n" +
+	"STATUS:CANCELLED\r\n" +
+	"END:VTODO\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseKeepJSONChecklist(t *testing.T) {
+	lists, format, err := Parse("", []byte(keepChecklistJSON))
+	if err != nil || format != FormatKeep {
+		t.Fatalf("parse: %v %s", err, format)
+	}
+	tags := []string{"food", "to-do"}
+	want := []List{{Title: "Groceries", Items: []Item{{Text: "Milk", Tags: tags}, {Text: "Eggs", Done: true, Tags: tags}}}}
+	if !reflect.DeepEqual(lists, want) {
+		t.Fatalf("unexpected lists: %+v", lists)
+	}
+}
+
+func TestParseKeepHTML(t *testing.T) {
+	lists, _, err := Parse(FormatKeep, []byte(keepTextHTML))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	tags := []string{"travel"}
+	want := []List{{Title: "Packing", Items: []Item{{Text: "Passport", Tags: tags}, {Text: "Charger & cable", Done: true, Tags: tags}, {Text: "Sunscreen", Tags: tags}}}}
+	if !reflect.DeepEqual(lists, want) {
+		t.Fatalf("unexpected text note: %+v", lists)
+	}
+
+	lists, _, err = Parse(FormatKeep, []byte(keepChecklistHTML))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want = []List{{Title: "Call plumber", Items: []Item{{Text: "Call plumber"}, {Text: "Pay rent", Done: true}}}}
+	if !reflect.DeepEqual(lists, want) {
+		t.Fatalf("expected an untitled checklist to be named after its first item, got %+v", lists)
+	}
+}
+
+func TestParseReminders(t *testing.T) {
+	lists, format, err := Parse("", []byte(remindersICS))
+	if err != nil || format != FormatReminders {
+		t.Fatalf("parse: %v %s", err, format)
+	}
+	want := []List{{Title: "Errands", Items: []Item{
+		{Text: "Pick up dry cleaning, shirts", Note: "Ticket 42\nBack counter", Tags: []string{"town", "weekend"}},
+		{Text: "Return library books", Done: true},
+	}}}
+	if !reflect.DeepEqual(lists, want) {
+		t.Fatalf("unexpected lists: %+v", lists)
+	}
+}
+
+func TestParseTakeoutZipPrefersJSON(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"Takeout/Keep/Groceries.json":  keepChecklistJSON,
+		"Takeout/Keep/Groceries.html":  keepChecklistHTML,
+		"Takeout/Keep/Packing.html":    keepTextHTML,
+		"Takeout/Keep/Labels.txt":      "Food\nTravel\n",
+		"Takeout/Keep/Trashed.json":    `{"title":"Old","textContent":"gone","isTrashed":true}`,
+		"Takeout/archive_browser.html": "<html><body><h1>Your data</h1></body></html>",
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("zip: %v", err)
+		}
+		_, _ = w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	lists, format, err := Parse("", buf.Bytes())
+	if err != nil || format != FormatKeep {
+		t.Fatalf("parse: %v %s", err, format)
+	}
+	var titles []string
+	for _, list := range lists {
+		titles = append(titles, list.Title)
+	}
+	if len(lists) != 2 || !strings.Contains(strings.Join(titles, ","), "Groceries") || !strings.Contains(strings.Join(titles, ","), "Packing") {
+		t.Fatalf("expected Groceries from JSON and Packing from HTML, got %v", titles)
+	}
+
+	if _, _, err := Parse(FormatReminders, buf.Bytes()); !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("expected a Keep zip to hold no reminders, got %v", err)
+	}
+}
+
+func TestParseRejectsUnknownData(t *testing.T) {
+	if _, _, err := Parse("", []byte("just some text")); !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("expected ErrUnknownFormat, got %v", err)
+	}
+	if _, err := ParseFormat("evernote"); err == nil {
+		t.Fatal("expected an unknown format name to be rejected")
+	}
+}
+
+func TestPlanStrategies(t *testing.T) {
+	existing := []Existing{{ID: "list-1", Title: "Groceries"}, {ID: "list-2", Title: "Groceries (2)"}}
+	lists := []List{{Title: "groceries"}, {Title: "Groceries"}, {Title: "Packing"}}
+	titles := func(targets []Target) []string {
+		var got []string
+		for _, target := range targets {
+			got = append(got, target.Title+"|"+target.ReplaceListID)
+		}
+		return got
+	}
+	for strategy, want := range map[Strategy][]string{
+		StrategyNew:     {"groceries|", "Groceries|", "Packing|"},
+		StrategyReplace: {"groceries|list-1", "Groceries|", "Packing|"},
+		StrategySuffix:  {"groceries (3)|", "Groceries (4)|", "Packing|"},
+	} {
+		if got := titles(Plan(strategy, existing, lists)); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %v, got %v", strategy, want, got)
+		}
+	}
+	if strategy, err := ParseStrategy(""); err != nil || strategy != StrategyNew {
+		t.Fatalf("expected new by default, got %s %v", strategy, err)
+	}
+	if _, err := ParseStrategy("merge"); err == nil {
+		t.Fatal("expected an unknown strategy to be rejected")
+	}
+}
@@ -0,0 +1,199 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Google Keep notes become one list each. A checklist's entries become its
+// items; a text note's lines do, with bullets and checkboxes ("- ", "☐ ",
+// "[x] ", …) stripped and checked ones marked done. Labels become tags on
+// every item. Trashed and empty notes are skipped.
+
+// keepNote is a note in Takeout's JSON.
+type keepNote struct {
+	Title       string `json:"title"`
+	TextContent string `json:"textContent"`
+	ListContent []struct {
+		Text      string `json:"text"`
+		IsChecked bool   `json:"isChecked"`
+	} `json:"listContent"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	IsTrashed bool `json:"isTrashed"`
+}
+
+// parseKeepJSON reads one note. It reports false for notes that are trashed
+// or empty.
+func parseKeepJSON(data []byte) (List, bool, error) {
+	var note keepNote
+	if err := json.Unmarshal(data, &note); err != nil {
+		return List{}, false, err
+	}
+	if note.IsTrashed {
+		return List{}, false, nil
+	}
+	var items []Item
+	if note.ListContent != nil {
+		for _, entry := range note.ListContent {
+			if text := strings.TrimSpace(entry.Text); text != "" {
+				items = append(items, Item{Text: text, Done: entry.IsChecked})
+			}
+		}
+	} else {
+		items = textItems(note.TextContent)
+	}
+	labels := make([]string, 0, len(note.Labels))
+	for _, label := range note.Labels {
+		labels = append(labels, label.Name)
+	}
+	return keepList(note.Title, items, labels)
+}
+
+// parseKeepHTML reads one note from Takeout's HTML, for exports without the
+// JSON files. The HTML marks the parts with classes: "title", "content" with
+// either text and <br>s or "listitem" entries (class "checked" when done)
+// holding a "text" span, and "label-name" chips.
+func parseKeepHTML(data []byte) (List, bool, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	type element struct {
+		name    string
+		classes []string
+	}
+	var (
+		stack     []element
+		title     strings.Builder
+		text      strings.Builder
+		label     strings.Builder
+		labels    []string
+		items     []Item
+		checklist bool
+	)
+	within := func(class string) bool {
+		return slices.ContainsFunc(stack, func(e element) bool { return slices.Contains(e.classes, class) })
+	}
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return List{}, false, err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			var classes []string
+			for _, attr := range token.Attr {
+				if attr.Name.Local == "class" {
+					classes = strings.Fields(attr.Value)
+				}
+			}
+			name := strings.ToLower(token.Name.Local)
+			if slices.Contains(classes, "listitem") {
+				checklist = true
+				items = append(items, Item{Done: slices.Contains(classes, "checked")})
+			}
+			if name == "br" && within("content") {
+				text.WriteByte('\n')
+			}
+			// <br> and other void elements are closed right away.
+			if !slices.Contains(xml.HTMLAutoClose, name) {
+				stack = append(stack, element{name: name, classes: classes})
+			}
+		case xml.EndElement:
+			name := strings.ToLower(token.Name.Local)
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i].name == name {
+					if slices.Contains(stack[i].classes, "label-name") {
+						labels = append(labels, label.String())
+						label.Reset()
+					}
+					stack = stack[:i]
+					break
+				}
+			}
+		case xml.CharData:
+			switch {
+			case within("title"):
+				title.Write(token)
+			case within("label-name"):
+				label.Write(token)
+			case within("listitem") && within("text"):
+				items[len(items)-1].Text += string(token)
+			case within("content") && !within("listitem"):
+				text.Write(token)
+			}
+		}
+	}
+	if checklist {
+		for i := range items {
+			items[i].Text = strings.TrimSpace(items[i].Text)
+		}
+		items = slices.DeleteFunc(items, func(item Item) bool { return item.Text == "" })
+	} else {
+		items = textItems(text.String())
+	}
+	return keepList(strings.TrimSpace(title.String()), items, labels)
+}
+
+func keepList(title string, items []Item, labels []string) (List, bool, error) {
+	if len(items) == 0 {
+		return List{}, false, nil
+	}
+	tags := normalizeTags(labels)
+	for i := range items {
+		items[i].Tags = tags
+	}
+	if title == "" {
+		title = titleFrom(items)
+	}
+	return List{Title: title, Items: items}, true, nil
+}
+
+// bullets and checkboxes are stripped from text note lines, in that order,
+// so "- [x] milk" reads as a done "milk".
+var (
+	bullets    = []string{"- ", "* ", "• "}
+	checkboxes = []struct {
+		prefix string
+		done   bool
+	}{
+		{"[ ] ", false}, {"[x] ", true}, {"[X] ", true},
+		{"☐ ", false}, {"☑ ", true}, {"☒ ", true}, {"✓ ", true}, {"✔ ", true},
+	}
+)
+
+// textItems turns each non-empty line of a text note into an item.
+func textItems(text string) []Item {
+	var items []Item
+	for line := range strings.Lines(text) {
+		line = strings.TrimSpace(line)
+		for _, bullet := range bullets {
+			if rest, ok := strings.CutPrefix(line, bullet); ok {
+				line = strings.TrimSpace(rest)
+				break
+			}
+		}
+		var done bool
+		for _, checkbox := range checkboxes {
+			if rest, ok := strings.CutPrefix(line, checkbox.prefix); ok {
+				line, done = strings.TrimSpace(rest), checkbox.done
+				break
+			}
+		}
+		if line != "" {
+			items = append(items, Item{Text: line, Done: done})
+		}
+	}
+	return items
+}
@@ -0,0 +1,140 @@
+package importer
+
+import (
+	"errors"
+	"strings"
+)
+
+// Apple Reminders lists are exported as iCalendar files: one VCALENDAR per
+// list, named by X-WR-CALNAME, with a VTODO per reminder. SUMMARY becomes the
+// item text, DESCRIPTION its note, CATEGORIES its tags, and STATUS:COMPLETED
+// (or a COMPLETED date) marks it done. Cancelled reminders are skipped, and
+// subtasks are imported as items of the list like any other reminder.
+
+// parseReminders reads every calendar in data as a list.
+func parseReminders(data []byte) ([]List, error) {
+	var (
+		lists    []List
+		calendar *List
+		todo     *Item
+		status   string
+		depth    int
+	)
+	for _, line := range unfoldICS(string(data)) {
+		name, value := splitICSLine(line)
+		if name == "BEGIN" || name == "END" {
+			value = strings.ToUpper(value)
+		}
+		switch {
+		case name == "BEGIN" && value == "VCALENDAR":
+			lists = append(lists, List{})
+			calendar = &lists[len(lists)-1]
+		case name == "END" && value == "VCALENDAR":
+			calendar = nil
+		case calendar == nil:
+			continue
+		case name == "BEGIN" && value == "VTODO":
+			todo, status = &Item{}, ""
+		case name == "END" && value == "VTODO" && todo != nil:
+			todo.Text = strings.TrimSpace(todo.Text)
+			if todo.Text != "" && status != "CANCELLED" {
+				calendar.Items = append(calendar.Items, *todo)
+			}
+			todo = nil
+		case name == "BEGIN":
+			// Alarms and other components nested in a reminder have
+			// properties of their own.
+			depth++
+		case name == "END":
+			depth = max(depth-1, 0)
+		case depth > 0:
+			continue
+		case todo == nil:
+			if name == "X-WR-CALNAME" {
+				calendar.Title = strings.TrimSpace(unescapeICS(value))
+			}
+		case name == "SUMMARY":
+			todo.Text = unescapeICS(value)
+		case name == "DESCRIPTION":
+			todo.Note = strings.TrimSpace(unescapeICS(value))
+		case name == "STATUS":
+			status = strings.ToUpper(value)
+			todo.Done = todo.Done || status == "COMPLETED"
+		case name == "COMPLETED":
+			todo.Done = true
+		case name == "CATEGORIES":
+			todo.Tags = normalizeTags(append(todo.Tags, splitICSList(value)...))
+		}
+	}
+	if calendar != nil || len(lists) == 0 {
+		return nil, errors.New("not an iCalendar file")
+	}
+	imported := lists[:0]
+	for _, list := range lists {
+		if len(list.Items) == 0 {
+			continue
+		}
+		if list.Title == "" {
+			list.Title = "Reminders"
+		}
+		imported = append(imported, list)
+	}
+	return imported, nil
+}
+
+// unfoldICS splits data into content lines, joining folded ones (RFC 5545
+// 3.1: a line break followed by a space or tab continues the line).
+func unfoldICS(data string) []string {
+	var lines []string
+	for line := range strings.Lines(data) {
+		line = strings.TrimRight(line, "\r\n")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// splitICSLine splits "NAME;PARAM=x:value" into its upper-cased name and the
+// value, dropping the parameters. Quoted parameter values may contain colons.
+func splitICSLine(line string) (string, string) {
+	quoted := false
+	for i, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ':' && !quoted:
+			name, _, _ := strings.Cut(line[:i], ";")
+			return strings.ToUpper(name), line[i+1:]
+		}
+	}
+	return strings.ToUpper(line), ""
+}
+
+// unescapeICS undoes TEXT escaping: \n, \, \; and \\.
+func unescapeICS(value string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+}
+
+// splitICSList splits a TEXT list at unescaped commas.
+func splitICSList(value string) []string {
+	var values []string
+	var current strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && i+1 < len(value):
+			current.WriteString(value[i : i+2])
+			i++
+		case value[i] == ',':
+			values = append(values, unescapeICS(current.String()))
+			current.Reset()
+		default:
+			current.WriteByte(value[i])
+		}
+	}
+	return append(values, unescapeICS(current.String()))
+}
@@ -0,0 +1,81 @@
+package importer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Strategy decides what happens when an imported list has the title of a
+// list the user already has. Titles are compared case-insensitively.
+type Strategy string
+
+const (
+	// StrategyNew creates every imported list as a new list, next to any
+	// list of the same title.
+	StrategyNew Strategy = "new"
+	// StrategyReplace replaces the items of the existing list with the
+	// imported ones. Each existing list is replaced at most once; further
+	// imported lists of its title are created as new lists.
+	StrategyReplace Strategy = "replace"
+	// StrategySuffix creates new lists like StrategyNew, but numbers titles
+	// that are taken: "Groceries (2)".
+	StrategySuffix Strategy = "suffix"
+)
+
+// ParseStrategy validates a strategy name; empty means StrategyNew.
+func ParseStrategy(s string) (Strategy, error) {
+	switch strategy := Strategy(strings.ToLower(strings.TrimSpace(s))); strategy {
+	case "":
+		return StrategyNew, nil
+	case StrategyNew, StrategyReplace, StrategySuffix:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown import strategy %q (want new, replace or suffix)", s)
+	}
+}
+
+// Existing is a list the user already has.
+type Existing struct {
+	ID    string
+	Title string
+}
+
+// Target is where an imported list goes.
+type Target struct {
+	Title string
+	// ReplaceListID names the existing list whose items are replaced; empty
+	// creates a new list.
+	ReplaceListID string
+}
+
+// Plan decides the target of each imported list, in order.
+func Plan(strategy Strategy, existing []Existing, lists []List) []Target {
+	key := func(title string) string { return strings.ToLower(strings.TrimSpace(title)) }
+	byTitle := make(map[string]string, len(existing))
+	taken := make(map[string]bool, len(existing)+len(lists))
+	for _, list := range existing {
+		if _, ok := byTitle[key(list.Title)]; !ok {
+			byTitle[key(list.Title)] = list.ID
+		}
+		taken[key(list.Title)] = true
+	}
+	replaced := make(map[string]bool)
+	targets := make([]Target, 0, len(lists))
+	for _, list := range lists {
+		target := Target{Title: list.Title}
+		switch strategy {
+		case StrategyReplace:
+			if id, ok := byTitle[key(list.Title)]; ok && !replaced[id] {
+				replaced[id] = true
+				target.ReplaceListID = id
+			}
+		case StrategySuffix:
+			for n := 2; taken[key(target.Title)]; n++ {
+				target.Title = fmt.Sprintf("%s (%d)", list.Title, n)
+			}
+		}
+		taken[key(target.Title)] = true
+		targets = append(targets, target)
+	}
+	return targets
+}
@@ -0,0 +1,341 @@
+// Package integrity checks stored data for silent corruption once a day and
+// reports the result to the operator.
+//
+// Init only verifies the file structure and foreign keys at startup; damage
+// done while the server runs, by a bad restore, a buggy write path or a disk
+// flipping bits in a payload, would otherwise surface as clients that fail
+// to sync weeks later. The daily check replays every user's op log on top of
+// their snapshot, as bootstrap and compaction do, checks that the log is in
+// order, that the materialized state survives being written as a snapshot
+// and read back (which compaction relies on), and asks the store to check
+// its rows (see RowChecker).
+package integrity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/storage"
+)
+
+// Checks that problems are found by.
+const (
+	// CheckReplay covers snapshots that do not decode, ops the replay
+	// rejects, and states that change when written as a snapshot.
+	CheckReplay = "replay"
+	// CheckGeneration covers op logs out of serverSeq order.
+	CheckGeneration = "generation"
+	// CheckRows covers the problems RowChecker finds.
+	CheckRows = "rows"
+	// CheckRead covers users whose data could not be read.
+	CheckRead = "read"
+)
+
+// maxProblems bounds the problems a report lists, and maxUserProblems those
+// listed for one user, so a widespread failure stays readable.
+const (
+	maxProblems     = 100
+	maxUserProblems = 5
+)
+
+// Problem is one inconsistency.
+type Problem struct {
+	Check string `json:"check"`
+	// UserID is the user affected, when the problem concerns one.
+	UserID string `json:"userId,omitempty"`
+	Detail string `json:"detail"`
+}
+
+// Report is the result of one check. It holds ids, counts and error
+// messages, but no list titles, item text or notes.
+type Report struct {
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Users      int       `json:"users"`
+	Ops        int64     `json:"ops"`
+	// RowsChecked is false when the store cannot check its rows, as in demo
+	// mode.
+	RowsChecked bool      `json:"rowsChecked"`
+	Problems    []Problem `json:"problems"`
+	// Omitted counts the problems left out of Problems.
+	Omitted int `json:"omitted,omitempty"`
+}
+
+// OK reports whether the check found nothing wrong.
+func (r Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Summary is a one-line description of the report.
+func (r Report) Summary() string {
+	if r.OK() {
+		return fmt.Sprintf("integrity check passed: %d users, %d ops", r.Users, r.Ops)
+	}
+	return fmt.Sprintf("integrity check found %d problems: %d users, %d ops", len(r.Problems)+r.Omitted, r.Users, r.Ops)
+}
+
+// Text renders the report as plain text, one problem per line.
+func (r Report) Text() string {
+	var b strings.Builder
+	b.WriteString(r.Summary() + "\n\n")
+	fmt.Fprintf(&b, "Started:  %s\nFinished: %s\n", r.StartedAt.UTC().Format(time.RFC3339), r.FinishedAt.UTC().Format(time.RFC3339))
+	if !r.RowsChecked {
+		b.WriteString("Rows were not checked: the store does not support it.\n")
+	}
+	if len(r.Problems) > 0 {
+		b.WriteString("\n")
+	}
+	for _, problem := range r.Problems {
+		if problem.UserID != "" {
+			fmt.Fprintf(&b, "- [%s] user %s: %s\n", problem.Check, problem.UserID, problem.Detail)
+		} else {
+			fmt.Fprintf(&b, "- [%s] %s\n", problem.Check, problem.Detail)
+		}
+	}
+	if r.Omitted > 0 {
+		fmt.Fprintf(&b, "- and %d more\n", r.Omitted)
+	}
+	return b.String()
+}
+
+func (r *Report) add(problem Problem) {
+	if len(r.Problems) == maxProblems {
+		r.Omitted++
+		return
+	}
+	r.Problems = append(r.Problems, problem)
+}
+
+// RowChecker is implemented by stores that can check their rows for
+// invariants the schema does not enforce, such as storage.SQLiteStore.
+type RowChecker interface {
+	CheckConsistency(ctx context.Context) ([]string, error)
+}
+
+// Notifier delivers reports.
+type Notifier interface {
+	Notify(ctx context.Context, report Report) error
+}
+
+// Checker checks the store after each UTC midnight and sends the report to
+// its notifiers, whether or not it found problems, so a silent operator
+// inbox means the check stopped running. All methods accept a nil Checker
+// and then do nothing.
+type Checker struct {
+	store     storage.Store
+	rows      RowChecker
+	notifiers []Notifier
+	now       func() time.Time
+
+	mu   sync.Mutex
+	day  time.Time
+	last *Report
+}
+
+// New returns a checker whose first check runs after the next UTC midnight.
+// Rows are checked when store, or the store it wraps, is a RowChecker.
+func New(store storage.Store, notifiers ...Notifier) *Checker {
+	c := &Checker{store: store, notifiers: notifiers, now: time.Now}
+	inner := store
+	if unwrapper, ok := inner.(interface{ Unwrap() storage.Store }); ok {
+		inner = unwrapper.Unwrap()
+	}
+	c.rows, _ = inner.(RowChecker)
+	c.day = startOfDay(c.now())
+	return c
+}
+
+func startOfDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// Run calls RunOnce every interval until ctx is done. The interval only
+// bounds how late after midnight the check starts.
+func (c *Checker) Run(ctx context.Context, interval time.Duration) {
+	if c == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := c.RunOnce(ctx)
+			if err != nil {
+				log.Printf("integrity check error: %v", err)
+			} else if report != nil {
+				log.Printf("integrity check done users=%d ops=%d problems=%d", report.Users, report.Ops, len(report.Problems)+report.Omitted)
+			}
+		}
+	}
+}
+
+// RunOnce checks the store and sends the report once the UTC day has changed,
+// and returns the report, or nil when the day is still running. A check that
+// fails is not retried until the next day.
+func (c *Checker) RunOnce(ctx context.Context) (*Report, error) {
+	if c == nil {
+		return nil, nil
+	}
+	now := c.now()
+	c.mu.Lock()
+	if !startOfDay(now).After(c.day) {
+		c.mu.Unlock()
+		return nil, nil
+	}
+	c.day = startOfDay(now)
+	c.mu.Unlock()
+
+	report, err := c.Check(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, notifier := range c.notifiers {
+		if err := notifier.Notify(ctx, report); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return &report, fmt.Errorf("send report: %w", err)
+	}
+	return &report, nil
+}
+
+// Check checks the store now and returns the report without sending it. It
+// fails only when the users cannot be listed or ctx ends; users whose data
+// cannot be read are reported as problems.
+func (c *Checker) Check(ctx context.Context) (Report, error) {
+	report := Report{StartedAt: c.now()}
+	if c.rows != nil {
+		problems, err := c.rows.CheckConsistency(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return Report{}, ctx.Err()
+			}
+			report.add(Problem{Check: CheckRead, Detail: err.Error()})
+		}
+		for _, problem := range problems {
+			report.add(Problem{Check: CheckRows, Detail: problem})
+		}
+		report.RowsChecked = err == nil
+	}
+	users, err := c.store.ListUsers(ctx)
+	if err != nil {
+		return Report{}, fmt.Errorf("list users: %w", err)
+	}
+	report.Users = len(users)
+	for _, user := range users {
+		problems, ops, err := c.checkUser(ctx, user.UserID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return Report{}, ctx.Err()
+			}
+			problems = append(problems, Problem{Check: CheckRead, Detail: err.Error()})
+		}
+		report.Ops += ops
+		if len(problems) > maxUserProblems {
+			omitted := len(problems) - maxUserProblems
+			problems = append(problems[:maxUserProblems], Problem{Check: problems[maxUserProblems].Check, Detail: fmt.Sprintf("and %d more", omitted)})
+		}
+		for _, problem := range problems {
+			problem.UserID = user.UserID
+			report.add(problem)
+		}
+	}
+	report.FinishedAt = c.now()
+
+	c.mu.Lock()
+	c.last = &report
+	c.mu.Unlock()
+	return report, nil
+}
+
+// checkUser replays userID's active generation and returns the problems
+// found and the number of ops replayed.
+func (c *Checker) checkUser(ctx context.Context, userID string) ([]Problem, int64, error) {
+	snapshot, err := c.store.GetSnapshot(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	replay, err := materialize.NewReplay(snapshot.Blob)
+	if err != nil {
+		return []Problem{{Check: CheckReplay, Detail: fmt.Sprintf("snapshot of generation %s does not decode: %v", snapshot.DatasetGenerationKey, err)}}, 0, nil
+	}
+	var problems []Problem
+	var ops, previous int64
+	latest, err := c.store.ForEachOpSince(ctx, userID, 0, func(op storage.Op) error {
+		ops++
+		if op.ServerSeq <= previous {
+			problems = append(problems, Problem{Check: CheckGeneration, Detail: fmt.Sprintf("op %d follows op %d", op.ServerSeq, previous)})
+		}
+		previous = op.ServerSeq
+		replay.Apply(op)
+		return nil
+	})
+	if err != nil {
+		return nil, ops, err
+	}
+	if latest < previous {
+		problems = append(problems, Problem{Check: CheckGeneration, Detail: fmt.Sprintf("latest serverSeq %d is below op %d", latest, previous)})
+	}
+	for _, rejection := range replay.Rejections() {
+		problems = append(problems, Problem{Check: CheckReplay, Detail: fmt.Sprintf("op %d (%s) is rejected: %s", rejection.Op.ServerSeq, rejection.Op.Scope, rejection.Reason)})
+	}
+	if detail, err := roundTrip(replay.State()); err != nil {
+		return nil, ops, err
+	} else if detail != "" {
+		problems = append(problems, Problem{Check: CheckReplay, Detail: detail})
+	}
+	return problems, ops, nil
+}
+
+// roundTrip writes state as a snapshot, reads it back, and describes how the
+// two differ, as compaction would lose the difference.
+func roundTrip(state materialize.State) (string, error) {
+	encoded, err := materialize.EncodeSnapshot(state, time.Time{})
+	if err != nil {
+		return "", err
+	}
+	reread, err := materialize.NewReplay(encoded)
+	if err != nil {
+		return "state does not survive a snapshot round trip: " + err.Error(), nil
+	}
+	after := reread.State()
+	again, err := materialize.EncodeSnapshot(after, time.Time{})
+	if err != nil {
+		return "", err
+	}
+	if again != encoded {
+		return fmt.Sprintf("state changes in a snapshot round trip (%d lists, %d items before; %d lists, %d items after)",
+			len(state.Lists), len(state.Items()), len(after.Lists), len(after.Items())), nil
+	}
+	return "", nil
+}
+
+// WriteMetrics writes the result of the last check in the Prometheus text
+// format.
+func (c *Checker) WriteMetrics(w io.Writer) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	last := c.last
+	c.mu.Unlock()
+	if last == nil {
+		return nil
+	}
+	_, err := fmt.Fprintf(w, "# HELP tasklists_integrity_problems Problems found by the last integrity check.\n# TYPE tasklists_integrity_problems gauge\ntasklists_integrity_problems %d\n"+
+		"# HELP tasklists_integrity_last_check_timestamp_seconds When the last integrity check finished.\n# TYPE tasklists_integrity_last_check_timestamp_seconds gauge\ntasklists_integrity_last_check_timestamp_seconds %d\n",
+		len(last.Problems)+last.Omitted, last.FinishedAt.Unix())
+	return err
+}
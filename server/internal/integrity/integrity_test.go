@@ -0,0 +1,131 @@
+package integrity
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"a4-tasklists/server/internal/mail"
+	"a4-tasklists/server/internal/storage"
+)
+
+type reports struct {
+	sent []Report
+}
+
+func (r *reports) Notify(_ context.Context, report Report) error {
+	r.sent = append(r.sent, report)
+	return nil
+}
+
+type outbox struct {
+	to  []string
+	msg mail.Message
+}
+
+func (o *outbox) Send(_ context.Context, to string, msg mail.Message) error {
+	o.to, o.msg = append(o.to, to), msg
+	return nil
+}
+
+func TestRunOnceReportsRejectedOpsAfterMidnight(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	for _, userID := range []string{"user-1", "user-2"} {
+		if _, err := store.GetActiveDatasetGenerationKey(ctx, userID); err != nil {
+			t.Fatalf("generation: %v", err)
+		}
+	}
+	if _, err := store.InsertOps(ctx, "user-1", []storage.Op{
+		{Scope: "registry", Resource: "registry", Actor: "a", Clock: 1, Payload: []byte(`{"type":"createList","listId":"list-1","payload":{"title":"Groceries","pos":[{"digit":1,"actor":"a"}]}}`)},
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 2, Payload: []byte(`{"type":"insert","itemId":"item-1","payload":{"data":{"text":"milk"},"pos":[{"digit":1,"actor":"a"}]}}`)},
+	}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if _, err := store.InsertOps(ctx, "user-2", []storage.Op{{Scope: "list", Resource: "list-1", Actor: "b", Clock: 1, Payload: []byte(`{"type":"insert","payload":{"pos":"first"}}`)}}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	sink := &reports{}
+	sender := &outbox{}
+	checker := New(store, sink, &Email{Sender: sender, To: []string{"ops@example.com"}})
+	now := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	checker.now = func() time.Time { return now }
+	checker.day = startOfDay(now)
+	if report, err := checker.RunOnce(ctx); err != nil || report != nil {
+		t.Fatalf("nothing should be checked during the day: %+v %v", report, err)
+	}
+
+	now = now.Add(17 * time.Hour)
+	report, err := checker.RunOnce(ctx)
+	if err != nil || report == nil {
+		t.Fatalf("expected a report after midnight: %+v %v", report, err)
+	}
+	if report.Users != 2 || report.Ops != 3 || report.RowsChecked || len(sink.sent) != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if len(report.Problems) != 1 || report.Problems[0].UserID != "user-2" || report.Problems[0].Check != CheckReplay || !strings.Contains(report.Problems[0].Detail, "decode list payload") {
+		t.Fatalf("expected the undecodable op to be reported, got %+v", report.Problems)
+	}
+	if len(sender.to) != 1 || !strings.Contains(sender.msg.Subject, "found 1 problems") || !strings.Contains(sender.msg.Text, "user user-2: op 3 (list) is rejected") || strings.Contains(sender.msg.Text, "milk") {
+		t.Fatalf("unexpected email to %v: %+v", sender.to, sender.msg)
+	}
+	if report, err := checker.RunOnce(ctx); err != nil || report != nil {
+		t.Fatalf("expected one check per day, got %+v %v", report, err)
+	}
+	var metrics strings.Builder
+	if err := checker.WriteMetrics(&metrics); err != nil || !strings.Contains(metrics.String(), "tasklists_integrity_problems 1\n") {
+		t.Fatalf("unexpected metrics %q (%v)", metrics.String(), err)
+	}
+
+	var nilChecker *Checker
+	if report, err := nilChecker.RunOnce(ctx); report != nil || err != nil {
+		t.Fatal("expected a nil checker to do nothing")
+	}
+}
+
+func TestCheckAsksSQLiteStoresToCheckTheirRows(t *testing.T) {
+	ctx := context.Background()
+	sqlite, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := sqlite.Init(ctx); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlite.Close() })
+	if _, err := sqlite.GetActiveDatasetGenerationKey(ctx, "user-1"); err != nil {
+		t.Fatalf("generation: %v", err)
+	}
+
+	var body struct {
+		OK          bool   `json:"ok"`
+		Text        string `json:"text"`
+		Users       int    `json:"users"`
+		RowsChecked bool   `json:"rowsChecked"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer server.Close()
+	webhook, err := NewWebhook(server.URL)
+	if err != nil {
+		t.Fatalf("webhook: %v", err)
+	}
+	checker := New(storage.NewRetryingStore(sqlite, storage.RetryPolicy{}), webhook)
+	checker.day = checker.day.Add(-24 * time.Hour)
+	if _, err := checker.RunOnce(ctx); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if !body.OK || !body.RowsChecked || body.Users != 1 || body.Text != "integrity check passed: 1 users, 0 ops" {
+		t.Fatalf("unexpected webhook body %+v", body)
+	}
+}
@@ -0,0 +1,77 @@
+package integrity
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"a4-tasklists/server/internal/mail"
+)
+
+// Webhook posts each report as JSON to a URL. Besides the report's fields,
+// the body carries ok and a text line that chat webhooks display.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhook returns a webhook posting to target, which must be an http(s)
+// URL.
+func NewWebhook(target string) (*Webhook, error) {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("webhook url %q must be an http(s) url", target)
+	}
+	return &Webhook{URL: target, Client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (h *Webhook) Notify(ctx context.Context, report Report) error {
+	body, err := json.Marshal(struct {
+		Report
+		OK   bool   `json:"ok"`
+		Text string `json:"text"`
+	}{report, report.OK(), report.Summary()})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Email sends each report as a plain-text email. Reports go to operators,
+// so they are neither translated nor rendered from the email templates.
+type Email struct {
+	Sender mail.Sender
+	To     []string
+}
+
+func (e *Email) Notify(ctx context.Context, report Report) error {
+	msg := mail.Message{Subject: "[tasklists] " + report.Summary(), Text: report.Text()}
+	var errs []error
+	for _, to := range e.To {
+		if err := e.Sender.Send(ctx, to, msg); err != nil {
+			errs = append(errs, fmt.Errorf("email %s: %w", to, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,178 @@
+// Package ipfilter restricts operational endpoints to trusted networks.
+//
+// The app is meant to be exposed publicly, but surfaces such as /admin/*,
+// /metrics, and /debug/* should only answer to operators. The filter matches
+// the client address against allow and deny CIDR lists before any other
+// middleware runs.
+package ipfilter
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// DefaultPaths are the paths guarded when Config.Paths is empty.
+var DefaultPaths = []string{"/admin", "/metrics", "/debug"}
+
+// DefaultAllow is used when Config.Allow is empty: loopback only.
+var DefaultAllow = []netip.Prefix{
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("::1/128"),
+}
+
+// Config describes which paths are guarded and who may reach them.
+type Config struct {
+	// Paths lists guarded paths; each also guards everything below it. Empty
+	// selects DefaultPaths.
+	Paths []string
+	// Allow lists networks that may reach guarded paths. Empty selects
+	// DefaultAllow.
+	Allow []netip.Prefix
+	// Deny lists networks that are always rejected, even when allowed.
+	Deny []netip.Prefix
+	// TrustedProxies lists reverse proxies whose ClientIPHeader is believed.
+	TrustedProxies []netip.Prefix
+	// ClientIPHeader names the header carrying the original client address,
+	// e.g. X-Forwarded-For or X-Real-IP. It is ignored unless the direct peer
+	// is a trusted proxy.
+	ClientIPHeader string
+}
+
+// Filter guards configured path prefixes by client address.
+type Filter struct {
+	cfg Config
+
+	// mu guards cfg.Allow and cfg.Deny, which SetNetworks replaces.
+	mu sync.RWMutex
+}
+
+// New returns a filter for cfg, filling in defaults.
+func New(cfg Config) *Filter {
+	if len(cfg.Paths) == 0 {
+		cfg.Paths = DefaultPaths
+	}
+	if len(cfg.Allow) == 0 {
+		cfg.Allow = DefaultAllow
+	}
+	cfg.ClientIPHeader = http.CanonicalHeaderKey(strings.TrimSpace(cfg.ClientIPHeader))
+	return &Filter{cfg: cfg}
+}
+
+// ParsePrefixes parses CIDR ranges. Bare addresses are treated as single-host
+// ranges.
+func ParsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("parse address %q: %w", value, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("parse cidr %q: %w", value, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Middleware rejects guarded requests from disallowed clients with 403.
+// Unguarded paths pass through untouched.
+func (f *Filter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !f.guarded(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		addr, ok := f.clientAddr(r)
+		if !ok || !f.Allowed(addr) {
+			log.Printf("ipfilter rejected path=%s client=%s", r.URL.Path, addr)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SetNetworks replaces the allow and deny lists, e.g. after a configuration
+// reload. An empty allow list selects DefaultAllow, as in New.
+func (f *Filter) SetNetworks(allow []netip.Prefix, deny []netip.Prefix) {
+	if len(allow) == 0 {
+		allow = DefaultAllow
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cfg.Allow = allow
+	f.cfg.Deny = deny
+}
+
+// Allowed reports whether addr may reach guarded paths.
+func (f *Filter) Allowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return !containsAddr(f.cfg.Deny, addr) && containsAddr(f.cfg.Allow, addr)
+}
+
+func (f *Filter) guarded(path string) bool {
+	for _, guarded := range f.cfg.Paths {
+		guarded = strings.TrimSuffix(guarded, "/")
+		if path == guarded || strings.HasPrefix(path, guarded+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr resolves the client address. Forwarded addresses are walked from
+// the right, skipping trusted proxies, so a client cannot spoof its address by
+// prepending entries to X-Forwarded-For.
+func (f *Filter) clientAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	peer = peer.Unmap()
+	if f.cfg.ClientIPHeader == "" || !containsAddr(f.cfg.TrustedProxies, peer) {
+		return peer, true
+	}
+	entries := strings.Split(strings.Join(r.Header.Values(f.cfg.ClientIPHeader), ","), ",")
+	for i := len(entries) - 1; i >= 0; i-- {
+		value := strings.TrimSpace(entries[i])
+		if value == "" {
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		addr = addr.Unmap()
+		if !containsAddr(f.cfg.TrustedProxies, addr) {
+			return addr, true
+		}
+		peer = addr
+	}
+	return peer, true
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package ipfilter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func mustPrefixes(t *testing.T, values ...string) []netip.Prefix {
+	t.Helper()
+	prefixes, err := ParsePrefixes(values)
+	if err != nil {
+		t.Fatalf("parse prefixes: %v", err)
+	}
+	return prefixes
+}
+
+func serve(filter *Filter, path string, remoteAddr string, forwarded string) int {
+	handler := filter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = remoteAddr
+	if forwarded != "" {
+		req.Header.Set("X-Forwarded-For", forwarded)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder.Code
+}
+
+func TestDefaultsAllowOnlyLoopbackOnGuardedPaths(t *testing.T) {
+	filter := New(Config{})
+	cases := []struct {
+		path       string
+		remoteAddr string
+		want       int
+	}{
+		{"/metrics", "127.0.0.1:5000", http.StatusNoContent},
+		{"/debug/pprof/", "[::1]:5000", http.StatusNoContent},
+		{"/metrics", "203.0.113.7:5000", http.StatusForbidden},
+		{"/admin/clients", "203.0.113.7:5000", http.StatusForbidden},
+		{"/admin", "203.0.113.7:5000", http.StatusForbidden},
+		{"/sync/pull", "203.0.113.7:5000", http.StatusNoContent},
+		{"/metricsfoo", "203.0.113.7:5000", http.StatusNoContent},
+	}
+	for _, tc := range cases {
+		if got := serve(filter, tc.path, tc.remoteAddr, ""); got != tc.want {
+			t.Errorf("%s from %s: expected %d, got %d", tc.path, tc.remoteAddr, tc.want, got)
+		}
+	}
+}
+
+func TestDenyOverridesAllow(t *testing.T) {
+	filter := New(Config{
+		Allow: mustPrefixes(t, "10.0.0.0/8"),
+		Deny:  mustPrefixes(t, "10.0.5.0/24"),
+	})
+	if got := serve(filter, "/metrics", "10.1.2.3:80", ""); got != http.StatusNoContent {
+		t.Fatalf("expected allowed network to pass, got %d", got)
+	}
+	if got := serve(filter, "/metrics", "10.0.5.9:80", ""); got != http.StatusForbidden {
+		t.Fatalf("expected denied network to be rejected, got %d", got)
+	}
+}
+
+func TestSetNetworksReplacesLists(t *testing.T) {
+	filter := New(Config{Allow: mustPrefixes(t, "10.0.0.0/8")})
+	filter.SetNetworks(mustPrefixes(t, "192.168.1.0/24"), mustPrefixes(t, "192.168.1.66"))
+	if got := serve(filter, "/metrics", "10.1.2.3:80", ""); got != http.StatusForbidden {
+		t.Fatalf("expected the old allow list to be gone, got %d", got)
+	}
+	if got := serve(filter, "/metrics", "192.168.1.20:80", ""); got != http.StatusNoContent {
+		t.Fatalf("expected the new allow list to apply, got %d", got)
+	}
+	if got := serve(filter, "/metrics", "192.168.1.66:80", ""); got != http.StatusForbidden {
+		t.Fatalf("expected the new deny list to apply, got %d", got)
+	}
+	filter.SetNetworks(nil, nil)
+	if got := serve(filter, "/metrics", "127.0.0.1:80", ""); got != http.StatusNoContent {
+		t.Fatalf("expected an empty allow list to fall back to loopback, got %d", got)
+	}
+}
+
+func TestForwardedHeaderOnlyTrustedFromProxies(t *testing.T) {
+	filter := New(Config{
+		Allow:          mustPrefixes(t, "192.168.1.0/24"),
+		TrustedProxies: mustPrefixes(t, "10.0.0.1"),
+		ClientIPHeader: "X-Forwarded-For",
+	})
+	if got := serve(filter, "/metrics", "10.0.0.1:80", "192.168.1.20"); got != http.StatusNoContent {
+		t.Fatalf("expected forwarded allowed client to pass, got %d", got)
+	}
+	// A client cannot prepend an allowed address; the proxy appends the real one.
+	if got := serve(filter, "/metrics", "10.0.0.1:80", "192.168.1.20, 203.0.113.7"); got != http.StatusForbidden {
+		t.Fatalf("expected spoofed forwarded chain to be rejected, got %d", got)
+	}
+	if got := serve(filter, "/metrics", "203.0.113.7:80", "192.168.1.20"); got != http.StatusForbidden {
+		t.Fatalf("expected header from untrusted peer to be ignored, got %d", got)
+	}
+}
+
+func TestParsePrefixesRejectsGarbage(t *testing.T) {
+	if _, err := ParsePrefixes([]string{"not-a-cidr"}); err == nil {
+		t.Fatal("expected invalid cidr to be rejected")
+	}
+}
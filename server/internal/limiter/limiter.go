@@ -0,0 +1,237 @@
+// Package limiter bounds the number of requests in flight.
+//
+// SQLite has a single writer, and after an outage every client pulls at
+// once. Instead of letting those requests pile up on the database until they
+// all time out, the limiter admits a bounded number, lets a few more wait
+// briefly, and rejects the rest at once with 503 so clients back off.
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// GlobalName names the global limit in stats and metrics.
+const GlobalName = "global"
+
+// Config describes the limits. Zero limits are unlimited.
+type Config struct {
+	// Global caps requests in flight across all limited paths.
+	Global int
+	// Endpoints caps requests in flight per path. A path ending in / also
+	// covers everything below it.
+	Endpoints map[string]int
+	// MaxWait is how long a request may wait for a free slot before it is
+	// rejected. Zero rejects at once.
+	MaxWait time.Duration
+	// Exempt lists paths that are never limited, such as health checks.
+	Exempt []string
+}
+
+// limit is one semaphore with its counters.
+type limit struct {
+	name  string
+	slots chan struct{}
+
+	waiting  atomic.Int64
+	admitted atomic.Int64
+	rejected atomic.Int64
+	waitNs   atomic.Int64
+}
+
+// Limiter enforces a Config.
+type Limiter struct {
+	global    *limit
+	endpoints []*limit
+	maxWait   time.Duration
+	exempt    []string
+}
+
+// New returns a limiter for cfg.
+func New(cfg Config) *Limiter {
+	l := &Limiter{maxWait: cfg.MaxWait, exempt: cfg.Exempt}
+	if cfg.Global > 0 {
+		l.global = &limit{name: GlobalName, slots: make(chan struct{}, cfg.Global)}
+	}
+	for _, path := range slices.Sorted(maps.Keys(cfg.Endpoints)) {
+		if n := cfg.Endpoints[path]; n > 0 {
+			l.endpoints = append(l.endpoints, &limit{name: path, slots: make(chan struct{}, n)})
+		}
+	}
+	// Longest paths first, so the most specific limit wins.
+	slices.SortStableFunc(l.endpoints, func(a, b *limit) int { return len(b.name) - len(a.name) })
+	return l
+}
+
+// ParseEndpoints parses per-path limits such as "/sync/pull=16,/sync/push=8".
+func ParseEndpoints(spec string) (map[string]int, error) {
+	endpoints := map[string]int{}
+	for entry := range strings.SplitSeq(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		path, value, ok := strings.Cut(entry, "=")
+		path = strings.TrimSpace(path)
+		if !ok || !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("invalid endpoint limit %q (want /path=N)", entry)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid limit in %q", entry)
+		}
+		endpoints[path] = n
+	}
+	return endpoints, nil
+}
+
+// endpoint returns the limit covering path, if any.
+func (l *Limiter) endpoint(path string) *limit {
+	for _, candidate := range l.endpoints {
+		if path == candidate.name || (strings.HasSuffix(candidate.name, "/") && strings.HasPrefix(path, candidate.name)) {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// acquire takes a slot, waiting until deadline at most.
+func (lim *limit) acquire(ctx context.Context, deadline <-chan time.Time) bool {
+	select {
+	case lim.slots <- struct{}{}:
+		lim.admitted.Add(1)
+		return true
+	default:
+	}
+	if deadline == nil {
+		lim.rejected.Add(1)
+		return false
+	}
+	lim.waiting.Add(1)
+	defer lim.waiting.Add(-1)
+	start := time.Now()
+	defer func() { lim.waitNs.Add(int64(time.Since(start))) }()
+	select {
+	case lim.slots <- struct{}{}:
+		lim.admitted.Add(1)
+		return true
+	case <-deadline:
+	case <-ctx.Done():
+	}
+	lim.rejected.Add(1)
+	return false
+}
+
+func (lim *limit) release() {
+	<-lim.slots
+}
+
+// Middleware serves requests with next while slots are free and rejects the
+// rest with 503 and Retry-After. The endpoint slot is taken before the
+// global one, so requests for a saturated endpoint never hold global slots.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(l.exempt, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var deadline <-chan time.Time
+		if l.maxWait > 0 {
+			timer := time.NewTimer(l.maxWait)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+		for _, lim := range []*limit{l.endpoint(r.URL.Path), l.global} {
+			if lim == nil {
+				continue
+			}
+			if !lim.acquire(r.Context(), deadline) {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "server busy, retry later", http.StatusServiceUnavailable)
+				return
+			}
+			defer lim.release()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Stats describes one limit.
+type Stats struct {
+	Name        string  `json:"name"`
+	Limit       int     `json:"limit"`
+	InFlight    int     `json:"inFlight"`
+	Waiting     int64   `json:"waiting"`
+	Admitted    int64   `json:"admitted"`
+	Rejected    int64   `json:"rejected"`
+	WaitSeconds float64 `json:"waitSeconds"`
+}
+
+// Stats returns the global limit (if any) followed by the endpoint limits.
+func (l *Limiter) Stats() []Stats {
+	var stats []Stats
+	for _, lim := range append([]*limit{l.global}, l.endpoints...) {
+		if lim == nil {
+			continue
+		}
+		stats = append(stats, Stats{
+			Name:        lim.name,
+			Limit:       cap(lim.slots),
+			InFlight:    len(lim.slots),
+			Waiting:     lim.waiting.Load(),
+			Admitted:    lim.admitted.Load(),
+			Rejected:    lim.rejected.Load(),
+			WaitSeconds: time.Duration(lim.waitNs.Load()).Seconds(),
+		})
+	}
+	return stats
+}
+
+// WriteMetrics writes the stats in the Prometheus text format.
+func (l *Limiter) WriteMetrics(w io.Writer) error {
+	stats := l.Stats()
+	metrics := []struct {
+		name  string
+		kind  string
+		help  string
+		value func(Stats) string
+	}{
+		{"tasklists_limiter_limit", "gauge", "Maximum requests in flight.", func(s Stats) string { return strconv.Itoa(s.Limit) }},
+		{"tasklists_limiter_in_flight", "gauge", "Requests holding a slot.", func(s Stats) string { return strconv.Itoa(s.InFlight) }},
+		{"tasklists_limiter_waiting", "gauge", "Requests waiting for a slot.", func(s Stats) string { return strconv.FormatInt(s.Waiting, 10) }},
+		{"tasklists_limiter_admitted_total", "counter", "Requests that got a slot.", func(s Stats) string { return strconv.FormatInt(s.Admitted, 10) }},
+		{"tasklists_limiter_rejected_total", "counter", "Requests rejected with 503.", func(s Stats) string { return strconv.FormatInt(s.Rejected, 10) }},
+		{"tasklists_limiter_wait_seconds_total", "counter", "Time requests spent waiting for a slot.", func(s Stats) string { return strconv.FormatFloat(s.WaitSeconds, 'f', -1, 64) }},
+	}
+	for _, metric := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind); err != nil {
+			return err
+		}
+		for _, s := range stats {
+			if _, err := fmt.Fprintf(w, "%s{limit=%q} %s\n", metric.name, s.Name, metric.value(s)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// MetricsHandler serves WriteMetrics.
+func (l *Limiter) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = l.WriteMetrics(w)
+	})
+}
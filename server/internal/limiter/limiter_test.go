@@ -0,0 +1,94 @@
+package limiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingHandler holds every request until release is closed.
+func blockingHandler(started chan<- struct{}, release <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func serve(handler http.Handler, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	return recorder
+}
+
+func TestLimitsRejectFastWhenSaturated(t *testing.T) {
+	endpoints, err := ParseEndpoints("/sync/pull=1, /lists/=2")
+	if err != nil {
+		t.Fatalf("parse endpoints: %v", err)
+	}
+	limiter := New(Config{Global: 2, Endpoints: endpoints, Exempt: []string{"/healthz"}})
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := limiter.Middleware(blockingHandler(started, release))
+
+	var wg sync.WaitGroup
+	wg.Go(func() { serve(handler, "/sync/pull") })
+	<-started
+	if resp := serve(handler, "/sync/pull"); resp.Code != http.StatusServiceUnavailable || resp.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected a saturated endpoint to answer 503 with Retry-After, got %d", resp.Code)
+	}
+	wg.Go(func() { serve(handler, "/lists/a/items") })
+	<-started
+	if resp := serve(handler, "/lists/b/items"); resp.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected the global limit to reject, got %d", resp.Code)
+	}
+	if resp := serve(limiter.Middleware(http.NotFoundHandler()), "/healthz"); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected exempt paths to pass, got %d", resp.Code)
+	}
+	close(release)
+	wg.Wait()
+
+	stats := limiter.Stats()
+	if len(stats) != 3 || stats[0].Name != GlobalName || stats[0].InFlight != 0 || stats[0].Rejected != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	var metrics strings.Builder
+	if err := limiter.WriteMetrics(&metrics); err != nil {
+		t.Fatalf("write metrics: %v", err)
+	}
+	if !strings.Contains(metrics.String(), `tasklists_limiter_rejected_total{limit="/sync/pull"} 1`) {
+		t.Fatalf("expected endpoint rejections in metrics, got:\n%s", metrics.String())
+	}
+}
+
+func TestWaitingRequestsGetFreedSlots(t *testing.T) {
+	limiter := New(Config{Global: 1, MaxWait: time.Second})
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := limiter.Middleware(blockingHandler(started, release))
+
+	var wg sync.WaitGroup
+	wg.Go(func() { serve(handler, "/sync/pull") })
+	<-started
+	codes := make(chan int, 1)
+	wg.Go(func() { codes <- serve(handler, "/sync/pull").Code })
+	for limiter.Stats()[0].Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	release <- struct{}{}
+	<-started
+	close(release)
+	wg.Wait()
+	if code := <-codes; code != http.StatusNoContent {
+		t.Fatalf("expected the waiting request to be served, got %d", code)
+	}
+}
+
+func TestParseEndpointsRejectsGarbage(t *testing.T) {
+	for _, spec := range []string{"sync/pull=1", "/sync/pull", "/sync/pull=-1", "/sync/pull=x"} {
+		if _, err := ParseEndpoints(spec); err == nil {
+			t.Errorf("expected %q to be rejected", spec)
+		}
+	}
+}
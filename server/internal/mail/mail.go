@@ -0,0 +1,190 @@
+// Package mail renders and sends the emails the server writes, such as
+// digests.
+//
+// Every email is a pair of templates: <name>.txt, a text/template holding the
+// subject and the plain-text body, and <name>.html, an html/template with the
+// same content for clients that show HTML. Both are wrapped by a shared layout
+// (layout.txt and layout.html), which is where a deployment puts its branding.
+// The templates ship with the server; operators replace single files with
+// their own (see LoadDir).
+//
+// Templates translate with the functions t (a message) and tf (a message
+// formatted like fmt.Sprintf), using the recipient's language; lang returns
+// the language tag and subject the rendered subject.
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	texttemplate "text/template"
+
+	"a4-tasklists/server/internal/i18n"
+)
+
+//go:embed templates/*
+var builtin embed.FS
+
+// layout is the file name, without extension, of the shared layout.
+const layout = "layout"
+
+// Message is a rendered email. HTML is empty for plain-text only mail.
+type Message struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html,omitempty"`
+}
+
+// Templates holds the email templates by name.
+type Templates struct {
+	sources map[string]string
+	text    map[string]*texttemplate.Template
+	html    map[string]*htmltemplate.Template
+}
+
+// Builtin returns the templates that ship with the server.
+func Builtin() *Templates {
+	sources := map[string]string{}
+	entries, err := fs.ReadDir(builtin, "templates")
+	if err != nil {
+		panic(fmt.Sprintf("mail: builtin templates: %v", err))
+	}
+	for _, entry := range entries {
+		data, err := fs.ReadFile(builtin, "templates/"+entry.Name())
+		if err != nil {
+			panic(fmt.Sprintf("mail: builtin templates: %v", err))
+		}
+		sources[entry.Name()] = string(data)
+	}
+	templates := &Templates{}
+	if err := templates.compile(sources); err != nil {
+		panic(fmt.Sprintf("mail: builtin templates: %v", err))
+	}
+	return templates
+}
+
+// LoadDir replaces templates with the files of the same name in dir, e.g.
+// layout.html to brand every email or digest.txt to reword the digest. Files
+// that are not templates of the server are an error, so a misspelled name
+// does not go unnoticed.
+func (t *Templates) LoadDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	sources := maps.Clone(t.sources)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if _, ok := t.sources[name]; !ok {
+			return fmt.Errorf("%s is not an email template; expected one of %s", name, strings.Join(slices.Sorted(maps.Keys(t.sources)), ", "))
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		sources[name] = string(data)
+	}
+	return t.compile(sources)
+}
+
+// compile parses every email with the layout and replaces the templates only
+// when all of them parse.
+func (t *Templates) compile(sources map[string]string) error {
+	text := map[string]*texttemplate.Template{}
+	html := map[string]*htmltemplate.Template{}
+	for file, source := range sources {
+		name, ok := strings.CutSuffix(file, ".txt")
+		if !ok || name == layout {
+			continue
+		}
+		textTemplate := texttemplate.New(name).Funcs(texttemplate.FuncMap(funcs(i18n.Printer{}, "")))
+		if _, err := textTemplate.New(layout + ".txt").Parse(sources[layout+".txt"]); err != nil {
+			return err
+		}
+		if _, err := textTemplate.Parse(source); err != nil {
+			return err
+		}
+		if textTemplate.Lookup("subject") == nil {
+			return fmt.Errorf("%s defines no subject", file)
+		}
+		text[name] = textTemplate
+
+		htmlSource, ok := sources[name+".html"]
+		if !ok {
+			continue
+		}
+		htmlTemplate := htmltemplate.New(name).Funcs(htmltemplate.FuncMap(funcs(i18n.Printer{}, "")))
+		if _, err := htmlTemplate.New(layout + ".html").Parse(sources[layout+".html"]); err != nil {
+			return err
+		}
+		if _, err := htmlTemplate.Parse(htmlSource); err != nil {
+			return err
+		}
+		html[name] = htmlTemplate
+	}
+	t.sources, t.text, t.html = sources, text, html
+	return nil
+}
+
+// funcs returns the template functions for one recipient.
+func funcs(p i18n.Printer, subject string) map[string]any {
+	return map[string]any{
+		"t":       p.Text,
+		"tf":      p.Sprintf,
+		"lang":    p.Language,
+		"subject": func() string { return subject },
+	}
+}
+
+// Names returns the names of the emails, e.g. "digest".
+func (t *Templates) Names() []string {
+	return slices.Sorted(maps.Keys(t.text))
+}
+
+// Render writes the email name for data in the language of p.
+func (t *Templates) Render(name string, p i18n.Printer, data any) (Message, error) {
+	textTemplate, ok := t.text[name]
+	if !ok {
+		return Message{}, fmt.Errorf("no email template %q", name)
+	}
+	textTemplate, err := textTemplate.Clone()
+	if err != nil {
+		return Message{}, err
+	}
+	var subject, text bytes.Buffer
+	textTemplate.Funcs(funcs(p, ""))
+	if err := textTemplate.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	msg := Message{Subject: strings.Join(strings.Fields(subject.String()), " ")}
+	if msg.Subject == "" {
+		return Message{}, errors.New("email subject is empty")
+	}
+	if err := textTemplate.Execute(&text, data); err != nil {
+		return Message{}, err
+	}
+	msg.Text = text.String()
+	if htmlTemplate, ok := t.html[name]; ok {
+		htmlTemplate, err := htmlTemplate.Clone()
+		if err != nil {
+			return Message{}, err
+		}
+		var html bytes.Buffer
+		if err := htmlTemplate.Funcs(funcs(p, msg.Subject)).Execute(&html, data); err != nil {
+			return Message{}, err
+		}
+		msg.HTML = html.String()
+	}
+	return msg, nil
+}
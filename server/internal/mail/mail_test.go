@@ -0,0 +1,84 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"a4-tasklists/server/internal/i18n"
+)
+
+// digestView has the shape of the digest package's template data.
+type digestView struct {
+	Frequency string
+	Added     int
+	Completed int
+	Lists     []digestList
+}
+
+type digestList struct {
+	Title     string
+	Added     []string
+	Completed []string
+}
+
+func TestRenderEscapesHTMLOnly(t *testing.T) {
+	data := digestView{Added: 1, Lists: []digestList{{Title: "Tools", Added: []string{"<b>hammer</b> & nails"}}}}
+	msg, err := Builtin().Render("digest", i18n.Printer{}, data)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if msg.Subject != "Your daily list digest: 1 added, 0 completed" {
+		t.Fatalf("unexpected subject %q", msg.Subject)
+	}
+	if !strings.Contains(msg.Text, "- <b>hammer</b> & nails\n") {
+		t.Fatalf("expected the text part unescaped, got %q", msg.Text)
+	}
+	if !strings.Contains(msg.HTML, "<li>&lt;b&gt;hammer&lt;/b&gt; &amp; nails</li>") || !strings.Contains(msg.HTML, `<html lang="en">`) {
+		t.Fatalf("expected the HTML part escaped, got %q", msg.HTML)
+	}
+	if _, err := Builtin().Render("welcome", i18n.Printer{}, data); err == nil {
+		t.Fatal("expected an unknown template to fail")
+	}
+}
+
+func TestLoadDirReplacesSingleTemplates(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("write template: %v", err)
+		}
+	}
+	write("layout.txt", `{{define "layout"}}Example Co.
+
+{{template "content" .}}{{end}}`)
+	templates := Builtin()
+	if err := templates.LoadDir(dir); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	msg, err := templates.Render("digest", i18n.Printer{}, digestView{})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if msg.Text != "Example Co.\n\n" || !strings.Contains(msg.HTML, "<main") {
+		t.Fatalf("expected the text layout replaced and the HTML kept, got %q %q", msg.Text, msg.HTML)
+	}
+
+	for name, content := range map[string]string{
+		"digset.txt": `{{define "subject"}}x{{end}}`,
+		"digest.txt": `{{define "subject"}}{{.Nope}`,
+	} {
+		broken := t.TempDir()
+		if err := os.WriteFile(filepath.Join(broken, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("write template: %v", err)
+		}
+		if err := templates.LoadDir(broken); err == nil {
+			t.Fatalf("expected %s to be rejected", name)
+		}
+	}
+	if msg, err := templates.Render("digest", i18n.Printer{}, digestView{}); err != nil || msg.Text != "Example Co.\n\n" {
+		t.Fatalf("expected a rejected directory to keep the templates, got %q %v", msg.Text, err)
+	}
+}
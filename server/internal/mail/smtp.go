@@ -0,0 +1,109 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Sender delivers an email.
+type Sender interface {
+	Send(ctx context.Context, to string, msg Message) error
+}
+
+// SMTPConfig configures SMTPSender. Username and Password are optional; when
+// set, PLAIN auth is used, which net/smtp only allows over TLS or to
+// localhost.
+type SMTPConfig struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+// SMTPSender sends mail through an SMTP relay.
+type SMTPSender struct {
+	config SMTPConfig
+}
+
+func NewSMTPSender(config SMTPConfig) (*SMTPSender, error) {
+	if _, _, err := net.SplitHostPort(config.Addr); err != nil {
+		return nil, fmt.Errorf("smtp address must be host:port: %w", err)
+	}
+	if config.From == "" {
+		return nil, errors.New("smtp sender address is required")
+	}
+	return &SMTPSender{config: config}, nil
+}
+
+func (s *SMTPSender) Send(_ context.Context, to string, msg Message) error {
+	if strings.ContainsAny(to, "\r\n") {
+		return errors.New("invalid recipient address")
+	}
+	var auth smtp.Auth
+	if s.config.Username != "" {
+		host, _, _ := net.SplitHostPort(s.config.Addr)
+		auth = smtp.PlainAuth("", s.config.Username, s.config.Password, host)
+	}
+	data, err := encode(s.config.From, to, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(s.config.Addr, auth, s.config.From, []string{to}, data)
+}
+
+// encode builds an RFC 5322 message. With an HTML body it is
+// multipart/alternative with the plain text first, so clients that cannot or
+// are told not to show HTML (screen readers, text-only clients) get the text.
+func encode(from string, to string, msg Message, date time.Time) ([]byte, error) {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "From: %s\r\n", from)
+	fmt.Fprintf(&out, "To: %s\r\n", to)
+	fmt.Fprintf(&out, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&out, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	out.WriteString("MIME-Version: 1.0\r\n")
+	if msg.HTML == "" {
+		out.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		out.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+		out.WriteString("\r\n")
+		out.WriteString(strings.ReplaceAll(msg.Text, "\n", "\r\n"))
+		return out.Bytes(), nil
+	}
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	fmt.Fprintf(&out, "Content-Type: multipart/alternative; boundary=%s\r\n", parts.Boundary())
+	out.WriteString("\r\n")
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		writer, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoder := quotedprintable.NewWriter(writer)
+		if _, err := encoder.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	out.Write(body.Bytes())
+	return out.Bytes(), nil
+}
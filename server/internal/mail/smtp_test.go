@@ -0,0 +1,61 @@
+package mail
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodePlainTextEncodesSubjectAndUsesCRLF(t *testing.T) {
+	data, err := encode("lists@example.com", "ada@example.com", Message{Subject: "Your digest ✓", Text: "line 1\nline 2\n"}, time.Unix(0, 0).UTC())
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	msg := string(data)
+	if !strings.Contains(msg, "Subject: =?utf-8?q?Your_digest_=E2=9C=93?=\r\n") {
+		t.Fatalf("subject not encoded: %q", msg)
+	}
+	if !strings.HasSuffix(msg, "\r\n\r\nline 1\r\nline 2\r\n") {
+		t.Fatalf("body not CRLF-terminated: %q", msg)
+	}
+}
+
+func TestEncodeHTMLIsMultipartAlternativeWithTextFirst(t *testing.T) {
+	data, err := encode("lists@example.com", "ada@example.com", Message{Subject: "Digest", Text: "Grüße\n", HTML: "<p>Grüße</p>\n"}, time.Unix(0, 0).UTC())
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("unexpected content type %q: %v", parsed.Header.Get("Content-Type"), err)
+	}
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	var parts []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("next part: %v", err)
+		}
+		// NextPart decodes quoted-printable and drops the header.
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("read part: %v", err)
+		}
+		parts = append(parts, part.Header.Get("Content-Type")+": "+string(body))
+	}
+	want := []string{"text/plain; charset=utf-8: Grüße\r\n", "text/html; charset=utf-8: <p>Grüße</p>\r\n"}
+	if len(parts) != 2 || parts[0] != want[0] || parts[1] != want[1] {
+		t.Fatalf("unexpected parts %q", parts)
+	}
+}
@@ -0,0 +1,60 @@
+package materialize
+
+import (
+	"errors"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+// ErrListNotFound is returned when the requested list is not visible in the
+// materialized state.
+var ErrListNotFound = errors.New("list not found")
+
+// Copy configures Duplicate.
+type Copy struct {
+	// ListID is the id of the new list.
+	ListID string
+	// Title of the new list; empty keeps the source title.
+	Title string
+	// Actor signs the generated ops and positions. It must be unused by any
+	// client so the ops cannot collide with theirs.
+	Actor string
+	// NewItemID returns a fresh id for each copied item.
+	NewItemID func() string
+}
+
+// Duplicate returns the ops that create a copy of sourceListID after the last
+// visible list, with the source's items (text and note) in order and all of
+// them unchecked. The ops use the same shapes as client ops and clocks above
+// every clock seen so far.
+func Duplicate(snapshotBlob string, ops []storage.Op, sourceListID string, target Copy) ([]storage.Op, error) {
+	g, err := NewGenerator(snapshotBlob, ops, target.Actor)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.DuplicateList(sourceListID, target); err != nil {
+		return nil, err
+	}
+	return g.Ops(), nil
+}
+
+// DuplicateList generates the ops of Duplicate with the generator's actor;
+// target.Actor is ignored.
+func (g *Generator) DuplicateList(sourceListID string, target Copy) error {
+	source, ok := g.State().FindList(sourceListID)
+	if !ok {
+		return ErrListNotFound
+	}
+	title := target.Title
+	if title == "" {
+		title = source.Title
+	}
+	if err := g.CreateList(target.ListID, title); err != nil {
+		return err
+	}
+	items := make([]NewItem, 0, len(source.Items))
+	for _, item := range source.Items {
+		items = append(items, NewItem{ID: target.NewItemID(), Text: item.Text, Note: item.Note})
+	}
+	return g.AppendItems(target.ListID, items)
+}
@@ -0,0 +1,76 @@
+package materialize
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestDuplicateCopiesItemsUncheckedAfterLastList(t *testing.T) {
+	ops := []storage.Op{
+		{Scope: "registry", Resource: "registry", Actor: "a", Clock: 1, Payload: []byte(`{"type":"createList","listId":"list-1","payload":{"title":"Packing","pos":[{"digit":512,"actor":"a"}]}}`)},
+		{Scope: "registry", Resource: "registry", Actor: "a", Clock: 2, Payload: []byte(`{"type":"createList","listId":"list-2","payload":{"title":"Other","pos":[{"digit":768,"actor":"a"}]}}`)},
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 3, Payload: []byte(`{"type":"insert","itemId":"item-1","payload":{"data":{"text":"passport","note":"check expiry"},"pos":[{"digit":512,"actor":"a"}]}}`)},
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 4, Payload: []byte(`{"type":"insert","itemId":"item-2","payload":{"data":{"text":"charger"},"pos":[{"digit":768,"actor":"a"}]}}`)},
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 9, Payload: []byte(`{"type":"update","itemId":"item-1","payload":{"data":{"done":true}}}`)},
+	}
+	next := 0
+	copied, err := Duplicate("", ops, "list-1", Copy{
+		ListID: "list-3",
+		Actor:  "server-1",
+		NewItemID: func() string {
+			next++
+			return fmt.Sprintf("copy-%d", next)
+		},
+	})
+	if err != nil {
+		t.Fatalf("duplicate: %v", err)
+	}
+	if len(copied) != 3 {
+		t.Fatalf("expected a create and two inserts, got %d ops", len(copied))
+	}
+	for _, op := range copied {
+		if err := storage.ValidateOp(op); err != nil {
+			t.Fatalf("invalid op %s: %v", op.Payload, err)
+		}
+		if op.Clock <= 9 {
+			t.Fatalf("expected clocks above existing ones, got %d", op.Clock)
+		}
+	}
+
+	state, err := Build("", append(ops, copied...))
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if len(state.Lists) != 3 || state.Lists[2].ID != "list-3" || state.Lists[2].Title != "Packing" {
+		t.Fatalf("expected the copy to be appended as Packing, got %+v", state.Lists)
+	}
+	items := state.Lists[2].Items
+	if len(items) != 2 || items[0].Text != "passport" || items[0].Note != "check expiry" || items[1].Text != "charger" {
+		t.Fatalf("unexpected copied items: %+v", items)
+	}
+	if items[0].Done || items[1].Done {
+		t.Fatalf("expected copied items to be unchecked: %+v", items)
+	}
+	if source, _ := state.FindList("list-1"); !source.Items[0].Done {
+		t.Fatalf("source list must be unchanged: %+v", source)
+	}
+
+	if _, err := Duplicate("", ops, "missing", Copy{ListID: "list-4", Actor: "server-1", NewItemID: func() string { return "x" }}); !errors.Is(err, ErrListNotFound) {
+		t.Fatalf("expected ErrListNotFound, got %v", err)
+	}
+}
+
+func TestSpreadPositionKeepsOrderBeyondOneLevel(t *testing.T) {
+	const n = 2000
+	previous := spreadPosition(0, n, "a")
+	for i := 1; i < n; i++ {
+		current := spreadPosition(i, n, "a")
+		if comparePositions(previous, current) >= 0 {
+			t.Fatalf("position %d does not sort after %d: %v vs %v", i, i-1, current, previous)
+		}
+		previous = current
+	}
+}
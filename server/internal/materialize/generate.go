@@ -0,0 +1,231 @@
+package materialize
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+// ErrItemNotFound is returned when the requested item is not visible in its
+// list.
+var ErrItemNotFound = errors.New("item not found")
+
+// NewItem is an item appended by Generator.AppendItems.
+type NewItem struct {
+	ID   string
+	Text string
+	Note string
+	Done bool
+	Tags []string
+}
+
+// ItemChange is a partial update of an item's data; nil fields stay as they
+// are.
+type ItemChange struct {
+	Text *string
+	Note *string
+	Done *bool
+}
+
+// Generator produces ops on behalf of the server, using the same shapes as
+// client ops. Every op is signed by a single actor, which must be unused by any
+// client, and clocked above every clock seen so far, so generated ops cannot
+// collide with client ops. Each generated op is applied to the generator's own
+// state, so later calls see earlier ones.
+type Generator struct {
+	b     *builder
+	actor string
+	clock int64
+	ops   []storage.Op
+}
+
+// NewGenerator replays ops (in serverSeq order) on top of the snapshot blob.
+func NewGenerator(snapshotBlob string, ops []storage.Op, actor string) (*Generator, error) {
+	b := &builder{lists: make(map[string]*listEntry)}
+	if err := b.loadSnapshot(snapshotBlob); err != nil {
+		return nil, err
+	}
+	g := &Generator{b: b, actor: actor}
+	for _, op := range ops {
+		g.Observe(op)
+	}
+	return g, nil
+}
+
+// Observe applies an op from the log and raises the clock above it, so ops
+// can be streamed in (in serverSeq order) instead of passed to NewGenerator.
+// It must not be called once ops were generated.
+func (g *Generator) Observe(op storage.Op) {
+	g.b.apply(op)
+	g.clock = max(g.clock, op.Clock)
+}
+
+// State returns the materialized state including the ops generated so far.
+func (g *Generator) State() State {
+	return g.b.state()
+}
+
+// Ops returns the ops generated so far, in order.
+func (g *Generator) Ops() []storage.Op {
+	return g.ops
+}
+
+// CreateList appends a createList op that places the list after the last
+// visible list.
+func (g *Generator) CreateList(listID string, title string) error {
+	var lastListPos position
+	if lists := g.b.state().Lists; len(lists) > 0 {
+		lastListPos = g.b.lists[lists[len(lists)-1].ID].pos
+	}
+	pos := appendPosition(lastListPos)
+	pos[len(pos)-1].Actor = g.actor
+	return g.emit(ScopeRegistry, ResourceRegistry, map[string]any{
+		"type":    "createList",
+		"itemId":  listID,
+		"listId":  listID,
+		"payload": map[string]any{"title": title, "pos": pos},
+	})
+}
+
+// AppendItems appends insert ops that place items, in order, after the last
+// visible item of listID, each followed by an addTag op per tag.
+func (g *Generator) AppendItems(listID string, items []NewItem) error {
+	le, ok := g.visibleList(listID)
+	if !ok {
+		return ErrListNotFound
+	}
+	var last *itemEntry
+	for _, ie := range le.items {
+		if !ie.deleted && (last == nil || compareEntries(ie.entry, last.entry) > 0) {
+			last = ie
+		}
+	}
+	var lastPos position
+	if last != nil {
+		lastPos = last.pos
+	}
+	for i, item := range items {
+		if err := g.emit(ScopeList, listID, map[string]any{
+			"type":   "insert",
+			"itemId": item.ID,
+			"payload": map[string]any{
+				"data": map[string]any{"text": item.Text, "done": item.Done, "note": item.Note},
+				"pos":  spreadAfter(lastPos, i, len(items), g.actor),
+			},
+		}); err != nil {
+			return err
+		}
+		for _, tag := range item.Tags {
+			if err := g.emit(ScopeList, listID, map[string]any{
+				"type":    "addTag",
+				"itemId":  item.ID,
+				"payload": map[string]any{"tag": tag},
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// UpdateItem appends an update op carrying only the fields set in change.
+func (g *Generator) UpdateItem(listID string, itemID string, change ItemChange) error {
+	le, ok := g.visibleList(listID)
+	if !ok {
+		return ErrListNotFound
+	}
+	if ie, ok := le.items[itemID]; !ok || ie.deleted {
+		return ErrItemNotFound
+	}
+	data := make(map[string]any)
+	if change.Text != nil {
+		data["text"] = *change.Text
+	}
+	if change.Note != nil {
+		data["note"] = *change.Note
+	}
+	if change.Done != nil {
+		data["done"] = *change.Done
+	}
+	if len(data) == 0 {
+		return errors.New("item update requires at least one field")
+	}
+	return g.emit(ScopeList, listID, map[string]any{
+		"type":    "update",
+		"itemId":  itemID,
+		"payload": map[string]any{"data": data},
+	})
+}
+
+// RemoveItem appends a remove op for a visible item.
+func (g *Generator) RemoveItem(listID string, itemID string) error {
+	le, ok := g.visibleList(listID)
+	if !ok {
+		return ErrListNotFound
+	}
+	if ie, ok := le.items[itemID]; !ok || ie.deleted {
+		return ErrItemNotFound
+	}
+	return g.emit(ScopeList, listID, map[string]any{
+		"type":   "remove",
+		"itemId": itemID,
+	})
+}
+
+func (g *Generator) visibleList(listID string) (*listEntry, bool) {
+	le, ok := g.b.lists[listID]
+	if !ok || !le.registered || le.deleted {
+		return nil, false
+	}
+	return le, true
+}
+
+// emit signs and clocks payload the way a client does (the whole op is the
+// payload) and applies the op.
+func (g *Generator) emit(scope string, resource string, payload map[string]any) error {
+	g.clock++
+	payload["actor"] = g.actor
+	payload["clock"] = g.clock
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encode op: %w", err)
+	}
+	op := storage.Op{Scope: scope, Resource: resource, Actor: g.actor, Clock: g.clock, Payload: encoded}
+	g.b.apply(op)
+	g.ops = append(g.ops, op)
+	return nil
+}
+
+// spreadPosition returns the position of entry i out of n, spacing all n
+// evenly through the digit space (using as many levels as needed) so none of
+// them collide.
+func spreadPosition(i int, n int, actor string) position {
+	levels, space := 1, int64(positionBase)
+	for space <= int64(n) && levels < positionDepth {
+		levels++
+		space *= positionBase
+	}
+	value := int64(i+1) * space / int64(n+1)
+	pos := make(position, levels)
+	for level := levels - 1; level >= 0; level-- {
+		pos[level] = positionComponent{Digit: value % positionBase, Actor: actor}
+		value /= positionBase
+	}
+	return pos
+}
+
+// spreadAfter is spreadPosition for n entries that must sort after previous:
+// they share the first-level digits above previous when there are enough of
+// them, and are nested under previous otherwise.
+func spreadAfter(previous position, i int, n int, actor string) position {
+	if len(previous) == 0 {
+		return spreadPosition(i, n, actor)
+	}
+	first := previous[0].Digit
+	if free := positionBase - first; free > int64(n) {
+		return position{{Digit: first + int64(i+1)*free/int64(n+1), Actor: actor}}
+	}
+	return append(append(position{}, previous...), spreadPosition(i, n, actor)...)
+}
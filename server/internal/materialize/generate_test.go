@@ -0,0 +1,66 @@
+package materialize
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestGeneratorAppendsAfterLastItemAndUpdates(t *testing.T) {
+	ops := []storage.Op{
+		{Scope: "registry", Resource: "registry", Actor: "a", Clock: 1, Payload: []byte(`{"type":"createList","listId":"list-1","payload":{"title":"Chores","pos":[{"digit":512,"actor":"a"}]}}`)},
+		// Near the end of the first level, so the appended items must nest.
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 2, Payload: []byte(`{"type":"insert","itemId":"item-1","payload":{"data":{"text":"dishes"},"pos":[{"digit":1020,"actor":"a"}]}}`)},
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 3, Payload: []byte(`{"type":"insert","itemId":"item-0","payload":{"data":{"text":"laundry"},"pos":[{"digit":100,"actor":"a"}]}}`)},
+	}
+	g, err := NewGenerator("", ops, "server-1")
+	if err != nil {
+		t.Fatalf("new generator: %v", err)
+	}
+	items := make([]NewItem, 0, 10)
+	for i := range 10 {
+		items = append(items, NewItem{ID: fmt.Sprintf("new-%d", i), Text: fmt.Sprintf("task %d", i)})
+	}
+	items[0].Tags = []string{"#Fridge"}
+	if err := g.AppendItems("list-1", items); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	done := true
+	if err := g.UpdateItem("list-1", "new-3", ItemChange{Done: &done}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if err := g.UpdateItem("list-1", "missing", ItemChange{Done: &done}); !errors.Is(err, ErrItemNotFound) {
+		t.Fatalf("expected ErrItemNotFound, got %v", err)
+	}
+	if err := g.AppendItems("list-9", items); !errors.Is(err, ErrListNotFound) {
+		t.Fatalf("expected ErrListNotFound, got %v", err)
+	}
+	if err := g.RemoveItem("list-1", "new-9"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := g.RemoveItem("list-1", "new-9"); !errors.Is(err, ErrItemNotFound) {
+		t.Fatalf("expected ErrItemNotFound for a removed item, got %v", err)
+	}
+	if len(g.Ops()) != 13 {
+		t.Fatalf("expected 13 ops, got %d", len(g.Ops()))
+	}
+
+	state, err := Build("", append(ops, g.Ops()...))
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	got := state.Lists[0].Items
+	if len(got) != 11 || got[0].ID != "item-0" || got[1].ID != "item-1" {
+		t.Fatalf("unexpected items: %+v", got)
+	}
+	for i, item := range got[2:] {
+		if item.ID != fmt.Sprintf("new-%d", i) || item.Done != (i == 3) {
+			t.Fatalf("unexpected appended item %d: %+v", i, item)
+		}
+	}
+	if len(got[2].Tags) != 1 || got[2].Tags[0] != "fridge" {
+		t.Fatalf("expected the first appended item to be tagged, got %v", got[2].Tags)
+	}
+}
@@ -0,0 +1,580 @@
+// Package materialize rebuilds the current list/item state from a snapshot blob
+// and the op log replayed on top of it.
+//
+// The sync protocol treats payloads as opaque, but several server features
+// (tags, read APIs, summaries) need a view of the current data. This package is
+// the single place that interprets CRDT payloads on the server. It is
+// intentionally forgiving: malformed ops are skipped rather than failing the
+// whole build. Snapshot entries get the same position digits the client assigns
+// on import (only the actor tie-break differs), so ops positioned relative to
+// them order the same way they do on clients.
+package materialize
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+// SnapshotSchema is the export snapshot schema id understood by Build.
+const SnapshotSchema = "net.aggregat4.tasklist.snapshot@v1"
+
+// Item is the materialized state of a single task.
+type Item struct {
+	ID     string   `json:"id"`
+	ListID string   `json:"listId"`
+	Text   string   `json:"text"`
+	Done   bool     `json:"done"`
+	Note   string   `json:"note"`
+	Tags   []string `json:"tags"`
+	// Comments are in the order they were made.
+	Comments []Comment `json:"comments,omitempty"`
+}
+
+// Comment is a remark on an item, made through a "comment" scope op.
+type Comment struct {
+	ID    string `json:"id"`
+	Text  string `json:"text"`
+	Actor string `json:"actor"`
+	Clock int64  `json:"clock"`
+}
+
+// List is the materialized state of a list with its visible items in order.
+type List struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Items []Item `json:"items"`
+}
+
+// State is the materialized view of a user's active dataset generation.
+type State struct {
+	Lists []List `json:"lists"`
+}
+
+// FindList returns the list with the given id.
+func (s State) FindList(listID string) (List, bool) {
+	for _, list := range s.Lists {
+		if list.ID == listID {
+			return list, true
+		}
+	}
+	return List{}, false
+}
+
+// Items returns all visible items across all lists in list order.
+func (s State) Items() []Item {
+	items := make([]Item, 0)
+	for _, list := range s.Lists {
+		items = append(items, list.Items...)
+	}
+	return items
+}
+
+type position []positionComponent
+
+type positionComponent struct {
+	Digit int64  `json:"digit"`
+	Actor string `json:"actor"`
+}
+
+type stamp struct {
+	clock int64
+	actor string
+}
+
+func (s stamp) after(other stamp) bool {
+	if s.clock != other.clock {
+		return s.clock > other.clock
+	}
+	return s.actor >= other.actor
+}
+
+type entry struct {
+	id      string
+	pos     position
+	deleted bool
+	updated stamp
+}
+
+type itemEntry struct {
+	entry
+	text string
+	done bool
+	note string
+	tags []string
+}
+
+type commentEntry struct {
+	itemID  string
+	text    string
+	at      stamp
+	deleted bool
+}
+
+type listEntry struct {
+	entry
+	title        string
+	titleUpdated stamp
+	registered   bool
+	items        map[string]*itemEntry
+	comments     map[string]*commentEntry
+	lastItemPos  position
+}
+
+type builder struct {
+	lists       map[string]*listEntry
+	lastListPos position
+}
+
+// Build replays ops (in serverSeq order) on top of the snapshot blob.
+func Build(snapshotBlob string, ops []storage.Op) (State, error) {
+	state, _, err := BuildChecked(snapshotBlob, ops)
+	return state, err
+}
+
+// Rejection is an op a build skipped because it could not be applied.
+type Rejection struct {
+	Op     storage.Op
+	Reason string
+}
+
+// BuildChecked is Build that also reports the ops it skipped: ops of a scope
+// this package interprets whose payload does not decode, and ops that fail
+// while being applied. Ops that merely have no effect are not reported.
+func BuildChecked(snapshotBlob string, ops []storage.Op) (State, []Rejection, error) {
+	replay, err := NewReplay(snapshotBlob)
+	if err != nil {
+		return State{}, nil, err
+	}
+	for _, op := range ops {
+		replay.Apply(op)
+	}
+	return replay.State(), replay.Rejections(), nil
+}
+
+// Replay is BuildChecked one op at a time, for callers that stream the op
+// log (storage.Store.ForEachOpSince) instead of loading it.
+type Replay struct {
+	builder    *builder
+	rejections []Rejection
+}
+
+// NewReplay starts a replay on top of the snapshot blob.
+func NewReplay(snapshotBlob string) (*Replay, error) {
+	b := &builder{lists: make(map[string]*listEntry)}
+	if err := b.loadSnapshot(snapshotBlob); err != nil {
+		return nil, err
+	}
+	return &Replay{builder: b}, nil
+}
+
+// Apply replays the next op, in serverSeq order. It reports whether the op
+// applied; rejected ops are kept for Rejections.
+func (r *Replay) Apply(op storage.Op) bool {
+	if err := r.builder.apply(op); err != nil {
+		r.rejections = append(r.rejections, Rejection{Op: op, Reason: err.Error()})
+		return false
+	}
+	return true
+}
+
+// State returns the state after the ops applied so far.
+func (r *Replay) State() State {
+	return r.builder.state()
+}
+
+// Rejections returns the ops skipped so far.
+func (r *Replay) Rejections() []Rejection {
+	return r.rejections
+}
+
+type snapshotDocument struct {
+	Schema string `json:"schema"`
+	Data   struct {
+		Lists []struct {
+			ListID string `json:"listId"`
+			Title  string `json:"title"`
+			Items  []struct {
+				ID       string   `json:"id"`
+				Text     string   `json:"text"`
+				Done     bool     `json:"done"`
+				Note     string   `json:"note"`
+				Tags     []string `json:"tags"`
+				Comments []struct {
+					ID    string `json:"id"`
+					Text  string `json:"text"`
+					Actor string `json:"actor"`
+					Clock int64  `json:"clock"`
+				} `json:"comments"`
+			} `json:"items"`
+		} `json:"lists"`
+	} `json:"data"`
+}
+
+func (b *builder) loadSnapshot(blob string) error {
+	if strings.TrimSpace(blob) == "" {
+		return nil
+	}
+	var doc snapshotDocument
+	if err := json.Unmarshal([]byte(blob), &doc); err != nil {
+		return err
+	}
+	for _, list := range doc.Data.Lists {
+		if list.ListID == "" {
+			continue
+		}
+		le := b.ensureList(list.ListID)
+		le.title = list.Title
+		le.registered = true
+		for _, item := range list.Items {
+			if item.ID == "" {
+				continue
+			}
+			ie := le.ensureItem(item.ID)
+			ie.text = item.Text
+			ie.done = item.Done
+			ie.note = item.Note
+			for _, tag := range item.Tags {
+				ie.addTag(tag)
+			}
+			for _, comment := range item.Comments {
+				if comment.ID != "" {
+					le.comments[comment.ID] = &commentEntry{itemID: item.ID, text: comment.Text, at: stamp{clock: comment.Clock, actor: comment.Actor}}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+type opPayload struct {
+	Type      string `json:"type"`
+	ListID    string `json:"listId"`
+	ItemID    string `json:"itemId"`
+	CommentID string `json:"commentId"`
+	Payload   struct {
+		Title *string  `json:"title"`
+		Pos   position `json:"pos"`
+		Tag   string   `json:"tag"`
+		Data  *struct {
+			Text *string `json:"text"`
+			Done *bool   `json:"done"`
+			Note *string `json:"note"`
+		} `json:"data"`
+		Text *string `json:"text"`
+		Done *bool   `json:"done"`
+		Note *string `json:"note"`
+	} `json:"payload"`
+}
+
+// apply replays one op. A failure leaves the ops applied so far in place.
+func (b *builder) apply(op storage.Op) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("apply %s op: %v", op.Scope, recovered)
+		}
+	}()
+	// Scopes the server does not interpret stay opaque.
+	s, ok := scopes[op.Scope]
+	if !ok || s.apply == nil {
+		return nil
+	}
+	var payload opPayload
+	if err := json.Unmarshal(op.Payload, &payload); err != nil {
+		return fmt.Errorf("decode %s payload: %w", op.Scope, err)
+	}
+	s.apply(b, op, payload, stamp{clock: op.Clock, actor: op.Actor})
+	return nil
+}
+
+func (b *builder) applyRegistry(payload opPayload, at stamp) {
+	listID := payload.ListID
+	if listID == "" {
+		listID = payload.ItemID
+	}
+	if listID == "" {
+		return
+	}
+	switch payload.Type {
+	case "createList":
+		le := b.ensureList(listID)
+		le.registered = true
+		if payload.Payload.Title != nil && at.after(le.titleUpdated) {
+			le.title = *payload.Payload.Title
+			le.titleUpdated = at
+		}
+		if len(payload.Payload.Pos) > 0 {
+			le.pos = payload.Payload.Pos
+		}
+	case "renameList":
+		le := b.ensureList(listID)
+		if payload.Payload.Title != nil && at.after(le.titleUpdated) {
+			le.title = *payload.Payload.Title
+			le.titleUpdated = at
+		}
+	case "reorderList":
+		le := b.ensureList(listID)
+		if len(payload.Payload.Pos) > 0 && at.after(le.updated) {
+			le.pos = payload.Payload.Pos
+			le.updated = at
+		}
+	case "removeList":
+		if le, ok := b.lists[listID]; ok {
+			le.deleted = true
+		}
+	}
+}
+
+func (b *builder) applyList(listID string, payload opPayload, at stamp) {
+	if listID == "" {
+		return
+	}
+	le := b.ensureList(listID)
+	if payload.Type == "renameList" {
+		if payload.Payload.Title != nil && at.after(le.titleUpdated) {
+			le.title = *payload.Payload.Title
+			le.titleUpdated = at
+		}
+		return
+	}
+	if payload.ItemID == "" {
+		return
+	}
+	text, done, note := payload.Payload.Text, payload.Payload.Done, payload.Payload.Note
+	if data := payload.Payload.Data; data != nil {
+		text, done, note = data.Text, data.Done, data.Note
+	}
+	switch payload.Type {
+	case "insert":
+		if existing, ok := le.items[payload.ItemID]; ok && existing.deleted {
+			return
+		}
+		ie := le.ensureItem(payload.ItemID)
+		if len(payload.Payload.Pos) > 0 {
+			ie.pos = payload.Payload.Pos
+		}
+		ie.setData(text, done, note, at)
+	case "update":
+		if ie, ok := le.items[payload.ItemID]; ok {
+			ie.setData(text, done, note, at)
+		}
+	case "move":
+		if ie, ok := le.items[payload.ItemID]; ok && len(payload.Payload.Pos) > 0 {
+			ie.pos = payload.Payload.Pos
+		}
+	case "remove":
+		if ie, ok := le.items[payload.ItemID]; ok {
+			ie.deleted = true
+		}
+	case "addTag":
+		if ie, ok := le.items[payload.ItemID]; ok {
+			ie.addTag(payload.Payload.Tag)
+		}
+	case "removeTag":
+		if ie, ok := le.items[payload.ItemID]; ok {
+			ie.removeTag(payload.Payload.Tag)
+		}
+	}
+}
+
+// applyComment adds or removes a comment on an item of listID. A removal that
+// arrives before its add leaves a tombstone so the comment stays removed.
+func (b *builder) applyComment(listID string, payload opPayload, at stamp) {
+	if listID == "" || payload.CommentID == "" {
+		return
+	}
+	le := b.ensureList(listID)
+	ce, ok := le.comments[payload.CommentID]
+	switch payload.Type {
+	case "addComment":
+		if ok || payload.ItemID == "" || payload.Payload.Text == nil {
+			return
+		}
+		le.comments[payload.CommentID] = &commentEntry{itemID: payload.ItemID, text: *payload.Payload.Text, at: at}
+	case "removeComment":
+		if !ok {
+			le.comments[payload.CommentID] = &commentEntry{deleted: true}
+			return
+		}
+		ce.deleted = true
+	}
+}
+
+func (b *builder) ensureList(listID string) *listEntry {
+	if le, ok := b.lists[listID]; ok {
+		return le
+	}
+	b.lastListPos = appendPosition(b.lastListPos)
+	le := &listEntry{
+		entry:    entry{id: listID, pos: b.lastListPos},
+		items:    make(map[string]*itemEntry),
+		comments: make(map[string]*commentEntry),
+	}
+	b.lists[listID] = le
+	return le
+}
+
+func (le *listEntry) ensureItem(itemID string) *itemEntry {
+	if ie, ok := le.items[itemID]; ok {
+		return ie
+	}
+	le.lastItemPos = appendPosition(le.lastItemPos)
+	ie := &itemEntry{entry: entry{id: itemID, pos: le.lastItemPos}}
+	le.items[itemID] = ie
+	return ie
+}
+
+func (ie *itemEntry) setData(text *string, done *bool, note *string, at stamp) {
+	if !at.after(ie.updated) {
+		return
+	}
+	if text != nil {
+		ie.text = *text
+	}
+	if done != nil {
+		ie.done = *done
+	}
+	if note != nil {
+		ie.note = *note
+	}
+	ie.updated = at
+}
+
+func (ie *itemEntry) addTag(tag string) {
+	tag = storage.NormalizeTag(tag)
+	if tag == "" || slices.Contains(ie.tags, tag) {
+		return
+	}
+	ie.tags = append(ie.tags, tag)
+}
+
+func (ie *itemEntry) removeTag(tag string) {
+	tag = storage.NormalizeTag(tag)
+	ie.tags = slices.DeleteFunc(ie.tags, func(existing string) bool { return existing == tag })
+}
+
+func (b *builder) state() State {
+	lists := make([]*listEntry, 0, len(b.lists))
+	for _, le := range b.lists {
+		if le.registered && !le.deleted {
+			lists = append(lists, le)
+		}
+	}
+	slices.SortFunc(lists, func(a, b *listEntry) int { return compareEntries(a.entry, b.entry) })
+	state := State{Lists: make([]List, 0, len(lists))}
+	for _, le := range lists {
+		items := make([]*itemEntry, 0, len(le.items))
+		for _, ie := range le.items {
+			if !ie.deleted {
+				items = append(items, ie)
+			}
+		}
+		slices.SortFunc(items, func(a, b *itemEntry) int { return compareEntries(a.entry, b.entry) })
+		comments := le.itemComments()
+		list := List{ID: le.id, Title: le.title, Items: make([]Item, 0, len(items))}
+		for _, ie := range items {
+			tags := slices.Clone(ie.tags)
+			if tags == nil {
+				tags = []string{}
+			}
+			list.Items = append(list.Items, Item{
+				ID:       ie.id,
+				ListID:   le.id,
+				Text:     ie.text,
+				Done:     ie.done,
+				Note:     ie.note,
+				Tags:     tags,
+				Comments: comments[ie.id],
+			})
+		}
+		state.Lists = append(state.Lists, list)
+	}
+	return state
+}
+
+// itemComments groups the visible comments by item, oldest first.
+func (le *listEntry) itemComments() map[string][]Comment {
+	ids := make([]string, 0, len(le.comments))
+	for id, ce := range le.comments {
+		if !ce.deleted {
+			ids = append(ids, id)
+		}
+	}
+	slices.SortFunc(ids, func(a, b string) int {
+		left, right := le.comments[a].at, le.comments[b].at
+		if left.clock != right.clock {
+			return cmp.Compare(left.clock, right.clock)
+		}
+		if c := strings.Compare(left.actor, right.actor); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+	grouped := make(map[string][]Comment)
+	for _, id := range ids {
+		ce := le.comments[id]
+		grouped[ce.itemID] = append(grouped[ce.itemID], Comment{ID: id, Text: ce.text, Actor: ce.at.actor, Clock: ce.at.clock})
+	}
+	return grouped
+}
+
+func compareEntries(a, b entry) int {
+	if cmp := comparePositions(a.pos, b.pos); cmp != 0 {
+		return cmp
+	}
+	return strings.Compare(a.id, b.id)
+}
+
+func comparePositions(a, b position) int {
+	for i := 0; i < max(len(a), len(b)); i++ {
+		var left, right positionComponent
+		if i < len(a) {
+			left = a[i]
+		}
+		if i < len(b) {
+			right = b[i]
+		}
+		if left.Digit != right.Digit {
+			if left.Digit < right.Digit {
+				return -1
+			}
+			return 1
+		}
+		if cmp := strings.Compare(left.Actor, right.Actor); cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+const (
+	positionBase  = 1024
+	positionDepth = 6
+)
+
+// appendPosition mirrors the client's between(previous, null) used when a
+// snapshot is imported: each entry lands halfway between its predecessor and
+// the end of the digit space. The importing actor is unknown here, so the actor
+// component stays empty and only acts as a tie-break against op positions.
+func appendPosition(previous position) position {
+	result := make(position, 0, len(previous)+1)
+	for level := range positionDepth {
+		var leftDigit int64
+		if level < len(previous) {
+			leftDigit = previous[level].Digit
+		}
+		if positionBase-leftDigit > 1 {
+			return append(result, positionComponent{Digit: (leftDigit + positionBase) / 2})
+		}
+		if level < len(previous) {
+			result = append(result, previous[level])
+		} else {
+			result = append(result, positionComponent{Digit: leftDigit})
+		}
+	}
+	return append(result, positionComponent{Digit: positionBase / 2})
+}
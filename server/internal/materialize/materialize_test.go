@@ -0,0 +1,182 @@
+package materialize
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+const snapshotBlob = `{"schema":"net.aggregat4.tasklist.snapshot@v1","data":{"lists":[{"listId":"list-1","title":"Inbox","items":[{"id":"item-1","text":"first","done":false},{"id":"item-2","text":"second","done":true,"tags":["Home"]}]}]}}`
+
+func TestBuildFromSnapshot(t *testing.T) {
+	state, err := Build(snapshotBlob, nil)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	list, ok := state.FindList("list-1")
+	if !ok {
+		t.Fatalf("list-1 missing")
+	}
+	if list.Title != "Inbox" || len(list.Items) != 2 {
+		t.Fatalf("unexpected list: %+v", list)
+	}
+	if list.Items[0].ID != "item-1" || list.Items[1].Tags[0] != "home" {
+		t.Fatalf("unexpected items: %+v", list.Items)
+	}
+}
+
+func TestBuildAppliesOps(t *testing.T) {
+	ops := []storage.Op{
+		{Scope: "registry", Resource: "registry", Actor: "a", Clock: 1, Payload: []byte(`{"type":"createList","listId":"list-2","payload":{"title":"Work","pos":[{"digit":512,"actor":"a"}]}}`)},
+		{Scope: "list", Resource: "list-2", Actor: "a", Clock: 2, Payload: []byte(`{"type":"insert","itemId":"w-2","payload":{"data":{"text":"later"},"pos":[{"digit":600,"actor":"a"}]}}`)},
+		{Scope: "list", Resource: "list-2", Actor: "a", Clock: 3, Payload: []byte(`{"type":"insert","itemId":"w-1","payload":{"data":{"text":"sooner"},"pos":[{"digit":100,"actor":"a"}]}}`)},
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 4, Payload: []byte(`{"type":"update","itemId":"item-1","payload":{"data":{"done":true}}}`)},
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 5, Payload: []byte(`{"type":"remove","itemId":"item-2"}`)},
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 6, Payload: []byte(`not json`)},
+	}
+	state, err := Build(snapshotBlob, ops)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if len(state.Lists) != 2 {
+		t.Fatalf("lists: got %d", len(state.Lists))
+	}
+	inbox, _ := state.FindList("list-1")
+	if len(inbox.Items) != 1 || !inbox.Items[0].Done {
+		t.Fatalf("unexpected inbox: %+v", inbox)
+	}
+	work, _ := state.FindList("list-2")
+	if len(work.Items) != 2 || work.Items[0].ID != "w-1" {
+		t.Fatalf("unexpected work order: %+v", work.Items)
+	}
+}
+
+func TestBuildIgnoresStaleUpdates(t *testing.T) {
+	ops := []storage.Op{
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 5, Payload: []byte(`{"type":"update","itemId":"item-1","payload":{"data":{"text":"newer"}}}`)},
+		{Scope: "list", Resource: "list-1", Actor: "b", Clock: 3, Payload: []byte(`{"type":"update","itemId":"item-1","payload":{"data":{"text":"older"}}}`)},
+	}
+	state, err := Build(snapshotBlob, ops)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	inbox, _ := state.FindList("list-1")
+	if inbox.Items[0].Text != "newer" {
+		t.Fatalf("stale update applied: %+v", inbox.Items[0])
+	}
+}
+
+func TestEncodeSnapshotRoundTrip(t *testing.T) {
+	state, err := Build(snapshotBlob, nil)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	blob, err := EncodeSnapshot(state, time.Unix(0, 0))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	again, err := Build(blob, nil)
+	if err != nil {
+		t.Fatalf("rebuild: %v", err)
+	}
+	if !reflect.DeepEqual(state, again) {
+		t.Fatalf("round trip mismatch:\n%+v\n%+v", state, again)
+	}
+}
+
+func TestBuildAppliesCommentsAndKeepsThemInSnapshots(t *testing.T) {
+	ops := []storage.Op{
+		{Scope: "comment", Resource: "list-1", Actor: "b", Clock: 5, Payload: []byte(`{"type":"addComment","itemId":"item-1","commentId":"c-2","payload":{"text":"second"}}`)},
+		{Scope: "comment", Resource: "list-1", Actor: "a", Clock: 4, Payload: []byte(`{"type":"addComment","itemId":"item-1","commentId":"c-1","payload":{"text":"first"}}`)},
+		// The removal of c-3 arrives before its add and must win.
+		{Scope: "comment", Resource: "list-1", Actor: "a", Clock: 7, Payload: []byte(`{"type":"removeComment","commentId":"c-3"}`)},
+		{Scope: "comment", Resource: "list-1", Actor: "a", Clock: 6, Payload: []byte(`{"type":"addComment","itemId":"item-2","commentId":"c-3","payload":{"text":"gone"}}`)},
+	}
+	state, err := Build(snapshotBlob, ops)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	want := []Comment{{ID: "c-1", Text: "first", Actor: "a", Clock: 4}, {ID: "c-2", Text: "second", Actor: "b", Clock: 5}}
+	list, _ := state.FindList("list-1")
+	if !reflect.DeepEqual(list.Items[0].Comments, want) || len(list.Items[1].Comments) != 0 {
+		t.Fatalf("unexpected comments: %+v", list.Items)
+	}
+
+	blob, err := EncodeSnapshot(state, time.Now())
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	restored, err := Build(blob, []storage.Op{
+		{Scope: "comment", Resource: "list-1", Actor: "a", Clock: 8, Payload: []byte(`{"type":"removeComment","commentId":"c-1"}`)},
+	})
+	if err != nil {
+		t.Fatalf("rebuild: %v", err)
+	}
+	list, _ = restored.FindList("list-1")
+	if !reflect.DeepEqual(list.Items[0].Comments, want[1:]) {
+		t.Fatalf("expected comments to survive the snapshot, got %+v", list.Items[0].Comments)
+	}
+}
+
+func TestBuildCheckedReportsUndecodableOps(t *testing.T) {
+	ops := []storage.Op{
+		{ServerSeq: 1, Scope: "list", Resource: "list-1", Actor: "a", Clock: 1, Payload: []byte(`{"type":"update","itemId":"item-1","payload":{"data":{"done":true}}}`)},
+		{ServerSeq: 2, Scope: "list", Resource: "list-1", Actor: "a", Clock: 2, Payload: []byte(`{"type":"update","itemId":42}`)},
+		{ServerSeq: 3, Scope: "registry", Resource: "registry", Actor: "a", Clock: 3, Payload: []byte(`["createList"]`)},
+		// Scopes the server does not interpret stay opaque.
+		{ServerSeq: 4, Scope: "future", Resource: "x", Actor: "a", Clock: 4, Payload: []byte(`[1,2]`)},
+		{ServerSeq: 5, Scope: "list", Resource: "list-1", Actor: "a", Clock: 5, Payload: []byte(`{"type":"unknown","itemId":"item-1"}`)},
+	}
+	state, rejections, err := BuildChecked(snapshotBlob, ops)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if len(rejections) != 2 || rejections[0].Op.ServerSeq != 2 || rejections[1].Op.ServerSeq != 3 || rejections[0].Reason == "" {
+		t.Fatalf("unexpected rejections: %+v", rejections)
+	}
+	list, _ := state.FindList("list-1")
+	if !list.Items[0].Done {
+		t.Fatalf("valid ops must still apply: %+v", list.Items)
+	}
+}
+
+func TestScopesValidateAndNameTheirList(t *testing.T) {
+	op := func(scope, resource, payload string) storage.Op {
+		return storage.Op{Scope: scope, Resource: resource, Actor: "a", Clock: 1, Payload: []byte(payload)}
+	}
+	for _, valid := range []storage.Op{
+		op(ScopeRegistry, ResourceRegistry, `{"type":"createList","listId":"list-1"}`),
+		op(ScopeList, "list-1", `{"type":"update","itemId":42}`),
+		op(ScopeComment, "list-1", `{"type":"addComment"}`),
+	} {
+		if err := ValidateOp(valid); err != nil {
+			t.Errorf("expected %s op to be valid, got %v", valid.Scope, err)
+		}
+	}
+	for _, invalid := range []storage.Op{
+		op("future", "x", `{}`),
+		op(ScopeRegistry, "list-1", `{"type":"createList","listId":"list-1"}`),
+		op(ScopeList, "list-1", `{`),
+	} {
+		if err := ValidateOp(invalid); err == nil {
+			t.Errorf("expected %s op on %s to be rejected", invalid.Scope, invalid.Resource)
+		}
+	}
+
+	for _, tc := range []struct {
+		op   storage.Op
+		want string
+	}{
+		{op(ScopeRegistry, ResourceRegistry, `{"type":"renameList","listId":"list-1"}`), "list-1"},
+		{op(ScopeRegistry, ResourceRegistry, `{"type":"removeList","itemId":"list-2"}`), "list-2"},
+		{op(ScopeRegistry, ResourceRegistry, `["createList"]`), ResourceRegistry},
+		{op(ScopeComment, "list-3", `{"type":"addComment"}`), "list-3"},
+		{op("future", "list-4", `[]`), "list-4"},
+	} {
+		if got := OpListID(tc.op); got != tc.want {
+			t.Errorf("OpListID(%s %s) = %q, want %q", tc.op.Scope, tc.op.Payload, got, tc.want)
+		}
+	}
+}
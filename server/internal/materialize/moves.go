@@ -0,0 +1,69 @@
+package materialize
+
+import (
+	"encoding/json"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+// Move is an item moved between lists within one batch of ops. Clients move an
+// item by removing it from the source list and inserting it, with its data,
+// into the target list.
+type Move struct {
+	ItemID       string
+	SourceListID string
+	TargetListID string
+	// RemoveIndex and InsertIndex locate the two ops in the batch.
+	RemoveIndex int
+	InsertIndex int
+}
+
+// FindMoves pairs each list-scope remove with an insert of the same item into
+// a different list in the same batch, in batch order.
+func FindMoves(ops []storage.Op) []Move {
+	type itemOp struct {
+		listID string
+		index  int
+	}
+	removes := make(map[string][]itemOp)
+	inserts := make(map[string][]itemOp)
+	order := make([]string, 0)
+	for i, op := range ops {
+		if op.Scope != ScopeList {
+			continue
+		}
+		var payload opPayload
+		if err := json.Unmarshal(op.Payload, &payload); err != nil || payload.ItemID == "" {
+			continue
+		}
+		switch payload.Type {
+		case "remove":
+			removes[payload.ItemID] = append(removes[payload.ItemID], itemOp{listID: op.Resource, index: i})
+		case "insert":
+			inserts[payload.ItemID] = append(inserts[payload.ItemID], itemOp{listID: op.Resource, index: i})
+		default:
+			continue
+		}
+		if len(removes[payload.ItemID])+len(inserts[payload.ItemID]) == 1 {
+			order = append(order, payload.ItemID)
+		}
+	}
+	moves := make([]Move, 0)
+	for _, itemID := range order {
+		for _, remove := range removes[itemID] {
+			for _, insert := range inserts[itemID] {
+				if insert.listID != remove.listID {
+					moves = append(moves, Move{
+						ItemID:       itemID,
+						SourceListID: remove.listID,
+						TargetListID: insert.listID,
+						RemoveIndex:  remove.index,
+						InsertIndex:  insert.index,
+					})
+					break
+				}
+			}
+		}
+	}
+	return moves
+}
@@ -0,0 +1,21 @@
+package materialize
+
+import (
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestFindMovesPairsRemoveAndInsertAcrossLists(t *testing.T) {
+	ops := []storage.Op{
+		{Scope: "list", Resource: "list-a", Actor: "a", Clock: 1, Payload: []byte(`{"type":"remove","itemId":"item-1"}`)},
+		{Scope: "list", Resource: "list-a", Actor: "a", Clock: 2, Payload: []byte(`{"type":"insert","itemId":"item-2"}`)},
+		{Scope: "list", Resource: "list-b", Actor: "a", Clock: 3, Payload: []byte(`{"type":"insert","itemId":"item-1"}`)},
+		{Scope: "list", Resource: "list-a", Actor: "a", Clock: 4, Payload: []byte(`{"type":"remove","itemId":"item-2"}`)},
+	}
+	moves := FindMoves(ops)
+	want := Move{ItemID: "item-1", SourceListID: "list-a", TargetListID: "list-b", RemoveIndex: 0, InsertIndex: 2}
+	if len(moves) != 1 || moves[0] != want {
+		t.Fatalf("expected only the cross-list move, got %+v", moves)
+	}
+}
@@ -0,0 +1,118 @@
+package materialize
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+// Op scopes the server accepts.
+const (
+	// ScopeRegistry ops create, rename, reorder and remove lists. Their
+	// resourceId is always ResourceRegistry.
+	ScopeRegistry = "registry"
+	// ScopeList ops change the items of the list named by their resourceId.
+	ScopeList = "list"
+	// ScopeComment ops add and remove comments on the items of the list named
+	// by their resourceId.
+	ScopeComment = "comment"
+)
+
+// ResourceRegistry is the resourceId of registry ops.
+const ResourceRegistry = "registry"
+
+// scope is what the server knows about the ops of one scope.
+type scope struct {
+	// validate checks a pushed op beyond the envelope checks of
+	// storage.ValidateOp. It is optional, and must not reject payloads for
+	// failing to decode: those are stored and quarantined if they keep
+	// failing, so a client bug cannot block a whole push.
+	validate func(op storage.Op) error
+	// apply replays a decoded op. A payload that does not decode rejects the
+	// op.
+	apply func(b *builder, op storage.Op, payload opPayload, at stamp)
+	// listID returns the list an op concerns.
+	listID func(op storage.Op, payload opPayload) string
+}
+
+// scopes registers every scope. A feature that brings a new kind of op, such
+// as attachments, adds its scope here (with the builder method applying it)
+// and does not need to touch push validation or the replay.
+var scopes = map[string]scope{
+	ScopeRegistry: {
+		validate: func(op storage.Op) error {
+			if op.Resource != ResourceRegistry {
+				return fmt.Errorf("invalid op resource: registry ops need resourceId %q, got %q", ResourceRegistry, op.Resource)
+			}
+			return nil
+		},
+		apply: func(b *builder, _ storage.Op, payload opPayload, at stamp) {
+			b.applyRegistry(payload, at)
+		},
+		listID: func(_ storage.Op, payload opPayload) string {
+			// Early clients named the list itemId.
+			if payload.ListID == "" {
+				return payload.ItemID
+			}
+			return payload.ListID
+		},
+	},
+	ScopeList: {
+		apply: func(b *builder, op storage.Op, payload opPayload, at stamp) {
+			b.applyList(op.Resource, payload, at)
+		},
+		listID: resourceList,
+	},
+	ScopeComment: {
+		apply: func(b *builder, op storage.Op, payload opPayload, at stamp) {
+			b.applyComment(op.Resource, payload, at)
+		},
+		listID: resourceList,
+	},
+}
+
+func resourceList(op storage.Op, _ opPayload) string {
+	return op.Resource
+}
+
+// Scopes returns the names of the registered scopes, sorted.
+func Scopes() []string {
+	return slices.Sorted(maps.Keys(scopes))
+}
+
+// ValidateOp checks a pushed op: its envelope (storage.ValidateOp), that its
+// scope is registered, and what the scope requires. Stored ops are not
+// checked again when read, so ops of scopes that are no longer registered
+// stay readable and are replayed as opaque.
+func ValidateOp(op storage.Op) error {
+	if err := storage.ValidateOp(op); err != nil {
+		return err
+	}
+	s, ok := scopes[op.Scope]
+	if !ok {
+		return fmt.Errorf("invalid op scope %q: want one of %s", op.Scope, strings.Join(Scopes(), ", "))
+	}
+	if s.validate != nil {
+		return s.validate(op)
+	}
+	return nil
+}
+
+// OpListID returns the id of the list op concerns, or "" when it names none.
+// Ops of unknown scopes are taken to concern the list named by their
+// resourceId.
+func OpListID(op storage.Op) string {
+	s, ok := scopes[op.Scope]
+	if !ok {
+		return op.Resource
+	}
+	var payload opPayload
+	if err := json.Unmarshal(op.Payload, &payload); err != nil {
+		return resourceList(op, payload)
+	}
+	return s.listID(op, payload)
+}
@@ -0,0 +1,58 @@
+package materialize
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type encodedItem struct {
+	ID       string    `json:"id"`
+	Text     string    `json:"text"`
+	Done     bool      `json:"done"`
+	Note     string    `json:"note"`
+	Tags     []string  `json:"tags,omitempty"`
+	Comments []Comment `json:"comments,omitempty"`
+}
+
+type encodedList struct {
+	ListID string        `json:"listId"`
+	Title  string        `json:"title"`
+	Items  []encodedItem `json:"items"`
+}
+
+type encodedSnapshot struct {
+	Schema     string `json:"schema"`
+	ExportedAt string `json:"exportedAt"`
+	Data       struct {
+		Lists []encodedList `json:"lists"`
+	} `json:"data"`
+}
+
+// EncodeSnapshot renders state as an export snapshot blob (see
+// docs/export-snapshot-spec.md) that clients can restore from.
+func EncodeSnapshot(state State, exportedAt time.Time) (string, error) {
+	doc := encodedSnapshot{
+		Schema:     SnapshotSchema,
+		ExportedAt: exportedAt.UTC().Format("2006-01-02T15:04:05.000Z"),
+	}
+	doc.Data.Lists = make([]encodedList, 0, len(state.Lists))
+	for _, list := range state.Lists {
+		encoded := encodedList{ListID: list.ID, Title: list.Title, Items: make([]encodedItem, 0, len(list.Items))}
+		for _, item := range list.Items {
+			encoded.Items = append(encoded.Items, encodedItem{
+				ID:       item.ID,
+				Text:     item.Text,
+				Done:     item.Done,
+				Note:     item.Note,
+				Tags:     item.Tags,
+				Comments: item.Comments,
+			})
+		}
+		doc.Data.Lists = append(doc.Data.Lists, encoded)
+	}
+	blob, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	return string(blob), nil
+}
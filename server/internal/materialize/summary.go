@@ -0,0 +1,174 @@
+package materialize
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+// ResourceChange counts the ops for one resource and the item changes they
+// carry.
+type ResourceChange struct {
+	Scope      string `json:"scope"`
+	ResourceID string `json:"resourceId"`
+	OpCount    int    `json:"opCount"`
+	// Added counts item inserts.
+	Added int `json:"added"`
+	// Completed counts updates that mark an item done.
+	Completed int `json:"completed"`
+	// Removed counts item removals.
+	Removed int `json:"removed"`
+}
+
+// Summary describes what a batch of ops changed without replaying it.
+type Summary struct {
+	OpCount   int              `json:"opCount"`
+	Resources []ResourceChange `json:"resources"`
+	// Lists holds the ids of lists touched by the ops, either directly (list
+	// scope) or through the registry (create, rename, reorder, remove).
+	Lists []string `json:"lists"`
+}
+
+// Summarize counts ops per resource, sorted by scope and resource id. Lists are
+// in first-touched order. Like Build, it skips payloads it cannot parse rather
+// than failing.
+func Summarize(ops []storage.Op) Summary {
+	summary := Summary{OpCount: len(ops), Resources: make([]ResourceChange, 0), Lists: make([]string, 0)}
+	index := make(map[[2]string]int)
+	lists := make(map[string]struct{})
+	addList := func(listID string) {
+		if _, ok := lists[listID]; listID != "" && !ok {
+			lists[listID] = struct{}{}
+			summary.Lists = append(summary.Lists, listID)
+		}
+	}
+	for _, op := range ops {
+		key := [2]string{op.Scope, op.Resource}
+		i, ok := index[key]
+		if !ok {
+			i = len(summary.Resources)
+			index[key] = i
+			summary.Resources = append(summary.Resources, ResourceChange{Scope: op.Scope, ResourceID: op.Resource})
+		}
+		change := &summary.Resources[i]
+		change.OpCount++
+		var payload opPayload
+		if err := json.Unmarshal(op.Payload, &payload); err != nil {
+			continue
+		}
+		switch op.Scope {
+		case ScopeRegistry:
+			addList(scopes[ScopeRegistry].listID(op, payload))
+		case ScopeList:
+			addList(op.Resource)
+			switch payload.Type {
+			case "insert":
+				change.Added++
+			case "update":
+				done := payload.Payload.Done
+				if payload.Payload.Data != nil {
+					done = payload.Payload.Data.Done
+				}
+				if done != nil && *done {
+					change.Completed++
+				}
+			case "remove":
+				change.Removed++
+			}
+		}
+	}
+	slices.SortStableFunc(summary.Resources, func(a, b ResourceChange) int {
+		if c := strings.Compare(a.Scope, b.Scope); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ResourceID, b.ResourceID)
+	})
+	return summary
+}
+
+// ItemActivity holds the ids of the items a batch of ops added to and
+// completed in one list.
+type ItemActivity struct {
+	ListID    string
+	Added     []string
+	Completed []string
+}
+
+// Activity returns the items inserted and marked done by the ops, per list in
+// first-touched order. Each item appears at most once per kind; whether it
+// still exists is for the caller to check against the materialized state.
+func Activity(ops []storage.Op) []ItemActivity {
+	activity := make([]ItemActivity, 0)
+	index := make(map[string]int)
+	seen := make(map[[3]string]struct{})
+	for _, event := range ItemEvents(ops) {
+		key := [3]string{event.ListID, event.ItemID, event.Kind}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		i, ok := index[event.ListID]
+		if !ok {
+			i = len(activity)
+			index[event.ListID] = i
+			activity = append(activity, ItemActivity{ListID: event.ListID})
+		}
+		if event.Kind == ItemAdded {
+			activity[i].Added = append(activity[i].Added, event.ItemID)
+		} else {
+			activity[i].Completed = append(activity[i].Completed, event.ItemID)
+		}
+	}
+	return activity
+}
+
+// Item event kinds.
+const (
+	ItemAdded     = "added"
+	ItemCompleted = "completed"
+)
+
+// ItemEvent is an item added to, or marked done in, a list by one op.
+type ItemEvent struct {
+	Kind      string
+	ListID    string
+	ItemID    string
+	ServerSeq int64
+}
+
+// ItemEvents returns an event for every insert and every update that marks an
+// item done, in op order.
+func ItemEvents(ops []storage.Op) []ItemEvent {
+	events := make([]ItemEvent, 0)
+	for _, op := range ops {
+		if op.Scope != ScopeList || op.Resource == "" {
+			continue
+		}
+		var payload opPayload
+		if err := json.Unmarshal(op.Payload, &payload); err != nil || payload.ItemID == "" {
+			continue
+		}
+		event := ItemEvent{ListID: op.Resource, ItemID: payload.ItemID, ServerSeq: op.ServerSeq}
+		switch {
+		case payload.Type == "insert":
+			event.Kind = ItemAdded
+		case payload.Type == "update" && payload.marksDone():
+			event.Kind = ItemCompleted
+		default:
+			continue
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// marksDone reports whether the payload sets the item's done flag.
+func (p opPayload) marksDone() bool {
+	done := p.Payload.Done
+	if p.Payload.Data != nil {
+		done = p.Payload.Data.Done
+	}
+	return done != nil && *done
+}
@@ -0,0 +1,55 @@
+package materialize
+
+import (
+	"slices"
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestSummarizeCountsChangesPerResource(t *testing.T) {
+	ops := []storage.Op{
+		{Scope: "registry", Resource: "registry", Actor: "a", Clock: 1, Payload: []byte(`{"type":"createList","listId":"list-2","payload":{"title":"Work"}}`)},
+		{Scope: "list", Resource: "list-2", Actor: "a", Clock: 2, Payload: []byte(`{"type":"insert","itemId":"w-1","payload":{"data":{"text":"a"}}}`)},
+		{Scope: "list", Resource: "list-2", Actor: "a", Clock: 3, Payload: []byte(`{"type":"insert","itemId":"w-2","payload":{"data":{"text":"b"}}}`)},
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 4, Payload: []byte(`{"type":"update","itemId":"item-1","payload":{"data":{"done":true}}}`)},
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 5, Payload: []byte(`{"type":"update","itemId":"item-1","payload":{"data":{"done":false}}}`)},
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 6, Payload: []byte(`{"type":"remove","itemId":"item-2"}`)},
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 7, Payload: []byte(`not json`)},
+	}
+	summary := Summarize(ops)
+	if summary.OpCount != len(ops) {
+		t.Fatalf("op count: got %d", summary.OpCount)
+	}
+	want := []ResourceChange{
+		{Scope: "list", ResourceID: "list-1", OpCount: 4, Completed: 1, Removed: 1},
+		{Scope: "list", ResourceID: "list-2", OpCount: 2, Added: 2},
+		{Scope: "registry", ResourceID: "registry", OpCount: 1},
+	}
+	if !slices.Equal(summary.Resources, want) {
+		t.Fatalf("resources: got %+v", summary.Resources)
+	}
+	if !slices.Equal(summary.Lists, []string{"list-2", "list-1"}) {
+		t.Fatalf("lists: got %v", summary.Lists)
+	}
+}
+
+func TestActivityListsAddedAndCompletedItemsOnce(t *testing.T) {
+	ops := []storage.Op{
+		{Scope: "list", Resource: "list-2", Actor: "a", Clock: 1, Payload: []byte(`{"type":"insert","itemId":"w-1","payload":{"data":{"text":"a"}}}`)},
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 2, Payload: []byte(`{"type":"update","itemId":"item-1","payload":{"data":{"done":true}}}`)},
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 3, Payload: []byte(`{"type":"update","itemId":"item-1","payload":{"done":true}}`)},
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 4, Payload: []byte(`{"type":"update","itemId":"item-2","payload":{"data":{"text":"renamed"}}}`)},
+		{Scope: "list", Resource: "list-2", Actor: "a", Clock: 5, Payload: []byte(`{"type":"update","itemId":"w-1","payload":{"data":{"done":true}}}`)},
+	}
+	activity := Activity(ops)
+	if len(activity) != 2 {
+		t.Fatalf("expected two lists, got %+v", activity)
+	}
+	if activity[0].ListID != "list-2" || !slices.Equal(activity[0].Added, []string{"w-1"}) || !slices.Equal(activity[0].Completed, []string{"w-1"}) {
+		t.Fatalf("list-2: got %+v", activity[0])
+	}
+	if activity[1].ListID != "list-1" || len(activity[1].Added) != 0 || !slices.Equal(activity[1].Completed, []string{"item-1"}) {
+		t.Fatalf("list-1: got %+v", activity[1])
+	}
+}
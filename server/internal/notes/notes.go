@@ -0,0 +1,326 @@
+// Package notes enforces the size limit on item notes and strips markup from
+// them that could run in another client. Notes are Markdown that clients
+// render, so whatever one device writes into a note reaches every device of
+// everyone the list is shared with.
+//
+// Sanitizing removes active content only: script-like elements with their
+// content, event handler and style attributes, and javascript:, vbscript:
+// and data: URLs in HTML attributes and Markdown links. Everything else,
+// including harmless inline HTML and text such as "a < b", is left as it is.
+package notes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/storage"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// DefaultMaxBytes is the largest note accepted unless configured otherwise.
+const DefaultMaxBytes = 64 << 10
+
+// ErrTooLarge is returned for notes over the size limit.
+var ErrTooLarge = errors.New("note is too large")
+
+// Policy checks notes against a size limit and sanitizes them. The zero value
+// allows DefaultMaxBytes.
+type Policy struct {
+	// MaxBytes is the largest note accepted, in bytes.
+	MaxBytes int
+}
+
+func (p Policy) maxBytes() int {
+	if p.MaxBytes <= 0 {
+		return DefaultMaxBytes
+	}
+	return p.MaxBytes
+}
+
+// Check returns note sanitized, or an error wrapping ErrTooLarge when it is
+// over the limit.
+func (p Policy) Check(note string) (string, error) {
+	if len(note) > p.maxBytes() {
+		return "", fmt.Errorf("%w: %d bytes, at most %d", ErrTooLarge, len(note), p.maxBytes())
+	}
+	return Sanitize(note), nil
+}
+
+// CheckOp applies Check to the note a list op sets, in payload.note or
+// payload.data.note. The op comes back unchanged when its note needs no
+// change, so payloads are only re-encoded when sanitizing changed something.
+func (p Policy) CheckOp(op storage.Op) (storage.Op, error) {
+	if op.Scope != materialize.ScopeList {
+		return op, nil
+	}
+	outer, ok := object(op.Payload)
+	if !ok {
+		return op, nil
+	}
+	inner, ok := object(outer["payload"])
+	if !ok {
+		return op, nil
+	}
+	changed, err := p.checkField(inner, "note")
+	if err != nil {
+		return storage.Op{}, err
+	}
+	if data, ok := object(inner["data"]); ok {
+		dataChanged, err := p.checkField(data, "note")
+		if err != nil {
+			return storage.Op{}, err
+		}
+		if dataChanged {
+			inner["data"] = encode(data)
+			changed = true
+		}
+	}
+	if changed {
+		outer["payload"] = encode(inner)
+		op.Payload = encode(outer)
+	}
+	return op, nil
+}
+
+// CheckSnapshot applies Check to the item notes of a snapshot blob. Blobs
+// that are not snapshot documents are returned as they are.
+func (p Policy) CheckSnapshot(blob string) (string, error) {
+	doc, ok := object([]byte(blob))
+	if !ok {
+		return blob, nil
+	}
+	data, ok := object(doc["data"])
+	if !ok {
+		return blob, nil
+	}
+	var lists []map[string]json.RawMessage
+	if json.Unmarshal(data["lists"], &lists) != nil {
+		return blob, nil
+	}
+	changed := false
+	for _, list := range lists {
+		var items []map[string]json.RawMessage
+		if json.Unmarshal(list["items"], &items) != nil {
+			continue
+		}
+		listChanged := false
+		for _, item := range items {
+			itemChanged, err := p.checkField(item, "note")
+			if err != nil {
+				return "", err
+			}
+			listChanged = listChanged || itemChanged
+		}
+		if listChanged {
+			list["items"] = encode(items)
+			changed = true
+		}
+	}
+	if !changed {
+		return blob, nil
+	}
+	data["lists"] = encode(lists)
+	doc["data"] = encode(data)
+	return string(encode(doc)), nil
+}
+
+// checkField checks the string in fields[name], replacing it when sanitizing
+// changed it. Missing and non-string values are left alone.
+func (p Policy) checkField(fields map[string]json.RawMessage, name string) (bool, error) {
+	raw, ok := fields[name]
+	if !ok {
+		return false, nil
+	}
+	var note string
+	if json.Unmarshal(raw, &note) != nil {
+		return false, nil
+	}
+	checked, err := p.Check(note)
+	if err != nil {
+		return false, err
+	}
+	if checked == note {
+		return false, nil
+	}
+	fields[name] = encode(checked)
+	return true, nil
+}
+
+func object(raw json.RawMessage) (map[string]json.RawMessage, bool) {
+	var fields map[string]json.RawMessage
+	if len(raw) == 0 || json.Unmarshal(raw, &fields) != nil || fields == nil {
+		return nil, false
+	}
+	return fields, true
+}
+
+// encode marshals v without escaping HTML characters, so sanitized notes keep
+// their text readable in the stored payload.
+func encode(v any) json.RawMessage {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	// Values come from decoded JSON, so encoding cannot fail.
+	_ = encoder.Encode(v)
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// droppedElements are removed together with their content.
+var droppedElements = map[string]bool{
+	"script": true, "style": true, "iframe": true, "frame": true, "frameset": true,
+	"object": true, "embed": true, "applet": true, "noscript": true, "noembed": true,
+	"noframes": true, "template": true, "svg": true, "math": true, "xmp": true,
+}
+
+// droppedTags are removed while their content stays.
+var droppedTags = map[string]bool{
+	"base": true, "meta": true, "link": true, "form": true, "input": true,
+	"button": true, "select": true, "option": true, "textarea": true,
+}
+
+// droppedAttributes are removed from every element, besides on* handlers.
+var droppedAttributes = map[string]bool{"style": true, "srcdoc": true, "formaction": true}
+
+// urlAttributes are removed when they hold an unsafe URL.
+var urlAttributes = map[string]bool{
+	"href": true, "src": true, "action": true, "xlink:href": true, "poster": true,
+	"background": true, "cite": true, "srcset": true, "data": true, "lowsrc": true,
+}
+
+var (
+	// markdownLink matches the target of an inline link or image.
+	markdownLink = regexp.MustCompile(`(?i)(\]\(\s*<?)([^)\s>]*)`)
+	// markdownReference matches the target of a link reference definition.
+	markdownReference = regexp.MustCompile(`(?im)^(\s{0,3}\[[^\]]+\]:\s*<?)(\S*)`)
+)
+
+// Sanitize strips active content from a note (see the package comment).
+// Sanitizing a sanitized note changes nothing.
+func Sanitize(note string) string {
+	if !strings.ContainsAny(note, "<]:") {
+		return note
+	}
+	note = sanitizeHTML(note)
+	note = markdownLink.ReplaceAllStringFunc(note, neutralizeTarget(markdownLink))
+	return markdownReference.ReplaceAllStringFunc(note, neutralizeTarget(markdownReference))
+}
+
+// neutralizeTarget replaces an unsafe link target matched by pattern with #.
+func neutralizeTarget(pattern *regexp.Regexp) func(string) string {
+	return func(match string) string {
+		parts := pattern.FindStringSubmatch(match)
+		if !unsafeURL(parts[2]) {
+			return match
+		}
+		return parts[1] + "#"
+	}
+}
+
+func sanitizeHTML(note string) string {
+	if !strings.Contains(note, "<") {
+		return note
+	}
+	tokenizer := xhtml.NewTokenizer(strings.NewReader(note))
+	var out strings.Builder
+	// skipping names the dropped element whose content is being skipped;
+	// depth counts nested elements of the same name.
+	skipping, depth := "", 0
+	for {
+		kind := tokenizer.Next()
+		if kind == xhtml.ErrorToken {
+			if tokenizer.Err() != io.EOF {
+				out.Write(tokenizer.Raw())
+			}
+			return out.String()
+		}
+		raw := string(tokenizer.Raw())
+		token := tokenizer.Token()
+		if skipping != "" {
+			switch {
+			case kind == xhtml.StartTagToken && token.Data == skipping:
+				depth++
+			case kind == xhtml.EndTagToken && token.Data == skipping:
+				depth--
+				if depth == 0 {
+					skipping = ""
+				}
+			}
+			continue
+		}
+		switch kind {
+		case xhtml.TextToken:
+			out.WriteString(raw)
+		case xhtml.CommentToken, xhtml.DoctypeToken:
+			// Comments can hide conditional markup; neither is needed in a
+			// note.
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			if droppedElements[token.Data] {
+				if kind == xhtml.StartTagToken {
+					skipping, depth = token.Data, 1
+				}
+				continue
+			}
+			if droppedTags[token.Data] || (strings.Contains(token.Data, ":") && unsafeURL(token.Data)) {
+				continue
+			}
+			out.WriteString(renderTag(token, raw))
+		case xhtml.EndTagToken:
+			if !droppedElements[token.Data] && !droppedTags[token.Data] {
+				out.WriteString(raw)
+			}
+		}
+	}
+}
+
+// renderTag returns raw when the tag carries no unsafe attribute, and the tag
+// rebuilt without them otherwise.
+func renderTag(token xhtml.Token, raw string) string {
+	kept := token.Attr[:0:0]
+	for _, attr := range token.Attr {
+		name := strings.ToLower(attr.Key)
+		if strings.HasPrefix(name, "on") || droppedAttributes[name] || (urlAttributes[name] && unsafeURL(attr.Val)) {
+			continue
+		}
+		kept = append(kept, attr)
+	}
+	if len(kept) == len(token.Attr) {
+		return raw
+	}
+	var b strings.Builder
+	b.WriteString("<" + token.Data)
+	for _, attr := range kept {
+		fmt.Fprintf(&b, ` %s="%s"`, attr.Key, html.EscapeString(attr.Val))
+	}
+	if token.Type == xhtml.SelfClosingTagToken {
+		b.WriteString(" /")
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+// unsafeURL reports whether url uses a scheme that runs code or embeds
+// content when followed. Browsers ignore whitespace and control characters
+// inside the scheme, so they are ignored here too.
+func unsafeURL(url string) bool {
+	url = strings.ToLower(html.UnescapeString(url))
+	url = strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, url)
+	for _, scheme := range []string{"javascript:", "vbscript:", "data:"} {
+		if strings.HasPrefix(url, scheme) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package notes
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestSanitizeStripsActiveContent(t *testing.T) {
+	for _, tc := range []struct{ note, want string }{
+		{"plain *markdown* with a < b & c > d", "plain *markdown* with a < b & c > d"},
+		{"before<script>alert(1)</script>after", "beforeafter"},
+		{"<b onclick=\"steal()\">bold</b>", `<b>bold</b>`},
+		{`<a href="https://example.com" title="x">ok</a>`, `<a href="https://example.com" title="x">ok</a>`},
+		{`<a href=" jav&#x09;ascript:alert(1)">x</a>`, `<a>x</a>`},
+		{`<img src="data:text/html;base64,PHNjcmlwdD4=" alt="pic"/>`, `<img alt="pic" />`},
+		{"<svg><g><svg></svg></g><script>x</script></svg>kept", "kept"},
+		{"<!-- <script>x</script> -->text", "text"},
+		{"<p style=\"background:url(x)\">p</p>", "<p>p</p>"},
+		{"unterminated <script>alert(1)", "unterminated "},
+		{"[click](javascript:alert(1)) and [fine](https://example.com)", "[click](#)) and [fine](https://example.com)"},
+		{"![img](  DATA:image/svg+xml,abc)", "![img](  #)"},
+		{"[ref]: vbscript:msgbox\n[ok]: https://example.com", "[ref]: #\n[ok]: https://example.com"},
+		{"<javascript:alert(1)>", ""},
+	} {
+		got := Sanitize(tc.note)
+		if got != tc.want {
+			t.Errorf("Sanitize(%q) = %q, want %q", tc.note, got, tc.want)
+		}
+		if again := Sanitize(got); again != got {
+			t.Errorf("Sanitize is not idempotent for %q: %q -> %q", tc.note, got, again)
+		}
+	}
+}
+
+func TestCheckOpSanitizesAndLimitsNotes(t *testing.T) {
+	policy := Policy{MaxBytes: 64}
+	op := func(payload string) storage.Op {
+		return storage.Op{Scope: "list", Resource: "list-1", Actor: "actor-1", Clock: 1, Payload: []byte(payload)}
+	}
+
+	untouched := `{"type":"update","itemId":"item-1","payload":{"data":{"note":"fine"}}}`
+	if checked, err := policy.CheckOp(op(untouched)); err != nil || string(checked.Payload) != untouched {
+		t.Fatalf("expected a clean note to keep its payload, got %s (%v)", checked.Payload, err)
+	}
+	checked, err := policy.CheckOp(op(`{"type":"update","itemId":"item-1","payload":{"note":"a<script>x</script>b","data":{"note":"<i onload=x>c</i>","done":true}}}`))
+	if err != nil {
+		t.Fatalf("check op: %v", err)
+	}
+	want := `{"itemId":"item-1","payload":{"data":{"done":true,"note":"<i>c</i>"},"note":"ab"},"type":"update"}`
+	if string(checked.Payload) != want {
+		t.Fatalf("unexpected sanitized payload %s", checked.Payload)
+	}
+	if _, err := policy.CheckOp(op(`{"type":"insert","itemId":"item-1","payload":{"data":{"note":"` + strings.Repeat("x", 65) + `"}}}`)); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+	// Other scopes and payloads without notes are none of its business.
+	comment := op(`{"type":"add","payload":{"note":"<script>x</script>"}}`)
+	comment.Scope = "comment"
+	if checked, err := policy.CheckOp(comment); err != nil || string(checked.Payload) != string(comment.Payload) {
+		t.Fatalf("expected comment ops to be left alone, got %s (%v)", checked.Payload, err)
+	}
+	if _, err := (Policy{}).CheckOp(op(`{"payload":{"note":"` + strings.Repeat("x", 1000) + `"}}`)); err != nil {
+		t.Fatalf("expected the default limit to allow 1000 bytes, got %v", err)
+	}
+}
+
+func TestCheckSnapshotSanitizesItemNotes(t *testing.T) {
+	policy := Policy{MaxBytes: 64}
+	clean := `{"schema":"net.aggregat4.tasklist.snapshot@v1","data":{"lists":[{"listId":"list-1","items":[{"id":"item-1","note":"ok"}]}]}}`
+	if checked, err := policy.CheckSnapshot(clean); err != nil || checked != clean {
+		t.Fatalf("expected a clean snapshot to stay as it is, got %s (%v)", checked, err)
+	}
+	checked, err := policy.CheckSnapshot(`{"schema":"s","data":{"lists":[{"listId":"list-1","items":[{"id":"item-1","note":"<script>x</script>ok"}]}]}}`)
+	if err != nil || !strings.Contains(checked, `"note":"ok"`) || strings.Contains(checked, "script") {
+		t.Fatalf("unexpected sanitized snapshot %s (%v)", checked, err)
+	}
+	if _, err := policy.CheckSnapshot(`{"data":{"lists":[{"items":[{"note":"` + strings.Repeat("x", 65) + `"}]}]}}`); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+	if checked, err := policy.CheckSnapshot(""); err != nil || checked != "" {
+		t.Fatalf("expected an empty snapshot to pass, got %q (%v)", checked, err)
+	}
+}
@@ -0,0 +1,125 @@
+// Package outbox delivers the side effects of committed writes.
+//
+// Writes that change a user's op log or generation record an outbox event in
+// their own transaction (see storage.Store.ListOutbox). A Dispatcher hands
+// pending events to its handlers and deletes them only once every handler
+// succeeded, so side effects run at least once: a crash before the
+// acknowledgement means they run again, never that they are lost. Handlers
+// must therefore tolerate repeats.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+// batchSize bounds how many events one round reads and delivers.
+const batchSize = 100
+
+// Handler runs the side effects of one event.
+type Handler func(ctx context.Context, event storage.OutboxEvent) error
+
+// Dispatcher delivers outbox events to handlers, in the order they were
+// recorded. All methods accept a nil Dispatcher and then do nothing.
+type Dispatcher struct {
+	store storage.Store
+
+	mu       sync.Mutex
+	handlers []Handler
+	// draining is set while a Dispatch delivers; again asks it for another
+	// round because events arrived meanwhile.
+	draining bool
+	again    bool
+}
+
+// New returns a dispatcher for the events of store.
+func New(store storage.Store) *Dispatcher {
+	return &Dispatcher{store: store}
+}
+
+// Handle adds a handler that every event is delivered to.
+func (d *Dispatcher) Handle(handler Handler) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers = append(d.handlers, handler)
+}
+
+// Dispatch delivers the pending events. A Dispatch that finds another one
+// delivering leaves the new events to it and returns right away, so writers
+// can call it after every commit. When a handler fails, the event and those
+// after it stay pending for the next Dispatch.
+func (d *Dispatcher) Dispatch(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	if d.draining {
+		d.again = true
+		d.mu.Unlock()
+		return nil
+	}
+	d.draining, d.again = true, false
+	handlers := d.handlers
+	d.mu.Unlock()
+	for {
+		delivered, err := d.deliver(ctx, handlers)
+		d.mu.Lock()
+		if err != nil || (delivered < batchSize && !d.again) {
+			d.draining = false
+			d.mu.Unlock()
+			return err
+		}
+		d.again = false
+		d.mu.Unlock()
+	}
+}
+
+// deliver runs one round and returns how many events it delivered.
+func (d *Dispatcher) deliver(ctx context.Context, handlers []Handler) (int, error) {
+	events, err := d.store.ListOutbox(ctx, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	for i, event := range events {
+		for _, handle := range handlers {
+			if err := handle(ctx, event); err != nil {
+				err = fmt.Errorf("deliver outbox event %d kind=%s user=%s: %w", event.ID, event.Kind, event.UserID, err)
+				return i, errors.Join(err, d.store.AckOutbox(ctx, events[:i]))
+			}
+		}
+	}
+	if err := d.store.AckOutbox(ctx, events); err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+// Run dispatches every interval until ctx is done, which delivers events of
+// writes made without a Dispatch after them, such as background compactions,
+// and retries failed deliveries.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	if d == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := d.Dispatch(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("outbox delivery error: %v", err)
+		}
+	}
+}
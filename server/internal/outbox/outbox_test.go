@@ -0,0 +1,59 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestDispatcherDeliversAtLeastOnce(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	insert := func(userID string, clock int64) {
+		t.Helper()
+		if _, err := store.InsertOps(ctx, userID, []storage.Op{{Scope: "list", Resource: "list-1", Actor: "actor-1", Clock: clock, Payload: []byte(`{}`)}}); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	insert("user-1", 1)
+	insert("user-2", 1)
+
+	dispatcher := New(store)
+	var delivered []string
+	failing := "user-2"
+	dispatcher.Handle(func(_ context.Context, event storage.OutboxEvent) error {
+		if event.UserID == failing {
+			return errors.New("fan-out unavailable")
+		}
+		delivered = append(delivered, event.UserID+"/"+event.Kind)
+		return nil
+	})
+	if err := dispatcher.Dispatch(ctx); err == nil {
+		t.Fatal("expected the failed delivery to be reported")
+	}
+	if len(delivered) != 1 || delivered[0] != "user-1/ops" {
+		t.Fatalf("unexpected deliveries %v", delivered)
+	}
+
+	// The failed event stays pending and is delivered once the handler
+	// recovers, followed by the events recorded meanwhile.
+	insert("user-1", 2)
+	failing = ""
+	if err := dispatcher.Dispatch(ctx); err != nil {
+		t.Fatalf("dispatch: %v", err)
+	}
+	if len(delivered) != 3 || delivered[1] != "user-2/ops" || delivered[2] != "user-1/ops" {
+		t.Fatalf("unexpected deliveries %v", delivered)
+	}
+	if pending, err := store.ListOutbox(ctx, 10); err != nil || len(pending) != 0 {
+		t.Fatalf("expected delivered events to be acknowledged, got %+v (%v)", pending, err)
+	}
+
+	var disabled *Dispatcher
+	disabled.Handle(func(context.Context, storage.OutboxEvent) error { return nil })
+	if err := disabled.Dispatch(ctx); err != nil {
+		t.Fatalf("nil dispatcher: %v", err)
+	}
+}
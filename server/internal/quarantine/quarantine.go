@@ -0,0 +1,121 @@
+// Package quarantine takes ops that keep failing to materialize out of the op
+// log.
+//
+// Materialization skips an op it cannot apply, so one bad op does not break
+// reads. The op is still replayed on every read and compaction and sent to
+// every client that pulls, though. The tracker counts failures per op and,
+// once an op has failed repeatedly, quarantines it: the op leaves the op log
+// and waits for an operator to fix or discard it under /admin/quarantine.
+package quarantine
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/storage"
+)
+
+// DefaultThreshold is how often an op may fail before it is quarantined.
+const DefaultThreshold = 3
+
+// Tracker counts materialization failures per op and quarantines ops that
+// reach the threshold. Counts are kept in memory and start over on restart.
+type Tracker struct {
+	store     storage.Store
+	threshold int
+
+	mu       sync.Mutex
+	failures map[int64]int
+}
+
+// New returns a tracker that quarantines an op after threshold failures. A
+// threshold of zero only counts failures and never quarantines.
+func New(store storage.Store, threshold int) *Tracker {
+	return &Tracker{store: store, threshold: threshold, failures: make(map[int64]int)}
+}
+
+// Build materializes like materialize.Build and reports the ops that failed.
+// A nil tracker only builds; all methods accept a nil tracker.
+func (t *Tracker) Build(ctx context.Context, userID string, snapshotBlob string, ops []storage.Op) (materialize.State, error) {
+	state, rejections, err := materialize.BuildChecked(snapshotBlob, ops)
+	if err != nil {
+		return materialize.State{}, err
+	}
+	t.Report(ctx, userID, rejections)
+	return state, nil
+}
+
+// Report records failed ops of userID and quarantines those that reached the
+// threshold. Errors are logged, since the caller's build already succeeded
+// without the ops.
+func (t *Tracker) Report(ctx context.Context, userID string, rejections []materialize.Rejection) {
+	if t == nil {
+		return
+	}
+	for _, rejection := range rejections {
+		op := rejection.Op
+		if op.ServerSeq == 0 {
+			// Not stored yet, such as ops of a push being checked.
+			continue
+		}
+		if !t.fail(op.ServerSeq) {
+			log.Printf("op materialize failed user=%s server_seq=%d scope=%s reason=%q", userID, op.ServerSeq, op.Scope, rejection.Reason)
+			continue
+		}
+		t.quarantine(ctx, userID, rejection)
+	}
+}
+
+// Quarantine quarantines failed ops of userID at once, for callers that are
+// about to drop them, such as a compaction folding the op log. It returns the
+// serverSeqs of the ops that left the op log.
+func (t *Tracker) Quarantine(ctx context.Context, userID string, rejections []materialize.Rejection) []int64 {
+	if t == nil {
+		return nil
+	}
+	var quarantined []int64
+	for _, rejection := range rejections {
+		if rejection.Op.ServerSeq != 0 && t.quarantine(ctx, userID, rejection) {
+			quarantined = append(quarantined, rejection.Op.ServerSeq)
+		}
+	}
+	return quarantined
+}
+
+// quarantine moves the rejected op into quarantine and reports whether it
+// was still in the op log.
+func (t *Tracker) quarantine(ctx context.Context, userID string, rejection materialize.Rejection) bool {
+	op := rejection.Op
+	err := t.store.QuarantineOp(ctx, userID, op.ServerSeq, rejection.Reason, time.Now().Unix())
+	if errors.Is(err, storage.ErrOpNotFound) {
+		// Another request quarantined it first, or it was compacted away.
+		t.forget(op.ServerSeq)
+		return false
+	}
+	if err != nil {
+		log.Printf("op quarantine error user=%s server_seq=%d: %v", userID, op.ServerSeq, err)
+		return false
+	}
+	t.forget(op.ServerSeq)
+	log.Printf("ALERT: op quarantined user=%s server_seq=%d scope=%s resource=%s actor=%s reason=%q; inspect it under /admin/quarantine",
+		userID, op.ServerSeq, op.Scope, op.Resource, op.Actor, rejection.Reason)
+	return true
+}
+
+// fail counts a failure and reports whether the op reached the threshold.
+func (t *Tracker) fail(serverSeq int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failures[serverSeq]++
+	return t.threshold > 0 && t.failures[serverSeq] >= t.threshold
+}
+
+func (t *Tracker) forget(serverSeq int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.failures, serverSeq)
+}
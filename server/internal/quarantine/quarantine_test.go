@@ -0,0 +1,56 @@
+package quarantine
+
+import (
+	"context"
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestTrackerQuarantinesAtThreshold(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	if _, err := store.InsertOps(ctx, "user-1", []storage.Op{
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 1, Payload: []byte(`{"type":"update","itemId":42}`)},
+	}); err != nil {
+		t.Fatalf("insert ops: %v", err)
+	}
+	build := func(tracker *Tracker) {
+		t.Helper()
+		ops, _, err := store.GetOpsSince(ctx, "user-1", 0)
+		if err != nil {
+			t.Fatalf("get ops: %v", err)
+		}
+		if _, err := tracker.Build(ctx, "user-1", "", ops); err != nil {
+			t.Fatalf("build: %v", err)
+		}
+	}
+	quarantined := func() int {
+		t.Helper()
+		ops, err := store.ListQuarantinedOps(ctx)
+		if err != nil {
+			t.Fatalf("list quarantined ops: %v", err)
+		}
+		return len(ops)
+	}
+
+	build(nil)
+	counting := New(store, 0)
+	for range 5 {
+		build(counting)
+	}
+	if quarantined() != 0 {
+		t.Fatal("a nil tracker or a zero threshold must not quarantine")
+	}
+
+	tracker := New(store, 3)
+	build(tracker)
+	build(tracker)
+	if quarantined() != 0 {
+		t.Fatal("quarantined before the threshold")
+	}
+	build(tracker)
+	if quarantined() != 1 {
+		t.Fatal("expected the op in quarantine at the threshold")
+	}
+}
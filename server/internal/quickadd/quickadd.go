@@ -0,0 +1,129 @@
+// Package quickadd parses one-line item entries such as
+//
+//	milk tomorrow #groceries
+//
+// into the item text, its hashtags and an optional due date, so phone
+// shortcuts and other single-field clients can add items with one request.
+//
+// Hashtags may appear anywhere. A due date is only recognized at the end of the
+// text (after hashtags are removed), so words like "Monday" inside an item stay
+// part of it. Recognized due phrases, optionally preceded by "on", "by" or
+// "due", are:
+//
+//	today, tonight, tomorrow
+//	monday … sunday, next monday … next sunday (the next such day after today)
+//	next week (seven days from today)
+//	in 3 days, in 2 weeks
+//	2024-05-01
+package quickadd
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+// DateLayout is the format of Entry.Due.
+const DateLayout = "2006-01-02"
+
+// Entry is a parsed quick-add line.
+type Entry struct {
+	// Text is the item text without hashtags and the due phrase.
+	Text string
+	// Hashtags are the normalized hashtags in order of appearance, without
+	// duplicates.
+	Hashtags []string
+	// Due is the due date (DateLayout), or empty when none was given.
+	Due string
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// Parse splits text into an Entry. Relative dates are resolved against now, in
+// now's location.
+func Parse(text string, now time.Time) Entry {
+	var entry Entry
+	words := make([]string, 0)
+	for _, word := range strings.Fields(text) {
+		if strings.HasPrefix(word, "#") {
+			if tag := storage.NormalizeTag(word); tag != "" && !slices.Contains(entry.Hashtags, tag) {
+				entry.Hashtags = append(entry.Hashtags, tag)
+			}
+			continue
+		}
+		words = append(words, word)
+	}
+	if due, n := trailingDue(words, now); n > 0 && n < len(words) {
+		entry.Due = due.Format(DateLayout)
+		words = words[:len(words)-n]
+		if last := strings.ToLower(words[len(words)-1]); len(words) > 1 && (last == "on" || last == "by" || last == "due") {
+			words = words[:len(words)-1]
+		}
+	}
+	entry.Text = strings.Join(words, " ")
+	return entry
+}
+
+// trailingDue resolves a due phrase at the end of words and returns the date
+// and how many words it spans (zero when there is none).
+func trailingDue(words []string, now time.Time) (time.Time, int) {
+	if len(words) == 0 {
+		return time.Time{}, 0
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	word := func(fromEnd int) string {
+		if fromEnd > len(words) {
+			return ""
+		}
+		return strings.ToLower(strings.TrimRight(words[len(words)-fromEnd], ".,!"))
+	}
+	last := word(1)
+	switch last {
+	case "today", "tonight":
+		return today, 1
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), 1
+	case "week":
+		if word(2) == "next" {
+			return today.AddDate(0, 0, 7), 2
+		}
+	}
+	if weekday, ok := weekdays[last]; ok {
+		days := (int(weekday) - int(today.Weekday()) + 7) % 7
+		if days == 0 {
+			days = 7
+		}
+		n := 1
+		if word(2) == "next" {
+			n = 2
+		}
+		return today.AddDate(0, 0, days), n
+	}
+	if date, err := time.ParseInLocation(DateLayout, last, now.Location()); err == nil {
+		return date, 1
+	}
+	if word(3) == "in" {
+		count, err := strconv.Atoi(word(2))
+		if err != nil || count < 0 || count > 3650 {
+			return time.Time{}, 0
+		}
+		switch last {
+		case "day", "days":
+			return today.AddDate(0, 0, count), 3
+		case "week", "weeks":
+			return today.AddDate(0, 0, 7*count), 3
+		}
+	}
+	return time.Time{}, 0
+}
@@ -0,0 +1,39 @@
+package quickadd
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	// A Wednesday.
+	now := time.Date(2024, time.May, 1, 18, 30, 0, 0, time.UTC)
+	cases := []struct {
+		text     string
+		want     string
+		hashtags []string
+		due      string
+	}{
+		{"milk tomorrow #groceries", "milk", []string{"groceries"}, "2024-05-02"},
+		{"#Groceries #fridge milk #groceries", "milk", []string{"groceries", "fridge"}, ""},
+		{"call mom on friday", "call mom", nil, "2024-05-03"},
+		{"water plants next wednesday", "water plants", nil, "2024-05-08"},
+		{"water plants wednesday", "water plants", nil, "2024-05-08"},
+		{"file taxes by 2024-06-15", "file taxes", nil, "2024-06-15"},
+		{"renew passport in 2 weeks", "renew passport", nil, "2024-05-15"},
+		{"pay rent in 3 days #home", "pay rent", []string{"home"}, "2024-05-04"},
+		{"report due today.", "report", nil, "2024-05-01"},
+		{"plan review next week", "plan review", nil, "2024-05-08"},
+		{"read Monday notes", "read Monday notes", nil, ""},
+		{"tomorrow", "tomorrow", nil, ""},
+		{"in 2 weeks", "in 2 weeks", nil, ""},
+		{"  ", "", nil, ""},
+	}
+	for _, tc := range cases {
+		got := Parse(tc.text, now)
+		if got.Text != tc.want || !slices.Equal(got.Hashtags, tc.hashtags) || got.Due != tc.due {
+			t.Errorf("Parse(%q): got %+v, want text %q hashtags %v due %q", tc.text, got, tc.want, tc.hashtags, tc.due)
+		}
+	}
+}
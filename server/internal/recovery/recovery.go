@@ -0,0 +1,162 @@
+// Package recovery turns handler panics into 500 responses.
+//
+// Without it net/http logs the panic and drops the connection, so the client
+// sees a network error it retries forever and the operator has a stack trace
+// nobody can tie to a report. Instead each panic gets an error id that the
+// response, the log line and the error report share.
+package recovery
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// reportTimeout bounds how long reporting one panic may take.
+const reportTimeout = 30 * time.Second
+
+// maxFrames bounds the stack kept for reports.
+const maxFrames = 64
+
+// Panic is a recovered panic.
+type Panic struct {
+	// ErrorID is 32 hex digits, returned to the client in the errorId field
+	// and the X-Error-Id header.
+	ErrorID string
+	Value   any
+	// Stack is the stack of the panicking goroutine, innermost call first.
+	Stack  []runtime.Frame
+	Method string
+	// Path is the request path, without the query, which may carry secrets.
+	Path string
+}
+
+// Config describes what happens to recovered panics besides logging them.
+type Config struct {
+	// Report, when set, is called with every recovered panic in a goroutine
+	// of its own, so a slow error tracker does not hold up the response.
+	Report func(ctx context.Context, p Panic)
+}
+
+// Recoverer recovers panics of the handlers it wraps.
+type Recoverer struct {
+	report func(ctx context.Context, p Panic)
+	panics atomic.Int64
+}
+
+// New returns a recoverer for cfg.
+func New(cfg Config) *Recoverer {
+	return &Recoverer{report: cfg.Report}
+}
+
+// Middleware serves requests with next and answers 500 when it panics. A
+// panic after the response has started cannot change its status; the
+// connection is dropped instead, so the client does not take a truncated
+// response for a complete one. http.ErrAbortHandler is passed on untouched.
+func (rc *Recoverer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tracking := &startWriter{ResponseWriter: w}
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+			p := Panic{ErrorID: newErrorID(), Value: recovered, Stack: callers(), Method: r.Method, Path: r.URL.Path}
+			rc.panics.Add(1)
+			log.Printf("panic recovered error_id=%s method=%s path=%s panic=%q\n%s", p.ErrorID, p.Method, p.Path, fmt.Sprint(recovered), debug.Stack())
+			if rc.report != nil {
+				go func() {
+					ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), reportTimeout)
+					defer cancel()
+					rc.report(ctx, p)
+				}()
+			}
+			if tracking.started {
+				panic(http.ErrAbortHandler)
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Header().Set("X-Error-Id", p.ErrorID)
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "internal server error", "errorId": p.ErrorID})
+		}()
+		next.ServeHTTP(tracking, r)
+	})
+}
+
+// Panics returns how many panics were recovered since the start.
+func (rc *Recoverer) Panics() int64 {
+	return rc.panics.Load()
+}
+
+// WriteMetrics writes the panic counter in the Prometheus text format.
+func (rc *Recoverer) WriteMetrics(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP tasklists_http_panics_total Handler panics recovered into 500 responses.\n# TYPE tasklists_http_panics_total counter\ntasklists_http_panics_total %d\n", rc.Panics())
+	return err
+}
+
+func newErrorID() string {
+	raw := make([]byte, 16)
+	_, _ = rand.Read(raw)
+	return hex.EncodeToString(raw)
+}
+
+// callers returns the stack of the panicking goroutine from the panic on.
+// It runs in the deferred function, so the frames of the panicking calls
+// are still there, below runtime.gopanic.
+func callers() []runtime.Frame {
+	pcs := make([]uintptr, maxFrames)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var stack []runtime.Frame
+	for {
+		frame, more := frames.Next()
+		if frame.Function == "runtime.gopanic" {
+			// Everything before is recovery itself.
+			stack = stack[:0]
+		} else {
+			stack = append(stack, frame)
+		}
+		if !more {
+			return stack
+		}
+	}
+}
+
+// startWriter notes when the response has started.
+type startWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *startWriter) WriteHeader(status int) {
+	w.started = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *startWriter) Write(p []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *startWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.started = true
+		flusher.Flush()
+	}
+}
+
+func (w *startWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
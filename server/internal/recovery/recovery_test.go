@@ -0,0 +1,74 @@
+package recovery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPanicsBecomeInternalServerErrors(t *testing.T) {
+	reports := make(chan Panic, 1)
+	recoverer := New(Config{Report: func(_ context.Context, p Panic) { reports <- p }})
+	handler := recoverer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		explode(r.URL.Query().Get("what"))
+	}))
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/sync/push?token=secret", nil))
+	var body struct {
+		Error   string `json:"error"`
+		ErrorID string `json:"errorId"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || resp.Code != http.StatusInternalServerError {
+		t.Fatalf("expected a JSON 500, got %d (%v)", resp.Code, err)
+	}
+	if len(body.ErrorID) != 32 || resp.Header().Get("X-Error-Id") != body.ErrorID {
+		t.Fatalf("expected the error id in body and header, got %q and %q", body.ErrorID, resp.Header().Get("X-Error-Id"))
+	}
+	report := <-reports
+	if report.ErrorID != body.ErrorID || report.Value != "boom" || report.Path != "/sync/push" || report.Method != http.MethodPost {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if len(report.Stack) == 0 || !strings.HasSuffix(report.Stack[0].Function, ".explode") {
+		t.Fatalf("expected the stack to start at the panic, got %+v", report.Stack)
+	}
+	var metrics strings.Builder
+	if err := recoverer.WriteMetrics(&metrics); err != nil || !strings.Contains(metrics.String(), "tasklists_http_panics_total 1\n") {
+		t.Fatalf("expected the panic to be counted, got %q (%v)", metrics.String(), err)
+	}
+}
+
+func TestPanicsAfterTheResponseStartedAbortIt(t *testing.T) {
+	recoverer := New(Config{})
+	for name, handler := range map[string]http.Handler{
+		"started": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("partial"))
+			panic("late")
+		}),
+		"aborted": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}),
+	} {
+		func() {
+			defer func() {
+				if recovered := recover(); recovered != http.ErrAbortHandler {
+					t.Errorf("%s: expected the handler to be aborted, got %v", name, recovered)
+				}
+			}()
+			recoverer.Middleware(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+	}
+	if recoverer.Panics() != 1 {
+		t.Fatalf("expected only the real panic to be counted, got %d", recoverer.Panics())
+	}
+}
+
+func explode(what string) {
+	if what == "" {
+		what = "boom"
+	}
+	panic(what)
+}
@@ -0,0 +1,177 @@
+package sentry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// DefaultThrottle is how long a Reporter holds back repeats of an event.
+const DefaultThrottle = 10 * time.Minute
+
+// sendTimeout bounds how long sending one event may take.
+const sendTimeout = 30 * time.Second
+
+// maxErrorBody bounds how much of a 5xx response is kept to read its error.
+const maxErrorBody = 4096
+
+// digits are replaced when fingerprinting, so "user 12" and "user 13" are
+// one pattern.
+var digits = regexp.MustCompile(`[0-9]+`)
+
+// Reporter scrubs events and sends them in the background. A failing
+// database fails every request in the same way, so each pattern of event,
+// told apart by type and message without digits, is sent at most once per
+// throttle interval; repeats are only logged. All methods accept a nil
+// Reporter and then do nothing.
+type Reporter struct {
+	client      *Client
+	environment string
+	throttle    time.Duration
+	now         func() time.Time
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// NewReporter returns a reporter sending to client. environment, when set,
+// tags events with the deployment they come from, such as "production".
+func NewReporter(client *Client, environment string) *Reporter {
+	return &Reporter{client: client, environment: environment, throttle: DefaultThrottle, now: time.Now, last: map[string]time.Time{}}
+}
+
+// Report scrubs event and sends it unless the same pattern was sent within
+// the throttle interval. It returns the event id, which is generated when
+// event has none, and whether the event is sent.
+func (r *Reporter) Report(event Event) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	if event.ID == "" {
+		event.ID = newEventID()
+	}
+	event.Message, event.Value = Scrub(event.Message), Scrub(event.Value)
+	event.Environment = r.environment
+	fingerprint := event.Type + "\x00" + digits.ReplaceAllString(event.Message+"\x00"+event.Value, "0")
+	now := r.now()
+	r.mu.Lock()
+	if last, ok := r.last[fingerprint]; ok && now.Sub(last) < r.throttle {
+		r.mu.Unlock()
+		return event.ID, false
+	}
+	for key, last := range r.last {
+		if now.Sub(last) >= r.throttle {
+			delete(r.last, key)
+		}
+	}
+	r.last[fingerprint] = now
+	r.mu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		defer cancel()
+		if err := r.client.Capture(ctx, event); err != nil {
+			log.Printf("sentry report error event_id=%s: %v", event.ID, err)
+		}
+	}()
+	return event.ID, true
+}
+
+// StorageFailure reports a failure of the database, such as a tripped
+// circuit breaker or a full disk.
+func (r *Reporter) StorageFailure(err error) {
+	if r == nil {
+		return
+	}
+	id, sent := r.Report(Event{Level: LevelError, Type: "storage failure", Value: err.Error()})
+	if sent {
+		log.Printf("storage failure reported event_id=%s", id)
+	}
+}
+
+// Middleware reports the server errors next answers with, reading the error
+// from the JSON body most of them carry. Such responses get the event id in
+// an X-Error-Id header. 503s are left out: they shed load or wait out a busy
+// database, and a database that stays down is reported by StorageFailure.
+// Panics are reported where they are recovered.
+func (r *Reporter) Middleware(next http.Handler) http.Handler {
+	if r == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		capturing := &errorWriter{ResponseWriter: w}
+		next.ServeHTTP(capturing, req)
+		if capturing.id == "" {
+			return
+		}
+		var body struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(capturing.body.Bytes(), &body) != nil || body.Error == "" {
+			body.Error = http.StatusText(capturing.status)
+		}
+		r.Report(Event{
+			ID:     capturing.id,
+			Level:  LevelError,
+			Type:   "server error",
+			Value:  body.Error,
+			Method: req.Method,
+			Path:   req.URL.Path,
+			Tags:   map[string]string{"status": http.StatusText(capturing.status)},
+		})
+	})
+}
+
+func newEventID() string {
+	raw := make([]byte, 16)
+	_, _ = rand.Read(raw)
+	return hex.EncodeToString(raw)
+}
+
+// errorWriter keeps the start of server error responses.
+type errorWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	status      int
+	// id is set for responses that are reported.
+	id   string
+	body bytes.Buffer
+}
+
+func (w *errorWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader, w.status = true, status
+		if status >= http.StatusInternalServerError && status != http.StatusServiceUnavailable {
+			w.id = newEventID()
+			w.Header().Set("X-Error-Id", w.id)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *errorWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.id != "" && w.body.Len() < maxErrorBody {
+		w.body.Write(p[:min(len(p), maxErrorBody-w.body.Len())])
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *errorWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *errorWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
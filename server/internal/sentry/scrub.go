@@ -0,0 +1,47 @@
+package sentry
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxScrubbedLength bounds the text of an event.
+const maxScrubbedLength = 1024
+
+// scrubbers replace what must not leave the server, in order. Error messages
+// can quote anything a request carried, so besides credentials they drop
+// quoted strings and JSON, which may hold list titles, item text or notes.
+var scrubbers = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	// JSON objects and arrays, such as op payloads, up to the last bracket.
+	{regexp.MustCompile(`(?s)\{.*\}`), "{…}"},
+	{regexp.MustCompile(`(?s)\[\s*[{"].*\]`), "[…]"},
+	{regexp.MustCompile(`"(?:[^"\\]|\\.)*"`), `"…"`},
+	{regexp.MustCompile(`'[^'\n]*'`), `'…'`},
+	// Credentials in URLs, headers and key=value pairs.
+	{regexp.MustCompile(`://[^/\s@]+@`), "://[redacted]@"},
+	{regexp.MustCompile(`(?i)\b(bearer|basic)\s+\S+`), "$1 [redacted]"},
+	{regexp.MustCompile(`(?i)\b([a-z_-]*(?:secret|token|password|passwd|session|cookie|signature|dsn|key))(\s*[=:]\s*)[^\s&,;]+`), "$1$2[redacted]"},
+	{regexp.MustCompile(`[A-Za-z0-9.!#$%&*+/=?^_{|}~-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)+`), "[email]"},
+	// Long runs of token characters: API token secrets, keys, hashes.
+	{regexp.MustCompile(`[A-Za-z0-9_+/-]{32,}={0,2}`), "[redacted]"},
+}
+
+// Scrub removes credentials, email addresses and user content from text
+// before it is sent to the tracker, and shortens it to a bounded length.
+func Scrub(text string) string {
+	for _, scrubber := range scrubbers {
+		text = scrubber.pattern.ReplaceAllString(text, scrubber.replacement)
+	}
+	if len(text) > maxScrubbedLength {
+		cut := maxScrubbedLength
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = text[:cut] + "…"
+	}
+	return strings.TrimSpace(text)
+}
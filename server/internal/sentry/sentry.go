@@ -0,0 +1,193 @@
+// Package sentry reports events to a Sentry-compatible error tracker
+// (Sentry, GlitchTip, Bugsink), so operators without log aggregation still
+// see crash patterns. It speaks the envelope endpoint directly. Events carry
+// no request bodies, headers or query strings, and a Reporter scrubs their
+// text (see Scrub).
+package sentry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Levels of events.
+const (
+	LevelFatal = "fatal"
+	LevelError = "error"
+)
+
+// inAppPrefix marks the functions of this server in stack traces.
+const inAppPrefix = "a4-tasklists/"
+
+// Event is one error report.
+type Event struct {
+	// ID is the event id, 32 hex digits. Operators can look an error up by
+	// it, so it is the id shown to the client that hit the error.
+	ID      string
+	Level   string
+	Message string
+	// Type and Value describe the exception, such as "panic" and the panic
+	// value.
+	Type  string
+	Value string
+	// Frames is the stack, innermost call first as runtime.CallersFrames
+	// yields it.
+	Frames []runtime.Frame
+	// Method and Path describe the request that failed, if any.
+	Method string
+	Path   string
+	Tags   map[string]string
+	// Environment names the deployment, such as "production".
+	Environment string
+}
+
+// Client sends events to the project of a DSN.
+type Client struct {
+	endpoint   string
+	auth       string
+	dsn        string
+	serverName string
+	HTTP       *http.Client
+}
+
+// New returns a client for dsn, such as https://key@sentry.example.com/42.
+func New(dsn string) (*Client, error) {
+	parsed, err := url.Parse(strings.TrimSpace(dsn))
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "" || parsed.User == nil || parsed.User.Username() == "" {
+		return nil, fmt.Errorf("dsn %q must look like https://key@host/project", dsn)
+	}
+	prefix, project := "", strings.Trim(parsed.Path, "/")
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, project = "/"+project[:i], project[i+1:]
+	}
+	if project == "" {
+		return nil, fmt.Errorf("dsn %q names no project", dsn)
+	}
+	auth := "Sentry sentry_version=7, sentry_client=tasklists/1, sentry_key=" + parsed.User.Username()
+	if secret, ok := parsed.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+	serverName, _ := os.Hostname()
+	endpoint := url.URL{Scheme: parsed.Scheme, Host: parsed.Host, Path: prefix + "/api/" + project + "/envelope/"}
+	redacted := *parsed
+	redacted.User = url.User(parsed.User.Username())
+	return &Client{
+		endpoint:   endpoint.String(),
+		auth:       auth,
+		dsn:        redacted.String(),
+		serverName: serverName,
+		HTTP:       &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Capture sends event.
+func (c *Client) Capture(ctx context.Context, event Event) error {
+	body, err := c.envelope(event, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", c.auth)
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("sentry status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// envelope returns event as an envelope of one event item.
+func (c *Client) envelope(event Event, now time.Time) ([]byte, error) {
+	payload := map[string]any{
+		"event_id":  event.ID,
+		"timestamp": now.Format(time.RFC3339Nano),
+		"platform":  "go",
+		"level":     event.Level,
+		"logger":    "tasklists",
+	}
+	if c.serverName != "" {
+		payload["server_name"] = c.serverName
+	}
+	if event.Environment != "" {
+		payload["environment"] = event.Environment
+	}
+	if event.Message != "" {
+		payload["message"] = event.Message
+	}
+	if event.Type != "" {
+		exception := map[string]any{"type": event.Type, "value": event.Value}
+		if len(event.Frames) > 0 {
+			exception["stacktrace"] = map[string]any{"frames": frames(event.Frames)}
+		}
+		payload["exception"] = map[string]any{"values": []any{exception}}
+	}
+	if event.Method != "" {
+		payload["request"] = map[string]any{"method": event.Method, "url": event.Path}
+	}
+	if len(event.Tags) > 0 {
+		payload["tags"] = event.Tags
+	}
+	item, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	header, err := json.Marshal(map[string]any{"event_id": event.ID, "sent_at": now.Format(time.RFC3339Nano), "dsn": c.dsn})
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	body.Write(header)
+	body.WriteString("\n")
+	fmt.Fprintf(&body, `{"type":"event","length":%d}`+"\n", len(item))
+	body.Write(item)
+	body.WriteString("\n")
+	return body.Bytes(), nil
+}
+
+// frames converts a stack to Sentry frames, which list the outermost call
+// first.
+func frames(stack []runtime.Frame) []map[string]any {
+	converted := make([]map[string]any, 0, len(stack))
+	for i := len(stack) - 1; i >= 0; i-- {
+		frame := stack[i]
+		module, function := splitFunction(frame.Function)
+		converted = append(converted, map[string]any{
+			"module":   module,
+			"function": function,
+			"abs_path": frame.File,
+			"lineno":   frame.Line,
+			"in_app":   strings.HasPrefix(frame.Function, inAppPrefix),
+		})
+	}
+	return converted
+}
+
+// splitFunction splits a qualified function name such as
+// a4-tasklists/server/internal/httpapi.(*Server).handlePush into its package
+// and the rest.
+func splitFunction(name string) (module, function string) {
+	slash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[slash+1:], ".")
+	if dot < 0 {
+		return "", name
+	}
+	return name[:slash+1+dot], name[slash+1+dot+1:]
+}
@@ -0,0 +1,181 @@
+// Package signup decides who may sign up through OIDC.
+//
+// Deployments that accept logins from an identity provider anyone can
+// register with would otherwise let anyone in. The gate runs in the login
+// callback before anything is stored for the user:
+//
+//   - Deny refuses subjects and email domains, returning users included.
+//   - Returning users are admitted.
+//   - Allow admits new subjects and verified email domains outright.
+//   - An invite code (from an invitation link) admits a new user and uses up
+//     one use of its invite.
+//   - With RequireInvite or an allow list, nobody else gets in. Otherwise
+//     signup is open, up to MaxPerHour new users an hour.
+package signup
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"a4-tasklists/server/internal/auth"
+	"a4-tasklists/server/internal/storage"
+)
+
+// Config says who may sign up. Entries of Allow and Deny are OIDC subjects
+// (the sub claim) or, with a leading "@", email domains such as
+// "@example.com", which match that domain exactly.
+type Config struct {
+	Allow []string
+	Deny  []string
+	// RequireInvite makes new users outside Allow present an invite code.
+	RequireInvite bool
+	// MaxPerHour caps open signups, those without an invite or allow list
+	// entry, per rolling hour; 0 is unlimited.
+	MaxPerHour int
+}
+
+// Enabled reports whether the config restricts anything.
+func (c Config) Enabled() bool {
+	return len(c.Allow) > 0 || len(c.Deny) > 0 || c.RequireInvite || c.MaxPerHour > 0
+}
+
+// closed reports whether signup needs an allow list entry or an invite.
+func (c Config) closed() bool {
+	return c.RequireInvite || len(c.Allow) > 0
+}
+
+// Gate enforces a Config; its Admit method is auth.Config.Admit.
+type Gate struct {
+	store  storage.Store
+	config Config
+	now    func() time.Time
+
+	// mu guards recent and MaxPerHour, which can change at runtime.
+	mu     sync.Mutex
+	recent []time.Time
+}
+
+// New returns a gate checking returning users and invites against store.
+func New(store storage.Store, config Config) *Gate {
+	return &Gate{store: store, config: config, now: time.Now}
+}
+
+// SetMaxPerHour changes the cap on open signups, e.g. after a settings
+// change.
+func (g *Gate) SetMaxPerHour(maxPerHour int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.config.MaxPerHour = maxPerHour
+}
+
+// Admit returns nil when the login may proceed and an error wrapping
+// auth.ErrSignupRefused when it may not.
+func (g *Gate) Admit(ctx context.Context, signup auth.Signup) error {
+	if matches(g.config.Deny, signup.Subject, signup.Email) {
+		return fmt.Errorf("%w: this account may not sign in here", auth.ErrSignupRefused)
+	}
+	for _, userID := range []string{signup.UserID, signup.PreviousUserID} {
+		if userID == "" {
+			continue
+		}
+		exists, err := g.store.UserExists(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+	}
+	verifiedEmail := ""
+	if signup.EmailVerified {
+		verifiedEmail = signup.Email
+	}
+	if matches(g.config.Allow, signup.Subject, verifiedEmail) {
+		log.Printf("signup allowed user=%s", signup.UserID)
+		return nil
+	}
+	if signup.InviteCode != "" {
+		invite, err := g.store.RedeemInvite(ctx, HashCode(signup.InviteCode), g.now().Unix())
+		if err == nil {
+			log.Printf("signup invite redeemed user=%s invite=%s uses=%d/%d", signup.UserID, invite.ID, invite.Uses, invite.MaxUses)
+			return nil
+		}
+		if !errors.Is(err, storage.ErrInviteNotFound) {
+			return err
+		}
+		if g.config.closed() {
+			return fmt.Errorf("%w: the invite code is unknown, expired or used up", auth.ErrSignupRefused)
+		}
+	}
+	if g.config.closed() {
+		return fmt.Errorf("%w: signing up needs an invitation", auth.ErrSignupRefused)
+	}
+	if maxPerHour, ok := g.take(); !ok {
+		log.Printf("signup rate limit reached max_per_hour=%d", maxPerHour)
+		return fmt.Errorf("%w: too many people are signing up right now; try again later", auth.ErrSignupRefused)
+	}
+	log.Printf("signup open user=%s", signup.UserID)
+	return nil
+}
+
+// take counts an open signup, or reports that the hour's are used up. It
+// returns the cap it checked against.
+func (g *Gate) take() (int, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	maxPerHour := g.config.MaxPerHour
+	if maxPerHour <= 0 {
+		return maxPerHour, true
+	}
+	now := g.now()
+	g.recent = slices.DeleteFunc(g.recent, func(at time.Time) bool { return now.Sub(at) >= time.Hour })
+	if len(g.recent) >= maxPerHour {
+		return maxPerHour, false
+	}
+	g.recent = append(g.recent, now)
+	return maxPerHour, true
+}
+
+// matches reports whether subject or the domain of email is in entries.
+func matches(entries []string, subject string, email string) bool {
+	domain := ""
+	if at := strings.LastIndexByte(email, '@'); at >= 0 {
+		domain = strings.ToLower(email[at:])
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry, "@") {
+			if domain != "" && strings.ToLower(entry) == domain {
+				return true
+			}
+		} else if entry == subject {
+			return true
+		}
+	}
+	return false
+}
+
+// NewCode returns a random invite code and the hash it is stored under.
+func NewCode() (string, string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	code := base64.RawURLEncoding.EncodeToString(raw)
+	return code, HashCode(code), nil
+}
+
+// HashCode returns the hash an invite code is stored under.
+func HashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
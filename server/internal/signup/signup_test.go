@@ -0,0 +1,87 @@
+package signup
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"a4-tasklists/server/internal/auth"
+	"a4-tasklists/server/internal/storage"
+)
+
+func refused(err error) bool {
+	return errors.Is(err, auth.ErrSignupRefused)
+}
+
+func TestGateAdmitsAllowedReturningAndInvitedUsers(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	if err := store.UpdateUserProfile(ctx, "old-user", storage.UserProfile{DisplayName: "Old"}); err != nil {
+		t.Fatalf("save profile: %v", err)
+	}
+	code, hash, err := NewCode()
+	if err != nil {
+		t.Fatalf("new code: %v", err)
+	}
+	if err := store.CreateInvite(ctx, storage.Invite{ID: "invite-1", MaxUses: 1, CreatedAt: 1}, hash); err != nil {
+		t.Fatalf("create invite: %v", err)
+	}
+	gate := New(store, Config{Allow: []string{"@example.com", "sub-friend"}, Deny: []string{"sub-banned", "@spam.test"}})
+
+	for name, tc := range map[string]struct {
+		signup auth.Signup
+		admit  bool
+	}{
+		"allowed domain":       {auth.Signup{UserID: "a", Subject: "a", Email: "ann@EXAMPLE.com", EmailVerified: true}, true},
+		"unverified domain":    {auth.Signup{UserID: "b", Subject: "b", Email: "bob@example.com"}, false},
+		"subdomain":            {auth.Signup{UserID: "c", Subject: "c", Email: "cy@mail.example.com", EmailVerified: true}, false},
+		"allowed subject":      {auth.Signup{UserID: "d", Subject: "sub-friend"}, true},
+		"returning user":       {auth.Signup{UserID: "old-user", Subject: "e"}, true},
+		"returning by old id":  {auth.Signup{UserID: "new-id", PreviousUserID: "old-user", Subject: "f"}, true},
+		"denied subject":       {auth.Signup{UserID: "old-user", Subject: "sub-banned"}, false},
+		"denied domain":        {auth.Signup{UserID: "g", Subject: "sub-friend", Email: "x@spam.test", EmailVerified: true}, false},
+		"stranger":             {auth.Signup{UserID: "h", Subject: "h"}, false},
+		"stranger with a code": {auth.Signup{UserID: "i", Subject: "i", InviteCode: "made-up"}, false},
+	} {
+		err := gate.Admit(ctx, tc.signup)
+		if tc.admit && err != nil {
+			t.Fatalf("%s: expected to be admitted, got %v", name, err)
+		}
+		if !tc.admit && !refused(err) {
+			t.Fatalf("%s: expected to be refused, got %v", name, err)
+		}
+	}
+
+	if err := gate.Admit(ctx, auth.Signup{UserID: "j", Subject: "j", InviteCode: code}); err != nil {
+		t.Fatalf("expected the invite to admit, got %v", err)
+	}
+	if err := gate.Admit(ctx, auth.Signup{UserID: "k", Subject: "k", InviteCode: code}); !refused(err) {
+		t.Fatalf("expected a used-up invite to be refused, got %v", err)
+	}
+	invites, err := store.ListInvites(ctx)
+	if err != nil || len(invites) != 1 || invites[0].Uses != 1 {
+		t.Fatalf("expected the invite to be used once, got %+v %v", invites, err)
+	}
+}
+
+func TestGateCapsOpenSignupsPerHour(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+	gate := New(storage.NewMemoryStore(), Config{MaxPerHour: 2})
+	gate.now = func() time.Time { return now }
+
+	for _, user := range []string{"a", "b"} {
+		if err := gate.Admit(ctx, auth.Signup{UserID: user, Subject: user}); err != nil {
+			t.Fatalf("expected %s to sign up, got %v", user, err)
+		}
+	}
+	if err := gate.Admit(ctx, auth.Signup{UserID: "c", Subject: "c"}); !refused(err) {
+		t.Fatalf("expected the third signup in an hour to be refused, got %v", err)
+	}
+	// An unknown code does not close open signup.
+	now = now.Add(time.Hour)
+	if err := gate.Admit(ctx, auth.Signup{UserID: "c", Subject: "c", InviteCode: "made-up"}); err != nil {
+		t.Fatalf("expected signup to reopen after an hour, got %v", err)
+	}
+}
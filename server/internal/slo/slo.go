@@ -0,0 +1,470 @@
+// Package slo measures requests per route and checks them against service
+// level objectives.
+//
+// An objective such as "99% of pulls answer within 200ms" grants an error
+// budget: 1% of pulls may be slow or fail. The burn rate is how fast that
+// budget is spent, as a multiple of the rate that would spend it exactly:
+// at 1 the budget lasts the whole period, at 14.4 a 30-day budget is gone in
+// two days. As in the multiwindow alerts of the Google SRE workbook, an alert
+// fires when the burn rate is high over both a long and a short window. The
+// long window keeps a few slow requests from raising it, the short one lets
+// it resolve soon after the problem does.
+//
+// Counters live in memory and start over on restart; they are exported on
+// /metrics for whoever scrapes it, and alerts can be posted to a webhook for
+// servers nobody scrapes.
+package slo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Objective is a latency objective for one path: Target of its requests
+// answer within Latency without a server error.
+type Objective struct {
+	// Path is the path measured. A path ending in / also covers everything
+	// below it.
+	Path string
+	// Latency is the slowest answer that still counts as good.
+	Latency time.Duration
+	// Target is the share of requests that must be good, such as 0.99.
+	Target float64
+}
+
+// String returns the objective in the form ParseObjectives reads, which also
+// names it in metrics and alerts.
+func (o Objective) String() string {
+	return fmt.Sprintf("%s=%s@%s", o.Path, o.Latency, strconv.FormatFloat(o.Target*100, 'f', -1, 64))
+}
+
+func (o Objective) covers(path string) bool {
+	return path == o.Path || (strings.HasSuffix(o.Path, "/") && strings.HasPrefix(path, o.Path))
+}
+
+// ParseObjectives parses objectives such as "/sync/pull=200ms@99,/sync/push=500ms@99.9":
+// the path, the latency, and the target in percent.
+func ParseObjectives(spec string) ([]Objective, error) {
+	var objectives []Objective
+	for entry := range strings.SplitSeq(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		path, rest, ok := strings.Cut(entry, "=")
+		latency, target, ok2 := strings.Cut(rest, "@")
+		path = strings.TrimSpace(path)
+		if !ok || !ok2 || !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("invalid objective %q (want /path=200ms@99)", entry)
+		}
+		duration, err := time.ParseDuration(strings.TrimSpace(latency))
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid latency in %q", entry)
+		}
+		percent, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(target, "%")), 64)
+		if err != nil || percent <= 0 || percent >= 100 {
+			return nil, fmt.Errorf("invalid target in %q (want a percentage below 100)", entry)
+		}
+		objectives = append(objectives, Objective{Path: path, Latency: duration, Target: percent / 100})
+	}
+	return objectives, nil
+}
+
+// Severities of alerts.
+const (
+	SeverityPage   = "page"
+	SeverityTicket = "ticket"
+)
+
+// alertRule fires when the burn rate reaches burnRate over both windows. The
+// rates are the workbook's: a page spends 2% of a 30-day budget in an hour, a
+// ticket 5% in six hours.
+type alertRule struct {
+	severity    string
+	long, short time.Duration
+	burnRate    float64
+	longName    string
+	shortName   string
+}
+
+var alertRules = []alertRule{
+	{severity: SeverityPage, long: time.Hour, short: 5 * time.Minute, burnRate: 14.4, longName: "1h", shortName: "5m"},
+	{severity: SeverityTicket, long: 6 * time.Hour, short: 30 * time.Minute, burnRate: 6, longName: "6h", shortName: "30m"},
+}
+
+// windows are the windows burn rates are exported for.
+var windows = []struct {
+	name     string
+	duration time.Duration
+}{{"5m", 5 * time.Minute}, {"30m", 30 * time.Minute}, {"1h", time.Hour}, {"6h", 6 * time.Hour}}
+
+// minAlertRequests is how many requests the long window needs before an
+// alert may fire, so one slow request on a quiet server does not page.
+const minAlertRequests = 20
+
+// bucketCount is how many one-minute buckets an objective keeps, enough for
+// the longest window.
+const bucketCount = 6 * 60
+
+// durationBuckets are the upper bounds of the latency histogram, in seconds.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Alert is an alert firing or resolving, as posted to the webhook.
+type Alert struct {
+	Objective string `json:"objective"`
+	Severity  string `json:"severity"`
+	// Status is "firing" or "resolved".
+	Status string `json:"status"`
+	// Window and BurnRate describe the long window, ShortWindow and
+	// ShortBurnRate the short one.
+	Window        string    `json:"window"`
+	BurnRate      float64   `json:"burnRate"`
+	ShortWindow   string    `json:"shortWindow"`
+	ShortBurnRate float64   `json:"shortBurnRate"`
+	At            time.Time `json:"at"`
+	// Text describes the alert in a sentence, which chat webhooks (Slack,
+	// Mattermost, ntfy) show as the message.
+	Text string `json:"text"`
+}
+
+// Notifier delivers alerts.
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// Config describes what a Recorder measures.
+type Config struct {
+	Objectives []Objective
+	// Route names the route a request takes, such as the mux pattern it
+	// matches, to keep per-route metrics to a bounded set of labels. Nil or
+	// "" counts the request under "other".
+	Route func(*http.Request) string
+	// Exempt lists paths that are not measured, such as long-lived streams.
+	Exempt []string
+	// Alerts receives alerts as they fire and resolve. Nil leaves them to
+	// /metrics.
+	Alerts Notifier
+}
+
+// routeStats counts the requests of one route.
+type routeStats struct {
+	statuses map[string]int64
+	// buckets counts requests per durationBuckets bound, non-cumulatively;
+	// the last entry is +Inf.
+	buckets []int64
+	sum     float64
+	count   int64
+}
+
+// bucket counts one minute of an objective's requests.
+type bucket struct {
+	minute      int64
+	good, total int64
+}
+
+// objectiveStats tracks one objective.
+type objectiveStats struct {
+	Objective
+	buckets     [bucketCount]bucket
+	good, total int64
+	// firing holds the severities whose alert was last reported firing.
+	firing map[string]bool
+}
+
+// Recorder measures requests and evaluates objectives.
+type Recorder struct {
+	route  func(*http.Request) string
+	exempt []string
+	alerts Notifier
+	now    func() time.Time
+
+	mu         sync.Mutex
+	routes     map[string]*routeStats
+	objectives []*objectiveStats
+}
+
+// New returns a recorder for cfg.
+func New(cfg Config) *Recorder {
+	r := &Recorder{route: cfg.Route, exempt: cfg.Exempt, alerts: cfg.Alerts, now: time.Now, routes: map[string]*routeStats{}}
+	for _, objective := range cfg.Objectives {
+		r.objectives = append(r.objectives, &objectiveStats{Objective: objective, firing: map[string]bool{}})
+	}
+	return r
+}
+
+// Middleware measures the requests served by next.
+func (rec *Recorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(rec.exempt, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route := ""
+		if rec.route != nil {
+			route = rec.route(r)
+		}
+		recording := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recording, r)
+		rec.record(route, r.URL.Path, recording.status, time.Since(start))
+	})
+}
+
+// record counts one request.
+func (rec *Recorder) record(route, path string, status int, elapsed time.Duration) {
+	if route == "" {
+		route = "other"
+	}
+	minute := rec.now().Unix() / 60
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	stats := rec.routes[route]
+	if stats == nil {
+		stats = &routeStats{statuses: map[string]int64{}, buckets: make([]int64, len(durationBuckets)+1)}
+		rec.routes[route] = stats
+	}
+	stats.statuses[strconv.Itoa(status/100)+"xx"]++
+	seconds := elapsed.Seconds()
+	bound, _ := slices.BinarySearch(durationBuckets, seconds)
+	stats.buckets[bound]++
+	stats.sum += seconds
+	stats.count++
+	for _, objective := range rec.objectives {
+		if !objective.covers(path) {
+			continue
+		}
+		b := &objective.buckets[minute%bucketCount]
+		if b.minute != minute {
+			*b = bucket{minute: minute}
+		}
+		b.total++
+		objective.total++
+		if status < http.StatusInternalServerError && elapsed <= objective.Latency {
+			b.good++
+			objective.good++
+		}
+	}
+}
+
+// window totals the requests of the last d, including the current minute.
+// Callers hold rec.mu.
+func (o *objectiveStats) window(now time.Time, d time.Duration) (good, total int64) {
+	minute := now.Unix() / 60
+	oldest := minute - int64(d/time.Minute) + 1
+	for _, b := range o.buckets {
+		if b.minute >= oldest && b.minute <= minute {
+			good += b.good
+			total += b.total
+		}
+	}
+	return good, total
+}
+
+// burnRate returns the burn rate over the last d and how many requests it
+// rests on. Callers hold rec.mu.
+func (o *objectiveStats) burnRate(now time.Time, d time.Duration) (float64, int64) {
+	good, total := o.window(now, d)
+	if total == 0 {
+		return 0, 0
+	}
+	return float64(total-good) / float64(total) / (1 - o.Target), total
+}
+
+// evaluate returns the alert rule reports as they stand at now. Callers hold
+// rec.mu.
+func (o *objectiveStats) evaluate(now time.Time) []Alert {
+	var alerts []Alert
+	for _, rule := range alertRules {
+		long, requests := o.burnRate(now, rule.long)
+		short, _ := o.burnRate(now, rule.short)
+		status := "resolved"
+		if long >= rule.burnRate && short >= rule.burnRate && requests >= minAlertRequests {
+			status = "firing"
+		}
+		alerts = append(alerts, Alert{
+			Objective:     o.String(),
+			Severity:      rule.severity,
+			Status:        status,
+			Window:        rule.longName,
+			BurnRate:      long,
+			ShortWindow:   rule.shortName,
+			ShortBurnRate: short,
+			At:            now.UTC(),
+		})
+	}
+	return alerts
+}
+
+// Evaluate checks every objective and returns the alerts that started firing
+// or resolved since the last call, after handing them to the notifier.
+// Delivery errors are logged; the alert is not retried.
+func (rec *Recorder) Evaluate(ctx context.Context) []Alert {
+	now := rec.now()
+	var changed []Alert
+	rec.mu.Lock()
+	for _, objective := range rec.objectives {
+		for _, alert := range objective.evaluate(now) {
+			firing := alert.Status == "firing"
+			if firing == objective.firing[alert.Severity] {
+				continue
+			}
+			objective.firing[alert.Severity] = firing
+			alert.Text = alertText(alert, objective.Objective)
+			changed = append(changed, alert)
+		}
+	}
+	rec.mu.Unlock()
+
+	for _, alert := range changed {
+		log.Printf("slo alert %s objective=%s severity=%s burn_rate_%s=%.2f burn_rate_%s=%.2f", alert.Status, alert.Objective, alert.Severity, alert.Window, alert.BurnRate, alert.ShortWindow, alert.ShortBurnRate)
+		if rec.alerts == nil {
+			continue
+		}
+		if err := rec.alerts.Notify(ctx, alert); err != nil {
+			log.Printf("slo alert delivery error objective=%s: %v", alert.Objective, err)
+		}
+	}
+	return changed
+}
+
+func alertText(alert Alert, objective Objective) string {
+	goal := fmt.Sprintf("%s%% of %s within %s", strconv.FormatFloat(objective.Target*100, 'f', -1, 64), objective.Path, objective.Latency)
+	if alert.Status == "firing" {
+		return fmt.Sprintf("%s: error budget of %s burning %.1fx over %s and %.1fx over %s",
+			strings.ToUpper(alert.Severity), goal, alert.BurnRate, alert.Window, alert.ShortBurnRate, alert.ShortWindow)
+	}
+	return fmt.Sprintf("Resolved (%s): error budget of %s is no longer burning fast", alert.Severity, goal)
+}
+
+// Run evaluates the objectives every interval until ctx is done. Without
+// objectives it returns at once.
+func (rec *Recorder) Run(ctx context.Context, interval time.Duration) {
+	if len(rec.objectives) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rec.Evaluate(ctx)
+		}
+	}
+}
+
+// WriteMetrics writes the per-route metrics and the objectives in the
+// Prometheus text format.
+func (rec *Recorder) WriteMetrics(w io.Writer) error {
+	now := rec.now()
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	var b strings.Builder
+	routes := slices.Sorted(maps.Keys(rec.routes))
+
+	writeHeader(&b, "tasklists_http_requests_total", "counter", "Requests answered, by route and status class.")
+	for _, route := range routes {
+		statuses := rec.routes[route].statuses
+		for _, status := range slices.Sorted(maps.Keys(statuses)) {
+			fmt.Fprintf(&b, "tasklists_http_requests_total{route=%q,status=%q} %d\n", route, status, statuses[status])
+		}
+	}
+	writeHeader(&b, "tasklists_http_request_duration_seconds", "histogram", "Time to answer requests, by route.")
+	for _, route := range routes {
+		stats := rec.routes[route]
+		var cumulative int64
+		for i, count := range stats.buckets {
+			cumulative += count
+			bound := "+Inf"
+			if i < len(durationBuckets) {
+				bound = strconv.FormatFloat(durationBuckets[i], 'f', -1, 64)
+			}
+			fmt.Fprintf(&b, "tasklists_http_request_duration_seconds_bucket{route=%q,le=%q} %d\n", route, bound, cumulative)
+		}
+		fmt.Fprintf(&b, "tasklists_http_request_duration_seconds_sum{route=%q} %s\n", route, strconv.FormatFloat(stats.sum, 'f', -1, 64))
+		fmt.Fprintf(&b, "tasklists_http_request_duration_seconds_count{route=%q} %d\n", route, stats.count)
+	}
+
+	if len(rec.objectives) > 0 {
+		writeHeader(&b, "tasklists_slo_target", "gauge", "Share of requests an objective expects to be good.")
+		for _, o := range rec.objectives {
+			fmt.Fprintf(&b, "tasklists_slo_target{slo=%q} %s\n", o.String(), strconv.FormatFloat(o.Target, 'f', -1, 64))
+		}
+		writeHeader(&b, "tasklists_slo_requests_total", "counter", "Requests an objective covers.")
+		for _, o := range rec.objectives {
+			fmt.Fprintf(&b, "tasklists_slo_requests_total{slo=%q} %d\n", o.String(), o.total)
+		}
+		writeHeader(&b, "tasklists_slo_good_requests_total", "counter", "Requests answered in time and without a server error.")
+		for _, o := range rec.objectives {
+			fmt.Fprintf(&b, "tasklists_slo_good_requests_total{slo=%q} %d\n", o.String(), o.good)
+		}
+		writeHeader(&b, "tasklists_slo_burn_rate", "gauge", "How fast the error budget is spent; 1 spends it exactly over the objective's period.")
+		for _, o := range rec.objectives {
+			for _, window := range windows {
+				rate, _ := o.burnRate(now, window.duration)
+				fmt.Fprintf(&b, "tasklists_slo_burn_rate{slo=%q,window=%q} %s\n", o.String(), window.name, formatRate(rate))
+			}
+		}
+		writeHeader(&b, "tasklists_slo_alert", "gauge", "1 while the burn rate alert of a severity fires.")
+		for _, o := range rec.objectives {
+			for _, alert := range o.evaluate(now) {
+				firing := 0
+				if alert.Status == "firing" {
+					firing = 1
+				}
+				fmt.Fprintf(&b, "tasklists_slo_alert{slo=%q,severity=%q} %d\n", o.String(), alert.Severity, firing)
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeHeader(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func formatRate(rate float64) string {
+	return strconv.FormatFloat(math.Round(rate*1000)/1000, 'f', -1, 64)
+}
+
+// statusWriter remembers the status written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		flusher.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
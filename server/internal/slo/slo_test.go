@@ -0,0 +1,125 @@
+package slo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseObjectives(t *testing.T) {
+	objectives, err := ParseObjectives(" /sync/pull=200ms@99, /lists/=1s@99.5% ")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(objectives) != 2 || objectives[0] != (Objective{Path: "/sync/pull", Latency: 200 * time.Millisecond, Target: 0.99}) || objectives[1].String() != "/lists/=1s@99.5" {
+		t.Fatalf("unexpected objectives %+v", objectives)
+	}
+	for _, spec := range []string{"sync/pull=200ms@99", "/sync/pull=200ms", "/sync/pull=fast@99", "/sync/pull=200ms@100", "/sync/pull=-1s@99"} {
+		if _, err := ParseObjectives(spec); err == nil {
+			t.Errorf("expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestBurningBudgetFiresAndResolvesAlerts(t *testing.T) {
+	var posted []Alert
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("decode alert: %v", err)
+		}
+		posted = append(posted, alert)
+	}))
+	defer webhook.Close()
+	notifier, err := NewWebhook(webhook.URL)
+	if err != nil {
+		t.Fatalf("webhook: %v", err)
+	}
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	recorder := New(Config{
+		Objectives: []Objective{{Path: "/sync/pull", Latency: 200 * time.Millisecond, Target: 0.99}},
+		Route:      func(r *http.Request) string { return r.URL.Path },
+		Exempt:     []string{"/sync/stream"},
+		Alerts:     notifier,
+	})
+	recorder.now = func() time.Time { return now }
+	failing := false
+	handler := recorder.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	serve := func(path string, n int) {
+		for range n {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		}
+	}
+
+	// An hour of healthy pulls.
+	for range 60 {
+		serve("/sync/pull", 10)
+		now = now.Add(time.Minute)
+	}
+	serve("/sync/stream", 5)
+	if alerts := recorder.Evaluate(context.Background()); len(alerts) != 0 {
+		t.Fatalf("expected no alerts for healthy traffic, got %+v", alerts)
+	}
+
+	// A minute of failures burns the budget 20x over five minutes, but not
+	// over the hour.
+	failing = true
+	serve("/sync/pull", 10)
+	if alerts := recorder.Evaluate(context.Background()); len(alerts) != 0 {
+		t.Fatalf("expected a short spike alone not to page, got %+v", alerts)
+	}
+	for range 8 {
+		serve("/sync/pull", 10)
+		now = now.Add(time.Minute)
+	}
+	alerts := recorder.Evaluate(context.Background())
+	if len(alerts) != 2 || alerts[0].Severity != SeverityPage || alerts[0].Status != "firing" || alerts[0].BurnRate < 14.4 || alerts[1].Severity != SeverityTicket {
+		t.Fatalf("expected both alerts to fire, got %+v", alerts)
+	}
+	if len(posted) != 2 || posted[0].Objective != "/sync/pull=200ms@99" || !strings.HasPrefix(posted[0].Text, "PAGE: ") {
+		t.Fatalf("expected the alert to be posted, got %+v", posted)
+	}
+	if alerts := recorder.Evaluate(context.Background()); len(alerts) != 0 {
+		t.Fatalf("expected a firing alert to be reported once, got %+v", alerts)
+	}
+
+	var metrics strings.Builder
+	if err := recorder.WriteMetrics(&metrics); err != nil {
+		t.Fatalf("write metrics: %v", err)
+	}
+	for _, want := range []string{
+		`tasklists_http_requests_total{route="/sync/pull",status="2xx"} 600`,
+		`tasklists_http_requests_total{route="/sync/pull",status="5xx"} 90`,
+		`tasklists_http_request_duration_seconds_count{route="/sync/pull"} 690`,
+		`tasklists_slo_burn_rate{slo="/sync/pull=200ms@99",window="5m"} 100`,
+		`tasklists_slo_alert{slo="/sync/pull=200ms@99",severity="page"} 1`,
+	} {
+		if !strings.Contains(metrics.String(), want) {
+			t.Fatalf("expected %s in metrics, got:\n%s", want, metrics.String())
+		}
+	}
+	if strings.Contains(metrics.String(), "/sync/stream") {
+		t.Fatalf("expected exempt paths not to be measured, got:\n%s", metrics.String())
+	}
+
+	// Once pulls recover, the short window clears the page; the ticket's
+	// half hour still holds the failures.
+	failing = false
+	for range 6 {
+		serve("/sync/pull", 10)
+		now = now.Add(time.Minute)
+	}
+	alerts = recorder.Evaluate(context.Background())
+	if len(alerts) != 1 || alerts[0].Severity != SeverityPage || alerts[0].Status != "resolved" || len(posted) != 3 {
+		t.Fatalf("expected the page alert to resolve, got %+v", alerts)
+	}
+}
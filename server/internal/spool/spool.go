@@ -0,0 +1,296 @@
+// Package spool keeps pushes that arrive while storage is degraded.
+//
+// When the database is locked for longer than the retries cover or its disk
+// is full, a push would otherwise fail and the client would keep resending
+// the same ops until storage recovers. Instead the push is appended to a
+// journal on another volume and acknowledged with 202 Accepted; a background
+// loop replays the journal in arrival order once storage accepts writes
+// again. Ops are ordered by their clocks rather than by arrival, so pushes
+// that are applied directly in the meantime do not change the outcome.
+package spool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+// Entry is one spooled push.
+type Entry struct {
+	UserID               string       `json:"userId"`
+	ClientID             string       `json:"clientId"`
+	DatasetGenerationKey string       `json:"datasetGenerationKey"`
+	Ops                  []storage.Op `json:"ops"`
+	SpooledAt            time.Time    `json:"spooledAt"`
+}
+
+// Spoolable reports whether a push that failed with err should be spooled
+// rather than rejected: the storage error must be one that goes away without
+// the client changing anything.
+func Spoolable(err error) bool {
+	return storage.IsTransient(err) || storage.IsFull(err)
+}
+
+// Journal is an append-only file of spooled pushes, one JSON line each.
+// Entries that fail replay permanently are moved to a ".rejected" file next
+// to it for an operator to inspect. All methods accept a nil Journal; Append
+// then fails, so callers fall back to rejecting the push.
+type Journal struct {
+	path string
+
+	// replaying serializes replays, so only Append changes the file while a
+	// replay applies entries.
+	replaying sync.Mutex
+
+	mu      sync.Mutex
+	pending int
+}
+
+// Open opens the journal at path, creating it if needed. Entries left by a
+// previous run stay pending.
+func Open(path string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create spool directory: %w", err)
+	}
+	if err := trimTornAppend(path); err != nil {
+		return nil, fmt.Errorf("open spool: %w", err)
+	}
+	j := &Journal{path: path}
+	entries, err := j.read()
+	if err != nil {
+		return nil, err
+	}
+	j.pending = len(entries)
+	return j, nil
+}
+
+// Append adds entry to the journal. It returns once the entry is on disk, so
+// a push may be acknowledged afterwards.
+func (j *Journal) Append(entry Entry) error {
+	if j == nil {
+		return errors.New("push spool is not configured")
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := appendLine(j.path, line); err != nil {
+		return fmt.Errorf("spool push: %w", err)
+	}
+	j.pending++
+	return nil
+}
+
+// Pending returns the number of entries waiting for replay.
+func (j *Journal) Pending() int {
+	if j == nil {
+		return 0
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.pending
+}
+
+// Replay applies the pending entries in order. An entry that fails with a
+// Spoolable error stops the replay and stays pending together with the
+// entries after it; an entry that fails otherwise is moved to the rejected
+// file. Replay returns how many entries were applied.
+func (j *Journal) Replay(ctx context.Context, apply func(context.Context, Entry) error) (int, error) {
+	if j == nil {
+		return 0, nil
+	}
+	j.replaying.Lock()
+	defer j.replaying.Unlock()
+	j.mu.Lock()
+	entries, err := j.read()
+	j.mu.Unlock()
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+
+	applied, done := 0, 0
+	var replayErr error
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			replayErr = err
+			break
+		}
+		err := apply(ctx, entry)
+		if err != nil && Spoolable(err) {
+			replayErr = err
+			break
+		}
+		if err != nil {
+			log.Printf("push spool rejected entry user=%s client=%s ops=%d spooled_at=%s: %v", entry.UserID, entry.ClientID, len(entry.Ops), entry.SpooledAt.Format(time.RFC3339), err)
+			if rejectErr := j.reject(entry); rejectErr != nil {
+				// Keep the entry and everything after it rather than lose it.
+				replayErr = rejectErr
+				break
+			}
+		} else {
+			applied++
+		}
+		done++
+	}
+	if done == 0 {
+		return applied, replayErr
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	// Entries appended during the replay follow the ones read above.
+	current, err := j.read()
+	if err != nil {
+		return applied, errors.Join(replayErr, err)
+	}
+	remaining := current[done:]
+	if err := j.rewrite(remaining); err != nil {
+		return applied, errors.Join(replayErr, err)
+	}
+	j.pending = len(remaining)
+	return applied, replayErr
+}
+
+// Run replays the journal every interval until ctx is done.
+func (j *Journal) Run(ctx context.Context, interval time.Duration, apply func(context.Context, Entry) error) {
+	if j == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if j.Pending() == 0 {
+			continue
+		}
+		applied, err := j.Replay(ctx, apply)
+		if applied > 0 {
+			log.Printf("push spool replayed entries=%d pending=%d", applied, j.Pending())
+		}
+		if err != nil && !Spoolable(err) && ctx.Err() == nil {
+			log.Printf("push spool replay error: %v", err)
+		}
+	}
+}
+
+// read returns the entries in the journal.
+func (j *Journal) read() ([]Entry, error) {
+	data, err := os.ReadFile(j.path)
+	if err != nil {
+		return nil, fmt.Errorf("read spool: %w", err)
+	}
+	var entries []Entry
+	for i, line := range bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("read spool line %d: %w", i+1, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// rewrite atomically replaces the journal with entries.
+func (j *Journal) rewrite(entries []Entry) error {
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	file, err := os.CreateTemp(filepath.Dir(j.path), ".spool-*")
+	if err != nil {
+		return fmt.Errorf("rewrite spool: %w", err)
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("rewrite spool: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("rewrite spool: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("rewrite spool: %w", err)
+	}
+	if err := os.Rename(file.Name(), j.path); err != nil {
+		return fmt.Errorf("rewrite spool: %w", err)
+	}
+	return nil
+}
+
+func (j *Journal) reject(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := appendLine(j.path+".rejected", line); err != nil {
+		return fmt.Errorf("reject spooled push: %w", err)
+	}
+	return nil
+}
+
+// appendLine appends line and syncs it. A failed append is cut off again so
+// the next one starts on a line of its own.
+func appendLine(path string, line []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	if _, err = file.Write(append(line, '\n')); err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		_ = file.Truncate(info.Size())
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// trimTornAppend creates the journal if needed and removes a final line
+// without a newline, which is an append cut off by a crash before it was
+// acknowledged.
+func trimTornAppend(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 || data[len(data)-1] == '\n' {
+		return nil
+	}
+	return file.Truncate(int64(bytes.LastIndexByte(data, '\n') + 1))
+}
@@ -0,0 +1,92 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestJournalReplaysInOrder(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "spool", "pushes.jsonl")
+	journal, err := Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for _, clientID := range []string{"a", "bad", "b", "c"} {
+		if err := journal.Append(Entry{UserID: "user-1", ClientID: clientID}); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	var seen []string
+	apply := func(_ context.Context, entry Entry) error {
+		switch entry.ClientID {
+		case "bad":
+			return errors.New("client revoked")
+		case "b":
+			if len(seen) == 1 {
+				seen = append(seen, entry.ClientID)
+				// Pushes keep arriving while storage is down.
+				if err := journal.Append(Entry{UserID: "user-1", ClientID: "d"}); err != nil {
+					t.Fatalf("append during replay: %v", err)
+				}
+				return storage.ErrUnavailable
+			}
+		}
+		seen = append(seen, entry.ClientID)
+		return nil
+	}
+	applied, err := journal.Replay(ctx, apply)
+	if applied != 1 || !errors.Is(err, storage.ErrUnavailable) {
+		t.Fatalf("first replay: applied=%d err=%v", applied, err)
+	}
+	if journal.Pending() != 3 {
+		t.Fatalf("expected b, c and d to stay pending, got %d", journal.Pending())
+	}
+
+	// A crash in the middle of an append leaves a partial line behind.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatalf("open journal file: %v", err)
+	}
+	_, _ = file.WriteString(`{"userId":"user-1","cli`)
+	_ = file.Close()
+	journal, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if journal.Pending() != 3 {
+		t.Fatalf("expected the torn append to be dropped, got %d pending", journal.Pending())
+	}
+
+	applied, err = journal.Replay(ctx, apply)
+	if applied != 3 || err != nil {
+		t.Fatalf("second replay: applied=%d err=%v", applied, err)
+	}
+	if want := []string{"a", "b", "b", "c", "d"}; !slices.Equal(seen, want) {
+		t.Fatalf("replay order: got %v, want %v", seen, want)
+	}
+	if journal.Pending() != 0 {
+		t.Fatalf("expected an empty journal, got %d", journal.Pending())
+	}
+	rejected, err := os.ReadFile(path + ".rejected")
+	if err != nil || len(rejected) == 0 {
+		t.Fatalf("expected the failed entry to be kept: %v", err)
+	}
+}
+
+func TestNilJournal(t *testing.T) {
+	var journal *Journal
+	if err := journal.Append(Entry{}); err == nil {
+		t.Fatal("expected a nil journal to refuse entries")
+	}
+	if applied, err := journal.Replay(context.Background(), nil); applied != 0 || err != nil {
+		t.Fatalf("nil replay: %d %v", applied, err)
+	}
+}
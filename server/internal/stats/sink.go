@@ -0,0 +1,113 @@
+package stats
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ParseSink returns the sink for an export target: an http(s) URL is a
+// Prometheus Pushgateway group URL (e.g.
+// http://gateway:9091/metrics/job/tasklists), anything else a file path.
+func ParseSink(target string) (Sink, error) {
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return nil, errors.New("empty export target")
+	}
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		parsed, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		if parsed.Host == "" {
+			return nil, fmt.Errorf("gateway url %q has no host", target)
+		}
+		return &GatewaySink{URL: target, Client: &http.Client{Timeout: 30 * time.Second}}, nil
+	}
+	return &FileSink{Path: target}, nil
+}
+
+// FileSink appends each day to a file as a JSON line.
+type FileSink struct {
+	Path string
+}
+
+func (s *FileSink) Export(_ context.Context, day Day) error {
+	line, err := json.Marshal(day)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// GatewaySink replaces the metrics of a Pushgateway group with each day's
+// numbers, as gauges. The gateway keeps the latest day only; scraping it
+// builds the history.
+type GatewaySink struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *GatewaySink) Export(ctx context.Context, day Day) error {
+	var body bytes.Buffer
+	writeMetrics(&body, day)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.URL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("gateway status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// writeMetrics writes day in the Prometheus text format.
+func writeMetrics(w io.Writer, day Day) {
+	date, _ := time.Parse(time.DateOnly, day.Date)
+	partial := 0
+	if day.Partial {
+		partial = 1
+	}
+	for _, metric := range []struct {
+		name  string
+		help  string
+		value int64
+	}{
+		{"tasklists_stats_day_timestamp_seconds", "Start of the reported UTC day.", date.Unix()},
+		{"tasklists_stats_ops", "Ops added by pushes during the day.", day.Ops},
+		{"tasklists_stats_partial", "1 if ops were only counted for part of the day.", int64(partial)},
+		{"tasklists_stats_users", "Users with a dataset.", int64(day.Users)},
+		{"tasklists_stats_active_users", "Users with a client active in the last 24 hours.", int64(day.ActiveUsers)},
+		{"tasklists_stats_clients", "Known sync clients.", int64(day.Clients)},
+		{"tasklists_stats_active_clients", "Clients that synced in the last 24 hours.", int64(day.ActiveClients)},
+		{"tasklists_stats_lists", "Lists across all users.", int64(day.Lists)},
+		{"tasklists_stats_items", "Visible items across all users.", int64(day.Items)},
+		{"tasklists_stats_op_log_ops", "Ops in the active generations' op logs.", day.OpLogOps},
+		{"tasklists_stats_op_log_bytes", "Payload bytes of the active generations' op logs.", day.OpLogBytes},
+		{"tasklists_stats_snapshot_bytes", "Bytes of the active snapshots.", day.SnapshotBytes},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", metric.name, metric.help, metric.name, metric.name, metric.value)
+	}
+}
@@ -0,0 +1,213 @@
+// Package stats exports daily usage aggregates for capacity planning.
+//
+// An export holds counts only: no user or client ids, no list titles and no
+// op payloads, so it can leave the server without exposing anyone's data.
+// Ops per day cannot be read back from storage (ops carry no timestamps and
+// compaction folds them away), so they are counted in memory as pushes are
+// accepted and start over on restart; the day is then marked partial. The
+// other numbers are read from storage when the day is exported.
+package stats
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"a4-tasklists/server/internal/materialize"
+	"a4-tasklists/server/internal/storage"
+)
+
+// activeWindow is how recently a client must have synced or sent a heartbeat
+// to count as active.
+const activeWindow = 24 * time.Hour
+
+// Day is the export for one UTC day.
+type Day struct {
+	// Date is the day, formatted as 2006-01-02.
+	Date string `json:"date"`
+	// Ops is how many ops pushes carried that day, after validation. Ops a
+	// client retried after a lost response count again.
+	Ops int64 `json:"ops"`
+	// Partial is set when ops were only counted for part of the day, because
+	// the server started after midnight.
+	Partial bool `json:"partial,omitempty"`
+
+	Users       int `json:"users"`
+	ActiveUsers int `json:"activeUsers"`
+	Clients     int `json:"clients"`
+	// ActiveClients synced or sent a heartbeat in the 24 hours before the
+	// export.
+	ActiveClients int `json:"activeClients"`
+	Lists         int `json:"lists"`
+	Items         int `json:"items"`
+
+	// OpLogOps, OpLogBytes and SnapshotBytes total the active generations at
+	// export time.
+	OpLogOps      int64 `json:"opLogOps"`
+	OpLogBytes    int64 `json:"opLogBytes"`
+	SnapshotBytes int64 `json:"snapshotBytes"`
+}
+
+// Sink receives the daily exports.
+type Sink interface {
+	Export(ctx context.Context, day Day) error
+}
+
+// Exporter counts ops and exports a Day to its sink after each UTC midnight.
+// All methods accept a nil Exporter and then do nothing.
+type Exporter struct {
+	store storage.Store
+	sink  Sink
+	now   func() time.Time
+
+	mu      sync.Mutex
+	day     time.Time
+	ops     int64
+	partial bool
+}
+
+// New returns an exporter counting from now. The current day is partial
+// unless it is exactly midnight.
+func New(store storage.Store, sink Sink) *Exporter {
+	e := &Exporter{store: store, sink: sink, now: time.Now}
+	e.start(e.now())
+	return e
+}
+
+func (e *Exporter) start(now time.Time) {
+	e.day = startOfDay(now)
+	e.partial = now.After(e.day)
+}
+
+func startOfDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// Ops counts ops added by a push.
+func (e *Exporter) Ops(n int) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.ops += int64(n)
+}
+
+// Run calls RunOnce every interval until ctx is done. The interval only
+// bounds how late after midnight a day is exported.
+func (e *Exporter) Run(ctx context.Context, interval time.Duration) {
+	if e == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			day, err := e.RunOnce(ctx)
+			if err != nil {
+				log.Printf("stats export error: %v", err)
+			} else if day != nil {
+				log.Printf("stats exported date=%s ops=%d active_clients=%d", day.Date, day.Ops, day.ActiveClients)
+			}
+		}
+	}
+}
+
+// RunOnce exports the previous day once the UTC day has changed and returns
+// it, or nil when the day is still running. Ops counted since midnight move
+// on to the new day, which a missed run can only delay by one interval. When
+// the export fails, the day is not retried; its ops are not added to the next
+// day either.
+func (e *Exporter) RunOnce(ctx context.Context) (*Day, error) {
+	if e == nil {
+		return nil, nil
+	}
+	now := e.now()
+	e.mu.Lock()
+	if !startOfDay(now).After(e.day) {
+		e.mu.Unlock()
+		return nil, nil
+	}
+	day := Day{Date: e.day.Format(time.DateOnly), Ops: e.ops, Partial: e.partial}
+	e.day, e.ops, e.partial = startOfDay(now), 0, false
+	e.mu.Unlock()
+
+	if err := e.collect(ctx, &day, now); err != nil {
+		return nil, fmt.Errorf("collect %s: %w", day.Date, err)
+	}
+	if err := e.sink.Export(ctx, day); err != nil {
+		return nil, fmt.Errorf("export %s: %w", day.Date, err)
+	}
+	return &day, nil
+}
+
+// collect fills in the numbers read from storage. Users whose state cannot
+// be materialized are left out of the list and item counts.
+func (e *Exporter) collect(ctx context.Context, day *Day, now time.Time) error {
+	users, err := e.store.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+	day.Users = len(users)
+	skipped := 0
+	for _, user := range users {
+		day.OpLogOps += user.OpCount
+		day.OpLogBytes += user.OpBytes
+		day.SnapshotBytes += user.SnapshotBytes
+		lists, items, err := e.countLists(ctx, user.UserID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			skipped++
+			continue
+		}
+		day.Lists += lists
+		day.Items += items
+	}
+	if skipped > 0 {
+		// Neither user ids nor errors, which may quote list content, are
+		// logged.
+		log.Printf("stats list counts skipped users=%d", skipped)
+	}
+
+	clients, err := e.store.ListFleetClients(ctx)
+	if err != nil {
+		return err
+	}
+	day.Clients = len(clients)
+	activeUsers := make(map[string]struct{})
+	for _, client := range clients {
+		lastSeen := time.Unix(max(client.UpdatedAt, client.HeartbeatAt), 0)
+		if now.Sub(lastSeen) <= activeWindow {
+			day.ActiveClients++
+			activeUsers[client.UserID] = struct{}{}
+		}
+	}
+	day.ActiveUsers = len(activeUsers)
+	return nil
+}
+
+func (e *Exporter) countLists(ctx context.Context, userID string) (int, int, error) {
+	snapshot, err := e.store.GetSnapshot(ctx, userID)
+	if err != nil {
+		return 0, 0, err
+	}
+	replay, err := materialize.NewReplay(snapshot.Blob)
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err := e.store.ForEachOpSince(ctx, userID, 0, func(op storage.Op) error {
+		replay.Apply(op)
+		return nil
+	}); err != nil {
+		return 0, 0, err
+	}
+	state := replay.State()
+	return len(state.Lists), len(state.Items()), nil
+}
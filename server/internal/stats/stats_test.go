@@ -0,0 +1,152 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+type daySink struct {
+	days []Day
+}
+
+func (s *daySink) Export(_ context.Context, day Day) error {
+	s.days = append(s.days, day)
+	return nil
+}
+
+func TestRunOnceExportsTheFinishedDay(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	for _, userID := range []string{"user-1", "user-2"} {
+		if _, err := store.GetActiveDatasetGenerationKey(ctx, userID); err != nil {
+			t.Fatalf("generation: %v", err)
+		}
+	}
+	if _, err := store.InsertOps(ctx, "user-1", []storage.Op{
+		{Scope: "registry", Resource: "registry", Actor: "a", Clock: 1, Payload: []byte(`{"type":"createList","listId":"list-1","payload":{"title":"Groceries","pos":[{"digit":1,"actor":"a"}]}}`)},
+		{Scope: "list", Resource: "list-1", Actor: "a", Clock: 2, Payload: []byte(`{"type":"insert","itemId":"item-1","payload":{"data":{"text":"milk"},"pos":[{"digit":1,"actor":"a"}]}}`)},
+	}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	for _, client := range []struct{ userID, clientID string }{{"user-1", "phone"}, {"user-1", "laptop"}, {"user-2", "tablet"}} {
+		if err := store.UpdateClientCursor(ctx, client.userID, client.clientID, 0); err != nil {
+			t.Fatalf("cursor: %v", err)
+		}
+	}
+
+	sink := &daySink{}
+	exporter := New(store, sink)
+	now := time.Now().UTC()
+	exporter.now = func() time.Time { return now }
+	exporter.start(time.Date(now.Year(), now.Month(), now.Day(), 8, 0, 0, 0, time.UTC))
+	exporter.Ops(2)
+	exporter.Ops(3)
+	if day, err := exporter.RunOnce(ctx); err != nil || day != nil {
+		t.Fatalf("nothing should be exported during the day: %+v %v", day, err)
+	}
+
+	started := exporter.day
+	now = started.Add(24*time.Hour + time.Minute)
+	day, err := exporter.RunOnce(ctx)
+	if err != nil || day == nil {
+		t.Fatalf("run once: %+v %v", day, err)
+	}
+	want := Day{
+		Date:          started.Format(time.DateOnly),
+		Ops:           5,
+		Partial:       true,
+		Users:         2,
+		ActiveUsers:   2,
+		Clients:       3,
+		ActiveClients: 3,
+		Lists:         1,
+		Items:         1,
+		OpLogOps:      2,
+	}
+	day.OpLogBytes, day.SnapshotBytes = 0, 0
+	if *day != want {
+		t.Fatalf("unexpected day:\n got %+v\nwant %+v", *day, want)
+	}
+	if len(sink.days) != 1 {
+		t.Fatalf("expected one export, got %d", len(sink.days))
+	}
+
+	exporter.now = time.Now
+	if day, err := exporter.RunOnce(ctx); err != nil || day != nil {
+		t.Fatalf("the next day should not be exported yet: %+v %v", day, err)
+	}
+	if exporter.ops != 0 || exporter.partial {
+		t.Fatalf("the next day should start empty: ops=%d partial=%v", exporter.ops, exporter.partial)
+	}
+
+	now = started.Add(10 * 24 * time.Hour)
+	exporter.now = func() time.Time { return now }
+	day, err = exporter.RunOnce(ctx)
+	if err != nil || day == nil || day.ActiveClients != 0 || day.ActiveUsers != 0 {
+		t.Fatalf("clients idle for days should not count as active: %+v %v", day, err)
+	}
+}
+
+func TestSinks(t *testing.T) {
+	day := Day{Date: "2026-03-01", Ops: 12, Users: 2, ActiveClients: 3, Lists: 4}
+
+	path := filepath.Join(t.TempDir(), "stats.jsonl")
+	sink, err := ParseSink(path)
+	if err != nil {
+		t.Fatalf("parse file sink: %v", err)
+	}
+	for range 2 {
+		if err := sink.Export(context.Background(), day); err != nil {
+			t.Fatalf("file export: %v", err)
+		}
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	var exported Day
+	if len(lines) != 2 || json.Unmarshal([]byte(lines[1]), &exported) != nil || exported != day {
+		t.Fatalf("unexpected file export:\n%s", raw)
+	}
+
+	var method, body string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		method, body = r.Method, string(raw)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+	sink, err = ParseSink(gateway.URL + "/metrics/job/tasklists")
+	if err != nil {
+		t.Fatalf("parse gateway sink: %v", err)
+	}
+	if err := sink.Export(context.Background(), day); err != nil {
+		t.Fatalf("gateway export: %v", err)
+	}
+	for _, line := range []string{"tasklists_stats_ops 12", "tasklists_stats_active_clients 3", "tasklists_stats_lists 4", "tasklists_stats_day_timestamp_seconds 1772323200"} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("gateway body misses %q:\n%s", line, body)
+		}
+	}
+	if method != http.MethodPut {
+		t.Fatalf("gateway method: got %s", method)
+	}
+
+	if _, err := ParseSink("http:///no-host"); err == nil {
+		t.Fatalf("gateway url without host should be rejected")
+	}
+}
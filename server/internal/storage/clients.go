@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Orders of ListClientsPage.
+const (
+	// ClientOrderID sorts by user id, then client id.
+	ClientOrderID = "id"
+	// ClientOrderLastSeen sorts the most recently seen clients first.
+	ClientOrderLastSeen = "lastSeen"
+	// ClientOrderLag sorts the clients trailing their user's op log furthest
+	// first.
+	ClientOrderLag = "lag"
+)
+
+// Page sizes of ListClientsPage.
+const (
+	DefaultClientPageSize = 50
+	MaxClientPageSize     = 500
+)
+
+// Errors of ListClientsPage for queries it cannot serve.
+var (
+	// ErrInvalidCursor is returned for a page cursor that was not returned
+	// by a listing in the same order.
+	ErrInvalidCursor = errors.New("invalid page cursor")
+	// ErrUnknownClientOrder is returned for an order that is not one of the
+	// ClientOrder constants.
+	ErrUnknownClientOrder = errors.New("unknown client order")
+)
+
+// ClientQuery selects a page of clients. Ties in the order are broken by user
+// id, then client id.
+type ClientQuery struct {
+	// UserID restricts the listing to one user's clients when set.
+	UserID string
+	// Order is one of the ClientOrder constants; empty means ClientOrderID.
+	Order string
+	// After is the Next cursor of the previous page; empty starts at the
+	// beginning.
+	After string
+	// Limit is the page size, DefaultClientPageSize when zero and at most
+	// MaxClientPageSize.
+	Limit int
+}
+
+// ClientPage is a page of clients.
+type ClientPage struct {
+	Clients []FleetClient `json:"clients"`
+	// Next continues the listing; it is empty on the last page.
+	Next string `json:"next,omitempty"`
+}
+
+// LastSeen is when the client last synced or sent a heartbeat, in Unix
+// seconds.
+func (c FleetClient) LastSeen() int64 {
+	return max(c.UpdatedAt, c.HeartbeatAt)
+}
+
+// Lag is how many serverSeqs the client's cursor trails the latest op of its
+// user's active generation.
+func (c FleetClient) Lag() int64 {
+	return max(c.LatestServerSeq-c.LastSeenServerSeq, 0)
+}
+
+// normalized validates the query and fills in the defaults.
+func (q ClientQuery) normalized() (ClientQuery, error) {
+	switch q.Order {
+	case "":
+		q.Order = ClientOrderID
+	case ClientOrderID, ClientOrderLastSeen, ClientOrderLag:
+	default:
+		return q, fmt.Errorf("%w %q", ErrUnknownClientOrder, q.Order)
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultClientPageSize
+	}
+	q.Limit = min(q.Limit, MaxClientPageSize)
+	return q, nil
+}
+
+// clientCursor is the position after the last client of a page.
+type clientCursor struct {
+	Order    string `json:"o"`
+	Value    int64  `json:"v,omitempty"`
+	UserID   string `json:"u"`
+	ClientID string `json:"c"`
+}
+
+func newClientCursor(order string, client FleetClient) clientCursor {
+	return clientCursor{Order: order, Value: client.sortValue(order), UserID: client.UserID, ClientID: client.ClientID}
+}
+
+func (c clientCursor) encode() string {
+	encoded, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+func decodeClientCursor(order string, after string) (clientCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(after)
+	if err != nil {
+		return clientCursor{}, ErrInvalidCursor
+	}
+	var cursor clientCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil || cursor.Order != order {
+		return clientCursor{}, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// sortValue is the client's primary sort key in order, or 0 for
+// ClientOrderID.
+func (c FleetClient) sortValue(order string) int64 {
+	switch order {
+	case ClientOrderLastSeen:
+		return c.LastSeen()
+	case ClientOrderLag:
+		return c.Lag()
+	default:
+		return 0
+	}
+}
+
+// compareClients orders clients for a listing: descending by the sort value,
+// then ascending by user and client id.
+func compareClients(order string, a, b FleetClient) int {
+	return cmp.Or(
+		cmp.Compare(b.sortValue(order), a.sortValue(order)),
+		strings.Compare(a.UserID, b.UserID),
+		strings.Compare(a.ClientID, b.ClientID),
+	)
+}
+
+// pageClients cuts a page out of clients, which must be sorted with
+// compareClients and hold every client matching the query.
+func pageClients(clients []FleetClient, query ClientQuery) (ClientPage, error) {
+	start := 0
+	if query.After != "" {
+		cursor, err := decodeClientCursor(query.Order, query.After)
+		if err != nil {
+			return ClientPage{}, err
+		}
+		for start < len(clients) && cursor.compare(clients[start]) >= 0 {
+			start++
+		}
+	}
+	page := ClientPage{Clients: clients[start:min(start+query.Limit, len(clients))]}
+	if start+query.Limit < len(clients) {
+		page.Next = newClientCursor(query.Order, page.Clients[len(page.Clients)-1]).encode()
+	}
+	return page, nil
+}
+
+// compare orders the cursor position against client like compareClients.
+func (c clientCursor) compare(client FleetClient) int {
+	return cmp.Or(
+		cmp.Compare(client.sortValue(c.Order), c.Value),
+		strings.Compare(c.UserID, client.UserID),
+		strings.Compare(c.ClientID, client.ClientID),
+	)
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Large op payloads, typically items carrying long notes, are compressed with
+// zstd: at rest when the store is configured to (see CompressPayloads), and
+// on pull when the client asks for it.
+
+// DefaultCompressMinBytes is the payload size from which payloads are
+// compressed unless configured otherwise.
+const DefaultCompressMinBytes = 4096
+
+// PayloadEncodingZstd marks an op whose payload is delivered as a JSON string
+// holding the base64 of the zstd-compressed payload.
+const PayloadEncodingZstd = "zstd"
+
+// maxDecompressedPayload bounds what decompressing a payload may produce, so
+// a corrupt or hostile frame cannot exhaust memory.
+const maxDecompressedPayload = 64 << 20
+
+// compressedPayloadMarker prefixes payloads stored compressed. JSON never
+// starts with a NUL byte, so stored payloads need no extra column to tell
+// them apart, and payloads written before compression was enabled stay
+// readable.
+var compressedPayloadMarker = []byte("\x00zstd\x00")
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedPayload), zstd.WithDecoderConcurrency(0))
+)
+
+// CompressPayload returns payload zstd-compressed.
+func CompressPayload(payload []byte) []byte {
+	return zstdEncoder.EncodeAll(payload, nil)
+}
+
+// DecompressPayload reverses CompressPayload.
+func DecompressPayload(data []byte) ([]byte, error) {
+	payload, err := zstdDecoder.DecodeAll(data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decompress payload: %w", err)
+	}
+	return payload, nil
+}
+
+// CompressOps returns ops with every payload of at least minBytes replaced by
+// its compressed form (see PayloadEncodingZstd) where that is smaller. ops is
+// not modified.
+func CompressOps(ops []Op, minBytes int) []Op {
+	compressed := make([]Op, len(ops))
+	for i, op := range ops {
+		compressed[i] = op
+		if len(op.Payload) < minBytes || op.PayloadEncoding != "" {
+			continue
+		}
+		encoded, err := json.Marshal(base64.StdEncoding.EncodeToString(CompressPayload(op.Payload)))
+		if err != nil || len(encoded) >= len(op.Payload) {
+			continue
+		}
+		compressed[i].Payload = encoded
+		compressed[i].PayloadEncoding = PayloadEncodingZstd
+	}
+	return compressed
+}
+
+// DecompressOp returns op with a payload delivered in the form of CompressOps
+// decompressed again.
+func DecompressOp(op Op) (Op, error) {
+	switch op.PayloadEncoding {
+	case "":
+		return op, nil
+	case PayloadEncodingZstd:
+	default:
+		return Op{}, fmt.Errorf("unknown payload encoding %q", op.PayloadEncoding)
+	}
+	var encoded string
+	if err := json.Unmarshal(op.Payload, &encoded); err != nil {
+		return Op{}, errors.New("compressed payload must be a base64 string")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return Op{}, errors.New("compressed payload must be a base64 string")
+	}
+	if op.Payload, err = DecompressPayload(data); err != nil {
+		return Op{}, err
+	}
+	op.PayloadEncoding = ""
+	return op, nil
+}
+
+// storedPayload returns what the ops table keeps for payload: the payload
+// itself, or marker and compressed payload when it has at least minBytes
+// (minBytes > 0) and compressing makes it smaller.
+func storedPayload(payload []byte, minBytes int) any {
+	if minBytes > 0 && len(payload) >= minBytes {
+		compressed := append(bytes.Clone(compressedPayloadMarker), CompressPayload(payload)...)
+		if len(compressed) < len(payload) {
+			return compressed
+		}
+	}
+	return string(payload)
+}
+
+// loadStoredPayload reverses storedPayload.
+func loadStoredPayload(stored []byte) ([]byte, error) {
+	if data, ok := bytes.CutPrefix(stored, compressedPayloadMarker); ok {
+		return DecompressPayload(data)
+	}
+	return stored, nil
+}
@@ -0,0 +1,36 @@
+package storage_test
+
+import (
+	"strings"
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestCompressOpsRoundTrip(t *testing.T) {
+	large := storage.Op{Scope: "list", Resource: "list-1", Actor: "actor-1", Clock: 1, Payload: []byte(`{"note":"` + strings.Repeat("a long note ", 100) + `"}`)}
+	small := storage.Op{Scope: "list", Resource: "list-1", Actor: "actor-1", Clock: 2, Payload: []byte(`{"note":"short"}`)}
+	ops := []storage.Op{large, small}
+	compressed := storage.CompressOps(ops, 64)
+	if compressed[0].PayloadEncoding != storage.PayloadEncodingZstd || len(compressed[0].Payload) >= len(large.Payload) {
+		t.Fatalf("expected the large payload to be compressed, got %+v", compressed[0])
+	}
+	if compressed[1].PayloadEncoding != "" || string(compressed[1].Payload) != string(small.Payload) {
+		t.Fatalf("expected the small payload to stay as it is, got %+v", compressed[1])
+	}
+	if ops[0].PayloadEncoding != "" {
+		t.Fatal("expected the ops passed in to stay unchanged")
+	}
+	for i, op := range compressed {
+		decoded, err := storage.DecompressOp(op)
+		if err != nil || decoded.PayloadEncoding != "" || string(decoded.Payload) != string(ops[i].Payload) {
+			t.Fatalf("op %d did not round trip: %+v (%v)", i, decoded, err)
+		}
+	}
+	if err := storage.ValidateOp(compressed[0]); err == nil {
+		t.Fatal("expected a compressed op to be refused on push")
+	}
+	if _, err := storage.DecompressOp(storage.Op{PayloadEncoding: "zstd", Payload: []byte(`"not base64!"`)}); err == nil {
+		t.Fatal("expected a malformed compressed payload to be rejected")
+	}
+}
@@ -0,0 +1,1551 @@
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore is an in-memory implementation of Store. It mirrors the SQLite
+// semantics (dedupe, monotonic cursors, generation resets) without touching
+// the filesystem, for tests and demo deployments.
+type MemoryStore struct {
+	mu       sync.Mutex
+	lastSeq  int64
+	users    map[string]*memoryUser
+	actors   map[string]memoryActor
+	profiles map[string]UserProfile
+	passkeys map[string][]Passkey
+	apps     []OAuthApp
+	invites  []memoryInvite
+	settings map[string]Setting
+	changes  []SettingChange
+	outbox   []OutboxEvent
+	outboxID int64
+}
+
+type memoryInvite struct {
+	invite   Invite
+	codeHash string
+}
+
+type memoryActor struct {
+	userID   string
+	clientID string
+}
+
+type memoryUser struct {
+	generations map[string]string
+	snapshot    Snapshot
+	ops         []Op
+	dedupe      map[opKey]struct{}
+	clients     map[string]*memoryClient
+	streams     map[memoryStreamKey]StreamCursor
+	revoked     map[string]struct{}
+	tags        map[TaggedItem]map[string]struct{}
+	digest      DigestSettings
+	templates   []string
+	archived    []string
+	muted       []string
+	apiTokens   []memoryAPIToken
+	household   []HouseholdMember
+	totp        *TOTPFactor
+	serverActor string
+	exports     []ExportSchedule
+	quarantined []QuarantinedOp
+}
+
+type memoryAPIToken struct {
+	token      APIToken
+	secretHash string
+	signingKey string
+}
+
+type memoryStreamKey struct {
+	clientID     string
+	connectionID string
+}
+
+type memoryClient struct {
+	lastSeenServerSeq int64
+	updatedAt         int64
+	hint              string
+	heartbeat         ClientHeartbeat
+}
+
+type opKey struct {
+	actor    string
+	clock    int64
+	scope    string
+	resource string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:    make(map[string]*memoryUser),
+		actors:   make(map[string]memoryActor),
+		profiles: make(map[string]UserProfile),
+		passkeys: make(map[string][]Passkey),
+	}
+}
+
+func (s *MemoryStore) Init(context.Context) error { return nil }
+
+func (s *MemoryStore) Close() error { return nil }
+
+// user returns the user's state, creating the initial generation when missing.
+// Callers must hold s.mu.
+func (s *MemoryStore) user(userID string) (*memoryUser, error) {
+	if userID == "" {
+		return nil, errors.New("userId is required")
+	}
+	if user, ok := s.users[userID]; ok {
+		return user, nil
+	}
+	user := &memoryUser{generations: make(map[string]string), revoked: make(map[string]struct{})}
+	user.install(Snapshot{DatasetGenerationKey: uuid.NewString()})
+	s.users[userID] = user
+	return user, nil
+}
+
+// install activates snapshot as a new generation. generations maps every key
+// the user has used to its parent key, so lineage survives later resets.
+func (u *memoryUser) install(snapshot Snapshot) {
+	snapshot.DatasetGenerationID = int64(len(u.generations) + 1)
+	snapshot.CreatedAt = time.Now().Unix()
+	u.generations[snapshot.DatasetGenerationKey] = snapshot.ParentDatasetGenerationKey
+	u.snapshot = snapshot
+	u.ops = nil
+	u.dedupe = make(map[opKey]struct{})
+	// Cursors point into the replaced generation, but clients stay registered
+	// so pending hints and heartbeat telemetry survive the reset.
+	if u.clients == nil {
+		u.clients = make(map[string]*memoryClient)
+	}
+	for _, client := range u.clients {
+		client.lastSeenServerSeq = 0
+	}
+	u.streams = make(map[memoryStreamKey]StreamCursor)
+	u.tags = make(map[TaggedItem]map[string]struct{})
+	for _, tag := range snapshotTags(snapshot.Blob) {
+		u.addTag(TaggedItem{ListID: tag.listID, ItemID: tag.itemID}, tag.tag)
+	}
+}
+
+func (u *memoryUser) maxServerSeq() int64 {
+	if len(u.ops) == 0 {
+		return 0
+	}
+	return u.ops[len(u.ops)-1].ServerSeq
+}
+
+func (u *memoryUser) addTag(item TaggedItem, tag string) {
+	tags, ok := u.tags[item]
+	if !ok {
+		tags = make(map[string]struct{})
+		u.tags[item] = tags
+	}
+	tags[tag] = struct{}{}
+}
+
+func (u *memoryUser) applyTagChange(change tagChange) {
+	item := TaggedItem{ListID: change.listID, ItemID: change.itemID}
+	switch change.kind {
+	case tagChangeAdd:
+		u.addTag(item, change.tag)
+	case tagChangeRemove:
+		delete(u.tags[item], change.tag)
+	case tagChangeDropItem:
+		delete(u.tags, item)
+	case tagChangeDropList:
+		for candidate := range u.tags {
+			if candidate.ListID == change.listID {
+				delete(u.tags, candidate)
+			}
+		}
+	}
+}
+
+func (s *MemoryStore) InsertOps(_ context.Context, userID string, ops []Op) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return 0, err
+	}
+	for _, op := range ops {
+		if err := ValidateOp(op); err != nil {
+			return 0, err
+		}
+	}
+	stored := false
+	for _, op := range ops {
+		key := opKey{actor: op.Actor, clock: op.Clock, scope: op.Scope, resource: op.Resource}
+		if _, ok := user.dedupe[key]; ok {
+			continue
+		}
+		user.dedupe[key] = struct{}{}
+		s.lastSeq++
+		op.ServerSeq = s.lastSeq
+		op.Payload = slices.Clone(op.Payload)
+		user.ops = append(user.ops, op)
+		user.applyTagChange(tagChangeForOp(op))
+		stored = true
+	}
+	if stored {
+		s.recordOutbox(userID, user, OutboxKindOps)
+	}
+	return user.maxServerSeq(), nil
+}
+
+// recordOutbox adds an outbox event for a change to the user's active
+// generation. Callers must hold s.mu.
+func (s *MemoryStore) recordOutbox(userID string, user *memoryUser, kind string) {
+	s.outboxID++
+	s.outbox = append(s.outbox, OutboxEvent{
+		ID:                   s.outboxID,
+		UserID:               userID,
+		Kind:                 kind,
+		DatasetGenerationKey: user.snapshot.DatasetGenerationKey,
+		ServerSeq:            user.maxServerSeq(),
+		CreatedAt:            time.Now().Unix(),
+	})
+}
+
+func (s *MemoryStore) ListOutbox(_ context.Context, limit int) ([]OutboxEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.outbox[:min(limit, len(s.outbox))]), nil
+}
+
+func (s *MemoryStore) AckOutbox(_ context.Context, events []OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outbox = slices.DeleteFunc(s.outbox, func(pending OutboxEvent) bool {
+		return slices.ContainsFunc(events, func(event OutboxEvent) bool { return event.ID == pending.ID })
+	})
+	return nil
+}
+
+func (s *MemoryStore) GetOpsSince(_ context.Context, userID string, since int64) ([]Op, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	ops := make([]Op, 0)
+	for _, op := range user.ops {
+		if op.ServerSeq > since {
+			op.Payload = slices.Clone(op.Payload)
+			ops = append(ops, op)
+		}
+	}
+	return ops, user.maxServerSeq(), nil
+}
+
+// ForEachOpSince copies the ops first so that fn runs without the lock and
+// may call the store.
+func (s *MemoryStore) ForEachOpSince(ctx context.Context, userID string, since int64, fn func(Op) error) (int64, error) {
+	ops, serverSeq, err := s.GetOpsSince(ctx, userID, since)
+	if err != nil {
+		return 0, err
+	}
+	for _, op := range ops {
+		if err := fn(op); err != nil {
+			return 0, err
+		}
+	}
+	return serverSeq, nil
+}
+
+func (s *MemoryStore) GetActiveDatasetGenerationKey(_ context.Context, userID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return "", err
+	}
+	return user.snapshot.DatasetGenerationKey, nil
+}
+
+func (s *MemoryStore) GetSnapshot(_ context.Context, userID string) (Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return user.snapshot, nil
+}
+
+func (s *MemoryStore) ReplaceSnapshot(ctx context.Context, userID string, snapshot Snapshot) error {
+	return s.ReplaceSnapshotIf(ctx, userID, snapshot, SnapshotPrecondition{})
+}
+
+func (s *MemoryStore) ReplaceSnapshotIf(_ context.Context, userID string, snapshot Snapshot, precondition SnapshotPrecondition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	if snapshot.DatasetGenerationKey == "" {
+		return errors.New("datasetGenerationKey is required")
+	}
+	if _, ok := user.generations[snapshot.DatasetGenerationKey]; ok {
+		return ErrDatasetGenerationKeyExists
+	}
+	if parent := snapshot.ParentDatasetGenerationKey; parent != "" {
+		if _, ok := user.generations[parent]; !ok {
+			return fmt.Errorf("unknown parent datasetGenerationKey %q", parent)
+		}
+	}
+	if precondition.DatasetGenerationKey != "" && precondition.DatasetGenerationKey != user.snapshot.DatasetGenerationKey {
+		return ErrDatasetGenerationChanged
+	}
+	if precondition.CheckServerSeq && precondition.MaxServerSeq != user.maxServerSeq() {
+		return ErrDatasetGenerationChanged
+	}
+	user.install(snapshot)
+	s.recordOutbox(userID, user, OutboxKindGeneration)
+	return nil
+}
+
+func (s *MemoryStore) GetGenerationLineage(_ context.Context, userID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return nil, err
+	}
+	lineage := []string{user.snapshot.DatasetGenerationKey}
+	for parent := user.generations[user.snapshot.DatasetGenerationKey]; parent != ""; parent = user.generations[parent] {
+		lineage = append(lineage, parent)
+	}
+	return lineage, nil
+}
+
+func (s *MemoryStore) GetOpStats(_ context.Context, userID string) (OpStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return OpStats{}, err
+	}
+	stats := OpStats{Count: int64(len(user.ops)), MaxServerSeq: user.maxServerSeq()}
+	for _, op := range user.ops {
+		stats.Bytes += int64(len(op.Payload))
+	}
+	return stats, nil
+}
+
+func (s *MemoryStore) ListUsers(context.Context) ([]UserSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := make([]UserSummary, 0, len(s.users))
+	for userID, user := range s.users {
+		summary := UserSummary{
+			UserID:               userID,
+			DatasetGenerationKey: user.snapshot.DatasetGenerationKey,
+			Usage: Usage{
+				SnapshotBytes: int64(len(user.snapshot.Blob)),
+				OpCount:       int64(len(user.ops)),
+				ClientCount:   int64(len(user.clients)),
+			},
+			MaxServerSeq: user.maxServerSeq(),
+		}
+		for _, op := range user.ops {
+			summary.OpBytes += int64(len(op.Payload))
+		}
+		users = append(users, summary)
+	}
+	slices.SortFunc(users, func(a, b UserSummary) int { return strings.Compare(a.UserID, b.UserID) })
+	return users, nil
+}
+
+func (s *MemoryStore) GetUsage(_ context.Context, userID string) (Usage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return Usage{}, err
+	}
+	usage := Usage{
+		SnapshotBytes: int64(len(user.snapshot.Blob)),
+		OpCount:       int64(len(user.ops)),
+		ClientCount:   int64(len(user.clients)),
+	}
+	for _, op := range user.ops {
+		usage.OpBytes += int64(len(op.Payload))
+	}
+	return usage, nil
+}
+
+func (s *MemoryStore) BindActors(_ context.Context, userID string, clientID string, actors []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.user(userID); err != nil {
+		return err
+	}
+	if clientID == "" {
+		return errors.New("clientId is required")
+	}
+	for _, actor := range actors {
+		if owner, ok := s.actors[actor]; ok && owner.userID != userID {
+			return fmt.Errorf("%w: %s", ErrActorNotOwned, actor)
+		}
+	}
+	for _, actor := range actors {
+		if _, ok := s.actors[actor]; !ok {
+			s.actors[actor] = memoryActor{userID: userID, clientID: clientID}
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) MigrateUserID(_ context.Context, fromUserID string, toUserID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if fromUserID == "" || toUserID == "" {
+		return errors.New("userId is required")
+	}
+	user, ok := s.users[fromUserID]
+	if !ok {
+		return nil
+	}
+	if _, exists := s.users[toUserID]; exists {
+		return nil
+	}
+	s.users[toUserID] = user
+	delete(s.users, fromUserID)
+	if profile, ok := s.profiles[fromUserID]; ok {
+		s.profiles[toUserID] = profile
+		delete(s.profiles, fromUserID)
+	}
+	if passkeys, ok := s.passkeys[fromUserID]; ok {
+		s.passkeys[toUserID] = passkeys
+		delete(s.passkeys, fromUserID)
+	}
+	for actor, owner := range s.actors {
+		if owner.userID == fromUserID {
+			owner.userID = toUserID
+			s.actors[actor] = owner
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) ListPasskeys(_ context.Context, userID string) ([]Passkey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.passkeys[userID]), nil
+}
+
+func (s *MemoryStore) AddPasskey(_ context.Context, userID string, passkey Passkey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.user(userID); err != nil {
+		return err
+	}
+	for _, existing := range s.passkeys {
+		for _, candidate := range existing {
+			if bytes.Equal(candidate.CredentialID, passkey.CredentialID) {
+				return errors.New("passkey already registered")
+			}
+		}
+	}
+	s.passkeys[userID] = append(s.passkeys[userID], passkey)
+	return nil
+}
+
+func (s *MemoryStore) UpdatePasskey(_ context.Context, userID string, passkey Passkey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, candidate := range s.passkeys[userID] {
+		if bytes.Equal(candidate.CredentialID, passkey.CredentialID) {
+			s.passkeys[userID][i] = passkey
+			return nil
+		}
+	}
+	return errors.New("passkey not found")
+}
+
+func (s *MemoryStore) CountPasskeys(context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var count int64
+	for _, passkeys := range s.passkeys {
+		count += int64(len(passkeys))
+	}
+	return count, nil
+}
+
+func (s *MemoryStore) GetTOTPFactor(_ context.Context, userID string) (TOTPFactor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok || user.totp == nil {
+		return TOTPFactor{}, ErrTOTPFactorNotFound
+	}
+	factor := *user.totp
+	factor.RecoveryCodeHashes = slices.Clone(factor.RecoveryCodeHashes)
+	return factor, nil
+}
+
+func (s *MemoryStore) SetTOTPFactor(_ context.Context, userID string, factor TOTPFactor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	if factor.Secret == "" {
+		return errors.New("totp secret is required")
+	}
+	factor.RecoveryCodeHashes = slices.Clone(factor.RecoveryCodeHashes)
+	user.totp = &factor
+	return nil
+}
+
+func (s *MemoryStore) DeleteTOTPFactor(_ context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[userID]; ok {
+		user.totp = nil
+	}
+	return nil
+}
+
+func (s *MemoryStore) AcceptTOTPStep(_ context.Context, userID string, step int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok || user.totp == nil || step <= user.totp.LastStep {
+		return false, nil
+	}
+	user.totp.LastStep = step
+	return true, nil
+}
+
+func (s *MemoryStore) UseTOTPRecoveryCode(_ context.Context, userID string, codeHash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok || user.totp == nil {
+		return false, nil
+	}
+	i := slices.Index(user.totp.RecoveryCodeHashes, codeHash)
+	if i < 0 {
+		return false, nil
+	}
+	user.totp.RecoveryCodeHashes = slices.Delete(user.totp.RecoveryCodeHashes, i, i+1)
+	return true, nil
+}
+
+func (s *MemoryStore) UpdateUserProfile(_ context.Context, userID string, profile UserProfile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.user(userID); err != nil {
+		return err
+	}
+	stored := s.profiles[userID]
+	if stored.Locale != "" {
+		profile.Locale = stored.Locale
+	}
+	if stored.TimeZone != "" {
+		profile.TimeZone = stored.TimeZone
+	}
+	s.profiles[userID] = profile
+	return nil
+}
+
+func (s *MemoryStore) ServerActor(_ context.Context, userID string, candidate string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return "", err
+	}
+	if candidate == "" {
+		return "", errors.New("candidate actor is required")
+	}
+	if user.serverActor == "" {
+		user.serverActor = candidate
+	}
+	return user.serverActor, nil
+}
+
+func (s *MemoryStore) SetTimeZone(_ context.Context, userID string, timeZone string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.user(userID); err != nil {
+		return err
+	}
+	profile := s.profiles[userID]
+	profile.TimeZone = timeZone
+	s.profiles[userID] = profile
+	return nil
+}
+
+func (s *MemoryStore) SetLocale(_ context.Context, userID string, locale string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.user(userID); err != nil {
+		return err
+	}
+	profile := s.profiles[userID]
+	profile.Locale = locale
+	s.profiles[userID] = profile
+	return nil
+}
+
+func (s *MemoryStore) GetUserProfile(_ context.Context, userID string) (UserProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.user(userID); err != nil {
+		return UserProfile{}, err
+	}
+	return s.profiles[userID], nil
+}
+
+func (s *MemoryStore) GetDigestSettings(_ context.Context, userID string) (DigestSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return DigestSettings{}, err
+	}
+	settings := user.digest
+	settings.UserID = userID
+	settings.TimeZone = s.profiles[userID].TimeZone
+	return settings, nil
+}
+
+func (s *MemoryStore) SetDigestFrequency(_ context.Context, userID string, frequency string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	if user.digest.Frequency != frequency {
+		user.digest = DigestSettings{Frequency: frequency}
+	}
+	return nil
+}
+
+func (s *MemoryStore) ListDigestSubscriptions(context.Context) ([]DigestSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subscriptions := make([]DigestSettings, 0)
+	for userID, user := range s.users {
+		if user.digest.Frequency == "" {
+			continue
+		}
+		settings := user.digest
+		settings.UserID = userID
+		settings.TimeZone = s.profiles[userID].TimeZone
+		subscriptions = append(subscriptions, settings)
+	}
+	slices.SortFunc(subscriptions, func(a, b DigestSettings) int {
+		return strings.Compare(a.UserID, b.UserID)
+	})
+	return subscriptions, nil
+}
+
+func (s *MemoryStore) MarkDigestSent(_ context.Context, userID string, sentAt int64, serverSeq int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	user.digest.LastSentAt = sentAt
+	user.digest.LastServerSeq = serverSeq
+	return nil
+}
+
+func (s *MemoryStore) GetActorAttribution(_ context.Context, userID string, actors []string) (map[string]UserProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.user(userID); err != nil {
+		return nil, err
+	}
+	attribution := make(map[string]UserProfile)
+	for _, actor := range actors {
+		owner, ok := s.actors[actor]
+		if !ok || owner.userID == userID {
+			continue
+		}
+		if profile := s.profiles[owner.userID].Attribution(); profile != (UserProfile{}) {
+			attribution[actor] = profile
+		}
+	}
+	return attribution, nil
+}
+
+func (s *MemoryStore) TouchClient(_ context.Context, userID string, clientID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	if clientID == "" {
+		return errors.New("clientId is required")
+	}
+	client, ok := user.clients[clientID]
+	if !ok {
+		client = &memoryClient{}
+		user.clients[clientID] = client
+	}
+	client.updatedAt = time.Now().Unix()
+	return nil
+}
+
+func (s *MemoryStore) UpdateClientCursor(_ context.Context, userID string, clientID string, serverSeq int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	if clientID == "" {
+		return errors.New("clientId is required")
+	}
+	client, ok := user.clients[clientID]
+	if !ok {
+		client = &memoryClient{}
+		user.clients[clientID] = client
+	}
+	client.lastSeenServerSeq = max(client.lastSeenServerSeq, serverSeq)
+	client.updatedAt = time.Now().Unix()
+	return nil
+}
+
+func (s *MemoryStore) RecordClientHeartbeat(_ context.Context, userID string, clientID string, heartbeat ClientHeartbeat) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	if clientID == "" {
+		return errors.New("clientId is required")
+	}
+	client, ok := user.clients[clientID]
+	if !ok {
+		client = &memoryClient{lastSeenServerSeq: heartbeat.ReportedServerSeq}
+		user.clients[clientID] = client
+	}
+	client.heartbeat = heartbeat
+	client.updatedAt = time.Now().Unix()
+	return nil
+}
+
+func (s *MemoryStore) ListClients(_ context.Context, userID string) ([]Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return nil, err
+	}
+	clients := make([]Client, 0, len(user.clients))
+	for clientID, client := range user.clients {
+		clients = append(clients, Client{ClientID: clientID, LastSeenServerSeq: client.lastSeenServerSeq, UpdatedAt: client.updatedAt, Hint: client.hint, ClientHeartbeat: client.heartbeat})
+	}
+	slices.SortFunc(clients, func(a, b Client) int { return strings.Compare(a.ClientID, b.ClientID) })
+	return clients, nil
+}
+
+func (s *MemoryStore) ListFleetClients(context.Context) ([]FleetClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clients := make([]FleetClient, 0)
+	for userID, user := range s.users {
+		latest := user.maxServerSeq()
+		for clientID, client := range user.clients {
+			clients = append(clients, FleetClient{
+				UserID:          userID,
+				Client:          Client{ClientID: clientID, LastSeenServerSeq: client.lastSeenServerSeq, UpdatedAt: client.updatedAt, Hint: client.hint, ClientHeartbeat: client.heartbeat},
+				LatestServerSeq: latest,
+			})
+		}
+	}
+	slices.SortFunc(clients, func(a, b FleetClient) int {
+		return cmp.Or(strings.Compare(a.UserID, b.UserID), strings.Compare(a.ClientID, b.ClientID))
+	})
+	return clients, nil
+}
+
+func (s *MemoryStore) ListClientsPage(ctx context.Context, query ClientQuery) (ClientPage, error) {
+	query, err := query.normalized()
+	if err != nil {
+		return ClientPage{}, err
+	}
+	clients, err := s.ListFleetClients(ctx)
+	if err != nil {
+		return ClientPage{}, err
+	}
+	if query.UserID != "" {
+		clients = slices.DeleteFunc(clients, func(client FleetClient) bool { return client.UserID != query.UserID })
+	}
+	slices.SortFunc(clients, func(a, b FleetClient) int { return compareClients(query.Order, a, b) })
+	return pageClients(clients, query)
+}
+
+func (s *MemoryStore) MinClientCursor(_ context.Context, userID string) (int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return 0, false, err
+	}
+	var cursor int64
+	found := false
+	for _, client := range user.clients {
+		if !found || client.lastSeenServerSeq < cursor {
+			cursor, found = client.lastSeenServerSeq, true
+		}
+	}
+	return cursor, found, nil
+}
+
+func (s *MemoryStore) SetClientHint(_ context.Context, userID string, clientID string, hint string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	if clientID == "" {
+		return errors.New("clientId is required")
+	}
+	client, ok := user.clients[clientID]
+	if !ok {
+		return ErrClientNotFound
+	}
+	client.hint = hint
+	return nil
+}
+
+func (s *MemoryStore) RetireClient(_ context.Context, userID string, clientID string, revoke bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	if clientID == "" {
+		return errors.New("clientId is required")
+	}
+	if _, ok := user.clients[clientID]; !ok && !revoke {
+		return ErrClientNotFound
+	}
+	delete(user.clients, clientID)
+	for key := range user.streams {
+		if key.clientID == clientID {
+			delete(user.streams, key)
+		}
+	}
+	if revoke {
+		user.revoked[clientID] = struct{}{}
+	}
+	return nil
+}
+
+func (s *MemoryStore) CheckClient(_ context.Context, userID string, clientID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	if _, ok := user.revoked[clientID]; ok {
+		return ErrClientRevoked
+	}
+	return nil
+}
+
+func (s *MemoryStore) SaveStreamCursor(_ context.Context, userID string, cursor StreamCursor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	if cursor.ClientID == "" || cursor.ConnectionID == "" {
+		return errors.New("clientId and connectionId are required")
+	}
+	if cursor.UpdatedAt == 0 {
+		cursor.UpdatedAt = time.Now().Unix()
+	}
+	key := memoryStreamKey{clientID: cursor.ClientID, connectionID: cursor.ConnectionID}
+	delete(user.streams, key)
+	var kept []memoryStreamKey
+	for other := range user.streams {
+		if other.clientID == cursor.ClientID {
+			kept = append(kept, other)
+		}
+	}
+	if len(kept) >= MaxStreamCursorsPerClient {
+		slices.SortFunc(kept, func(a, b memoryStreamKey) int {
+			return cmp.Compare(user.streams[a].UpdatedAt, user.streams[b].UpdatedAt)
+		})
+		for _, oldest := range kept[:len(kept)-MaxStreamCursorsPerClient+1] {
+			delete(user.streams, oldest)
+		}
+	}
+	user.streams[key] = cursor
+	return nil
+}
+
+func (s *MemoryStore) GetStreamCursor(_ context.Context, userID string, clientID string, connectionID string) (StreamCursor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return StreamCursor{}, err
+	}
+	cursor, ok := user.streams[memoryStreamKey{clientID: clientID, connectionID: connectionID}]
+	if !ok {
+		return StreamCursor{}, ErrStreamCursorNotFound
+	}
+	return cursor, nil
+}
+
+func (s *MemoryStore) TakeClientHint(_ context.Context, userID string, clientID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return "", err
+	}
+	client, ok := user.clients[clientID]
+	if !ok {
+		return "", nil
+	}
+	hint := client.hint
+	client.hint = ""
+	return hint, nil
+}
+
+func (s *MemoryStore) ListTags(_ context.Context, userID string) ([]TagCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64)
+	for _, tags := range user.tags {
+		for tag := range tags {
+			counts[tag]++
+		}
+	}
+	result := make([]TagCount, 0, len(counts))
+	for tag, count := range counts {
+		result = append(result, TagCount{Tag: tag, Count: count})
+	}
+	slices.SortFunc(result, func(a, b TagCount) int { return strings.Compare(a.Tag, b.Tag) })
+	return result, nil
+}
+
+func (s *MemoryStore) ListTaggedItems(_ context.Context, userID string, tag string) ([]TaggedItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return nil, err
+	}
+	tag = NormalizeTag(tag)
+	items := make([]TaggedItem, 0)
+	for item, tags := range user.tags {
+		if _, ok := tags[tag]; ok {
+			items = append(items, item)
+		}
+	}
+	slices.SortFunc(items, func(a, b TaggedItem) int {
+		if cmp := strings.Compare(a.ListID, b.ListID); cmp != 0 {
+			return cmp
+		}
+		return strings.Compare(a.ItemID, b.ItemID)
+	})
+	return items, nil
+}
+
+func (s *MemoryStore) SetListTemplate(_ context.Context, userID string, listID string, template bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	user.templates = markList(user.templates, listID, template)
+	return nil
+}
+
+func (s *MemoryStore) ListTemplates(_ context.Context, userID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{}, user.templates...), nil
+}
+
+func (s *MemoryStore) SetListArchived(_ context.Context, userID string, listID string, archived bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	user.archived = markList(user.archived, listID, archived)
+	return nil
+}
+
+func (s *MemoryStore) ListArchivedLists(_ context.Context, userID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{}, user.archived...), nil
+}
+
+func (s *MemoryStore) SetListMuted(_ context.Context, userID string, listID string, muted bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	user.muted = markList(user.muted, listID, muted)
+	return nil
+}
+
+func (s *MemoryStore) ListMutedLists(_ context.Context, userID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{}, user.muted...), nil
+}
+
+// markList adds listID to (or removes it from) an ordered set of list ids.
+func markList(listIDs []string, listID string, marked bool) []string {
+	present := slices.Contains(listIDs, listID)
+	switch {
+	case marked && !present:
+		return append(listIDs, listID)
+	case !marked && present:
+		return slices.DeleteFunc(listIDs, func(id string) bool { return id == listID })
+	}
+	return listIDs
+}
+
+func (s *MemoryStore) CreateAPIToken(_ context.Context, userID string, token APIToken, secretHash string, signingKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	if token.ID == "" || secretHash == "" {
+		return errors.New("token id and secret hash are required")
+	}
+	token.UserID = ""
+	token.Scopes = slices.Clone(token.Scopes)
+	user.apiTokens = append(user.apiTokens, memoryAPIToken{token: token, secretHash: secretHash, signingKey: signingKey})
+	return nil
+}
+
+func (s *MemoryStore) ListAPITokens(_ context.Context, userID string) ([]APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return nil, err
+	}
+	tokens := make([]APIToken, 0, len(user.apiTokens))
+	for _, stored := range user.apiTokens {
+		token := stored.token
+		token.UserID = userID
+		token.Scopes = slices.Clone(token.Scopes)
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+func (s *MemoryStore) RevokeAPIToken(_ context.Context, userID string, tokenID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(user.apiTokens, func(stored memoryAPIToken) bool { return stored.token.ID == tokenID })
+	if i < 0 {
+		return ErrAPITokenNotFound
+	}
+	user.apiTokens = slices.Delete(user.apiTokens, i, i+1)
+	return nil
+}
+
+func (s *MemoryStore) CreateHouseholdMember(_ context.Context, userID string, member HouseholdMember) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	if member.ID == "" {
+		return errors.New("member id is required")
+	}
+	member.ListIDs = slices.Clone(member.ListIDs)
+	user.household = append(user.household, member)
+	return nil
+}
+
+func (s *MemoryStore) ListHouseholdMembers(_ context.Context, userID string) ([]HouseholdMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]HouseholdMember, 0, len(user.household))
+	for _, member := range user.household {
+		member.ListIDs = slices.Clone(member.ListIDs)
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+func (s *MemoryStore) GetHouseholdMember(_ context.Context, userID string, memberID string) (HouseholdMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return HouseholdMember{}, err
+	}
+	i := slices.IndexFunc(user.household, func(member HouseholdMember) bool { return member.ID == memberID })
+	if i < 0 {
+		return HouseholdMember{}, ErrHouseholdMemberNotFound
+	}
+	member := user.household[i]
+	member.ListIDs = slices.Clone(member.ListIDs)
+	return member, nil
+}
+
+func (s *MemoryStore) UpdateHouseholdMember(_ context.Context, userID string, member HouseholdMember) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(user.household, func(existing HouseholdMember) bool { return existing.ID == member.ID })
+	if i < 0 {
+		return ErrHouseholdMemberNotFound
+	}
+	user.household[i].Name, user.household[i].ListIDs = member.Name, slices.Clone(member.ListIDs)
+	return nil
+}
+
+func (s *MemoryStore) DeleteHouseholdMember(_ context.Context, userID string, memberID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(user.household, func(member HouseholdMember) bool { return member.ID == memberID })
+	if i < 0 {
+		return ErrHouseholdMemberNotFound
+	}
+	user.household = slices.Delete(user.household, i, i+1)
+	user.apiTokens = slices.DeleteFunc(user.apiTokens, func(stored memoryAPIToken) bool { return stored.token.MemberID == memberID })
+	return nil
+}
+
+func (s *MemoryStore) CreateExportSchedule(_ context.Context, userID string, schedule ExportSchedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	if schedule.ID == "" || len(schedule.SealedConfig) == 0 {
+		return errors.New("schedule id and sealed config are required")
+	}
+	schedule.UserID = ""
+	schedule.SealedConfig = slices.Clone(schedule.SealedConfig)
+	user.exports = append(user.exports, schedule)
+	return nil
+}
+
+func (s *MemoryStore) ListExportSchedules(_ context.Context, userID string) ([]ExportSchedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return nil, err
+	}
+	return user.exportSchedules(userID), nil
+}
+
+func (s *MemoryStore) ListAllExportSchedules(context.Context) ([]ExportSchedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	userIDs := slices.Sorted(maps.Keys(s.users))
+	schedules := make([]ExportSchedule, 0)
+	for _, userID := range userIDs {
+		schedules = append(schedules, s.users[userID].exportSchedules(userID)...)
+	}
+	return schedules, nil
+}
+
+func (u *memoryUser) exportSchedules(userID string) []ExportSchedule {
+	schedules := make([]ExportSchedule, 0, len(u.exports))
+	for _, schedule := range u.exports {
+		schedule.UserID = userID
+		schedule.SealedConfig = slices.Clone(schedule.SealedConfig)
+		schedules = append(schedules, schedule)
+	}
+	return schedules
+}
+
+func (s *MemoryStore) DeleteExportSchedule(_ context.Context, userID string, scheduleID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(user.exports, func(schedule ExportSchedule) bool { return schedule.ID == scheduleID })
+	if i < 0 {
+		return ErrExportScheduleNotFound
+	}
+	user.exports = slices.Delete(user.exports, i, i+1)
+	return nil
+}
+
+func (s *MemoryStore) MarkExportRun(_ context.Context, userID string, scheduleID string, ranAt int64, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(user.exports, func(schedule ExportSchedule) bool { return schedule.ID == scheduleID })
+	if i < 0 {
+		return ErrExportScheduleNotFound
+	}
+	user.exports[i].LastRunAt, user.exports[i].LastError = ranAt, lastError
+	return nil
+}
+
+func (s *MemoryStore) UseAPIToken(_ context.Context, secretHash string, usedAt int64) (APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for userID, user := range s.users {
+		for i := range user.apiTokens {
+			if stored := &user.apiTokens[i]; stored.secretHash == secretHash {
+				if stored.token.ExpiresAt != 0 && stored.token.ExpiresAt <= usedAt {
+					return APIToken{}, ErrAPITokenNotFound
+				}
+				stored.token.LastUsedAt = usedAt
+				token := stored.token
+				token.UserID = userID
+				token.Scopes = slices.Clone(token.Scopes)
+				return token, nil
+			}
+		}
+	}
+	return APIToken{}, ErrAPITokenNotFound
+}
+
+func (s *MemoryStore) SignedAPIToken(_ context.Context, tokenID string, now int64) (APIToken, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for userID, user := range s.users {
+		for _, stored := range user.apiTokens {
+			if stored.token.ID != tokenID || !stored.token.Signed || (stored.token.ExpiresAt != 0 && stored.token.ExpiresAt <= now) {
+				continue
+			}
+			token := stored.token
+			token.UserID = userID
+			token.Scopes = slices.Clone(token.Scopes)
+			return token, stored.signingKey, nil
+		}
+	}
+	return APIToken{}, "", ErrAPITokenNotFound
+}
+
+func (s *MemoryStore) UseSignedAPIToken(_ context.Context, tokenID string, usedAt int64) (APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for userID, user := range s.users {
+		for i := range user.apiTokens {
+			stored := &user.apiTokens[i]
+			if stored.token.ID != tokenID || !stored.token.Signed {
+				continue
+			}
+			if stored.token.ExpiresAt != 0 && stored.token.ExpiresAt <= usedAt {
+				return APIToken{}, ErrAPITokenNotFound
+			}
+			stored.token.LastUsedAt = usedAt
+			token := stored.token
+			token.UserID = userID
+			token.Scopes = slices.Clone(token.Scopes)
+			return token, nil
+		}
+	}
+	return APIToken{}, ErrAPITokenNotFound
+}
+
+func (s *MemoryStore) CreateOAuthApp(_ context.Context, app OAuthApp) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if app.ID == "" || len(app.RedirectURIs) == 0 {
+		return errors.New("app id and redirect uris are required")
+	}
+	if slices.ContainsFunc(s.apps, func(existing OAuthApp) bool { return existing.ID == app.ID }) {
+		return fmt.Errorf("oauth app %s already exists", app.ID)
+	}
+	s.apps = append(s.apps, cloneOAuthApp(app))
+	return nil
+}
+
+func (s *MemoryStore) GetOAuthApp(_ context.Context, appID string) (OAuthApp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.apps, func(app OAuthApp) bool { return app.ID == appID })
+	if i < 0 {
+		return OAuthApp{}, ErrOAuthAppNotFound
+	}
+	return cloneOAuthApp(s.apps[i]), nil
+}
+
+func (s *MemoryStore) ListOAuthApps(context.Context) ([]OAuthApp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	apps := make([]OAuthApp, 0, len(s.apps))
+	for _, app := range s.apps {
+		apps = append(apps, cloneOAuthApp(app))
+	}
+	return apps, nil
+}
+
+func (s *MemoryStore) DeleteOAuthApp(_ context.Context, appID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.apps, func(app OAuthApp) bool { return app.ID == appID })
+	if i < 0 {
+		return ErrOAuthAppNotFound
+	}
+	s.apps = slices.Delete(s.apps, i, i+1)
+	return nil
+}
+
+func cloneOAuthApp(app OAuthApp) OAuthApp {
+	app.RedirectURIs = slices.Clone(app.RedirectURIs)
+	app.Scopes = slices.Clone(app.Scopes)
+	return app
+}
+
+func (s *MemoryStore) UserExists(_ context.Context, userID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, user := s.users[userID]
+	_, profile := s.profiles[userID]
+	return user || profile, nil
+}
+
+func (s *MemoryStore) CreateInvite(_ context.Context, invite Invite, codeHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if invite.ID == "" || codeHash == "" || invite.MaxUses < 1 {
+		return errors.New("invite id, code hash and uses are required")
+	}
+	if slices.ContainsFunc(s.invites, func(existing memoryInvite) bool {
+		return existing.invite.ID == invite.ID || existing.codeHash == codeHash
+	}) {
+		return fmt.Errorf("invite %s already exists", invite.ID)
+	}
+	invite.Uses, invite.LastUsedAt = 0, 0
+	s.invites = append(s.invites, memoryInvite{invite: invite, codeHash: codeHash})
+	return nil
+}
+
+func (s *MemoryStore) ListInvites(context.Context) ([]Invite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	invites := make([]Invite, 0, len(s.invites))
+	for _, stored := range s.invites {
+		invites = append(invites, stored.invite)
+	}
+	slices.SortStableFunc(invites, func(a, b Invite) int { return cmp.Compare(a.CreatedAt, b.CreatedAt) })
+	return invites, nil
+}
+
+func (s *MemoryStore) DeleteInvite(_ context.Context, inviteID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.invites, func(stored memoryInvite) bool { return stored.invite.ID == inviteID })
+	if i < 0 {
+		return ErrInviteNotFound
+	}
+	s.invites = slices.Delete(s.invites, i, i+1)
+	return nil
+}
+
+func (s *MemoryStore) RedeemInvite(_ context.Context, codeHash string, usedAt int64) (Invite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.invites {
+		invite := &s.invites[i].invite
+		if s.invites[i].codeHash != codeHash || invite.Uses >= invite.MaxUses || (invite.ExpiresAt != 0 && invite.ExpiresAt <= usedAt) {
+			continue
+		}
+		invite.Uses++
+		invite.LastUsedAt = usedAt
+		return *invite, nil
+	}
+	return Invite{}, ErrInviteNotFound
+}
+
+func (s *MemoryStore) ListSettings(context.Context) ([]Setting, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	settings := make([]Setting, 0, len(s.settings))
+	for _, key := range slices.Sorted(maps.Keys(s.settings)) {
+		settings = append(settings, s.settings[key])
+	}
+	return settings, nil
+}
+
+func (s *MemoryStore) PutSetting(_ context.Context, setting Setting) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if setting.Key == "" {
+		return errors.New("setting key is required")
+	}
+	change := SettingChange{Key: setting.Key, Value: &setting.Value, ChangedAt: setting.UpdatedAt, ChangedBy: setting.UpdatedBy}
+	if previous, ok := s.settings[setting.Key]; ok {
+		change.Previous = &previous.Value
+	}
+	if s.settings == nil {
+		s.settings = make(map[string]Setting)
+	}
+	s.settings[setting.Key] = setting
+	s.recordSettingChange(change)
+	return nil
+}
+
+func (s *MemoryStore) DeleteSetting(_ context.Context, key string, changedBy string, changedAt int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, ok := s.settings[key]
+	if !ok {
+		return ErrSettingNotFound
+	}
+	delete(s.settings, key)
+	s.recordSettingChange(SettingChange{Key: key, Previous: &previous.Value, ChangedAt: changedAt, ChangedBy: changedBy})
+	return nil
+}
+
+func (s *MemoryStore) recordSettingChange(change SettingChange) {
+	change.ID = int64(len(s.changes)) + 1
+	s.changes = append(s.changes, change)
+}
+
+func (s *MemoryStore) ListSettingChanges(_ context.Context, limit int) ([]SettingChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changes := slices.Clone(s.changes)
+	slices.Reverse(changes)
+	if limit > 0 && len(changes) > limit {
+		changes = changes[:limit]
+	}
+	return changes, nil
+}
+
+func (s *MemoryStore) QuarantineOp(_ context.Context, userID string, serverSeq int64, reason string, quarantinedAt int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(user.ops, func(op Op) bool { return op.ServerSeq == serverSeq })
+	if i < 0 {
+		return ErrOpNotFound
+	}
+	op := user.ops[i]
+	user.ops = slices.Delete(user.ops, i, i+1)
+	delete(user.dedupe, opKey{actor: op.Actor, clock: op.Clock, scope: op.Scope, resource: op.Resource})
+	user.quarantined = append(user.quarantined, QuarantinedOp{Op: op, Reason: reason, QuarantinedAt: quarantinedAt})
+	return nil
+}
+
+func (s *MemoryStore) ListQuarantinedOps(context.Context) ([]QuarantinedOp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ops := make([]QuarantinedOp, 0)
+	for userID, user := range s.users {
+		for _, op := range user.quarantined {
+			ops = append(ops, cloneQuarantinedOp(op, userID))
+		}
+	}
+	slices.SortFunc(ops, func(a, b QuarantinedOp) int { return cmp.Compare(a.ServerSeq, b.ServerSeq) })
+	return ops, nil
+}
+
+func (s *MemoryStore) GetQuarantinedOp(_ context.Context, serverSeq int64) (QuarantinedOp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for userID, user := range s.users {
+		for _, op := range user.quarantined {
+			if op.ServerSeq == serverSeq {
+				return cloneQuarantinedOp(op, userID), nil
+			}
+		}
+	}
+	return QuarantinedOp{}, ErrOpNotFound
+}
+
+func (s *MemoryStore) DeleteQuarantinedOp(_ context.Context, serverSeq int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		i := slices.IndexFunc(user.quarantined, func(op QuarantinedOp) bool { return op.ServerSeq == serverSeq })
+		if i >= 0 {
+			user.quarantined = slices.Delete(user.quarantined, i, i+1)
+			return nil
+		}
+	}
+	return ErrOpNotFound
+}
+
+func cloneQuarantinedOp(op QuarantinedOp, userID string) QuarantinedOp {
+	op.UserID = userID
+	op.Payload = slices.Clone(op.Payload)
+	return op
+}
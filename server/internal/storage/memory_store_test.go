@@ -0,0 +1,14 @@
+package storage_test
+
+import (
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+	"a4-tasklists/server/internal/storage/storagetest"
+)
+
+func TestMemoryStoreContract(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		return storage.NewMemoryStore()
+	})
+}
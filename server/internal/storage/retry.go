@@ -0,0 +1,595 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"syscall"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLite allows one writer at a time. Under load a write can fail with
+// SQLITE_BUSY even with a busy timeout, and such failures go away on their
+// own, unlike constraint violations or corrupt input. RetryingStore retries
+// the former with jittered backoff and, when the database keeps failing,
+// stops calling it for a while so requests fail fast instead of queueing.
+
+// ErrUnavailable is returned while the circuit breaker is open.
+var ErrUnavailable = errors.New("storage temporarily unavailable")
+
+// IsTransient reports whether err is a failure that may succeed when retried,
+// such as a busy or locked database. Callers should answer such errors with
+// 503 rather than 500.
+func IsTransient(err error) bool {
+	if errors.Is(err, ErrUnavailable) {
+		return true
+	}
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		// Extended codes such as SQLITE_BUSY_SNAPSHOT keep the primary code
+		// in the low byte.
+		switch sqliteErr.Code() & 0xff {
+		case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+			return true
+		}
+	}
+	return false
+}
+
+// IsFull reports whether err means the disk holding the database is full.
+// Unlike transient errors it does not go away by retrying right away, but it
+// usually does once an operator frees space.
+func IsFull(err error) bool {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code()&0xff == sqlite3.SQLITE_FULL {
+		return true
+	}
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+// RetryPolicy configures a RetryingStore. Zero fields take the defaults.
+type RetryPolicy struct {
+	// Attempts is how often a call is tried in total. 1 disables retries.
+	Attempts int
+	// BaseDelay is the backoff before the first retry; it doubles with every
+	// further retry up to MaxDelay. The actual delay is drawn uniformly from
+	// zero up to that bound so that retrying requests spread out.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// BreakerThreshold is the number of consecutive calls that failed with a
+	// transient error after which the breaker opens. Zero disables the breaker.
+	BreakerThreshold int
+	// BreakerCooldown is how long an open breaker fails calls with
+	// ErrUnavailable before it lets calls through again.
+	BreakerCooldown time.Duration
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.Attempts <= 0 {
+		p.Attempts = 3
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = 20 * time.Millisecond
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = 500 * time.Millisecond
+	}
+	if p.BreakerCooldown <= 0 {
+		p.BreakerCooldown = 5 * time.Second
+	}
+	return p
+}
+
+// RetryingStore wraps a Store and retries calls that fail with a transient
+// error. Init and Close are passed through unchanged.
+type RetryingStore struct {
+	inner  Store
+	policy RetryPolicy
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	onFailure func(err error)
+}
+
+// NewRetryingStore wraps inner with policy.
+func NewRetryingStore(inner Store, policy RetryPolicy) *RetryingStore {
+	return &RetryingStore{inner: inner, policy: policy.withDefaults()}
+}
+
+// Unwrap returns the wrapped store.
+func (s *RetryingStore) Unwrap() Store {
+	return s.inner
+}
+
+// OnFailure sets a function that is called with the error when the breaker
+// opens and when a call fails because the disk is full, so that operators
+// can be told. It is called without locks held and must not block.
+func (s *RetryingStore) OnFailure(hook func(err error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onFailure = hook
+}
+
+// BreakerOpen reports whether calls currently fail fast.
+func (s *RetryingStore) BreakerOpen() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Now().Before(s.openUntil)
+}
+
+// finalError makes do return err without retrying it.
+type finalError struct {
+	err error
+}
+
+func (e finalError) Error() string { return e.err.Error() }
+
+// do runs fn until it succeeds, fails permanently, or runs out of attempts.
+func (s *RetryingStore) do(ctx context.Context, fn func() error) error {
+	if s.BreakerOpen() {
+		return ErrUnavailable
+	}
+	delay := s.policy.BaseDelay
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if final, ok := err.(finalError); ok {
+			err = final.err
+			break
+		}
+		if !IsTransient(err) || attempt >= s.policy.Attempts {
+			break
+		}
+		timer := time.NewTimer(rand.N(delay) + 1)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			s.record(err)
+			return err
+		}
+		delay = min(2*delay, s.policy.MaxDelay)
+	}
+	s.record(err)
+	return err
+}
+
+// record updates the breaker with the outcome of a call and reports the
+// failures OnFailure asks for.
+func (s *RetryingStore) record(err error) {
+	s.mu.Lock()
+	hook := s.onFailure
+	opened := false
+	if !IsTransient(err) {
+		s.failures = 0
+	} else {
+		s.failures++
+		if s.policy.BreakerThreshold > 0 && s.failures >= s.policy.BreakerThreshold {
+			now := time.Now()
+			opened = !now.Before(s.openUntil)
+			s.openUntil = now.Add(s.policy.BreakerCooldown)
+		}
+	}
+	s.mu.Unlock()
+	if hook == nil {
+		return
+	}
+	switch {
+	case opened:
+		hook(fmt.Errorf("storage circuit breaker opened after %d failed calls: %w", s.policy.BreakerThreshold, err))
+	case IsFull(err):
+		hook(err)
+	}
+}
+
+func retryValue[T any](ctx context.Context, s *RetryingStore, fn func() (T, error)) (T, error) {
+	var value T
+	err := s.do(ctx, func() error {
+		var err error
+		value, err = fn()
+		return err
+	})
+	return value, err
+}
+
+func (s *RetryingStore) Init(ctx context.Context) error {
+	return s.inner.Init(ctx)
+}
+
+func (s *RetryingStore) Close() error {
+	return s.inner.Close()
+}
+
+func (s *RetryingStore) InsertOps(ctx context.Context, userID string, ops []Op) (int64, error) {
+	return retryValue(ctx, s, func() (int64, error) { return s.inner.InsertOps(ctx, userID, ops) })
+}
+
+func (s *RetryingStore) GetOpsSince(ctx context.Context, userID string, since int64) ([]Op, int64, error) {
+	var ops []Op
+	var seq int64
+	err := s.do(ctx, func() error {
+		var err error
+		ops, seq, err = s.inner.GetOpsSince(ctx, userID, since)
+		return err
+	})
+	return ops, seq, err
+}
+
+// ForEachOpSince is only retried while fn has not seen an op, so fn never
+// sees an op twice.
+func (s *RetryingStore) ForEachOpSince(ctx context.Context, userID string, since int64, fn func(Op) error) (int64, error) {
+	var seq int64
+	delivered := false
+	err := s.do(ctx, func() error {
+		var err error
+		seq, err = s.inner.ForEachOpSince(ctx, userID, since, func(op Op) error {
+			delivered = true
+			return fn(op)
+		})
+		if err != nil && delivered {
+			return finalError{err}
+		}
+		return err
+	})
+	return seq, err
+}
+
+func (s *RetryingStore) GetActiveDatasetGenerationKey(ctx context.Context, userID string) (string, error) {
+	return retryValue(ctx, s, func() (string, error) { return s.inner.GetActiveDatasetGenerationKey(ctx, userID) })
+}
+
+func (s *RetryingStore) GetSnapshot(ctx context.Context, userID string) (Snapshot, error) {
+	return retryValue(ctx, s, func() (Snapshot, error) { return s.inner.GetSnapshot(ctx, userID) })
+}
+
+func (s *RetryingStore) ReplaceSnapshot(ctx context.Context, userID string, snapshot Snapshot) error {
+	return s.do(ctx, func() error { return s.inner.ReplaceSnapshot(ctx, userID, snapshot) })
+}
+
+func (s *RetryingStore) ReplaceSnapshotIf(ctx context.Context, userID string, snapshot Snapshot, precondition SnapshotPrecondition) error {
+	return s.do(ctx, func() error { return s.inner.ReplaceSnapshotIf(ctx, userID, snapshot, precondition) })
+}
+
+func (s *RetryingStore) ListOutbox(ctx context.Context, limit int) ([]OutboxEvent, error) {
+	return retryValue(ctx, s, func() ([]OutboxEvent, error) { return s.inner.ListOutbox(ctx, limit) })
+}
+
+func (s *RetryingStore) AckOutbox(ctx context.Context, events []OutboxEvent) error {
+	return s.do(ctx, func() error { return s.inner.AckOutbox(ctx, events) })
+}
+
+func (s *RetryingStore) GetGenerationLineage(ctx context.Context, userID string) ([]string, error) {
+	return retryValue(ctx, s, func() ([]string, error) { return s.inner.GetGenerationLineage(ctx, userID) })
+}
+
+func (s *RetryingStore) GetOpStats(ctx context.Context, userID string) (OpStats, error) {
+	return retryValue(ctx, s, func() (OpStats, error) { return s.inner.GetOpStats(ctx, userID) })
+}
+
+func (s *RetryingStore) GetUsage(ctx context.Context, userID string) (Usage, error) {
+	return retryValue(ctx, s, func() (Usage, error) { return s.inner.GetUsage(ctx, userID) })
+}
+
+func (s *RetryingStore) ListUsers(ctx context.Context) ([]UserSummary, error) {
+	return retryValue(ctx, s, func() ([]UserSummary, error) { return s.inner.ListUsers(ctx) })
+}
+
+func (s *RetryingStore) BindActors(ctx context.Context, userID string, clientID string, actors []string) error {
+	return s.do(ctx, func() error { return s.inner.BindActors(ctx, userID, clientID, actors) })
+}
+
+func (s *RetryingStore) MigrateUserID(ctx context.Context, fromUserID string, toUserID string) error {
+	return s.do(ctx, func() error { return s.inner.MigrateUserID(ctx, fromUserID, toUserID) })
+}
+
+func (s *RetryingStore) ListPasskeys(ctx context.Context, userID string) ([]Passkey, error) {
+	return retryValue(ctx, s, func() ([]Passkey, error) { return s.inner.ListPasskeys(ctx, userID) })
+}
+
+func (s *RetryingStore) AddPasskey(ctx context.Context, userID string, passkey Passkey) error {
+	return s.do(ctx, func() error { return s.inner.AddPasskey(ctx, userID, passkey) })
+}
+
+func (s *RetryingStore) UpdatePasskey(ctx context.Context, userID string, passkey Passkey) error {
+	return s.do(ctx, func() error { return s.inner.UpdatePasskey(ctx, userID, passkey) })
+}
+
+func (s *RetryingStore) CountPasskeys(ctx context.Context) (int64, error) {
+	return retryValue(ctx, s, func() (int64, error) { return s.inner.CountPasskeys(ctx) })
+}
+
+func (s *RetryingStore) GetTOTPFactor(ctx context.Context, userID string) (TOTPFactor, error) {
+	return retryValue(ctx, s, func() (TOTPFactor, error) { return s.inner.GetTOTPFactor(ctx, userID) })
+}
+
+func (s *RetryingStore) SetTOTPFactor(ctx context.Context, userID string, factor TOTPFactor) error {
+	return s.do(ctx, func() error { return s.inner.SetTOTPFactor(ctx, userID, factor) })
+}
+
+func (s *RetryingStore) DeleteTOTPFactor(ctx context.Context, userID string) error {
+	return s.do(ctx, func() error { return s.inner.DeleteTOTPFactor(ctx, userID) })
+}
+
+func (s *RetryingStore) AcceptTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	return retryValue(ctx, s, func() (bool, error) { return s.inner.AcceptTOTPStep(ctx, userID, step) })
+}
+
+func (s *RetryingStore) UseTOTPRecoveryCode(ctx context.Context, userID string, codeHash string) (bool, error) {
+	return retryValue(ctx, s, func() (bool, error) { return s.inner.UseTOTPRecoveryCode(ctx, userID, codeHash) })
+}
+
+func (s *RetryingStore) UpdateUserProfile(ctx context.Context, userID string, profile UserProfile) error {
+	return s.do(ctx, func() error { return s.inner.UpdateUserProfile(ctx, userID, profile) })
+}
+
+func (s *RetryingStore) ServerActor(ctx context.Context, userID string, candidate string) (string, error) {
+	return retryValue(ctx, s, func() (string, error) { return s.inner.ServerActor(ctx, userID, candidate) })
+}
+
+func (s *RetryingStore) SetTimeZone(ctx context.Context, userID string, timeZone string) error {
+	return s.do(ctx, func() error { return s.inner.SetTimeZone(ctx, userID, timeZone) })
+}
+
+func (s *RetryingStore) SetLocale(ctx context.Context, userID string, locale string) error {
+	return s.do(ctx, func() error { return s.inner.SetLocale(ctx, userID, locale) })
+}
+
+func (s *RetryingStore) GetUserProfile(ctx context.Context, userID string) (UserProfile, error) {
+	return retryValue(ctx, s, func() (UserProfile, error) { return s.inner.GetUserProfile(ctx, userID) })
+}
+
+func (s *RetryingStore) GetDigestSettings(ctx context.Context, userID string) (DigestSettings, error) {
+	return retryValue(ctx, s, func() (DigestSettings, error) { return s.inner.GetDigestSettings(ctx, userID) })
+}
+
+func (s *RetryingStore) SetDigestFrequency(ctx context.Context, userID string, frequency string) error {
+	return s.do(ctx, func() error { return s.inner.SetDigestFrequency(ctx, userID, frequency) })
+}
+
+func (s *RetryingStore) ListDigestSubscriptions(ctx context.Context) ([]DigestSettings, error) {
+	return retryValue(ctx, s, func() ([]DigestSettings, error) { return s.inner.ListDigestSubscriptions(ctx) })
+}
+
+func (s *RetryingStore) MarkDigestSent(ctx context.Context, userID string, sentAt int64, serverSeq int64) error {
+	return s.do(ctx, func() error { return s.inner.MarkDigestSent(ctx, userID, sentAt, serverSeq) })
+}
+
+func (s *RetryingStore) CreateExportSchedule(ctx context.Context, userID string, schedule ExportSchedule) error {
+	return s.do(ctx, func() error { return s.inner.CreateExportSchedule(ctx, userID, schedule) })
+}
+
+func (s *RetryingStore) ListExportSchedules(ctx context.Context, userID string) ([]ExportSchedule, error) {
+	return retryValue(ctx, s, func() ([]ExportSchedule, error) { return s.inner.ListExportSchedules(ctx, userID) })
+}
+
+func (s *RetryingStore) DeleteExportSchedule(ctx context.Context, userID string, scheduleID string) error {
+	return s.do(ctx, func() error { return s.inner.DeleteExportSchedule(ctx, userID, scheduleID) })
+}
+
+func (s *RetryingStore) ListAllExportSchedules(ctx context.Context) ([]ExportSchedule, error) {
+	return retryValue(ctx, s, func() ([]ExportSchedule, error) { return s.inner.ListAllExportSchedules(ctx) })
+}
+
+func (s *RetryingStore) MarkExportRun(ctx context.Context, userID string, scheduleID string, ranAt int64, lastError string) error {
+	return s.do(ctx, func() error { return s.inner.MarkExportRun(ctx, userID, scheduleID, ranAt, lastError) })
+}
+
+func (s *RetryingStore) GetActorAttribution(ctx context.Context, userID string, actors []string) (map[string]UserProfile, error) {
+	return retryValue(ctx, s, func() (map[string]UserProfile, error) { return s.inner.GetActorAttribution(ctx, userID, actors) })
+}
+
+func (s *RetryingStore) TouchClient(ctx context.Context, userID string, clientID string) error {
+	return s.do(ctx, func() error { return s.inner.TouchClient(ctx, userID, clientID) })
+}
+
+func (s *RetryingStore) UpdateClientCursor(ctx context.Context, userID string, clientID string, serverSeq int64) error {
+	return s.do(ctx, func() error { return s.inner.UpdateClientCursor(ctx, userID, clientID, serverSeq) })
+}
+
+func (s *RetryingStore) RecordClientHeartbeat(ctx context.Context, userID string, clientID string, heartbeat ClientHeartbeat) error {
+	return s.do(ctx, func() error { return s.inner.RecordClientHeartbeat(ctx, userID, clientID, heartbeat) })
+}
+
+func (s *RetryingStore) ListClients(ctx context.Context, userID string) ([]Client, error) {
+	return retryValue(ctx, s, func() ([]Client, error) { return s.inner.ListClients(ctx, userID) })
+}
+
+func (s *RetryingStore) ListFleetClients(ctx context.Context) ([]FleetClient, error) {
+	return retryValue(ctx, s, func() ([]FleetClient, error) { return s.inner.ListFleetClients(ctx) })
+}
+
+func (s *RetryingStore) ListClientsPage(ctx context.Context, query ClientQuery) (ClientPage, error) {
+	return retryValue(ctx, s, func() (ClientPage, error) { return s.inner.ListClientsPage(ctx, query) })
+}
+
+func (s *RetryingStore) MinClientCursor(ctx context.Context, userID string) (int64, bool, error) {
+	var cursor int64
+	var ok bool
+	err := s.do(ctx, func() error {
+		var err error
+		cursor, ok, err = s.inner.MinClientCursor(ctx, userID)
+		return err
+	})
+	return cursor, ok, err
+}
+
+func (s *RetryingStore) SetClientHint(ctx context.Context, userID string, clientID string, hint string) error {
+	return s.do(ctx, func() error { return s.inner.SetClientHint(ctx, userID, clientID, hint) })
+}
+
+func (s *RetryingStore) TakeClientHint(ctx context.Context, userID string, clientID string) (string, error) {
+	return retryValue(ctx, s, func() (string, error) { return s.inner.TakeClientHint(ctx, userID, clientID) })
+}
+
+func (s *RetryingStore) RetireClient(ctx context.Context, userID string, clientID string, revoke bool) error {
+	return s.do(ctx, func() error { return s.inner.RetireClient(ctx, userID, clientID, revoke) })
+}
+
+func (s *RetryingStore) CheckClient(ctx context.Context, userID string, clientID string) error {
+	return s.do(ctx, func() error { return s.inner.CheckClient(ctx, userID, clientID) })
+}
+
+func (s *RetryingStore) SaveStreamCursor(ctx context.Context, userID string, cursor StreamCursor) error {
+	return s.do(ctx, func() error { return s.inner.SaveStreamCursor(ctx, userID, cursor) })
+}
+
+func (s *RetryingStore) GetStreamCursor(ctx context.Context, userID string, clientID string, connectionID string) (StreamCursor, error) {
+	return retryValue(ctx, s, func() (StreamCursor, error) { return s.inner.GetStreamCursor(ctx, userID, clientID, connectionID) })
+}
+
+func (s *RetryingStore) ListTags(ctx context.Context, userID string) ([]TagCount, error) {
+	return retryValue(ctx, s, func() ([]TagCount, error) { return s.inner.ListTags(ctx, userID) })
+}
+
+func (s *RetryingStore) ListTaggedItems(ctx context.Context, userID string, tag string) ([]TaggedItem, error) {
+	return retryValue(ctx, s, func() ([]TaggedItem, error) { return s.inner.ListTaggedItems(ctx, userID, tag) })
+}
+
+func (s *RetryingStore) SetListTemplate(ctx context.Context, userID string, listID string, template bool) error {
+	return s.do(ctx, func() error { return s.inner.SetListTemplate(ctx, userID, listID, template) })
+}
+
+func (s *RetryingStore) ListTemplates(ctx context.Context, userID string) ([]string, error) {
+	return retryValue(ctx, s, func() ([]string, error) { return s.inner.ListTemplates(ctx, userID) })
+}
+
+func (s *RetryingStore) SetListArchived(ctx context.Context, userID string, listID string, archived bool) error {
+	return s.do(ctx, func() error { return s.inner.SetListArchived(ctx, userID, listID, archived) })
+}
+
+func (s *RetryingStore) ListArchivedLists(ctx context.Context, userID string) ([]string, error) {
+	return retryValue(ctx, s, func() ([]string, error) { return s.inner.ListArchivedLists(ctx, userID) })
+}
+
+func (s *RetryingStore) SetListMuted(ctx context.Context, userID string, listID string, muted bool) error {
+	return s.do(ctx, func() error { return s.inner.SetListMuted(ctx, userID, listID, muted) })
+}
+
+func (s *RetryingStore) ListMutedLists(ctx context.Context, userID string) ([]string, error) {
+	return retryValue(ctx, s, func() ([]string, error) { return s.inner.ListMutedLists(ctx, userID) })
+}
+
+func (s *RetryingStore) CreateAPIToken(ctx context.Context, userID string, token APIToken, secretHash string, signingKey string) error {
+	return s.do(ctx, func() error { return s.inner.CreateAPIToken(ctx, userID, token, secretHash, signingKey) })
+}
+
+func (s *RetryingStore) ListAPITokens(ctx context.Context, userID string) ([]APIToken, error) {
+	return retryValue(ctx, s, func() ([]APIToken, error) { return s.inner.ListAPITokens(ctx, userID) })
+}
+
+func (s *RetryingStore) RevokeAPIToken(ctx context.Context, userID string, tokenID string) error {
+	return s.do(ctx, func() error { return s.inner.RevokeAPIToken(ctx, userID, tokenID) })
+}
+
+func (s *RetryingStore) UseAPIToken(ctx context.Context, secretHash string, usedAt int64) (APIToken, error) {
+	return retryValue(ctx, s, func() (APIToken, error) { return s.inner.UseAPIToken(ctx, secretHash, usedAt) })
+}
+
+func (s *RetryingStore) SignedAPIToken(ctx context.Context, tokenID string, now int64) (APIToken, string, error) {
+	var token APIToken
+	var signingKey string
+	err := s.do(ctx, func() error {
+		var err error
+		token, signingKey, err = s.inner.SignedAPIToken(ctx, tokenID, now)
+		return err
+	})
+	return token, signingKey, err
+}
+
+func (s *RetryingStore) UseSignedAPIToken(ctx context.Context, tokenID string, usedAt int64) (APIToken, error) {
+	return retryValue(ctx, s, func() (APIToken, error) { return s.inner.UseSignedAPIToken(ctx, tokenID, usedAt) })
+}
+
+func (s *RetryingStore) CreateOAuthApp(ctx context.Context, app OAuthApp) error {
+	return s.do(ctx, func() error { return s.inner.CreateOAuthApp(ctx, app) })
+}
+
+func (s *RetryingStore) GetOAuthApp(ctx context.Context, appID string) (OAuthApp, error) {
+	return retryValue(ctx, s, func() (OAuthApp, error) { return s.inner.GetOAuthApp(ctx, appID) })
+}
+
+func (s *RetryingStore) ListOAuthApps(ctx context.Context) ([]OAuthApp, error) {
+	return retryValue(ctx, s, func() ([]OAuthApp, error) { return s.inner.ListOAuthApps(ctx) })
+}
+
+func (s *RetryingStore) DeleteOAuthApp(ctx context.Context, appID string) error {
+	return s.do(ctx, func() error { return s.inner.DeleteOAuthApp(ctx, appID) })
+}
+
+func (s *RetryingStore) UserExists(ctx context.Context, userID string) (bool, error) {
+	return retryValue(ctx, s, func() (bool, error) { return s.inner.UserExists(ctx, userID) })
+}
+
+func (s *RetryingStore) CreateHouseholdMember(ctx context.Context, userID string, member HouseholdMember) error {
+	return s.do(ctx, func() error { return s.inner.CreateHouseholdMember(ctx, userID, member) })
+}
+
+func (s *RetryingStore) ListHouseholdMembers(ctx context.Context, userID string) ([]HouseholdMember, error) {
+	return retryValue(ctx, s, func() ([]HouseholdMember, error) { return s.inner.ListHouseholdMembers(ctx, userID) })
+}
+
+func (s *RetryingStore) GetHouseholdMember(ctx context.Context, userID string, memberID string) (HouseholdMember, error) {
+	return retryValue(ctx, s, func() (HouseholdMember, error) { return s.inner.GetHouseholdMember(ctx, userID, memberID) })
+}
+
+func (s *RetryingStore) UpdateHouseholdMember(ctx context.Context, userID string, member HouseholdMember) error {
+	return s.do(ctx, func() error { return s.inner.UpdateHouseholdMember(ctx, userID, member) })
+}
+
+func (s *RetryingStore) DeleteHouseholdMember(ctx context.Context, userID string, memberID string) error {
+	return s.do(ctx, func() error { return s.inner.DeleteHouseholdMember(ctx, userID, memberID) })
+}
+
+func (s *RetryingStore) CreateInvite(ctx context.Context, invite Invite, codeHash string) error {
+	return s.do(ctx, func() error { return s.inner.CreateInvite(ctx, invite, codeHash) })
+}
+
+func (s *RetryingStore) ListInvites(ctx context.Context) ([]Invite, error) {
+	return retryValue(ctx, s, func() ([]Invite, error) { return s.inner.ListInvites(ctx) })
+}
+
+func (s *RetryingStore) DeleteInvite(ctx context.Context, inviteID string) error {
+	return s.do(ctx, func() error { return s.inner.DeleteInvite(ctx, inviteID) })
+}
+
+func (s *RetryingStore) RedeemInvite(ctx context.Context, codeHash string, usedAt int64) (Invite, error) {
+	return retryValue(ctx, s, func() (Invite, error) { return s.inner.RedeemInvite(ctx, codeHash, usedAt) })
+}
+
+func (s *RetryingStore) ListSettings(ctx context.Context) ([]Setting, error) {
+	return retryValue(ctx, s, func() ([]Setting, error) { return s.inner.ListSettings(ctx) })
+}
+
+func (s *RetryingStore) PutSetting(ctx context.Context, setting Setting) error {
+	return s.do(ctx, func() error { return s.inner.PutSetting(ctx, setting) })
+}
+
+func (s *RetryingStore) DeleteSetting(ctx context.Context, key string, changedBy string, changedAt int64) error {
+	return s.do(ctx, func() error { return s.inner.DeleteSetting(ctx, key, changedBy, changedAt) })
+}
+
+func (s *RetryingStore) ListSettingChanges(ctx context.Context, limit int) ([]SettingChange, error) {
+	return retryValue(ctx, s, func() ([]SettingChange, error) { return s.inner.ListSettingChanges(ctx, limit) })
+}
+
+func (s *RetryingStore) QuarantineOp(ctx context.Context, userID string, serverSeq int64, reason string, quarantinedAt int64) error {
+	return s.do(ctx, func() error { return s.inner.QuarantineOp(ctx, userID, serverSeq, reason, quarantinedAt) })
+}
+
+func (s *RetryingStore) ListQuarantinedOps(ctx context.Context) ([]QuarantinedOp, error) {
+	return retryValue(ctx, s, func() ([]QuarantinedOp, error) { return s.inner.ListQuarantinedOps(ctx) })
+}
+
+func (s *RetryingStore) GetQuarantinedOp(ctx context.Context, serverSeq int64) (QuarantinedOp, error) {
+	return retryValue(ctx, s, func() (QuarantinedOp, error) { return s.inner.GetQuarantinedOp(ctx, serverSeq) })
+}
+
+func (s *RetryingStore) DeleteQuarantinedOp(ctx context.Context, serverSeq int64) error {
+	return s.do(ctx, func() error { return s.inner.DeleteQuarantinedOp(ctx, serverSeq) })
+}
@@ -0,0 +1,217 @@
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"a4-tasklists/server/internal/storage"
+	"a4-tasklists/server/internal/storage/storagetest"
+)
+
+func TestRetryingStoreContract(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		return storage.NewRetryingStore(newSQLiteStore(t), storage.RetryPolicy{})
+	})
+}
+
+// busyError returns the error SQLite reports for a write while another
+// connection holds the write lock.
+func busyError(t *testing.T) error {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "busy.db")
+	holder, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { _ = holder.Close() })
+	if _, err := holder.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	tx, err := holder.Begin()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	t.Cleanup(func() { _ = tx.Rollback() })
+	if _, err := tx.Exec("INSERT INTO t (id) VALUES (1)"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	writer, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { _ = writer.Close() })
+	_, err = writer.Exec("INSERT INTO t (id) VALUES (2)")
+	if err == nil {
+		t.Fatal("expected the second writer to fail")
+	}
+	return err
+}
+
+func TestIsTransient(t *testing.T) {
+	if err := busyError(t); !storage.IsTransient(err) {
+		t.Fatalf("busy error %v is not transient", err)
+	}
+	if !storage.IsTransient(storage.ErrUnavailable) {
+		t.Fatal("ErrUnavailable is not transient")
+	}
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "constraint.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+	if _, err := db.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY); INSERT INTO t (id) VALUES (1)"); err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	_, err = db.Exec("INSERT INTO t (id) VALUES (1)")
+	if err == nil || storage.IsTransient(err) {
+		t.Fatalf("constraint violation %v should be permanent", err)
+	}
+	if storage.IsTransient(storage.ErrClientNotFound) {
+		t.Fatal("ErrClientNotFound should be permanent")
+	}
+}
+
+func TestIsFull(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "full.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+	// A page limit stands in for a full disk.
+	if _, err := db.Exec("CREATE TABLE t (data BLOB); PRAGMA max_page_count = 4"); err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	_, err = db.Exec("INSERT INTO t (data) VALUES (zeroblob(65536))")
+	if !storage.IsFull(err) {
+		t.Fatalf("expected a full database, got %v", err)
+	}
+	if storage.IsTransient(err) {
+		t.Fatal("a full disk should not be retried like a busy database")
+	}
+	if storage.IsFull(busyError(t)) {
+		t.Fatal("busy error reported as full")
+	}
+}
+
+// flakyStore fails GetUsage with err for the first failures calls.
+type flakyStore struct {
+	storage.Store
+	err      error
+	failures int
+	calls    int
+}
+
+func (s *flakyStore) GetUsage(ctx context.Context, userID string) (storage.Usage, error) {
+	s.calls++
+	if s.calls <= s.failures {
+		return storage.Usage{}, s.err
+	}
+	return s.Store.GetUsage(ctx, userID)
+}
+
+func TestRetryingStoreRetriesTransientErrors(t *testing.T) {
+	ctx := context.Background()
+	inner := &flakyStore{Store: storage.NewMemoryStore(), err: busyError(t), failures: 2}
+	store := storage.NewRetryingStore(inner, storage.RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond})
+	if _, err := store.GetUsage(ctx, "user-1"); err != nil {
+		t.Fatalf("get usage: %v", err)
+	}
+	if inner.calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", inner.calls)
+	}
+
+	permanent := errors.New("broken")
+	inner = &flakyStore{Store: storage.NewMemoryStore(), err: permanent, failures: 2}
+	store = storage.NewRetryingStore(inner, storage.RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond})
+	if _, err := store.GetUsage(ctx, "user-1"); !errors.Is(err, permanent) {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+	if inner.calls != 1 {
+		t.Fatalf("permanent errors must not be retried, got %d calls", inner.calls)
+	}
+}
+
+func TestRetryingStoreBreaker(t *testing.T) {
+	ctx := context.Background()
+	inner := &flakyStore{Store: storage.NewMemoryStore(), err: busyError(t), failures: 4}
+	store := storage.NewRetryingStore(inner, storage.RetryPolicy{
+		Attempts:         2,
+		BaseDelay:        time.Millisecond,
+		BreakerThreshold: 2,
+		BreakerCooldown:  50 * time.Millisecond,
+	})
+	var reported []error
+	store.OnFailure(func(err error) { reported = append(reported, err) })
+	for range 2 {
+		if _, err := store.GetUsage(ctx, "user-1"); !storage.IsTransient(err) {
+			t.Fatalf("expected transient error, got %v", err)
+		}
+	}
+	if !store.BreakerOpen() {
+		t.Fatal("expected the breaker to open")
+	}
+	if len(reported) != 1 || !storage.IsTransient(reported[0]) {
+		t.Fatalf("expected the breaker opening to be reported once, got %v", reported)
+	}
+	if _, err := store.GetUsage(ctx, "user-1"); !errors.Is(err, storage.ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable, got %v", err)
+	}
+	if inner.calls != 4 {
+		t.Fatalf("an open breaker must not call the store, got %d calls", inner.calls)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := store.GetUsage(ctx, "user-1"); err != nil {
+		t.Fatalf("expected the store to recover after the cooldown: %v", err)
+	}
+	if store.BreakerOpen() {
+		t.Fatal("expected the breaker to close after a success")
+	}
+}
+
+// midStreamStore fails ForEachOpSince with err after delivering the first op.
+type midStreamStore struct {
+	storage.Store
+	err   error
+	calls int
+}
+
+func (s *midStreamStore) ForEachOpSince(ctx context.Context, userID string, since int64, fn func(storage.Op) error) (int64, error) {
+	s.calls++
+	ops, _, err := s.GetOpsSince(ctx, userID, since)
+	if err != nil {
+		return 0, err
+	}
+	if len(ops) > 0 {
+		if err := fn(ops[0]); err != nil {
+			return 0, err
+		}
+	}
+	return 0, s.err
+}
+
+func TestRetryingStoreDoesNotRetryIterationsThatDeliveredOps(t *testing.T) {
+	ctx := context.Background()
+	memory := storage.NewMemoryStore()
+	if _, err := memory.InsertOps(ctx, "user-1", []storage.Op{{Scope: "list", Resource: "list-1", Actor: "a", Clock: 1, Payload: []byte(`{}`)}}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	inner := &midStreamStore{Store: memory, err: busyError(t)}
+	store := storage.NewRetryingStore(inner, storage.RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond})
+	seen := 0
+	_, err := store.ForEachOpSince(ctx, "user-1", 0, func(storage.Op) error {
+		seen++
+		return nil
+	})
+	if !storage.IsTransient(err) {
+		t.Fatalf("expected the transient error, got %v", err)
+	}
+	if inner.calls != 1 || seen != 1 {
+		t.Fatalf("a partly delivered iteration must not be retried: calls=%d seen=%d", inner.calls, seen)
+	}
+}
@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func (s *SQLiteStore) BindActors(ctx context.Context, userID string, clientID string, actors []string) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if clientID == "" {
+		return errors.New("clientId is required")
+	}
+	if len(actors) == 0 {
+		return nil
+	}
+	conn, err := s.dbWrite.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("get write conn: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE;"); err != nil {
+		return fmt.Errorf("begin immediate: %w", err)
+	}
+
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		_, _ = conn.ExecContext(ctx, "ROLLBACK;")
+	}()
+
+	now := time.Now().Unix()
+	for _, actor := range actors {
+		if _, err := conn.ExecContext(ctx, `
+			INSERT INTO actors (actor_id, user_id, client_id, created_at)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT(actor_id) DO NOTHING
+		`, actor, internalUserID, clientID, now); err != nil {
+			return fmt.Errorf("bind actor: %w", err)
+		}
+		var ownerID int64
+		if err := conn.QueryRowContext(ctx, `SELECT user_id FROM actors WHERE actor_id = ?`, actor).Scan(&ownerID); err != nil {
+			return fmt.Errorf("load actor: %w", err)
+		}
+		if ownerID != internalUserID {
+			return fmt.Errorf("%w: %s", ErrActorNotOwned, actor)
+		}
+	}
+	if _, err := conn.ExecContext(ctx, "COMMIT;"); err != nil {
+		return fmt.Errorf("commit actors: %w", err)
+	}
+	committed = true
+	return nil
+}
+
+func (s *SQLiteStore) UpdateUserProfile(ctx context.Context, userID string, profile UserProfile) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.dbWrite.ExecContext(ctx, `
+		UPDATE users
+		SET email = ?, display_name = ?, avatar_url = ?, locale = COALESCE(NULLIF(locale, ''), NULLIF(?, '')), time_zone = COALESCE(time_zone, NULLIF(?, ''))
+		WHERE id = ?
+	`, profile.Email, profile.DisplayName, profile.AvatarURL, profile.Locale, profile.TimeZone, internalUserID); err != nil {
+		return fmt.Errorf("update user profile: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ServerActor(ctx context.Context, userID string, candidate string) (string, error) {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if candidate == "" {
+		return "", errors.New("candidate actor is required")
+	}
+	var actor string
+	if err := s.dbWrite.QueryRowContext(ctx, `
+		UPDATE users SET server_actor = COALESCE(server_actor, ?) WHERE id = ?
+		RETURNING server_actor
+	`, candidate, internalUserID).Scan(&actor); err != nil {
+		return "", fmt.Errorf("load server actor: %w", err)
+	}
+	return actor, nil
+}
+
+func (s *SQLiteStore) SetTimeZone(ctx context.Context, userID string, timeZone string) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.dbWrite.ExecContext(ctx, `
+		UPDATE users SET time_zone = NULLIF(?, '') WHERE id = ?
+	`, timeZone, internalUserID); err != nil {
+		return fmt.Errorf("update time zone: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) SetLocale(ctx context.Context, userID string, locale string) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.dbWrite.ExecContext(ctx, `
+		UPDATE users SET locale = NULLIF(?, '') WHERE id = ?
+	`, locale, internalUserID); err != nil {
+		return fmt.Errorf("update locale: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) GetUserProfile(ctx context.Context, userID string) (UserProfile, error) {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return UserProfile{}, err
+	}
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	var profile UserProfile
+	row := db.QueryRowContext(ctx, `
+		SELECT COALESCE(email, ''), COALESCE(display_name, ''), COALESCE(avatar_url, ''), COALESCE(locale, ''), COALESCE(time_zone, '')
+		FROM users WHERE id = ?
+	`, internalUserID)
+	if err := row.Scan(&profile.Email, &profile.DisplayName, &profile.AvatarURL, &profile.Locale, &profile.TimeZone); err != nil {
+		return UserProfile{}, fmt.Errorf("load user profile: %w", err)
+	}
+	return profile, nil
+}
+
+func (s *SQLiteStore) GetActorAttribution(ctx context.Context, userID string, actors []string) (map[string]UserProfile, error) {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	attribution := make(map[string]UserProfile)
+	if len(actors) == 0 {
+		return attribution, nil
+	}
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	args := make([]any, 0, len(actors)+1)
+	args = append(args, internalUserID)
+	for _, actor := range actors {
+		args = append(args, actor)
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT a.actor_id, COALESCE(u.display_name, ''), COALESCE(u.avatar_url, '')
+		FROM actors a
+		JOIN users u ON u.id = a.user_id
+		WHERE a.user_id != ? AND a.actor_id IN (`+placeholders(len(actors))+`)
+		AND (COALESCE(u.display_name, '') != '' OR COALESCE(u.avatar_url, '') != '')
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query actor attribution: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var actor string
+		var profile UserProfile
+		if err := rows.Scan(&actor, &profile.DisplayName, &profile.AvatarURL); err != nil {
+			return nil, fmt.Errorf("scan actor attribution: %w", err)
+		}
+		attribution[actor] = profile
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate actor attribution: %w", err)
+	}
+	return attribution, nil
+}
+
+// placeholders returns n comma-separated SQL parameter markers.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
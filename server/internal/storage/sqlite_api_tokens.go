@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+func (s *SQLiteStore) CreateAPIToken(ctx context.Context, userID string, token APIToken, secretHash string, signingKey string) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if token.ID == "" || secretHash == "" {
+		return errors.New("token id and secret hash are required")
+	}
+	_, err = s.dbWrite.ExecContext(ctx, `
+		INSERT INTO api_tokens (token_id, user_id, name, scopes, list_id, secret_hash, created_at, expires_at, signed, member_id, signing_key)
+		VALUES (?, ?, ?, ?, NULLIF(?, ''), ?, ?, NULLIF(?, 0), ?, NULLIF(?, ''), NULLIF(?, ''))
+	`, token.ID, internalUserID, token.Name, strings.Join(token.Scopes, ","), token.ListID, secretHash, token.CreatedAt, token.ExpiresAt, token.Signed, token.MemberID, signingKey)
+	if err != nil {
+		return fmt.Errorf("create API token: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListAPITokens(ctx context.Context, userID string) ([]APIToken, error) {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT token_id, name, scopes, COALESCE(list_id, ''), created_at, COALESCE(expires_at, 0), COALESCE(last_used_at, 0), COALESCE(signed, 0), COALESCE(member_id, '')
+		FROM api_tokens
+		WHERE user_id = ?
+		ORDER BY created_at ASC, rowid ASC
+	`, internalUserID)
+	if err != nil {
+		return nil, fmt.Errorf("list API tokens: %w", err)
+	}
+	defer rows.Close()
+	tokens := make([]APIToken, 0)
+	for rows.Next() {
+		token := APIToken{UserID: userID}
+		var scopes string
+		if err := rows.Scan(&token.ID, &token.Name, &scopes, &token.ListID, &token.CreatedAt, &token.ExpiresAt, &token.LastUsedAt, &token.Signed, &token.MemberID); err != nil {
+			return nil, fmt.Errorf("scan API token: %w", err)
+		}
+		token.Scopes = splitScopes(scopes)
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate API tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+func (s *SQLiteStore) RevokeAPIToken(ctx context.Context, userID string, tokenID string) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	result, err := s.dbWrite.ExecContext(ctx, `
+		DELETE FROM api_tokens WHERE user_id = ? AND token_id = ?
+	`, internalUserID, tokenID)
+	if err != nil {
+		return fmt.Errorf("revoke API token: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("revoke API token: %w", err)
+	} else if affected == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) UseAPIToken(ctx context.Context, secretHash string, usedAt int64) (APIToken, error) {
+	var token APIToken
+	var scopes string
+	err := s.dbWrite.QueryRowContext(ctx, `
+		UPDATE api_tokens SET last_used_at = ?
+		WHERE secret_hash = ? AND (expires_at IS NULL OR expires_at > ?)
+		RETURNING token_id, (SELECT user_external_id FROM users WHERE users.id = api_tokens.user_id), name, scopes,
+			COALESCE(list_id, ''), created_at, COALESCE(expires_at, 0), last_used_at, COALESCE(signed, 0), COALESCE(member_id, '')
+	`, usedAt, secretHash, usedAt).Scan(&token.ID, &token.UserID, &token.Name, &scopes, &token.ListID, &token.CreatedAt, &token.ExpiresAt, &token.LastUsedAt, &token.Signed, &token.MemberID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIToken{}, ErrAPITokenNotFound
+	}
+	if err != nil {
+		return APIToken{}, fmt.Errorf("use API token: %w", err)
+	}
+	token.Scopes = splitScopes(scopes)
+	return token, nil
+}
+
+func (s *SQLiteStore) SignedAPIToken(ctx context.Context, tokenID string, now int64) (APIToken, string, error) {
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	var token APIToken
+	var scopes, signingKey string
+	err := db.QueryRowContext(ctx, `
+		SELECT token_id, (SELECT user_external_id FROM users WHERE users.id = api_tokens.user_id), name, scopes,
+			COALESCE(list_id, ''), created_at, COALESCE(expires_at, 0), COALESCE(last_used_at, 0), signed, COALESCE(member_id, ''), COALESCE(signing_key, '')
+		FROM api_tokens
+		WHERE token_id = ? AND signed = 1 AND (expires_at IS NULL OR expires_at > ?)
+	`, tokenID, now).Scan(&token.ID, &token.UserID, &token.Name, &scopes, &token.ListID, &token.CreatedAt, &token.ExpiresAt, &token.LastUsedAt, &token.Signed, &token.MemberID, &signingKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIToken{}, "", ErrAPITokenNotFound
+	}
+	if err != nil {
+		return APIToken{}, "", fmt.Errorf("load signed API token: %w", err)
+	}
+	token.Scopes = splitScopes(scopes)
+	return token, signingKey, nil
+}
+
+func (s *SQLiteStore) UseSignedAPIToken(ctx context.Context, tokenID string, usedAt int64) (APIToken, error) {
+	var token APIToken
+	var scopes string
+	err := s.dbWrite.QueryRowContext(ctx, `
+		UPDATE api_tokens SET last_used_at = ?
+		WHERE token_id = ? AND signed = 1 AND (expires_at IS NULL OR expires_at > ?)
+		RETURNING token_id, (SELECT user_external_id FROM users WHERE users.id = api_tokens.user_id), name, scopes,
+			COALESCE(list_id, ''), created_at, COALESCE(expires_at, 0), last_used_at, signed, COALESCE(member_id, '')
+	`, usedAt, tokenID, usedAt).Scan(&token.ID, &token.UserID, &token.Name, &scopes, &token.ListID, &token.CreatedAt, &token.ExpiresAt, &token.LastUsedAt, &token.Signed, &token.MemberID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIToken{}, ErrAPITokenNotFound
+	}
+	if err != nil {
+		return APIToken{}, fmt.Errorf("use signed API token: %w", err)
+	}
+	token.Scopes = splitScopes(scopes)
+	return token, nil
+}
+
+func splitScopes(scopes string) []string {
+	if scopes == "" {
+		return []string{}
+	}
+	return strings.Split(scopes, ",")
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (s *SQLiteStore) SetListArchived(ctx context.Context, userID string, listID string, archived bool) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if listID == "" {
+		return errors.New("listId is required")
+	}
+	if archived {
+		_, err = s.dbWrite.ExecContext(ctx, `
+			INSERT INTO archived_lists (user_id, list_id, archived_at)
+			VALUES (?, ?, ?)
+			ON CONFLICT(user_id, list_id) DO NOTHING
+		`, internalUserID, listID, time.Now().Unix())
+	} else {
+		_, err = s.dbWrite.ExecContext(ctx, `
+			DELETE FROM archived_lists WHERE user_id = ? AND list_id = ?
+		`, internalUserID, listID)
+	}
+	if err != nil {
+		return fmt.Errorf("set list archived: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListArchivedLists(ctx context.Context, userID string) ([]string, error) {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT list_id FROM archived_lists
+		WHERE user_id = ?
+		ORDER BY archived_at ASC, rowid ASC
+	`, internalUserID)
+	if err != nil {
+		return nil, fmt.Errorf("list archived lists: %w", err)
+	}
+	defer rows.Close()
+	listIDs := make([]string, 0)
+	for rows.Next() {
+		var listID string
+		if err := rows.Scan(&listID); err != nil {
+			return nil, fmt.Errorf("scan archived list: %w", err)
+		}
+		listIDs = append(listIDs, listID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate archived lists: %w", err)
+	}
+	return listIDs, nil
+}
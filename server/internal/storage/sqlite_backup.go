@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Backups are written with VACUUM INTO, which copies the database as of one
+// read transaction into a fresh, compact file while writers carry on. The
+// copy is a plain SQLite file: restoring it means putting it in place of the
+// database while the server is stopped.
+
+// Names of the files in a backup, relative to the directory it was written
+// to. The per-user layout adds one file per user below BackupUserDir.
+const (
+	BackupMainFile = "main.db"
+	BackupUserDir  = "users"
+)
+
+// Backup writes a consistent copy of the database to dir as BackupMainFile
+// and returns the names of the files written.
+func (s *SQLiteStore) Backup(ctx context.Context, dir string) ([]string, error) {
+	if err := s.backupInto(ctx, filepath.Join(dir, BackupMainFile)); err != nil {
+		return nil, err
+	}
+	return []string{BackupMainFile}, nil
+}
+
+// Backup writes the shared database as BackupMainFile and every user file
+// below BackupUserDir. Each file is consistent on its own; they are not
+// copied at the same instant.
+func (s *PerUserStore) Backup(ctx context.Context, dir string) ([]string, error) {
+	files, err := s.SQLiteStore.Backup(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, BackupUserDir), 0o700); err != nil {
+		return nil, fmt.Errorf("backup: %w", err)
+	}
+	err = s.forEachUser(ctx, func(store *SQLiteStore) error {
+		name := filepath.ToSlash(filepath.Join(BackupUserDir, filepath.Base(store.path)))
+		if err := store.backupInto(ctx, filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			return err
+		}
+		files = append(files, name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// backupInto copies the database to path, which must not exist yet.
+func (s *SQLiteStore) backupInto(ctx context.Context, path string) error {
+	// A connection of its own keeps the writer free for the duration of the
+	// copy; the read connections are query_only, which VACUUM INTO refuses.
+	source, err := sql.Open("sqlite", s.path)
+	if err != nil {
+		return fmt.Errorf("backup %s: %w", filepath.Base(s.path), err)
+	}
+	defer func() { _ = source.Close() }()
+	source.SetMaxOpenConns(1)
+	if _, err := source.ExecContext(ctx, "PRAGMA busy_timeout = 5000;"); err != nil {
+		return fmt.Errorf("backup %s: %w", filepath.Base(s.path), err)
+	}
+	if _, err := source.ExecContext(ctx, "VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("backup %s: %w", filepath.Base(s.path), err)
+	}
+	// The copy carries this process's instance lock, so a server started on
+	// the restored file would refuse to start until the lock expired.
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return fmt.Errorf("backup %s: %w", filepath.Base(s.path), err)
+	}
+	defer func() { _ = db.Close() }()
+	if _, err := db.ExecContext(ctx, "DELETE FROM instance_lock"); err != nil {
+		return fmt.Errorf("backup %s: clear instance lock: %w", filepath.Base(s.path), err)
+	}
+	return nil
+}
+
+// VerifyBackupFile checks that path is a database of this server that passes
+// a full integrity check, without changing it.
+func VerifyBackupFile(ctx context.Context, path string) error {
+	db, err := sql.Open("sqlite", "file:"+filepath.ToSlash(path)+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("verify %s: %w", filepath.Base(path), err)
+	}
+	defer func() { _ = db.Close() }()
+	var applicationID int64
+	if err := db.QueryRowContext(ctx, "PRAGMA application_id;").Scan(&applicationID); err != nil {
+		return fmt.Errorf("verify %s: %w", filepath.Base(path), err)
+	}
+	if applicationID != sqliteApplicationID {
+		return fmt.Errorf("verify %s: not a database of this server (application_id %#x)", filepath.Base(path), applicationID)
+	}
+	store := &SQLiteStore{dbWrite: db, path: path, integrityCheck: IntegrityCheckFull}
+	return store.verifyIntegrity(ctx)
+}
@@ -0,0 +1,87 @@
+package storage_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestBackupCopiesDatabaseWithoutInstanceLock(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := storage.OpenSQLite(filepath.Join(dir, "data.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init sqlite: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+	if _, err := store.InsertOps(ctx, "user-1", []storage.Op{{Scope: "list", Resource: "list-1", Actor: "a", Clock: 1, Payload: []byte(`{"type":"insert"}`)}}); err != nil {
+		t.Fatalf("insert ops: %v", err)
+	}
+
+	backupDir := t.TempDir()
+	files, err := store.Backup(ctx, backupDir)
+	if err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+	if !slices.Equal(files, []string{storage.BackupMainFile}) {
+		t.Fatalf("unexpected backup files: %v", files)
+	}
+	path := filepath.Join(backupDir, storage.BackupMainFile)
+	if err := storage.VerifyBackupFile(ctx, path); err != nil {
+		t.Fatalf("verify backup: %v", err)
+	}
+	// A second server may open the copy right away, so the lock of the
+	// running one was not copied.
+	restored, err := storage.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("open backup: %v", err)
+	}
+	if err := restored.Init(ctx); err != nil {
+		t.Fatalf("init backup: %v", err)
+	}
+	defer func() { _ = restored.Close() }()
+	ops, _, err := restored.GetOpsSince(ctx, "user-1", 0)
+	if err != nil || len(ops) != 1 {
+		t.Fatalf("expected the op in the backup, got %d ops: %v", len(ops), err)
+	}
+
+	if err := os.WriteFile(filepath.Join(backupDir, "other.db"), []byte("not a database"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := storage.VerifyBackupFile(ctx, filepath.Join(backupDir, "other.db")); err == nil {
+		t.Fatal("expected a file that is no database to fail verification")
+	}
+}
+
+func TestPerUserBackupIncludesUserFiles(t *testing.T) {
+	ctx := context.Background()
+	store := openPerUserStore(t, t.TempDir(), 1)
+	op := storage.Op{Scope: "list", Resource: "list-1", Actor: "actor-1", Clock: 1, Payload: []byte(`{"type":"insert"}`)}
+	for _, userID := range []string{"alice", "bob"} {
+		if _, err := store.InsertOps(ctx, userID, []storage.Op{op}); err != nil {
+			t.Fatalf("insert ops: %v", err)
+		}
+	}
+	backupDir := t.TempDir()
+	files, err := store.Backup(ctx, backupDir)
+	if err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+	want := []string{storage.BackupMainFile, storage.BackupUserDir + "/" + storage.UserFileName("alice"), storage.BackupUserDir + "/" + storage.UserFileName("bob")}
+	slices.Sort(want[1:])
+	if !slices.Equal(files, want) {
+		t.Fatalf("unexpected backup files: got %v, want %v", files, want)
+	}
+	for _, file := range files {
+		if err := storage.VerifyBackupFile(ctx, filepath.Join(backupDir, filepath.FromSlash(file))); err != nil {
+			t.Fatalf("verify %s: %v", file, err)
+		}
+	}
+}
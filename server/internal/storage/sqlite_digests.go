@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+func (s *SQLiteStore) GetDigestSettings(ctx context.Context, userID string) (DigestSettings, error) {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return DigestSettings{}, err
+	}
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	settings := DigestSettings{UserID: userID}
+	row := db.QueryRowContext(ctx, `
+		SELECT COALESCE(digest_frequency, ''), COALESCE(digest_last_sent_at, 0), COALESCE(digest_last_server_seq, 0), COALESCE(time_zone, '')
+		FROM users WHERE id = ?
+	`, internalUserID)
+	if err := row.Scan(&settings.Frequency, &settings.LastSentAt, &settings.LastServerSeq, &settings.TimeZone); err != nil {
+		return DigestSettings{}, fmt.Errorf("load digest settings: %w", err)
+	}
+	return settings, nil
+}
+
+func (s *SQLiteStore) SetDigestFrequency(ctx context.Context, userID string, frequency string) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.dbWrite.ExecContext(ctx, `
+		UPDATE users
+		SET digest_frequency = NULLIF(?1, ''), digest_last_sent_at = NULL, digest_last_server_seq = NULL
+		WHERE id = ?2 AND COALESCE(digest_frequency, '') <> ?1
+	`, frequency, internalUserID); err != nil {
+		return fmt.Errorf("update digest frequency: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListDigestSubscriptions(ctx context.Context) ([]DigestSettings, error) {
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT user_external_id, digest_frequency, COALESCE(digest_last_sent_at, 0), COALESCE(digest_last_server_seq, 0), COALESCE(time_zone, '')
+		FROM users
+		WHERE COALESCE(digest_frequency, '') <> ''
+		ORDER BY user_external_id ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list digest subscriptions: %w", err)
+	}
+	defer rows.Close()
+	subscriptions := make([]DigestSettings, 0)
+	for rows.Next() {
+		var settings DigestSettings
+		if err := rows.Scan(&settings.UserID, &settings.Frequency, &settings.LastSentAt, &settings.LastServerSeq, &settings.TimeZone); err != nil {
+			return nil, fmt.Errorf("scan digest subscription: %w", err)
+		}
+		subscriptions = append(subscriptions, settings)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate digest subscriptions: %w", err)
+	}
+	return subscriptions, nil
+}
+
+func (s *SQLiteStore) MarkDigestSent(ctx context.Context, userID string, sentAt int64, serverSeq int64) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.dbWrite.ExecContext(ctx, `
+		UPDATE users SET digest_last_sent_at = ?, digest_last_server_seq = ? WHERE id = ?
+	`, sentAt, serverSeq, internalUserID); err != nil {
+		return fmt.Errorf("mark digest sent: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+func (s *SQLiteStore) CreateExportSchedule(ctx context.Context, userID string, schedule ExportSchedule) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if schedule.ID == "" || len(schedule.SealedConfig) == 0 {
+		return errors.New("schedule id and sealed config are required")
+	}
+	_, err = s.dbWrite.ExecContext(ctx, `
+		INSERT INTO export_schedules (schedule_id, user_id, format, cadence, destination, target, sealed_config, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, schedule.ID, internalUserID, schedule.Format, schedule.Cadence, schedule.Destination, schedule.Target, schedule.SealedConfig, schedule.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("create export schedule: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListExportSchedules(ctx context.Context, userID string) ([]ExportSchedule, error) {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.queryExportSchedules(ctx, `
+		SELECT ?, e.schedule_id, e.format, e.cadence, e.destination, e.target, e.sealed_config, e.created_at,
+			COALESCE(e.last_run_at, 0), COALESCE(e.last_error, '')
+		FROM export_schedules e
+		WHERE e.user_id = ?
+		ORDER BY e.created_at ASC, e.rowid ASC
+	`, userID, internalUserID)
+}
+
+func (s *SQLiteStore) ListAllExportSchedules(ctx context.Context) ([]ExportSchedule, error) {
+	return s.queryExportSchedules(ctx, `
+		SELECT u.user_external_id, e.schedule_id, e.format, e.cadence, e.destination, e.target, e.sealed_config, e.created_at,
+			COALESCE(e.last_run_at, 0), COALESCE(e.last_error, '')
+		FROM export_schedules e
+		JOIN users u ON u.id = e.user_id
+		ORDER BY u.user_external_id ASC, e.created_at ASC, e.rowid ASC
+	`)
+}
+
+func (s *SQLiteStore) queryExportSchedules(ctx context.Context, query string, args ...any) ([]ExportSchedule, error) {
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list export schedules: %w", err)
+	}
+	defer rows.Close()
+	schedules := make([]ExportSchedule, 0)
+	for rows.Next() {
+		var schedule ExportSchedule
+		if err := rows.Scan(&schedule.UserID, &schedule.ID, &schedule.Format, &schedule.Cadence, &schedule.Destination, &schedule.Target,
+			&schedule.SealedConfig, &schedule.CreatedAt, &schedule.LastRunAt, &schedule.LastError); err != nil {
+			return nil, fmt.Errorf("scan export schedule: %w", err)
+		}
+		schedules = append(schedules, schedule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate export schedules: %w", err)
+	}
+	return schedules, nil
+}
+
+func (s *SQLiteStore) DeleteExportSchedule(ctx context.Context, userID string, scheduleID string) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	result, err := s.dbWrite.ExecContext(ctx, `
+		DELETE FROM export_schedules WHERE user_id = ? AND schedule_id = ?
+	`, internalUserID, scheduleID)
+	return exportScheduleChanged(result, err, "delete export schedule")
+}
+
+func (s *SQLiteStore) MarkExportRun(ctx context.Context, userID string, scheduleID string, ranAt int64, lastError string) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	result, err := s.dbWrite.ExecContext(ctx, `
+		UPDATE export_schedules SET last_run_at = ?, last_error = NULLIF(?, '')
+		WHERE user_id = ? AND schedule_id = ?
+	`, ranAt, lastError, internalUserID, scheduleID)
+	return exportScheduleChanged(result, err, "mark export run")
+}
+
+func exportScheduleChanged(result sql.Result, err error, action string) error {
+	if err != nil {
+		return fmt.Errorf("%s: %w", action, err)
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("%s: %w", action, err)
+	} else if affected == 0 {
+		return ErrExportScheduleNotFound
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+func (s *SQLiteStore) CreateHouseholdMember(ctx context.Context, userID string, member HouseholdMember) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if member.ID == "" {
+		return errors.New("member id is required")
+	}
+	_, err = s.dbWrite.ExecContext(ctx, `
+		INSERT INTO household_members (member_id, user_id, name, list_ids, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, member.ID, internalUserID, member.Name, strings.Join(member.ListIDs, ","), member.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("create household member: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListHouseholdMembers(ctx context.Context, userID string) ([]HouseholdMember, error) {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT member_id, name, list_ids, created_at
+		FROM household_members
+		WHERE user_id = ?
+		ORDER BY created_at ASC, rowid ASC
+	`, internalUserID)
+	if err != nil {
+		return nil, fmt.Errorf("list household members: %w", err)
+	}
+	defer rows.Close()
+	members := make([]HouseholdMember, 0)
+	for rows.Next() {
+		member, err := scanHouseholdMember(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan household member: %w", err)
+		}
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate household members: %w", err)
+	}
+	return members, nil
+}
+
+func (s *SQLiteStore) GetHouseholdMember(ctx context.Context, userID string, memberID string) (HouseholdMember, error) {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return HouseholdMember{}, err
+	}
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	member, err := scanHouseholdMember(db.QueryRowContext(ctx, `
+		SELECT member_id, name, list_ids, created_at
+		FROM household_members
+		WHERE user_id = ? AND member_id = ?
+	`, internalUserID, memberID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return HouseholdMember{}, ErrHouseholdMemberNotFound
+	}
+	if err != nil {
+		return HouseholdMember{}, fmt.Errorf("get household member: %w", err)
+	}
+	return member, nil
+}
+
+func (s *SQLiteStore) UpdateHouseholdMember(ctx context.Context, userID string, member HouseholdMember) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	result, err := s.dbWrite.ExecContext(ctx, `
+		UPDATE household_members SET name = ?, list_ids = ?
+		WHERE user_id = ? AND member_id = ?
+	`, member.Name, strings.Join(member.ListIDs, ","), internalUserID, member.ID)
+	if err != nil {
+		return fmt.Errorf("update household member: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("update household member: %w", err)
+	} else if affected == 0 {
+		return ErrHouseholdMemberNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) DeleteHouseholdMember(ctx context.Context, userID string, memberID string) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	tx, err := s.dbWrite.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin delete household member: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	result, err := tx.ExecContext(ctx, `
+		DELETE FROM household_members WHERE user_id = ? AND member_id = ?
+	`, internalUserID, memberID)
+	if err != nil {
+		return fmt.Errorf("delete household member: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("delete household member: %w", err)
+	} else if affected == 0 {
+		return ErrHouseholdMemberNotFound
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM api_tokens WHERE user_id = ? AND member_id = ?
+	`, internalUserID, memberID); err != nil {
+		return fmt.Errorf("revoke household member tokens: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit delete household member: %w", err)
+	}
+	return nil
+}
+
+func scanHouseholdMember(row interface{ Scan(...any) error }) (HouseholdMember, error) {
+	var member HouseholdMember
+	var listIDs string
+	if err := row.Scan(&member.ID, &member.Name, &listIDs, &member.CreatedAt); err != nil {
+		return HouseholdMember{}, err
+	}
+	member.ListIDs = []string{}
+	if listIDs != "" {
+		member.ListIDs = strings.Split(listIDs, ",")
+	}
+	return member, nil
+}
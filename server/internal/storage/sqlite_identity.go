@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (s *SQLiteStore) MigrateUserID(ctx context.Context, fromUserID string, toUserID string) error {
+	if fromUserID == "" || toUserID == "" {
+		return errors.New("userId is required")
+	}
+	conn, err := s.dbWrite.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("get write conn: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE;"); err != nil {
+		return fmt.Errorf("begin immediate: %w", err)
+	}
+
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		_, _ = conn.ExecContext(ctx, "ROLLBACK;")
+	}()
+
+	var internalUserID int64
+	err = conn.QueryRowContext(ctx, "SELECT id FROM users WHERE user_external_id = ?", fromUserID).Scan(&internalUserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("load user id: %w", err)
+	}
+	var exists int
+	err = conn.QueryRowContext(ctx, "SELECT 1 FROM users WHERE user_external_id = ?", toUserID).Scan(&exists)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("load target user id: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, "UPDATE users SET user_external_id = ? WHERE id = ?", toUserID, internalUserID); err != nil {
+		return fmt.Errorf("rename user: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, `
+		INSERT INTO user_id_migrations (from_user_external_id, to_user_external_id, migrated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(from_user_external_id) DO UPDATE SET
+			to_user_external_id = excluded.to_user_external_id,
+			migrated_at = excluded.migrated_at
+	`, fromUserID, toUserID, time.Now().Unix()); err != nil {
+		return fmt.Errorf("record user id migration: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, "COMMIT;"); err != nil {
+		return fmt.Errorf("commit user id migration: %w", err)
+	}
+	committed = true
+	return nil
+}
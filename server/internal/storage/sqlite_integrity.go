@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// IntegrityCheck selects how thoroughly Init verifies the database before the
+// server starts serving it. Serving a corrupted file would hand broken
+// snapshots to every client, so failing fast is preferable.
+type IntegrityCheck string
+
+const (
+	// IntegrityCheckOff skips verification.
+	IntegrityCheckOff IntegrityCheck = "off"
+	// IntegrityCheckQuick runs PRAGMA quick_check, which skips index content
+	// verification and is considerably faster on large files.
+	IntegrityCheckQuick IntegrityCheck = "quick"
+	// IntegrityCheckFull runs PRAGMA integrity_check.
+	IntegrityCheckFull IntegrityCheck = "full"
+)
+
+// maxIntegrityProblems bounds how many problems are reported in the error.
+const maxIntegrityProblems = 5
+
+// ParseIntegrityCheck parses an IntegrityCheck mode name.
+func ParseIntegrityCheck(value string) (IntegrityCheck, error) {
+	switch mode := IntegrityCheck(strings.ToLower(strings.TrimSpace(value))); mode {
+	case IntegrityCheckOff, IntegrityCheckQuick, IntegrityCheckFull:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown integrity check mode %q (want off, quick, or full)", value)
+	}
+}
+
+// SetIntegrityCheck configures the verification run by Init. The default is
+// IntegrityCheckQuick. Call it before Init.
+func (s *SQLiteStore) SetIntegrityCheck(mode IntegrityCheck) {
+	s.integrityCheck = mode
+}
+
+// verifyIntegrity checks the file structure, foreign keys, and the invariant
+// that every user's meta row points at one of that user's snapshots.
+func (s *SQLiteStore) verifyIntegrity(ctx context.Context) error {
+	mode := s.integrityCheck
+	if mode == "" {
+		mode = IntegrityCheckQuick
+	}
+	if mode == IntegrityCheckOff {
+		return nil
+	}
+	pragma := "PRAGMA quick_check;"
+	if mode == IntegrityCheckFull {
+		pragma = "PRAGMA integrity_check;"
+	}
+	problems, err := s.collectProblems(ctx, s.dbWrite, pragma, func(scan func(...any) error) (string, error) {
+		var message string
+		if err := scan(&message); err != nil {
+			return "", err
+		}
+		if message == "ok" {
+			return "", nil
+		}
+		return message, nil
+	})
+	if err != nil {
+		return fmt.Errorf("integrity check: %w", err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check of %s failed: %s; restore the file from a backup (or salvage it with `sqlite3 %s .recover`) before starting the server",
+			s.path, strings.Join(problems, "; "), s.path)
+	}
+
+	problems, err = s.collectProblems(ctx, s.dbWrite, foreignKeyCheck, describeForeignKeyProblem)
+	if err != nil {
+		return fmt.Errorf("foreign key check: %w", err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("foreign key check of %s failed: %s; restore the file from a backup before starting the server",
+			s.path, strings.Join(problems, "; "))
+	}
+
+	problems, err = s.collectProblems(ctx, s.dbWrite, activeGenerationCheck, describeActiveGenerationProblem)
+	if err != nil {
+		return fmt.Errorf("meta check: %w", err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("active generation check of %s failed: %s; restore the file from a backup, or delete the affected users' meta rows to start them from an empty dataset",
+			s.path, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+const foreignKeyCheck = "PRAGMA foreign_key_check;"
+
+func describeForeignKeyProblem(scan func(...any) error) (string, error) {
+	var table, parent string
+	var rowID, fkID any
+	if err := scan(&table, &rowID, &parent, &fkID); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s row %v references a missing %s row", table, rowID, parent), nil
+}
+
+const activeGenerationCheck = `
+	SELECT m.user_id, m.active_dataset_generation_id
+	FROM meta m
+	LEFT JOIN snapshots s ON s.dataset_generation_id = m.active_dataset_generation_id AND s.user_id = m.user_id
+	WHERE s.dataset_generation_id IS NULL
+`
+
+func describeActiveGenerationProblem(scan func(...any) error) (string, error) {
+	var userID, datasetGenerationID int64
+	if err := scan(&userID, &datasetGenerationID); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("user %d points at missing snapshot %d", userID, datasetGenerationID), nil
+}
+
+// consistencyChecks are the invariants CheckConsistency verifies besides
+// those Init does. Installing a generation deletes the user's ops and tag
+// index rows, so rows of any other generation were left behind by a broken
+// write or a bad restore.
+var consistencyChecks = []struct {
+	query    string
+	describe func(scan func(...any) error) (string, error)
+}{
+	{foreignKeyCheck, describeForeignKeyProblem},
+	{activeGenerationCheck, describeActiveGenerationProblem},
+	{`
+		SELECT o.user_id, o.dataset_generation_id, COUNT(*)
+		FROM ops o
+		LEFT JOIN meta m ON m.user_id = o.user_id
+		WHERE m.active_dataset_generation_id IS NOT o.dataset_generation_id
+		GROUP BY o.user_id, o.dataset_generation_id
+	`, func(scan func(...any) error) (string, error) {
+		var userID, datasetGenerationID, count int64
+		if err := scan(&userID, &datasetGenerationID, &count); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("user %d has %d ops in generation %d, which is not active", userID, count, datasetGenerationID), nil
+	}},
+	{`
+		SELECT t.user_id, t.dataset_generation_id, COUNT(*)
+		FROM item_tags t
+		LEFT JOIN meta m ON m.user_id = t.user_id
+		WHERE m.active_dataset_generation_id IS NOT t.dataset_generation_id
+		GROUP BY t.user_id, t.dataset_generation_id
+	`, func(scan func(...any) error) (string, error) {
+		var userID, datasetGenerationID, count int64
+		if err := scan(&userID, &datasetGenerationID, &count); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("user %d has %d tag index rows in generation %d, which is not active", userID, count, datasetGenerationID), nil
+	}},
+	{`
+		SELECT s.user_id, s.dataset_generation_id, p.user_id
+		FROM snapshots s
+		JOIN snapshots p ON p.dataset_generation_id = s.parent_dataset_generation_id
+		WHERE p.user_id != s.user_id
+	`, func(scan func(...any) error) (string, error) {
+		var userID, datasetGenerationID, parentUserID int64
+		if err := scan(&userID, &datasetGenerationID, &parentUserID); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("generation %d of user %d descends from a generation of user %d", datasetGenerationID, userID, parentUserID), nil
+	}},
+}
+
+// CheckConsistency looks for damage on a live database that the file
+// structure checks cannot see: rows referencing missing users or
+// generations, users pointing at a missing or foreign snapshot, ops and tag
+// index rows outside the active generation, and generations descending from
+// another user's. It returns a description of each problem, up to
+// maxIntegrityProblems per check, prefixed with the file name.
+func (s *SQLiteStore) CheckConsistency(ctx context.Context) ([]string, error) {
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	var found []string
+	for _, check := range consistencyChecks {
+		problems, err := s.collectProblems(ctx, db, check.query, check.describe)
+		if err != nil {
+			return nil, fmt.Errorf("consistency check of %s: %w", filepath.Base(s.path), err)
+		}
+		for _, problem := range problems {
+			found = append(found, filepath.Base(s.path)+": "+problem)
+		}
+	}
+	return found, nil
+}
+
+// CheckConsistency checks the shared database and every user file.
+func (s *PerUserStore) CheckConsistency(ctx context.Context) ([]string, error) {
+	found, err := s.SQLiteStore.CheckConsistency(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = s.forEachUser(ctx, func(store *SQLiteStore) error {
+		problems, err := store.CheckConsistency(ctx)
+		found = append(found, problems...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// collectProblems runs query on db and gathers up to maxIntegrityProblems
+// non-empty descriptions produced by describe.
+func (s *SQLiteStore) collectProblems(ctx context.Context, db *sql.DB, query string, describe func(scan func(...any) error) (string, error)) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	problems := make([]string, 0)
+	for rows.Next() {
+		problem, err := describe(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		if problem == "" {
+			continue
+		}
+		if len(problems) == maxIntegrityProblems {
+			problems = append(problems, "...")
+			break
+		}
+		problems = append(problems, problem)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return problems, nil
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+func (s *SQLiteStore) UserExists(ctx context.Context, userID string) (bool, error) {
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE user_external_id = ?)", userID).Scan(&exists); err != nil {
+		return false, fmt.Errorf("check user: %w", err)
+	}
+	return exists, nil
+}
+
+func (s *SQLiteStore) CreateInvite(ctx context.Context, invite Invite, codeHash string) error {
+	if invite.ID == "" || codeHash == "" || invite.MaxUses < 1 {
+		return errors.New("invite id, code hash and uses are required")
+	}
+	_, err := s.dbWrite.ExecContext(ctx, `
+		INSERT INTO invites (invite_id, code_hash, note, max_uses, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, NULLIF(?, 0))
+	`, invite.ID, codeHash, invite.Note, invite.MaxUses, invite.CreatedAt, invite.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("create invite: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListInvites(ctx context.Context) ([]Invite, error) {
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT invite_id, note, max_uses, uses, created_at, COALESCE(expires_at, 0), COALESCE(last_used_at, 0)
+		FROM invites
+		ORDER BY created_at ASC, invite_id ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list invites: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	invites := make([]Invite, 0)
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan invite: %w", err)
+		}
+		invites = append(invites, invite)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate invites: %w", err)
+	}
+	return invites, nil
+}
+
+func (s *SQLiteStore) DeleteInvite(ctx context.Context, inviteID string) error {
+	result, err := s.dbWrite.ExecContext(ctx, `DELETE FROM invites WHERE invite_id = ?`, inviteID)
+	if err != nil {
+		return fmt.Errorf("delete invite: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("delete invite: %w", err)
+	} else if affected == 0 {
+		return ErrInviteNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) RedeemInvite(ctx context.Context, codeHash string, usedAt int64) (Invite, error) {
+	invite, err := scanInvite(s.dbWrite.QueryRowContext(ctx, `
+		UPDATE invites SET uses = uses + 1, last_used_at = ?
+		WHERE code_hash = ? AND uses < max_uses AND (expires_at IS NULL OR expires_at > ?)
+		RETURNING invite_id, note, max_uses, uses, created_at, COALESCE(expires_at, 0), last_used_at
+	`, usedAt, codeHash, usedAt))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Invite{}, ErrInviteNotFound
+	}
+	if err != nil {
+		return Invite{}, fmt.Errorf("redeem invite: %w", err)
+	}
+	return invite, nil
+}
+
+func scanInvite(row interface{ Scan(...any) error }) (Invite, error) {
+	var invite Invite
+	err := row.Scan(&invite.ID, &invite.Note, &invite.MaxUses, &invite.Uses, &invite.CreatedAt, &invite.ExpiresAt, &invite.LastUsedAt)
+	return invite, err
+}
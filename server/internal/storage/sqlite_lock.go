@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Only one server process may write a database file. Two processes would each
+// believe they own the single writer connection and their interleaved
+// transactions can corrupt generation state in ways SQLite cannot detect. The
+// database therefore carries an application_id identifying it as ours and an
+// instance_lock row that the owning process refreshes periodically. A second
+// process that finds a fresh heartbeat refuses to start. A process that finds
+// its own claim taken over, or cannot refresh it for the lock's lifetime, stops
+// writing: it closes its writer connection so every later write fails.
+
+// sqliteApplicationID marks a database file as created by this server ("A4TL").
+const sqliteApplicationID = 0x4134544c
+
+const (
+	instanceLockTTL       = 30 * time.Second
+	instanceLockHeartbeat = 10 * time.Second
+)
+
+// ErrDatabaseInUse is returned by Init when another live server process holds
+// the database.
+var ErrDatabaseInUse = errors.New("database is in use by another server process")
+
+// ErrInstanceLockLost is passed to the OnInstanceLockLost callback when
+// another process took over the database.
+var ErrInstanceLockLost = errors.New("instance lock lost to another server process")
+
+const instanceLockSchema = `
+CREATE TABLE IF NOT EXISTS instance_lock (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	instance_id TEXT NOT NULL,
+	pid INTEGER NOT NULL,
+	hostname TEXT NOT NULL,
+	heartbeat_at INTEGER NOT NULL
+);
+`
+
+type instanceLock struct {
+	id   string
+	stop chan struct{}
+	done chan struct{}
+	// lost is set by the heartbeat before done is closed.
+	lost bool
+}
+
+// OnInstanceLockLost registers fn to be called after the store lost its
+// instance lock and stopped writing, e.g. to exit the process. Call it before
+// Init.
+func (s *SQLiteStore) OnInstanceLockLost(fn func(error)) {
+	s.onLockLost = fn
+}
+
+// checkApplicationID stamps fresh databases and rejects files that belong to
+// another application.
+func (s *SQLiteStore) checkApplicationID(ctx context.Context) error {
+	var applicationID int64
+	if err := s.dbWrite.QueryRowContext(ctx, "PRAGMA application_id;").Scan(&applicationID); err != nil {
+		return fmt.Errorf("read application_id: %w", err)
+	}
+	switch applicationID {
+	case sqliteApplicationID:
+		return nil
+	case 0:
+		if _, err := s.dbWrite.ExecContext(ctx, fmt.Sprintf("PRAGMA application_id = %d;", sqliteApplicationID)); err != nil {
+			return fmt.Errorf("set application_id: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%s is not a tasklists database (application_id %#x)", s.path, applicationID)
+	}
+}
+
+// acquireInstanceLock claims the database for this process and starts the
+// heartbeat that keeps the claim alive.
+func (s *SQLiteStore) acquireInstanceLock(ctx context.Context) error {
+	if _, err := s.dbWrite.ExecContext(ctx, instanceLockSchema); err != nil {
+		return fmt.Errorf("init instance lock: %w", err)
+	}
+	hostname, _ := os.Hostname()
+	lock := &instanceLock{id: uuid.NewString(), stop: make(chan struct{}), done: make(chan struct{})}
+
+	conn, err := s.dbWrite.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("get write conn: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE;"); err != nil {
+		return fmt.Errorf("begin immediate: %w", err)
+	}
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		_, _ = conn.ExecContext(ctx, "ROLLBACK;")
+	}()
+
+	now := time.Now()
+	var holderPID int64
+	var holderHost string
+	var heartbeatAt int64
+	row := conn.QueryRowContext(ctx, "SELECT pid, hostname, heartbeat_at FROM instance_lock WHERE id = 1")
+	switch err := row.Scan(&holderPID, &holderHost, &heartbeatAt); {
+	case err == nil:
+		age := now.Sub(time.Unix(heartbeatAt, 0))
+		if age < instanceLockTTL {
+			return fmt.Errorf("%w: pid %d on host %q refreshed its lock %s ago; stop it first, or wait %s if it has crashed",
+				ErrDatabaseInUse, holderPID, holderHost, age.Round(time.Second), (instanceLockTTL - age).Round(time.Second))
+		}
+	case errors.Is(err, sql.ErrNoRows):
+	default:
+		return fmt.Errorf("read instance lock: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, `
+		INSERT INTO instance_lock (id, instance_id, pid, hostname, heartbeat_at)
+		VALUES (1, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			instance_id = excluded.instance_id,
+			pid = excluded.pid,
+			hostname = excluded.hostname,
+			heartbeat_at = excluded.heartbeat_at
+	`, lock.id, os.Getpid(), hostname, now.Unix()); err != nil {
+		return fmt.Errorf("write instance lock: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, "COMMIT;"); err != nil {
+		return fmt.Errorf("commit instance lock: %w", err)
+	}
+	committed = true
+
+	s.lock = lock
+	go s.heartbeat(lock)
+	return nil
+}
+
+func (s *SQLiteStore) heartbeat(lock *instanceLock) {
+	defer close(lock.done)
+	ticker := time.NewTicker(s.lockHeartbeat())
+	defer ticker.Stop()
+	refreshedAt := time.Now()
+	for {
+		select {
+		case <-lock.stop:
+			return
+		case <-ticker.C:
+			err := s.refreshInstanceLock(lock)
+			switch {
+			case err == nil:
+				refreshedAt = time.Now()
+				continue
+			case errors.Is(err, ErrInstanceLockLost):
+			case time.Since(refreshedAt) >= instanceLockTTL:
+				// Another process may take over a claim this old.
+				err = fmt.Errorf("%w: not refreshed for %s: %v", ErrInstanceLockLost, instanceLockTTL, err)
+			default:
+				log.Printf("instance lock heartbeat error: %v", err)
+				continue
+			}
+			log.Printf("instance lock lost for %s, refusing further writes: %v", s.path, err)
+			lock.lost = true
+			_ = s.dbWrite.Close()
+			if s.onLockLost != nil {
+				s.onLockLost(err)
+			}
+			return
+		}
+	}
+}
+
+// refreshInstanceLock renews this process's claim, or returns
+// ErrInstanceLockLost when another process holds the lock now.
+func (s *SQLiteStore) refreshInstanceLock(lock *instanceLock) error {
+	result, err := s.dbWrite.Exec("UPDATE instance_lock SET heartbeat_at = ? WHERE id = 1 AND instance_id = ?", time.Now().Unix(), lock.id)
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrInstanceLockLost
+	}
+	return nil
+}
+
+func (s *SQLiteStore) lockHeartbeat() time.Duration {
+	if s.heartbeatInterval > 0 {
+		return s.heartbeatInterval
+	}
+	return instanceLockHeartbeat
+}
+
+// releaseInstanceLock stops the heartbeat and removes this process's claim so
+// a successor can start immediately.
+func (s *SQLiteStore) releaseInstanceLock() error {
+	if s.lock == nil {
+		return nil
+	}
+	close(s.lock.stop)
+	<-s.lock.done
+	if s.lock.lost {
+		s.lock = nil
+		return nil
+	}
+	_, err := s.dbWrite.Exec("DELETE FROM instance_lock WHERE id = 1 AND instance_id = ?", s.lock.id)
+	s.lock = nil
+	if err != nil {
+		return fmt.Errorf("release instance lock: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreStopsWritingAfterLosingItsInstanceLock(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	store.heartbeatInterval = 10 * time.Millisecond
+	lost := make(chan error, 1)
+	store.OnInstanceLockLost(func(err error) { lost <- err })
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	op := Op{Scope: "list", Resource: "list-1", Actor: "actor-1", Clock: 1, Payload: []byte(`{"type":"insert"}`)}
+	if _, err := store.InsertOps(ctx, "user-1", []Op{op}); err != nil {
+		t.Fatalf("insert ops: %v", err)
+	}
+
+	// Another process takes over, e.g. after this one stalled past the TTL.
+	other, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open raw sqlite: %v", err)
+	}
+	defer func() { _ = other.Close() }()
+	if _, err := other.ExecContext(ctx, "UPDATE instance_lock SET instance_id = 'other', heartbeat_at = ?", time.Now().Unix()); err != nil {
+		t.Fatalf("steal lock: %v", err)
+	}
+	select {
+	case err := <-lost:
+		if !errors.Is(err, ErrInstanceLockLost) {
+			t.Fatalf("expected ErrInstanceLockLost, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("lost lock was not noticed")
+	}
+
+	op.Clock = 2
+	if _, err := store.InsertOps(ctx, "user-1", []Op{op}); err == nil {
+		t.Fatalf("expected writes to be refused after the lock was lost")
+	}
+	if err := store.ReplaceSnapshot(ctx, "user-1", Snapshot{DatasetGenerationKey: "dataset-2"}); err == nil {
+		t.Fatalf("expected snapshot writes to be refused after the lock was lost")
+	}
+	var holder string
+	if err := other.QueryRowContext(ctx, "SELECT instance_id FROM instance_lock").Scan(&holder); err != nil || holder != "other" {
+		t.Fatalf("expected the new holder to keep the lock: %q %v", holder, err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+}
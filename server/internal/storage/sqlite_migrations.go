@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// Init renames tables and creates missing tables and columns on every start. Deployments can
+// preview those changes (for example before rolling out a new release)
+// with PlanMigrations, which applies them inside a transaction and rolls it
+// back.
+
+// MigrationPlan describes the schema changes Init would apply.
+type MigrationPlan struct {
+	// NewDatabase is true when the database has no tables yet.
+	NewDatabase bool
+	// Renames lists the tables Init would rename, as old->new.
+	Renames []string
+	// Tables lists the tables Init would create.
+	Tables []string
+	// Columns lists the columns Init would add, as table.column.
+	Columns []string
+}
+
+// Pending reports whether Init would change the schema.
+func (p MigrationPlan) Pending() bool {
+	return len(p.Renames) > 0 || len(p.Tables) > 0 || len(p.Columns) > 0
+}
+
+// PlanMigrations reports what Init would change without changing anything.
+// Call it instead of Init; it neither claims the instance lock nor starts
+// serving reads, so it is safe next to a running server.
+func (s *SQLiteStore) PlanMigrations(ctx context.Context) (MigrationPlan, error) {
+	if _, err := s.dbWrite.ExecContext(ctx, "PRAGMA busy_timeout = 5000;"); err != nil {
+		return MigrationPlan{}, fmt.Errorf("set busy timeout: %w", err)
+	}
+	var applicationID int64
+	if err := s.dbWrite.QueryRowContext(ctx, "PRAGMA application_id;").Scan(&applicationID); err != nil {
+		return MigrationPlan{}, fmt.Errorf("read application_id: %w", err)
+	}
+	if applicationID != 0 && applicationID != sqliteApplicationID {
+		return MigrationPlan{}, fmt.Errorf("%s is not a tasklists database (application_id %#x)", s.path, applicationID)
+	}
+
+	tx, err := s.dbWrite.BeginTx(ctx, nil)
+	if err != nil {
+		return MigrationPlan{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	before, err := tableNames(ctx, tx)
+	if err != nil {
+		return MigrationPlan{}, err
+	}
+	var renames []string
+	for _, migration := range tableRenames {
+		renamed, err := renameTable(ctx, tx, migration.from, migration.to)
+		if err != nil {
+			return MigrationPlan{}, err
+		}
+		if renamed {
+			renames = append(renames, migration.from+"->"+migration.to)
+			before = append(before, migration.to)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, schema); err != nil {
+		return MigrationPlan{}, fmt.Errorf("init schema: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, instanceLockSchema); err != nil {
+		return MigrationPlan{}, fmt.Errorf("init instance lock: %w", err)
+	}
+	after, err := tableNames(ctx, tx)
+	if err != nil {
+		return MigrationPlan{}, err
+	}
+	plan := MigrationPlan{NewDatabase: len(before) == 0, Renames: renames}
+	for _, table := range after {
+		if !slices.Contains(before, table) {
+			plan.Tables = append(plan.Tables, table)
+		}
+	}
+	for _, migration := range columnMigrations {
+		added, err := ensureColumn(ctx, tx, migration.table, migration.column, migration.definition)
+		if err != nil {
+			return MigrationPlan{}, err
+		}
+		if added {
+			plan.Columns = append(plan.Columns, migration.table+"."+migration.column)
+		}
+	}
+	return plan, nil
+}
+
+func tableNames(ctx context.Context, db sqlExecQuerier) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("list tables: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("scan table: %w", err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate tables: %w", err)
+	}
+	return names, nil
+}
+
+// renameTable renames from to to and reports whether it did. Databases
+// without a from table (new or already migrated) are left alone.
+func renameTable(ctx context.Context, db sqlExecQuerier, from string, to string) (bool, error) {
+	tables, err := tableNames(ctx, db)
+	if err != nil {
+		return false, err
+	}
+	if !slices.Contains(tables, from) {
+		return false, nil
+	}
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s RENAME TO %s", from, to)); err != nil {
+		return false, fmt.Errorf("rename %s to %s: %w", from, to, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (s *SQLiteStore) SetListMuted(ctx context.Context, userID string, listID string, muted bool) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if listID == "" {
+		return errors.New("listId is required")
+	}
+	if muted {
+		_, err = s.dbWrite.ExecContext(ctx, `
+			INSERT INTO muted_lists (user_id, list_id, muted_at)
+			VALUES (?, ?, ?)
+			ON CONFLICT(user_id, list_id) DO NOTHING
+		`, internalUserID, listID, time.Now().Unix())
+	} else {
+		_, err = s.dbWrite.ExecContext(ctx, `
+			DELETE FROM muted_lists WHERE user_id = ? AND list_id = ?
+		`, internalUserID, listID)
+	}
+	if err != nil {
+		return fmt.Errorf("set list muted: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListMutedLists(ctx context.Context, userID string) ([]string, error) {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT list_id FROM muted_lists
+		WHERE user_id = ?
+		ORDER BY muted_at ASC, rowid ASC
+	`, internalUserID)
+	if err != nil {
+		return nil, fmt.Errorf("list muted lists: %w", err)
+	}
+	defer rows.Close()
+	listIDs := make([]string, 0)
+	for rows.Next() {
+		var listID string
+		if err := rows.Scan(&listID); err != nil {
+			return nil, fmt.Errorf("scan muted list: %w", err)
+		}
+		listIDs = append(listIDs, listID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate muted lists: %w", err)
+	}
+	return listIDs, nil
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+func (s *SQLiteStore) CreateOAuthApp(ctx context.Context, app OAuthApp) error {
+	if app.ID == "" || len(app.RedirectURIs) == 0 {
+		return errors.New("app id and redirect uris are required")
+	}
+	_, err := s.dbWrite.ExecContext(ctx, `
+		INSERT INTO oauth_apps (app_id, name, redirect_uris, scopes, secret_hash, created_at)
+		VALUES (?, ?, ?, ?, NULLIF(?, ''), ?)
+	`, app.ID, app.Name, strings.Join(app.RedirectURIs, "\n"), strings.Join(app.Scopes, ","), app.SecretHash, app.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("create oauth app: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) GetOAuthApp(ctx context.Context, appID string) (OAuthApp, error) {
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	app, err := scanOAuthApp(db.QueryRowContext(ctx, `
+		SELECT app_id, name, redirect_uris, scopes, COALESCE(secret_hash, ''), created_at
+		FROM oauth_apps
+		WHERE app_id = ?
+	`, appID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return OAuthApp{}, ErrOAuthAppNotFound
+	}
+	if err != nil {
+		return OAuthApp{}, fmt.Errorf("get oauth app: %w", err)
+	}
+	return app, nil
+}
+
+func (s *SQLiteStore) ListOAuthApps(ctx context.Context) ([]OAuthApp, error) {
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT app_id, name, redirect_uris, scopes, COALESCE(secret_hash, ''), created_at
+		FROM oauth_apps
+		ORDER BY created_at ASC, rowid ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list oauth apps: %w", err)
+	}
+	defer rows.Close()
+	apps := make([]OAuthApp, 0)
+	for rows.Next() {
+		app, err := scanOAuthApp(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan oauth app: %w", err)
+		}
+		apps = append(apps, app)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate oauth apps: %w", err)
+	}
+	return apps, nil
+}
+
+func (s *SQLiteStore) DeleteOAuthApp(ctx context.Context, appID string) error {
+	result, err := s.dbWrite.ExecContext(ctx, `DELETE FROM oauth_apps WHERE app_id = ?`, appID)
+	if err != nil {
+		return fmt.Errorf("delete oauth app: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("delete oauth app: %w", err)
+	} else if affected == 0 {
+		return ErrOAuthAppNotFound
+	}
+	return nil
+}
+
+func scanOAuthApp(row interface{ Scan(...any) error }) (OAuthApp, error) {
+	var app OAuthApp
+	var redirectURIs, scopes string
+	if err := row.Scan(&app.ID, &app.Name, &redirectURIs, &scopes, &app.SecretHash, &app.CreatedAt); err != nil {
+		return OAuthApp{}, err
+	}
+	app.RedirectURIs = strings.Split(redirectURIs, "\n")
+	app.Scopes = splitScopes(scopes)
+	return app, nil
+}
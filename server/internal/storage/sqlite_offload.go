@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"a4-tasklists/server/internal/blobstore"
+
+	"github.com/google/uuid"
+)
+
+// blobOffload moves large snapshot blobs, and optionally op payloads, out of
+// SQLite into an object store. The snapshots row then keeps an empty
+// snapshot_blob plus snapshot_ref (object key) and snapshot_sha256 (hex
+// checksum of the blob). An offloaded op keeps an empty payload plus
+// payload_ref, an object key ending in the payload's checksum, and
+// payload_bytes for the usage statistics.
+//
+// Every snapshot and op payload is uploaded as a fresh object, so each object
+// belongs to one row. A snapshot's object is deleted once another snapshot
+// replaced it, an op's once the op is gone: when compaction or a reset clears
+// the log, or when a quarantined op is discarded.
+type blobOffload struct {
+	blobs    blobstore.Store
+	minBytes int
+	payloads bool
+}
+
+// CompressPayloads stores op payloads of at least minBytes zstd-compressed
+// when that makes them smaller; 0 turns compression off. Call it before
+// serving requests. Payloads are read back whichever setting wrote them, and
+// offloaded payloads are never compressed.
+func (s *SQLiteStore) CompressPayloads(minBytes int) {
+	s.compressMinBytes = minBytes
+}
+
+// OffloadSnapshots stores snapshot blobs of at least minBytes in blobs instead
+// of the snapshots table. Call it before serving requests. Snapshots written
+// earlier stay where they are; offloaded ones need blobs to be readable.
+func (s *SQLiteStore) OffloadSnapshots(blobs blobstore.Store, minBytes int) {
+	s.offload = blobOffload{blobs: blobs, minBytes: minBytes}
+}
+
+// StorePayloadsIn keeps all list content, every op payload and snapshot blob,
+// in blobs, and only metadata, object keys, and checksums in SQLite. It is
+// meant for deployments that must keep content on another volume than the
+// rest, e.g. a directory on an encrypted volume. It replaces OffloadSnapshots.
+// Call it before serving requests. Content written earlier stays in SQLite.
+func (s *SQLiteStore) StorePayloadsIn(blobs blobstore.Store) {
+	s.offload = blobOffload{blobs: blobs, payloads: true}
+}
+
+func (o blobOffload) applies(blob string) bool {
+	return o.blobs != nil && blob != "" && len(blob) >= o.minBytes
+}
+
+func (o blobOffload) store(ctx context.Context, userID int64, blob string) (string, string, error) {
+	sum := sha256.Sum256([]byte(blob))
+	checksum := hex.EncodeToString(sum[:])
+	key := fmt.Sprintf("snapshots/%d/%s-%s", userID, uuid.NewString(), checksum)
+	if err := o.blobs.Put(ctx, key, []byte(blob)); err != nil {
+		return "", "", fmt.Errorf("offload snapshot: %w", err)
+	}
+	return key, checksum, nil
+}
+
+func (o blobOffload) load(ctx context.Context, key string, checksum string) (string, error) {
+	if o.blobs == nil {
+		return "", errors.New("snapshot is offloaded but no blob store is configured")
+	}
+	data, err := o.blobs.Get(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("load offloaded snapshot %s: %w", key, err)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != checksum {
+		return "", fmt.Errorf("offloaded snapshot %s failed checksum verification", key)
+	}
+	return string(data), nil
+}
+
+// storePayloads writes the payloads of ops to the object store and returns
+// their keys, or nil when payloads stay in SQLite. Each payload gets a new
+// key, so the caller must remove the objects of ops it does not store.
+func (o blobOffload) storePayloads(ctx context.Context, userID int64, ops []Op) ([]string, error) {
+	if o.blobs == nil || !o.payloads {
+		return nil, nil
+	}
+	keys := make([]string, 0, len(ops))
+	for _, op := range ops {
+		sum := sha256.Sum256(op.Payload)
+		key := fmt.Sprintf("ops/%d/%s-%s", userID, uuid.NewString(), hex.EncodeToString(sum[:]))
+		if err := o.blobs.Put(ctx, key, op.Payload); err != nil {
+			o.remove(ctx, keys)
+			return nil, fmt.Errorf("offload op payload: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// remove deletes objects no row refers to any more. It runs after the
+// transaction that dropped the references, so a failed delete only leaves an
+// orphaned object behind and is logged rather than returned.
+func (o blobOffload) remove(ctx context.Context, keys []string) {
+	if o.blobs == nil {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	for _, key := range keys {
+		if err := o.blobs.Delete(ctx, key); err != nil {
+			log.Printf("delete offloaded object %s: %v", key, err)
+		}
+	}
+}
+
+// loadPayload reads an offloaded op payload and verifies it against the
+// checksum at the end of its key. Keys written by earlier releases are the
+// bare checksum.
+func (o blobOffload) loadPayload(ctx context.Context, key string) ([]byte, error) {
+	if o.blobs == nil {
+		return nil, errors.New("op payload is offloaded but no blob store is configured")
+	}
+	data, err := o.blobs.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("load offloaded op payload %s: %w", key, err)
+	}
+	sum := sha256.Sum256(data)
+	base := path.Base(key)
+	if hex.EncodeToString(sum[:]) != base[strings.LastIndexByte(base, '-')+1:] {
+		return nil, fmt.Errorf("offloaded op payload %s failed checksum verification", key)
+	}
+	return data, nil
+}
+
+// payloadRefs returns the objects owned by the user's ops, i.e. those to
+// remove once the ops are deleted.
+func payloadRefs(ctx context.Context, conn *sql.Conn, userID int64) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT payload_ref FROM ops WHERE user_id = ? AND payload_ref IS NOT NULL", userID)
+	if err != nil {
+		return nil, fmt.Errorf("list offloaded payloads: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("scan offloaded payload: %w", err)
+		}
+		if ownedObject(key) {
+			keys = append(keys, key)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate offloaded payloads: %w", err)
+	}
+	return keys, nil
+}
+
+// supersededSnapshotRefs detaches the objects of the user's snapshots other
+// than the active one and returns their keys, to remove once the new snapshot
+// is committed.
+func supersededSnapshotRefs(ctx context.Context, conn *sql.Conn, userID int64, activeID int64) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, `
+		SELECT snapshot_ref FROM snapshots
+		WHERE user_id = ? AND dataset_generation_id != ? AND snapshot_ref IS NOT NULL
+	`, userID, activeID)
+	if err != nil {
+		return nil, fmt.Errorf("list offloaded snapshots: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("scan offloaded snapshot: %w", err)
+		}
+		if ownedObject(key) {
+			keys = append(keys, key)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate offloaded snapshots: %w", err)
+	}
+	for _, key := range keys {
+		if _, err := conn.ExecContext(ctx, "UPDATE snapshots SET snapshot_ref = NULL, snapshot_sha256 = NULL WHERE snapshot_ref = ?", key); err != nil {
+			return nil, fmt.Errorf("detach offloaded snapshot: %w", err)
+		}
+	}
+	return keys, nil
+}
+
+// ownedObject reports whether key belongs to a single row. Earlier releases
+// used the bare checksum as the object name, so identical content shared one
+// object across rows and, with per-user databases, across users; those
+// objects are never deleted.
+func ownedObject(key string) bool {
+	return strings.Contains(path.Base(key), "-")
+}
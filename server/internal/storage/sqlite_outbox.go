@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// recordOutbox adds an outbox event for a change to the generation within the
+// transaction on conn.
+func recordOutbox(ctx context.Context, conn *sql.Conn, internalUserID int64, datasetGenerationID int64, kind string) error {
+	if _, err := conn.ExecContext(ctx, `
+		INSERT INTO outbox (user_id, kind, dataset_generation_key, server_seq, created_at)
+		SELECT ?, ?, s.dataset_generation_key, COALESCE((
+			SELECT MAX(o.server_seq) FROM ops o
+			WHERE o.user_id = s.user_id AND o.dataset_generation_id = s.dataset_generation_id
+		), 0), ?
+		FROM snapshots s
+		WHERE s.dataset_generation_id = ?
+	`, internalUserID, kind, time.Now().Unix(), datasetGenerationID); err != nil {
+		return fmt.Errorf("record outbox event: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListOutbox(ctx context.Context, limit int) ([]OutboxEvent, error) {
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT e.event_id, u.user_external_id, e.kind, e.dataset_generation_key, e.server_seq, e.created_at
+		FROM outbox e
+		JOIN users u ON u.id = e.user_id
+		ORDER BY e.event_id ASC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list outbox: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	events := make([]OutboxEvent, 0)
+	for rows.Next() {
+		var event OutboxEvent
+		if err := rows.Scan(&event.ID, &event.UserID, &event.Kind, &event.DatasetGenerationKey, &event.ServerSeq, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan outbox event: %w", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate outbox: %w", err)
+	}
+	return events, nil
+}
+
+func (s *SQLiteStore) AckOutbox(ctx context.Context, events []OutboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	tx, err := s.dbWrite.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin ack outbox: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	for _, event := range events {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM outbox WHERE event_id = ?", event.ID); err != nil {
+			return fmt.Errorf("ack outbox event: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit ack outbox: %w", err)
+	}
+	return nil
+}
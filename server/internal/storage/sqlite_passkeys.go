@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (s *SQLiteStore) ListPasskeys(ctx context.Context, userID string) ([]Passkey, error) {
+	if userID == "" {
+		return nil, errors.New("userId is required")
+	}
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT p.credential_id, p.data
+		FROM passkeys p
+		JOIN users u ON u.id = p.user_id
+		WHERE u.user_external_id = ?
+		ORDER BY p.created_at
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query passkeys: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	var passkeys []Passkey
+	for rows.Next() {
+		var passkey Passkey
+		if err := rows.Scan(&passkey.CredentialID, &passkey.Data); err != nil {
+			return nil, fmt.Errorf("scan passkey: %w", err)
+		}
+		passkeys = append(passkeys, passkey)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate passkeys: %w", err)
+	}
+	return passkeys, nil
+}
+
+func (s *SQLiteStore) AddPasskey(ctx context.Context, userID string, passkey Passkey) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	if _, err := s.dbWrite.ExecContext(ctx, `
+		INSERT INTO passkeys (credential_id, user_id, data, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, passkey.CredentialID, internalUserID, passkey.Data, now, now); err != nil {
+		return fmt.Errorf("insert passkey: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) UpdatePasskey(ctx context.Context, userID string, passkey Passkey) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	result, err := s.dbWrite.ExecContext(ctx, `
+		UPDATE passkeys SET data = ?, updated_at = ? WHERE credential_id = ? AND user_id = ?
+	`, passkey.Data, time.Now().Unix(), passkey.CredentialID, internalUserID)
+	if err != nil {
+		return fmt.Errorf("update passkey: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return errors.New("passkey not found")
+	}
+	return nil
+}
+
+func (s *SQLiteStore) CountPasskeys(ctx context.Context) (int64, error) {
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	var count int64
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM passkeys").Scan(&count); err != nil {
+		return 0, fmt.Errorf("count passkeys: %w", err)
+	}
+	return count, nil
+}
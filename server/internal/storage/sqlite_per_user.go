@@ -0,0 +1,677 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// In the per-user layout every user's op log, snapshots, clients, and list
+// metadata live in a SQLite file of their own, named after the SHA-256 of the
+// user id so that ids such as email addresses do not show up in file names.
+// Backing up or deleting a user's data is then a file operation, and writes
+// of different users no longer queue for one writer connection. Data that has
+// to be looked up across users (profiles, passkeys, actor bindings, API
+// tokens, OAuth apps, and digest settings) stays in a shared database.
+//
+// User files are opened on first use and the least recently used idle ones
+// are closed beyond a limit, so the number of open files and connections
+// stays bounded however many users there are.
+
+// DefaultMaxOpenUserFiles is how many user files PerUserStore keeps open
+// unless configured otherwise.
+const DefaultMaxOpenUserFiles = 64
+
+// PerUserStore is a Store that keeps each user's data in a separate SQLite
+// file. Methods that are not about one user's data go to the shared database.
+type PerUserStore struct {
+	*SQLiteStore
+	dir       string
+	maxOpen   int
+	configure []func(*SQLiteStore)
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// files holds the open user files by name; busy marks names being opened,
+	// closed, or renamed, which nobody else may touch meanwhile.
+	files map[string]*userFile
+	busy  map[string]bool
+	tick  uint64
+}
+
+type userFile struct {
+	store    *SQLiteStore
+	refs     int
+	lastUsed uint64
+}
+
+// OpenSQLitePerUser opens a per-user store with the shared database at
+// sharedPath and the user files in dir. At most maxOpen idle user files are
+// kept open; 0 means DefaultMaxOpenUserFiles.
+func OpenSQLitePerUser(sharedPath string, dir string, maxOpen int) (*PerUserStore, error) {
+	if dir == "" {
+		return nil, errors.New("user database directory is required")
+	}
+	shared, err := OpenSQLite(sharedPath)
+	if err != nil {
+		return nil, err
+	}
+	if maxOpen <= 0 {
+		maxOpen = DefaultMaxOpenUserFiles
+	}
+	s := &PerUserStore{SQLiteStore: shared, dir: dir, maxOpen: maxOpen, files: map[string]*userFile{}, busy: map[string]bool{}}
+	s.cond = sync.NewCond(&s.mu)
+	return s, nil
+}
+
+// Configure applies fn to the shared database now and to every user file
+// before it is initialized, e.g. to set the tuning or integrity check. Call
+// it before Init.
+func (s *PerUserStore) Configure(fn func(*SQLiteStore)) {
+	fn(s.SQLiteStore)
+	s.configure = append(s.configure, fn)
+}
+
+func (s *PerUserStore) Init(ctx context.Context) error {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return fmt.Errorf("create user database directory: %w", err)
+	}
+	return s.SQLiteStore.Init(ctx)
+}
+
+// Close closes the user files and the shared database. Calls still running
+// against a user file fail.
+func (s *PerUserStore) Close() error {
+	s.mu.Lock()
+	files := s.files
+	s.files = map[string]*userFile{}
+	s.mu.Unlock()
+	var err error
+	for _, file := range files {
+		err = cmp.Or(err, file.store.Close())
+	}
+	return cmp.Or(err, s.SQLiteStore.Close())
+}
+
+// UserFileName returns the name of the file holding userID's data.
+func UserFileName(userID string) string {
+	sum := sha256.Sum256([]byte(userID))
+	return hex.EncodeToString(sum[:]) + ".db"
+}
+
+// acquire returns the open user file name, opening it if needed. The file
+// stays open until release is called.
+func (s *PerUserStore) acquire(ctx context.Context, name string) (*SQLiteStore, func(), error) {
+	s.mu.Lock()
+	for s.busy[name] {
+		s.cond.Wait()
+	}
+	s.tick++
+	if file, ok := s.files[name]; ok {
+		file.refs++
+		file.lastUsed = s.tick
+		s.mu.Unlock()
+		return file.store, func() { s.release(name) }, nil
+	}
+	s.busy[name] = true
+	s.mu.Unlock()
+
+	store, err := s.openUserFile(ctx, name)
+
+	s.mu.Lock()
+	delete(s.busy, name)
+	if err == nil {
+		s.files[name] = &userFile{store: store, refs: 1, lastUsed: s.tick}
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	return store, func() { s.release(name) }, nil
+}
+
+func (s *PerUserStore) openUserFile(ctx context.Context, name string) (*SQLiteStore, error) {
+	store, err := OpenSQLite(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range s.configure {
+		fn(store)
+	}
+	if err := store.Init(ctx); err != nil {
+		_ = store.Close()
+		return nil, fmt.Errorf("open user database %s: %w", name, err)
+	}
+	return store, nil
+}
+
+func (s *PerUserStore) release(name string) {
+	s.mu.Lock()
+	if file, ok := s.files[name]; ok {
+		file.refs--
+	}
+	evicted := s.evictLocked()
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	s.closeFiles(evicted)
+}
+
+// evictLocked takes the least recently used idle files beyond maxOpen out of
+// the cache and marks them busy until closeFiles has closed them.
+func (s *PerUserStore) evictLocked() map[string]*SQLiteStore {
+	var evicted map[string]*SQLiteStore
+	for len(s.files) > s.maxOpen {
+		oldest := ""
+		for name, file := range s.files {
+			if file.refs == 0 && !s.busy[name] && (oldest == "" || file.lastUsed < s.files[oldest].lastUsed) {
+				oldest = name
+			}
+		}
+		if oldest == "" {
+			break
+		}
+		if evicted == nil {
+			evicted = map[string]*SQLiteStore{}
+		}
+		evicted[oldest] = s.files[oldest].store
+		delete(s.files, oldest)
+		s.busy[oldest] = true
+	}
+	return evicted
+}
+
+func (s *PerUserStore) closeFiles(files map[string]*SQLiteStore) {
+	if len(files) == 0 {
+		return
+	}
+	for name, store := range files {
+		if err := store.Close(); err != nil {
+			log.Printf("user database close error file=%s: %v", name, err)
+		}
+	}
+	s.mu.Lock()
+	for name := range files {
+		delete(s.busy, name)
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// OpenUserFiles returns how many user files are open.
+func (s *PerUserStore) OpenUserFiles() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.files)
+}
+
+// userNames lists the user files on disk.
+func (s *PerUserStore) userNames() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("list user databases: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && len(name) == sha256.Size*2+len(".db") && strings.HasSuffix(name, ".db") {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// withUser runs fn against userID's file.
+func withUser[T any](ctx context.Context, s *PerUserStore, userID string, fn func(*SQLiteStore) (T, error)) (T, error) {
+	var zero T
+	if userID == "" {
+		return zero, errors.New("userId is required")
+	}
+	store, release, err := s.acquire(ctx, UserFileName(userID))
+	if err != nil {
+		return zero, err
+	}
+	defer release()
+	return fn(store)
+}
+
+// forEachUser runs fn against every user file on disk, one at a time.
+func (s *PerUserStore) forEachUser(ctx context.Context, fn func(*SQLiteStore) error) error {
+	names, err := s.userNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		store, release, err := s.acquire(ctx, name)
+		if err != nil {
+			return err
+		}
+		err = fn(store)
+		release()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forEachOpenUser runs fn against every user file that is open, one at a
+// time, for work that only concerns recently used files.
+func (s *PerUserStore) forEachOpenUser(ctx context.Context, fn func(*SQLiteStore) error) error {
+	s.mu.Lock()
+	names := slices.Sorted(maps.Keys(s.files))
+	s.mu.Unlock()
+	for _, name := range names {
+		store, release, err := s.acquire(ctx, name)
+		if err != nil {
+			return err
+		}
+		err = fn(store)
+		release()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// do adapts withUser to methods without a result.
+func (s *PerUserStore) do(ctx context.Context, userID string, fn func(*SQLiteStore) error) error {
+	_, err := withUser(ctx, s, userID, func(store *SQLiteStore) (struct{}, error) {
+		return struct{}{}, fn(store)
+	})
+	return err
+}
+
+func (s *PerUserStore) InsertOps(ctx context.Context, userID string, ops []Op) (int64, error) {
+	return withUser(ctx, s, userID, func(store *SQLiteStore) (int64, error) { return store.InsertOps(ctx, userID, ops) })
+}
+
+func (s *PerUserStore) GetOpsSince(ctx context.Context, userID string, since int64) ([]Op, int64, error) {
+	var ops []Op
+	serverSeq, err := withUser(ctx, s, userID, func(store *SQLiteStore) (int64, error) {
+		var (
+			serverSeq int64
+			err       error
+		)
+		ops, serverSeq, err = store.GetOpsSince(ctx, userID, since)
+		return serverSeq, err
+	})
+	return ops, serverSeq, err
+}
+
+func (s *PerUserStore) ForEachOpSince(ctx context.Context, userID string, since int64, fn func(Op) error) (int64, error) {
+	return withUser(ctx, s, userID, func(store *SQLiteStore) (int64, error) { return store.ForEachOpSince(ctx, userID, since, fn) })
+}
+
+func (s *PerUserStore) GetActiveDatasetGenerationKey(ctx context.Context, userID string) (string, error) {
+	return withUser(ctx, s, userID, func(store *SQLiteStore) (string, error) {
+		return store.GetActiveDatasetGenerationKey(ctx, userID)
+	})
+}
+
+func (s *PerUserStore) GetSnapshot(ctx context.Context, userID string) (Snapshot, error) {
+	return withUser(ctx, s, userID, func(store *SQLiteStore) (Snapshot, error) { return store.GetSnapshot(ctx, userID) })
+}
+
+func (s *PerUserStore) ReplaceSnapshot(ctx context.Context, userID string, snapshot Snapshot) error {
+	return s.do(ctx, userID, func(store *SQLiteStore) error { return store.ReplaceSnapshot(ctx, userID, snapshot) })
+}
+
+func (s *PerUserStore) ReplaceSnapshotIf(ctx context.Context, userID string, snapshot Snapshot, precondition SnapshotPrecondition) error {
+	return s.do(ctx, userID, func(store *SQLiteStore) error {
+		return store.ReplaceSnapshotIf(ctx, userID, snapshot, precondition)
+	})
+}
+
+// ListOutbox only reads the open user files: outbox events are written
+// through an open file and usually delivered before it is closed. Events left
+// in a closed file, for example by a crash, are listed once it is opened
+// again.
+func (s *PerUserStore) ListOutbox(ctx context.Context, limit int) ([]OutboxEvent, error) {
+	var events []OutboxEvent
+	err := s.forEachOpenUser(ctx, func(store *SQLiteStore) error {
+		found, err := store.ListOutbox(ctx, limit)
+		events = append(events, found...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Event ids are per file, so creation time orders events across users.
+	slices.SortStableFunc(events, func(a, b OutboxEvent) int { return cmp.Compare(a.CreatedAt, b.CreatedAt) })
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	if events == nil {
+		events = make([]OutboxEvent, 0)
+	}
+	return events, nil
+}
+
+func (s *PerUserStore) AckOutbox(ctx context.Context, events []OutboxEvent) error {
+	byUser := make(map[string][]OutboxEvent)
+	for _, event := range events {
+		byUser[event.UserID] = append(byUser[event.UserID], event)
+	}
+	for userID, userEvents := range byUser {
+		if err := s.do(ctx, userID, func(store *SQLiteStore) error { return store.AckOutbox(ctx, userEvents) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PerUserStore) GetGenerationLineage(ctx context.Context, userID string) ([]string, error) {
+	return withUser(ctx, s, userID, func(store *SQLiteStore) ([]string, error) { return store.GetGenerationLineage(ctx, userID) })
+}
+
+func (s *PerUserStore) GetOpStats(ctx context.Context, userID string) (OpStats, error) {
+	return withUser(ctx, s, userID, func(store *SQLiteStore) (OpStats, error) { return store.GetOpStats(ctx, userID) })
+}
+
+func (s *PerUserStore) GetUsage(ctx context.Context, userID string) (Usage, error) {
+	return withUser(ctx, s, userID, func(store *SQLiteStore) (Usage, error) { return store.GetUsage(ctx, userID) })
+}
+
+func (s *PerUserStore) ListUsers(ctx context.Context) ([]UserSummary, error) {
+	var users []UserSummary
+	err := s.forEachUser(ctx, func(store *SQLiteStore) error {
+		found, err := store.ListUsers(ctx)
+		users = append(users, found...)
+		return err
+	})
+	slices.SortFunc(users, func(a, b UserSummary) int { return strings.Compare(a.UserID, b.UserID) })
+	return users, err
+}
+
+// MigrateUserID migrates the shared database and renames fromUserID's file.
+// Like SQLiteStore, it does nothing when toUserID already has a file.
+// UserExists also finds users whose data predates the shared users table,
+// such as those that only ever synced.
+func (s *PerUserStore) UserExists(ctx context.Context, userID string) (bool, error) {
+	if exists, err := s.SQLiteStore.UserExists(ctx, userID); err != nil || exists {
+		return exists, err
+	}
+	_, err := os.Stat(filepath.Join(s.dir, UserFileName(userID)))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *PerUserStore) MigrateUserID(ctx context.Context, fromUserID string, toUserID string) error {
+	if err := s.SQLiteStore.MigrateUserID(ctx, fromUserID, toUserID); err != nil {
+		return err
+	}
+	from, to := UserFileName(fromUserID), UserFileName(toUserID)
+	if from == to {
+		return nil
+	}
+	s.mu.Lock()
+	for s.busy[from] || s.busy[to] {
+		s.cond.Wait()
+	}
+	s.busy[from], s.busy[to] = true, true
+	for s.files[from] != nil && s.files[from].refs > 0 {
+		s.cond.Wait()
+	}
+	var open []*SQLiteStore
+	for _, name := range []string{from, to} {
+		if file, ok := s.files[name]; ok {
+			open = append(open, file.store)
+			delete(s.files, name)
+		}
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.busy, from)
+		delete(s.busy, to)
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	}()
+	for _, store := range open {
+		if err := store.Close(); err != nil {
+			return err
+		}
+	}
+
+	fromPath, toPath := filepath.Join(s.dir, from), filepath.Join(s.dir, to)
+	for path, wantExists := range map[string]bool{toPath: false, fromPath: true} {
+		_, err := os.Stat(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if (err == nil) != wantExists {
+			return nil
+		}
+	}
+	store, err := s.openUserFile(ctx, from)
+	if err != nil {
+		return err
+	}
+	if err := store.MigrateUserID(ctx, fromUserID, toUserID); err != nil {
+		_ = store.Close()
+		return err
+	}
+	// Closing checkpoints the WAL into the file, so the file alone carries
+	// the data when it is renamed.
+	if err := store.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(fromPath, toPath); err != nil {
+		return fmt.Errorf("rename user database: %w", err)
+	}
+	return nil
+}
+
+func (s *PerUserStore) TouchClient(ctx context.Context, userID string, clientID string) error {
+	return s.do(ctx, userID, func(store *SQLiteStore) error { return store.TouchClient(ctx, userID, clientID) })
+}
+
+func (s *PerUserStore) UpdateClientCursor(ctx context.Context, userID string, clientID string, serverSeq int64) error {
+	return s.do(ctx, userID, func(store *SQLiteStore) error {
+		return store.UpdateClientCursor(ctx, userID, clientID, serverSeq)
+	})
+}
+
+func (s *PerUserStore) RecordClientHeartbeat(ctx context.Context, userID string, clientID string, heartbeat ClientHeartbeat) error {
+	return s.do(ctx, userID, func(store *SQLiteStore) error {
+		return store.RecordClientHeartbeat(ctx, userID, clientID, heartbeat)
+	})
+}
+
+func (s *PerUserStore) ListClients(ctx context.Context, userID string) ([]Client, error) {
+	return withUser(ctx, s, userID, func(store *SQLiteStore) ([]Client, error) { return store.ListClients(ctx, userID) })
+}
+
+func (s *PerUserStore) ListFleetClients(ctx context.Context) ([]FleetClient, error) {
+	var clients []FleetClient
+	err := s.forEachUser(ctx, func(store *SQLiteStore) error {
+		found, err := store.ListFleetClients(ctx)
+		clients = append(clients, found...)
+		return err
+	})
+	slices.SortFunc(clients, func(a, b FleetClient) int { return compareClients(ClientOrderID, a, b) })
+	return clients, err
+}
+
+// ListClientsPage reads every user file for listings across users, since
+// there is no index spanning them.
+func (s *PerUserStore) ListClientsPage(ctx context.Context, query ClientQuery) (ClientPage, error) {
+	query, err := query.normalized()
+	if err != nil {
+		return ClientPage{}, err
+	}
+	if query.UserID != "" {
+		return withUser(ctx, s, query.UserID, func(store *SQLiteStore) (ClientPage, error) {
+			return store.ListClientsPage(ctx, query)
+		})
+	}
+	clients, err := s.ListFleetClients(ctx)
+	if err != nil {
+		return ClientPage{}, err
+	}
+	slices.SortFunc(clients, func(a, b FleetClient) int { return compareClients(query.Order, a, b) })
+	return pageClients(clients, query)
+}
+
+func (s *PerUserStore) MinClientCursor(ctx context.Context, userID string) (int64, bool, error) {
+	var found bool
+	cursor, err := withUser(ctx, s, userID, func(store *SQLiteStore) (int64, error) {
+		var (
+			cursor int64
+			err    error
+		)
+		cursor, found, err = store.MinClientCursor(ctx, userID)
+		return cursor, err
+	})
+	return cursor, found, err
+}
+
+func (s *PerUserStore) SetClientHint(ctx context.Context, userID string, clientID string, hint string) error {
+	return s.do(ctx, userID, func(store *SQLiteStore) error { return store.SetClientHint(ctx, userID, clientID, hint) })
+}
+
+func (s *PerUserStore) TakeClientHint(ctx context.Context, userID string, clientID string) (string, error) {
+	return withUser(ctx, s, userID, func(store *SQLiteStore) (string, error) {
+		return store.TakeClientHint(ctx, userID, clientID)
+	})
+}
+
+func (s *PerUserStore) RetireClient(ctx context.Context, userID string, clientID string, revoke bool) error {
+	return s.do(ctx, userID, func(store *SQLiteStore) error { return store.RetireClient(ctx, userID, clientID, revoke) })
+}
+
+func (s *PerUserStore) CheckClient(ctx context.Context, userID string, clientID string) error {
+	return s.do(ctx, userID, func(store *SQLiteStore) error { return store.CheckClient(ctx, userID, clientID) })
+}
+
+func (s *PerUserStore) SaveStreamCursor(ctx context.Context, userID string, cursor StreamCursor) error {
+	return s.do(ctx, userID, func(store *SQLiteStore) error { return store.SaveStreamCursor(ctx, userID, cursor) })
+}
+
+func (s *PerUserStore) GetStreamCursor(ctx context.Context, userID string, clientID string, connectionID string) (StreamCursor, error) {
+	return withUser(ctx, s, userID, func(store *SQLiteStore) (StreamCursor, error) {
+		return store.GetStreamCursor(ctx, userID, clientID, connectionID)
+	})
+}
+
+func (s *PerUserStore) ListTags(ctx context.Context, userID string) ([]TagCount, error) {
+	return withUser(ctx, s, userID, func(store *SQLiteStore) ([]TagCount, error) { return store.ListTags(ctx, userID) })
+}
+
+func (s *PerUserStore) ListTaggedItems(ctx context.Context, userID string, tag string) ([]TaggedItem, error) {
+	return withUser(ctx, s, userID, func(store *SQLiteStore) ([]TaggedItem, error) {
+		return store.ListTaggedItems(ctx, userID, tag)
+	})
+}
+
+func (s *PerUserStore) SetListTemplate(ctx context.Context, userID string, listID string, template bool) error {
+	return s.do(ctx, userID, func(store *SQLiteStore) error { return store.SetListTemplate(ctx, userID, listID, template) })
+}
+
+func (s *PerUserStore) ListTemplates(ctx context.Context, userID string) ([]string, error) {
+	return withUser(ctx, s, userID, func(store *SQLiteStore) ([]string, error) { return store.ListTemplates(ctx, userID) })
+}
+
+func (s *PerUserStore) SetListArchived(ctx context.Context, userID string, listID string, archived bool) error {
+	return s.do(ctx, userID, func(store *SQLiteStore) error { return store.SetListArchived(ctx, userID, listID, archived) })
+}
+
+func (s *PerUserStore) ListArchivedLists(ctx context.Context, userID string) ([]string, error) {
+	return withUser(ctx, s, userID, func(store *SQLiteStore) ([]string, error) { return store.ListArchivedLists(ctx, userID) })
+}
+
+func (s *PerUserStore) QuarantineOp(ctx context.Context, userID string, serverSeq int64, reason string, quarantinedAt int64) error {
+	return s.do(ctx, userID, func(store *SQLiteStore) error {
+		return store.QuarantineOp(ctx, userID, serverSeq, reason, quarantinedAt)
+	})
+}
+
+// ListQuarantinedOps orders by serverSeq, then user id: serverSeqs count per
+// user file, so the push order across users is not known.
+func (s *PerUserStore) ListQuarantinedOps(ctx context.Context) ([]QuarantinedOp, error) {
+	var ops []QuarantinedOp
+	err := s.forEachUser(ctx, func(store *SQLiteStore) error {
+		found, err := store.ListQuarantinedOps(ctx)
+		ops = append(ops, found...)
+		return err
+	})
+	slices.SortFunc(ops, func(a, b QuarantinedOp) int {
+		return cmp.Or(cmp.Compare(a.ServerSeq, b.ServerSeq), strings.Compare(a.UserID, b.UserID))
+	})
+	return ops, err
+}
+
+// GetQuarantinedOp fails when several users have a quarantined op with
+// serverSeq, since serverSeqs count per user file.
+func (s *PerUserStore) GetQuarantinedOp(ctx context.Context, serverSeq int64) (QuarantinedOp, error) {
+	var matches []QuarantinedOp
+	err := s.forEachUser(ctx, func(store *SQLiteStore) error {
+		op, err := store.GetQuarantinedOp(ctx, serverSeq)
+		if err == nil {
+			matches = append(matches, op)
+		} else if !errors.Is(err, ErrOpNotFound) {
+			return err
+		}
+		return nil
+	})
+	switch {
+	case err != nil:
+		return QuarantinedOp{}, err
+	case len(matches) == 0:
+		return QuarantinedOp{}, ErrOpNotFound
+	case len(matches) > 1:
+		return QuarantinedOp{}, fmt.Errorf("serverSeq %d is quarantined for %d users", serverSeq, len(matches))
+	}
+	return matches[0], nil
+}
+
+func (s *PerUserStore) DeleteQuarantinedOp(ctx context.Context, serverSeq int64) error {
+	op, err := s.GetQuarantinedOp(ctx, serverSeq)
+	if err != nil {
+		return err
+	}
+	return s.do(ctx, op.UserID, func(store *SQLiteStore) error { return store.DeleteQuarantinedOp(ctx, serverSeq) })
+}
+
+// Checkpoint checkpoints the shared database and the open user files. The
+// last connection to a file checkpoints it when the file is closed.
+func (s *PerUserStore) Checkpoint(ctx context.Context, mode CheckpointMode) (CheckpointResult, error) {
+	result, err := s.SQLiteStore.Checkpoint(ctx, mode)
+	if err != nil {
+		return result, err
+	}
+	s.mu.Lock()
+	open := map[string]*SQLiteStore{}
+	for name, file := range s.files {
+		if !s.busy[name] {
+			file.refs++
+			open[name] = file.store
+		}
+	}
+	s.mu.Unlock()
+	for name, store := range open {
+		if err == nil {
+			var fileResult CheckpointResult
+			fileResult, err = store.Checkpoint(ctx, mode)
+			result.Busy = result.Busy || fileResult.Busy
+			result.LogFrames += fileResult.LogFrames
+			result.CheckpointedFrames += fileResult.CheckpointedFrames
+		}
+		s.release(name)
+	}
+	return result, err
+}
@@ -0,0 +1,77 @@
+package storage_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+	"a4-tasklists/server/internal/storage/storagetest"
+)
+
+func openPerUserStore(t *testing.T, dir string, maxOpen int) *storage.PerUserStore {
+	t.Helper()
+	store, err := storage.OpenSQLitePerUser(filepath.Join(dir, "shared.db"), filepath.Join(dir, "users"), maxOpen)
+	if err != nil {
+		t.Fatalf("open per-user sqlite: %v", err)
+	}
+	if err := store.Init(context.Background()); err != nil {
+		t.Fatalf("init per-user sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func TestPerUserStoreContract(t *testing.T) {
+	// A single open file makes most tests that touch two users reopen files.
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		return openPerUserStore(t, t.TempDir(), 1)
+	})
+}
+
+func TestPerUserStoreKeepsOneFilePerUser(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := openPerUserStore(t, dir, 2)
+	op := storage.Op{Scope: "list", Resource: "list-1", Actor: "actor-1", Clock: 1, Payload: []byte(`{"type":"insert"}`)}
+	for _, userID := range []string{"alice@example.com", "bob", "carol"} {
+		if _, err := store.InsertOps(ctx, userID, []storage.Op{op}); err != nil {
+			t.Fatalf("insert ops: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "users", storage.UserFileName(userID))); err != nil {
+			t.Fatalf("expected a file for %s: %v", userID, err)
+		}
+	}
+	if open := store.OpenUserFiles(); open != 2 {
+		t.Fatalf("expected the least recently used file to be closed, %d open", open)
+	}
+	users, err := store.ListUsers(ctx)
+	if err != nil || len(users) != 3 || users[0].UserID != "alice@example.com" {
+		t.Fatalf("expected users from every file: %+v (%v)", users, err)
+	}
+
+	if err := store.MigrateUserID(ctx, "bob", "bob@example.com"); err != nil {
+		t.Fatalf("migrate user id: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "users", storage.UserFileName("bob"))); !os.IsNotExist(err) {
+		t.Fatalf("expected the old file to be renamed: %v", err)
+	}
+	if ops, _, err := store.GetOpsSince(ctx, "bob@example.com", 0); err != nil || len(ops) != 1 {
+		t.Fatalf("expected the op in the renamed file: %d (%v)", len(ops), err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	// Deleting a user's file deletes their data and nobody else's.
+	if err := os.Remove(filepath.Join(dir, "users", storage.UserFileName("carol"))); err != nil {
+		t.Fatalf("remove file: %v", err)
+	}
+	store = openPerUserStore(t, dir, 2)
+	if ops, _, err := store.GetOpsSince(ctx, "carol", 0); err != nil || len(ops) != 0 {
+		t.Fatalf("expected no ops after removing the file: %d (%v)", len(ops), err)
+	}
+	if ops, _, err := store.GetOpsSince(ctx, "alice@example.com", 0); err != nil || len(ops) != 1 {
+		t.Fatalf("other users must keep their ops: %d (%v)", len(ops), err)
+	}
+}
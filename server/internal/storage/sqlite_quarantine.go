@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+func (s *SQLiteStore) QuarantineOp(ctx context.Context, userID string, serverSeq int64, reason string, quarantinedAt int64) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.ensureActiveSnapshot(ctx, internalUserID); err != nil {
+		return err
+	}
+	datasetGenerationID, err := s.getActiveDatasetGenerationID(ctx, internalUserID)
+	if err != nil {
+		return err
+	}
+	conn, err := s.dbWrite.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("get write conn: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE;"); err != nil {
+		return fmt.Errorf("begin immediate: %w", err)
+	}
+
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		_, _ = conn.ExecContext(ctx, "ROLLBACK;")
+	}()
+
+	result, err := conn.ExecContext(ctx, `
+		INSERT INTO quarantined_ops (server_seq, user_id, scope, resource_id, actor, clock, payload, client_id, payload_ref, reason, quarantined_at)
+		SELECT server_seq, user_id, scope, resource_id, actor, clock, payload, client_id, payload_ref, ?, ?
+		FROM ops
+		WHERE server_seq = ? AND user_id = ? AND dataset_generation_id = ?
+	`, reason, quarantinedAt, serverSeq, internalUserID, datasetGenerationID)
+	if err != nil {
+		return fmt.Errorf("quarantine op: %w", err)
+	}
+	if moved, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("quarantine op rows: %w", err)
+	} else if moved == 0 {
+		return ErrOpNotFound
+	}
+	if _, err := conn.ExecContext(ctx, "DELETE FROM ops WHERE server_seq = ?", serverSeq); err != nil {
+		return fmt.Errorf("delete quarantined op: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, "COMMIT;"); err != nil {
+		return fmt.Errorf("commit quarantine: %w", err)
+	}
+	committed = true
+	return nil
+}
+
+const quarantinedOpColumns = `
+	q.server_seq, u.user_external_id, q.scope, q.resource_id, q.actor, q.clock, q.payload,
+	COALESCE(q.client_id, ''), COALESCE(q.payload_ref, ''), q.reason, q.quarantined_at
+`
+
+func (s *SQLiteStore) scanQuarantinedOp(ctx context.Context, row interface{ Scan(...any) error }) (QuarantinedOp, error) {
+	var op QuarantinedOp
+	var payload []byte
+	var payloadRef string
+	if err := row.Scan(&op.ServerSeq, &op.UserID, &op.Scope, &op.Resource, &op.Actor, &op.Clock, &payload, &op.ClientID, &payloadRef, &op.Reason, &op.QuarantinedAt); err != nil {
+		return QuarantinedOp{}, err
+	}
+	var err error
+	if op.Payload, err = loadStoredPayload(payload); err != nil {
+		return QuarantinedOp{}, err
+	}
+	if payloadRef != "" {
+		if op.Payload, err = s.offload.loadPayload(ctx, payloadRef); err != nil {
+			return QuarantinedOp{}, err
+		}
+	}
+	return op, nil
+}
+
+func (s *SQLiteStore) ListQuarantinedOps(ctx context.Context) ([]QuarantinedOp, error) {
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+quarantinedOpColumns+`
+		FROM quarantined_ops q
+		JOIN users u ON u.id = q.user_id
+		ORDER BY q.server_seq ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list quarantined ops: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	ops := make([]QuarantinedOp, 0)
+	for rows.Next() {
+		op, err := s.scanQuarantinedOp(ctx, rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan quarantined op: %w", err)
+		}
+		ops = append(ops, op)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate quarantined ops: %w", err)
+	}
+	return ops, nil
+}
+
+func (s *SQLiteStore) GetQuarantinedOp(ctx context.Context, serverSeq int64) (QuarantinedOp, error) {
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	op, err := s.scanQuarantinedOp(ctx, db.QueryRowContext(ctx, `
+		SELECT `+quarantinedOpColumns+`
+		FROM quarantined_ops q
+		JOIN users u ON u.id = q.user_id
+		WHERE q.server_seq = ?
+	`, serverSeq))
+	if errors.Is(err, sql.ErrNoRows) {
+		return QuarantinedOp{}, ErrOpNotFound
+	}
+	if err != nil {
+		return QuarantinedOp{}, fmt.Errorf("get quarantined op: %w", err)
+	}
+	return op, nil
+}
+
+func (s *SQLiteStore) DeleteQuarantinedOp(ctx context.Context, serverSeq int64) error {
+	var payloadRef sql.NullString
+	err := s.dbWrite.QueryRowContext(ctx, "DELETE FROM quarantined_ops WHERE server_seq = ? RETURNING payload_ref", serverSeq).Scan(&payloadRef)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrOpNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("delete quarantined op: %w", err)
+	}
+	if payloadRef.Valid && ownedObject(payloadRef.String) {
+		s.offload.remove(ctx, []string{payloadRef.String})
+	}
+	return nil
+}
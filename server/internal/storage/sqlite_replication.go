@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// External replication (Litestream or similar) ships the SQLite WAL to object
+// storage. The replicator must control when the WAL is checkpointed into the
+// main database file, otherwise frames can be checkpointed away before they
+// were shipped. In that mode SQLite's automatic checkpoints are disabled and
+// checkpoints only run when requested explicitly.
+
+// CheckpointMode is a SQLite wal_checkpoint mode.
+type CheckpointMode string
+
+const (
+	// CheckpointPassive copies as many frames as possible without waiting for
+	// readers or writers. It never blocks a replicator holding a read lock.
+	CheckpointPassive CheckpointMode = "PASSIVE"
+	// CheckpointTruncate waits for readers, checkpoints everything, and
+	// truncates the WAL file.
+	CheckpointTruncate CheckpointMode = "TRUNCATE"
+)
+
+// CheckpointResult reports the outcome of a WAL checkpoint.
+type CheckpointResult struct {
+	// Busy is true when the checkpoint could not complete because of
+	// concurrent readers or writers.
+	Busy bool
+	// LogFrames is the number of frames in the WAL.
+	LogFrames int64
+	// CheckpointedFrames is the number of frames copied into the database.
+	CheckpointedFrames int64
+}
+
+// DisableAutoCheckpoint leaves WAL checkpointing to an external replicator or
+// to explicit Checkpoint calls. Call it before Init.
+func (s *SQLiteStore) DisableAutoCheckpoint() {
+	s.manualCheckpoints = true
+}
+
+// Checkpoint runs a WAL checkpoint on the writer connection.
+func (s *SQLiteStore) Checkpoint(ctx context.Context, mode CheckpointMode) (CheckpointResult, error) {
+	switch mode {
+	case CheckpointPassive, CheckpointTruncate:
+	default:
+		return CheckpointResult{}, fmt.Errorf("unsupported checkpoint mode %q", mode)
+	}
+	var busy int
+	var result CheckpointResult
+	row := s.dbWrite.QueryRowContext(ctx, fmt.Sprintf("PRAGMA wal_checkpoint(%s);", mode))
+	if err := row.Scan(&busy, &result.LogFrames, &result.CheckpointedFrames); err != nil {
+		return CheckpointResult{}, fmt.Errorf("wal checkpoint: %w", err)
+	}
+	result.Busy = busy != 0
+	return result, nil
+}
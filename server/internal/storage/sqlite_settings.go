@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+func (s *SQLiteStore) ListSettings(ctx context.Context) ([]Setting, error) {
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	rows, err := db.QueryContext(ctx, `SELECT key, value, updated_at, updated_by FROM settings ORDER BY key ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("list settings: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	settings := make([]Setting, 0)
+	for rows.Next() {
+		var setting Setting
+		if err := rows.Scan(&setting.Key, &setting.Value, &setting.UpdatedAt, &setting.UpdatedBy); err != nil {
+			return nil, fmt.Errorf("scan setting: %w", err)
+		}
+		settings = append(settings, setting)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate settings: %w", err)
+	}
+	return settings, nil
+}
+
+func (s *SQLiteStore) PutSetting(ctx context.Context, setting Setting) error {
+	if setting.Key == "" {
+		return errors.New("setting key is required")
+	}
+	tx, err := s.dbWrite.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin setting tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	var previous sql.NullString
+	if err := tx.QueryRowContext(ctx, `SELECT value FROM settings WHERE key = ?`, setting.Key).Scan(&previous); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("load setting: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO settings (key, value, updated_at, updated_by) VALUES (?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at, updated_by = excluded.updated_by
+	`, setting.Key, setting.Value, setting.UpdatedAt, setting.UpdatedBy); err != nil {
+		return fmt.Errorf("put setting: %w", err)
+	}
+	if err := recordSettingChange(ctx, tx, setting.Key, previous, sql.NullString{String: setting.Value, Valid: true}, setting.UpdatedAt, setting.UpdatedBy); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) DeleteSetting(ctx context.Context, key string, changedBy string, changedAt int64) error {
+	tx, err := s.dbWrite.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin setting tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	var previous sql.NullString
+	err = tx.QueryRowContext(ctx, `DELETE FROM settings WHERE key = ? RETURNING value`, key).Scan(&previous)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSettingNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("delete setting: %w", err)
+	}
+	if err := recordSettingChange(ctx, tx, key, previous, sql.NullString{}, changedAt, changedBy); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func recordSettingChange(ctx context.Context, tx *sql.Tx, key string, previous sql.NullString, value sql.NullString, changedAt int64, changedBy string) error {
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO setting_changes (key, previous, value, changed_at, changed_by) VALUES (?, ?, ?, ?, ?)
+	`, key, previous, value, changedAt, changedBy); err != nil {
+		return fmt.Errorf("record setting change: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListSettingChanges(ctx context.Context, limit int) ([]SettingChange, error) {
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT change_id, key, previous, value, changed_at, changed_by
+		FROM setting_changes
+		ORDER BY change_id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list setting changes: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	changes := make([]SettingChange, 0)
+	for rows.Next() {
+		var change SettingChange
+		var previous, value sql.NullString
+		if err := rows.Scan(&change.ID, &change.Key, &previous, &value, &change.ChangedAt, &change.ChangedBy); err != nil {
+			return nil, fmt.Errorf("scan setting change: %w", err)
+		}
+		if previous.Valid {
+			change.Previous = &previous.String
+		}
+		if value.Valid {
+			change.Value = &value.String
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate setting changes: %w", err)
+	}
+	return changes, nil
+}
@@ -24,7 +24,9 @@ CREATE TABLE IF NOT EXISTS snapshots (
 	dataset_generation_key TEXT NOT NULL,
 	snapshot_blob TEXT NOT NULL,
 	created_at INTEGER NOT NULL,
-	FOREIGN KEY(user_id) REFERENCES users(id)
+	parent_dataset_generation_id INTEGER,
+	FOREIGN KEY(user_id) REFERENCES users(id),
+	FOREIGN KEY(parent_dataset_generation_id) REFERENCES snapshots(dataset_generation_id)
 );
 
 CREATE UNIQUE INDEX IF NOT EXISTS idx_snapshots_user_key
@@ -65,6 +67,199 @@ CREATE TABLE IF NOT EXISTS clients (
 	FOREIGN KEY(user_id) REFERENCES users(id),
 	PRIMARY KEY (user_id, client_id)
 );
+
+CREATE INDEX IF NOT EXISTS idx_clients_cursor
+ON clients(user_id, last_seen_server_seq);
+
+CREATE TABLE IF NOT EXISTS revoked_clients (
+	user_id INTEGER NOT NULL,
+	client_id TEXT NOT NULL,
+	revoked_at INTEGER NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id),
+	PRIMARY KEY (user_id, client_id)
+);
+
+CREATE TABLE IF NOT EXISTS stream_cursors (
+	user_id INTEGER NOT NULL,
+	client_id TEXT NOT NULL,
+	connection_id TEXT NOT NULL,
+	dataset_generation_key TEXT NOT NULL,
+	server_seq INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id),
+	PRIMARY KEY (user_id, client_id, connection_id)
+);
+
+CREATE TABLE IF NOT EXISTS list_templates (
+	user_id INTEGER NOT NULL,
+	list_id TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id),
+	PRIMARY KEY (user_id, list_id)
+);
+
+CREATE TABLE IF NOT EXISTS archived_lists (
+	user_id INTEGER NOT NULL,
+	list_id TEXT NOT NULL,
+	archived_at INTEGER NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id),
+	PRIMARY KEY (user_id, list_id)
+);
+
+CREATE TABLE IF NOT EXISTS outbox (
+	event_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	kind TEXT NOT NULL,
+	dataset_generation_key TEXT NOT NULL,
+	server_seq INTEGER NOT NULL,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS muted_lists (
+	user_id INTEGER NOT NULL,
+	list_id TEXT NOT NULL,
+	muted_at INTEGER NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id),
+	PRIMARY KEY (user_id, list_id)
+);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+	token_id TEXT NOT NULL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	scopes TEXT NOT NULL,
+	secret_hash TEXT NOT NULL UNIQUE,
+	created_at INTEGER NOT NULL,
+	last_used_at INTEGER,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS household_members (
+	member_id TEXT NOT NULL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	list_ids TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS export_schedules (
+	schedule_id TEXT NOT NULL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	format TEXT NOT NULL,
+	cadence TEXT NOT NULL,
+	destination TEXT NOT NULL,
+	target TEXT NOT NULL,
+	sealed_config BLOB NOT NULL,
+	created_at INTEGER NOT NULL,
+	last_run_at INTEGER,
+	last_error TEXT,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS user_id_migrations (
+	from_user_external_id TEXT NOT NULL PRIMARY KEY,
+	to_user_external_id TEXT NOT NULL,
+	migrated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS oauth_apps (
+	app_id TEXT NOT NULL PRIMARY KEY,
+	name TEXT NOT NULL,
+	redirect_uris TEXT NOT NULL,
+	scopes TEXT NOT NULL,
+	secret_hash TEXT,
+	created_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS invites (
+	invite_id TEXT NOT NULL PRIMARY KEY,
+	code_hash TEXT NOT NULL UNIQUE,
+	note TEXT NOT NULL,
+	max_uses INTEGER NOT NULL,
+	uses INTEGER NOT NULL DEFAULT 0,
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER,
+	last_used_at INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS settings (
+	key TEXT NOT NULL PRIMARY KEY,
+	value TEXT NOT NULL,
+	updated_at INTEGER NOT NULL,
+	updated_by TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS setting_changes (
+	change_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	key TEXT NOT NULL,
+	previous TEXT,
+	value TEXT,
+	changed_at INTEGER NOT NULL,
+	changed_by TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS quarantined_ops (
+	server_seq INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	scope TEXT NOT NULL,
+	resource_id TEXT NOT NULL,
+	actor TEXT NOT NULL,
+	clock INTEGER NOT NULL,
+	payload TEXT NOT NULL,
+	client_id TEXT,
+	reason TEXT NOT NULL,
+	quarantined_at INTEGER NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS passkeys (
+	credential_id BLOB NOT NULL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	data BLOB NOT NULL,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS totp_factors (
+	user_id INTEGER NOT NULL PRIMARY KEY,
+	secret TEXT NOT NULL,
+	confirmed INTEGER NOT NULL,
+	last_step INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+	user_id INTEGER NOT NULL,
+	code_hash TEXT NOT NULL,
+	PRIMARY KEY (user_id, code_hash),
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS actors (
+	actor_id TEXT NOT NULL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	client_id TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS item_tags (
+	user_id INTEGER NOT NULL,
+	dataset_generation_id INTEGER NOT NULL,
+	list_id TEXT NOT NULL,
+	item_id TEXT NOT NULL,
+	tag TEXT NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id),
+	FOREIGN KEY(dataset_generation_id) REFERENCES snapshots(dataset_generation_id),
+	PRIMARY KEY (user_id, dataset_generation_id, list_id, item_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS idx_item_tags_tag
+ON item_tags(user_id, dataset_generation_id, tag);
 `
 
 // SQLiteStore is a SQLite-backed implementation of Store.
@@ -72,6 +267,18 @@ type SQLiteStore struct {
 	dbWrite *sql.DB
 	dbRead  *sql.DB
 	path    string
+	offload blobOffload
+	// compressMinBytes is the payload size from which op payloads are stored
+	// compressed; 0 stores them as they are.
+	compressMinBytes int
+
+	manualCheckpoints bool
+	lock              *instanceLock
+	onLockLost        func(error)
+	// heartbeatInterval overrides instanceLockHeartbeat in tests.
+	heartbeatInterval time.Duration
+	integrityCheck    IntegrityCheck
+	tuning            *SQLiteTuning
 }
 
 func OpenSQLite(path string) (*SQLiteStore, error) {
@@ -94,41 +301,139 @@ func (s *SQLiteStore) Init(ctx context.Context) error {
 	if _, err := s.dbWrite.ExecContext(ctx, "PRAGMA journal_mode = WAL;"); err != nil {
 		return fmt.Errorf("enable wal: %w", err)
 	}
-	if _, err := s.dbWrite.ExecContext(ctx, "PRAGMA synchronous = NORMAL;"); err != nil {
-		return fmt.Errorf("set synchronous: %w", err)
-	}
 	if _, err := s.dbWrite.ExecContext(ctx, "PRAGMA busy_timeout = 5000;"); err != nil {
 		return fmt.Errorf("set busy timeout: %w", err)
 	}
+	for _, pragma := range s.tuningPragmas() {
+		if _, err := s.dbWrite.ExecContext(ctx, "PRAGMA "+pragma+";"); err != nil {
+			return fmt.Errorf("set %s: %w", pragma, err)
+		}
+	}
+	if s.manualCheckpoints {
+		if _, err := s.dbWrite.ExecContext(ctx, "PRAGMA wal_autocheckpoint = 0;"); err != nil {
+			return fmt.Errorf("disable wal autocheckpoint: %w", err)
+		}
+	}
+	if err := s.checkApplicationID(ctx); err != nil {
+		return err
+	}
+	if err := s.acquireInstanceLock(ctx); err != nil {
+		return err
+	}
+	for _, migration := range tableRenames {
+		if _, err := renameTable(ctx, s.dbWrite, migration.from, migration.to); err != nil {
+			return err
+		}
+	}
 	_, err := s.dbWrite.ExecContext(ctx, schema)
 	if err != nil {
 		return fmt.Errorf("init schema: %w", err)
 	}
+	for _, migration := range columnMigrations {
+		if _, err := ensureColumn(ctx, s.dbWrite, migration.table, migration.column, migration.definition); err != nil {
+			return err
+		}
+	}
+	if err := s.verifyIntegrity(ctx); err != nil {
+		_ = s.releaseInstanceLock()
+		return err
+	}
 	if s.dbRead == nil {
-		readDB, err := sql.Open("sqlite", s.path)
+		readDB, err := sql.Open("sqlite", s.readDSN())
 		if err != nil {
 			return fmt.Errorf("open read sqlite: %w", err)
 		}
 		readDB.SetMaxOpenConns(10)
 		readDB.SetMaxIdleConns(10)
-		if _, err := readDB.ExecContext(ctx, "PRAGMA query_only = ON;"); err != nil {
-			return fmt.Errorf("set query only: %w", err)
-		}
-		if _, err := readDB.ExecContext(ctx, "PRAGMA busy_timeout = 5000;"); err != nil {
-			return fmt.Errorf("set read busy timeout: %w", err)
-		}
-		if _, err := readDB.ExecContext(ctx, "PRAGMA foreign_keys = ON;"); err != nil {
-			return fmt.Errorf("enable read foreign keys: %w", err)
+		if err := readDB.PingContext(ctx); err != nil {
+			_ = readDB.Close()
+			return fmt.Errorf("open read sqlite: %w", err)
 		}
 		s.dbRead = readDB
 	}
 	return nil
 }
 
+// tableRenames lists tables that were renamed after release, oldest first.
+// They run before the schema so it does not create an empty table under the
+// new name.
+var tableRenames = []struct{ from, to string }{
+	// API tokens started out as assistant tokens.
+	{"assistant_tokens", "api_tokens"},
+}
+
+// columnMigrations lists the columns introduced after their table was first
+// created, oldest first.
+var columnMigrations = []struct{ table, column, definition string }{
+	{"snapshots", "parent_dataset_generation_id", "INTEGER REFERENCES snapshots(dataset_generation_id)"},
+	{"snapshots", "snapshot_ref", "TEXT"},
+	{"snapshots", "snapshot_sha256", "TEXT"},
+	{"snapshots", "snapshot_bytes", "INTEGER"},
+	{"users", "display_name", "TEXT"},
+	{"users", "avatar_url", "TEXT"},
+	{"users", "email", "TEXT"},
+	{"users", "locale", "TEXT"},
+	{"users", "digest_frequency", "TEXT"},
+	{"users", "digest_last_sent_at", "INTEGER"},
+	{"users", "digest_last_server_seq", "INTEGER"},
+	{"clients", "hint", "TEXT"},
+	{"ops", "client_id", "TEXT"},
+	{"api_tokens", "list_id", "TEXT"},
+	{"api_tokens", "expires_at", "INTEGER"},
+	{"clients", "app_version", "TEXT"},
+	{"clients", "clock_skew_ms", "INTEGER"},
+	{"clients", "reported_server_seq", "INTEGER"},
+	{"clients", "heartbeat_at", "INTEGER"},
+	{"ops", "payload_ref", "TEXT"},
+	{"ops", "payload_bytes", "INTEGER"},
+	{"quarantined_ops", "payload_ref", "TEXT"},
+	{"users", "time_zone", "TEXT"},
+	{"api_tokens", "signed", "INTEGER"},
+	{"api_tokens", "member_id", "TEXT"},
+	{"api_tokens", "signing_key", "TEXT"},
+	{"users", "server_actor", "TEXT"},
+}
+
+// sqlExecQuerier is satisfied by *sql.DB and *sql.Tx.
+type sqlExecQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// ensureColumn adds a column introduced after the table was first created, so
+// databases from older releases keep working without a separate migration step.
+// It reports whether the column had to be added.
+func ensureColumn(ctx context.Context, db sqlExecQuerier, table string, column string, definition string) (bool, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return false, fmt.Errorf("inspect %s: %w", table, err)
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, fmt.Errorf("scan %s column: %w", table, err)
+		}
+		if name == column {
+			return false, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("iterate %s columns: %w", table, err)
+	}
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return false, fmt.Errorf("add %s.%s: %w", table, column, err)
+	}
+	return true, nil
+}
+
 func (s *SQLiteStore) Close() error {
 	var err error
 	if s.dbWrite != nil {
-		err = s.dbWrite.Close()
+		err = s.releaseInstanceLock()
+		if closeErr := s.dbWrite.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
 	}
 	if s.dbRead != nil {
 		if closeErr := s.dbRead.Close(); closeErr != nil && err == nil {
@@ -178,6 +483,22 @@ func (s *SQLiteStore) InsertOps(ctx context.Context, userID string, ops []Op) (i
 	if err != nil {
 		return 0, err
 	}
+	// Offloaded payloads are written before the transaction so that the
+	// writer connection is not held during blob I/O.
+	payloadRefs, err := s.offload.storePayloads(ctx, internalUserID, ops)
+	if err != nil {
+		return 0, err
+	}
+	// Objects of ops that end up not stored, duplicates or all of them when
+	// the transaction fails, have no row and are removed again.
+	committed := false
+	var unusedRefs []string
+	defer func() {
+		if !committed {
+			unusedRefs = payloadRefs
+		}
+		s.offload.remove(ctx, unusedRefs)
+	}()
 	conn, err := s.dbWrite.Conn(ctx)
 	if err != nil {
 		return 0, fmt.Errorf("get write conn: %w", err)
@@ -188,7 +509,6 @@ func (s *SQLiteStore) InsertOps(ctx context.Context, userID string, ops []Op) (i
 		return 0, fmt.Errorf("begin immediate: %w", err)
 	}
 
-	committed := false
 	defer func() {
 		if committed {
 			return
@@ -196,22 +516,45 @@ func (s *SQLiteStore) InsertOps(ctx context.Context, userID string, ops []Op) (i
 		_, _ = conn.ExecContext(ctx, "ROLLBACK;")
 	}()
 
+	stored := false
 	stmt, err := conn.PrepareContext(ctx, `
-		INSERT OR IGNORE INTO ops (dataset_generation_id, user_id, scope, resource_id, actor, clock, payload)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
+		INSERT OR IGNORE INTO ops (dataset_generation_id, user_id, scope, resource_id, actor, clock, payload, client_id, payload_ref, payload_bytes)
+		VALUES (?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), ?)
 	`)
 	if err != nil {
 		return 0, fmt.Errorf("prepare insert: %w", err)
 	}
 	defer func() { _ = stmt.Close() }()
 
-	for _, op := range ops {
-		if op.Scope == "" || op.Resource == "" || op.Actor == "" || op.Clock <= 0 {
-			return 0, fmt.Errorf("invalid op metadata: scope=%q resource=%q actor=%q clock=%d", op.Scope, op.Resource, op.Actor, op.Clock)
+	for i, op := range ops {
+		if err := ValidateOp(op); err != nil {
+			return 0, err
 		}
-		if _, err := stmt.ExecContext(ctx, datasetGenerationID, internalUserID, op.Scope, op.Resource, op.Actor, op.Clock, string(op.Payload)); err != nil {
+		payload, payloadRef := storedPayload(op.Payload, s.compressMinBytes), ""
+		if payloadRefs != nil {
+			payload, payloadRef = "", payloadRefs[i]
+		}
+		result, err := stmt.ExecContext(ctx, datasetGenerationID, internalUserID, op.Scope, op.Resource, op.Actor, op.Clock, payload, op.ClientID, payloadRef, len(op.Payload))
+		if err != nil {
 			return 0, fmt.Errorf("insert op: %w", err)
 		}
+		if inserted, err := result.RowsAffected(); err != nil {
+			return 0, fmt.Errorf("insert op rows: %w", err)
+		} else if inserted == 0 {
+			if payloadRef != "" {
+				unusedRefs = append(unusedRefs, payloadRef)
+			}
+			continue
+		}
+		stored = true
+		if err := indexTags(ctx, conn, internalUserID, datasetGenerationID, op); err != nil {
+			return 0, err
+		}
+	}
+	if stored {
+		if err := recordOutbox(ctx, conn, internalUserID, datasetGenerationID, OutboxKindOps); err != nil {
+			return 0, err
+		}
 	}
 	if _, err := conn.ExecContext(ctx, "COMMIT;"); err != nil {
 		return 0, fmt.Errorf("commit ops: %w", err)
@@ -221,56 +564,84 @@ func (s *SQLiteStore) InsertOps(ctx context.Context, userID string, ops []Op) (i
 }
 
 func (s *SQLiteStore) GetOpsSince(ctx context.Context, userID string, since int64) ([]Op, int64, error) {
-	internalUserID, err := s.resolveUserID(ctx, userID)
+	ops := make([]Op, 0)
+	maxSeq, err := s.ForEachOpSince(ctx, userID, since, func(op Op) error {
+		ops = append(ops, op)
+		return nil
+	})
 	if err != nil {
 		return nil, 0, err
 	}
-	if err := s.ensureActiveSnapshot(ctx, internalUserID); err != nil {
-		return nil, 0, err
-	}
-	datasetGenerationID, err := s.getActiveDatasetGenerationID(ctx, internalUserID)
+	return ops, maxSeq, nil
+}
+
+func (s *SQLiteStore) ForEachOpSince(ctx context.Context, userID string, since int64, fn func(Op) error) (int64, error) {
+	internalUserID, err := s.resolveUserID(ctx, userID)
 	if err != nil {
-		return nil, 0, err
+		return 0, err
+	}
+	if err := s.ensureActiveSnapshot(ctx, internalUserID); err != nil {
+		return 0, err
 	}
 	db := s.dbRead
 	if db == nil {
 		db = s.dbWrite
 	}
-	rows, err := db.QueryContext(ctx, `
-		SELECT server_seq, scope, resource_id, actor, clock, payload
+	// One read transaction sees one snapshot of the database, so the returned
+	// serverSeq never covers an op committed after the ops were read.
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin read tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	var datasetGenerationID int64
+	if err := tx.QueryRowContext(ctx, "SELECT active_dataset_generation_id FROM meta WHERE user_id = ?", internalUserID).Scan(&datasetGenerationID); err != nil {
+		return 0, fmt.Errorf("load active dataset_generation_id: %w", err)
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT server_seq, scope, resource_id, actor, clock, payload, COALESCE(client_id, ''), COALESCE(payload_ref, '')
 		FROM ops
 		WHERE user_id = ? AND dataset_generation_id = ? AND server_seq > ?
 		ORDER BY server_seq ASC
 	`, internalUserID, datasetGenerationID, since)
 	if err != nil {
-		return nil, 0, fmt.Errorf("query ops: %w", err)
+		return 0, fmt.Errorf("query ops: %w", err)
 	}
 	defer func() { _ = rows.Close() }()
 
-	ops := make([]Op, 0)
 	var maxSeq int64
 	for rows.Next() {
 		var op Op
-		var payload string
-		if err := rows.Scan(&op.ServerSeq, &op.Scope, &op.Resource, &op.Actor, &op.Clock, &payload); err != nil {
-			return nil, 0, fmt.Errorf("scan op: %w", err)
+		var payload []byte
+		var payloadRef string
+		if err := rows.Scan(&op.ServerSeq, &op.Scope, &op.Resource, &op.Actor, &op.Clock, &payload, &op.ClientID, &payloadRef); err != nil {
+			return 0, fmt.Errorf("scan op: %w", err)
+		}
+		if op.Payload, err = loadStoredPayload(payload); err != nil {
+			return 0, fmt.Errorf("op %d: %w", op.ServerSeq, err)
+		}
+		if payloadRef != "" {
+			if op.Payload, err = s.offload.loadPayload(ctx, payloadRef); err != nil {
+				return 0, err
+			}
 		}
-		op.Payload = []byte(payload)
 		if op.ServerSeq > maxSeq {
 			maxSeq = op.ServerSeq
 		}
-		ops = append(ops, op)
+		if err := fn(op); err != nil {
+			return 0, err
+		}
 	}
 	if err := rows.Err(); err != nil {
-		return nil, 0, fmt.Errorf("iterate ops: %w", err)
+		return 0, fmt.Errorf("iterate ops: %w", err)
 	}
 	if maxSeq == 0 {
-		maxSeq, err = s.maxServerSeq(ctx, internalUserID)
-		if err != nil {
-			return nil, 0, err
+		row := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(server_seq), 0) FROM ops WHERE user_id = ? AND dataset_generation_id = ?", internalUserID, datasetGenerationID)
+		if err := row.Scan(&maxSeq); err != nil {
+			return 0, fmt.Errorf("max server seq: %w", err)
 		}
 	}
-	return ops, maxSeq, nil
+	return maxSeq, nil
 }
 
 func (s *SQLiteStore) TouchClient(ctx context.Context, userID string, clientID string) error {
@@ -313,6 +684,353 @@ func (s *SQLiteStore) UpdateClientCursor(ctx context.Context, userID string, cli
 	return nil
 }
 
+func (s *SQLiteStore) RecordClientHeartbeat(ctx context.Context, userID string, clientID string, heartbeat ClientHeartbeat) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if clientID == "" {
+		return errors.New("clientId is required")
+	}
+	_, err = s.dbWrite.ExecContext(ctx, `
+		INSERT INTO clients (user_id, client_id, last_seen_server_seq, updated_at, app_version, clock_skew_ms, reported_server_seq, heartbeat_at)
+		VALUES (?, ?, ?, ?, NULLIF(?, ''), ?, ?, ?)
+		ON CONFLICT(user_id, client_id) DO UPDATE SET
+			updated_at = excluded.updated_at,
+			app_version = excluded.app_version,
+			clock_skew_ms = excluded.clock_skew_ms,
+			reported_server_seq = excluded.reported_server_seq,
+			heartbeat_at = excluded.heartbeat_at
+	`, internalUserID, clientID, heartbeat.ReportedServerSeq, time.Now().Unix(),
+		heartbeat.AppVersion, heartbeat.ClockSkewMs, heartbeat.ReportedServerSeq, heartbeat.HeartbeatAt)
+	if err != nil {
+		return fmt.Errorf("record client heartbeat: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListClients(ctx context.Context, userID string) ([]Client, error) {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT client_id, last_seen_server_seq, updated_at, COALESCE(hint, ''),
+			COALESCE(app_version, ''), COALESCE(clock_skew_ms, 0), COALESCE(reported_server_seq, 0), COALESCE(heartbeat_at, 0)
+		FROM clients
+		WHERE user_id = ?
+		ORDER BY client_id ASC
+	`, internalUserID)
+	if err != nil {
+		return nil, fmt.Errorf("query clients: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	clients := make([]Client, 0)
+	for rows.Next() {
+		var client Client
+		if err := rows.Scan(&client.ClientID, &client.LastSeenServerSeq, &client.UpdatedAt, &client.Hint,
+			&client.AppVersion, &client.ClockSkewMs, &client.ReportedServerSeq, &client.HeartbeatAt); err != nil {
+			return nil, fmt.Errorf("scan client: %w", err)
+		}
+		clients = append(clients, client)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate clients: %w", err)
+	}
+	return clients, nil
+}
+
+func (s *SQLiteStore) ListFleetClients(ctx context.Context) ([]FleetClient, error) {
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT u.user_external_id, c.client_id, c.last_seen_server_seq, c.updated_at, COALESCE(c.hint, ''),
+			COALESCE(c.app_version, ''), COALESCE(c.clock_skew_ms, 0), COALESCE(c.reported_server_seq, 0), COALESCE(c.heartbeat_at, 0),
+			COALESCE((
+				SELECT MAX(o.server_seq) FROM ops o
+				JOIN meta m ON m.user_id = o.user_id AND m.active_dataset_generation_id = o.dataset_generation_id
+				WHERE o.user_id = c.user_id
+			), 0)
+		FROM clients c
+		JOIN users u ON u.id = c.user_id
+		ORDER BY u.user_external_id ASC, c.client_id ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query fleet clients: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	clients := make([]FleetClient, 0)
+	for rows.Next() {
+		var client FleetClient
+		if err := rows.Scan(&client.UserID, &client.ClientID, &client.LastSeenServerSeq, &client.UpdatedAt, &client.Hint,
+			&client.AppVersion, &client.ClockSkewMs, &client.ReportedServerSeq, &client.HeartbeatAt, &client.LatestServerSeq); err != nil {
+			return nil, fmt.Errorf("scan fleet client: %w", err)
+		}
+		clients = append(clients, client)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate fleet clients: %w", err)
+	}
+	return clients, nil
+}
+
+// clientPageKeysets continue a listing after its cursor, by order. The
+// arguments are the cursor's sort value (twice) and ids; ClientOrderID takes
+// only the ids.
+var clientPageKeysets = map[string]struct{ where, orderBy string }{
+	ClientOrderID:       {"(user_external_id, client_id) > (?, ?)", "user_external_id ASC, client_id ASC"},
+	ClientOrderLastSeen: {"(last_seen < ? OR (last_seen = ? AND (user_external_id, client_id) > (?, ?)))", "last_seen DESC, user_external_id ASC, client_id ASC"},
+	ClientOrderLag:      {"(lag < ? OR (lag = ? AND (user_external_id, client_id) > (?, ?)))", "lag DESC, user_external_id ASC, client_id ASC"},
+}
+
+func (s *SQLiteStore) ListClientsPage(ctx context.Context, query ClientQuery) (ClientPage, error) {
+	query, err := query.normalized()
+	if err != nil {
+		return ClientPage{}, err
+	}
+	keyset := clientPageKeysets[query.Order]
+	where := "1 = 1"
+	args := []any{query.UserID, query.UserID}
+	if query.After != "" {
+		cursor, err := decodeClientCursor(query.Order, query.After)
+		if err != nil {
+			return ClientPage{}, err
+		}
+		where = keyset.where
+		if query.Order != ClientOrderID {
+			args = append(args, cursor.Value, cursor.Value)
+		}
+		args = append(args, cursor.UserID, cursor.ClientID)
+	}
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	// The id order can stop after the page. Last seen and lag are computed
+	// per client, so those orders rank every listed client first; that is a
+	// sort in the database instead of shipping the whole table to the caller.
+	rows, err := db.QueryContext(ctx, `
+		WITH listed AS (
+			SELECT u.user_external_id, c.client_id, c.last_seen_server_seq, c.updated_at, COALESCE(c.hint, '') AS hint,
+				COALESCE(c.app_version, '') AS app_version, COALESCE(c.clock_skew_ms, 0) AS clock_skew_ms,
+				COALESCE(c.reported_server_seq, 0) AS reported_server_seq, COALESCE(c.heartbeat_at, 0) AS heartbeat_at,
+				COALESCE((
+					SELECT MAX(o.server_seq) FROM ops o
+					JOIN meta m ON m.user_id = o.user_id AND m.active_dataset_generation_id = o.dataset_generation_id
+					WHERE o.user_id = c.user_id
+				), 0) AS latest
+			FROM clients c
+			JOIN users u ON u.id = c.user_id
+			WHERE ? = '' OR u.user_external_id = ?
+		), ranked AS (
+			SELECT *, MAX(updated_at, heartbeat_at) AS last_seen, MAX(latest - last_seen_server_seq, 0) AS lag
+			FROM listed
+		)
+		SELECT user_external_id, client_id, last_seen_server_seq, updated_at, hint,
+			app_version, clock_skew_ms, reported_server_seq, heartbeat_at, latest
+		FROM ranked
+		WHERE `+where+`
+		ORDER BY `+keyset.orderBy+`
+		LIMIT ?
+	`, append(args, query.Limit+1)...)
+	if err != nil {
+		return ClientPage{}, fmt.Errorf("query client page: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	page := ClientPage{Clients: make([]FleetClient, 0, query.Limit)}
+	for rows.Next() {
+		var client FleetClient
+		if err := rows.Scan(&client.UserID, &client.ClientID, &client.LastSeenServerSeq, &client.UpdatedAt, &client.Hint,
+			&client.AppVersion, &client.ClockSkewMs, &client.ReportedServerSeq, &client.HeartbeatAt, &client.LatestServerSeq); err != nil {
+			return ClientPage{}, fmt.Errorf("scan client page: %w", err)
+		}
+		page.Clients = append(page.Clients, client)
+	}
+	if err := rows.Err(); err != nil {
+		return ClientPage{}, fmt.Errorf("iterate client page: %w", err)
+	}
+	if len(page.Clients) > query.Limit {
+		page.Clients = page.Clients[:query.Limit]
+		page.Next = newClientCursor(query.Order, page.Clients[query.Limit-1]).encode()
+	}
+	return page, nil
+}
+
+func (s *SQLiteStore) MinClientCursor(ctx context.Context, userID string) (int64, bool, error) {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return 0, false, err
+	}
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	// Served from idx_clients_cursor without touching other users' clients.
+	var cursor int64
+	err = db.QueryRowContext(ctx, `
+		SELECT last_seen_server_seq FROM clients
+		WHERE user_id = ?
+		ORDER BY last_seen_server_seq ASC
+		LIMIT 1
+	`, internalUserID).Scan(&cursor)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("min client cursor: %w", err)
+	}
+	return cursor, true, nil
+}
+
+func (s *SQLiteStore) SetClientHint(ctx context.Context, userID string, clientID string, hint string) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if clientID == "" {
+		return errors.New("clientId is required")
+	}
+	// Only existing clients get a hint: inserting a row here would add a zero
+	// cursor that holds back compaction.
+	result, err := s.dbWrite.ExecContext(ctx, `
+		UPDATE clients SET hint = NULLIF(?, '')
+		WHERE user_id = ? AND client_id = ?
+	`, hint, internalUserID, clientID)
+	if err != nil {
+		return fmt.Errorf("set client hint: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("set client hint: %w", err)
+	}
+	if updated == 0 {
+		return ErrClientNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) RetireClient(ctx context.Context, userID string, clientID string, revoke bool) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if clientID == "" {
+		return errors.New("clientId is required")
+	}
+	conn, err := s.dbWrite.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("get write conn: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE;"); err != nil {
+		return fmt.Errorf("begin immediate: %w", err)
+	}
+
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		_, _ = conn.ExecContext(ctx, "ROLLBACK;")
+	}()
+
+	result, err := conn.ExecContext(ctx, "DELETE FROM clients WHERE user_id = ? AND client_id = ?", internalUserID, clientID)
+	if err != nil {
+		return fmt.Errorf("delete client: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("delete client: %w", err)
+	}
+	if deleted == 0 && !revoke {
+		return ErrClientNotFound
+	}
+	if _, err := conn.ExecContext(ctx, "DELETE FROM stream_cursors WHERE user_id = ? AND client_id = ?", internalUserID, clientID); err != nil {
+		return fmt.Errorf("delete stream cursors: %w", err)
+	}
+	if revoke {
+		if _, err := conn.ExecContext(ctx, `
+			INSERT INTO revoked_clients (user_id, client_id, revoked_at)
+			VALUES (?, ?, ?)
+			ON CONFLICT(user_id, client_id) DO NOTHING
+		`, internalUserID, clientID, time.Now().Unix()); err != nil {
+			return fmt.Errorf("revoke client: %w", err)
+		}
+	}
+	if _, err := conn.ExecContext(ctx, "COMMIT;"); err != nil {
+		return fmt.Errorf("commit retire client: %w", err)
+	}
+	committed = true
+	return nil
+}
+
+func (s *SQLiteStore) CheckClient(ctx context.Context, userID string, clientID string) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	var revoked int
+	err = db.QueryRowContext(ctx, `
+		SELECT 1 FROM revoked_clients WHERE user_id = ? AND client_id = ?
+	`, internalUserID, clientID).Scan(&revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("check client: %w", err)
+	}
+	return ErrClientRevoked
+}
+
+func (s *SQLiteStore) TakeClientHint(ctx context.Context, userID string, clientID string) (string, error) {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	var hint string
+	err = s.dbWrite.QueryRowContext(ctx, `
+		SELECT hint FROM clients
+		WHERE user_id = ? AND client_id = ? AND hint IS NOT NULL
+	`, internalUserID, clientID).Scan(&hint)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("load client hint: %w", err)
+	}
+	// Clearing only the hint that was read means concurrent pulls deliver it
+	// once and an operator's newer hint is kept.
+	result, err := s.dbWrite.ExecContext(ctx, `
+		UPDATE clients SET hint = NULL
+		WHERE user_id = ? AND client_id = ? AND hint = ?
+	`, internalUserID, clientID, hint)
+	if err != nil {
+		return "", fmt.Errorf("clear client hint: %w", err)
+	}
+	cleared, err := result.RowsAffected()
+	if err != nil {
+		return "", fmt.Errorf("clear client hint: %w", err)
+	}
+	if cleared == 0 {
+		return "", nil
+	}
+	return hint, nil
+}
+
 func (s *SQLiteStore) maxServerSeq(ctx context.Context, userID int64) (int64, error) {
 	datasetGenerationID, err := s.getActiveDatasetGenerationID(ctx, userID)
 	if err != nil {
@@ -421,18 +1139,30 @@ func (s *SQLiteStore) GetSnapshot(ctx context.Context, userID string) (Snapshot,
 		db = s.dbWrite
 	}
 	row := db.QueryRowContext(ctx, `
-		SELECT s.dataset_generation_id, s.dataset_generation_key, s.snapshot_blob
+		SELECT s.dataset_generation_id, s.dataset_generation_key, s.snapshot_blob, s.snapshot_ref, s.snapshot_sha256, s.created_at
 		FROM snapshots s
 		JOIN meta m ON m.active_dataset_generation_id = s.dataset_generation_id
 		WHERE m.user_id = ?
 	`, internalUserID)
-	if err := row.Scan(&snapshot.DatasetGenerationID, &snapshot.DatasetGenerationKey, &snapshot.Blob); err != nil {
+	var ref, checksum sql.NullString
+	if err := row.Scan(&snapshot.DatasetGenerationID, &snapshot.DatasetGenerationKey, &snapshot.Blob, &ref, &checksum, &snapshot.CreatedAt); err != nil {
 		return Snapshot{}, fmt.Errorf("load snapshot: %w", err)
 	}
+	if ref.Valid && ref.String != "" {
+		blob, err := s.offload.load(ctx, ref.String, checksum.String)
+		if err != nil {
+			return Snapshot{}, err
+		}
+		snapshot.Blob = blob
+	}
 	return snapshot, nil
 }
 
 func (s *SQLiteStore) ReplaceSnapshot(ctx context.Context, userID string, snapshot Snapshot) error {
+	return s.ReplaceSnapshotIf(ctx, userID, snapshot, SnapshotPrecondition{})
+}
+
+func (s *SQLiteStore) ReplaceSnapshotIf(ctx context.Context, userID string, snapshot Snapshot, precondition SnapshotPrecondition) error {
 	internalUserID, err := s.resolveUserID(ctx, userID)
 	if err != nil {
 		return err
@@ -447,6 +1177,26 @@ func (s *SQLiteStore) ReplaceSnapshot(ctx context.Context, userID string, snapsh
 	if exists {
 		return ErrDatasetGenerationKeyExists
 	}
+	// Upload before the write transaction so the single writer is never held
+	// across network I/O. The object is removed again if the transaction
+	// fails.
+	committed := false
+	storedBlob := snapshot.Blob
+	var ref, checksum sql.NullString
+	if s.offload.applies(snapshot.Blob) {
+		key, sum, err := s.offload.store(ctx, internalUserID, snapshot.Blob)
+		if err != nil {
+			return err
+		}
+		storedBlob = ""
+		ref = sql.NullString{String: key, Valid: true}
+		checksum = sql.NullString{String: sum, Valid: true}
+		defer func() {
+			if !committed {
+				s.offload.remove(ctx, []string{key})
+			}
+		}()
+	}
 	conn, err := s.dbWrite.Conn(ctx)
 	if err != nil {
 		return fmt.Errorf("get write conn: %w", err)
@@ -455,7 +1205,6 @@ func (s *SQLiteStore) ReplaceSnapshot(ctx context.Context, userID string, snapsh
 	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE;"); err != nil {
 		return fmt.Errorf("begin immediate: %w", err)
 	}
-	committed := false
 	defer func() {
 		if committed {
 			return
@@ -463,11 +1212,26 @@ func (s *SQLiteStore) ReplaceSnapshot(ctx context.Context, userID string, snapsh
 		_, _ = conn.ExecContext(ctx, "ROLLBACK;")
 	}()
 
+	if err := checkSnapshotPrecondition(ctx, conn, internalUserID, precondition); err != nil {
+		return err
+	}
+
+	var parentDatasetGenerationID sql.NullInt64
+	if snapshot.ParentDatasetGenerationKey != "" {
+		row := conn.QueryRowContext(ctx, "SELECT dataset_generation_id FROM snapshots WHERE user_id = ? AND dataset_generation_key = ?", internalUserID, snapshot.ParentDatasetGenerationKey)
+		if err := row.Scan(&parentDatasetGenerationID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("unknown parent datasetGenerationKey %q", snapshot.ParentDatasetGenerationKey)
+			}
+			return fmt.Errorf("lookup parent snapshot id: %w", err)
+		}
+	}
+
 	now := time.Now().Unix()
 	if _, err := conn.ExecContext(ctx, `
-		INSERT INTO snapshots (user_id, dataset_generation_key, snapshot_blob, created_at)
-		VALUES (?, ?, ?, ?)
-	`, internalUserID, snapshot.DatasetGenerationKey, snapshot.Blob, now); err != nil {
+		INSERT INTO snapshots (user_id, dataset_generation_key, snapshot_blob, created_at, parent_dataset_generation_id, snapshot_ref, snapshot_sha256, snapshot_bytes)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, internalUserID, snapshot.DatasetGenerationKey, storedBlob, now, parentDatasetGenerationID, ref, checksum, len(snapshot.Blob)); err != nil {
 		return fmt.Errorf("insert snapshot: %w", err)
 	}
 	var datasetGenerationID int64
@@ -484,19 +1248,87 @@ func (s *SQLiteStore) ReplaceSnapshot(ctx context.Context, userID string, snapsh
 	`, internalUserID, datasetGenerationID, now); err != nil {
 		return fmt.Errorf("store snapshot: %w", err)
 	}
+	removedRefs, err := supersededSnapshotRefs(ctx, conn, internalUserID, datasetGenerationID)
+	if err != nil {
+		return err
+	}
+	opRefs, err := payloadRefs(ctx, conn, internalUserID)
+	if err != nil {
+		return err
+	}
+	removedRefs = append(removedRefs, opRefs...)
 	if _, err := conn.ExecContext(ctx, "DELETE FROM ops WHERE user_id = ?", internalUserID); err != nil {
 		return fmt.Errorf("clear ops: %w", err)
 	}
-	if _, err := conn.ExecContext(ctx, "DELETE FROM clients WHERE user_id = ?", internalUserID); err != nil {
-		return fmt.Errorf("clear clients: %w", err)
+	if _, err := conn.ExecContext(ctx, "DELETE FROM item_tags WHERE user_id = ?", internalUserID); err != nil {
+		return fmt.Errorf("clear tags: %w", err)
+	}
+	if err := indexSnapshotTags(ctx, conn, internalUserID, datasetGenerationID, snapshot.Blob); err != nil {
+		return err
+	}
+	// Cursors point into the replaced generation, but clients stay registered
+	// so pending hints and heartbeat telemetry survive the reset.
+	if _, err := conn.ExecContext(ctx, "UPDATE clients SET last_seen_server_seq = 0 WHERE user_id = ?", internalUserID); err != nil {
+		return fmt.Errorf("reset client cursors: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, "DELETE FROM stream_cursors WHERE user_id = ?", internalUserID); err != nil {
+		return fmt.Errorf("clear stream cursors: %w", err)
+	}
+	if err := recordOutbox(ctx, conn, internalUserID, datasetGenerationID, OutboxKindGeneration); err != nil {
+		return err
 	}
 	if _, err := conn.ExecContext(ctx, "COMMIT;"); err != nil {
 		return fmt.Errorf("commit snapshot: %w", err)
 	}
 	committed = true
+	s.offload.remove(ctx, removedRefs)
 	return nil
 }
 
+func (s *SQLiteStore) GetGenerationLineage(ctx context.Context, userID string) ([]string, error) {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ensureActiveSnapshot(ctx, internalUserID); err != nil {
+		return nil, err
+	}
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	rows, err := db.QueryContext(ctx, `
+		WITH RECURSIVE lineage(dataset_generation_id, dataset_generation_key, parent_dataset_generation_id, depth) AS (
+			SELECT s.dataset_generation_id, s.dataset_generation_key, s.parent_dataset_generation_id, 0
+			FROM meta m
+			JOIN snapshots s ON s.dataset_generation_id = m.active_dataset_generation_id
+			WHERE m.user_id = ?
+			UNION ALL
+			SELECT p.dataset_generation_id, p.dataset_generation_key, p.parent_dataset_generation_id, l.depth + 1
+			FROM lineage l
+			JOIN snapshots p ON p.dataset_generation_id = l.parent_dataset_generation_id
+		)
+		SELECT dataset_generation_key FROM lineage ORDER BY depth ASC
+	`, internalUserID)
+	if err != nil {
+		return nil, fmt.Errorf("query generation lineage: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	lineage := make([]string, 0)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("scan generation lineage: %w", err)
+		}
+		lineage = append(lineage, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate generation lineage: %w", err)
+	}
+	return lineage, nil
+}
+
 func (s *SQLiteStore) datasetGenerationKeyExists(ctx context.Context, userID int64, key string) (bool, error) {
 	db := s.dbRead
 	if db == nil {
@@ -517,3 +1349,59 @@ func (s *SQLiteStore) datasetGenerationKeyExists(ctx context.Context, userID int
 	}
 	return true, nil
 }
+
+func checkSnapshotPrecondition(ctx context.Context, conn *sql.Conn, userID int64, precondition SnapshotPrecondition) error {
+	if precondition.DatasetGenerationKey == "" && !precondition.CheckServerSeq {
+		return nil
+	}
+	var activeKey string
+	var maxSeq int64
+	row := conn.QueryRowContext(ctx, `
+		SELECT s.dataset_generation_key,
+			(SELECT COALESCE(MAX(o.server_seq), 0) FROM ops o WHERE o.user_id = m.user_id AND o.dataset_generation_id = m.active_dataset_generation_id)
+		FROM meta m
+		JOIN snapshots s ON s.dataset_generation_id = m.active_dataset_generation_id
+		WHERE m.user_id = ?
+	`, userID)
+	if err := row.Scan(&activeKey, &maxSeq); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrDatasetGenerationChanged
+		}
+		return fmt.Errorf("check snapshot precondition: %w", err)
+	}
+	if precondition.DatasetGenerationKey != "" && precondition.DatasetGenerationKey != activeKey {
+		return ErrDatasetGenerationChanged
+	}
+	if precondition.CheckServerSeq && precondition.MaxServerSeq != maxSeq {
+		return ErrDatasetGenerationChanged
+	}
+	return nil
+}
+
+func (s *SQLiteStore) GetOpStats(ctx context.Context, userID string) (OpStats, error) {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return OpStats{}, err
+	}
+	if err := s.ensureActiveSnapshot(ctx, internalUserID); err != nil {
+		return OpStats{}, err
+	}
+	datasetGenerationID, err := s.getActiveDatasetGenerationID(ctx, internalUserID)
+	if err != nil {
+		return OpStats{}, err
+	}
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	var stats OpStats
+	row := db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(COALESCE(payload_bytes, LENGTH(payload))), 0), COALESCE(MAX(server_seq), 0)
+		FROM ops
+		WHERE user_id = ? AND dataset_generation_id = ?
+	`, internalUserID, datasetGenerationID)
+	if err := row.Scan(&stats.Count, &stats.Bytes, &stats.MaxServerSeq); err != nil {
+		return OpStats{}, fmt.Errorf("op stats: %w", err)
+	}
+	return stats, nil
+}
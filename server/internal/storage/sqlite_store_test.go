@@ -1,19 +1,39 @@
-package storage
+package storage_test
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+
+	"a4-tasklists/server/internal/blobstore"
+	"a4-tasklists/server/internal/storage"
+	"a4-tasklists/server/internal/storage/storagetest"
 )
 
-func newSQLiteStore(t *testing.T) *SQLiteStore {
+func newSQLiteStore(t *testing.T) storage.Store {
+	t.Helper()
+	return openSQLiteStore(t, nil)
+}
+
+func openSQLiteStore(t *testing.T, blobs blobstore.Store) *storage.SQLiteStore {
 	t.Helper()
 	dir := t.TempDir()
 	path := filepath.Join(dir, "test.db")
-	store, err := OpenSQLite(path)
+	store, err := storage.OpenSQLite(path)
 	if err != nil {
 		t.Fatalf("open sqlite: %v", err)
 	}
+	if blobs != nil {
+		store.OffloadSnapshots(blobs, 0)
+	}
 	if err := store.Init(context.Background()); err != nil {
 		t.Fatalf("init sqlite: %v", err)
 	}
@@ -21,111 +41,546 @@ func newSQLiteStore(t *testing.T) *SQLiteStore {
 	return store
 }
 
-func TestInsertAndGetOps(t *testing.T) {
-	store := newSQLiteStore(t)
-	userID := "user-1"
-	ops := []Op{
-		{
-			Scope:    "list",
-			Resource: "list-1",
-			Actor:    "actor-1",
-			Clock:    1,
-			Payload:  []byte(`{"type":"insert","itemId":"item-1"}`),
-		},
+func TestSQLiteStoreContract(t *testing.T) {
+	storagetest.Run(t, newSQLiteStore)
+}
+
+func TestSQLiteStoreContractWithSnapshotOffload(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		return openSQLiteStore(t, blobstore.NewMemory())
+	})
+}
+
+func TestSnapshotOffloadVerifiesChecksum(t *testing.T) {
+	ctx := context.Background()
+	blobs := blobstore.NewMemory()
+	store := openSQLiteStore(t, blobs)
+	blob := `{"schema":"net.aggregat4.tasklist.snapshot@v1","data":{"lists":[]}}`
+	if err := store.ReplaceSnapshot(ctx, "user-1", storage.Snapshot{DatasetGenerationKey: "dataset-1", Blob: blob}); err != nil {
+		t.Fatalf("replace snapshot: %v", err)
+	}
+	keys := blobs.Keys()
+	if len(keys) != 1 {
+		t.Fatalf("expected one offloaded blob, got %v", keys)
+	}
+	snapshot, err := store.GetSnapshot(ctx, "user-1")
+	if err != nil || snapshot.Blob != blob {
+		t.Fatalf("get snapshot: %q %v", snapshot.Blob, err)
+	}
+	if err := blobs.Put(ctx, keys[0], []byte("tampered")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if _, err := store.GetSnapshot(ctx, "user-1"); err == nil {
+		t.Fatalf("expected checksum error for tampered blob")
+	}
+}
+
+func TestReplacingAnOffloadedSnapshotDeletesTheOldObject(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = data
+		case http.MethodGet:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(data)
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+	objectKeys := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		keys := make([]string, 0, len(objects))
+		for key := range objects {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		return keys
 	}
-	seq, err := store.InsertOps(context.Background(), userID, ops)
+	blobs, err := blobstore.NewS3(blobstore.S3Config{Endpoint: server.URL, Bucket: "snapshots", AccessKeyID: "key", SecretAccessKey: "secret"})
 	if err != nil {
+		t.Fatalf("new s3: %v", err)
+	}
+	store := openSQLiteStore(t, blobs)
+
+	if err := store.ReplaceSnapshot(ctx, "user-1", storage.Snapshot{DatasetGenerationKey: "dataset-1", Blob: `{"lists":["first"]}`}); err != nil {
+		t.Fatalf("replace snapshot: %v", err)
+	}
+	first := objectKeys()
+	if len(first) != 1 {
+		t.Fatalf("expected one offloaded snapshot, got %v", first)
+	}
+	// Identical content still gets its own object.
+	if err := store.ReplaceSnapshot(ctx, "user-1", storage.Snapshot{DatasetGenerationKey: "dataset-2", ParentDatasetGenerationKey: "dataset-1", Blob: `{"lists":["first"]}`}); err != nil {
+		t.Fatalf("replace snapshot: %v", err)
+	}
+	second := objectKeys()
+	if len(second) != 1 || second[0] == first[0] {
+		t.Fatalf("expected only the new snapshot's object, had %v and now %v", first, second)
+	}
+	snapshot, err := store.GetSnapshot(ctx, "user-1")
+	if err != nil || snapshot.Blob != `{"lists":["first"]}` {
+		t.Fatalf("get snapshot: %q %v", snapshot.Blob, err)
+	}
+
+	err = store.ReplaceSnapshotIf(ctx, "user-1", storage.Snapshot{DatasetGenerationKey: "dataset-3", Blob: `{"lists":["stale"]}`}, storage.SnapshotPrecondition{DatasetGenerationKey: "dataset-1"})
+	if !errors.Is(err, storage.ErrDatasetGenerationChanged) {
+		t.Fatalf("expected a failed precondition, got %v", err)
+	}
+	if keys := objectKeys(); !slices.Equal(keys, second) {
+		t.Fatalf("expected the failed replacement to remove its upload, got %v", keys)
+	}
+}
+
+func TestSQLiteStoreContractWithPayloadStore(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		store, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatalf("open sqlite: %v", err)
+		}
+		store.StorePayloadsIn(blobstore.NewMemory())
+		if err := store.Init(context.Background()); err != nil {
+			t.Fatalf("init sqlite: %v", err)
+		}
+		t.Cleanup(func() { _ = store.Close() })
+		return store
+	})
+}
+
+func TestPayloadStoreKeepsContentOutOfSQLite(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := storage.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	blobs := blobstore.NewMemory()
+	store.StorePayloadsIn(blobs)
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	if err := store.ReplaceSnapshot(ctx, "user-1", storage.Snapshot{DatasetGenerationKey: "dataset-1", Blob: `{"secret":"snapshot"}`}); err != nil {
+		t.Fatalf("replace snapshot: %v", err)
+	}
+	payload := `{"type":"insert","itemId":"item-1","payload":{"data":{"text":"secret item"}}}`
+	if _, err := store.InsertOps(ctx, "user-1", []storage.Op{{Scope: "list", Resource: "list-1", Actor: "actor-1", Clock: 1, Payload: []byte(payload)}}); err != nil {
 		t.Fatalf("insert ops: %v", err)
 	}
-	if seq == 0 {
-		t.Fatalf("serverSeq should advance")
+
+	ops, _, err := store.GetOpsSince(ctx, "user-1", 0)
+	if err != nil || len(ops) != 1 || string(ops[0].Payload) != payload {
+		t.Fatalf("expected the payload back from the blob store: %+v (%v)", ops, err)
+	}
+	if stats, err := store.GetOpStats(ctx, "user-1"); err != nil || stats.Bytes != int64(len(payload)) {
+		t.Fatalf("expected op bytes to count offloaded payloads: %+v (%v)", stats, err)
 	}
-	pulled, seq2, err := store.GetOpsSince(context.Background(), userID, 0)
+	if keys := blobs.Keys(); len(keys) != 2 {
+		t.Fatalf("expected the snapshot and the payload in the blob store, got %v", keys)
+	}
+
+	db, err := sql.Open("sqlite", path)
 	if err != nil {
-		t.Fatalf("get ops: %v", err)
+		t.Fatalf("open raw sqlite: %v", err)
 	}
-	if seq2 != seq {
-		t.Fatalf("serverSeq mismatch: %d vs %d", seq2, seq)
+	defer func() { _ = db.Close() }()
+	var inline int
+	if err := db.QueryRowContext(ctx, `
+		SELECT (SELECT COUNT(*) FROM ops WHERE payload != '') + (SELECT COUNT(*) FROM snapshots WHERE snapshot_blob LIKE '%secret%')
+	`).Scan(&inline); err != nil || inline != 0 {
+		t.Fatalf("expected no content in SQLite, found %d rows (%v)", inline, err)
 	}
-	if len(pulled) != 1 {
-		t.Fatalf("ops length: got %d", len(pulled))
+
+	for _, key := range blobs.Keys() {
+		if strings.HasPrefix(key, "ops/") {
+			if err := blobs.Put(ctx, key, []byte("tampered")); err != nil {
+				t.Fatalf("put: %v", err)
+			}
+		}
+	}
+	if _, _, err := store.GetOpsSince(ctx, "user-1", 0); err == nil {
+		t.Fatalf("expected checksum error for a tampered payload")
 	}
 }
 
-func TestInsertOpsDedupe(t *testing.T) {
-	store := newSQLiteStore(t)
-	userID := "user-1"
-	ops := []Op{
-		{
-			Scope:    "list",
-			Resource: "list-1",
-			Actor:    "actor-1",
-			Clock:    1,
-			Payload:  []byte(`{"type":"insert","itemId":"item-1"}`),
-		},
+func TestCompressedPayloadsReadBackUnchanged(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := storage.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	store.CompressPayloads(256)
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init sqlite: %v", err)
 	}
-	if _, err := store.InsertOps(context.Background(), userID, ops); err != nil {
+	t.Cleanup(func() { _ = store.Close() })
+	large := `{"type":"update","itemId":"item-1","payload":{"note":"` + strings.Repeat("buy more milk ", 200) + `"}}`
+	small := `{"type":"insert","itemId":"item-2"}`
+	if _, err := store.InsertOps(ctx, "user-1", []storage.Op{
+		{Scope: "list", Resource: "list-1", Actor: "actor-1", Clock: 1, Payload: []byte(large)},
+		{Scope: "list", Resource: "list-1", Actor: "actor-1", Clock: 2, Payload: []byte(small)},
+	}); err != nil {
 		t.Fatalf("insert ops: %v", err)
 	}
-	if _, err := store.InsertOps(context.Background(), userID, ops); err != nil {
+	ops, _, err := store.GetOpsSince(ctx, "user-1", 0)
+	if err != nil || len(ops) != 2 || string(ops[0].Payload) != large || string(ops[1].Payload) != small {
+		t.Fatalf("expected the payloads back unchanged: %+v (%v)", ops, err)
+	}
+	if stats, err := store.GetOpStats(ctx, "user-1"); err != nil || stats.Bytes != int64(len(large)+len(small)) {
+		t.Fatalf("expected op bytes to count uncompressed payloads: %+v (%v)", stats, err)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open raw sqlite: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+	var compressed, inline int
+	if err := db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(typeof(payload) = 'blob' AND LENGTH(payload) < ?), 0), COALESCE(SUM(payload = ?), 0) FROM ops
+	`, len(large)/4, small).Scan(&compressed, &inline); err != nil || compressed != 1 || inline != 1 {
+		t.Fatalf("expected one compressed and one inline payload, got %d and %d (%v)", compressed, inline, err)
+	}
+
+	// Quarantined ops keep the stored form and are read back the same way.
+	if err := store.QuarantineOp(ctx, "user-1", ops[0].ServerSeq, "test", 1); err != nil {
+		t.Fatalf("quarantine op: %v", err)
+	}
+	quarantined, err := store.GetQuarantinedOp(ctx, ops[0].ServerSeq)
+	if err != nil || string(quarantined.Payload) != large {
+		t.Fatalf("expected the quarantined payload back unchanged: %+v (%v)", quarantined, err)
+	}
+}
+
+func TestManualCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	store.DisableAutoCheckpoint()
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	ops := make([]storage.Op, 0, 200)
+	for clock := int64(1); clock <= 200; clock++ {
+		ops = append(ops, storage.Op{Scope: "list", Resource: "list-1", Actor: "actor-1", Clock: clock, Payload: []byte(`{"type":"insert"}`)})
+	}
+	if _, err := store.InsertOps(ctx, "user-1", ops); err != nil {
 		t.Fatalf("insert ops: %v", err)
 	}
-	pulled, _, err := store.GetOpsSince(context.Background(), userID, 0)
+	result, err := store.Checkpoint(ctx, storage.CheckpointPassive)
 	if err != nil {
-		t.Fatalf("get ops: %v", err)
+		t.Fatalf("checkpoint: %v", err)
+	}
+	if result.LogFrames == 0 {
+		t.Fatalf("expected WAL frames with auto-checkpoint disabled: %+v", result)
 	}
-	if len(pulled) != 1 {
-		t.Fatalf("ops length: got %d", len(pulled))
+	if _, err := store.Checkpoint(ctx, "bogus"); err == nil {
+		t.Fatalf("expected error for unsupported mode")
 	}
 }
 
-func TestClientCursorTracking(t *testing.T) {
-	store := newSQLiteStore(t)
-	userID := "user-1"
-	if err := store.TouchClient(context.Background(), userID, "client-1"); err != nil {
-		t.Fatalf("touch client: %v", err)
+func TestSecondInstanceRefusesToStart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+	first, err := storage.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
 	}
-	if err := store.UpdateClientCursor(context.Background(), userID, "client-1", 5); err != nil {
-		t.Fatalf("update cursor: %v", err)
+	if err := first.Init(ctx); err != nil {
+		t.Fatalf("init first: %v", err)
 	}
-	if err := store.UpdateClientCursor(context.Background(), userID, "client-1", 3); err != nil {
-		t.Fatalf("cursor should not regress: %v", err)
+
+	second, err := storage.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = second.Close() })
+	if err := second.Init(ctx); !errors.Is(err, storage.ErrDatabaseInUse) {
+		t.Fatalf("expected ErrDatabaseInUse, got %v", err)
+	}
+
+	if err := first.Close(); err != nil {
+		t.Fatalf("close first: %v", err)
+	}
+	if err := second.Init(ctx); err != nil {
+		t.Fatalf("init after release: %v", err)
 	}
 }
 
-func TestSnapshotReplaceResetsOps(t *testing.T) {
-	store := newSQLiteStore(t)
-	userID := "user-1"
+func TestInitRejectsBrokenDatabase(t *testing.T) {
 	ctx := context.Background()
-	if _, err := store.InsertOps(ctx, userID, []Op{
+	for _, tc := range []struct {
+		name    string
+		corrupt string
+		want    string
+	}{
 		{
-			Scope:    "list",
-			Resource: "list-1",
-			Actor:    "actor-1",
-			Clock:    1,
-			Payload:  []byte(`{"type":"insert","itemId":"item-1"}`),
+			name:    "dangling foreign key",
+			corrupt: "PRAGMA foreign_keys = OFF; DELETE FROM snapshots WHERE user_id = (SELECT id FROM users WHERE user_external_id = 'user-1');",
+			want:    "foreign key check",
 		},
-	}); err != nil {
-		t.Fatalf("insert ops: %v", err)
+		{
+			name:    "meta points at another user's snapshot",
+			corrupt: "UPDATE meta SET active_dataset_generation_id = (SELECT active_dataset_generation_id FROM meta WHERE user_id = (SELECT id FROM users WHERE user_external_id = 'user-2')) WHERE user_id = (SELECT id FROM users WHERE user_external_id = 'user-1');",
+			want:    "points at missing snapshot",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db")
+			store, err := storage.OpenSQLite(path)
+			if err != nil {
+				t.Fatalf("open sqlite: %v", err)
+			}
+			if err := store.Init(ctx); err != nil {
+				t.Fatalf("init: %v", err)
+			}
+			for _, userID := range []string{"user-1", "user-2"} {
+				if _, err := store.GetActiveDatasetGenerationKey(ctx, userID); err != nil {
+					t.Fatalf("active key: %v", err)
+				}
+			}
+			if err := store.Close(); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+
+			db, err := sql.Open("sqlite", path)
+			if err != nil {
+				t.Fatalf("open raw: %v", err)
+			}
+			if _, err := db.ExecContext(ctx, tc.corrupt); err != nil {
+				t.Fatalf("corrupt: %v", err)
+			}
+			_ = db.Close()
+
+			reopened, err := storage.OpenSQLite(path)
+			if err != nil {
+				t.Fatalf("open sqlite: %v", err)
+			}
+			t.Cleanup(func() { _ = reopened.Close() })
+			err = reopened.Init(ctx)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected %q error, got %v", tc.want, err)
+			}
+		})
 	}
-	if err := store.ReplaceSnapshot(ctx, userID, Snapshot{
-		DatasetGenerationKey: "dataset-new",
-		Blob:                 `{"schema":"net.aggregat4.tasklist.snapshot@v1","data":{"registry":{"clock":0,"entries":[]},"lists":[]}}`,
-	}); err != nil {
-		t.Fatalf("replace snapshot: %v", err)
+}
+
+func TestCheckConsistencyFindsRowsOutsideTheActiveGeneration(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := storage.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init: %v", err)
 	}
-	ops, _, err := store.GetOpsSince(ctx, userID, 0)
+	t.Cleanup(func() { _ = store.Close() })
+	for _, userID := range []string{"user-1", "user-2"} {
+		if _, err := store.GetActiveDatasetGenerationKey(ctx, userID); err != nil {
+			t.Fatalf("active key: %v", err)
+		}
+	}
+	if _, err := store.InsertOps(ctx, "user-1", []storage.Op{{Scope: "list", Resource: "list-1", Actor: "a", Clock: 1, Payload: []byte(`{}`)}}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if problems, err := store.CheckConsistency(ctx); err != nil || len(problems) != 0 {
+		t.Fatalf("expected a consistent database, got %q (%v)", problems, err)
+	}
+
+	// The server keeps running while a broken write or restore leaves ops in
+	// a generation that is not active, and links generations across users.
+	db, err := sql.Open("sqlite", path)
 	if err != nil {
-		t.Fatalf("get ops: %v", err)
+		t.Fatalf("open raw: %v", err)
 	}
-	if len(ops) != 0 {
-		t.Fatalf("ops should be cleared after snapshot replace")
+	defer func() { _ = db.Close() }()
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO snapshots (user_id, dataset_generation_key, snapshot_blob, created_at)
+		SELECT id, 'stale', '', 0 FROM users WHERE user_external_id = 'user-1';
+		UPDATE ops SET dataset_generation_id = (SELECT dataset_generation_id FROM snapshots WHERE dataset_generation_key = 'stale');
+		UPDATE snapshots SET parent_dataset_generation_id = (SELECT dataset_generation_id FROM snapshots WHERE dataset_generation_key = 'stale')
+		WHERE user_id = (SELECT id FROM users WHERE user_external_id = 'user-2');
+	`); err != nil {
+		t.Fatalf("corrupt: %v", err)
 	}
-	snapshot, err := store.GetSnapshot(ctx, userID)
+
+	problems, err := store.CheckConsistency(ctx)
 	if err != nil {
-		t.Fatalf("get snapshot: %v", err)
+		t.Fatalf("check: %v", err)
+	}
+	if len(problems) != 2 || !strings.Contains(problems[0], "test.db: user 1 has 1 ops in generation 3, which is not active") || !strings.Contains(problems[1], "descends from a generation of user 1") {
+		t.Fatalf("unexpected problems %q", problems)
+	}
+}
+
+func TestPlanMigrationsDoesNotChangeTheDatabase(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+	plan := func() storage.MigrationPlan {
+		t.Helper()
+		store, err := storage.OpenSQLite(path)
+		if err != nil {
+			t.Fatalf("open sqlite: %v", err)
+		}
+		defer func() { _ = store.Close() }()
+		plan, err := store.PlanMigrations(ctx)
+		if err != nil {
+			t.Fatalf("plan migrations: %v", err)
+		}
+		return plan
+	}
+
+	for range 2 {
+		if fresh := plan(); !fresh.NewDatabase || !strings.Contains(strings.Join(fresh.Tables, ","), "users") {
+			t.Fatalf("expected a new database needing the users table, got %+v", fresh)
+		}
+	}
+
+	store, err := storage.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if current := plan(); current.NewDatabase || current.Pending() {
+		t.Fatalf("expected nothing pending after init, got %+v", current)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open raw: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, "ALTER TABLE users DROP COLUMN locale; DROP TABLE oauth_apps;"); err != nil {
+		t.Fatalf("downgrade schema: %v", err)
+	}
+	_ = db.Close()
+	old := plan()
+	if strings.Join(old.Tables, ",") != "oauth_apps" || strings.Join(old.Columns, ",") != "users.locale" {
+		t.Fatalf("expected the dropped table and column to be pending, got %+v", old)
+	}
+	if again := plan(); strings.Join(again.Columns, ",") != "users.locale" {
+		t.Fatalf("expected planning to leave the database unchanged, got %+v", again)
+	}
+}
+
+func TestInitRenamesTheAssistantTokensTable(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+	open := func() *storage.SQLiteStore {
+		t.Helper()
+		store, err := storage.OpenSQLite(path)
+		if err != nil {
+			t.Fatalf("open sqlite: %v", err)
+		}
+		return store
+	}
+	store := open()
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	token := storage.APIToken{ID: "token-1", Name: "Dashboard", Scopes: []string{storage.TokenScopeRead}, CreatedAt: 1}
+	if err := store.CreateAPIToken(ctx, "user-1", token, "hash-1", ""); err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// Databases created before the rename still have the old table.
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open raw: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, "ALTER TABLE api_tokens RENAME TO assistant_tokens"); err != nil {
+		t.Fatalf("downgrade schema: %v", err)
+	}
+	_ = db.Close()
+
+	planner := open()
+	plan, err := planner.PlanMigrations(ctx)
+	_ = planner.Close()
+	if err != nil {
+		t.Fatalf("plan migrations: %v", err)
+	}
+	if strings.Join(plan.Renames, ",") != "assistant_tokens->api_tokens" || len(plan.Tables) != 0 {
+		t.Fatalf("expected only the rename to be pending, got %+v", plan)
+	}
+
+	store = open()
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init after downgrade: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	tokens, err := store.ListAPITokens(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("list tokens: %v", err)
+	}
+	if len(tokens) != 1 || tokens[0].ID != "token-1" {
+		t.Fatalf("expected the token to survive the rename, got %+v", tokens)
+	}
+}
+
+func TestTuningAppliesToEveryReadConnection(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	want := storage.SQLiteTuning{
+		CacheBytes:            16 << 20,
+		MmapBytes:             64 << 20,
+		JournalSizeLimitBytes: 8 << 20,
+		Synchronous:           storage.SynchronousFull,
+		TempStore:             storage.TempStoreMemory,
+	}
+	store.SetTuning(want)
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("init sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	// Concurrent reads make the pool open several connections.
+	var wg sync.WaitGroup
+	results := make([]storage.SQLiteTuning, 5)
+	errs := make([]error, len(results))
+	for i := range results {
+		wg.Go(func() { results[i], errs[i] = store.Tuning(ctx) })
+	}
+	wg.Wait()
+	for i, got := range results {
+		if errs[i] != nil {
+			t.Fatalf("read tuning: %v", errs[i])
+		}
+		if got != want {
+			t.Fatalf("unexpected tuning:\n got %+v\nwant %+v", got, want)
+		}
+	}
+
+	if _, err := storage.ParseSynchronous("sometimes"); err == nil {
+		t.Fatalf("expected error for unknown synchronous level")
 	}
-	if snapshot.DatasetGenerationKey != "dataset-new" {
-		t.Fatalf("snapshot datasetGenerationKey mismatch: %s", snapshot.DatasetGenerationKey)
+	if level, err := storage.ParseTempStore(" Memory "); err != nil || level != storage.TempStoreMemory {
+		t.Fatalf("parse temp store: %q %v", level, err)
 	}
 }
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (s *SQLiteStore) SaveStreamCursor(ctx context.Context, userID string, cursor StreamCursor) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if cursor.ClientID == "" || cursor.ConnectionID == "" {
+		return errors.New("clientId and connectionId are required")
+	}
+	if cursor.UpdatedAt == 0 {
+		cursor.UpdatedAt = time.Now().Unix()
+	}
+	tx, err := s.dbWrite.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin save stream cursor: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO stream_cursors (user_id, client_id, connection_id, dataset_generation_key, server_seq, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id, client_id, connection_id) DO UPDATE SET
+			dataset_generation_key = excluded.dataset_generation_key,
+			server_seq = excluded.server_seq,
+			updated_at = excluded.updated_at
+	`, internalUserID, cursor.ClientID, cursor.ConnectionID, cursor.DatasetGenerationKey, cursor.ServerSeq, cursor.UpdatedAt); err != nil {
+		return fmt.Errorf("save stream cursor: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM stream_cursors
+		WHERE user_id = ? AND client_id = ? AND rowid NOT IN (
+			SELECT rowid FROM stream_cursors
+			WHERE user_id = ? AND client_id = ?
+			ORDER BY updated_at DESC, rowid DESC
+			LIMIT ?
+		)
+	`, internalUserID, cursor.ClientID, internalUserID, cursor.ClientID, MaxStreamCursorsPerClient); err != nil {
+		return fmt.Errorf("trim stream cursors: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit stream cursor: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) GetStreamCursor(ctx context.Context, userID string, clientID string, connectionID string) (StreamCursor, error) {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return StreamCursor{}, err
+	}
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	cursor := StreamCursor{ClientID: clientID, ConnectionID: connectionID}
+	err = db.QueryRowContext(ctx, `
+		SELECT dataset_generation_key, server_seq, updated_at
+		FROM stream_cursors
+		WHERE user_id = ? AND client_id = ? AND connection_id = ?
+	`, internalUserID, clientID, connectionID).Scan(&cursor.DatasetGenerationKey, &cursor.ServerSeq, &cursor.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return StreamCursor{}, ErrStreamCursorNotFound
+	}
+	if err != nil {
+		return StreamCursor{}, fmt.Errorf("get stream cursor: %w", err)
+	}
+	return cursor, nil
+}
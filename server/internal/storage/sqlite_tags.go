@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// indexTags keeps item_tags in step with a freshly inserted op.
+func indexTags(ctx context.Context, conn *sql.Conn, userID int64, datasetGenerationID int64, op Op) error {
+	change := tagChangeForOp(op)
+	var err error
+	switch change.kind {
+	case tagChangeAdd:
+		_, err = conn.ExecContext(ctx, `
+			INSERT OR IGNORE INTO item_tags (user_id, dataset_generation_id, list_id, item_id, tag)
+			VALUES (?, ?, ?, ?, ?)
+		`, userID, datasetGenerationID, change.listID, change.itemID, change.tag)
+	case tagChangeRemove:
+		_, err = conn.ExecContext(ctx, `
+			DELETE FROM item_tags
+			WHERE user_id = ? AND dataset_generation_id = ? AND list_id = ? AND item_id = ? AND tag = ?
+		`, userID, datasetGenerationID, change.listID, change.itemID, change.tag)
+	case tagChangeDropItem:
+		_, err = conn.ExecContext(ctx, `
+			DELETE FROM item_tags
+			WHERE user_id = ? AND dataset_generation_id = ? AND list_id = ? AND item_id = ?
+		`, userID, datasetGenerationID, change.listID, change.itemID)
+	case tagChangeDropList:
+		_, err = conn.ExecContext(ctx, `
+			DELETE FROM item_tags
+			WHERE user_id = ? AND dataset_generation_id = ? AND list_id = ?
+		`, userID, datasetGenerationID, change.listID)
+	}
+	if err != nil {
+		return fmt.Errorf("index tags: %w", err)
+	}
+	return nil
+}
+
+// indexSnapshotTags seeds item_tags from a freshly installed snapshot.
+func indexSnapshotTags(ctx context.Context, conn *sql.Conn, userID int64, datasetGenerationID int64, blob string) error {
+	for _, tag := range snapshotTags(blob) {
+		if _, err := conn.ExecContext(ctx, `
+			INSERT OR IGNORE INTO item_tags (user_id, dataset_generation_id, list_id, item_id, tag)
+			VALUES (?, ?, ?, ?, ?)
+		`, userID, datasetGenerationID, tag.listID, tag.itemID, tag.tag); err != nil {
+			return fmt.Errorf("index snapshot tags: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListTags(ctx context.Context, userID string) ([]TagCount, error) {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ensureActiveSnapshot(ctx, internalUserID); err != nil {
+		return nil, err
+	}
+	datasetGenerationID, err := s.getActiveDatasetGenerationID(ctx, internalUserID)
+	if err != nil {
+		return nil, err
+	}
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT tag, COUNT(*)
+		FROM item_tags
+		WHERE user_id = ? AND dataset_generation_id = ?
+		GROUP BY tag
+		ORDER BY tag ASC
+	`, internalUserID, datasetGenerationID)
+	if err != nil {
+		return nil, fmt.Errorf("query tags: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	tags := make([]TagCount, 0)
+	for rows.Next() {
+		var tag TagCount
+		if err := rows.Scan(&tag.Tag, &tag.Count); err != nil {
+			return nil, fmt.Errorf("scan tag: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate tags: %w", err)
+	}
+	return tags, nil
+}
+
+func (s *SQLiteStore) ListTaggedItems(ctx context.Context, userID string, tag string) ([]TaggedItem, error) {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ensureActiveSnapshot(ctx, internalUserID); err != nil {
+		return nil, err
+	}
+	datasetGenerationID, err := s.getActiveDatasetGenerationID(ctx, internalUserID)
+	if err != nil {
+		return nil, err
+	}
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT list_id, item_id
+		FROM item_tags
+		WHERE user_id = ? AND dataset_generation_id = ? AND tag = ?
+		ORDER BY list_id ASC, item_id ASC
+	`, internalUserID, datasetGenerationID, NormalizeTag(tag))
+	if err != nil {
+		return nil, fmt.Errorf("query tagged items: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	items := make([]TaggedItem, 0)
+	for rows.Next() {
+		var item TaggedItem
+		if err := rows.Scan(&item.ListID, &item.ItemID); err != nil {
+			return nil, fmt.Errorf("scan tagged item: %w", err)
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate tagged items: %w", err)
+	}
+	return items, nil
+}
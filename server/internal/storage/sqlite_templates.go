@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (s *SQLiteStore) SetListTemplate(ctx context.Context, userID string, listID string, template bool) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if listID == "" {
+		return errors.New("listId is required")
+	}
+	if template {
+		_, err = s.dbWrite.ExecContext(ctx, `
+			INSERT INTO list_templates (user_id, list_id, created_at)
+			VALUES (?, ?, ?)
+			ON CONFLICT(user_id, list_id) DO NOTHING
+		`, internalUserID, listID, time.Now().Unix())
+	} else {
+		_, err = s.dbWrite.ExecContext(ctx, `
+			DELETE FROM list_templates WHERE user_id = ? AND list_id = ?
+		`, internalUserID, listID)
+	}
+	if err != nil {
+		return fmt.Errorf("set list template: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListTemplates(ctx context.Context, userID string) ([]string, error) {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT list_id FROM list_templates
+		WHERE user_id = ?
+		ORDER BY created_at ASC, rowid ASC
+	`, internalUserID)
+	if err != nil {
+		return nil, fmt.Errorf("list templates: %w", err)
+	}
+	defer rows.Close()
+	templates := make([]string, 0)
+	for rows.Next() {
+		var listID string
+		if err := rows.Scan(&listID); err != nil {
+			return nil, fmt.Errorf("scan template: %w", err)
+		}
+		templates = append(templates, listID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate templates: %w", err)
+	}
+	return templates, nil
+}
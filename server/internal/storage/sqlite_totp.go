@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (s *SQLiteStore) GetTOTPFactor(ctx context.Context, userID string) (TOTPFactor, error) {
+	if userID == "" {
+		return TOTPFactor{}, errors.New("userId is required")
+	}
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	var factor TOTPFactor
+	var internalUserID int64
+	err := db.QueryRowContext(ctx, `
+		SELECT t.user_id, t.secret, t.confirmed, t.last_step
+		FROM totp_factors t
+		JOIN users u ON u.id = t.user_id
+		WHERE u.user_external_id = ?
+	`, userID).Scan(&internalUserID, &factor.Secret, &factor.Confirmed, &factor.LastStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return TOTPFactor{}, ErrTOTPFactorNotFound
+	}
+	if err != nil {
+		return TOTPFactor{}, fmt.Errorf("get totp factor: %w", err)
+	}
+	rows, err := db.QueryContext(ctx, "SELECT code_hash FROM totp_recovery_codes WHERE user_id = ? ORDER BY code_hash", internalUserID)
+	if err != nil {
+		return TOTPFactor{}, fmt.Errorf("query recovery codes: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return TOTPFactor{}, fmt.Errorf("scan recovery code: %w", err)
+		}
+		factor.RecoveryCodeHashes = append(factor.RecoveryCodeHashes, hash)
+	}
+	if err := rows.Err(); err != nil {
+		return TOTPFactor{}, fmt.Errorf("iterate recovery codes: %w", err)
+	}
+	return factor, nil
+}
+
+func (s *SQLiteStore) SetTOTPFactor(ctx context.Context, userID string, factor TOTPFactor) error {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if factor.Secret == "" {
+		return errors.New("totp secret is required")
+	}
+	tx, err := s.dbWrite.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO totp_factors (user_id, secret, confirmed, last_step, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			secret = excluded.secret,
+			confirmed = excluded.confirmed,
+			last_step = excluded.last_step,
+			updated_at = excluded.updated_at
+	`, internalUserID, factor.Secret, factor.Confirmed, factor.LastStep, time.Now().Unix()); err != nil {
+		return fmt.Errorf("store totp factor: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM totp_recovery_codes WHERE user_id = ?", internalUserID); err != nil {
+		return fmt.Errorf("clear recovery codes: %w", err)
+	}
+	for _, hash := range factor.RecoveryCodeHashes {
+		if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO totp_recovery_codes (user_id, code_hash) VALUES (?, ?)", internalUserID, hash); err != nil {
+			return fmt.Errorf("store recovery code: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit totp factor: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) DeleteTOTPFactor(ctx context.Context, userID string) error {
+	if userID == "" {
+		return errors.New("userId is required")
+	}
+	tx, err := s.dbWrite.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	for _, table := range []string{"totp_recovery_codes", "totp_factors"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE user_id = (SELECT id FROM users WHERE user_external_id = ?)", userID); err != nil {
+			return fmt.Errorf("delete totp factor: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit totp factor: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) AcceptTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	result, err := s.dbWrite.ExecContext(ctx, `
+		UPDATE totp_factors SET last_step = ?, updated_at = ?
+		WHERE user_id = (SELECT id FROM users WHERE user_external_id = ?) AND last_step < ?
+	`, step, time.Now().Unix(), userID, step)
+	if err != nil {
+		return false, fmt.Errorf("accept totp step: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("accept totp step: %w", err)
+	}
+	return updated > 0, nil
+}
+
+func (s *SQLiteStore) UseTOTPRecoveryCode(ctx context.Context, userID string, codeHash string) (bool, error) {
+	result, err := s.dbWrite.ExecContext(ctx, `
+		DELETE FROM totp_recovery_codes
+		WHERE user_id = (SELECT id FROM users WHERE user_external_id = ?) AND code_hash = ?
+	`, userID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("use recovery code: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("use recovery code: %w", err)
+	}
+	return deleted > 0, nil
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Synchronous is the PRAGMA synchronous level, which trades durability of the
+// latest transactions after a power loss for commit latency.
+type Synchronous string
+
+const (
+	// SynchronousOff leaves syncing to the operating system. A power loss can
+	// corrupt the database.
+	SynchronousOff Synchronous = "off"
+	// SynchronousNormal syncs at WAL checkpoints. A power loss can roll back
+	// the latest commits but never corrupts the database.
+	SynchronousNormal Synchronous = "normal"
+	// SynchronousFull syncs the WAL at every commit.
+	SynchronousFull Synchronous = "full"
+	// SynchronousExtra also syncs the directory after deleting files.
+	SynchronousExtra Synchronous = "extra"
+)
+
+// ParseSynchronous parses a Synchronous level name.
+func ParseSynchronous(value string) (Synchronous, error) {
+	switch level := Synchronous(strings.ToLower(strings.TrimSpace(value))); level {
+	case SynchronousOff, SynchronousNormal, SynchronousFull, SynchronousExtra:
+		return level, nil
+	default:
+		return "", fmt.Errorf("unknown synchronous level %q (want off, normal, full, or extra)", value)
+	}
+}
+
+// TempStore is the PRAGMA temp_store setting: where SQLite keeps temporary
+// tables and indices for sorts it cannot serve from an index.
+type TempStore string
+
+const (
+	// TempStoreDefault uses the compile-time default, a file.
+	TempStoreDefault TempStore = "default"
+	// TempStoreFile keeps temporary data in files.
+	TempStoreFile TempStore = "file"
+	// TempStoreMemory keeps temporary data in memory.
+	TempStoreMemory TempStore = "memory"
+)
+
+// ParseTempStore parses a TempStore setting name.
+func ParseTempStore(value string) (TempStore, error) {
+	switch store := TempStore(strings.ToLower(strings.TrimSpace(value))); store {
+	case TempStoreDefault, TempStoreFile, TempStoreMemory:
+		return store, nil
+	default:
+		return "", fmt.Errorf("unknown temp store %q (want default, file, or memory)", value)
+	}
+}
+
+// SQLiteTuning holds the PRAGMA settings that trade memory and durability for
+// speed. Init applies them to every connection.
+type SQLiteTuning struct {
+	// CacheBytes is the page cache size of each connection (PRAGMA
+	// cache_size). The cache fills up as pages are read, so the writer and
+	// every busy reader can each use up to this much memory.
+	CacheBytes int64
+	// MmapBytes is how much of the file each connection reads through a
+	// memory map instead of read calls (PRAGMA mmap_size); 0 disables it.
+	MmapBytes int64
+	// JournalSizeLimitBytes is the size the WAL file is truncated to after a
+	// checkpoint (PRAGMA journal_size_limit), so a burst of writes does not
+	// keep its disk space.
+	JournalSizeLimitBytes int64
+	Synchronous           Synchronous
+	TempStore             TempStore
+}
+
+// DefaultSQLiteTuning returns the settings used unless SetTuning is called.
+// They keep SQLite's memory defaults and only bound the WAL file, which suits
+// small hosts; larger ones gain from raising CacheBytes and MmapBytes.
+func DefaultSQLiteTuning() SQLiteTuning {
+	return SQLiteTuning{
+		CacheBytes:            2 << 20,
+		MmapBytes:             0,
+		JournalSizeLimitBytes: 64 << 20,
+		Synchronous:           SynchronousNormal,
+		TempStore:             TempStoreDefault,
+	}
+}
+
+// SetTuning configures the PRAGMA settings applied by Init. Empty Synchronous
+// and TempStore fields keep their defaults. Call it before Init.
+func (s *SQLiteStore) SetTuning(tuning SQLiteTuning) {
+	defaults := DefaultSQLiteTuning()
+	if tuning.Synchronous == "" {
+		tuning.Synchronous = defaults.Synchronous
+	}
+	if tuning.TempStore == "" {
+		tuning.TempStore = defaults.TempStore
+	}
+	s.tuning = &tuning
+}
+
+// tuningPragmas lists the configured settings as PRAGMA assignments.
+func (s *SQLiteStore) tuningPragmas() []string {
+	tuning := DefaultSQLiteTuning()
+	if s.tuning != nil {
+		tuning = *s.tuning
+	}
+	return []string{
+		// A negative cache_size is in KiB rather than pages.
+		fmt.Sprintf("cache_size = %d", -max(tuning.CacheBytes/1024, 1)),
+		fmt.Sprintf("mmap_size = %d", tuning.MmapBytes),
+		fmt.Sprintf("journal_size_limit = %d", tuning.JournalSizeLimitBytes),
+		"synchronous = " + strings.ToUpper(string(tuning.Synchronous)),
+		"temp_store = " + strings.ToUpper(string(tuning.TempStore)),
+	}
+}
+
+// readDSN is the data source name of the read pool. database/sql opens pool
+// connections on demand, so per-connection PRAGMAs have to be part of the DSN
+// rather than executed once after opening.
+func (s *SQLiteStore) readDSN() string {
+	pragmas := append([]string{"busy_timeout = 5000", "query_only = ON", "foreign_keys = ON"}, s.tuningPragmas()...)
+	separator := "?"
+	if strings.Contains(s.path, "?") {
+		separator = "&"
+	}
+	return s.path + separator + url.Values{"_pragma": pragmas}.Encode()
+}
+
+// Tuning reads the settings in effect back from a read connection.
+func (s *SQLiteStore) Tuning(ctx context.Context) (SQLiteTuning, error) {
+	conn, err := s.dbRead.Conn(ctx)
+	if err != nil {
+		return SQLiteTuning{}, err
+	}
+	defer func() { _ = conn.Close() }()
+	var cacheSize, pageSize, synchronous, tempStore int64
+	var tuning SQLiteTuning
+	for _, pragma := range []struct {
+		name  string
+		value *int64
+	}{
+		{"cache_size", &cacheSize},
+		{"page_size", &pageSize},
+		{"mmap_size", &tuning.MmapBytes},
+		{"journal_size_limit", &tuning.JournalSizeLimitBytes},
+		{"synchronous", &synchronous},
+		{"temp_store", &tempStore},
+	} {
+		if err := conn.QueryRowContext(ctx, "PRAGMA "+pragma.name+";").Scan(pragma.value); err != nil {
+			return SQLiteTuning{}, fmt.Errorf("read %s: %w", pragma.name, err)
+		}
+	}
+	tuning.CacheBytes = -cacheSize * 1024
+	if cacheSize > 0 {
+		tuning.CacheBytes = cacheSize * pageSize
+	}
+	levels := []Synchronous{SynchronousOff, SynchronousNormal, SynchronousFull, SynchronousExtra}
+	if synchronous >= 0 && synchronous < int64(len(levels)) {
+		tuning.Synchronous = levels[synchronous]
+	}
+	stores := []TempStore{TempStoreDefault, TempStoreFile, TempStoreMemory}
+	if tempStore >= 0 && tempStore < int64(len(stores)) {
+		tuning.TempStore = stores[tempStore]
+	}
+	return tuning, nil
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+func (s *SQLiteStore) GetUsage(ctx context.Context, userID string) (Usage, error) {
+	internalUserID, err := s.resolveUserID(ctx, userID)
+	if err != nil {
+		return Usage{}, err
+	}
+	if err := s.ensureActiveSnapshot(ctx, internalUserID); err != nil {
+		return Usage{}, err
+	}
+	datasetGenerationID, err := s.getActiveDatasetGenerationID(ctx, internalUserID)
+	if err != nil {
+		return Usage{}, err
+	}
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	// Attachments are not stored by the server yet, so AttachmentBytes stays 0.
+	var usage Usage
+	row := db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COALESCE(snapshot_bytes, LENGTH(snapshot_blob)) FROM snapshots WHERE dataset_generation_id = ?),
+			(SELECT COUNT(*) FROM ops WHERE user_id = ? AND dataset_generation_id = ?),
+			(SELECT COALESCE(SUM(COALESCE(payload_bytes, LENGTH(payload))), 0) FROM ops WHERE user_id = ? AND dataset_generation_id = ?),
+			(SELECT COUNT(*) FROM clients WHERE user_id = ?)
+	`, datasetGenerationID, internalUserID, datasetGenerationID, internalUserID, datasetGenerationID, internalUserID)
+	if err := row.Scan(&usage.SnapshotBytes, &usage.OpCount, &usage.OpBytes, &usage.ClientCount); err != nil {
+		return Usage{}, fmt.Errorf("load usage: %w", err)
+	}
+	return usage, nil
+}
+
+func (s *SQLiteStore) ListUsers(ctx context.Context) ([]UserSummary, error) {
+	db := s.dbRead
+	if db == nil {
+		db = s.dbWrite
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT u.user_external_id, s.dataset_generation_key, COALESCE(s.snapshot_bytes, LENGTH(s.snapshot_blob)),
+			COUNT(o.server_seq), COALESCE(SUM(COALESCE(o.payload_bytes, LENGTH(o.payload))), 0), COALESCE(MAX(o.server_seq), 0),
+			(SELECT COUNT(*) FROM clients c WHERE c.user_id = u.id)
+		FROM users u
+		JOIN meta m ON m.user_id = u.id
+		JOIN snapshots s ON s.dataset_generation_id = m.active_dataset_generation_id
+		LEFT JOIN ops o ON o.user_id = u.id AND o.dataset_generation_id = m.active_dataset_generation_id
+		GROUP BY u.id
+		ORDER BY u.user_external_id ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query users: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	users := make([]UserSummary, 0)
+	for rows.Next() {
+		var user UserSummary
+		if err := rows.Scan(&user.UserID, &user.DatasetGenerationKey, &user.SnapshotBytes, &user.OpCount, &user.OpBytes, &user.MaxServerSeq, &user.ClientCount); err != nil {
+			return nil, fmt.Errorf("scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate users: %w", err)
+	}
+	return users, nil
+}
@@ -16,8 +16,9 @@ type Store interface {
 	// Close releases resources held by the storage backend.
 	Close() error
 
-	// InsertOps stores a batch of client operations for the active dataset
-	// generation and returns the latest server sequence for that generation.
+	// InsertOps stores a batch of client operations (with their originating
+	// Op.ClientID) for the active dataset generation and returns the latest
+	// server sequence for that generation.
 	//
 	// Why: push responses need the authoritative server cursor so clients can
 	// advance safely without re-reading old ops.
@@ -30,6 +31,15 @@ type Store interface {
 	// cursor, even when no new ops were returned.
 	GetOpsSince(ctx context.Context, userID string, since int64) ([]Op, int64, error)
 
+	// ForEachOpSince calls fn with each operation GetOpsSince would return, in
+	// serverSeq order, and then returns the latest serverSeq. An error from fn
+	// stops the iteration and is returned as is. fn runs while the backend's
+	// read snapshot is held, so slow callbacks delay WAL checkpoints.
+	//
+	// Why: bootstrap, compaction, and exports walk the whole op log, which
+	// must not need memory proportional to it.
+	ForEachOpSince(ctx context.Context, userID string, since int64, fn func(Op) error) (int64, error)
+
 	// GetActiveDatasetGenerationKey returns the key of the user's active dataset
 	// generation, creating initial generation state when missing.
 	//
@@ -50,6 +60,184 @@ type Store interface {
 	// and ops cannot leak into the new dataset.
 	ReplaceSnapshot(ctx context.Context, userID string, snapshot Snapshot) error
 
+	// ReplaceSnapshotIf behaves like ReplaceSnapshot but first verifies the
+	// precondition inside the same transaction, returning
+	// ErrDatasetGenerationChanged when it no longer holds.
+	//
+	// Why: server-side compaction folds the op log into a snapshot it built from
+	// a read; ops pushed in between must not be silently discarded.
+	ReplaceSnapshotIf(ctx context.Context, userID string, snapshot Snapshot, precondition SnapshotPrecondition) error
+
+	// ListOutbox returns up to limit undelivered outbox events of all users,
+	// oldest first. InsertOps adds one when it stored new ops and
+	// ReplaceSnapshot(If) one for the new generation, each in the transaction
+	// of the change.
+	//
+	// Why: side effects of a write, such as waking streams, run after the
+	// commit; recording them with the write lets a dispatcher deliver them at
+	// least once even when the process dies in between.
+	ListOutbox(ctx context.Context, limit int) ([]OutboxEvent, error)
+
+	// AckOutbox deletes delivered outbox events. Events that are already gone
+	// are ignored.
+	AckOutbox(ctx context.Context, events []OutboxEvent) error
+
+	// GetGenerationLineage returns the key of the active generation followed by
+	// the keys of its ancestors, newest first.
+	//
+	// Why: a client holding a stale key that is an ancestor only missed a
+	// compaction and could be upgraded, while any other key belongs to a
+	// divergent import and requires a full resync.
+	GetGenerationLineage(ctx context.Context, userID string) ([]string, error)
+
+	// GetOpStats returns op count, payload bytes, and the latest serverSeq of the
+	// user's active dataset generation.
+	//
+	// Why: compaction thresholds are expressed in ops and bytes.
+	GetOpStats(ctx context.Context, userID string) (OpStats, error)
+
+	// GetUsage reports snapshot size, op log size, attachment bytes, and client
+	// count for the user's active dataset generation.
+	//
+	// Why: quota UI and capacity planning need per-user numbers without
+	// downloading the snapshot.
+	GetUsage(ctx context.Context, userID string) (Usage, error)
+
+	// ListUsers summarizes every user with a dataset, ordered by user id.
+	//
+	// Why: the admin UI lists users and their op log size without a query per
+	// user.
+	ListUsers(ctx context.Context) ([]UserSummary, error)
+
+	// BindActors registers each actor id to the user (and the client that first
+	// used it) on first sight, and returns ErrActorNotOwned if any of them is
+	// already registered to another user.
+	//
+	// Why: op attribution relies on actor ids; without a binding any user could
+	// push ops claiming another user's actor once lists are shared.
+	BindActors(ctx context.Context, userID string, clientID string, actors []string) error
+
+	// ServerActor returns the actor id the server signs the user's generated
+	// ops with, storing candidate as that id if the user has none yet.
+	//
+	// Why: REST writes, imports and quick-add act for the user without a
+	// client; one actor per user keeps the actor registry from growing with
+	// every request.
+	ServerActor(ctx context.Context, userID string, candidate string) (string, error)
+
+	// MigrateUserID moves all data of fromUserID to toUserID and records the
+	// mapping. It does nothing when fromUserID is unknown or toUserID already
+	// exists, so it is safe to call on every login.
+	//
+	// Why: switching the identity claim (e.g. from an opaque, rotating sub to a
+	// verified email) must not orphan existing datasets.
+	MigrateUserID(ctx context.Context, fromUserID string, toUserID string) error
+
+	// ListPasskeys returns the user's registered passkeys. Unknown users have
+	// none; the lookup never creates a user.
+	//
+	// Why: passkey login resolves the user from the authenticator's user handle
+	// before it is authenticated, so probing must not create accounts.
+	ListPasskeys(ctx context.Context, userID string) ([]Passkey, error)
+
+	// AddPasskey registers a new passkey for the user.
+	AddPasskey(ctx context.Context, userID string, passkey Passkey) error
+
+	// UpdatePasskey replaces the stored data of one of the user's passkeys.
+	//
+	// Why: the authenticator's signature counter must be persisted after every
+	// login to detect cloned credentials.
+	UpdatePasskey(ctx context.Context, userID string, passkey Passkey) error
+
+	// CountPasskeys returns the number of passkeys registered across all users.
+	//
+	// Why: a fresh deployment lets the first user sign up even when open signup
+	// is disabled.
+	CountPasskeys(ctx context.Context) (int64, error)
+
+	// GetTOTPFactor returns the user's TOTP second factor, confirmed or still
+	// being enrolled, or ErrTOTPFactorNotFound. It never creates a user.
+	//
+	// Why: passkey login asks for a code before the session is established,
+	// and only users who finished enrollment are asked.
+	GetTOTPFactor(ctx context.Context, userID string) (TOTPFactor, error)
+
+	// SetTOTPFactor replaces the user's TOTP factor and its recovery codes.
+	SetTOTPFactor(ctx context.Context, userID string, factor TOTPFactor) error
+
+	// DeleteTOTPFactor removes the user's TOTP factor and recovery codes.
+	// Deleting a missing factor is not an error.
+	DeleteTOTPFactor(ctx context.Context, userID string) error
+
+	// AcceptTOTPStep records step as the user's last used TOTP time step and
+	// reports true, or reports false when step is not after the last one.
+	//
+	// Why: a code stays valid for its whole time step and the neighbouring
+	// ones; recording the step atomically stops an observed code from being
+	// replayed in that window, even by concurrent logins.
+	AcceptTOTPStep(ctx context.Context, userID string, step int64) (bool, error)
+
+	// UseTOTPRecoveryCode removes the recovery code with codeHash and reports
+	// whether the user had it.
+	//
+	// Why: each recovery code must work exactly once.
+	UseTOTPRecoveryCode(ctx context.Context, userID string, codeHash string) (bool, error)
+
+	// UpdateUserProfile stores the user's profile claims. The locale and time
+	// zone are only taken when none is stored, so ones the user chose survive
+	// later logins.
+	//
+	// Why: ops only carry actor ids; showing who made a change in a shared list
+	// needs a name to resolve them to.
+	UpdateUserProfile(ctx context.Context, userID string, profile UserProfile) error
+
+	// SetTimeZone stores the user's time zone, or clears it with "".
+	//
+	// Why: digests and relative due dates follow the user's day, not the
+	// server's.
+	SetTimeZone(ctx context.Context, userID string, timeZone string) error
+
+	// SetLocale stores the user's locale, or clears it with "".
+	//
+	// Why: emails and exports are written in the user's language.
+	SetLocale(ctx context.Context, userID string, locale string) error
+
+	// GetUserProfile returns the user's stored profile, or a zero profile when
+	// none was recorded.
+	//
+	// Why: clients show the signed-in user's name and avatar.
+	GetUserProfile(ctx context.Context, userID string) (UserProfile, error)
+
+	// GetDigestSettings returns the user's email digest settings; users who
+	// never opted in get zero settings.
+	GetDigestSettings(ctx context.Context, userID string) (DigestSettings, error)
+
+	// SetDigestFrequency stores the user's digest frequency (DigestDaily,
+	// DigestWeekly, or "" to opt out). Changing it restarts the schedule, so the
+	// next digest only covers activity from then on.
+	//
+	// Why: digests are opt-in per user, and switching back on after months must
+	// not mail the whole backlog.
+	SetDigestFrequency(ctx context.Context, userID string, frequency string) error
+
+	// ListDigestSubscriptions returns the settings of all users with a digest
+	// frequency, ordered by user id.
+	//
+	// Why: the digest job runs across users, outside any request.
+	ListDigestSubscriptions(ctx context.Context) ([]DigestSettings, error)
+
+	// MarkDigestSent records that the user's digest covering ops up to serverSeq
+	// went out at sentAt (unix seconds).
+	MarkDigestSent(ctx context.Context, userID string, sentAt int64, serverSeq int64) error
+
+	// GetActorAttribution returns the attribution of the users owning the given
+	// actors, keyed by actor id. Actors owned by userID itself, unknown actors,
+	// and owners without a profile are omitted.
+	//
+	// Why: pull responses attribute ops made by other users in shared lists
+	// without a separate lookup service.
+	GetActorAttribution(ctx context.Context, userID string, actors []string) (map[string]UserProfile, error)
+
 	// TouchClient upserts client presence without advancing the cursor.
 	//
 	// Why: this keeps a client record alive (for heartbeat/registration use-cases)
@@ -62,4 +250,291 @@ type Store interface {
 	// Why: compaction safety depends on the minimum known client cursor. Push and
 	// pull both establish authoritative progress points and should call this.
 	UpdateClientCursor(ctx context.Context, userID string, clientID string, serverSeq int64) error
+
+	// RecordClientHeartbeat stores the client's latest heartbeat and upserts
+	// its presence. A new client record starts at the reported cursor; an
+	// existing cursor is left alone, since only push and pull establish
+	// authoritative progress.
+	//
+	// Why: operators see each device's app version, clock skew, and how far
+	// it trails without waiting for it to sync.
+	RecordClientHeartbeat(ctx context.Context, userID string, clientID string, heartbeat ClientHeartbeat) error
+
+	// ListClients returns the user's known clients ordered by client id.
+	//
+	// Why: compaction and operators need to see how far each client has
+	// progressed.
+	ListClients(ctx context.Context, userID string) ([]Client, error)
+
+	// ListFleetClients returns the clients of all users ordered by user and
+	// client id, each with the latest serverSeq of its user's active
+	// generation.
+	//
+	// Why: operators judge from the whole fleet when to force compaction or
+	// deprecate a protocol version.
+	ListFleetClients(ctx context.Context) ([]FleetClient, error)
+
+	// ListClientsPage returns a page of clients in the query's order, each
+	// with the latest serverSeq of its user's active generation. A cursor
+	// from a listing in another order returns ErrInvalidCursor.
+	//
+	// Why: fleets outgrow listing every client in one response, and finding
+	// the laggards or the silent devices should not need the whole table.
+	ListClientsPage(ctx context.Context, query ClientQuery) (ClientPage, error)
+
+	// MinClientCursor returns the lowest cursor among the user's clients,
+	// and false when the user has none.
+	//
+	// Why: compaction reports how much of the folded op log some client had
+	// not pulled yet, which should not cost a scan of all clients.
+	MinClientCursor(ctx context.Context, userID string) (int64, bool, error)
+
+	// SetClientHint stores a pending hint (ClientHintResync or
+	// ClientHintUpgrade) for an existing client; an empty hint clears it.
+	// Unknown clients return ErrClientNotFound.
+	//
+	// Why: operators recover a single misbehaving device without resetting the
+	// whole dataset for every device.
+	SetClientHint(ctx context.Context, userID string, clientID string, hint string) error
+
+	// TakeClientHint returns the client's pending hint and clears it, or "" when
+	// there is none.
+	//
+	// Why: pull delivers each hint once, so a client acts on it a single time.
+	TakeClientHint(ctx context.Context, userID string, clientID string) (string, error)
+
+	// RetireClient removes the client's record so its cursor no longer counts.
+	// With revoke, the client id is also blocked from syncing again (see
+	// CheckClient). Unknown clients return ErrClientNotFound unless revoke is
+	// set.
+	//
+	// Why: a lost or wiped device otherwise pins the minimum client cursor
+	// forever, and its user should be able to cut it off.
+	RetireClient(ctx context.Context, userID string, clientID string, revoke bool) error
+
+	// CheckClient returns ErrClientRevoked when the user revoked clientID.
+	//
+	// Why: sync endpoints refuse revoked clients before touching any state.
+	CheckClient(ctx context.Context, userID string, clientID string) error
+
+	// SaveStreamCursor stores where one of the client's stream connections
+	// stopped, replacing that connection's previous cursor. Cursors are
+	// dropped with the client and when the active generation changes.
+	//
+	// Why: a reconnecting stream resumes exactly after the last event the
+	// connection delivered, on any instance sharing the store, so clients
+	// need no dedupe heuristics for replayed events.
+	SaveStreamCursor(ctx context.Context, userID string, cursor StreamCursor) error
+
+	// GetStreamCursor returns the saved cursor of one of the client's stream
+	// connections, or ErrStreamCursorNotFound.
+	GetStreamCursor(ctx context.Context, userID string, clientID string, connectionID string) (StreamCursor, error)
+
+	// ListTags returns the tags used in the user's active dataset generation
+	// together with the number of items carrying each tag.
+	//
+	// Why: integrations can offer label pickers without replaying the op log.
+	ListTags(ctx context.Context, userID string) ([]TagCount, error)
+
+	// ListTaggedItems returns the items carrying the (normalized) tag in the
+	// user's active dataset generation.
+	//
+	// Why: filtered item queries resolve candidates from the tag index and only
+	// materialize the details they need.
+	ListTaggedItems(ctx context.Context, userID string, tag string) ([]TaggedItem, error)
+
+	// SetListTemplate marks (or unmarks) a list as one of the user's templates.
+	//
+	// Why: recurring lists (packing, shopping) are kept as templates that are
+	// duplicated with unchecked items; list ids survive compaction, so the mark
+	// lives outside the op log.
+	SetListTemplate(ctx context.Context, userID string, listID string, template bool) error
+
+	// ListTemplates returns the ids of the user's template lists in the order
+	// they were marked. Ids of lists removed since are included; callers check
+	// them against the materialized state.
+	ListTemplates(ctx context.Context, userID string) ([]string, error)
+
+	// SetListArchived archives (or restores) one of the user's lists.
+	//
+	// Why: sync leaves archived lists out of default bootstrap and pull
+	// responses, so years of dead lists stop costing every client, while the
+	// data stays in the dataset and can be restored.
+	SetListArchived(ctx context.Context, userID string, listID string, archived bool) error
+
+	// ListArchivedLists returns the ids of the user's archived lists in the
+	// order they were archived.
+	ListArchivedLists(ctx context.Context, userID string) ([]string, error)
+
+	// SetListMuted mutes (or unmutes) notifications about one of the user's
+	// lists.
+	//
+	// Why: busy lists other people edit would otherwise fill every digest,
+	// drowning out the lists the user cares to hear about.
+	SetListMuted(ctx context.Context, userID string, listID string, muted bool) error
+
+	// ListMutedLists returns the ids of the user's muted lists in the order
+	// they were muted.
+	ListMutedLists(ctx context.Context, userID string) ([]string, error)
+
+	// CreateExportSchedule stores a new export schedule for the user.
+	//
+	// Why: users push their lists to storage they control on a cadence, as a
+	// personal off-server backup, without exporting by hand.
+	CreateExportSchedule(ctx context.Context, userID string, schedule ExportSchedule) error
+
+	// ListExportSchedules returns the user's export schedules, oldest first.
+	ListExportSchedules(ctx context.Context, userID string) ([]ExportSchedule, error)
+
+	// DeleteExportSchedule removes one of the user's export schedules, or
+	// returns ErrExportScheduleNotFound.
+	DeleteExportSchedule(ctx context.Context, userID string, scheduleID string) error
+
+	// ListAllExportSchedules returns every user's export schedules (with
+	// UserID set), ordered by user id and creation.
+	//
+	// Why: the export job runs across users, outside any request.
+	ListAllExportSchedules(ctx context.Context) ([]ExportSchedule, error)
+
+	// MarkExportRun records an export attempt at ranAt (unix seconds) and its
+	// error message, empty on success.
+	MarkExportRun(ctx context.Context, userID string, scheduleID string, ranAt int64, lastError string) error
+
+	// CreateAPIToken stores a new API token for the user under the
+	// hash of its secret. Signed tokens also keep signingKey, the key derived
+	// from the secret that requests are signed with; it is empty otherwise.
+	//
+	// Why: assistants, integrations and scripts act for a user without a
+	// browser session, so they authenticate with a bearer token whose scopes,
+	// list and expiry limit what they can do.
+	CreateAPIToken(ctx context.Context, userID string, token APIToken, secretHash string, signingKey string) error
+
+	// ListAPITokens returns the user's API tokens, oldest first.
+	ListAPITokens(ctx context.Context, userID string) ([]APIToken, error)
+
+	// RevokeAPIToken deletes one of the user's API tokens, or
+	// returns ErrAPITokenNotFound.
+	RevokeAPIToken(ctx context.Context, userID string, tokenID string) error
+
+	// UseAPIToken returns the token (with its UserID) stored under
+	// secretHash and records usedAt as its last use, or returns
+	// ErrAPITokenNotFound if there is none or it expired before usedAt.
+	UseAPIToken(ctx context.Context, secretHash string, usedAt int64) (APIToken, error)
+
+	// SignedAPIToken returns the signed token with tokenID (with its UserID)
+	// and its signing key, or returns ErrAPITokenNotFound if there is none or
+	// it expired before now. The key is empty for tokens stored before signing
+	// keys were kept.
+	//
+	// Why: server-to-server callers sign requests instead of sending the
+	// secret, so a captured request cannot be replayed or altered.
+	SignedAPIToken(ctx context.Context, tokenID string, now int64) (APIToken, string, error)
+
+	// UseSignedAPIToken records usedAt as the last use of the signed token
+	// with tokenID and returns it (with its UserID), or returns
+	// ErrAPITokenNotFound if there is none or it expired before usedAt.
+	UseSignedAPIToken(ctx context.Context, tokenID string, usedAt int64) (APIToken, error)
+
+	// CreateHouseholdMember stores a new household member of the user.
+	//
+	// Why: children and other household members get their own devices
+	// restricted to a few lists without needing a login of their own.
+	CreateHouseholdMember(ctx context.Context, userID string, member HouseholdMember) error
+
+	// ListHouseholdMembers returns the user's household members, oldest
+	// first.
+	ListHouseholdMembers(ctx context.Context, userID string) ([]HouseholdMember, error)
+
+	// GetHouseholdMember returns one of the user's household members, or
+	// ErrHouseholdMemberNotFound.
+	GetHouseholdMember(ctx context.Context, userID string, memberID string) (HouseholdMember, error)
+
+	// UpdateHouseholdMember replaces the name and lists of one of the user's
+	// household members, or returns ErrHouseholdMemberNotFound.
+	UpdateHouseholdMember(ctx context.Context, userID string, member HouseholdMember) error
+
+	// DeleteHouseholdMember removes one of the user's household members
+	// together with its API tokens, or returns ErrHouseholdMemberNotFound.
+	DeleteHouseholdMember(ctx context.Context, userID string, memberID string) error
+
+	// CreateOAuthApp registers a third-party app with the deployment.
+	//
+	// Why: third-party apps obtain API tokens through the user's consent
+	// (OAuth2) instead of users handing them session cookies or personal
+	// tokens.
+	CreateOAuthApp(ctx context.Context, app OAuthApp) error
+
+	// GetOAuthApp returns an app including its SecretHash, or returns
+	// ErrOAuthAppNotFound.
+	GetOAuthApp(ctx context.Context, appID string) (OAuthApp, error)
+
+	// ListOAuthApps returns all registered apps, oldest first.
+	ListOAuthApps(ctx context.Context) ([]OAuthApp, error)
+
+	// DeleteOAuthApp removes an app, or returns ErrOAuthAppNotFound. Tokens
+	// already issued to it stay valid until they expire or are revoked.
+	DeleteOAuthApp(ctx context.Context, appID string) error
+
+	// UserExists reports whether the user has been seen before. Unlike the
+	// methods that take a user id, it never creates the user.
+	//
+	// Why: signup gating has to tell a first login from a returning user
+	// before anything is stored for them.
+	UserExists(ctx context.Context, userID string) (bool, error)
+
+	// CreateInvite stores an invite whose code hashes to codeHash.
+	//
+	// Why: deployments that accept any OIDC login can still have first
+	// logins present an invite code.
+	CreateInvite(ctx context.Context, invite Invite, codeHash string) error
+
+	// ListInvites returns all invites, oldest first.
+	ListInvites(ctx context.Context) ([]Invite, error)
+
+	// DeleteInvite removes an invite, or returns ErrInviteNotFound.
+	DeleteInvite(ctx context.Context, inviteID string) error
+
+	// RedeemInvite uses one use of the invite stored under codeHash at
+	// usedAt and returns it, or returns ErrInviteNotFound if there is none,
+	// it expired before usedAt, or it is used up.
+	RedeemInvite(ctx context.Context, codeHash string, usedAt int64) (Invite, error)
+
+	// ListSettings returns the settings stored through the admin API, by key.
+	//
+	// Why: operators tune limits and flags at runtime without editing the
+	// environment or restarting; stored settings override both.
+	ListSettings(ctx context.Context) ([]Setting, error)
+
+	// PutSetting stores a setting and records the change.
+	PutSetting(ctx context.Context, setting Setting) error
+
+	// DeleteSetting removes a stored setting, recording the change as made by
+	// changedBy at changedAt, or returns ErrSettingNotFound.
+	DeleteSetting(ctx context.Context, key string, changedBy string, changedAt int64) error
+
+	// ListSettingChanges returns up to limit recorded setting changes, newest
+	// first.
+	ListSettingChanges(ctx context.Context, limit int) ([]SettingChange, error)
+
+	// QuarantineOp moves an op of the user's active generation out of the op
+	// log into quarantine, recording why at quarantinedAt, or returns
+	// ErrOpNotFound. Quarantined ops are left out of pulls and
+	// materialization.
+	//
+	// Why: an op that keeps failing to materialize would otherwise break
+	// every read and compaction of the user's data until an operator edits
+	// the database by hand.
+	QuarantineOp(ctx context.Context, userID string, serverSeq int64, reason string, quarantinedAt int64) error
+
+	// ListQuarantinedOps returns the quarantined ops of all users in the order
+	// they were pushed.
+	ListQuarantinedOps(ctx context.Context) ([]QuarantinedOp, error)
+
+	// GetQuarantinedOp returns the quarantined op that had serverSeq, or
+	// returns ErrOpNotFound.
+	GetQuarantinedOp(ctx context.Context, serverSeq int64) (QuarantinedOp, error)
+
+	// DeleteQuarantinedOp drops a quarantined op, or returns ErrOpNotFound.
+	// Restoring an op is InsertOps followed by DeleteQuarantinedOp.
+	DeleteQuarantinedOp(ctx context.Context, serverSeq int64) error
 }
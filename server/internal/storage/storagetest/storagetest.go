@@ -0,0 +1,1679 @@
+// Package storagetest is a conformance suite every storage.Store
+// implementation must pass.
+//
+// Backends call Run from their own tests with a factory returning a fresh,
+// initialized store. The suite covers the semantics handlers rely on: dedupe,
+// cursor monotonicity, generation resets, tag indexing, and per-user isolation.
+package storagetest
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+// Factory returns a fresh, initialized store. Implementations should register
+// cleanup with t.Cleanup.
+type Factory func(t *testing.T) storage.Store
+
+// Run executes the conformance suite against stores produced by newStore.
+func Run(t *testing.T, newStore Factory) {
+	t.Helper()
+	tests := []struct {
+		name string
+		run  func(t *testing.T, store storage.Store)
+	}{
+		{"InsertAndGetOps", testInsertAndGetOps},
+		{"InsertOpsDedupe", testInsertOpsDedupe},
+		{"InsertOpsRejectsInvalidMetadata", testInsertOpsRejectsInvalidMetadata},
+		{"GetOpsSinceCursor", testGetOpsSinceCursor},
+		{"ForEachOpSince", testForEachOpSince},
+		{"OpsBecomeVisibleInServerSeqOrder", testOpsBecomeVisibleInServerSeqOrder},
+		{"ClientCursorMonotonic", testClientCursorMonotonic},
+		{"ClientHints", testClientHints},
+		{"ClientHeartbeats", testClientHeartbeats},
+		{"FleetClients", testFleetClients},
+		{"ClientsPage", testClientsPage},
+		{"MinClientCursor", testMinClientCursor},
+		{"RetireClient", testRetireClient},
+		{"StreamCursors", testStreamCursors},
+		{"SnapshotReplaceResetsGeneration", testSnapshotReplaceResetsGeneration},
+		{"SnapshotReplaceRejectsDuplicateKey", testSnapshotReplaceRejectsDuplicateKey},
+		{"ReplaceSnapshotIfRejectsConcurrentOps", testReplaceSnapshotIfRejectsConcurrentOps},
+		{"GenerationLineage", testGenerationLineage},
+		{"Outbox", testOutbox},
+		{"OpStats", testOpStats},
+		{"Usage", testUsage},
+		{"ListUsers", testListUsers},
+		{"ActorBinding", testActorBinding},
+		{"ActorAttribution", testActorAttribution},
+		{"ServerActorIsStable", testServerActorIsStable},
+		{"DigestSettings", testDigestSettings},
+		{"UserPreferences", testUserPreferences},
+		{"MigrateUserID", testMigrateUserID},
+		{"Passkeys", testPasskeys},
+		{"TOTPFactors", testTOTPFactors},
+		{"TagIndexFollowsOps", testTagIndexFollowsOps},
+		{"TagIndexSeededFromSnapshot", testTagIndexSeededFromSnapshot},
+		{"ListTemplates", testListTemplates},
+		{"ArchivedLists", testArchivedLists},
+		{"MutedLists", testMutedLists},
+		{"APITokens", testAPITokens},
+		{"HouseholdMembers", testHouseholdMembers},
+		{"ExportSchedules", testExportSchedules},
+		{"OAuthApps", testOAuthApps},
+		{"UserExists", testUserExists},
+		{"Invites", testInvites},
+		{"Settings", testSettings},
+		{"QuarantineOps", testQuarantineOps},
+		{"PerUserIsolation", testPerUserIsolation},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.run(t, newStore(t))
+		})
+	}
+}
+
+const emptySnapshot = `{"schema":"net.aggregat4.tasklist.snapshot@v1","data":{"lists":[]}}`
+
+func listOp(clock int64, payload string) storage.Op {
+	return storage.Op{Scope: "list", Resource: "list-1", Actor: "actor-1", Clock: clock, Payload: []byte(payload)}
+}
+
+func insertOps(t *testing.T, store storage.Store, userID string, ops ...storage.Op) int64 {
+	t.Helper()
+	seq, err := store.InsertOps(context.Background(), userID, ops)
+	if err != nil {
+		t.Fatalf("insert ops: %v", err)
+	}
+	return seq
+}
+
+func getOps(t *testing.T, store storage.Store, userID string, since int64) ([]storage.Op, int64) {
+	t.Helper()
+	ops, seq, err := store.GetOpsSince(context.Background(), userID, since)
+	if err != nil {
+		t.Fatalf("get ops: %v", err)
+	}
+	return ops, seq
+}
+
+func testInsertAndGetOps(t *testing.T, store storage.Store) {
+	op := listOp(1, `{"type":"insert","itemId":"item-1"}`)
+	op.ClientID = "client-1"
+	seq := insertOps(t, store, "user-1", op)
+	if seq == 0 {
+		t.Fatalf("serverSeq should advance")
+	}
+	ops, seq2 := getOps(t, store, "user-1", 0)
+	if seq2 != seq {
+		t.Fatalf("serverSeq mismatch: %d vs %d", seq2, seq)
+	}
+	if len(ops) != 1 {
+		t.Fatalf("ops length: got %d", len(ops))
+	}
+	if ops[0].ServerSeq != seq || ops[0].Scope != "list" || ops[0].Resource != "list-1" || string(ops[0].Payload) != `{"type":"insert","itemId":"item-1"}` || ops[0].ClientID != "client-1" {
+		t.Fatalf("unexpected op: %+v", ops[0])
+	}
+}
+
+func testInsertOpsDedupe(t *testing.T, store storage.Store) {
+	op := listOp(1, `{"type":"insert","itemId":"item-1"}`)
+	first := insertOps(t, store, "user-1", op)
+	second := insertOps(t, store, "user-1", op)
+	if second != first {
+		t.Fatalf("duplicate push should not advance serverSeq: %d vs %d", second, first)
+	}
+	ops, _ := getOps(t, store, "user-1", 0)
+	if len(ops) != 1 {
+		t.Fatalf("ops length: got %d", len(ops))
+	}
+}
+
+func testInsertOpsRejectsInvalidMetadata(t *testing.T, store storage.Store) {
+	_, err := store.InsertOps(context.Background(), "user-1", []storage.Op{
+		{Scope: "list", Resource: "list-1", Actor: "", Clock: 1, Payload: []byte(`{}`)},
+	})
+	if err == nil {
+		t.Fatalf("expected error for missing actor")
+	}
+	ops, _ := getOps(t, store, "user-1", 0)
+	if len(ops) != 0 {
+		t.Fatalf("invalid batch should not be stored: %d ops", len(ops))
+	}
+}
+
+func testGetOpsSinceCursor(t *testing.T, store storage.Store) {
+	first := insertOps(t, store, "user-1", listOp(1, `{}`))
+	insertOps(t, store, "user-1", listOp(2, `{}`), listOp(3, `{}`))
+	ops, seq := getOps(t, store, "user-1", first)
+	if len(ops) != 2 {
+		t.Fatalf("ops since cursor: got %d", len(ops))
+	}
+	for i := 1; i < len(ops); i++ {
+		if ops[i].ServerSeq <= ops[i-1].ServerSeq {
+			t.Fatalf("ops out of order: %+v", ops)
+		}
+	}
+	empty, seqAgain := getOps(t, store, "user-1", seq)
+	if len(empty) != 0 || seqAgain != seq {
+		t.Fatalf("caught-up pull: ops=%d seq=%d want seq=%d", len(empty), seqAgain, seq)
+	}
+}
+
+func testForEachOpSince(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	first := insertOps(t, store, "user-1", listOp(1, `{}`))
+	insertOps(t, store, "user-1", listOp(2, `{}`), listOp(3, `{}`))
+	want, wantSeq := getOps(t, store, "user-1", first)
+	var got []storage.Op
+	seq, err := store.ForEachOpSince(ctx, "user-1", first, func(op storage.Op) error {
+		got = append(got, op)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("for each op: %v", err)
+	}
+	if seq != wantSeq || len(got) != len(want) {
+		t.Fatalf("for each op: got %d ops seq=%d, want %d ops seq=%d", len(got), seq, len(want), wantSeq)
+	}
+	for i := range want {
+		if got[i].ServerSeq != want[i].ServerSeq || got[i].Clock != want[i].Clock || string(got[i].Payload) != string(want[i].Payload) {
+			t.Fatalf("op %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if seq, err := store.ForEachOpSince(ctx, "user-1", wantSeq, func(storage.Op) error {
+		t.Fatal("caught-up iteration should not call fn")
+		return nil
+	}); err != nil || seq != wantSeq {
+		t.Fatalf("caught-up iteration: seq=%d err=%v", seq, err)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	if _, err := store.ForEachOpSince(ctx, "user-1", 0, func(storage.Op) error {
+		calls++
+		return stop
+	}); !errors.Is(err, stop) || calls != 1 {
+		t.Fatalf("fn error should stop the iteration: calls=%d err=%v", calls, err)
+	}
+}
+
+// testOpsBecomeVisibleInServerSeqOrder pulls with the returned cursor while
+// other goroutines push. A reader that only ever asks for ops after its
+// cursor must still see every op: an op must never become visible after one
+// with a higher serverSeq, and the cursor must never pass an op not returned.
+func testOpsBecomeVisibleInServerSeqOrder(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	const writers, opsPerWriter = 4, 25
+	insertOps(t, store, "user-1")
+	var wg sync.WaitGroup
+	for writer := range writers {
+		wg.Go(func() {
+			for i := range opsPerWriter {
+				op := storage.Op{Scope: "list", Resource: "list-1", Actor: fmt.Sprintf("actor-%d", writer), Clock: int64(i + 1), Payload: []byte(`{"type":"noop"}`)}
+				if _, err := store.InsertOps(ctx, "user-1", []storage.Op{op}); err != nil {
+					t.Errorf("insert op: %v", err)
+					return
+				}
+			}
+		})
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	var cursor int64
+	seen := 0
+	pull := func() {
+		ops, serverSeq, err := store.GetOpsSince(ctx, "user-1", cursor)
+		if err != nil {
+			t.Fatalf("get ops: %v", err)
+		}
+		for _, op := range ops {
+			if op.ServerSeq <= cursor {
+				t.Fatalf("op %d delivered after cursor %d", op.ServerSeq, cursor)
+			}
+			cursor = op.ServerSeq
+			seen++
+		}
+		if serverSeq < cursor {
+			t.Fatalf("serverSeq %d is behind the last op %d", serverSeq, cursor)
+		}
+		cursor = serverSeq
+	}
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		pull()
+	}
+	pull()
+	if seen != writers*opsPerWriter {
+		t.Fatalf("cursor skipped ops: saw %d of %d", seen, writers*opsPerWriter)
+	}
+}
+
+func testClientCursorMonotonic(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	if err := store.TouchClient(ctx, "user-1", "client-1"); err != nil {
+		t.Fatalf("touch client: %v", err)
+	}
+	if err := store.UpdateClientCursor(ctx, "user-1", "client-1", 5); err != nil {
+		t.Fatalf("update cursor: %v", err)
+	}
+	if err := store.UpdateClientCursor(ctx, "user-1", "client-1", 3); err != nil {
+		t.Fatalf("update cursor: %v", err)
+	}
+	if err := store.TouchClient(ctx, "user-1", "client-1"); err != nil {
+		t.Fatalf("touch client: %v", err)
+	}
+	clients, err := store.ListClients(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("list clients: %v", err)
+	}
+	if len(clients) != 1 || clients[0].ClientID != "client-1" || clients[0].LastSeenServerSeq != 5 {
+		t.Fatalf("cursor should not regress: %+v", clients)
+	}
+	if err := store.UpdateClientCursor(ctx, "user-1", "", 1); err == nil {
+		t.Fatalf("expected error for empty client id")
+	}
+}
+
+func testClientHeartbeats(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	first := storage.ClientHeartbeat{AppVersion: "1.4.0", ClockSkewMs: -250, ReportedServerSeq: 7, HeartbeatAt: 100}
+	if err := store.RecordClientHeartbeat(ctx, "user-1", "client-1", first); err != nil {
+		t.Fatalf("record heartbeat: %v", err)
+	}
+	clients, err := store.ListClients(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("list clients: %v", err)
+	}
+	if len(clients) != 1 || clients[0].ClientHeartbeat != first || clients[0].LastSeenServerSeq != 7 {
+		t.Fatalf("expected a new client at the reported cursor, got %+v", clients)
+	}
+
+	if err := store.UpdateClientCursor(ctx, "user-1", "client-1", 12); err != nil {
+		t.Fatalf("update cursor: %v", err)
+	}
+	second := storage.ClientHeartbeat{AppVersion: "1.5.0", ClockSkewMs: 40, ReportedServerSeq: 3, HeartbeatAt: 200}
+	if err := store.RecordClientHeartbeat(ctx, "user-1", "client-1", second); err != nil {
+		t.Fatalf("record heartbeat: %v", err)
+	}
+	clients, err = store.ListClients(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("list clients: %v", err)
+	}
+	if len(clients) != 1 || clients[0].ClientHeartbeat != second || clients[0].LastSeenServerSeq != 12 {
+		t.Fatalf("heartbeat should replace the report but keep the cursor, got %+v", clients)
+	}
+	if clients, err := store.ListClients(ctx, "user-2"); err != nil || len(clients) != 0 {
+		t.Fatalf("expected no clients for another user, got %+v, %v", clients, err)
+	}
+	if err := store.RecordClientHeartbeat(ctx, "user-1", "", first); err == nil {
+		t.Fatalf("expected error for empty client id")
+	}
+}
+
+func testFleetClients(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	seq := insertOps(t, store, "user-2", listOp(1, `{}`), listOp(2, `{}`))
+	if err := store.UpdateClientCursor(ctx, "user-2", "client-b", seq-1); err != nil {
+		t.Fatalf("update cursor: %v", err)
+	}
+	if err := store.RecordClientHeartbeat(ctx, "user-2", "client-a", storage.ClientHeartbeat{AppVersion: "2.0.0", HeartbeatAt: 100}); err != nil {
+		t.Fatalf("record heartbeat: %v", err)
+	}
+	if err := store.UpdateClientCursor(ctx, "user-1", "client-c", 0); err != nil {
+		t.Fatalf("update cursor: %v", err)
+	}
+	clients, err := store.ListFleetClients(ctx)
+	if err != nil {
+		t.Fatalf("list fleet clients: %v", err)
+	}
+	if len(clients) != 3 {
+		t.Fatalf("expected clients of both users, got %+v", clients)
+	}
+	if clients[0].UserID != "user-1" || clients[0].ClientID != "client-c" || clients[0].LatestServerSeq != 0 {
+		t.Fatalf("unexpected first client: %+v", clients[0])
+	}
+	if clients[1].UserID != "user-2" || clients[1].ClientID != "client-a" || clients[1].AppVersion != "2.0.0" || clients[1].LatestServerSeq != seq {
+		t.Fatalf("unexpected second client: %+v", clients[1])
+	}
+	if clients[2].ClientID != "client-b" || clients[2].LastSeenServerSeq != seq-1 || clients[2].LatestServerSeq != seq {
+		t.Fatalf("unexpected third client: %+v", clients[2])
+	}
+}
+
+func testClientsPage(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	seq := insertOps(t, store, "user-2", listOp(1, `{}`), listOp(2, `{}`), listOp(3, `{}`))
+	for _, client := range []struct {
+		userID, clientID string
+		cursor           int64
+	}{{"user-2", "client-a", seq}, {"user-2", "client-b", seq - 2}, {"user-2", "client-c", seq - 1}, {"user-1", "client-d", 0}, {"user-1", "client-e", 0}} {
+		if err := store.UpdateClientCursor(ctx, client.userID, client.clientID, client.cursor); err != nil {
+			t.Fatalf("update cursor: %v", err)
+		}
+	}
+	if err := store.RecordClientHeartbeat(ctx, "user-1", "client-e", storage.ClientHeartbeat{HeartbeatAt: time.Now().Add(time.Hour).Unix()}); err != nil {
+		t.Fatalf("record heartbeat: %v", err)
+	}
+	all, err := store.ListFleetClients(ctx)
+	if err != nil {
+		t.Fatalf("list fleet clients: %v", err)
+	}
+	ids := func(clients []storage.FleetClient) []string {
+		ids := make([]string, len(clients))
+		for i, client := range clients {
+			ids[i] = client.ClientID
+		}
+		return ids
+	}
+	for _, order := range []string{storage.ClientOrderID, storage.ClientOrderLastSeen, storage.ClientOrderLag} {
+		want := slices.Clone(all)
+		slices.SortStableFunc(want, func(a, b storage.FleetClient) int {
+			switch order {
+			case storage.ClientOrderLastSeen:
+				return cmp.Compare(b.LastSeen(), a.LastSeen())
+			case storage.ClientOrderLag:
+				return cmp.Compare(b.Lag(), a.Lag())
+			}
+			return 0
+		})
+		var got []storage.FleetClient
+		query := storage.ClientQuery{Order: order, Limit: 2}
+		for pages := 0; ; pages++ {
+			if pages > len(all) {
+				t.Fatalf("%s: listing does not end", order)
+			}
+			page, err := store.ListClientsPage(ctx, query)
+			if err != nil {
+				t.Fatalf("%s: list clients page: %v", order, err)
+			}
+			got = append(got, page.Clients...)
+			if page.Next == "" {
+				break
+			}
+			query.After = page.Next
+		}
+		if !slices.Equal(ids(got), ids(want)) {
+			t.Fatalf("%s: got %v, want %v", order, ids(got), ids(want))
+		}
+		if got[0].LatestServerSeq != want[0].LatestServerSeq {
+			t.Fatalf("%s: latest serverSeq not returned: %+v", order, got[0])
+		}
+	}
+	page, err := store.ListClientsPage(ctx, storage.ClientQuery{UserID: "user-2", Order: storage.ClientOrderLag})
+	if err != nil || !slices.Equal(ids(page.Clients), []string{"client-b", "client-c", "client-a"}) || page.Next != "" {
+		t.Fatalf("user filter: %v %+v", err, page)
+	}
+	first, err := store.ListClientsPage(ctx, storage.ClientQuery{Limit: 1})
+	if err != nil {
+		t.Fatalf("first page: %v", err)
+	}
+	if _, err := store.ListClientsPage(ctx, storage.ClientQuery{Order: storage.ClientOrderLag, After: first.Next}); !errors.Is(err, storage.ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor for a cursor of another order, got %v", err)
+	}
+	if _, err := store.ListClientsPage(ctx, storage.ClientQuery{Order: "size"}); !errors.Is(err, storage.ErrUnknownClientOrder) {
+		t.Fatalf("expected ErrUnknownClientOrder, got %v", err)
+	}
+}
+
+func testMinClientCursor(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	if _, ok, err := store.MinClientCursor(ctx, "user-1"); err != nil || ok {
+		t.Fatalf("a user without clients has no min cursor: ok=%v err=%v", ok, err)
+	}
+	seq := insertOps(t, store, "user-1", listOp(1, `{}`), listOp(2, `{}`))
+	for clientID, cursor := range map[string]int64{"client-a": seq, "client-b": seq - 1} {
+		if err := store.UpdateClientCursor(ctx, "user-1", clientID, cursor); err != nil {
+			t.Fatalf("update cursor: %v", err)
+		}
+	}
+	if err := store.UpdateClientCursor(ctx, "user-2", "client-c", 0); err != nil {
+		t.Fatalf("update cursor: %v", err)
+	}
+	if cursor, ok, err := store.MinClientCursor(ctx, "user-1"); err != nil || !ok || cursor != seq-1 {
+		t.Fatalf("min cursor: got %d ok=%v err=%v, want %d", cursor, ok, err, seq-1)
+	}
+}
+
+func testClientHints(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	if err := store.SetClientHint(ctx, "user-1", "client-1", storage.ClientHintResync); !errors.Is(err, storage.ErrClientNotFound) {
+		t.Fatalf("expected ErrClientNotFound for unknown client, got %v", err)
+	}
+	if err := store.UpdateClientCursor(ctx, "user-1", "client-1", 4); err != nil {
+		t.Fatalf("update cursor: %v", err)
+	}
+	if err := store.SetClientHint(ctx, "user-1", "client-1", storage.ClientHintResync); err != nil {
+		t.Fatalf("set hint: %v", err)
+	}
+	clients, err := store.ListClients(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("list clients: %v", err)
+	}
+	if len(clients) != 1 || clients[0].Hint != storage.ClientHintResync || clients[0].LastSeenServerSeq != 4 {
+		t.Fatalf("expected pending hint on listed client, got %+v", clients)
+	}
+	if hint, err := store.TakeClientHint(ctx, "user-2", "client-1"); err != nil || hint != "" {
+		t.Fatalf("expected no hint for another user, got %q, %v", hint, err)
+	}
+	if hint, err := store.TakeClientHint(ctx, "user-1", "client-1"); err != nil || hint != storage.ClientHintResync {
+		t.Fatalf("expected resync hint, got %q, %v", hint, err)
+	}
+	if hint, err := store.TakeClientHint(ctx, "user-1", "client-1"); err != nil || hint != "" {
+		t.Fatalf("expected hint to be delivered once, got %q, %v", hint, err)
+	}
+
+	if err := store.SetClientHint(ctx, "user-1", "client-1", storage.ClientHintUpgrade); err != nil {
+		t.Fatalf("set hint: %v", err)
+	}
+	if err := store.SetClientHint(ctx, "user-1", "client-1", ""); err != nil {
+		t.Fatalf("clear hint: %v", err)
+	}
+	if hint, err := store.TakeClientHint(ctx, "user-1", "client-1"); err != nil || hint != "" {
+		t.Fatalf("expected cleared hint, got %q, %v", hint, err)
+	}
+}
+
+func testRetireClient(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	for _, clientID := range []string{"client-1", "client-2"} {
+		if err := store.UpdateClientCursor(ctx, "user-1", clientID, 3); err != nil {
+			t.Fatalf("update cursor: %v", err)
+		}
+	}
+	if err := store.RetireClient(ctx, "user-1", "client-1", false); err != nil {
+		t.Fatalf("retire client: %v", err)
+	}
+	if err := store.RetireClient(ctx, "user-1", "client-1", false); !errors.Is(err, storage.ErrClientNotFound) {
+		t.Fatalf("expected ErrClientNotFound for retired client, got %v", err)
+	}
+	if err := store.CheckClient(ctx, "user-1", "client-1"); err != nil {
+		t.Fatalf("retired client without revoke should be allowed back, got %v", err)
+	}
+	if err := store.RetireClient(ctx, "user-1", "client-2", true); err != nil {
+		t.Fatalf("revoke client: %v", err)
+	}
+	if err := store.CheckClient(ctx, "user-1", "client-2"); !errors.Is(err, storage.ErrClientRevoked) {
+		t.Fatalf("expected ErrClientRevoked, got %v", err)
+	}
+	if err := store.CheckClient(ctx, "user-2", "client-2"); err != nil {
+		t.Fatalf("revocation should not leak to other users, got %v", err)
+	}
+	clients, err := store.ListClients(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("list clients: %v", err)
+	}
+	if len(clients) != 0 {
+		t.Fatalf("expected retired clients to be released, got %+v", clients)
+	}
+
+	if err := store.ReplaceSnapshot(ctx, "user-1", storage.Snapshot{DatasetGenerationKey: "gen-retire", Blob: emptySnapshot}); err != nil {
+		t.Fatalf("replace snapshot: %v", err)
+	}
+	if err := store.CheckClient(ctx, "user-1", "client-2"); !errors.Is(err, storage.ErrClientRevoked) {
+		t.Fatalf("expected revocation to survive a reset, got %v", err)
+	}
+}
+
+func testStreamCursors(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	save := func(clientID string, connectionID string, serverSeq int64, updatedAt int64) {
+		t.Helper()
+		cursor := storage.StreamCursor{ClientID: clientID, ConnectionID: connectionID, DatasetGenerationKey: "gen-1", ServerSeq: serverSeq, UpdatedAt: updatedAt}
+		if err := store.SaveStreamCursor(ctx, "user-1", cursor); err != nil {
+			t.Fatalf("save stream cursor: %v", err)
+		}
+	}
+	save("client-1", "tab-1", 3, 100)
+	save("client-1", "tab-1", 5, 101)
+	save("client-2", "tab-1", 7, 100)
+	cursor, err := store.GetStreamCursor(ctx, "user-1", "client-1", "tab-1")
+	if err != nil || cursor.ServerSeq != 5 || cursor.DatasetGenerationKey != "gen-1" || cursor.UpdatedAt != 101 {
+		t.Fatalf("expected the latest cursor of tab-1, got %+v (%v)", cursor, err)
+	}
+	if _, err := store.GetStreamCursor(ctx, "user-1", "client-1", "tab-2"); !errors.Is(err, storage.ErrStreamCursorNotFound) {
+		t.Fatalf("expected ErrStreamCursorNotFound, got %v", err)
+	}
+	if _, err := store.GetStreamCursor(ctx, "user-2", "client-1", "tab-1"); !errors.Is(err, storage.ErrStreamCursorNotFound) {
+		t.Fatalf("stream cursors should not leak to other users, got %v", err)
+	}
+	if err := store.SaveStreamCursor(ctx, "user-1", storage.StreamCursor{ClientID: "client-1"}); err == nil {
+		t.Fatal("expected a cursor without connection id to be rejected")
+	}
+
+	// Only the most recently saved connections of a client are kept.
+	for i := range storage.MaxStreamCursorsPerClient {
+		save("client-1", fmt.Sprintf("extra-%d", i), int64(i), int64(200+i))
+	}
+	if _, err := store.GetStreamCursor(ctx, "user-1", "client-1", "tab-1"); !errors.Is(err, storage.ErrStreamCursorNotFound) {
+		t.Fatalf("expected the oldest connection to be dropped, got %v", err)
+	}
+	if _, err := store.GetStreamCursor(ctx, "user-1", "client-1", "extra-0"); err != nil {
+		t.Fatalf("expected recent connections to be kept, got %v", err)
+	}
+
+	if err := store.UpdateClientCursor(ctx, "user-1", "client-1", 5); err != nil {
+		t.Fatalf("update cursor: %v", err)
+	}
+	if err := store.RetireClient(ctx, "user-1", "client-1", false); err != nil {
+		t.Fatalf("retire client: %v", err)
+	}
+	if _, err := store.GetStreamCursor(ctx, "user-1", "client-1", "extra-0"); !errors.Is(err, storage.ErrStreamCursorNotFound) {
+		t.Fatalf("expected a retired client's cursors to be dropped, got %v", err)
+	}
+	if err := store.ReplaceSnapshot(ctx, "user-1", storage.Snapshot{DatasetGenerationKey: "gen-2", Blob: emptySnapshot}); err != nil {
+		t.Fatalf("replace snapshot: %v", err)
+	}
+	if _, err := store.GetStreamCursor(ctx, "user-1", "client-2", "tab-1"); !errors.Is(err, storage.ErrStreamCursorNotFound) {
+		t.Fatalf("expected a reset to drop stream cursors, got %v", err)
+	}
+}
+
+func testSnapshotReplaceResetsGeneration(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	insertOps(t, store, "user-1", listOp(1, `{"type":"insert","itemId":"item-1"}`))
+	if err := store.UpdateClientCursor(ctx, "user-1", "client-1", 1); err != nil {
+		t.Fatalf("update cursor: %v", err)
+	}
+	if err := store.SetClientHint(ctx, "user-1", "client-1", storage.ClientHintResync); err != nil {
+		t.Fatalf("set hint: %v", err)
+	}
+	heartbeat := storage.ClientHeartbeat{AppVersion: "1.4.0", ClockSkewMs: 250, ReportedServerSeq: 1, HeartbeatAt: 100}
+	if err := store.RecordClientHeartbeat(ctx, "user-1", "client-1", heartbeat); err != nil {
+		t.Fatalf("record heartbeat: %v", err)
+	}
+	before, err := store.GetActiveDatasetGenerationKey(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("active key: %v", err)
+	}
+	if err := store.ReplaceSnapshot(ctx, "user-1", storage.Snapshot{DatasetGenerationKey: "dataset-new", Blob: emptySnapshot}); err != nil {
+		t.Fatalf("replace snapshot: %v", err)
+	}
+	ops, seq := getOps(t, store, "user-1", 0)
+	if len(ops) != 0 || seq != 0 {
+		t.Fatalf("ops should be cleared after reset: ops=%d seq=%d", len(ops), seq)
+	}
+	clients, err := store.ListClients(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("list clients: %v", err)
+	}
+	if len(clients) != 1 || clients[0].LastSeenServerSeq != 0 || clients[0].Hint != storage.ClientHintResync {
+		t.Fatalf("expected the client to keep its hint with a reset cursor: %+v", clients)
+	}
+	if clients[0].ClientHeartbeat != heartbeat {
+		t.Fatalf("expected the heartbeat to survive the reset: %+v", clients[0].ClientHeartbeat)
+	}
+	snapshot, err := store.GetSnapshot(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("get snapshot: %v", err)
+	}
+	if snapshot.DatasetGenerationKey != "dataset-new" || snapshot.Blob != emptySnapshot {
+		t.Fatalf("unexpected snapshot: %+v", snapshot)
+	}
+	after, err := store.GetActiveDatasetGenerationKey(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("active key: %v", err)
+	}
+	if after == before || after != "dataset-new" {
+		t.Fatalf("generation not switched: %s -> %s", before, after)
+	}
+}
+
+func testSnapshotReplaceRejectsDuplicateKey(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	active, err := store.GetActiveDatasetGenerationKey(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("active key: %v", err)
+	}
+	err = store.ReplaceSnapshot(ctx, "user-1", storage.Snapshot{DatasetGenerationKey: active, Blob: emptySnapshot})
+	if !errors.Is(err, storage.ErrDatasetGenerationKeyExists) {
+		t.Fatalf("expected ErrDatasetGenerationKeyExists, got %v", err)
+	}
+	if err := store.ReplaceSnapshot(ctx, "user-1", storage.Snapshot{Blob: emptySnapshot}); err == nil {
+		t.Fatalf("expected error for empty generation key")
+	}
+}
+
+func testReplaceSnapshotIfRejectsConcurrentOps(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	insertOps(t, store, "user-1", listOp(1, `{}`), listOp(2, `{}`))
+	active, err := store.GetActiveDatasetGenerationKey(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("active key: %v", err)
+	}
+	err = store.ReplaceSnapshotIf(ctx, "user-1", storage.Snapshot{DatasetGenerationKey: "next", Blob: emptySnapshot}, storage.SnapshotPrecondition{
+		DatasetGenerationKey: active,
+		MaxServerSeq:         1,
+		CheckServerSeq:       true,
+	})
+	if !errors.Is(err, storage.ErrDatasetGenerationChanged) {
+		t.Fatalf("expected ErrDatasetGenerationChanged for stale seq, got %v", err)
+	}
+	err = store.ReplaceSnapshotIf(ctx, "user-1", storage.Snapshot{DatasetGenerationKey: "next", Blob: emptySnapshot}, storage.SnapshotPrecondition{
+		DatasetGenerationKey: "someone-else",
+	})
+	if !errors.Is(err, storage.ErrDatasetGenerationChanged) {
+		t.Fatalf("expected ErrDatasetGenerationChanged for stale key, got %v", err)
+	}
+	_, seq := getOps(t, store, "user-1", 0)
+	if err := store.ReplaceSnapshotIf(ctx, "user-1", storage.Snapshot{DatasetGenerationKey: "next", Blob: emptySnapshot}, storage.SnapshotPrecondition{
+		DatasetGenerationKey: active,
+		MaxServerSeq:         seq,
+		CheckServerSeq:       true,
+	}); err != nil {
+		t.Fatalf("replace with matching precondition: %v", err)
+	}
+}
+
+func testOutbox(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	seq := insertOps(t, store, "user-1", listOp(1, `{"type":"insert","itemId":"item-1"}`))
+	insertOps(t, store, "user-1", listOp(1, `{"type":"insert","itemId":"item-1"}`))
+	if _, err := store.InsertOps(ctx, "user-1", nil); err != nil {
+		t.Fatalf("insert nothing: %v", err)
+	}
+	if err := store.ReplaceSnapshot(ctx, "user-1", storage.Snapshot{DatasetGenerationKey: "imported", Blob: emptySnapshot}); err != nil {
+		t.Fatalf("replace snapshot: %v", err)
+	}
+	events, err := store.ListOutbox(ctx, 10)
+	if err != nil {
+		t.Fatalf("list outbox: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected one event per change, got %+v", events)
+	}
+	if ops := events[0]; ops.UserID != "user-1" || ops.Kind != storage.OutboxKindOps || ops.ServerSeq != seq || ops.DatasetGenerationKey == "" || ops.CreatedAt == 0 {
+		t.Fatalf("unexpected ops event %+v", ops)
+	}
+	if generation := events[1]; generation.Kind != storage.OutboxKindGeneration || generation.DatasetGenerationKey != "imported" || generation.ServerSeq != 0 {
+		t.Fatalf("unexpected generation event %+v", generation)
+	}
+	if limited, err := store.ListOutbox(ctx, 1); err != nil || len(limited) != 1 || limited[0].ID != events[0].ID {
+		t.Fatalf("expected the limit to keep the oldest event, got %+v (%v)", limited, err)
+	}
+
+	if err := store.AckOutbox(ctx, events[:1]); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	if err := store.AckOutbox(ctx, events[:1]); err != nil {
+		t.Fatalf("acking twice should be harmless: %v", err)
+	}
+	events, err = store.ListOutbox(ctx, 10)
+	if err != nil || len(events) != 1 || events[0].Kind != storage.OutboxKindGeneration {
+		t.Fatalf("expected the generation event to stay pending, got %+v (%v)", events, err)
+	}
+}
+
+func testGenerationLineage(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	root, err := store.GetActiveDatasetGenerationKey(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("active key: %v", err)
+	}
+	if err := store.ReplaceSnapshot(ctx, "user-1", storage.Snapshot{DatasetGenerationKey: "compacted", Blob: emptySnapshot, ParentDatasetGenerationKey: root}); err != nil {
+		t.Fatalf("replace with parent: %v", err)
+	}
+	lineage, err := store.GetGenerationLineage(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("lineage: %v", err)
+	}
+	if len(lineage) != 2 || lineage[0] != "compacted" || lineage[1] != root {
+		t.Fatalf("unexpected lineage: %v", lineage)
+	}
+	snapshot, err := store.GetSnapshot(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	if age := time.Since(time.Unix(snapshot.CreatedAt, 0)); snapshot.DatasetGenerationKey != "compacted" || age < 0 || age > time.Minute {
+		t.Fatalf("expected the active snapshot to carry its creation time, got %+v", snapshot)
+	}
+	if err := store.ReplaceSnapshot(ctx, "user-1", storage.Snapshot{DatasetGenerationKey: "orphan", Blob: emptySnapshot, ParentDatasetGenerationKey: "missing"}); err == nil {
+		t.Fatalf("expected error for unknown parent")
+	}
+	if err := store.ReplaceSnapshot(ctx, "user-1", storage.Snapshot{DatasetGenerationKey: "imported", Blob: emptySnapshot}); err != nil {
+		t.Fatalf("replace without parent: %v", err)
+	}
+	lineage, err = store.GetGenerationLineage(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("lineage: %v", err)
+	}
+	if len(lineage) != 1 || lineage[0] != "imported" {
+		t.Fatalf("import should start a new lineage: %v", lineage)
+	}
+}
+
+func testOpStats(t *testing.T, store storage.Store) {
+	seq := insertOps(t, store, "user-1", listOp(1, `{}`), listOp(2, `{"a":1}`))
+	stats, err := store.GetOpStats(context.Background(), "user-1")
+	if err != nil {
+		t.Fatalf("op stats: %v", err)
+	}
+	if stats.Count != 2 || stats.Bytes != 9 || stats.MaxServerSeq != seq {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func testListUsers(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	if err := store.ReplaceSnapshot(ctx, "user-2", storage.Snapshot{DatasetGenerationKey: "imported", Blob: emptySnapshot}); err != nil {
+		t.Fatalf("replace snapshot: %v", err)
+	}
+	seq := insertOps(t, store, "user-2", listOp(1, `{}`), listOp(2, `{"a":1}`))
+	if err := store.UpdateClientCursor(ctx, "user-2", "client-1", seq); err != nil {
+		t.Fatalf("update cursor: %v", err)
+	}
+	insertOps(t, store, "user-1", listOp(1, `{}`))
+	users, err := store.ListUsers(ctx)
+	if err != nil {
+		t.Fatalf("list users: %v", err)
+	}
+	if len(users) != 2 || users[0].UserID != "user-1" || users[0].OpCount != 1 {
+		t.Fatalf("unexpected users: %+v", users)
+	}
+	want := storage.UserSummary{
+		UserID:               "user-2",
+		DatasetGenerationKey: "imported",
+		Usage:                storage.Usage{SnapshotBytes: int64(len(emptySnapshot)), OpCount: 2, OpBytes: 9, ClientCount: 1},
+		MaxServerSeq:         seq,
+	}
+	if users[1] != want {
+		t.Fatalf("unexpected summary: got %+v want %+v", users[1], want)
+	}
+}
+
+func testUsage(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	if err := store.ReplaceSnapshot(ctx, "user-1", storage.Snapshot{DatasetGenerationKey: "imported", Blob: emptySnapshot}); err != nil {
+		t.Fatalf("replace snapshot: %v", err)
+	}
+	seq := insertOps(t, store, "user-1", listOp(1, `{}`), listOp(2, `{"a":1}`))
+	if err := store.UpdateClientCursor(ctx, "user-1", "client-1", seq); err != nil {
+		t.Fatalf("update cursor: %v", err)
+	}
+	usage, err := store.GetUsage(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("usage: %v", err)
+	}
+	want := storage.Usage{SnapshotBytes: int64(len(emptySnapshot)), OpCount: 2, OpBytes: 9, ClientCount: 1}
+	if usage != want {
+		t.Fatalf("unexpected usage: got %+v want %+v", usage, want)
+	}
+	other, err := store.GetUsage(ctx, "user-2")
+	if err != nil {
+		t.Fatalf("usage for other user: %v", err)
+	}
+	if other.OpCount != 0 || other.ClientCount != 0 {
+		t.Fatalf("usage leaked across users: %+v", other)
+	}
+}
+
+func testActorBinding(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	if err := store.BindActors(ctx, "user-1", "client-1", []string{"actor-1", "actor-2"}); err != nil {
+		t.Fatalf("bind actors: %v", err)
+	}
+	if err := store.BindActors(ctx, "user-1", "client-2", []string{"actor-1"}); err != nil {
+		t.Fatalf("rebinding own actor should succeed: %v", err)
+	}
+	err := store.BindActors(ctx, "user-2", "client-3", []string{"actor-3", "actor-2"})
+	if !errors.Is(err, storage.ErrActorNotOwned) {
+		t.Fatalf("expected ErrActorNotOwned, got %v", err)
+	}
+	if err := store.BindActors(ctx, "user-1", "client-1", []string{"actor-3"}); err != nil {
+		t.Fatalf("a rejected batch must not bind its other actors: %v", err)
+	}
+}
+
+func testServerActorIsStable(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	actor, err := store.ServerActor(ctx, "user-1", "server-1")
+	if err != nil || actor != "server-1" {
+		t.Fatalf("first server actor: %q (%v)", actor, err)
+	}
+	if actor, err = store.ServerActor(ctx, "user-1", "server-2"); err != nil || actor != "server-1" {
+		t.Fatalf("expected the stored server actor, got %q (%v)", actor, err)
+	}
+	if actor, err = store.ServerActor(ctx, "user-2", "server-3"); err != nil || actor != "server-3" {
+		t.Fatalf("expected a server actor per user, got %q (%v)", actor, err)
+	}
+	if err := store.ReplaceSnapshot(ctx, "user-1", storage.Snapshot{DatasetGenerationKey: "gen-reset"}); err != nil {
+		t.Fatalf("replace snapshot: %v", err)
+	}
+	if actor, err = store.ServerActor(ctx, "user-1", "server-4"); err != nil || actor != "server-1" {
+		t.Fatalf("expected the server actor to survive a reset, got %q (%v)", actor, err)
+	}
+}
+
+func testActorAttribution(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	if err := store.BindActors(ctx, "user-1", "client-1", []string{"actor-1"}); err != nil {
+		t.Fatalf("bind user-1 actor: %v", err)
+	}
+	if err := store.BindActors(ctx, "user-2", "client-2", []string{"actor-2"}); err != nil {
+		t.Fatalf("bind user-2 actor: %v", err)
+	}
+	if err := store.BindActors(ctx, "user-3", "client-3", []string{"actor-3"}); err != nil {
+		t.Fatalf("bind user-3 actor: %v", err)
+	}
+	for userID, name := range map[string]string{"user-1": "Alice", "user-2": "Bob"} {
+		profile := storage.UserProfile{Email: userID + "@example.com", DisplayName: name, AvatarURL: "https://example.com/" + userID + ".png", Locale: "en"}
+		if err := store.UpdateUserProfile(ctx, userID, profile); err != nil {
+			t.Fatalf("update profile: %v", err)
+		}
+	}
+	profile, err := store.GetUserProfile(ctx, "user-2")
+	if err != nil {
+		t.Fatalf("get profile: %v", err)
+	}
+	if profile.Email != "user-2@example.com" || profile.DisplayName != "Bob" || profile.Locale != "en" {
+		t.Fatalf("unexpected profile: %+v", profile)
+	}
+	if empty, err := store.GetUserProfile(ctx, "user-3"); err != nil || empty != (storage.UserProfile{}) {
+		t.Fatalf("expected empty profile, got %+v (%v)", empty, err)
+	}
+	attribution, err := store.GetActorAttribution(ctx, "user-1", []string{"actor-1", "actor-2", "actor-3", "unknown"})
+	if err != nil {
+		t.Fatalf("actor attribution: %v", err)
+	}
+	want := storage.UserProfile{DisplayName: "Bob", AvatarURL: "https://example.com/user-2.png"}
+	if len(attribution) != 1 || attribution["actor-2"] != want {
+		t.Fatalf("unexpected attribution: %+v", attribution)
+	}
+}
+
+func testDigestSettings(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	settings, err := store.GetDigestSettings(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("get digest settings: %v", err)
+	}
+	if settings.Frequency != "" {
+		t.Fatalf("expected digests to be opt-in, got %+v", settings)
+	}
+	if err := store.SetDigestFrequency(ctx, "user-2", storage.DigestWeekly); err != nil {
+		t.Fatalf("set digest frequency: %v", err)
+	}
+	if err := store.SetDigestFrequency(ctx, "user-1", storage.DigestDaily); err != nil {
+		t.Fatalf("set digest frequency: %v", err)
+	}
+	if err := store.SetDigestFrequency(ctx, "user-3", ""); err != nil {
+		t.Fatalf("set digest frequency: %v", err)
+	}
+	if err := store.MarkDigestSent(ctx, "user-1", 1000, 7); err != nil {
+		t.Fatalf("mark digest sent: %v", err)
+	}
+	// Saving the same frequency again keeps the schedule.
+	if err := store.SetDigestFrequency(ctx, "user-1", storage.DigestDaily); err != nil {
+		t.Fatalf("set digest frequency: %v", err)
+	}
+	subscriptions, err := store.ListDigestSubscriptions(ctx)
+	if err != nil {
+		t.Fatalf("list digest subscriptions: %v", err)
+	}
+	want := []storage.DigestSettings{
+		{UserID: "user-1", Frequency: storage.DigestDaily, LastSentAt: 1000, LastServerSeq: 7},
+		{UserID: "user-2", Frequency: storage.DigestWeekly},
+	}
+	if len(subscriptions) != len(want) {
+		t.Fatalf("expected %d subscriptions, got %+v", len(want), subscriptions)
+	}
+	for i := range want {
+		if subscriptions[i] != want[i] {
+			t.Fatalf("subscription %d: expected %+v, got %+v", i, want[i], subscriptions[i])
+		}
+	}
+
+	if err := store.SetDigestFrequency(ctx, "user-1", storage.DigestWeekly); err != nil {
+		t.Fatalf("set digest frequency: %v", err)
+	}
+	settings, err = store.GetDigestSettings(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("get digest settings: %v", err)
+	}
+	if settings != (storage.DigestSettings{UserID: "user-1", Frequency: storage.DigestWeekly}) {
+		t.Fatalf("expected a frequency change to restart the schedule, got %+v", settings)
+	}
+}
+
+func testUserPreferences(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	profile := func() storage.UserProfile {
+		t.Helper()
+		profile, err := store.GetUserProfile(ctx, "user-1")
+		if err != nil {
+			t.Fatalf("get profile: %v", err)
+		}
+		return profile
+	}
+	claims := storage.UserProfile{Email: "ada@example.com", Locale: "de", TimeZone: "Europe/Berlin"}
+	if err := store.UpdateUserProfile(ctx, "user-1", claims); err != nil {
+		t.Fatalf("update profile: %v", err)
+	}
+	if got := profile(); got.Locale != "de" || got.TimeZone != "Europe/Berlin" {
+		t.Fatalf("expected the claims to set unset preferences, got %+v", got)
+	}
+	if err := store.SetTimeZone(ctx, "user-1", "America/New_York"); err != nil {
+		t.Fatalf("set time zone: %v", err)
+	}
+	if err := store.SetLocale(ctx, "user-1", "en-US"); err != nil {
+		t.Fatalf("set locale: %v", err)
+	}
+	claims.Email = "ada@example.org"
+	if err := store.UpdateUserProfile(ctx, "user-1", claims); err != nil {
+		t.Fatalf("update profile: %v", err)
+	}
+	if got := profile(); got.Email != "ada@example.org" || got.Locale != "en-US" || got.TimeZone != "America/New_York" {
+		t.Fatalf("expected a later login to keep the chosen preferences, got %+v", got)
+	}
+	if err := store.SetDigestFrequency(ctx, "user-1", storage.DigestDaily); err != nil {
+		t.Fatalf("set digest frequency: %v", err)
+	}
+	subscriptions, err := store.ListDigestSubscriptions(ctx)
+	if err != nil {
+		t.Fatalf("list digest subscriptions: %v", err)
+	}
+	if len(subscriptions) != 1 || subscriptions[0].TimeZone != "America/New_York" {
+		t.Fatalf("expected subscriptions to carry the time zone, got %+v", subscriptions)
+	}
+	if err := store.SetTimeZone(ctx, "user-1", ""); err != nil {
+		t.Fatalf("clear time zone: %v", err)
+	}
+	if err := store.SetLocale(ctx, "user-1", ""); err != nil {
+		t.Fatalf("clear locale: %v", err)
+	}
+	if got := profile(); got.Locale != "" || got.TimeZone != "" {
+		t.Fatalf("expected the preferences to be cleared, got %+v", got)
+	}
+}
+
+func testMigrateUserID(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	insertOps(t, store, "sub-1", listOp(1, `{}`))
+	if err := store.BindActors(ctx, "sub-1", "client-1", []string{"actor-1"}); err != nil {
+		t.Fatalf("bind actor: %v", err)
+	}
+	if err := store.MigrateUserID(ctx, "sub-1", "alice@example.com"); err != nil {
+		t.Fatalf("migrate user id: %v", err)
+	}
+	if ops, _ := getOps(t, store, "alice@example.com", 0); len(ops) != 1 {
+		t.Fatalf("expected migrated op, got %d", len(ops))
+	}
+	if ops, _ := getOps(t, store, "sub-1", 0); len(ops) != 0 {
+		t.Fatalf("old user id should start empty, got %d ops", len(ops))
+	}
+	if err := store.BindActors(ctx, "alice@example.com", "client-1", []string{"actor-1"}); err != nil {
+		t.Fatalf("actor should follow the migrated user: %v", err)
+	}
+	// The target now exists, so repeating the migration must not touch it.
+	if err := store.MigrateUserID(ctx, "sub-1", "alice@example.com"); err != nil {
+		t.Fatalf("repeat migration: %v", err)
+	}
+	if ops, _ := getOps(t, store, "alice@example.com", 0); len(ops) != 1 {
+		t.Fatalf("repeat migration changed data: %d ops", len(ops))
+	}
+	if err := store.MigrateUserID(ctx, "unknown", "bob@example.com"); err != nil {
+		t.Fatalf("migrating an unknown user should be a no-op: %v", err)
+	}
+}
+
+func testPasskeys(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	if passkeys, err := store.ListPasskeys(ctx, "alice"); err != nil || len(passkeys) != 0 {
+		t.Fatalf("expected no passkeys, got %v (%v)", passkeys, err)
+	}
+	if err := store.AddPasskey(ctx, "alice", storage.Passkey{CredentialID: []byte("cred-1"), Data: []byte("v1")}); err != nil {
+		t.Fatalf("add passkey: %v", err)
+	}
+	if err := store.AddPasskey(ctx, "bob", storage.Passkey{CredentialID: []byte("cred-1"), Data: []byte("other")}); err == nil {
+		t.Fatalf("expected duplicate credential id to be rejected")
+	}
+	if err := store.UpdatePasskey(ctx, "alice", storage.Passkey{CredentialID: []byte("cred-1"), Data: []byte("v2")}); err != nil {
+		t.Fatalf("update passkey: %v", err)
+	}
+	if err := store.UpdatePasskey(ctx, "bob", storage.Passkey{CredentialID: []byte("cred-1"), Data: []byte("stolen")}); err == nil {
+		t.Fatalf("expected update of another user's passkey to fail")
+	}
+	passkeys, err := store.ListPasskeys(ctx, "alice")
+	if err != nil {
+		t.Fatalf("list passkeys: %v", err)
+	}
+	if len(passkeys) != 1 || string(passkeys[0].Data) != "v2" {
+		t.Fatalf("unexpected passkeys: %+v", passkeys)
+	}
+	if count, err := store.CountPasskeys(ctx); err != nil || count != 1 {
+		t.Fatalf("expected 1 passkey, got %d (%v)", count, err)
+	}
+}
+
+func testTOTPFactors(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	if _, err := store.GetTOTPFactor(ctx, "alice"); !errors.Is(err, storage.ErrTOTPFactorNotFound) {
+		t.Fatalf("expected ErrTOTPFactorNotFound, got %v", err)
+	}
+	if accepted, err := store.AcceptTOTPStep(ctx, "alice", 1); err != nil || accepted {
+		t.Fatalf("expected no step accepted without a factor: %v %v", accepted, err)
+	}
+	factor := storage.TOTPFactor{Secret: "JBSWY3DPEHPK3PXP", Confirmed: true, RecoveryCodeHashes: []string{"hash-a", "hash-b"}}
+	if err := store.SetTOTPFactor(ctx, "alice", factor); err != nil {
+		t.Fatalf("set totp factor: %v", err)
+	}
+	got, err := store.GetTOTPFactor(ctx, "alice")
+	if err != nil || got.Secret != factor.Secret || !got.Confirmed || len(got.RecoveryCodeHashes) != 2 {
+		t.Fatalf("unexpected factor: %+v (%v)", got, err)
+	}
+
+	if accepted, err := store.AcceptTOTPStep(ctx, "alice", 100); err != nil || !accepted {
+		t.Fatalf("expected step 100 to be accepted: %v %v", accepted, err)
+	}
+	for _, step := range []int64{100, 99} {
+		if accepted, err := store.AcceptTOTPStep(ctx, "alice", step); err != nil || accepted {
+			t.Fatalf("expected step %d to be refused after 100: %v %v", step, accepted, err)
+		}
+	}
+	if used, err := store.UseTOTPRecoveryCode(ctx, "alice", "hash-a"); err != nil || !used {
+		t.Fatalf("expected the recovery code to work once: %v %v", used, err)
+	}
+	if used, err := store.UseTOTPRecoveryCode(ctx, "alice", "hash-a"); err != nil || used {
+		t.Fatalf("expected a used recovery code to be refused: %v %v", used, err)
+	}
+	if used, err := store.UseTOTPRecoveryCode(ctx, "bob", "hash-b"); err != nil || used {
+		t.Fatalf("expected another user's recovery code to be refused: %v %v", used, err)
+	}
+	got, err = store.GetTOTPFactor(ctx, "alice")
+	if err != nil || got.LastStep != 100 || len(got.RecoveryCodeHashes) != 1 || got.RecoveryCodeHashes[0] != "hash-b" {
+		t.Fatalf("unexpected factor after use: %+v (%v)", got, err)
+	}
+
+	if err := store.DeleteTOTPFactor(ctx, "alice"); err != nil {
+		t.Fatalf("delete totp factor: %v", err)
+	}
+	if _, err := store.GetTOTPFactor(ctx, "alice"); !errors.Is(err, storage.ErrTOTPFactorNotFound) {
+		t.Fatalf("expected the factor to be gone, got %v", err)
+	}
+	if used, err := store.UseTOTPRecoveryCode(ctx, "alice", "hash-b"); err != nil || used {
+		t.Fatalf("expected recovery codes to go with the factor: %v %v", used, err)
+	}
+}
+
+func testTagIndexFollowsOps(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	insertOps(t, store, "user-1",
+		listOp(1, `{"type":"insert","itemId":"item-1","payload":{"data":{"text":"milk"}}}`),
+		listOp(2, `{"type":"addTag","itemId":"item-1","payload":{"tag":"#Groceries"}}`),
+		listOp(3, `{"type":"insert","itemId":"item-2","payload":{"data":{"text":"eggs"}}}`),
+		listOp(4, `{"type":"addTag","itemId":"item-2","payload":{"tag":"groceries"}}`),
+		listOp(5, `{"type":"addTag","itemId":"item-2","payload":{"tag":"fridge"}}`),
+	)
+	tags, err := store.ListTags(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("list tags: %v", err)
+	}
+	if len(tags) != 2 || tags[0].Tag != "fridge" || tags[1].Tag != "groceries" || tags[1].Count != 2 {
+		t.Fatalf("unexpected tags: %+v", tags)
+	}
+	insertOps(t, store, "user-1",
+		listOp(6, `{"type":"remove","itemId":"item-2"}`),
+		listOp(7, `{"type":"removeTag","itemId":"item-1","payload":{"tag":"missing"}}`),
+	)
+	items, err := store.ListTaggedItems(ctx, "user-1", "Groceries")
+	if err != nil {
+		t.Fatalf("list tagged items: %v", err)
+	}
+	if len(items) != 1 || items[0].ItemID != "item-1" || items[0].ListID != "list-1" {
+		t.Fatalf("unexpected tagged items: %+v", items)
+	}
+	insertOps(t, store, "user-1", storage.Op{Scope: "registry", Resource: "registry", Actor: "actor-1", Clock: 8, Payload: []byte(`{"type":"removeList","listId":"list-1"}`)})
+	tags, err = store.ListTags(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("list tags: %v", err)
+	}
+	if len(tags) != 0 {
+		t.Fatalf("removing the list should drop its tags: %+v", tags)
+	}
+}
+
+func testTagIndexSeededFromSnapshot(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	insertOps(t, store, "user-1", listOp(1, `{"type":"addTag","itemId":"item-9","payload":{"tag":"old"}}`))
+	if err := store.ReplaceSnapshot(ctx, "user-1", storage.Snapshot{
+		DatasetGenerationKey: "dataset-new",
+		Blob:                 `{"schema":"net.aggregat4.tasklist.snapshot@v1","data":{"lists":[{"listId":"list-1","title":"Inbox","items":[{"id":"item-1","text":"milk","done":false,"tags":["shop"]}]}]}}`,
+	}); err != nil {
+		t.Fatalf("replace snapshot: %v", err)
+	}
+	tags, err := store.ListTags(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("list tags: %v", err)
+	}
+	if len(tags) != 1 || tags[0].Tag != "shop" {
+		t.Fatalf("unexpected tags: %+v", tags)
+	}
+}
+
+func testListTemplates(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	for _, listID := range []string{"list-2", "list-1", "list-2"} {
+		if err := store.SetListTemplate(ctx, "user-1", listID, true); err != nil {
+			t.Fatalf("mark template: %v", err)
+		}
+	}
+	if err := store.SetListTemplate(ctx, "user-1", "list-3", false); err != nil {
+		t.Fatalf("unmark unknown template: %v", err)
+	}
+	templates, err := store.ListTemplates(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("list templates: %v", err)
+	}
+	if !slices.Equal(templates, []string{"list-2", "list-1"}) {
+		t.Fatalf("expected templates in marking order, got %v", templates)
+	}
+	if err := store.ReplaceSnapshot(ctx, "user-1", storage.Snapshot{DatasetGenerationKey: "gen-templates", Blob: emptySnapshot}); err != nil {
+		t.Fatalf("replace snapshot: %v", err)
+	}
+	if err := store.SetListTemplate(ctx, "user-1", "list-2", false); err != nil {
+		t.Fatalf("unmark template: %v", err)
+	}
+	templates, err = store.ListTemplates(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("list templates: %v", err)
+	}
+	if !slices.Equal(templates, []string{"list-1"}) {
+		t.Fatalf("expected list-1 to remain a template, got %v", templates)
+	}
+	if templates, err := store.ListTemplates(ctx, "user-2"); err != nil || len(templates) != 0 {
+		t.Fatalf("expected no templates for user-2, got %v (%v)", templates, err)
+	}
+}
+
+func testArchivedLists(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	for _, listID := range []string{"list-1", "list-2", "list-1"} {
+		if err := store.SetListArchived(ctx, "user-1", listID, true); err != nil {
+			t.Fatalf("archive list: %v", err)
+		}
+	}
+	if err := store.SetListArchived(ctx, "user-1", "list-1", false); err != nil {
+		t.Fatalf("restore list: %v", err)
+	}
+	archived, err := store.ListArchivedLists(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("list archived lists: %v", err)
+	}
+	if !slices.Equal(archived, []string{"list-2"}) {
+		t.Fatalf("expected only list-2 to stay archived, got %v", archived)
+	}
+	if archived, err := store.ListArchivedLists(ctx, "user-2"); err != nil || len(archived) != 0 {
+		t.Fatalf("expected no archived lists for user-2, got %v (%v)", archived, err)
+	}
+}
+
+func testMutedLists(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	for _, listID := range []string{"list-1", "list-2", "list-3", "list-1"} {
+		if err := store.SetListMuted(ctx, "user-1", listID, true); err != nil {
+			t.Fatalf("mute list: %v", err)
+		}
+	}
+	if err := store.SetListMuted(ctx, "user-1", "list-2", false); err != nil {
+		t.Fatalf("unmute list: %v", err)
+	}
+	muted, err := store.ListMutedLists(ctx, "user-1")
+	if err != nil || !slices.Equal(muted, []string{"list-1", "list-3"}) {
+		t.Fatalf("expected list-1 and list-3 to stay muted, got %v (%v)", muted, err)
+	}
+	if muted, err := store.ListMutedLists(ctx, "user-2"); err != nil || len(muted) != 0 {
+		t.Fatalf("expected no muted lists for user-2, got %v (%v)", muted, err)
+	}
+}
+
+func testAPITokens(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	token := storage.APIToken{ID: "token-1", Name: "Kitchen speaker", Scopes: []string{storage.TokenScopeRead, storage.TokenScopeAdd}, CreatedAt: 100}
+	if err := store.CreateAPIToken(ctx, "user-1", token, "hash-1", ""); err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+	used, err := store.UseAPIToken(ctx, "hash-1", 200)
+	if err != nil {
+		t.Fatalf("use token: %v", err)
+	}
+	if used.ID != "token-1" || used.UserID != "user-1" || !slices.Equal(used.Scopes, token.Scopes) || used.LastUsedAt != 200 {
+		t.Fatalf("unexpected token: %+v", used)
+	}
+	if _, err := store.UseAPIToken(ctx, "hash-9", 200); !errors.Is(err, storage.ErrAPITokenNotFound) {
+		t.Fatalf("expected ErrAPITokenNotFound for unknown secret, got %v", err)
+	}
+	tokens, err := store.ListAPITokens(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("list tokens: %v", err)
+	}
+	if len(tokens) != 1 || tokens[0].Name != "Kitchen speaker" || tokens[0].LastUsedAt != 200 {
+		t.Fatalf("unexpected tokens: %+v", tokens)
+	}
+	expiring := storage.APIToken{ID: "token-2", Name: "Shortcut", Scopes: []string{storage.TokenScopeQuickAdd}, ListID: "list-1", CreatedAt: 100, ExpiresAt: 250}
+	if err := store.CreateAPIToken(ctx, "user-1", expiring, "hash-2", ""); err != nil {
+		t.Fatalf("create expiring token: %v", err)
+	}
+	if used, err := store.UseAPIToken(ctx, "hash-2", 249); err != nil || used.ListID != "list-1" || used.ExpiresAt != 250 {
+		t.Fatalf("unexpected expiring token: %+v (%v)", used, err)
+	}
+	if _, err := store.UseAPIToken(ctx, "hash-2", 250); !errors.Is(err, storage.ErrAPITokenNotFound) {
+		t.Fatalf("expected expired token to be rejected, got %v", err)
+	}
+	if err := store.RevokeAPIToken(ctx, "user-2", "token-1"); !errors.Is(err, storage.ErrAPITokenNotFound) {
+		t.Fatalf("expected another user's revoke to fail, got %v", err)
+	}
+	if err := store.RevokeAPIToken(ctx, "user-1", "token-1"); err != nil {
+		t.Fatalf("revoke token: %v", err)
+	}
+	if _, err := store.UseAPIToken(ctx, "hash-1", 300); !errors.Is(err, storage.ErrAPITokenNotFound) {
+		t.Fatalf("expected revoked token to be rejected, got %v", err)
+	}
+
+	signed := storage.APIToken{ID: "token-3", Name: "Mail bridge", Scopes: []string{storage.TokenScopeAdd}, CreatedAt: 100, ExpiresAt: 500, Signed: true}
+	if err := store.CreateAPIToken(ctx, "user-1", signed, "hash-3", "key-3"); err != nil {
+		t.Fatalf("create signed token: %v", err)
+	}
+	found, signingKey, err := store.SignedAPIToken(ctx, "token-3", 300)
+	if err != nil || found.UserID != "user-1" || !found.Signed || signingKey != "key-3" || found.LastUsedAt != 0 {
+		t.Fatalf("unexpected signed token: %+v %q (%v)", found, signingKey, err)
+	}
+	if _, _, err := store.SignedAPIToken(ctx, "token-2", 200); !errors.Is(err, storage.ErrAPITokenNotFound) {
+		t.Fatalf("expected a bearer token not to be found as signed, got %v", err)
+	}
+	if _, _, err := store.SignedAPIToken(ctx, "token-3", 500); !errors.Is(err, storage.ErrAPITokenNotFound) {
+		t.Fatalf("expected an expired signed token to be rejected, got %v", err)
+	}
+	if used, err := store.UseAPIToken(ctx, "hash-3", 300); err != nil || !used.Signed {
+		t.Fatalf("expected the signed flag on use, got %+v (%v)", used, err)
+	}
+	if used, err := store.UseSignedAPIToken(ctx, "token-3", 400); err != nil || used.UserID != "user-1" || used.LastUsedAt != 400 {
+		t.Fatalf("unexpected signed token use: %+v (%v)", used, err)
+	}
+	if _, err := store.UseSignedAPIToken(ctx, "token-2", 200); !errors.Is(err, storage.ErrAPITokenNotFound) {
+		t.Fatalf("expected a bearer token not to be used as signed, got %v", err)
+	}
+	if _, err := store.UseSignedAPIToken(ctx, "token-3", 500); !errors.Is(err, storage.ErrAPITokenNotFound) {
+		t.Fatalf("expected an expired signed token not to be used, got %v", err)
+	}
+}
+
+func testHouseholdMembers(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	for _, member := range []storage.HouseholdMember{
+		{ID: "member-1", Name: "Sam", ListIDs: []string{"list-1", "list-2"}, CreatedAt: 100},
+		{ID: "member-2", Name: "Kim", ListIDs: []string{}, CreatedAt: 200},
+	} {
+		if err := store.CreateHouseholdMember(ctx, "user-1", member); err != nil {
+			t.Fatalf("create member: %v", err)
+		}
+	}
+	members, err := store.ListHouseholdMembers(ctx, "user-1")
+	if err != nil || len(members) != 2 || members[0].Name != "Sam" || !slices.Equal(members[0].ListIDs, []string{"list-1", "list-2"}) || len(members[1].ListIDs) != 0 {
+		t.Fatalf("unexpected members: %+v (%v)", members, err)
+	}
+	if _, err := store.GetHouseholdMember(ctx, "user-2", "member-1"); !errors.Is(err, storage.ErrHouseholdMemberNotFound) {
+		t.Fatalf("expected another user's member to be hidden, got %v", err)
+	}
+	if err := store.UpdateHouseholdMember(ctx, "user-1", storage.HouseholdMember{ID: "member-1", Name: "Sammy", ListIDs: []string{"list-2"}}); err != nil {
+		t.Fatalf("update member: %v", err)
+	}
+	member, err := store.GetHouseholdMember(ctx, "user-1", "member-1")
+	if err != nil || member.Name != "Sammy" || !slices.Equal(member.ListIDs, []string{"list-2"}) || member.CreatedAt != 100 {
+		t.Fatalf("unexpected member: %+v (%v)", member, err)
+	}
+	if err := store.UpdateHouseholdMember(ctx, "user-2", storage.HouseholdMember{ID: "member-1", Name: "Eve"}); !errors.Is(err, storage.ErrHouseholdMemberNotFound) {
+		t.Fatalf("expected another user's update to fail, got %v", err)
+	}
+
+	// A member's tokens carry its id and go away with it.
+	for i, memberID := range []string{"member-1", "member-2", ""} {
+		token := storage.APIToken{ID: fmt.Sprintf("token-%d", i), Name: "Tablet", Scopes: []string{storage.TokenScopeRead}, CreatedAt: 300, MemberID: memberID}
+		if err := store.CreateAPIToken(ctx, "user-1", token, fmt.Sprintf("hash-%d", i), ""); err != nil {
+			t.Fatalf("create token: %v", err)
+		}
+	}
+	if used, err := store.UseAPIToken(ctx, "hash-0", 400); err != nil || used.MemberID != "member-1" {
+		t.Fatalf("expected the member on use, got %+v (%v)", used, err)
+	}
+	if err := store.DeleteHouseholdMember(ctx, "user-1", "member-1"); err != nil {
+		t.Fatalf("delete member: %v", err)
+	}
+	if err := store.DeleteHouseholdMember(ctx, "user-1", "member-1"); !errors.Is(err, storage.ErrHouseholdMemberNotFound) {
+		t.Fatalf("expected a deleted member to be gone, got %v", err)
+	}
+	tokens, err := store.ListAPITokens(ctx, "user-1")
+	if err != nil || len(tokens) != 2 || tokens[0].MemberID != "member-2" || tokens[1].MemberID != "" {
+		t.Fatalf("expected only the deleted member's tokens to be revoked, got %+v (%v)", tokens, err)
+	}
+}
+
+func testExportSchedules(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	schedules := []struct {
+		userID   string
+		schedule storage.ExportSchedule
+	}{
+		{"user-2", storage.ExportSchedule{ID: "export-3", Format: "json", Cadence: "weekly", Destination: "s3", Target: "s3://bucket/lists", SealedConfig: []byte("sealed-3"), CreatedAt: 300}},
+		{"user-1", storage.ExportSchedule{ID: "export-1", Format: "markdown", Cadence: "daily", Destination: "webdav", Target: "https://dav.example/lists", SealedConfig: []byte("sealed-1"), CreatedAt: 100}},
+		{"user-1", storage.ExportSchedule{ID: "export-2", Format: "json", Cadence: "hourly", Destination: "git", Target: "https://git.example/lists.git", SealedConfig: []byte("sealed-2"), CreatedAt: 200}},
+	}
+	for _, tc := range schedules {
+		if err := store.CreateExportSchedule(ctx, tc.userID, tc.schedule); err != nil {
+			t.Fatalf("create export schedule %s: %v", tc.schedule.ID, err)
+		}
+	}
+	if err := store.MarkExportRun(ctx, "user-1", "export-1", 1000, "upload failed"); err != nil {
+		t.Fatalf("mark export run: %v", err)
+	}
+	if err := store.MarkExportRun(ctx, "user-2", "export-1", 1000, ""); !errors.Is(err, storage.ErrExportScheduleNotFound) {
+		t.Fatalf("expected another user's schedule to be unknown, got %v", err)
+	}
+	own, err := store.ListExportSchedules(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("list export schedules: %v", err)
+	}
+	if len(own) != 2 || own[0].ID != "export-1" || own[1].ID != "export-2" {
+		t.Fatalf("expected user-1's schedules in creation order, got %+v", own)
+	}
+	if own[0].UserID != "user-1" || string(own[0].SealedConfig) != "sealed-1" || own[0].Target != "https://dav.example/lists" ||
+		own[0].LastRunAt != 1000 || own[0].LastError != "upload failed" {
+		t.Fatalf("unexpected schedule: %+v", own[0])
+	}
+	all, err := store.ListAllExportSchedules(ctx)
+	if err != nil {
+		t.Fatalf("list all export schedules: %v", err)
+	}
+	var ids []string
+	for _, schedule := range all {
+		ids = append(ids, schedule.UserID+"/"+schedule.ID)
+	}
+	if !slices.Equal(ids, []string{"user-1/export-1", "user-1/export-2", "user-2/export-3"}) {
+		t.Fatalf("unexpected schedules across users: %v", ids)
+	}
+	// A successful run clears the last error.
+	if err := store.MarkExportRun(ctx, "user-1", "export-1", 2000, ""); err != nil {
+		t.Fatalf("mark export run: %v", err)
+	}
+	if err := store.DeleteExportSchedule(ctx, "user-2", "export-2"); !errors.Is(err, storage.ErrExportScheduleNotFound) {
+		t.Fatalf("expected another user's delete to fail, got %v", err)
+	}
+	if err := store.DeleteExportSchedule(ctx, "user-1", "export-2"); err != nil {
+		t.Fatalf("delete export schedule: %v", err)
+	}
+	own, err = store.ListExportSchedules(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("list export schedules: %v", err)
+	}
+	if len(own) != 1 || own[0].LastRunAt != 2000 || own[0].LastError != "" {
+		t.Fatalf("unexpected schedules after delete: %+v", own)
+	}
+}
+
+func testOAuthApps(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	public := storage.OAuthApp{ID: "app-1", Name: "Planner", RedirectURIs: []string{"https://planner.example/cb", "planner://cb"}, Scopes: []string{storage.TokenScopeRead}, CreatedAt: 100}
+	confidential := storage.OAuthApp{ID: "app-2", Name: "Sync bridge", RedirectURIs: []string{"https://bridge.example/cb"}, Scopes: []string{storage.TokenScopeRead, storage.TokenScopeAdd}, SecretHash: "hash-1", CreatedAt: 200}
+	for _, app := range []storage.OAuthApp{public, confidential} {
+		if err := store.CreateOAuthApp(ctx, app); err != nil {
+			t.Fatalf("create app %s: %v", app.ID, err)
+		}
+	}
+	got, err := store.GetOAuthApp(ctx, "app-1")
+	if err != nil {
+		t.Fatalf("get app: %v", err)
+	}
+	if got.Name != "Planner" || !slices.Equal(got.RedirectURIs, public.RedirectURIs) || !slices.Equal(got.Scopes, public.Scopes) || got.SecretHash != "" {
+		t.Fatalf("unexpected app: %+v", got)
+	}
+	if got, err := store.GetOAuthApp(ctx, "app-2"); err != nil || got.SecretHash != "hash-1" {
+		t.Fatalf("expected the confidential app's secret hash, got %+v (%v)", got, err)
+	}
+	apps, err := store.ListOAuthApps(ctx)
+	if err != nil {
+		t.Fatalf("list apps: %v", err)
+	}
+	if len(apps) != 2 || apps[0].ID != "app-1" || apps[1].ID != "app-2" {
+		t.Fatalf("unexpected apps: %+v", apps)
+	}
+	if err := store.DeleteOAuthApp(ctx, "app-1"); err != nil {
+		t.Fatalf("delete app: %v", err)
+	}
+	if _, err := store.GetOAuthApp(ctx, "app-1"); !errors.Is(err, storage.ErrOAuthAppNotFound) {
+		t.Fatalf("expected ErrOAuthAppNotFound after delete, got %v", err)
+	}
+	if err := store.DeleteOAuthApp(ctx, "app-1"); !errors.Is(err, storage.ErrOAuthAppNotFound) {
+		t.Fatalf("expected a second delete to fail, got %v", err)
+	}
+}
+
+func testUserExists(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	if exists, err := store.UserExists(ctx, "user-1"); err != nil || exists {
+		t.Fatalf("expected an unknown user, got %t (%v)", exists, err)
+	}
+	// Checking does not create the user.
+	if exists, err := store.UserExists(ctx, "user-1"); err != nil || exists {
+		t.Fatalf("expected the user to stay unknown, got %t (%v)", exists, err)
+	}
+	if err := store.UpdateUserProfile(ctx, "user-1", storage.UserProfile{Email: "ada@example.com"}); err != nil {
+		t.Fatalf("update profile: %v", err)
+	}
+	insertOps(t, store, "user-2", listOp(1, `{"type":"insert","itemId":"item-1"}`))
+	for _, userID := range []string{"user-1", "user-2"} {
+		if exists, err := store.UserExists(ctx, userID); err != nil || !exists {
+			t.Fatalf("expected %s to exist, got %t (%v)", userID, exists, err)
+		}
+	}
+}
+
+func testInvites(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	once := storage.Invite{ID: "invite-1", Note: "Ada", MaxUses: 1, CreatedAt: 100}
+	team := storage.Invite{ID: "invite-2", Note: "Team", MaxUses: 2, CreatedAt: 200, ExpiresAt: 1000}
+	for hash, invite := range map[string]storage.Invite{"hash-1": once, "hash-2": team} {
+		if err := store.CreateInvite(ctx, invite, hash); err != nil {
+			t.Fatalf("create invite %s: %v", invite.ID, err)
+		}
+	}
+	redeemed, err := store.RedeemInvite(ctx, "hash-1", 300)
+	if err != nil || redeemed.ID != "invite-1" || redeemed.Uses != 1 || redeemed.LastUsedAt != 300 {
+		t.Fatalf("unexpected redeemed invite %+v (%v)", redeemed, err)
+	}
+	if _, err := store.RedeemInvite(ctx, "hash-1", 301); !errors.Is(err, storage.ErrInviteNotFound) {
+		t.Fatalf("expected a used-up invite to be refused, got %v", err)
+	}
+	if _, err := store.RedeemInvite(ctx, "hash-2", 999); err != nil {
+		t.Fatalf("redeem team invite: %v", err)
+	}
+	if _, err := store.RedeemInvite(ctx, "hash-2", 1000); !errors.Is(err, storage.ErrInviteNotFound) {
+		t.Fatalf("expected an expired invite to be refused, got %v", err)
+	}
+	if _, err := store.RedeemInvite(ctx, "hash-3", 300); !errors.Is(err, storage.ErrInviteNotFound) {
+		t.Fatalf("expected an unknown code to be refused, got %v", err)
+	}
+	invites, err := store.ListInvites(ctx)
+	if err != nil {
+		t.Fatalf("list invites: %v", err)
+	}
+	if len(invites) != 2 || invites[0].ID != "invite-1" || invites[1].ID != "invite-2" || invites[1].Uses != 1 || invites[1].ExpiresAt != 1000 || invites[1].Note != "Team" {
+		t.Fatalf("unexpected invites: %+v", invites)
+	}
+	if err := store.DeleteInvite(ctx, "invite-2"); err != nil {
+		t.Fatalf("delete invite: %v", err)
+	}
+	if err := store.DeleteInvite(ctx, "invite-2"); !errors.Is(err, storage.ErrInviteNotFound) {
+		t.Fatalf("expected a second delete to fail, got %v", err)
+	}
+}
+
+func testSettings(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	for _, setting := range []storage.Setting{
+		{Key: "SERVER_FEATURES", Value: "beta", UpdatedAt: 100, UpdatedBy: "ops"},
+		{Key: "SERVER_BACKUP_KEEP", Value: "7", UpdatedAt: 200, UpdatedBy: "ops"},
+		{Key: "SERVER_FEATURES", Value: "beta,share", UpdatedAt: 300, UpdatedBy: "ann"},
+	} {
+		if err := store.PutSetting(ctx, setting); err != nil {
+			t.Fatalf("put setting %s: %v", setting.Key, err)
+		}
+	}
+	settings, err := store.ListSettings(ctx)
+	if err != nil {
+		t.Fatalf("list settings: %v", err)
+	}
+	if len(settings) != 2 || settings[0] != (storage.Setting{Key: "SERVER_BACKUP_KEEP", Value: "7", UpdatedAt: 200, UpdatedBy: "ops"}) || settings[1].Value != "beta,share" || settings[1].UpdatedBy != "ann" {
+		t.Fatalf("unexpected settings: %+v", settings)
+	}
+	if err := store.DeleteSetting(ctx, "SERVER_BACKUP_KEEP", "ops", 400); err != nil {
+		t.Fatalf("delete setting: %v", err)
+	}
+	if err := store.DeleteSetting(ctx, "SERVER_BACKUP_KEEP", "ops", 500); !errors.Is(err, storage.ErrSettingNotFound) {
+		t.Fatalf("expected ErrSettingNotFound, got %v", err)
+	}
+	changes, err := store.ListSettingChanges(ctx, 0)
+	if err != nil {
+		t.Fatalf("list setting changes: %v", err)
+	}
+	value := func(v *string) string {
+		if v == nil {
+			return "<none>"
+		}
+		return *v
+	}
+	var got []string
+	for _, change := range changes {
+		got = append(got, fmt.Sprintf("%s %s->%s @%d by %s", change.Key, value(change.Previous), value(change.Value), change.ChangedAt, change.ChangedBy))
+	}
+	want := []string{
+		"SERVER_BACKUP_KEEP 7-><none> @400 by ops",
+		"SERVER_FEATURES beta->beta,share @300 by ann",
+		"SERVER_BACKUP_KEEP <none>->7 @200 by ops",
+		"SERVER_FEATURES <none>->beta @100 by ops",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("unexpected setting changes: %q", got)
+	}
+	if latest, err := store.ListSettingChanges(ctx, 1); err != nil || len(latest) != 1 || latest[0].ID != changes[0].ID {
+		t.Fatalf("expected the limit to keep the newest change, got %+v (%v)", latest, err)
+	}
+}
+
+func testQuarantineOps(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	insertOps(t, store, "user-1",
+		listOp(1, `{"type":"insert","itemId":"item-1"}`),
+		listOp(2, `{"type":"update","itemId":42}`),
+		listOp(3, `{"type":"remove","itemId":"item-1"}`),
+	)
+	ops, _, err := store.GetOpsSince(ctx, "user-1", 0)
+	if err != nil {
+		t.Fatalf("get ops: %v", err)
+	}
+	poison := ops[1]
+	if err := store.QuarantineOp(ctx, "user-2", poison.ServerSeq, "wrong user", 100); !errors.Is(err, storage.ErrOpNotFound) {
+		t.Fatalf("expected ErrOpNotFound for another user's op, got %v", err)
+	}
+	if err := store.QuarantineOp(ctx, "user-1", poison.ServerSeq, "decode list payload", 100); err != nil {
+		t.Fatalf("quarantine op: %v", err)
+	}
+	if err := store.QuarantineOp(ctx, "user-1", poison.ServerSeq, "again", 100); !errors.Is(err, storage.ErrOpNotFound) {
+		t.Fatalf("expected ErrOpNotFound for a quarantined op, got %v", err)
+	}
+	remaining, _, err := store.GetOpsSince(ctx, "user-1", 0)
+	if err != nil {
+		t.Fatalf("get ops: %v", err)
+	}
+	if len(remaining) != 2 || remaining[0].ServerSeq != ops[0].ServerSeq || remaining[1].ServerSeq != ops[2].ServerSeq {
+		t.Fatalf("quarantined op must leave the op log: %+v", remaining)
+	}
+
+	quarantined, err := store.ListQuarantinedOps(ctx)
+	if err != nil {
+		t.Fatalf("list quarantined ops: %v", err)
+	}
+	if len(quarantined) != 1 {
+		t.Fatalf("expected one quarantined op, got %+v", quarantined)
+	}
+	got := quarantined[0]
+	if got.UserID != "user-1" || got.ServerSeq != poison.ServerSeq || got.Clock != 2 || string(got.Payload) != string(poison.Payload) || got.Reason != "decode list payload" || got.QuarantinedAt != 100 {
+		t.Fatalf("unexpected quarantined op: %+v", got)
+	}
+	if single, err := store.GetQuarantinedOp(ctx, poison.ServerSeq); err != nil || single.UserID != "user-1" {
+		t.Fatalf("get quarantined op: %+v (%v)", single, err)
+	}
+
+	// Restoring is a plain insert: the op gets a new serverSeq.
+	fixed := got.Op
+	fixed.Payload = []byte(`{"type":"update","itemId":"item-1"}`)
+	insertOps(t, store, "user-1", fixed)
+	if err := store.DeleteQuarantinedOp(ctx, poison.ServerSeq); err != nil {
+		t.Fatalf("delete quarantined op: %v", err)
+	}
+	if _, err := store.GetQuarantinedOp(ctx, poison.ServerSeq); !errors.Is(err, storage.ErrOpNotFound) {
+		t.Fatalf("expected ErrOpNotFound after delete, got %v", err)
+	}
+	if err := store.DeleteQuarantinedOp(ctx, poison.ServerSeq); !errors.Is(err, storage.ErrOpNotFound) {
+		t.Fatalf("expected a second delete to fail, got %v", err)
+	}
+	restored, _, err := store.GetOpsSince(ctx, "user-1", ops[2].ServerSeq)
+	if err != nil {
+		t.Fatalf("get ops: %v", err)
+	}
+	if len(restored) != 1 || restored[0].Clock != 2 || string(restored[0].Payload) != string(fixed.Payload) {
+		t.Fatalf("expected the restored op after the existing ones, got %+v", restored)
+	}
+}
+
+func testPerUserIsolation(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	insertOps(t, store, "user-1", listOp(1, `{"type":"addTag","itemId":"item-1","payload":{"tag":"mine"}}`))
+	ops, _ := getOps(t, store, "user-2", 0)
+	if len(ops) != 0 {
+		t.Fatalf("user-2 sees user-1 ops: %d", len(ops))
+	}
+	tags, err := store.ListTags(ctx, "user-2")
+	if err != nil {
+		t.Fatalf("list tags: %v", err)
+	}
+	if len(tags) != 0 {
+		t.Fatalf("user-2 sees user-1 tags: %+v", tags)
+	}
+	key1, err := store.GetActiveDatasetGenerationKey(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("active key: %v", err)
+	}
+	key2, err := store.GetActiveDatasetGenerationKey(ctx, "user-2")
+	if err != nil {
+		t.Fatalf("active key: %v", err)
+	}
+	if key1 == key2 {
+		t.Fatalf("users share a generation key")
+	}
+	if err := store.ReplaceSnapshot(ctx, "user-2", storage.Snapshot{DatasetGenerationKey: "dataset-2", Blob: emptySnapshot}); err != nil {
+		t.Fatalf("replace snapshot: %v", err)
+	}
+	ops, _ = getOps(t, store, "user-1", 0)
+	if len(ops) != 1 {
+		t.Fatalf("user-2 reset cleared user-1 ops: %d", len(ops))
+	}
+	if _, _, err := store.GetOpsSince(ctx, "", 0); err == nil {
+		t.Fatalf("expected error for empty user id")
+	}
+}
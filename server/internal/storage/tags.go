@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// tagOpPayload is the subset of a CRDT payload the tag index cares about.
+type tagOpPayload struct {
+	Type    string `json:"type"`
+	ListID  string `json:"listId"`
+	ItemID  string `json:"itemId"`
+	Payload struct {
+		Tag string `json:"tag"`
+	} `json:"payload"`
+}
+
+type tagChangeKind int
+
+const (
+	tagChangeNone tagChangeKind = iota
+	tagChangeAdd
+	tagChangeRemove
+	tagChangeDropItem
+	tagChangeDropList
+)
+
+// tagChange is the effect an op has on the tag index.
+type tagChange struct {
+	kind   tagChangeKind
+	listID string
+	itemID string
+	tag    string
+}
+
+// tagChangeForOp interprets an op for the tag index. Payloads that do not
+// decode have no effect; the op itself is still stored verbatim.
+func tagChangeForOp(op Op) tagChange {
+	var payload tagOpPayload
+	if err := json.Unmarshal(op.Payload, &payload); err != nil {
+		return tagChange{}
+	}
+	switch {
+	case op.Scope == "list" && payload.Type == "addTag":
+		tag := NormalizeTag(payload.Payload.Tag)
+		if tag == "" || payload.ItemID == "" {
+			return tagChange{}
+		}
+		return tagChange{kind: tagChangeAdd, listID: op.Resource, itemID: payload.ItemID, tag: tag}
+	case op.Scope == "list" && payload.Type == "removeTag":
+		return tagChange{kind: tagChangeRemove, listID: op.Resource, itemID: payload.ItemID, tag: NormalizeTag(payload.Payload.Tag)}
+	case op.Scope == "list" && payload.Type == "remove":
+		return tagChange{kind: tagChangeDropItem, listID: op.Resource, itemID: payload.ItemID}
+	case op.Scope == "registry" && payload.Type == "removeList":
+		return tagChange{kind: tagChangeDropList, listID: payload.ListID}
+	}
+	return tagChange{}
+}
+
+type snapshotTag struct {
+	listID string
+	itemID string
+	tag    string
+}
+
+// snapshotTags extracts the optional per-item "tags" arrays of an export
+// snapshot. Blobs that are not export snapshots carry no tags.
+func snapshotTags(blob string) []snapshotTag {
+	if strings.TrimSpace(blob) == "" {
+		return nil
+	}
+	var doc struct {
+		Data struct {
+			Lists []struct {
+				ListID string `json:"listId"`
+				Items  []struct {
+					ID   string   `json:"id"`
+					Tags []string `json:"tags"`
+				} `json:"items"`
+			} `json:"lists"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(blob), &doc); err != nil {
+		return nil
+	}
+	tags := make([]snapshotTag, 0)
+	for _, list := range doc.Data.Lists {
+		for _, item := range list.Items {
+			for _, raw := range item.Tags {
+				tag := NormalizeTag(raw)
+				if tag == "" || list.ListID == "" || item.ID == "" {
+					continue
+				}
+				tags = append(tags, snapshotTag{listID: list.ListID, itemID: item.ID, tag: tag})
+			}
+		}
+	}
+	return tags
+}
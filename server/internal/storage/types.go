@@ -3,6 +3,9 @@ package storage
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"slices"
+	"strings"
 )
 
 // Op is the generic sync envelope stored by the server.
@@ -13,12 +16,413 @@ type Op struct {
 	Actor     string          `json:"actor"`
 	Clock     int64           `json:"clock"`
 	Payload   json.RawMessage `json:"payload"`
+	// PayloadEncoding is set on ops delivered with a compressed payload (see
+	// CompressOps); stored and pushed ops never carry it.
+	PayloadEncoding string `json:"payloadEncoding,omitempty"`
+	// ClientID records the client that pushed the op, so pulls can leave out
+	// a client's own ops. It is set by the server, never by the client.
+	ClientID string `json:"-"`
 }
 
+// ValidateOp checks the envelope fields every stored op must carry. The payload
+// stays opaque but must be a JSON value, since it is echoed verbatim to other
+// clients on pull.
+func ValidateOp(op Op) error {
+	if op.Scope == "" || op.Resource == "" || op.Actor == "" || op.Clock <= 0 {
+		return fmt.Errorf("invalid op metadata: scope=%q resource=%q actor=%q clock=%d", op.Scope, op.Resource, op.Actor, op.Clock)
+	}
+	if op.PayloadEncoding != "" {
+		return fmt.Errorf("invalid op payload encoding %q: ops are pushed uncompressed", op.PayloadEncoding)
+	}
+	if len(op.Payload) == 0 || !json.Valid(op.Payload) {
+		return fmt.Errorf("invalid op payload: scope=%q resource=%q actor=%q clock=%d", op.Scope, op.Resource, op.Actor, op.Clock)
+	}
+	return nil
+}
+
+// OutboxEvent records a committed change whose side effects, such as waking
+// the user's streams, still have to run. It is written in the transaction of
+// the change, so a crash can delay side effects but never lose them.
+type OutboxEvent struct {
+	ID     int64  `json:"id"`
+	UserID string `json:"userId"`
+	// Kind is OutboxKindOps or OutboxKindGeneration.
+	Kind                 string `json:"kind"`
+	DatasetGenerationKey string `json:"datasetGenerationKey"`
+	// ServerSeq is the latest serverSeq of the generation after the change.
+	ServerSeq int64 `json:"serverSeq"`
+	CreatedAt int64 `json:"createdAt"`
+}
+
+// Outbox event kinds.
+const (
+	// OutboxKindOps follows InsertOps calls that stored new ops.
+	OutboxKindOps = "ops"
+	// OutboxKindGeneration follows a snapshot replacing the active generation.
+	OutboxKindGeneration = "generation"
+)
+
 type Snapshot struct {
 	DatasetGenerationID  int64  `json:"-"`
 	DatasetGenerationKey string `json:"datasetGenerationKey"`
 	Blob                 string `json:"snapshot"`
+	// ParentDatasetGenerationKey links a generation derived from another one
+	// (such as a compaction) to its parent. Imports leave it empty because
+	// their data does not descend from the previous generation.
+	ParentDatasetGenerationKey string `json:"parentDatasetGenerationKey,omitempty"`
+	// CreatedAt is when the generation became active, in Unix seconds. It is
+	// set by the store and ignored when replacing a snapshot.
+	CreatedAt int64 `json:"-"`
+}
+
+// OpStats summarizes the op log of a user's active dataset generation.
+type OpStats struct {
+	Count        int64 `json:"count"`
+	Bytes        int64 `json:"bytes"`
+	MaxServerSeq int64 `json:"maxServerSeq"`
+}
+
+// UserProfile holds a user's identity provider claims from their latest login.
+type UserProfile struct {
+	Email       string `json:"email,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+	AvatarURL   string `json:"avatarUrl,omitempty"`
+	// Locale is the user's language tag, e.g. de-AT, for server-generated
+	// text.
+	Locale string `json:"locale,omitempty"`
+	// TimeZone is the IANA name of the user's time zone, e.g. Europe/Berlin.
+	// Empty means the server's zone.
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// Attribution returns the parts of the profile that may be shown to other
+// users.
+func (p UserProfile) Attribution() UserProfile {
+	return UserProfile{DisplayName: p.DisplayName, AvatarURL: p.AvatarURL}
+}
+
+// Digest frequencies a user can opt into. The empty frequency means no
+// digests.
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// DigestSettings is a user's email digest preference and delivery progress.
+type DigestSettings struct {
+	UserID    string `json:"-"`
+	Frequency string `json:"frequency"`
+	// LastSentAt is when the last digest went out (unix seconds), 0 before the
+	// first one.
+	LastSentAt int64 `json:"lastSentAt,omitempty"`
+	// LastServerSeq is the serverSeq the last digest covered.
+	LastServerSeq int64 `json:"-"`
+	// TimeZone is the user's time zone (see UserProfile), which decides when
+	// digests are due.
+	TimeZone string `json:"-"`
+}
+
+// ExportSchedule is a user's automatic export of their lists to a destination
+// outside the server.
+type ExportSchedule struct {
+	ID     string `json:"id"`
+	UserID string `json:"-"`
+	// Format is the rendering ("json" or "markdown") and Cadence how often it
+	// is pushed ("hourly", "daily" or "weekly").
+	Format  string `json:"format"`
+	Cadence string `json:"cadence"`
+	// Destination is the kind of destination ("webdav", "s3" or "git") and
+	// Target describes it without credentials, for display.
+	Destination string `json:"destination"`
+	Target      string `json:"target"`
+	// SealedConfig is the destination configuration including credentials,
+	// encrypted by the export package; storage treats it as opaque.
+	SealedConfig []byte `json:"-"`
+	CreatedAt    int64  `json:"createdAt"`
+	// LastRunAt is when the last export was attempted (unix seconds), and
+	// LastError why it failed, empty after a success.
+	LastRunAt int64  `json:"lastRunAt,omitempty"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// ErrExportScheduleNotFound is returned for unknown export schedules.
+var ErrExportScheduleNotFound = errors.New("export schedule not found")
+
+// Passkey is a registered WebAuthn credential. Data is the credential as
+// serialized by the auth package; storage treats it as opaque.
+type Passkey struct {
+	CredentialID []byte
+	Data         []byte
+}
+
+// TOTPFactor is a user's TOTP second factor. Secret is the base32 shared
+// secret. A factor is unconfirmed until the user entered a first code.
+// RecoveryCodeHashes holds the hex SHA-256 of the unused recovery codes;
+// LastStep is the last accepted time step.
+type TOTPFactor struct {
+	Secret             string
+	Confirmed          bool
+	LastStep           int64
+	RecoveryCodeHashes []string
+}
+
+var ErrTOTPFactorNotFound = errors.New("totp factor not found")
+
+// Usage summarizes the storage a user's active dataset generation consumes.
+type Usage struct {
+	SnapshotBytes   int64 `json:"snapshotBytes"`
+	OpCount         int64 `json:"opCount"`
+	OpBytes         int64 `json:"opBytes"`
+	AttachmentBytes int64 `json:"attachmentBytes"`
+	ClientCount     int64 `json:"clientCount"`
+}
+
+// UserSummary is a user's active generation with its usage, as operators see
+// it.
+type UserSummary struct {
+	UserID               string `json:"userId"`
+	DatasetGenerationKey string `json:"datasetGenerationKey"`
+	Usage
+	MaxServerSeq int64 `json:"maxServerSeq"`
+}
+
+// SnapshotPrecondition guards ReplaceSnapshotIf against concurrent changes.
+// Zero-valued fields are not checked.
+type SnapshotPrecondition struct {
+	// DatasetGenerationKey must equal the active generation key when set.
+	DatasetGenerationKey string
+	// MaxServerSeq must equal the active generation's latest serverSeq when
+	// CheckServerSeq is set, so no ops were appended since the caller looked.
+	MaxServerSeq   int64
+	CheckServerSeq bool
 }
 
 var ErrDatasetGenerationKeyExists = errors.New("datasetGenerationKey already exists")
+
+var ErrDatasetGenerationChanged = errors.New("active dataset generation changed")
+
+// ErrActorNotOwned is returned by BindActors when an actor id is already
+// registered to a different user.
+var ErrActorNotOwned = errors.New("actor is registered to another user")
+
+// ErrClientNotFound is returned when a client id has no record in the user's
+// active generation.
+var ErrClientNotFound = errors.New("client not found")
+
+// ErrClientRevoked is returned for client ids the user retired with revoke.
+var ErrClientRevoked = errors.New("client has been revoked")
+
+// Client hints an operator can leave for a single client. The client receives
+// the hint on its next pull.
+const (
+	// ClientHintResync asks the client to discard local state and bootstrap.
+	ClientHintResync = "resync"
+	// ClientHintUpgrade asks the client to reload to pick up a new version.
+	ClientHintUpgrade = "upgrade"
+)
+
+// Client is a sync client's recorded cursor within the active generation,
+// together with what it reported in its latest heartbeat.
+type Client struct {
+	ClientID          string `json:"clientId"`
+	LastSeenServerSeq int64  `json:"lastSeenServerSeq"`
+	UpdatedAt         int64  `json:"updatedAt"`
+	Hint              string `json:"hint,omitempty"`
+	ClientHeartbeat
+}
+
+// FleetClient is a client of any user together with the latest serverSeq of
+// that user's active generation, so its cursor lag can be computed.
+type FleetClient struct {
+	UserID string `json:"userId"`
+	Client
+	LatestServerSeq int64 `json:"latestServerSeq"`
+}
+
+// StreamCursor is where one of a client's stream connections stopped, saved
+// when the connection closes so a reconnect resumes exactly there.
+type StreamCursor struct {
+	ClientID string `json:"clientId"`
+	// ConnectionID is chosen by the client and stays the same across the
+	// reconnects of one logical connection.
+	ConnectionID         string `json:"connectionId"`
+	DatasetGenerationKey string `json:"datasetGenerationKey"`
+	// ServerSeq is the serverSeq of the last event the connection delivered.
+	ServerSeq int64 `json:"serverSeq"`
+	UpdatedAt int64 `json:"updatedAt"`
+}
+
+// MaxStreamCursorsPerClient is how many connection cursors are kept per
+// client; saving another drops the least recently saved one.
+const MaxStreamCursorsPerClient = 16
+
+// ErrStreamCursorNotFound is returned when a connection has no saved cursor in
+// the user's active generation.
+var ErrStreamCursorNotFound = errors.New("stream cursor not found")
+
+// ClientHeartbeat is what a client reports about itself on POST
+// /sync/heartbeat.
+type ClientHeartbeat struct {
+	AppVersion string `json:"appVersion,omitempty"`
+	// ClockSkewMs is the client's clock minus the server's, as the client
+	// measured it.
+	ClockSkewMs int64 `json:"clockSkewMs,omitempty"`
+	// ReportedServerSeq is the cursor the client says it has applied, which
+	// may trail LastSeenServerSeq when ops were delivered but not yet applied.
+	ReportedServerSeq int64 `json:"reportedServerSeq,omitempty"`
+	// HeartbeatAt is when the heartbeat was received, in Unix seconds.
+	HeartbeatAt int64 `json:"heartbeatAt,omitempty"`
+}
+
+// TagCount is a tag label with the number of visible items carrying it.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int64  `json:"count"`
+}
+
+// TaggedItem identifies an item carrying a tag in the active generation.
+type TaggedItem struct {
+	ListID string `json:"listId"`
+	ItemID string `json:"itemId"`
+}
+
+// NormalizeTag trims, lowercases, and strips a leading '#' from a tag so that
+// "#Groceries" and "groceries" address the same label.
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+}
+
+// API token scopes. A token may carry several.
+const (
+	// TokenScopeRead allows reading lists and items, including GET requests
+	// to the session API.
+	TokenScopeRead = "read"
+	// TokenScopeAdd allows adding items to existing lists.
+	TokenScopeAdd = "add"
+	// TokenScopeComplete allows checking and unchecking items.
+	TokenScopeComplete = "complete"
+	// TokenScopeQuickAdd allows POST /quick-add and nothing else.
+	TokenScopeQuickAdd = "quick-add"
+	// TokenScopeAdmin allows everything the user can do with a session.
+	TokenScopeAdmin = "admin"
+)
+
+// TokenScopes lists every known API token scope.
+var TokenScopes = []string{TokenScopeRead, TokenScopeAdd, TokenScopeComplete, TokenScopeQuickAdd, TokenScopeAdmin}
+
+// APIToken is a bearer token that lets assistants, integrations and scripts
+// act for a user within its scopes. Only a hash of the token's secret is
+// stored.
+type APIToken struct {
+	ID     string   `json:"id"`
+	UserID string   `json:"-"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ListID, when set, restricts the token to one list.
+	ListID    string `json:"listId,omitempty"`
+	CreatedAt int64  `json:"createdAt"`
+	// ExpiresAt is the unix time after which the token is rejected; zero
+	// means it never expires.
+	ExpiresAt  int64 `json:"expiresAt,omitempty"`
+	LastUsedAt int64 `json:"lastUsedAt,omitempty"`
+	// Signed tokens only accept HMAC-signed requests, never the bare secret.
+	Signed bool `json:"signed,omitempty"`
+	// MemberID, when set, makes the token a household member's: it only
+	// reaches the lists the member was given, whatever its scopes.
+	MemberID string `json:"memberId,omitempty"`
+}
+
+// HasScope reports whether the token carries scope, which the admin scope
+// implies.
+func (t APIToken) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope) || slices.Contains(t.Scopes, TokenScopeAdmin)
+}
+
+// AllowsList reports whether the token may access listID.
+func (t APIToken) AllowsList(listID string) bool {
+	return t.ListID == "" || t.ListID == listID
+}
+
+// ErrAPITokenNotFound is returned for unknown, revoked or expired API tokens.
+var ErrAPITokenNotFound = errors.New("API token not found")
+
+// HouseholdMember is a restricted account a user manages for someone in their
+// household, such as a child, who has no login of their own. Members act
+// through API tokens and only see and edit the lists in ListIDs.
+type HouseholdMember struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	ListIDs   []string `json:"listIds"`
+	CreatedAt int64    `json:"createdAt"`
+}
+
+// ErrHouseholdMemberNotFound is returned for unknown household members.
+var ErrHouseholdMemberNotFound = errors.New("household member not found")
+
+// OAuthApp is a third-party app the operator registered to request API tokens
+// through the OAuth2 authorization code flow.
+type OAuthApp struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirectUris"`
+	// Scopes are the API token scopes the app may request.
+	Scopes []string `json:"scopes"`
+	// SecretHash is the hash of a confidential app's client secret. Public
+	// apps (mobile or single-page) have none and rely on PKCE alone.
+	SecretHash string `json:"-"`
+	CreatedAt  int64  `json:"createdAt"`
+}
+
+// ErrOAuthAppNotFound is returned for unknown OAuth apps.
+var ErrOAuthAppNotFound = errors.New("oauth app not found")
+
+// Invite lets people sign up where first logins need an invite code. Only the
+// hash of the code is stored.
+type Invite struct {
+	ID   string `json:"id"`
+	Note string `json:"note"`
+	// MaxUses is how many signups the invite admits.
+	MaxUses    int   `json:"maxUses"`
+	Uses       int   `json:"uses"`
+	CreatedAt  int64 `json:"createdAt"`
+	ExpiresAt  int64 `json:"expiresAt,omitempty"`
+	LastUsedAt int64 `json:"lastUsedAt,omitempty"`
+}
+
+// ErrInviteNotFound is returned for unknown, expired or used-up invites.
+var ErrInviteNotFound = errors.New("invite not found")
+
+// Setting is a configuration value stored through the admin API, overriding
+// the environment and the config file.
+type Setting struct {
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	UpdatedAt int64  `json:"updatedAt"`
+	UpdatedBy string `json:"updatedBy"`
+}
+
+// SettingChange records one change of a stored setting. Previous is nil when
+// the setting was not stored before, Value when it was removed.
+type SettingChange struct {
+	ID        int64   `json:"id"`
+	Key       string  `json:"key"`
+	Previous  *string `json:"previous"`
+	Value     *string `json:"value"`
+	ChangedAt int64   `json:"changedAt"`
+	ChangedBy string  `json:"changedBy"`
+}
+
+// ErrSettingNotFound is returned for settings that are not stored.
+var ErrSettingNotFound = errors.New("setting not found")
+
+// QuarantinedOp is an op that was moved out of the op log because it kept
+// failing to materialize on the server.
+type QuarantinedOp struct {
+	Op
+	UserID        string `json:"userId"`
+	Reason        string `json:"reason"`
+	QuarantinedAt int64  `json:"quarantinedAt"`
+}
+
+// ErrOpNotFound is returned for ops that are not in the op log or quarantine.
+var ErrOpNotFound = errors.New("op not found")
@@ -0,0 +1,34 @@
+package storage_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+func FuzzValidateOp(f *testing.F) {
+	f.Add("list", "list-1", "actor-1", int64(1), []byte(`{"type":"insert"}`))
+	f.Add("registry", "registry", "actor-1", int64(0), []byte(`null`))
+	f.Add("list", "", "actor-1", int64(1), []byte(`{}`))
+	f.Add("list", "list-1", "actor-1", int64(1), []byte(``))
+	f.Add("list", "list-1", "actor-1", int64(1), []byte(`{"unterminated"`))
+	f.Fuzz(func(t *testing.T, scope, resource, actor string, clock int64, payload []byte) {
+		op := storage.Op{Scope: scope, Resource: resource, Actor: actor, Clock: clock, Payload: payload}
+		if err := storage.ValidateOp(op); err != nil {
+			return
+		}
+		// Accepted ops are echoed to other clients, so they must encode.
+		encoded, err := json.Marshal(op)
+		if err != nil {
+			t.Fatalf("valid op does not encode: %v", err)
+		}
+		var decoded storage.Op
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatalf("valid op does not decode: %v", err)
+		}
+		if decoded.Clock != op.Clock || decoded.Scope == "" || decoded.Actor == "" {
+			t.Fatalf("op did not round trip: %+v -> %+v", op, decoded)
+		}
+	})
+}
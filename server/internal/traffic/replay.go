@@ -0,0 +1,231 @@
+package traffic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"time"
+
+	"a4-tasklists/server/internal/auth"
+)
+
+// ReplayOptions tunes a replay.
+type ReplayOptions struct {
+	// Speed scales the recorded pacing: 1 replays in real time, 2 twice as
+	// fast. Zero sends every exchange as soon as the previous one finished.
+	Speed float64
+	// MaxExamples caps the mismatches kept in the report.
+	MaxExamples int
+}
+
+// Mismatch is an exchange whose replayed status differs from the recorded.
+type Mismatch struct {
+	Index    int    `json:"index"`
+	Kind     string `json:"kind"`
+	User     string `json:"user"`
+	Client   string `json:"client"`
+	Recorded int    `json:"recorded"`
+	Replayed int    `json:"replayed"`
+}
+
+// Report summarizes a replay.
+type Report struct {
+	Exchanges  int            `json:"exchanges"`
+	Kinds      map[string]int `json:"kinds"`
+	Mismatches int            `json:"mismatches"`
+	Examples   []Mismatch     `json:"examples"`
+	// RecordedPulledOps and ReplayedPulledOps total the ops returned by
+	// pulls. They differ when the recording started on a server that already
+	// held data, which the fresh server does not have.
+	RecordedPulledOps int           `json:"recordedPulledOps"`
+	ReplayedPulledOps int           `json:"replayedPulledOps"`
+	Duration          time.Duration `json:"duration"`
+}
+
+// replayUser maps a recorded user's generation keys to the replay server's.
+type replayUser struct {
+	generations map[string]string
+	current     string
+}
+
+// replayer holds the state of one replay.
+type replayer struct {
+	ctx     context.Context
+	handler http.Handler
+	users   map[string]*replayUser
+	// cursors are the replayed clients' pull positions, by user and client.
+	cursors map[[2]string]int64
+}
+
+// Replay sends the recorded exchanges, in order, to handler as the recorded
+// users and compares the outcomes. Generation keys and pull cursors are
+// translated to the replay server's, since it assigns its own. handler is
+// usually a fresh httpapi mux; requests reach it with the user already in
+// the context, so no authentication is involved.
+func Replay(ctx context.Context, handler http.Handler, exchanges iter.Seq2[Exchange, error], opts ReplayOptions) (Report, error) {
+	r := &replayer{ctx: ctx, handler: handler, users: make(map[string]*replayUser), cursors: make(map[[2]string]int64)}
+	report := Report{Kinds: make(map[string]int), Examples: make([]Mismatch, 0)}
+	started := time.Now()
+	for exchange, err := range exchanges {
+		if err != nil {
+			return report, err
+		}
+		if opts.Speed > 0 {
+			due := started.Add(time.Duration(float64(exchange.AtMs) * float64(time.Millisecond) / opts.Speed))
+			select {
+			case <-time.After(time.Until(due)):
+			case <-ctx.Done():
+				return report, ctx.Err()
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		status, pulled, err := r.replay(exchange)
+		if err != nil {
+			return report, fmt.Errorf("exchange %d: %w", report.Exchanges, err)
+		}
+		if status != exchange.Status {
+			report.Mismatches++
+			if len(report.Examples) < opts.MaxExamples {
+				report.Examples = append(report.Examples, Mismatch{
+					Index:    report.Exchanges,
+					Kind:     exchange.Kind,
+					User:     exchange.User,
+					Client:   exchange.Client,
+					Recorded: exchange.Status,
+					Replayed: status,
+				})
+			}
+		}
+		report.Exchanges++
+		report.Kinds[exchange.Kind]++
+		report.RecordedPulledOps += exchange.PulledOps
+		report.ReplayedPulledOps += pulled
+	}
+	report.Duration = time.Since(started)
+	return report, nil
+}
+
+// replay sends one exchange and returns the status and number of pulled ops.
+func (r *replayer) replay(exchange Exchange) (int, int, error) {
+	user, err := r.user(exchange.User)
+	if err != nil {
+		return 0, 0, err
+	}
+	key := r.generation(user, exchange)
+	cursor := [2]string{exchange.User, exchange.Client}
+	var req *http.Request
+	switch exchange.Kind {
+	case KindPush:
+		body, err := json.Marshal(map[string]any{
+			"clientId":             exchange.Client,
+			"datasetGenerationKey": key,
+			"ops":                  exchange.Ops,
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+		req = httptest.NewRequest(http.MethodPost, "/sync/push", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+	case KindPull:
+		since := int64(0)
+		if exchange.Since > 0 {
+			since = r.cursors[cursor]
+		}
+		query := url.Values{
+			"clientId":             {exchange.Client},
+			"datasetGenerationKey": {key},
+			"since":                {strconv.FormatInt(since, 10)},
+		}
+		if exchange.OmitOwn {
+			query.Set("omitOwn", "true")
+		}
+		if exchange.Mode != "" {
+			query.Set("mode", exchange.Mode)
+		}
+		req = httptest.NewRequest(http.MethodGet, "/sync/pull?"+query.Encode(), nil)
+	default:
+		return 0, 0, fmt.Errorf("unknown exchange kind %q", exchange.Kind)
+	}
+	var response struct {
+		DatasetGenerationKey string            `json:"datasetGenerationKey"`
+		ServerSeq            int64             `json:"serverSeq"`
+		Ops                  []json.RawMessage `json:"ops"`
+	}
+	status, err := r.do(exchange.User, req, &response)
+	if err != nil {
+		return 0, 0, err
+	}
+	if response.DatasetGenerationKey != "" {
+		user.current = response.DatasetGenerationKey
+		if exchange.ResponseGenerationKey != "" {
+			user.generations[exchange.ResponseGenerationKey] = response.DatasetGenerationKey
+		}
+	}
+	switch {
+	case status == http.StatusConflict:
+		// The recorded client bootstrapped next; that is not recorded, so
+		// start over from the beginning of the new generation.
+		r.cursors[cursor] = 0
+	case exchange.Kind == KindPull && status == http.StatusOK:
+		r.cursors[cursor] = response.ServerSeq
+	}
+	return status, len(response.Ops), nil
+}
+
+// user returns the replay state of a recorded user, bootstrapping the user
+// on the replay server first if needed.
+func (r *replayer) user(userID string) (*replayUser, error) {
+	if user, ok := r.users[userID]; ok {
+		return user, nil
+	}
+	var bootstrap struct {
+		DatasetGenerationKey string `json:"datasetGenerationKey"`
+	}
+	status, err := r.do(userID, httptest.NewRequest(http.MethodGet, "/sync/bootstrap", nil), &bootstrap)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("bootstrap %s: status %d", userID, status)
+	}
+	user := &replayUser{generations: make(map[string]string), current: bootstrap.DatasetGenerationKey}
+	r.users[userID] = user
+	return user, nil
+}
+
+// generation translates the generation key an exchange was sent with.
+func (r *replayer) generation(user *replayUser, exchange Exchange) string {
+	if key, ok := user.generations[exchange.DatasetGenerationKey]; ok {
+		return key
+	}
+	key := user.current
+	if exchange.Status == http.StatusConflict {
+		// The client was behind when recorded; keep it behind.
+		key = "replay-stale-" + exchange.DatasetGenerationKey
+	}
+	user.generations[exchange.DatasetGenerationKey] = key
+	return key
+}
+
+func (r *replayer) do(userID string, req *http.Request, target any) (int, error) {
+	req = req.WithContext(auth.ContextWithUserID(r.ctx, userID))
+	recorder := httptest.NewRecorder()
+	r.handler.ServeHTTP(recorder, req)
+	body, err := io.ReadAll(recorder.Result().Body)
+	if err != nil {
+		return 0, err
+	}
+	// Error responses need not carry the fields; a body that does not decode
+	// leaves target empty.
+	_ = json.Unmarshal(body, target)
+	return recorder.Code, nil
+}
@@ -0,0 +1,241 @@
+// Package traffic records anonymized sync exchanges so real-world workloads
+// can be replayed against a new server build (see cmd/replay).
+//
+// A recording is a file of JSON lines, one Exchange per push or pull. User,
+// client and actor ids are replaced by pseudonyms keyed with a secret that is
+// generated per recording and never written, so recordings cannot be linked
+// back to accounts or to each other. In op payloads the free-text fields
+// (titles, item text, notes, tags, comments) are replaced by placeholders of
+// the same length; ids, positions and op types are kept because they shape
+// the workload.
+package traffic
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+// Exchange kinds.
+const (
+	KindPush = "push"
+	KindPull = "pull"
+)
+
+// Exchange is one recorded sync request with the outcome it had.
+type Exchange struct {
+	// AtMs is when the request arrived, in milliseconds since the recording
+	// started.
+	AtMs   int64  `json:"atMs"`
+	Kind   string `json:"kind"`
+	User   string `json:"user"`
+	Client string `json:"client"`
+	// DatasetGenerationKey is the generation the client sent.
+	DatasetGenerationKey string `json:"datasetGenerationKey"`
+	// Since, OmitOwn and Mode are the pull parameters.
+	Since   int64  `json:"since,omitempty"`
+	OmitOwn bool   `json:"omitOwn,omitempty"`
+	Mode    string `json:"mode,omitempty"`
+	// Ops are the pushed ops.
+	Ops []storage.Op `json:"ops,omitempty"`
+
+	Status int `json:"status"`
+	// ResponseGenerationKey is the generation the server answered with, which
+	// differs from DatasetGenerationKey on conflicts.
+	ResponseGenerationKey string `json:"responseGenerationKey,omitempty"`
+	ServerSeq             int64  `json:"serverSeq,omitempty"`
+	// PulledOps is how many ops a pull returned.
+	PulledOps int `json:"pulledOps,omitempty"`
+}
+
+// Recorder appends exchanges to a recording. All methods accept a nil
+// Recorder and then do nothing.
+type Recorder struct {
+	key     []byte
+	started time.Time
+
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+// Create starts a recording at path. An existing file is appended to, as a
+// separate recording with its own pseudonyms.
+func Create(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open recording: %w", err)
+	}
+	return &Recorder{key: randomKey(), started: time.Now(), file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+func randomKey() []byte {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return key
+}
+
+// Record anonymizes exchange and appends it. AtMs is set from received. The
+// recording is flushed after every exchange so it survives a crash.
+func (r *Recorder) Record(received time.Time, exchange Exchange) error {
+	if r == nil {
+		return nil
+	}
+	exchange.AtMs = received.Sub(r.started).Milliseconds()
+	exchange.User = r.pseudonym("user", exchange.User)
+	exchange.Client = r.pseudonym("client", exchange.Client)
+	ops := make([]storage.Op, len(exchange.Ops))
+	for i, op := range exchange.Ops {
+		op.Actor = r.pseudonym("actor", op.Actor)
+		op.ServerSeq, op.ClientID = 0, ""
+		payload, err := r.anonymizePayload(op.Payload)
+		if err != nil {
+			return err
+		}
+		op.Payload = payload
+		ops[i] = op
+	}
+	exchange.Ops = ops
+	line, err := json.Marshal(exchange)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.writer == nil {
+		return errors.New("recording is closed")
+	}
+	if _, err := r.writer.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return r.writer.Flush()
+}
+
+// Close ends the recording.
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.writer == nil {
+		return nil
+	}
+	err := r.writer.Flush()
+	r.writer = nil
+	return errors.Join(err, r.file.Close())
+}
+
+// pseudonym maps an id to a stable stand-in for this recording.
+func (r *Recorder) pseudonym(kind, id string) string {
+	if id == "" {
+		return ""
+	}
+	return kind + "-" + r.digest(kind + "\x00" + id)[:16]
+}
+
+func (r *Recorder) digest(value string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// textFields are the payload keys whose string values are user content.
+var textFields = map[string]bool{
+	"title":   true,
+	"text":    true,
+	"note":    true,
+	"tag":     true,
+	"tags":    true,
+	"name":    true,
+	"comment": true,
+}
+
+// anonymizePayload redacts the text fields of an op payload and replaces the
+// actor ids inside it (positions carry them) with their pseudonyms.
+func (r *Recorder) anonymizePayload(payload json.RawMessage) (json.RawMessage, error) {
+	if len(payload) == 0 {
+		return payload, nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(payload)))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("decode op payload: %w", err)
+	}
+	return json.Marshal(r.anonymizeValue(value, ""))
+}
+
+func (r *Recorder) anonymizeValue(value any, key string) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for childKey, child := range v {
+			v[childKey] = r.anonymizeValue(child, childKey)
+		}
+		return v
+	case []any:
+		// Array elements belong to the array's key, as in "tags": [...].
+		for i, child := range v {
+			v[i] = r.anonymizeValue(child, key)
+		}
+		return v
+	case string:
+		switch {
+		case key == "actor":
+			return r.pseudonym("actor", v)
+		case textFields[key]:
+			return r.placeholder(v)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// placeholder returns a stand-in with the byte length of text. Equal texts
+// get equal placeholders, so tags keep matching each other.
+func (r *Recorder) placeholder(text string) string {
+	if text == "" {
+		return ""
+	}
+	digest := r.digest("text\x00" + text)
+	return strings.Repeat(digest, len(text)/len(digest)+1)[:len(text)]
+}
+
+// Read returns the exchanges of a recording in order. Iteration stops at the
+// first malformed line and yields its error.
+func Read(reader io.Reader) iter.Seq2[Exchange, error] {
+	return func(yield func(Exchange, error) bool) {
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if len(strings.TrimSpace(scanner.Text())) == 0 {
+				continue
+			}
+			var exchange Exchange
+			if err := json.Unmarshal(scanner.Bytes(), &exchange); err != nil {
+				yield(Exchange{}, fmt.Errorf("line %d: %w", line, err))
+				return
+			}
+			if !yield(exchange, nil) {
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			yield(Exchange{}, err)
+		}
+	}
+}
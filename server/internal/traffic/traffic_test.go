@@ -0,0 +1,84 @@
+package traffic
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"a4-tasklists/server/internal/storage"
+)
+
+func TestRecordAnonymizesExchanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traffic.jsonl")
+	recorder, err := Create(path)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	op := storage.Op{
+		Scope:    "list",
+		Resource: "list-1",
+		Actor:    "alice-phone",
+		Clock:    1,
+		Payload:  json.RawMessage(`{"type":"insert","itemId":"item-1","pos":[{"digit":5,"actor":"alice-phone"}],"data":{"text":"Buy milk","done":false},"tags":["home"]}`),
+	}
+	for _, exchange := range []Exchange{
+		{Kind: KindPush, User: "alice@example.com", Client: "phone", DatasetGenerationKey: "gen-1", Ops: []storage.Op{op}, Status: 200, ServerSeq: 1},
+		{Kind: KindPull, User: "alice@example.com", Client: "laptop", DatasetGenerationKey: "gen-1", Status: 200, ServerSeq: 1, PulledOps: 1},
+	} {
+		if err := recorder.Record(time.Now(), exchange); err != nil {
+			t.Fatalf("record: %v", err)
+		}
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	for _, secret := range []string{"alice", "phone", "laptop", "Buy milk", "home"} {
+		if strings.Contains(string(raw), secret) {
+			t.Fatalf("recording leaks %q:\n%s", secret, raw)
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer func() { _ = file.Close() }()
+	var exchanges []Exchange
+	for exchange, err := range Read(file) {
+		if err != nil {
+			t.Fatalf("read exchange: %v", err)
+		}
+		exchanges = append(exchanges, exchange)
+	}
+	if len(exchanges) != 2 || exchanges[0].User != exchanges[1].User || exchanges[0].Client == exchanges[1].Client {
+		t.Fatalf("pseudonyms should be stable per id: %+v", exchanges)
+	}
+	pushed := exchanges[0].Ops[0]
+	var payload struct {
+		Type   string `json:"type"`
+		ItemID string `json:"itemId"`
+		Pos    []struct {
+			Digit int    `json:"digit"`
+			Actor string `json:"actor"`
+		} `json:"pos"`
+		Data struct {
+			Text string `json:"text"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(pushed.Payload, &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if payload.Type != "insert" || payload.ItemID != "item-1" || pushed.Resource != "list-1" || payload.Pos[0].Digit != 5 {
+		t.Fatalf("workload structure should be kept: %s", pushed.Payload)
+	}
+	if payload.Pos[0].Actor != pushed.Actor || len(payload.Data.Text) != len("Buy milk") {
+		t.Fatalf("unexpected anonymized payload: actor=%s %s", pushed.Actor, pushed.Payload)
+	}
+}